@@ -1,19 +1,25 @@
 package accord
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"os/signal"
 	"path"
 	"sync"
+	"time"
 
 	"github.com/beeker1121/goque"
-	"github.com/sirupsen/logrus"
+	"github.com/syndtr/goleveldb/leveldb"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
-	SyncFilename    = "sync.queue"
-	HistoryFilename = "history.stack"
-	StateFilename   = "state.db"
+	SyncFilename       = "sync.queue"
+	HistoryFilename    = "history.stack"
+	DeadLetterFilename = "deadletter.queue"
+	StateFilename      = "state.db"
 )
 
 // Manager is where the majority of application specific logic should be stored and is generally
@@ -35,10 +41,245 @@ type Manager interface {
 // all goroutines that serve for synchronizing operations
 type Accord struct {
 
-	// The logrus logger to use for outputting logs. This should be passed in
-	// so that the user has fine control over how exactly data gets logged (log output, log level,
-	// etc...)
-	Logger *logrus.Entry
+	// The Logger to use for outputting logs. This should be passed in so that the user has fine control
+	// over how exactly data gets logged (log output, log level, etc...). The logging package adapts the
+	// common logging libraries to it
+	Logger Logger
+
+	// NodeID uniquely identifies this Accord process amongst the others it synchronizes with. It is stamped
+	// onto every Message we create as its Origin. If it's left empty we'll fall back to the machine's hostname
+	// when starting up
+	NodeID string
+
+	// Ordering is the guarantee we make about the order remote Messages get applied in. It defaults to
+	// OrderNone; see OrderingMode for the trade-offs
+	Ordering OrderingMode
+
+	// MaxHeldBack caps how many out of order Messages we'll hold on to while waiting for the ones they
+	// depend on, defaulting to DefaultMaxHeldBack
+	MaxHeldBack int
+
+	// PeerPolicy decides which peers Components should talk to and when misbehaving ones get banned (see
+	// AllowPeer and ReportViolation)
+	PeerPolicy PeerPolicy
+
+	// PeerAuth has our peers authenticate themselves with tokens before Components accept anything from
+	// them (see AuthenticatePeer), and holds the tokens we present to them in turn (see PeerToken)
+	PeerAuth PeerAuth
+
+	// Relay makes us queue the remote Messages we apply to be synchronized onwards, alongside the ones we
+	// create ourselves. Hubs need this so that a Message from one edge node makes it to all of the others;
+	// a Message is never sent back to the peer it came from (see AddPeer). Only turn it on for nodes that
+	// sit in the middle of a topology, as relaying between nodes that all relay to each other will pass
+	// Messages around in circles unless the Manager's ShouldProcess refuses ones it has already seen
+	Relay bool
+
+	// AckQuorum is how many peers have to acknowledge a Message before HandleNewMessageSync returns,
+	// defaulting to 1
+	AckQuorum int
+
+	// AckTimeout is how long HandleNewMessageSync waits for AckQuorum, defaulting to DefaultAckTimeout
+	AckTimeout time.Duration
+
+	// DedupWindow is how long we remember the IdempotencyKey of every Message we deliver for, defaulting to
+	// DefaultDedupWindow. Set it to a negative duration to not deduplicate Messages at all
+	DedupWindow time.Duration
+
+	// InFlightTimeout is how long a Message shipped to a peer waits to be acknowledged before it's shipped
+	// again (see PeerCursor.Ship), defaulting to DefaultInFlightTimeout
+	InFlightTimeout time.Duration
+
+	// Channels are named streams of Messages that are synchronized separately from our default one, keyed by
+	// name, along with the Manager that performs each stream's Messages (nil meaning our own). Every
+	// channel has a durable queue of its own, so a backlog of telemetry doesn't stand in the way of a
+	// configuration change. A Message picks its channel with Message.Channel, and both ends should
+	// configure the same ones: remote Messages on a channel we don't know are rejected
+	Channels map[string]Manager
+
+	// QueueShards splits each of our synchronization queues across this many queues on disk, so that new
+	// Messages can be written to several of them at once instead of queueing up behind each other. The
+	// shard a Message goes to is picked from its Key, so Messages with the same Key stay in order; those
+	// without one are spread out, and may be sent in a different order than they were created in unless
+	// the receiving end's Ordering puts them back in order. It defaults to a single queue. Lowering it
+	// leaves the shards that are no longer used to drain
+	QueueShards int
+
+	// ChunkSize splits Messages whose Payload is larger than this many bytes into chunks of at most this
+	// size when they're queued to be synchronized, so that multi-megabyte payloads don't end up as huge
+	// queue items and transport requests. Each chunk is a Message of ChunkType, synchronized like any
+	// other, which the receiving end holds on to until every chunk has arrived and it can handle the
+	// Message as a whole; our own history and Manager only ever see the whole Message. Every node has to
+	// be running a version that puts chunks back together before it's turned on. It defaults to 0, which
+	// never splits Messages
+	ChunkSize int
+
+	// MaxChunkedSize is how large a Message we're willing to put back together from a peer's chunks can
+	// be, in bytes (DefaultMaxChunkedSize by default). Chunks of a larger one are rejected as they arrive,
+	// whatever their peer claims, so that a broken or hostile peer can't fill our disk with them
+	MaxChunkedSize int
+
+	// ChunkTTL is how long we hold on to the chunks of a Message after the last of them arrived, waiting
+	// for the rest (DefaultChunkTTL by default). Chunks of a Message that's been waiting longer are
+	// dropped, emitting EventChunksExpired; a negative ChunkTTL holds on to them forever
+	ChunkTTL time.Duration
+
+	// FlushInterval turns on batched writes of our state and queues: rather than going to disk for every
+	// Message we handle, state updates and the Messages we queue to be synchronized are buffered and
+	// written together every FlushInterval, or as soon as FlushCount of them (DefaultFlushCount by default)
+	// have been buffered, counting the Messages waiting on each queue shard separately. Buffered Messages
+	// aren't sent to our peers until they've been written. This takes a lot of load off the disk under
+	// bursty workloads, at the cost of durability: if we crash, whatever was buffered is lost. Our history
+	// is still written straight away, so the state is caught up from it when we start again, but remote
+	// Messages we skipped or rejected in that time will be sent to us again, and our own Messages that
+	// never made it into a queue only reach our peers through anti-entropy (see components.AntiEntropy).
+	// Groups of Messages (see HandleNewMessages) are queued straight away regardless. Zero, the default,
+	// writes every update as it happens
+	FlushInterval time.Duration
+	FlushCount    int
+
+	// QueueSyncMode and StateSyncMode decide how hard we try to make sure writes to our queues (history and
+	// dead letters included) and to our state have reached the disk before carrying on, trading latency
+	// for how much a power cut can lose (see SyncMode). Under SyncInterval they're synced every SyncEvery
+	// (DefaultSyncInterval by default). Both default to SyncNever, which leaves it to the operating system.
+	// When our state writes are batched (see FlushInterval), SyncAlways applies to each flush
+	QueueSyncMode SyncMode
+	StateSyncMode SyncMode
+	SyncEvery     time.Duration
+
+	// EncryptionKey turns on encryption at rest: the Messages in our queues, history and dead letters, and
+	// whatever Managers and Components keep in our state, are encrypted with it (using AES-256-GCM) before
+	// they're written, so that a stolen or imaged device doesn't give them away. It must be
+	// EncryptionKeySize bytes long, and can be read from a file with LoadKeyFile. Our own bookkeeping
+	// (counters, digests, clocks and cursors) is left as it is, as are the keys our state is looked up by,
+	// which include Messages' Keys, idempotency keys and the values of our Indexes.
+	//
+	// Data written before a key was set can still be read, and is replaced as our queues drain. Data
+	// written with a key can't be read without it, and we refuse to start with a key our history wasn't
+	// written with. Snapshots are meant for other nodes, so they aren't encrypted
+	EncryptionKey []byte
+
+	// EncryptionKeys takes EncryptionKey's place when there's more than one key to hand us, because we're
+	// part way through rotating them (see RotateEncryptionKey). What we store is encrypted with its active
+	// key, and can be read with any of the keys it still accepts
+	EncryptionKeys *Keyring
+
+	// AutoRepair repairs our stores when we find them damaged on startup (see Repair) instead of failing
+	// with an ErrCorrupted. Repairing can lose whatever was in the damaged parts of a store, which is why
+	// it's off by default
+	AutoRepair bool
+
+	// Replica makes us a read-only replica, for reporting nodes and the like that must never originate an
+	// operation of their own. Every Message we're sent by our peers is still performed through the Manager
+	// as usual, but HandleNewMessage refuses anything new with a ReplicaError
+	Replica bool
+
+	// Filters are checked against every remote Message before it's handed to the Manager's ShouldProcess,
+	// the first one matching it deciding whether it's dropped or quarantined (see FilterRule). Use
+	// SetFilters to change them while we're running
+	Filters []FilterRule
+
+	// AuditFile, if it's set, is the file we keep an audit log in: a record of every Message we've
+	// processed, whether it was created here or sent to us, and what became of it, for compliance audits of
+	// the changes made across our cluster. The records are chained together by their hashes, so that the
+	// log can be checked for tampering (see AuditRecord and VerifyAudit). Set AuditStore instead to keep
+	// the log somewhere else
+	AuditFile  string
+	AuditStore AuditStore
+
+	// ACL limits the Message Types each of our peers may originate, rejecting remote Messages it doesn't
+	// allow before they're handed to the Manager's ShouldProcess (see ACL). Use SetACL to change it while
+	// we're running
+	ACL ACL
+
+	// MaxQueueLength and MaxQueueBytes bound each of our synchronization queues, so that a peer that's gone away
+	// can't fill our disk until we fall over. Zero means unbounded. Once either is reached, new Messages
+	// are dealt with according to QueueFullPolicy (remote Messages we relay are always queued)
+	MaxQueueLength int
+	MaxQueueBytes  int64
+
+	// QueueFullPolicy is what happens to new Messages while our queue is full, defaulting to
+	// QueueFullReject
+	QueueFullPolicy QueueFullPolicy
+
+	// ShedFunc is handed the Messages refused under QueueFullShed
+	ShedFunc ShedFunc
+
+	// OnReload is called to re-read our configuration whenever we Reload, which happens on SIGHUP
+	OnReload ReloadFunc
+
+	// RestartPolicies decide what happens to each of our Components when it fails, keyed by the names
+	// they're listed under in Components. Those without one get DefaultRestartPolicy, which unless it's
+	// changed escalates to a shutdown
+	RestartPolicies      map[string]RestartPolicy
+	DefaultRestartPolicy RestartPolicy
+
+	// PanicPolicy is what happens when our Manager panics while handling a Message, takes longer than
+	// ProcessTimeout over it or fails with an error that doesn't say what to do about it (see
+	// RetryableError, FatalError and ConflictError), defaulting to PanicShutdown. Panics are always
+	// recovered and logged with their stack trace either way
+	PanicPolicy PanicPolicy
+
+	// OnConflict settles the Messages our Manager reports a ConflictError for. They're dead lettered if it
+	// isn't set
+	OnConflict ConflictFunc
+
+	// StopTimeout is how long Stop waits, all told, for our Components to stop and for a Message that's
+	// being processed to finish, before giving up on whatever is still stuck so that we can close our
+	// stores and the process can exit. Stop waits as long as it takes by default
+	StopTimeout time.Duration
+
+	// SystemdNotify tells systemd when we've started and when we're stopping, for running us as a
+	// Type=notify service, and keeps its watchdog (WatchdogSec) fed from Listen so that it can restart us
+	// if we hang. It does nothing when we weren't started by systemd
+	SystemdNotify bool
+
+	// ProcessTimeout is how long our Manager gets to process each Message before we give up on it (see
+	// ContextManager for one that can be told to stop). There's no limit by default
+	ProcessTimeout time.Duration
+
+	// PeerTimeout is how long we go without hearing from a peer (see Heartbeat) before it's considered
+	// down, defaulting to DefaultPeerTimeout
+	PeerTimeout time.Duration
+
+	// MaxClockDrift is how far ahead of our own wall clock the HLC timestamp of a peer's Message can be
+	// (DefaultMaxClockDrift by default) before we stop letting it move our hybrid logical clock forward, so
+	// that one node with a badly wrong clock can't push every node's timestamps into the future for good.
+	// The Message is still handled as usual; only its timestamp is left out of our clock, emitting
+	// EventClockDrift. A negative MaxClockDrift lets any timestamp in
+	MaxClockDrift time.Duration
+
+	// BacklogAlerts decides when we report that our backlog is falling behind or that we seem to be offline
+	// (see OnBacklogChange). We only report on being offline by default
+	BacklogAlerts BacklogAlerts
+
+	// DiskQuota keeps our data directory from filling its disk (see SetDiskQuota to change it while we're
+	// running). There's no quota by default, but we keep track of how big the directory is either way
+	DiskQuota DiskQuota
+
+	// Metrics is where we record numbers about what we're doing (see the accord/metrics package for some
+	// implementations). Nothing is recorded unless it's set
+	Metrics Metrics
+
+	// Tracer records spans for each step of a Message's life: being enqueued, transported, checked with
+	// ShouldProcess and processed. The trace context travels along in the Message's Headers, so a single
+	// operation can be followed across nodes. It defaults to a Tracer from OpenTelemetry's global
+	// TracerProvider
+	Tracer trace.Tracer
+
+	// LogSampling thins out the Debug lines we log for every Message we handle (see SetLogSampling to change
+	// it while we're running). Every line is logged by default
+	LogSampling LogSampling
+
+	// RateLimit limits how quickly Messages are handed out to each of our peers through their cursors,
+	// unless it's overridden for a peer with SetPeerRateLimit. It's unlimited by default
+	RateLimit RateLimit
+
+	// SyncWindows are the times of day our peers can be sent Messages, unless they're overridden for a peer
+	// with SetPeerSyncWindows. Peers can be synchronized with at any time by default
+	SyncWindows []SyncWindow
+
+	// ProgressReporting decides when we report on peers catching up on a backlog (see SyncProgress)
+	ProgressReporting ProgressReporting
 
 	// Path to the directory where data should be stored. This should be passed in
 	// so that the user can choose where the data ges stored
@@ -48,18 +289,41 @@ type Accord struct {
 	// so that the user can add application specific logic
 	manager Manager
 
+	// typeManagers holds the Managers registered for particular Message Types (see Handle)
+	typeManagers map[string]Manager
+
 	// A list of Components that should be started and processed by Accord. This should be passed in so
 	// that the implementor can choose what kind of synchronization strategies to use (or write his/her own)
 	components []Component
 
+	// componentsMutex protects our Components while they're being added, removed or restarted, along
+	// with started and stopping (set once we've started starting or stopping them) and restarts (how many
+	// times each has been restarted, by name)
+	componentsMutex sync.Mutex
+	started         bool
+	stopping        bool
+	restarts        map[string]int
+
+	// startOrder is the order our Components were started in (see DependentComponent)
+	startOrder []Component
+
+	// shutdownHooks are called as we stop, guarded by hooksMutex (see OnShutdown)
+	shutdownHooks []func(reason error)
+	hooksMutex    sync.Mutex
+
 	// syncQueue is used to keep track of all of the messages that need to be synchronized
-	// remotely
+	// remotely. It's the first shard of our default channel; channels holds it along with the rest of the
+	// queues of all of our Channels, keyed by name
 	syncQueue *goque.Queue
+	channels  map[string]*channel
 
-	// historyStack is used to keep track of the messages that were performed locally by this instance that
-	// can be used for resolving merge conflicts
+	// historyStack is used to keep track of the messages that were performed by this instance (whether they
+	// originated locally or not) that can be used for resolving merge conflicts
 	historyStack *goque.Stack
 
+	// deadLetters holds the queued messages our transports gave up on delivering (see DeadLetter)
+	deadLetters *goque.Queue
+
 	// state is used to keep track of the internal state of our process so help detect divergence
 	// with other Accord processes. It's probably a bit of overkill to use a LevelDB database to keep
 	// track of our state but it's the easiest way of creating a persisted, thread safe piece of data.
@@ -75,17 +339,123 @@ type Accord struct {
 	// signalChannel is used to detect when a signal comes in from the operating system
 	signalChannel chan os.Signal
 
+	// reloadChannel receives the signals that should make us Reload
+	reloadChannel chan os.Signal
+
 	// We need to make sure that we don't process more than one message at a time or else our state might
 	// get messed up
 	processMutex *sync.Mutex
+
+	// historyMutex is held for reading while our history is copied somewhere, and for writing while it's
+	// pruned (see PruneHistory). It's always taken before processMutex
+	historyMutex sync.RWMutex
+
+	// stopContext is cancelled once we start stopping, taking the contexts ContextManagers are called with
+	// along with it
+	stopContext context.Context
+	cancelStop  context.CancelFunc
+
+	// filtersMutex guards Filters, which can be changed while we're running
+	filtersMutex sync.RWMutex
+
+	// audit writes our audit log, if we're keeping one
+	audit *auditLog
+
+	// aclMutex guards ACL, which can be changed while we're running
+	aclMutex sync.RWMutex
+
+	// peerAuthMutex guards PeerAuth, which can be changed while we're running
+	peerAuthMutex sync.RWMutex
+
+	// logSampler counts the lines our LogSampling applies to, and guards it
+	logSampler logSampler
+
+	// flushes keeps track of the peers catching up on a backlog, for ProgressReporting
+	flushes flushes
+
+	// sealer encrypts what we store with our EncryptionKeys, if we have any
+	sealer *sealer
+
+	// interceptors wrap HandleRemoteMessage, guarded by interceptorsMutex (see InterceptRemote)
+	interceptors      []RemoteInterceptor
+	interceptorsMutex sync.RWMutex
+
+	// hlc is our hybrid logical clock, used to stamp Messages with timestamps that are consistent across
+	// machines
+	hlc *HybridClock
+
+	// stages and pipelines hold the processing pipelines that should be run around the Manager for each
+	// Message Type (see RegisterStage and SetPipeline)
+	stages    map[string]Stage
+	pipelines map[string]*pipeline
+
+	// validators are the Validators run on Messages before anything else, by Type (see AddValidator)
+	validators map[string][]Validator
+
+	// indexes are the secondary indexes declared over our history (see AddIndex)
+	indexes map[string]IndexFunc
+
+	// events keeps track of our recent events and who is subscribed to them
+	events eventLog
+
+	// heldBack holds the remote Messages that arrived before the ones they depend on, keyed by ID
+	heldBack map[uint64]*Message
+
+	// dedupPrunedAt is when we last forgot the idempotency keys that fell out of our DedupWindow
+	dedupPrunedAt time.Time
+
+	// banList keeps track of misbehaving peers
+	banList banList
+
+	// peers keeps track of the peers we synchronize with and their cursors into our queue
+	peers peerSet
+
+	// health keeps track of when we last synchronized and what last went wrong (see Health)
+	health healthTracker
+
+	// liveness keeps track of when we last heard from each of our peers (see Heartbeat)
+	liveness livenessTracker
+
+	// backlog keeps track of whether we're lagged or offline (see BacklogAlerts)
+	backlog backlogWatch
+
+	// disk keeps track of how big our data directory is (see DiskQuota)
+	disk diskUsage
+
+	// schedule wakes up the goroutine that releases scheduled Messages as they come due (see
+	// HandleNewMessageAt)
+	schedule schedule
+
+	// readOnly is set (atomically) while our data directory is read-only, and lastWriteProbe is when we
+	// last checked whether it has become writable again (see ReadOnly)
+	readOnly       int32
+	lastWriteProbe time.Time
+
+	// paused is set (atomically) while we've been paused (see Pause)
+	paused int32
+
+	// draining is set (atomically) once we've started draining (see Drain)
+	draining int32
+
+	// queuedBytes is (atomically) the size of the Messages in all of our queues (see QueuedBytes)
+	queuedBytes int64
+
+	// syncStop ends our background syncs under SyncInterval, and syncDone is closed once they have
+	syncStop chan struct{}
+	syncDone chan struct{}
+
+	// repairRequested is set while Repair opens our data directory, and repairedStores lists the stores we
+	// repaired the last time we opened it
+	repairRequested bool
+	repairedStores  []string
 }
 
 // NewAccord creates a new instance of Accord for you to use. This function accepts an implementation
 // of the Manager interface, which will be called upon to do application specific logic. A list of
 // Components, so that the user what kind of synchronization strategies to use (or write his/her own).
-// The path to the directory where Accord should store its data. And a Logrus entry, so that the user
-// has fine control over how exactly logs get executed (log output, log level, hooks, etc...)
-func NewAccord(manager Manager, components []Component, dataDir string, logger *logrus.Entry) *Accord {
+// The path to the directory where Accord should store its data. And a Logger, so that the user has fine
+// control over how exactly logs get executed (log output, log level, hooks, etc...)
+func NewAccord(manager Manager, components []Component, dataDir string, logger Logger) *Accord {
 	return &Accord{
 		Logger:     logger,
 		dataDir:    dataDir,
@@ -117,82 +487,349 @@ func (accord *Accord) Start(signals ...os.Signal) (err error) {
 		accord.signalChannel = make(chan os.Signal, 1)
 		signal.Notify(accord.signalChannel, signals...)
 	}
+	accord.listenForReloads()
 
 	// Setup our internal variables and components
+	accord.stopContext, accord.cancelStop = context.WithCancel(context.Background())
 	accord.processMutex = &sync.Mutex{}
+	accord.heldBack = map[uint64]*Message{}
+
+	if accord.NodeID == "" {
+		accord.NodeID, err = os.Hostname()
+		if err != nil {
+			accord.Logger.WithError(err).Error("Unable to determine a NodeID")
+			return err
+		}
+	}
+
+	err = accord.Open()
+	if err != nil {
+		return err
+	}
 
-	accord.syncQueue, err = goque.OpenQueue(path.Join(accord.dataDir, SyncFilename))
+	// There's room for the one error that shuts us down, so that Shutdown never has to wait for Listen
+	accord.shutdown = make(chan error, 1)
+
+	accord.componentsMutex.Lock()
+	accord.stopping = false
+	accord.startOrder, err = accord.orderComponents()
+	order := accord.startOrder
+	accord.componentsMutex.Unlock()
 	if err != nil {
-		accord.Logger.WithError(err).Error("Unable to load synchronization queue")
+		accord.Logger.WithError(err).Error("Unable to work out what order to start our components in")
 		return err
 	}
 
-	accord.historyStack, err = goque.OpenStack(path.Join(accord.dataDir, HistoryFilename))
+	accord.Logger.Info("Starting components")
+	// Start our components up one by one, in an order that respects their dependencies (see
+	// DependentComponent)
+	for _, comp := range order {
+		err := accord.startComponent(comp)
+		if err != nil {
+			if panicErr, ok := err.(*PanicError); ok {
+				accord.logPanic(panicErr)
+			}
+			return err
+		}
+	}
+
+	accord.componentsMutex.Lock()
+	accord.started = true
+	accord.componentsMutex.Unlock()
+	accord.watchPeers()
+	accord.watchBacklog()
+	accord.watchChunks()
+	accord.watchDisk()
+	accord.runSchedule()
+
+	accord.Emit(EventStarted, "Accord started", map[string]interface{}{"node": accord.NodeID})
+	accord.sdNotify("READY=1")
+	return
+}
+
+// Open opens our data directory without starting any of our Components or processing anything, which is
+// all offline tools (like accordctl) need to inspect it. Start calls it for us; anybody else that calls it
+// should call Close once they're done. The node must not be running at the same time, as our stores can
+// only be opened by one process at a time
+func (accord *Accord) Open() (err error) {
+	if accord.processMutex == nil {
+		accord.processMutex = &sync.Mutex{}
+	}
+	accord.repairedStores = nil
+	accord.addBuiltinIndexes()
+
+	// Whatever we manage to open is closed again if we fail part way, so that the stores aren't left locked
+	// for Repair (or another attempt)
+	accord.channels, accord.historyStack, accord.deadLetters, accord.state = nil, nil, nil, nil
+	defer func() {
+		if err != nil {
+			accord.Close()
+		}
+	}()
+
+	accord.sealer = nil
+	keys := accord.EncryptionKeys
+	if keys == nil && accord.EncryptionKey != nil {
+		keys, err = NewKeyring(accord.EncryptionKey)
+		if err != nil {
+			return err
+		}
+	}
+	if keys != nil {
+		accord.sealer, err = newSealer(keys)
+		if err != nil {
+			accord.Logger.WithError(err).Error("Unable to use our encryption key")
+			return err
+		}
+	}
+
+	err = accord.openChannels()
+	if err != nil {
+		accord.Logger.WithError(err).Error("Unable to load synchronization queues")
+		return err
+	}
+
+	historyPath := path.Join(accord.dataDir, HistoryFilename)
+	err = recoverPrune(historyPath)
+	if err != nil {
+		accord.Logger.WithError(err).Error("Unable to recover from pruning our history")
+		return err
+	}
+	err = accord.openStore(historyPath, func() (err error) {
+		accord.historyStack, err = goque.OpenStack(historyPath)
+		return err
+	})
 	if err != nil {
 		accord.Logger.WithError(err).Error("Unable to load history stack")
 		return err
 	}
+	openStacks.Store(accord.historyStack, accord)
+	err = accord.checkEncryptionKey()
+	if err != nil {
+		accord.Logger.WithError(err).Error("Unable to read our history with our encryption key")
+		return err
+	}
 
-	accord.state, err = OpenState(path.Join(accord.dataDir, StateFilename))
+	deadLetterPath := path.Join(accord.dataDir, DeadLetterFilename)
+	err = accord.openStore(deadLetterPath, func() (err error) {
+		accord.deadLetters, err = goque.OpenQueue(deadLetterPath)
+		return err
+	})
+	if err != nil {
+		accord.Logger.WithError(err).Error("Unable to load dead letters")
+		return err
+	}
+
+	statePath := path.Join(accord.dataDir, StateFilename)
+	err = accord.openStore(statePath, func() (err error) {
+		accord.state, err = OpenState(statePath)
+		return err
+	})
 	if err != nil {
 		accord.Logger.WithError(err).Error("Unable to load state")
 		return err
 	}
+	accord.state.dedupWindow = accord.dedupWindow()
+	accord.state.sealer = accord.sealer
+	accord.state.writeOptions = stateWriteOptions(accord.StateSyncMode)
+	unclean, err := accord.state.markOpen()
+	if err != nil {
+		accord.Logger.WithError(err).Error("Unable to write to our state")
+		return err
+	}
+	if accord.FlushInterval > 0 {
+		accord.state.startBatching(accord.FlushInterval, accord.flushCount(), accord.flush)
+	}
 
-	accord.shutdown = make(chan error)
+	err = accord.recoverGroup()
+	if err != nil {
+		accord.Logger.WithError(err).Error("Unable to roll back an interrupted group of messages")
+		return err
+	}
 
-	accord.Logger.Info("Starting components")
-	// Iterate over all of our passed in components and start them up one by one
-	for _, comp := range accord.components {
-		err := comp.Start(accord)
+	err = accord.recoverState()
+	if err != nil {
+		accord.Logger.WithError(err).Error("Unable to catch our state up with our history")
+		return err
+	}
+
+	err = accord.loadBans()
+	if err != nil {
+		accord.Logger.WithError(err).Error("Unable to load peer bans")
+		return err
+	}
+
+	// Seed our hybrid logical clock with the last Message we performed so that we never hand out
+	// timestamps earlier than ones we've handed out before, even if the wall clock was moved backwards
+	// while we were stopped
+	accord.hlc = NewHybridClock()
+	accord.seedClock()
+
+	if accord.state.digestMissing {
+		accord.Logger.Info("Rebuilding state digest from history")
+		err = accord.state.rebuildDigest(accord.History())
 		if err != nil {
+			accord.Logger.WithError(err).Error("Unable to rebuild state digest")
 			return err
 		}
 	}
 
-	return
+	// After an unclean shutdown we make sure our state still matches our history before trusting it
+	if unclean || accord.repairRequested {
+		accord.Logger.Warn("We weren't stopped cleanly. Checking our state against our history")
+		err = accord.verifyState()
+		if err != nil {
+			accord.Logger.WithError(err).Error("Unable to verify our state")
+			return err
+		}
+	}
+
+	err = accord.buildIndexes()
+	if err != nil {
+		accord.Logger.WithError(err).Error("Unable to build history indexes")
+		return err
+	}
+
+	err = accord.openAudit()
+	if err != nil {
+		accord.Logger.WithError(err).Error("Unable to open our audit log")
+		return err
+	}
+
+	accord.startSyncing()
+	return nil
+}
+
+// Close closes our data directory again after Open. Stop takes care of this for a running node
+func (accord *Accord) Close() {
+	accord.stopSyncing()
+	if accord.state != nil {
+		// Messages still waiting to be queued are written out before our queues are closed
+		err := accord.state.stopBatching()
+		if err != nil {
+			accord.Logger.WithError(err).Error("We could not write out everything we had buffered")
+		}
+	}
+	accord.closeChannels()
+	if accord.historyStack != nil {
+		openStacks.Delete(accord.historyStack)
+		accord.historyStack.Close()
+	}
+	if accord.deadLetters != nil {
+		accord.deadLetters.Close()
+	}
+	if accord.state != nil {
+		accord.state.Close()
+	}
+	accord.closeAudit()
 }
 
 // Stop safely closes down the components registered with Accord and waits for them to
-// finish. This should *not* be used by components for closing Accord. Instead please use
+// finish (for up to StopTimeout altogether, if it's set). This should *not* be used by components for closing Accord. Instead please use
 // Shutdown
 func (accord *Accord) Stop() {
+	accord.stop(nil)
+}
+
+// stop does the work of Stop, handing reason (why we're stopping, if it was because of an error) to our
+// OnShutdown hooks
+func (accord *Accord) stop(reason error) {
+	accord.sdNotify("STOPPING=1")
+
+	// Managers in the middle of something can give up on it now rather than hold us up (see ContextManager)
+	if accord.cancelStop != nil {
+		accord.cancelStop()
+	}
+
+	// Once we're stopping our supervisor leaves our Components alone, so none of them can be restarted
+	// behind our back
+	accord.componentsMutex.Lock()
+	accord.stopping = true
+	accord.started = false
+	order := accord.startOrder
+	if order == nil {
+		order = accord.components
+	}
+	names := []string{}
+	for _, comp := range order {
+		name, _ := accord.nameOf(comp)
+		names = append(names, name)
+	}
+	accord.componentsMutex.Unlock()
+
+	// Components are stopped in the reverse of the order they were started in, each one finishing before
+	// the next is stopped, so nothing is ever left running without the Components it depends on. They
+	// share a single StopTimeout with the Message being processed, so that we're done within it however
+	// many of them there are
+	deadline := accord.stopDeadline()
 	accord.Logger.Info("Stopping components")
-	for _, comp := range accord.components {
-		comp.Stop(0)
+	for i := len(order) - 1; i >= 0; i-- {
+		comp := order[i]
+		accord.stopWithin(names[i], deadline, func() {
+			comp.Stop(0)
+			comp.WaitForStop()
+		})
 	}
 
-	accord.Logger.Info("Waiting for components to stop")
-	for _, comp := range accord.components {
-		comp.WaitForStop()
+	// There's no sense in reporting message processing as stuck just because our Components used up
+	// StopTimeout when nothing is being processed
+	accord.stopSchedule()
+	if !accord.processingIdle() {
+		accord.stopWithin("message processing", deadline, accord.waitForProcessing)
 	}
+	accord.runShutdownHooks(reason)
 
 	accord.Logger.Info("Closing disk connections")
-	accord.syncQueue.Close()
-	accord.historyStack.Close()
-	accord.state.Close()
+	accord.Close()
+
+	accord.Emit(EventStopped, "Accord stopped", nil)
 }
 
 // Listen simply listens on our interrupt channels and hangs until one comes in. If one does,
-// the Accord process is closed down cleanly
+// the Accord process is closed down cleanly. A SIGHUP makes us Reload instead, after which we carry on
+// listening. Under SystemdNotify, this is also where systemd's watchdog is told we're still alive
 func (accord *Accord) Listen() error {
-	select {
-	case <-accord.signalChannel:
-		accord.Logger.Info("Received OS signal")
-		accord.Stop()
-		return nil
+	var watchdog <-chan time.Time
+	if interval := accord.watchdogInterval(); interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		watchdog = ticker.C
+		accord.sdNotify("WATCHDOG=1")
+	}
 
-	case err := <-accord.shutdown:
-		accord.Logger.WithError(err).Warn("Shutting down due to error")
-		accord.Stop()
-		return err
+	for {
+		select {
+		case <-watchdog:
+			accord.sdNotify("WATCHDOG=1")
+
+		case <-accord.signalChannel:
+			accord.Logger.Info("Received OS signal")
+			accord.Stop()
+			return nil
+
+		case <-accord.reloadChannel:
+			accord.Logger.Info("Received reload signal")
+			accord.Reload()
+
+		case err := <-accord.shutdown:
+			accord.Logger.WithError(err).Warn("Shutting down due to error")
+			accord.Emit(EventShutdown, "Shutting down due to error", map[string]interface{}{"error": fmt.Sprint(err)})
+			accord.stop(err)
+			return err
+		}
 	}
 }
 
 // Shutdown provides a mechanism from which components and goroutines can trigger a shutdown
-// of Accord if they are in an unrecoverable state.
+// of Accord if they are in an unrecoverable state. It never blocks: the error waits for Listen to pick
+// it up, so it's safe to call before Listen, after we've stopped, or from many places at once. Only the
+// first error is kept; the rest are logged and dropped, as we're already on our way down
 func (accord *Accord) Shutdown(err error) {
-	accord.shutdown <- err
+	select {
+	case accord.shutdown <- err:
+	default:
+		accord.Logger.WithError(err).Warn("We're already shutting down (or aren't running), ignoring another shutdown")
+	}
 }
 
 // StartAndListen is a wrapper around the Init and Start functions, allowing for
@@ -211,26 +848,461 @@ func (accord *Accord) StartAndListen(signals ...os.Signal) error {
 	return nil
 }
 
+// Digest returns the Merkle tree summarizing every Message this process has performed, which can be
+// sent to a remote and compared against its own to cheaply detect divergence
+func (accord *Accord) Digest() Digest {
+	return accord.state.Digest()
+}
+
+// CompareDigest checks a remote's digest against our own
+func (accord *Accord) CompareDigest(remote Digest) DigestComparison {
+	return accord.state.Compare(remote)
+}
+
+// Now returns a new hybrid logical clock timestamp. These are monotonic and causally consistent with every
+// Message this process has seen, making them a better choice than the wall clock for ordering application
+// events across machines
+func (accord *Accord) Now() HLCTimestamp {
+	return accord.hlc.Now()
+}
+
+// Clock returns a copy of our vector clock, which tracks how many Messages from each node we've processed
+func (accord *Accord) Clock() VectorClock {
+	return accord.state.Clock()
+}
+
+// CurrentState returns our cumulative state value, the same one that's stamped onto Messages as StateAt
+func (accord *Accord) CurrentState() uint64 {
+	return accord.state.GetCurrent()
+}
+
 // HandleNewMessage processes a newly created message and adds it to our queue to be
 // synchronized
 func (accord *Accord) HandleNewMessage(msg *Message) error {
+	_, err := accord.handleNewMessage(context.Background(), msg)
+	return err
+}
+
+// HandleNewMessageContext is HandleNewMessage for a Message created as part of a larger operation. The
+// Message's spans are recorded as children of the span in ctx, if there is one
+func (accord *Accord) HandleNewMessageContext(ctx context.Context, msg *Message) error {
+	_, err := accord.handleNewMessage(ctx, msg)
+	return err
+}
+
+// handleNewMessage does the work of HandleNewMessage, returning the queue item the message was queued as
+func (accord *Accord) handleNewMessage(ctx context.Context, msg *Message) (queued queuedItem, err error) {
+	err = accord.refuseIfReplica(msg)
+	if err != nil {
+		return queuedItem{}, err
+	}
+
+	ch := accord.channelFor(msg.Channel)
+	if ch == nil {
+		return queuedItem{}, ErrUnknownChannel
+	}
+
+	err = accord.validateNew(msg)
+	if err != nil {
+		return queuedItem{}, err
+	}
+
+	done, err := accord.waitForRoom(ch, msg)
+	if done {
+		return queuedItem{}, err
+	}
+
+	accord.processMutex.Lock()
+	locked := true
+	defer func() {
+		if locked {
+			accord.processMutex.Unlock()
+		}
+	}()
+
+	if accord.Paused() {
+		return queuedItem{}, ErrPaused
+	}
+	if accord.Draining() {
+		return queuedItem{}, ErrDraining
+	}
+
+	err = accord.checkWritable()
+	if err != nil {
+		return queuedItem{}, err
+	}
+	if accord.diskQuotaRejecting() {
+		return queuedItem{}, ErrDiskQuota
+	}
+
+	duplicate, err := accord.isDuplicate(msg)
+	if err != nil {
+		return queuedItem{}, err
+	}
+	if duplicate {
+		accord.SampledDebug(WithMessage(accord.Logger, msg).WithField("idempotency_key", msg.IdempotencyKey), "Refusing a new message that duplicates one we already handled")
+		return queuedItem{}, ErrDuplicate
+	}
+
+	if msg.Origin == "" {
+		msg.Origin = accord.NodeID
+	}
+	correlate(ctx, msg)
+
+	// Stamp the message with where our clock will be once it's been performed. The state only actually
+	// moves its clock forward once the message has been successfully applied
+	msg.Clock = accord.state.NextClock(accord.NodeID)
+	msg.HLC = accord.hlc.Now()
+	if msg.Key != "" {
+		seq, err := accord.state.KeySeq(msg.Origin, msg.Key)
+		if err != nil {
+			WithMessage(accord.Logger, msg).WithError(err).Warn("We could not read the sequence for a message's key")
+			return queuedItem{}, err
+		}
+		msg.KeySeq = seq + 1
+	}
+
+	ctx, span := accord.startSpan(ctx, "accord.enqueue", msg)
+	defer func() { endSpan(span, err) }()
+	injectTrace(ctx, msg)
+
+	pipe := accord.pipelineFor(msg)
+	err = pipe.runBefore(msg, false)
+	if err != nil {
+		WithMessage(accord.Logger, msg).WithError(err).Info("A new message was rejected by its pipeline")
+		accord.metrics().Count(MetricMessagesRejected, 1)
+		accord.Emit(EventMessageRejected, "A new message was rejected by its pipeline", map[string]interface{}{"id": msg.ID, "error": err.Error()})
+		accord.recordAudit(msg, false, AuditRejected, err)
+		return queuedItem{}, err
+	}
+
+	accord.SampledDebug(WithMessage(accord.Logger, msg), "Processing a new message")
+	data, err := accord.apply(ctx, msg, false)
+	accord.recordAudit(msg, false, AuditApplied, err)
+	if err != nil {
+		return queuedItem{}, err
+	}
+
+	for _, err := range pipe.runAfter(msg, false) {
+		WithMessage(accord.Logger, msg).WithError(err).Warn("A pipeline stage failed after a message was applied")
+	}
+
+	// Locally created messages are the ones our remotes don't know about yet, so queue it up to be sent
+	// off to them. We hold on to its shard while letting go of processMutex, so that the next Message can
+	// be applied (and written to a different shard) while this one is being written, yet Messages sharing
+	// a shard, and so any sharing a Key, are still queued in the order they were applied
+	shard := accord.shardFor(ch, msg)
+	shard.mutex.Lock()
+	accord.processMutex.Unlock()
+	locked = false
+	item, err := accord.enqueueMessageLocked(shard, msg, data)
+	shard.mutex.Unlock()
+
+	// The state has already moved on at this point, so failing here is just as unrecoverable as failing to
+	// update it
+	if err != nil {
+		accord.processMutex.Lock()
+		defer accord.processMutex.Unlock()
+		return queuedItem{}, accord.failWrite(err, "We could not queue a message for synchronization")
+	}
+	accord.metrics().Count(MetricMessagesCreated, 1)
+
+	return queuedItem{shard: shard, id: item.ID}, nil
+}
+
+// HandleRemoteMessage processes a message that was sent to us from a remote Accord process. Unlike new
+// messages, the Manager first gets a chance to decide whether the message should be processed at all (using
+// ShouldProcess) so that synchronization conflicts can be resolved. Messages that are skipped this way
+// aren't considered errors.
+//
+// Depending on our Ordering, a message that arrives before the ones it depends on is held back and
+// applied once they've arrived, and one we've already delivered is dropped. Neither is considered an error.
+//
+// Messages go through our remote interceptors (see InterceptRemote) on their way in
+func (accord *Accord) HandleRemoteMessage(msg *Message) error {
+	return accord.interceptRemote(msg)
+}
+
+// handleRemoteMessage does the work of HandleRemoteMessage, once the Message is through our interceptors
+func (accord *Accord) handleRemoteMessage(msg *Message) (err error) {
 	accord.processMutex.Lock()
 	defer accord.processMutex.Unlock()
 
-	accord.Logger.Debug("Processing a new message")
-	err := accord.manager.Process(msg, false)
+	if accord.Paused() {
+		return ErrPaused
+	}
+
+	err = accord.checkWritable()
 	if err != nil {
-		accord.Logger.WithError(err).Warn("The manager had an error while processing a message. The safest thing to do is to blow ourselves up")
-		accord.Shutdown(err)
 		return err
 	}
 
-	err = accord.state.Update(msg)
+	// A Message that was split into chunks is handled once its last chunk arrives, and its chunks are
+	// only dropped once it has been, so that it can be put back together if it's sent again
+	if msg.Type == ChunkType {
+		whole, chunkErr := accord.receiveChunk(msg)
+		if whole == nil || chunkErr != nil {
+			return chunkErr
+		}
+		defer func() {
+			if _, rejected := err.(*StageError); err == nil || rejected {
+				accord.forgetChunks(whole.ID)
+			}
+		}()
+		msg = whole
+	}
+
+	log := WithMessage(accord.Logger, msg)
+	ctx, span := accord.startSpan(MessageContext(context.Background(), msg), "accord.receive", msg)
+	defer func() { endSpan(span, err) }()
+	accord.metrics().Count(MetricMessagesReceived, 1)
+	accord.recordSync()
+
+	// Receiving a message is an event in its own right, so our clock needs to move past it whether or
+	// not we end up processing it, unless its timestamp is too far ahead of ours to be believed
+	if _, ok := accord.hlc.UpdateWithin(msg.HLC, accord.maxClockDrift()); !ok {
+		log.WithField("hlc", msg.HLC.String()).Warn("Ignoring a remote message's timestamp, it's too far ahead of our clock")
+		accord.metrics().Count(MetricClockDrift, 1)
+		accord.Emit(EventClockDrift, "A remote message's timestamp was too far ahead of our clock", map[string]interface{}{"id": msg.ID, "origin": msg.Origin, "hlc": msg.HLC.String()})
+	}
+
+	check, err := accord.checkOrder(msg)
 	if err != nil {
-		accord.Logger.WithError(err).Warn("We could not update our internal state. Blowing up our application")
-		accord.Shutdown(err)
+		log.WithError(err).Warn("We could not determine whether a remote message was in order")
 		return err
 	}
 
+	switch check {
+	case orderDuplicate:
+		accord.SampledDebug(log, "Dropping a remote message that was already delivered")
+		return nil
+	case orderWait:
+		accord.SampledDebug(log, "Holding back a remote message until the ones before it arrive")
+		return accord.holdBack(msg)
+	}
+
+	duplicate, err := accord.isDuplicate(msg)
+	if err != nil {
+		return err
+	}
+	if duplicate {
+		accord.SampledDebug(log.WithField("idempotency_key", msg.IdempotencyKey), "Dropping a remote message that duplicates one we already handled")
+		return accord.markDelivered(msg)
+	}
+
+	err = accord.deliverRemote(ctx, msg)
+	if _, rejected := err.(*StageError); err != nil && !rejected {
+		return err
+	}
+
+	// Even a rejected message counts as delivered, so either way it may have unblocked others
+	releaseErr := accord.releaseHeldBack()
+	if releaseErr != nil {
+		return releaseErr
+	}
+	return err
+}
+
+// deliverRemote runs a remote message that's in order through the Manager and its pipeline
+func (accord *Accord) deliverRemote(ctx context.Context, msg *Message) (err error) {
+	log := WithMessage(accord.Logger, msg)
+	result := AuditApplied
+	defer func() { accord.recordAudit(msg, true, result, err) }()
+
+	ch := accord.channelFor(msg.Channel)
+	if ch == nil {
+		log.Warn("Rejecting a remote message on a channel we don't have")
+		return accord.rejectRemote(msg, &StageError{Stage: "channel", Err: ErrUnknownChannel})
+	}
+
+	err = accord.validateRemote(msg)
+	if err != nil {
+		return accord.rejectRemote(msg, err)
+	}
+
+	err = accord.authorizeRemote(msg)
+	if err != nil {
+		return accord.rejectRemote(msg, err)
+	}
+
+	filtered, err := accord.filterRemote(msg)
+	if filtered {
+		result = AuditFiltered
+	}
+	if filtered || err != nil {
+		return err
+	}
+
+	spanCtx, span := accord.startSpan(ctx, "accord.should_process", msg)
+	shouldProcess, err := accord.shouldProcess(spanCtx, msg)
+	span.SetAttributes(attribute.Bool("accord.should_process", shouldProcess))
+	endSpan(span, err)
+	if panicErr, ok := err.(*PanicError); ok {
+		return accord.rejectRemote(msg, accord.handleManagerPanic(msg, panicErr))
+	}
+
+	if !shouldProcess {
+		accord.SampledDebug(log, "The manager chose not to process a remote message")
+		accord.metrics().Count(MetricMessagesSkipped, 1)
+		result = AuditSkipped
+		return accord.markDelivered(msg)
+	}
+
+	pipe := accord.pipelineFor(msg)
+	err = pipe.runBefore(msg, true)
+	if err != nil {
+		log.WithError(err).Info("A remote message was rejected by its pipeline")
+		accord.metrics().Count(MetricMessagesRejected, 1)
+		accord.Emit(EventMessageRejected, "A remote message was rejected by its pipeline", map[string]interface{}{"id": msg.ID, "origin": msg.Origin, "error": err.Error()})
+		markErr := accord.markDelivered(msg)
+		if markErr != nil {
+			return markErr
+		}
+		return err
+	}
+
+	accord.SampledDebug(log, "Processing a remote message")
+	data, err := accord.apply(ctx, msg, true)
+	if err != nil {
+		return accord.rejectRemote(msg, err)
+	}
+
+	if accord.Relay {
+		_, err = accord.enqueueMessage(accord.shardFor(ch, msg), msg, data)
+		if err != nil {
+			return accord.failWrite(err, "We could not queue a message to be relayed")
+		}
+	}
+
+	for _, err := range pipe.runAfter(msg, true) {
+		log.WithError(err).Warn("A pipeline stage failed after a message was applied")
+	}
+
+	return nil
+}
+
+// rejectRemote marks a remote message as delivered if err rejected it, so that the ones after it aren't
+// held back waiting for it, and returns err
+func (accord *Accord) rejectRemote(msg *Message, err error) error {
+	if _, rejected := err.(*StageError); rejected {
+		markErr := accord.markDelivered(msg)
+		if markErr != nil {
+			return markErr
+		}
+	}
+	return err
+}
+
+// markDelivered records a remote message we decided not to apply as delivered, so that the ones after it
+// aren't held back waiting for it
+func (accord *Accord) markDelivered(msg *Message) error {
+	err := accord.state.MarkDelivered(msg)
+	if err != nil {
+		return accord.failWrite(err, "We could not update our internal state")
+	}
 	return nil
 }
+
+// apply does the work shared by both local and remote messages: it has the Manager process the message,
+// updates our state and records the message in our history. Any failure here leaves us in an unknown
+// state, so we blow ourselves up. The serialized message is returned so callers can make further use of
+// it without encoding it twice
+func (accord *Accord) apply(ctx context.Context, msg *Message, fromRemote bool) ([]byte, error) {
+	tx, err := accord.runManager(ctx, msg, fromRemote)
+	if err != nil {
+		return nil, err
+	}
+
+	// Our indexes are written along with our state, under the ID the Message is about to be given in our
+	// history, so that there's nothing left to write once it's been recorded there
+	itemID, err := accord.nextHistoryID()
+	if err != nil {
+		tx.rollback()
+		return nil, accord.failWrite(err, "We could not read our history")
+	}
+
+	batch := new(leveldb.Batch)
+	accord.indexMessage(batch, msg, itemID)
+	commitErr := error(nil)
+	committed := false
+	err = accord.state.update([]*Message{msg}, batch, func() error {
+		commitErr = tx.commit()
+		committed = commitErr == nil
+		return commitErr
+	})
+	if commitErr != nil {
+		return nil, accord.handleManagerError(msg, commitErr)
+	}
+	if err != nil {
+		// A committed transaction can't be rolled back, so our Manager is left ahead of us
+		if !committed {
+			tx.rollback()
+		}
+		return nil, accord.failWrite(err, "We could not update our internal state")
+	}
+
+	data, err := accord.sealer.encodeMessage(msg)
+	if err != nil {
+		WithMessage(accord.Logger, msg).WithError(err).Warn("We could not serialize a processed message. Blowing up our application")
+		accord.Shutdown(err)
+		return nil, err
+	}
+
+	item, err := accord.historyStack.Push(data)
+	if err != nil {
+		return nil, accord.failWrite(err, "We could not record a message in our history")
+	}
+	accord.syncQueueWrite(path.Join(accord.dataDir, HistoryFilename))
+	if item.ID != itemID {
+		return nil, accord.failWrite(fmt.Errorf("message %d was recorded as history item %d rather than %d", msg.ID, item.ID, itemID), "Our history is out of step with its indexes")
+	}
+
+	accord.metrics().Count(MetricMessagesApplied, 1)
+	return data, nil
+}
+
+// nextHistoryID returns the ID the next Message recorded in our history will be given. Our history is never
+// pruned down to nothing (see PruneHistory), so it carries on from the most recent one. The caller must
+// hold processMutex
+func (accord *Accord) nextHistoryID() (uint64, error) {
+	item, err := accord.historyStack.Peek()
+	if err == goque.ErrEmpty {
+		return 1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return item.ID + 1, nil
+}
+
+// runManager has our Manager process a Message, dealing with it failing according to our policies. If the
+// Manager is a TransactionalManager the transaction it's processed in is returned still open
+func (accord *Accord) runManager(ctx context.Context, msg *Message, fromRemote bool) (*managerTx, error) {
+	spanCtx, span := accord.startSpan(ctx, "accord.process", msg, attribute.Bool("accord.from_remote", fromRemote))
+	start := time.Now()
+	tx, err := accord.beginTx(msg, fromRemote)
+	if err == nil {
+		err = accord.processWithin(WithCause(spanCtx, msg), msg, fromRemote)
+		if err != nil {
+			tx.rollback()
+		}
+	}
+	accord.metrics().Timing(MetricProcessTime, time.Since(start))
+	endSpan(span, err)
+	if err != nil {
+		return nil, accord.handleManagerError(msg, err)
+	}
+	return tx, nil
+}
+
+// handleManagerError deals with our Manager failing on a Message, whether it panicked, took too long or
+// returned an error, returning the error the Message should be failed with
+func (accord *Accord) handleManagerError(msg *Message, err error) error {
+	if panicErr, ok := err.(*PanicError); ok {
+		return accord.handleManagerPanic(msg, panicErr)
+	}
+	if err == ErrProcessTimeout {
+		return accord.handleProcessTimeout(msg)
+	}
+	return accord.handleProcessError(msg, err)
+}
@@ -2,11 +2,12 @@ package accord
 
 import (
 	"errors"
+	"log/slog"
 	"os"
 	"os/signal"
 	"testing"
+	"time"
 
-	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -23,16 +24,26 @@ func (writer testingWriter) Write(p []byte) (n int, err error) {
 	return len(p), nil
 }
 
+// waitUntil polls a condition until it's true, giving up once half a second has passed. It's for waiting on
+// whatever our tests set going in the background
+func waitUntil(condition func() bool) bool {
+	deadline := time.Now().Add(500 * time.Millisecond)
+	for !condition() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return true
+}
+
 func TestAccordLogging(t *testing.T) {
 	defer AccordCleanup()
 
 	writer := createTestingWriter()
 
-	logger := logrus.New()
-	logger.Out = writer
-
 	accord := Accord{
-		Logger: logger.WithFields(nil),
+		Logger: NewSlogLogger(slog.New(slog.NewTextHandler(writer, nil)), nil),
 	}
 
 	accord.Start()
@@ -185,6 +196,21 @@ func TestAccordStartErrorShutdown(t *testing.T) {
 
 }
 
+func TestAccordShutdownWithoutListen(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+
+	// Nothing is listening yet, or even running, so none of these should block
+	accord.Shutdown(errors.New("too early"))
+	assert.Nil(t, accord.Start())
+	accord.Shutdown(errors.New("first error"))
+	accord.Shutdown(errors.New("second error"))
+
+	err := accord.Listen()
+	assert.Equal(t, "first error", err.Error())
+	accord.Shutdown(errors.New("too late"))
+}
+
 func TestAccordMultipleNewOperations(t *testing.T) {
 	defer AccordCleanup()
 	accord := DummyAccord()
@@ -0,0 +1,60 @@
+// Package accordtest helps applications unit test their Managers and Components against a real Accord,
+// without copying helpers out of Accord's own tests. It provides a Manager that records what it's handed,
+// Components that can be scripted to do whatever a test needs, a way of starting a throwaway Accord and
+// a few assertions to go with them.
+//
+// A test looks something like:
+//
+//	func TestMyManager(t *testing.T) {
+//		manager := &MyManager{}
+//		node := accordtest.New(t, manager)
+//
+//		msg, _ := accord.NewMessage([]byte("hello"))
+//		accordtest.Handle(t, node, msg)
+//		accordtest.AssertHistoryLen(t, node, 1)
+//	}
+package accordtest
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/Ssawa/accord/accord"
+)
+
+// Logger returns a logger that throws away everything logged to it, so that tests aren't drowned out by
+// our logs
+func Logger() accord.Logger {
+	return accord.NopLogger()
+}
+
+// NewUnstarted creates an Accord kept in a temporary directory of its own, which is removed once the test
+// is done, but doesn't start it. That's left to the test, after it's been configured. Its NodeID is
+// "test" and nothing it logs is shown
+func NewUnstarted(t testing.TB, manager accord.Manager, components ...accord.Component) *accord.Accord {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "accordtest")
+	if err != nil {
+		t.Fatalf("Unable to create a data directory: %s", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	node := accord.NewAccord(manager, components, dir, Logger())
+	node.NodeID = "test"
+	return node
+}
+
+// New creates an Accord like NewUnstarted and starts it, failing the test if it can't be. It's stopped
+// again once the test is done
+func New(t testing.TB, manager accord.Manager, components ...accord.Component) *accord.Accord {
+	t.Helper()
+	node := NewUnstarted(t, manager, components...)
+	err := node.Start()
+	if err != nil {
+		t.Fatalf("Unable to start Accord: %s", err)
+	}
+	// Cleanups run last in first out, so we're stopped before our data directory is removed
+	t.Cleanup(node.Stop)
+	return node
+}
@@ -0,0 +1,66 @@
+package accordtest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Ssawa/accord/accord"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManager(t *testing.T) {
+	manager := &Manager{}
+	node := New(t, manager)
+
+	msg, _ := accord.NewMessage([]byte("local"))
+	Handle(t, node, msg)
+	remote, _ := accord.NewMessage([]byte("remote"))
+	remote.Origin = "remote"
+	HandleRemote(t, node, remote)
+
+	AssertPayloads(t, manager, "local", "remote")
+	AssertHistoryLen(t, node, 2)
+	AssertQueued(t, node, 1)
+	assert.True(t, manager.Processed()[1].FromRemote)
+	assert.Len(t, manager.Checked(), 1)
+
+	manager.ProcessFunc = func(msg *accord.Message, fromRemote bool) error {
+		return &accord.RetryableError{Err: errors.New("not now")}
+	}
+	msg, _ = accord.NewMessage([]byte("failed"))
+	assert.NotNil(t, node.HandleNewMessage(msg))
+	AssertPayloads(t, manager, "local", "remote", "failed")
+	AssertHistoryLen(t, node, 2)
+
+	manager.Reset()
+	AssertPayloads(t, manager)
+}
+
+func TestComponent(t *testing.T) {
+	manager := &Manager{}
+	comp := &Component{
+		Script: []func(*accord.Accord) error{
+			func(acc *accord.Accord) error {
+				msg, _ := accord.NewMessage([]byte("from the component"))
+				return acc.HandleNewMessage(msg)
+			},
+			func(acc *accord.Accord) error {
+				return errors.New("the component failed")
+			},
+			func(acc *accord.Accord) error {
+				t.Error("the script carried on after failing")
+				return nil
+			},
+		},
+	}
+	node := NewUnstarted(t, manager, comp)
+	node.DefaultRestartPolicy = accord.RestartPolicy{Mode: accord.RestartAlways, Backoff: 10 * time.Millisecond}
+	assert.Nil(t, node.Start())
+	defer node.Stop()
+
+	assert.Nil(t, comp.WaitForScript(time.Second))
+	AssertPayloads(t, manager, "from the component")
+	Eventually(t, time.Second, func() bool { return comp.Starts() == 2 })
+	assert.Equal(t, "Component", node.Components()[0].Name)
+}
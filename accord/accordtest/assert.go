@@ -0,0 +1,77 @@
+package accordtest
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Ssawa/accord/accord"
+)
+
+// Handle hands a new Message to Accord, failing the test if it isn't handled
+func Handle(t testing.TB, acc *accord.Accord, msg *accord.Message) {
+	t.Helper()
+	err := acc.HandleNewMessage(msg)
+	if err != nil {
+		t.Fatalf("Unable to handle a new message: %s", err)
+	}
+}
+
+// HandleRemote hands a Message to Accord as though it came from a remote node, failing the test if it isn't
+// handled
+func HandleRemote(t testing.TB, acc *accord.Accord, msg *accord.Message) {
+	t.Helper()
+	err := acc.HandleRemoteMessage(msg)
+	if err != nil {
+		t.Fatalf("Unable to handle a remote message: %s", err)
+	}
+}
+
+// AssertPayloads checks that the Manager was handed Messages with exactly these payloads, in this order
+func AssertPayloads(t testing.TB, manager *Manager, payloads ...string) bool {
+	t.Helper()
+	got := manager.Payloads()
+	if len(payloads) == 0 {
+		payloads = []string{}
+	}
+	if !reflect.DeepEqual(got, payloads) {
+		t.Errorf("The manager was handed %q, not %q", got, payloads)
+		return false
+	}
+	return true
+}
+
+// AssertHistoryLen checks how many Messages are in Accord's history
+func AssertHistoryLen(t testing.TB, acc *accord.Accord, length uint64) bool {
+	t.Helper()
+	if got := acc.History().Len(); got != length {
+		t.Errorf("There are %d messages in our history, not %d", got, length)
+		return false
+	}
+	return true
+}
+
+// AssertQueued checks how many Messages are waiting to be synchronized, across every channel
+func AssertQueued(t testing.TB, acc *accord.Accord, queued uint64) bool {
+	t.Helper()
+	if got := acc.QueuedMessages(); got != queued {
+		t.Errorf("There are %d messages waiting to be synchronized, not %d", got, queued)
+		return false
+	}
+	return true
+}
+
+// Eventually checks condition every few milliseconds until it's true, failing the test if it still isn't
+// once timeout has passed. It's meant for waiting on Components, which do their work in the background
+func Eventually(t testing.TB, timeout time.Duration, condition func() bool) bool {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Errorf("The condition still wasn't met after %s", timeout)
+			return false
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return true
+}
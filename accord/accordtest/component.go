@@ -0,0 +1,133 @@
+package accordtest
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Ssawa/accord/accord"
+)
+
+// Component is a Component that can be scripted to do whatever a test needs of it. Script is run one step
+// per tick, in order, after which it sits idle until it's stopped. A step returning an error reports the
+// Component as failed (see accord.ReportFailure), just as a real one would, and ends its script
+type Component struct {
+	accord.ComponentRunner
+
+	// ComponentName is the name the Component is listed under (see accord.NamedComponent), defaulting to
+	// "Component"
+	ComponentName string
+
+	// StartErr, if set, is returned from Start in place of starting
+	StartErr error
+
+	Script []func(acc *accord.Accord) error
+
+	// TickInterval is how long to wait between steps, defaulting to 10ms
+	TickInterval time.Duration
+
+	mutex    sync.Mutex
+	accord   *accord.Accord
+	starts   int
+	stops    int
+	step     int
+	finished chan struct{}
+}
+
+// Name implements accord.NamedComponent
+func (comp *Component) Name() string {
+	if comp.ComponentName == "" {
+		return "Component"
+	}
+	return comp.ComponentName
+}
+
+// Start starts running our script, or returns StartErr
+func (comp *Component) Start(acc *accord.Accord) error {
+	if comp.StartErr != nil {
+		return comp.StartErr
+	}
+
+	comp.mutex.Lock()
+	comp.accord = acc
+	comp.starts++
+	if comp.finished == nil {
+		comp.finished = make(chan struct{})
+	}
+	comp.mutex.Unlock()
+
+	comp.ComponentRunner.Init(acc, comp.tick, comp.cleanup, acc.Logger.WithField("component", comp.Name()))
+	return nil
+}
+
+// Starts returns how many times we've been started, counting restarts
+func (comp *Component) Starts() int {
+	comp.mutex.Lock()
+	defer comp.mutex.Unlock()
+	return comp.starts
+}
+
+// Stops returns how many times we've stopped, counting failures
+func (comp *Component) Stops() int {
+	comp.mutex.Lock()
+	defer comp.mutex.Unlock()
+	return comp.stops
+}
+
+// ErrScriptTimeout is returned by WaitForScript when our script doesn't finish in time
+var ErrScriptTimeout = errors.New("the component's script didn't finish in time")
+
+// WaitForScript waits for every step of our script to have run, for up to timeout
+func (comp *Component) WaitForScript(timeout time.Duration) error {
+	comp.mutex.Lock()
+	finished := comp.finished
+	comp.mutex.Unlock()
+	if finished == nil {
+		return ErrScriptTimeout
+	}
+
+	select {
+	case <-finished:
+		return nil
+	case <-time.After(timeout):
+		return ErrScriptTimeout
+	}
+}
+
+func (comp *Component) tick(acc *accord.Accord) {
+	interval := comp.TickInterval
+	if interval == 0 {
+		interval = 10 * time.Millisecond
+	}
+	time.Sleep(interval)
+
+	comp.mutex.Lock()
+	if comp.step > len(comp.Script) {
+		comp.mutex.Unlock()
+		return
+	}
+	if comp.step == len(comp.Script) {
+		comp.step++
+		close(comp.finished)
+		comp.mutex.Unlock()
+		return
+	}
+	step := comp.Script[comp.step]
+	comp.step++
+	comp.mutex.Unlock()
+
+	err := step(acc)
+	if err != nil {
+		// Our script ends here
+		comp.mutex.Lock()
+		comp.step = len(comp.Script)
+		comp.mutex.Unlock()
+		acc.ReportFailure(comp, err)
+	}
+}
+
+func (comp *Component) cleanup(*accord.Accord) {
+	comp.mutex.Lock()
+	defer comp.mutex.Unlock()
+	comp.stops++
+}
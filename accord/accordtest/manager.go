@@ -0,0 +1,85 @@
+package accordtest
+
+import (
+	"sync"
+
+	"github.com/Ssawa/accord/accord"
+	"github.com/beeker1121/goque"
+)
+
+// Processed is a Message a Manager was handed, and whether it came from a remote node
+type Processed struct {
+	Message    accord.Message
+	FromRemote bool
+}
+
+// Manager is an accord.Manager that records every Message it's handed. By default it processes every
+// Message without error and accepts every remote Message; set ProcessFunc and ShouldProcessFunc to make it
+// do something else, like fail on a particular Message. It's safe to use from multiple goroutines
+type Manager struct {
+	// ProcessFunc, if set, decides what Process returns
+	ProcessFunc func(msg *accord.Message, fromRemote bool) error
+
+	// ShouldProcessFunc, if set, decides what ShouldProcess returns
+	ShouldProcessFunc func(msg accord.Message, history *goque.Stack) bool
+
+	mutex     sync.Mutex
+	processed []Processed
+	checked   []accord.Message
+}
+
+// Process records the Message, returning whatever ProcessFunc does. A Message that fails is still recorded
+func (manager *Manager) Process(msg *accord.Message, fromRemote bool) error {
+	manager.mutex.Lock()
+	manager.processed = append(manager.processed, Processed{Message: *msg, FromRemote: fromRemote})
+	manager.mutex.Unlock()
+
+	if manager.ProcessFunc != nil {
+		return manager.ProcessFunc(msg, fromRemote)
+	}
+	return nil
+}
+
+// ShouldProcess records the Message, returning whatever ShouldProcessFunc does
+func (manager *Manager) ShouldProcess(msg accord.Message, history *goque.Stack) bool {
+	manager.mutex.Lock()
+	manager.checked = append(manager.checked, msg)
+	manager.mutex.Unlock()
+
+	if manager.ShouldProcessFunc != nil {
+		return manager.ShouldProcessFunc(msg, history)
+	}
+	return true
+}
+
+// Processed returns every Message handed to Process, in the order they were handed over
+func (manager *Manager) Processed() []Processed {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	return append([]Processed{}, manager.processed...)
+}
+
+// Payloads returns the payloads of every Message handed to Process as strings, which is generally the
+// easiest thing to assert on
+func (manager *Manager) Payloads() []string {
+	payloads := []string{}
+	for _, processed := range manager.Processed() {
+		payloads = append(payloads, string(processed.Message.Payload))
+	}
+	return payloads
+}
+
+// Checked returns every Message handed to ShouldProcess
+func (manager *Manager) Checked() []accord.Message {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	return append([]accord.Message{}, manager.checked...)
+}
+
+// Reset forgets every Message we've recorded
+func (manager *Manager) Reset() {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	manager.processed = nil
+	manager.checked = nil
+}
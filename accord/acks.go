@@ -0,0 +1,120 @@
+package accord
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// DefaultAckTimeout is how long HandleNewMessageSync waits for acknowledgements by default
+const DefaultAckTimeout = 5 * time.Second
+
+// ErrAckTimeout is returned by HandleNewMessageSync when not enough peers acknowledged a Message in time.
+// The Message has still been performed locally and stays queued, so it will reach them eventually unless
+// our disk dies first
+var ErrAckTimeout = errors.New("timed out waiting for peers to acknowledge the message")
+
+// ErrNotEnoughPeers is returned by HandleNewMessageSync when we have fewer peers than acknowledgements are
+// required, so waiting could never succeed. The Message has still been performed locally
+var ErrNotEnoughPeers = errors.New("fewer peers are registered than acknowledgements are required")
+
+// ackWaiter is somebody waiting for a queued Message to be acknowledged
+type ackWaiter struct {
+	shard  *shard
+	item   uint64
+	needed int
+	done   chan struct{}
+}
+
+// HandleNewMessageSync processes a newly created message like HandleNewMessage, but only returns once
+// AckQuorum of our peers have acknowledged it, so that it can't be lost if our disk dies right after.
+// Use it for the operations that matter enough to be worth the wait.
+//
+// A peer acknowledges a Message by moving its cursor past it (see PeerCursor.Advance), which our
+// transports only do once the peer has confirmed it received the Message. Gossip hands Messages off to
+// its rumor mill rather than a particular peer, so it doesn't count towards a quorum in any meaningful way.
+//
+// If the quorum isn't reached within AckTimeout we return ErrAckTimeout. Errors from processing the
+// Message itself are returned just like HandleNewMessage's, in which case nothing is waited for
+func (accord *Accord) HandleNewMessageSync(msg *Message) error {
+	queued, err := accord.handleNewMessage(context.Background(), msg)
+	if err != nil {
+		return err
+	}
+
+	quorum := accord.AckQuorum
+	if quorum <= 0 {
+		quorum = 1
+	}
+	timeout := accord.AckTimeout
+	if timeout <= 0 {
+		timeout = DefaultAckTimeout
+	}
+
+	accord.peers.mutex.Lock()
+	if len(accord.peerNames()) < quorum {
+		accord.peers.mutex.Unlock()
+		return ErrNotEnoughPeers
+	}
+	waiter := &ackWaiter{shard: queued.shard, item: queued.id, needed: quorum, done: make(chan struct{})}
+	if accord.acknowledged(waiter) {
+		accord.peers.mutex.Unlock()
+		return nil
+	}
+	accord.peers.waiters = append(accord.peers.waiters, waiter)
+	accord.peers.mutex.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-waiter.done:
+		return nil
+	case <-timer.C:
+	}
+
+	accord.peers.mutex.Lock()
+	defer accord.peers.mutex.Unlock()
+	for i, other := range accord.peers.waiters {
+		if other == waiter {
+			accord.peers.waiters = append(accord.peers.waiters[:i], accord.peers.waiters[i+1:]...)
+			break
+		}
+	}
+
+	// We may have gotten there at the very last moment
+	select {
+	case <-waiter.done:
+		return nil
+	default:
+	}
+
+	WithMessage(accord.Logger, msg).WithField("quorum", quorum).Warn("Peers did not acknowledge a message in time")
+	return ErrAckTimeout
+}
+
+// acknowledged reports whether enough peers have moved past a waiter's Message. The caller must hold the
+// peers mutex
+func (accord *Accord) acknowledged(waiter *ackWaiter) bool {
+	acks := 0
+	for _, peer := range accord.peerNames() {
+		if accord.peers.cursors[cursorKey(peer, waiter.shard.channel.name, waiter.shard.index)] > waiter.item {
+			acks++
+		}
+	}
+	return acks >= waiter.needed
+}
+
+// notifyAcks wakes up everybody whose Message has now been acknowledged by enough peers. The caller must
+// hold the peers mutex
+func (accord *Accord) notifyAcks() {
+	remaining := accord.peers.waiters[:0]
+	for _, waiter := range accord.peers.waiters {
+		if accord.acknowledged(waiter) {
+			close(waiter.done)
+		} else {
+			remaining = append(remaining, waiter)
+		}
+	}
+	accord.peers.waiters = remaining
+}
@@ -0,0 +1,50 @@
+package accord
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleNewMessageSync(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	accord.AckQuorum = 2
+	accord.AckTimeout = 20 * time.Millisecond
+
+	msg, _ := NewMessage([]byte("lonely"))
+	assert.Equal(t, ErrNotEnoughPeers, accord.HandleNewMessageSync(msg))
+
+	peerA := accord.AddPeer("a")
+	peerB := accord.AddPeer("b")
+	assert.Nil(t, peerA.Advance(1))
+	assert.Nil(t, peerB.Advance(1))
+
+	// Only one of our peers has acknowledged the message by the time we give up
+	go func() {
+		waitUntil(func() bool { return peerA.Pending() == 1 })
+		peerA.Advance(1)
+	}()
+	msg, _ = NewMessage([]byte("ignored"))
+	assert.Equal(t, ErrAckTimeout, accord.HandleNewMessageSync(msg))
+	assert.True(t, waitUntil(func() bool { return peerA.Pending() == 0 }))
+	assert.Nil(t, peerB.Advance(1))
+
+	accord.AckTimeout = time.Second
+	result := make(chan error)
+	go func() {
+		msg, _ := NewMessage([]byte("acknowledged"))
+		result <- accord.HandleNewMessageSync(msg)
+	}()
+
+	for _, peer := range []*PeerCursor{peerA, peerB} {
+		current := peer
+		assert.True(t, waitUntil(func() bool { return current.Pending() == 1 }))
+		assert.Nil(t, current.Advance(1))
+	}
+	assert.Nil(t, <-result)
+}
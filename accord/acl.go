@@ -0,0 +1,66 @@
+package accord
+
+import "errors"
+
+// EventMessageUnauthorized is emitted when a remote Message is rejected because its origin isn't allowed
+// to originate Messages of its Type (see ACL)
+const EventMessageUnauthorized = "message_unauthorized"
+
+// ErrUnauthorized is what remote Messages our ACL doesn't allow are rejected with
+var ErrUnauthorized = errors.New("the message's origin isn't allowed to originate messages of its type")
+
+// ACL limits which Message Types each of our peers may originate, so that a hub isn't at the mercy of an
+// edge node that's been compromised or misconfigured: an edge node that only ever reports readings has no
+// business creating users, say. It's checked against the Origin of every remote Message (relayed ones
+// included) before it's handed to the Manager's ShouldProcess; Messages it doesn't allow are rejected,
+// and never retried by the peer that sent them. Patterns are shell patterns (see path.Match), so
+// "reading.*" allows every type starting with "reading.", and "*" allows everything
+type ACL struct {
+	// Origins lists, by NodeID, the Types each origin may originate. Every remote Message is let through
+	// while both it and Default are empty
+	Origins map[string][]string
+
+	// Default lists the Types every origin that isn't listed in Origins may originate. Leaving it empty
+	// while Origins isn't refuses every Message from an origin that isn't listed
+	Default []string
+}
+
+// enabled reports whether the ACL restricts anything
+func (acl ACL) enabled() bool {
+	return len(acl.Origins) > 0 || len(acl.Default) > 0
+}
+
+// Allows reports whether the ACL allows an origin to originate Messages of the given Type
+func (acl ACL) Allows(origin string, msgType string) bool {
+	if !acl.enabled() {
+		return true
+	}
+	types, ok := acl.Origins[origin]
+	if !ok {
+		types = acl.Default
+	}
+	return matchAny(types, msgType)
+}
+
+// SetACL replaces our ACL while we're running. Remote Messages we receive from then on are checked
+// against it
+func (accord *Accord) SetACL(acl ACL) {
+	accord.aclMutex.Lock()
+	defer accord.aclMutex.Unlock()
+	accord.ACL = acl
+}
+
+// authorizeRemote checks a remote Message against our ACL, returning a StageError if it isn't allowed
+func (accord *Accord) authorizeRemote(msg *Message) error {
+	accord.aclMutex.RLock()
+	allowed := accord.ACL.Allows(msg.Origin, msg.Type)
+	accord.aclMutex.RUnlock()
+	if allowed {
+		return nil
+	}
+
+	WithMessage(accord.Logger, msg).Warn("Rejecting a remote message its origin isn't allowed to originate")
+	accord.metrics().Count(MetricMessagesRejected, 1)
+	accord.Emit(EventMessageUnauthorized, "A remote message was rejected by our ACL", map[string]interface{}{"id": msg.ID, "origin": msg.Origin, "type": msg.Type})
+	return &StageError{Stage: "acl", Err: ErrUnauthorized}
+}
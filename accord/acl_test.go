@@ -0,0 +1,53 @@
+package accord
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestACLAllows(t *testing.T) {
+	assert.True(t, ACL{}.Allows("edge-1", "user.create"))
+
+	acl := ACL{Origins: map[string][]string{"edge-1": {"reading.*"}, "hub": {"*"}}}
+	assert.True(t, acl.Allows("edge-1", "reading.temperature"))
+	assert.False(t, acl.Allows("edge-1", "user.create"))
+	assert.True(t, acl.Allows("hub", "user.create"))
+	assert.False(t, acl.Allows("edge-2", "reading.temperature"))
+
+	acl.Default = []string{"reading.*"}
+	assert.True(t, acl.Allows("edge-2", "reading.temperature"))
+	assert.False(t, acl.Allows("edge-2", "user.create"))
+}
+
+func TestACL(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	accord.ACL = ACL{Origins: map[string][]string{"edge": {"reading.*"}}}
+	unauthorized := []Event{}
+	accord.Subscribe(func(event Event) {
+		if event.Kind == EventMessageUnauthorized {
+			unauthorized = append(unauthorized, event)
+		}
+	})
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	assert.Nil(t, accord.HandleRemoteMessage(&Message{ID: 1, Type: "reading.temperature", Origin: "edge"}))
+	err := accord.HandleRemoteMessage(&Message{ID: 2, Type: "user.create", Origin: "edge"})
+	if assert.IsType(t, &StageError{}, err) {
+		assert.Equal(t, ErrUnauthorized, err.(*StageError).Err)
+	}
+	assert.Equal(t, uint64(1), accord.History().Len())
+	if assert.Len(t, unauthorized, 1) {
+		assert.Equal(t, "edge", unauthorized[0].Fields["origin"])
+		assert.Equal(t, "user.create", unauthorized[0].Fields["type"])
+	}
+
+	// The ACL can be changed while we're running, and never applies to our own Messages
+	accord.SetACL(ACL{Default: []string{"*"}})
+	assert.Nil(t, accord.HandleRemoteMessage(&Message{ID: 3, Type: "user.create", Origin: "edge"}))
+	accord.SetACL(ACL{Default: []string{"reading.*"}})
+	assert.Nil(t, accord.HandleNewMessage(&Message{ID: 4, Type: "user.create"}))
+	assert.Equal(t, uint64(3), accord.History().Len())
+}
@@ -0,0 +1,255 @@
+package accord
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// EventAuditFailed is emitted when a Message's audit record can't be written
+const EventAuditFailed = "audit_failed"
+
+// ErrAuditTampered is returned by VerifyAudit when an audit record isn't what was originally written
+var ErrAuditTampered = errors.New("the audit log has been tampered with")
+
+// The results a Message can be recorded with in our audit log
+const (
+	AuditApplied  = "applied"
+	AuditSkipped  = "skipped"
+	AuditFiltered = "filtered"
+	AuditRejected = "rejected"
+	AuditFailed   = "failed"
+)
+
+// AuditRecord records what became of a Message we processed. Each record holds the hash of the one before
+// it, and its own hash covers that, so that none of them can be changed, removed or slipped in without
+// breaking the chain from that point on (see VerifyAudit)
+type AuditRecord struct {
+	// Seq numbers the records in the log, from 1
+	Seq  uint64    `json:"seq"`
+	Time time.Time `json:"time"`
+
+	// Node is the node that processed the Message, and Origin the one that created it. Remote is false
+	// for Messages Node created itself
+	Node   string `json:"node"`
+	Origin string `json:"origin"`
+	Remote bool   `json:"remote"`
+
+	MessageID uint64 `json:"message_id"`
+	Type      string `json:"type,omitempty"`
+	Channel   string `json:"channel,omitempty"`
+	Key       string `json:"key,omitempty"`
+
+	// CorrelationID is the chain of operations the Message belongs to (see Message.CorrelationID)
+	CorrelationID string `json:"correlation_id,omitempty"`
+
+	// Result is one of AuditApplied, AuditSkipped (by the Manager's ShouldProcess), AuditFiltered (by one
+	// of our Filters), AuditRejected (by a pipeline stage or our ACL) or AuditFailed, with the error behind
+	// it in Error
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+
+	Prev string `json:"prev"`
+	Hash string `json:"hash"`
+}
+
+// hash computes what a record's Hash should be
+func (record AuditRecord) hash() string {
+	record.Hash = ""
+	data, _ := json.Marshal(record)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// AuditStore is where our audit records are kept. Stores should only ever append to what they hold
+type AuditStore interface {
+	// Last returns the last record that was appended, or nil if there isn't one, so that the chain can be
+	// carried on from it when we're started again
+	Last() (*AuditRecord, error)
+
+	Append(record AuditRecord) error
+	Close() error
+}
+
+// AuditFile is an AuditStore writing one JSON record per line to a file, which is only ever appended to and
+// synced to disk after every record
+type AuditFile struct {
+	file *os.File
+	last *AuditRecord
+}
+
+// OpenAuditFile opens an audit log file, creating it if it doesn't exist
+func OpenAuditFile(path string) (*AuditFile, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	audit := &AuditFile{file: file}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		record := AuditRecord{}
+		err = json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil {
+			file.Close()
+			return nil, ErrAuditTampered
+		}
+		audit.last = &record
+	}
+	if scanner.Err() != nil {
+		file.Close()
+		return nil, scanner.Err()
+	}
+	return audit, nil
+}
+
+// Last returns the last record in the file
+func (audit *AuditFile) Last() (*AuditRecord, error) {
+	return audit.last, nil
+}
+
+// Append writes a record to the end of the file
+func (audit *AuditFile) Append(record AuditRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = audit.file.Write(append(data, '\n'))
+	if err != nil {
+		return err
+	}
+	audit.last = &record
+	return audit.file.Sync()
+}
+
+// Close closes the file
+func (audit *AuditFile) Close() error {
+	return audit.file.Close()
+}
+
+// auditLog chains records together as they're written to our AuditStore
+type auditLog struct {
+	mutex sync.Mutex
+	store AuditStore
+	last  AuditRecord
+}
+
+// openAudit sets up our audit log, if we have one, carrying the chain on from wherever it left off
+func (accord *Accord) openAudit() error {
+	store := accord.AuditStore
+	if store == nil && accord.AuditFile != "" {
+		file, err := OpenAuditFile(accord.AuditFile)
+		if err != nil {
+			return err
+		}
+		store = file
+	}
+	if store == nil {
+		return nil
+	}
+
+	last, err := store.Last()
+	if err != nil {
+		return err
+	}
+	accord.audit = &auditLog{store: store}
+	if last != nil {
+		accord.audit.last = *last
+	}
+	return nil
+}
+
+// closeAudit closes our audit log, if we have one
+func (accord *Accord) closeAudit() {
+	if accord.audit == nil {
+		return
+	}
+	err := accord.audit.store.Close()
+	if err != nil {
+		accord.Logger.WithError(err).Warn("Unable to close our audit log")
+	}
+	accord.audit = nil
+}
+
+// recordAudit writes down what became of a Message in our audit log, if we have one. err is what it was
+// refused with, which turns AuditApplied into AuditRejected or AuditFailed
+func (accord *Accord) recordAudit(msg *Message, remote bool, result string, err error) {
+	audit := accord.audit
+	if audit == nil {
+		return
+	}
+	if err != nil && result == AuditApplied {
+		result = AuditFailed
+		if _, rejected := err.(*StageError); rejected {
+			result = AuditRejected
+		}
+	}
+
+	audit.mutex.Lock()
+	defer audit.mutex.Unlock()
+
+	record := AuditRecord{
+		Seq:           audit.last.Seq + 1,
+		Time:          time.Now().UTC(),
+		Node:          accord.NodeID,
+		Origin:        msg.Origin,
+		Remote:        remote,
+		MessageID:     msg.ID,
+		Type:          msg.Type,
+		Channel:       msg.Channel,
+		Key:           msg.Key,
+		CorrelationID: msg.CorrelationID,
+		Result:        result,
+		Prev:          audit.last.Hash,
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	record.Hash = record.hash()
+
+	writeErr := audit.store.Append(record)
+	if writeErr != nil {
+		WithMessage(accord.Logger, msg).WithError(writeErr).Error("Unable to write a message's audit record")
+		accord.Emit(EventAuditFailed, "A message's audit record could not be written", map[string]interface{}{"id": msg.ID, "error": writeErr.Error()})
+		return
+	}
+	audit.last = record
+}
+
+// VerifyAudit reads an audit log written by an AuditFile, checking that every record is the one that was
+// originally written. It returns how many records were intact before the first that wasn't, along with
+// ErrAuditTampered if there was one. Records removed from the end of the log can't be noticed this way, so
+// the last record's Hash should be kept somewhere else as well
+func VerifyAudit(r io.Reader) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	count := 0
+	last := AuditRecord{}
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		record := AuditRecord{}
+		err := json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil {
+			return count, ErrAuditTampered
+		}
+		if record.Seq != last.Seq+1 || record.Prev != last.Hash || record.Hash != record.hash() {
+			return count, ErrAuditTampered
+		}
+		last = record
+		count++
+	}
+	return count, scanner.Err()
+}
@@ -0,0 +1,69 @@
+package accord
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditLog(t *testing.T) {
+	defer AccordCleanup()
+	dir, err := ioutil.TempDir("", "accord-audit")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	accord := DummyAccord()
+	accord.NodeID = "hub"
+	accord.AuditFile = filepath.Join(dir, "audit.log")
+	accord.Filters = []FilterRule{{Name: "no deletes", Types: []string{"user.delete"}}}
+	assert.Nil(t, accord.Start())
+
+	assert.Nil(t, accord.HandleNewMessage(&Message{ID: 1, Type: "user.create"}))
+	assert.Nil(t, accord.HandleRemoteMessage(&Message{ID: 2, Type: "user.update", Origin: "edge"}))
+	assert.Nil(t, accord.HandleRemoteMessage(&Message{ID: 3, Type: "user.delete", Origin: "edge"}))
+	accord.Stop()
+
+	// The chain carries on where it left off when we're started again
+	accord.ACL = ACL{Default: []string{"reading.*"}}
+	assert.Nil(t, accord.Start())
+	assert.NotNil(t, accord.HandleRemoteMessage(&Message{ID: 4, Type: "user.create", Origin: "edge"}))
+	accord.Stop()
+
+	data, err := ioutil.ReadFile(accord.AuditFile)
+	assert.Nil(t, err)
+	count, err := VerifyAudit(bytes.NewReader(data))
+	assert.Nil(t, err)
+	assert.Equal(t, 4, count)
+
+	file, err := OpenAuditFile(accord.AuditFile)
+	assert.Nil(t, err)
+	last, err := file.Last()
+	assert.Nil(t, err)
+	file.Close()
+	assert.Equal(t, uint64(4), last.Seq)
+	assert.Equal(t, "hub", last.Node)
+	assert.Equal(t, "edge", last.Origin)
+	assert.True(t, last.Remote)
+	assert.Equal(t, AuditRejected, last.Result)
+	assert.Contains(t, last.Error, ErrUnauthorized.Error())
+
+	lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+	assert.Contains(t, string(lines[0]), `"result":"applied"`)
+	assert.Contains(t, string(lines[0]), `"remote":false`)
+	assert.Contains(t, string(lines[1]), `"result":"applied"`)
+	assert.Contains(t, string(lines[2]), `"result":"filtered"`)
+
+	// Changing, removing or reordering records breaks the chain from that point on
+	tampered := bytes.Replace(data, []byte(`"origin":"edge"`), []byte(`"origin":"elsewhere"`), 1)
+	count, err = VerifyAudit(bytes.NewReader(tampered))
+	assert.Equal(t, ErrAuditTampered, err)
+	assert.Equal(t, 1, count)
+	removed := bytes.Join(append([][]byte{lines[0]}, lines[2:]...), []byte("\n"))
+	count, err = VerifyAudit(bytes.NewReader(removed))
+	assert.Equal(t, ErrAuditTampered, err)
+	assert.Equal(t, 1, count)
+}
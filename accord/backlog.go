@@ -0,0 +1,55 @@
+package accord
+
+import (
+	"time"
+
+	"github.com/beeker1121/goque"
+)
+
+// QueueDepth returns how many Messages are waiting to be synchronized, across all of our channels. It's
+// the same as QueuedMessages, and is here alongside OldestPendingAge and PeerLags for applications
+// building their own alerting on our backlog
+func (accord *Accord) QueueDepth() uint64 {
+	return accord.QueuedMessages()
+}
+
+// OldestPendingAge returns how long ago the oldest Message still waiting to be synchronized was created,
+// or zero when nothing is waiting. A backlog that's growing older means at least one of our peers isn't
+// keeping up, even if it isn't growing any longer. Ages come from the Messages' Timestamps, so they're
+// only as accurate as the clocks of the nodes that created them
+func (accord *Accord) OldestPendingAge() (time.Duration, error) {
+	oldest := time.Time{}
+	for _, shard := range accord.allShards() {
+		item, err := shard.queue.Peek()
+		if err == goque.ErrEmpty {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		msg, err := accord.sealer.decodeMessage(item.Value)
+		if err != nil {
+			return 0, err
+		}
+		if oldest.IsZero() || msg.Timestamp.Before(oldest) {
+			oldest = msg.Timestamp
+		}
+	}
+
+	if oldest.IsZero() {
+		return 0, nil
+	}
+	return time.Since(oldest), nil
+}
+
+// PeerLags returns the PeerLag of every one of our peers, by name
+func (accord *Accord) PeerLags() map[string]uint64 {
+	lags := map[string]uint64{}
+	for _, name := range accord.Peers() {
+		// A peer removed since we listed them has no lag to speak of
+		if lag, err := accord.PeerLag(name); err == nil {
+			lags[name] = lag
+		}
+	}
+	return lags
+}
@@ -0,0 +1,194 @@
+package accord
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event kinds emitted as our backlog crosses our BacklogAlerts' thresholds, and as we go offline and come
+// back online
+const (
+	EventBacklogLagged  = "backlog_lagged"
+	EventBacklogCleared = "backlog_cleared"
+	EventOffline        = "offline"
+	EventOnline         = "online"
+)
+
+// DefaultBacklogCheckInterval is how often we check our backlog against our BacklogAlerts, when their
+// CheckInterval isn't set
+const DefaultBacklogCheckInterval = 10 * time.Second
+
+// BacklogAlerts decides when our backlog is bad enough to tell somebody about, whether that's paging an
+// operator or showing a "sync delayed" banner to a user. We're lagged once more than MaxQueueDepth
+// Messages are waiting to be synchronized, or once the oldest of them is older than MaxPendingAge, and
+// offline while we have peers and haven't heard from any of them within our PeerTimeout. An
+// EventBacklogLagged and an EventOffline are emitted as that happens, an EventBacklogCleared and an
+// EventOnline once it's over, and OnBacklogChange hooks are called either way
+type BacklogAlerts struct {
+	// MaxQueueDepth is how many Messages can be waiting before we're lagged. There's no limit if it's 0
+	MaxQueueDepth uint64
+
+	// MaxPendingAge is how old the oldest waiting Message can be before we're lagged. There's no limit if
+	// it's 0
+	MaxPendingAge time.Duration
+
+	// CheckInterval is how often we check, defaulting to DefaultBacklogCheckInterval
+	CheckInterval time.Duration
+}
+
+// BacklogStatus is how our backlog looked when it was last checked (see BacklogAlerts)
+type BacklogStatus struct {
+	QueueDepth       uint64        `json:"queueDepth"`
+	OldestPendingAge time.Duration `json:"oldestPendingAge"`
+
+	// Lagged means our backlog is over one of our BacklogAlerts' thresholds, and Reasons says which
+	Lagged  bool     `json:"lagged"`
+	Reasons []string `json:"reasons,omitempty"`
+
+	// Offline means we haven't heard from any of our peers within our PeerTimeout
+	Offline bool `json:"offline"`
+
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// backlogWatch keeps track of whether we're lagged or offline, and who wants to know when that changes
+type backlogWatch struct {
+	mutex  sync.Mutex
+	status BacklogStatus
+	hooks  []func(BacklogStatus)
+}
+
+// SetBacklogAlerts changes our BacklogAlerts while we're running. A new CheckInterval only takes effect
+// the next time we start
+func (accord *Accord) SetBacklogAlerts(alerts BacklogAlerts) {
+	accord.backlog.mutex.Lock()
+	defer accord.backlog.mutex.Unlock()
+	accord.BacklogAlerts = alerts
+}
+
+// OnBacklogChange registers a function to be called whenever we become lagged or offline, or stop being
+// either (see BacklogAlerts). Hooks are called from the goroutine that checks our backlog, so they should
+// return quickly
+func (accord *Accord) OnBacklogChange(hook func(BacklogStatus)) {
+	accord.backlog.mutex.Lock()
+	defer accord.backlog.mutex.Unlock()
+	accord.backlog.hooks = append(accord.backlog.hooks, hook)
+}
+
+// BacklogStatus returns how our backlog looked when it was last checked, which is the zero value if it
+// hasn't been since we started
+func (accord *Accord) BacklogStatus() BacklogStatus {
+	accord.backlog.mutex.Lock()
+	defer accord.backlog.mutex.Unlock()
+	status := accord.backlog.status
+	status.Reasons = append([]string(nil), status.Reasons...)
+	return status
+}
+
+// backlogCheckInterval returns how often we check our backlog
+func (accord *Accord) backlogCheckInterval() time.Duration {
+	accord.backlog.mutex.Lock()
+	defer accord.backlog.mutex.Unlock()
+	if accord.BacklogAlerts.CheckInterval <= 0 {
+		return DefaultBacklogCheckInterval
+	}
+	return accord.BacklogAlerts.CheckInterval
+}
+
+// watchBacklog checks our backlog every CheckInterval until we stop
+func (accord *Accord) watchBacklog() {
+	accord.backlog.mutex.Lock()
+	accord.backlog.status = BacklogStatus{}
+	accord.backlog.mutex.Unlock()
+
+	stopped := accord.stopContext.Done()
+	go func() {
+		ticker := time.NewTicker(accord.backlogCheckInterval())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				accord.checkBacklog()
+			case <-stopped:
+				return
+			}
+		}
+	}()
+}
+
+// checkBacklog measures our backlog against our BacklogAlerts, reporting on whatever changed since we
+// last checked
+func (accord *Accord) checkBacklog() {
+	status := BacklogStatus{QueueDepth: accord.QueueDepth(), CheckedAt: time.Now().UTC()}
+	age, err := accord.OldestPendingAge()
+	if err != nil {
+		accord.Logger.WithError(err).Warn("Unable to find the age of our oldest waiting message")
+	}
+	status.OldestPendingAge = age
+
+	accord.backlog.mutex.Lock()
+	alerts := accord.BacklogAlerts
+	accord.backlog.mutex.Unlock()
+
+	if alerts.MaxQueueDepth > 0 && status.QueueDepth > alerts.MaxQueueDepth {
+		status.Reasons = append(status.Reasons, fmt.Sprintf("%d messages are waiting, more than %d", status.QueueDepth, alerts.MaxQueueDepth))
+	}
+	if alerts.MaxPendingAge > 0 && status.OldestPendingAge > alerts.MaxPendingAge {
+		status.Reasons = append(status.Reasons, fmt.Sprintf("the oldest waiting message is %s old, more than %s",
+			status.OldestPendingAge.Truncate(time.Second), alerts.MaxPendingAge))
+	}
+	status.Lagged = len(status.Reasons) > 0
+
+	peers := accord.PeerLiveness()
+	status.Offline = len(peers) > 0
+	for _, peer := range peers {
+		if peer.Healthy {
+			status.Offline = false
+			break
+		}
+	}
+
+	accord.backlog.mutex.Lock()
+	previous := accord.backlog.status
+	accord.backlog.status = status
+	hooks := append([]func(BacklogStatus){}, accord.backlog.hooks...)
+	accord.backlog.mutex.Unlock()
+
+	if status.Lagged == previous.Lagged && status.Offline == previous.Offline {
+		return
+	}
+
+	fields := map[string]interface{}{"queue_depth": status.QueueDepth, "oldest_pending_age": status.OldestPendingAge.String()}
+	log := accord.Logger.WithField("queue_depth", status.QueueDepth).WithField("oldest_pending_age", status.OldestPendingAge)
+	if status.Lagged && !previous.Lagged {
+		reasons := strings.Join(status.Reasons, ", ")
+		log.WithField("reasons", reasons).Warn("Our backlog is falling behind")
+		accord.Emit(EventBacklogLagged, "Synchronization is delayed: "+reasons, fields)
+	} else if !status.Lagged && previous.Lagged {
+		log.Info("Our backlog has recovered")
+		accord.Emit(EventBacklogCleared, "Synchronization has caught up", fields)
+	}
+	if status.Offline && !previous.Offline {
+		log.Warn("We haven't heard from any of our peers in a while, so we seem to be offline")
+		accord.Emit(EventOffline, "We seem to be offline", fields)
+	} else if !status.Offline && previous.Offline {
+		log.Info("We're back online")
+		accord.Emit(EventOnline, "We're back online", fields)
+	}
+
+	for _, hook := range hooks {
+		err := runBacklogHook(hook, status)
+		if panicErr, ok := err.(*PanicError); ok {
+			accord.logPanic(panicErr)
+		}
+	}
+}
+
+// runBacklogHook calls a single OnBacklogChange hook, turning a panic into a PanicError
+func runBacklogHook(hook func(BacklogStatus), status BacklogStatus) (err error) {
+	defer recoverPanic("OnBacklogChange hook", &err)
+	hook(status)
+	return nil
+}
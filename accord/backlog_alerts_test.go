@@ -0,0 +1,89 @@
+package accord
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBacklogAlerts(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	accord.BacklogAlerts = BacklogAlerts{MaxQueueDepth: 1, CheckInterval: 10 * time.Millisecond}
+	accord.PeerTimeout = time.Hour
+	changes := make(chan BacklogStatus, 10)
+	accord.OnBacklogChange(func(status BacklogStatus) { changes <- status })
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	wait := func() BacklogStatus {
+		select {
+		case status := <-changes:
+			return status
+		case <-time.After(2 * time.Second):
+			t.Fatal("our backlog never changed")
+		}
+		return BacklogStatus{}
+	}
+
+	peer := accord.AddPeer("remote")
+	for _, payload := range []string{"one", "two"} {
+		msg, _ := NewMessage([]byte(payload))
+		assert.Nil(t, accord.HandleNewMessage(msg))
+	}
+	status := wait()
+	assert.True(t, status.Lagged)
+	assert.False(t, status.Offline)
+	assert.Equal(t, uint64(2), status.QueueDepth)
+	assert.Len(t, status.Reasons, 1)
+	assert.True(t, accord.BacklogStatus().Lagged)
+
+	// Catching up clears the alert
+	msgs, err := peer.Peek(2)
+	assert.Nil(t, err)
+	assert.Nil(t, peer.Advance(len(msgs)))
+	status = wait()
+	assert.False(t, status.Lagged)
+	assert.Empty(t, status.Reasons)
+
+	kinds := []string{}
+	for _, event := range accord.RecentEvents() {
+		if event.Kind == EventBacklogLagged || event.Kind == EventBacklogCleared {
+			kinds = append(kinds, event.Kind)
+		}
+	}
+	assert.Equal(t, []string{EventBacklogLagged, EventBacklogCleared}, kinds)
+}
+
+func TestBacklogOffline(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	accord.BacklogAlerts = BacklogAlerts{CheckInterval: 2 * time.Millisecond}
+	accord.PeerTimeout = 10 * time.Millisecond
+	events := make(chan Event, 10)
+	accord.Subscribe(func(event Event) {
+		if event.Kind == EventOffline || event.Kind == EventOnline {
+			events <- event
+		}
+	})
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+	accord.AddPeer("remote")
+
+	wait := func(kind string) {
+		select {
+		case event := <-events:
+			assert.Equal(t, kind, event.Kind)
+		case <-time.After(2 * time.Second):
+			t.Fatal("we never emitted " + kind)
+		}
+	}
+
+	// Nobody has been in touch since we started, so we're offline until somebody is
+	wait(EventOffline)
+	assert.True(t, accord.BacklogStatus().Offline)
+	accord.Heartbeat("remote")
+	wait(EventOnline)
+	assert.False(t, accord.BacklogStatus().Offline)
+}
@@ -0,0 +1,42 @@
+package accord
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBacklog(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	age, err := accord.OldestPendingAge()
+	assert.Nil(t, err)
+	assert.Zero(t, age)
+	assert.Zero(t, accord.QueueDepth())
+
+	peer := accord.AddPeer("remote")
+	for _, payload := range []string{"one", "two"} {
+		msg, _ := NewMessage([]byte(payload))
+		msg.Timestamp = msg.Timestamp.Add(-time.Minute)
+		assert.Nil(t, accord.HandleNewMessage(msg))
+	}
+	assert.Equal(t, uint64(2), accord.QueueDepth())
+	assert.Equal(t, map[string]uint64{"remote": 2}, accord.PeerLags())
+	age, err = accord.OldestPendingAge()
+	assert.Nil(t, err)
+	assert.True(t, age >= time.Minute)
+
+	// Once our peer has caught up there's nothing left waiting
+	msgs, err := peer.Peek(2)
+	assert.Nil(t, err)
+	assert.Nil(t, peer.Advance(len(msgs)))
+	assert.Zero(t, accord.QueueDepth())
+	assert.Equal(t, map[string]uint64{"remote": 0}, accord.PeerLags())
+	age, err = accord.OldestPendingAge()
+	assert.Nil(t, err)
+	assert.Zero(t, age)
+}
@@ -0,0 +1,159 @@
+package accord
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/beeker1121/goque"
+)
+
+// queueFullPollInterval is how often a blocked HandleNewMessage checks whether our queue has room again
+const queueFullPollInterval = 50 * time.Millisecond
+
+// EventQueueFull is emitted when a new Message finds our queue full
+const EventQueueFull = "queue_full"
+
+// ErrQueueFull is returned by HandleNewMessage when our queue is over MaxQueueLength or MaxQueueBytes and
+// our QueueFullPolicy is QueueFullReject. The Message hasn't been performed
+var ErrQueueFull = errors.New("the synchronization queue is full")
+
+// QueueFullPolicy decides what happens to a new Message when our queue is full (see MaxQueueLength)
+type QueueFullPolicy int
+
+const (
+	// QueueFullReject refuses the Message with ErrQueueFull, leaving it to the caller to try again later
+	QueueFullReject QueueFullPolicy = iota
+
+	// QueueFullBlock makes HandleNewMessage wait until our peers have caught up enough for there to be
+	// room again
+	QueueFullBlock
+
+	// QueueFullShed hands the Message to our ShedFunc instead of performing it, so the application can
+	// spool it somewhere else, drop it on the floor, or return an error of its own
+	QueueFullShed
+)
+
+// ShedFunc is handed the new Messages we refuse because our queue is full under QueueFullShed. Whatever
+// it returns is returned by HandleNewMessage
+type ShedFunc func(msg *Message) error
+
+// QueuedBytes returns the size of the Messages waiting in our queues, as they're stored
+func (accord *Accord) QueuedBytes() int64 {
+	return atomic.LoadInt64(&accord.queuedBytes)
+}
+
+// QueuedMessages returns how many Messages are waiting in our queues, across all of our channels
+func (accord *Accord) QueuedMessages() uint64 {
+	total := uint64(0)
+	for _, ch := range accord.channels {
+		total += ch.length()
+	}
+	return total
+}
+
+// queueFull reports whether there's no room for another Message in a channel's queue. Each channel gets
+// MaxQueueLength and MaxQueueBytes to itself, so one that has backed up doesn't hold up the others
+func (accord *Accord) queueFull(ch *channel) bool {
+	if accord.MaxQueueLength > 0 && ch.length() >= uint64(accord.MaxQueueLength) {
+		return true
+	}
+	return accord.MaxQueueBytes > 0 && atomic.LoadInt64(&ch.bytes) >= accord.MaxQueueBytes
+}
+
+// waitForRoom applies our QueueFullPolicy to a new Message before it's handled. It returns done when the
+// Message shouldn't be handled any further, along with what HandleNewMessage should return. Blocking
+// happens here, outside of processMutex, so that remote Messages can carry on being handled meanwhile
+func (accord *Accord) waitForRoom(ch *channel, msg *Message) (done bool, err error) {
+	if !accord.queueFull(ch) {
+		return false, nil
+	}
+
+	queued, bytes := ch.length(), atomic.LoadInt64(&ch.bytes)
+	accord.Logger.WithField("channel", ch.name).WithField("queued", queued).WithField("bytes", bytes).Warn("Our synchronization queue is full")
+	accord.metrics().Count(MetricQueueFull, 1)
+	accord.Emit(EventQueueFull, "The synchronization queue is full", map[string]interface{}{"channel": ch.name, "queued": queued, "bytes": bytes, "policy": int(accord.QueueFullPolicy)})
+
+	switch accord.QueueFullPolicy {
+	case QueueFullBlock:
+		for accord.queueFull(ch) {
+			if accord.Paused() || accord.Draining() {
+				// Neither will let the Message through anyway, so let it find that out for itself
+				return false, nil
+			}
+			time.Sleep(queueFullPollInterval)
+		}
+		return false, nil
+
+	case QueueFullShed:
+		if accord.ShedFunc == nil {
+			return true, ErrQueueFull
+		}
+		return true, accord.ShedFunc(msg)
+
+	default:
+		return true, ErrQueueFull
+	}
+}
+
+// enqueue adds serialized Message data to the back of a shard, keeping track of its size
+func (accord *Accord) enqueue(shard *shard, data []byte) (*goque.Item, error) {
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	return accord.enqueueLocked(shard, data)
+}
+
+// enqueueLocked is enqueue for a caller that already holds the shard's mutex
+func (accord *Accord) enqueueLocked(shard *shard, data []byte) (*goque.Item, error) {
+	if accord.state.buffer != nil {
+		return accord.bufferLocked(shard, data)
+	}
+
+	item, err := shard.queue.Enqueue(data)
+	if err != nil {
+		return nil, err
+	}
+	shard.tail = item.ID
+	accord.syncQueueWrite(shard.path)
+	accord.addQueuedBytes(shard.channel, int64(len(item.Value)))
+	accord.recordQueue()
+	return item, nil
+}
+
+// dequeue removes the Message at the front of a shard, keeping track of its size
+func (accord *Accord) dequeue(shard *shard) error {
+	item, err := shard.queue.Dequeue()
+	if err != nil {
+		return err
+	}
+	accord.addQueuedBytes(shard.channel, -int64(len(item.Value)))
+	accord.recordQueue()
+	return nil
+}
+
+// countQueuedBytes works out how big the Messages already in a shard are when we open it
+func (accord *Accord) countQueuedBytes(shard *shard) error {
+	var total int64
+	head, err := shard.queue.Peek()
+	if err == goque.ErrEmpty {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for id := head.ID; ; id++ {
+		item, err := shard.queue.PeekByID(id)
+		if err == goque.ErrOutOfBounds {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		total += int64(len(item.Value))
+	}
+
+	accord.addQueuedBytes(shard.channel, total)
+	accord.recordQueue()
+	return nil
+}
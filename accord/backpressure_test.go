@@ -0,0 +1,58 @@
+package accord
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueueLimits(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	accord.MaxQueueLength = 2
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	peer := accord.AddPeer("remote")
+	for _, payload := range []string{"one", "two"} {
+		msg, _ := NewMessage([]byte(payload))
+		assert.Nil(t, accord.HandleNewMessage(msg))
+	}
+	assert.True(t, accord.QueuedBytes() > 0)
+
+	msg, _ := NewMessage([]byte("three"))
+	assert.Equal(t, ErrQueueFull, accord.HandleNewMessage(msg))
+	assert.Equal(t, uint64(2), accord.History().Len())
+
+	shed := []string{}
+	accord.QueueFullPolicy = QueueFullShed
+	accord.ShedFunc = func(msg *Message) error {
+		shed = append(shed, string(msg.Payload))
+		return nil
+	}
+	assert.Nil(t, accord.HandleNewMessage(msg))
+	assert.Equal(t, []string{"three"}, shed)
+	assert.Equal(t, uint64(2), accord.History().Len())
+
+	// Blocking waits for our peer to make room
+	accord.QueueFullPolicy = QueueFullBlock
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		peer.Advance(1)
+	}()
+	start := time.Now()
+	assert.Nil(t, accord.HandleNewMessage(msg))
+	assert.True(t, time.Since(start) >= 20*time.Millisecond)
+	assert.Equal(t, uint64(2), accord.Queue().Len())
+
+	// Bytes are counted the same way after a restart
+	bytes := accord.QueuedBytes()
+	peer.Advance(1)
+	assert.True(t, accord.QueuedBytes() < bytes)
+	bytes = accord.QueuedBytes()
+	assert.True(t, bytes > 0)
+	accord.Stop()
+	assert.Nil(t, accord.Start())
+	assert.Equal(t, bytes, accord.QueuedBytes())
+}
@@ -0,0 +1,355 @@
+package accord
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/beeker1121/goque"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// backupBatchSize is how many items we write to a backup's stores at a time
+const backupBatchSize = 1000
+
+// ErrBackupExists is returned by Backup when asked to write into a directory that isn't empty
+var ErrBackupExists = errors.New("the backup directory already exists and isn't empty")
+
+// ErrRunning is returned by RestoreBackup while the node is running
+var ErrRunning = errors.New("the node must be stopped first")
+
+// backupPoint is what our stores looked like at the moment a backup was taken
+type backupPoint struct {
+	// state is a snapshot of our state database
+	state *leveldb.Snapshot
+
+	// history is the ID of the most recent Message in our history, and queues the ID of the most recently
+	// queued item on each of our shards (zero for those that were empty)
+	history uint64
+	queues  map[*shard]uint64
+}
+
+// Backup writes a consistent copy of our queues, history and state into dir (which must not exist yet, or
+// be empty) while we carry on running. Messages are only held up for as long as it takes to note where
+// each of our stores is at: everything written after that is left out of the backup, so that it captures
+// a single moment in time like a node that was stopped cleanly would. Use RestoreBackup to bring it back
+func (accord *Accord) Backup(dir string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err == nil && len(entries) > 0 {
+		return ErrBackupExists
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// Our history can't be pruned while it's copied
+	accord.historyMutex.RLock()
+	defer accord.historyMutex.RUnlock()
+
+	point, err := accord.backupPoint()
+	if err != nil {
+		return err
+	}
+	defer point.state.Release()
+
+	// Our stores keep their position in the data directory, channels and shards included
+	for _, shard := range accord.allShards() {
+		err = accord.backupQueue(shard.queue, point.queues[shard], accord.backupPath(dir, shard.path))
+		if err != nil {
+			return err
+		}
+	}
+	err = accord.backupQueue(accord.deadLetters, 0, filepath.Join(dir, DeadLetterFilename))
+	if err != nil {
+		return err
+	}
+	err = accord.backupHistory(point.history, filepath.Join(dir, HistoryFilename))
+	if err != nil {
+		return err
+	}
+	err = backupState(point.state, filepath.Join(dir, StateFilename))
+	if err != nil {
+		return err
+	}
+
+	accord.Logger.WithField("dir", dir).Info("Backup written")
+	return nil
+}
+
+// backupPoint holds up new Messages just long enough to note where each of our stores is at
+func (accord *Accord) backupPoint() (*backupPoint, error) {
+	accord.processMutex.Lock()
+	defer accord.processMutex.Unlock()
+
+	// Applied Messages are queued after processMutex has been let go of (see handleNewMessage), so the
+	// shards have to be held as well
+	shards := accord.allShards()
+	for _, shard := range shards {
+		shard.mutex.Lock()
+		defer shard.mutex.Unlock()
+	}
+
+	for _, shard := range shards {
+		err := accord.flushShardLocked(shard)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := accord.state.Flush()
+	if err != nil {
+		return nil, err
+	}
+	state, err := accord.state.db.GetSnapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	point := &backupPoint{state: state, queues: map[*shard]uint64{}}
+	if item, err := accord.historyStack.Peek(); err == nil {
+		point.history = item.ID
+	}
+	for _, shard := range shards {
+		if length := shard.queue.Length(); length > 0 {
+			item, err := shard.queue.PeekByOffset(length - 1)
+			if err != nil {
+				state.Release()
+				return nil, err
+			}
+			point.queues[shard] = item.ID
+		}
+	}
+	return point, nil
+}
+
+// backupPath returns where one of our stores goes inside a backup
+func (accord *Accord) backupPath(dir string, store string) string {
+	rel, err := filepath.Rel(filepath.Clean(accord.dataDir), store)
+	if err != nil {
+		rel = filepath.Base(store)
+	}
+	return filepath.Join(dir, rel)
+}
+
+// backupQueue copies a queue into a new store at dest, up to (and including) the item with the ID last, or
+// everything in it if last is zero. Items keep their IDs, which our peers' cursors point at. Anything
+// dequeued while we copy has already been sent everywhere it needs to go, so it's simply skipped
+func (accord *Accord) backupQueue(queue *goque.Queue, last uint64, dest string) error {
+	db, err := openBackupStore(dest)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if last == 0 {
+		length := queue.Length()
+		if length == 0 {
+			return nil
+		}
+		item, err := queue.PeekByOffset(length - 1)
+		if err != nil {
+			return err
+		}
+		last = item.ID
+	}
+
+	head, err := queue.Peek()
+	if err == goque.ErrEmpty {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	batch := new(leveldb.Batch)
+	for id := head.ID; id <= last; id++ {
+		item, err := queue.PeekByID(id)
+		if err == goque.ErrOutOfBounds {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		err = writeBackupItem(db, batch, item.Key, item.Value)
+		if err != nil {
+			return err
+		}
+	}
+	return db.Write(batch, nil)
+}
+
+// backupHistory copies our history into a new store at dest, up to (and including) the Message with the
+// ID last. Nothing is removed from our history while it's copied (see PruneHistory), so it can be copied
+// while Messages are being added
+func (accord *Accord) backupHistory(last uint64, dest string) error {
+	db, err := openBackupStore(dest)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	length := accord.historyStack.Length()
+	if last == 0 || length == 0 {
+		return nil
+	}
+	oldest, err := accord.historyStack.PeekByOffset(length - 1)
+	if err != nil {
+		return err
+	}
+
+	batch := new(leveldb.Batch)
+	for id := oldest.ID; id <= last; id++ {
+		item, err := accord.historyStack.PeekByID(id)
+		if err != nil {
+			return err
+		}
+		err = writeBackupItem(db, batch, item.Key, item.Value)
+		if err != nil {
+			return err
+		}
+	}
+	return db.Write(batch, nil)
+}
+
+// backupState copies a snapshot of our state database into a new store at dest. The backup is as good as
+// a cleanly stopped node, so it isn't marked as open
+func backupState(state *leveldb.Snapshot, dest string) error {
+	db, err := openBackupStore(dest)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	batch := new(leveldb.Batch)
+	iter := state.NewIterator(&util.Range{}, nil)
+	defer iter.Release()
+	for iter.Next() {
+		if string(iter.Key()) == openKey {
+			continue
+		}
+		err = writeBackupItem(db, batch, iter.Key(), iter.Value())
+		if err != nil {
+			return err
+		}
+	}
+	if iter.Error() != nil {
+		return iter.Error()
+	}
+	return db.Write(batch, nil)
+}
+
+// openBackupStore creates one of a backup's stores
+func openBackupStore(dest string) (*leveldb.DB, error) {
+	err := os.MkdirAll(filepath.Dir(dest), 0755)
+	if err != nil {
+		return nil, err
+	}
+	return leveldb.OpenFile(dest, nil)
+}
+
+// writeBackupItem adds an item to the batch being written to a backup's store, writing it out once it's
+// grown big enough
+func writeBackupItem(db *leveldb.DB, batch *leveldb.Batch, key []byte, value []byte) error {
+	batch.Put(key, value)
+	if batch.Len() < backupBatchSize {
+		return nil
+	}
+	err := db.Write(batch, nil)
+	batch.Reset()
+	return err
+}
+
+// RestoreBackup replaces our data directory's stores with the ones in a backup written by Backup. The node
+// must be stopped (or not started yet); start it once the backup has been restored. The backup is copied,
+// so it can be restored again later
+func (accord *Accord) RestoreBackup(dir string) error {
+	accord.componentsMutex.Lock()
+	running := accord.started
+	accord.componentsMutex.Unlock()
+	if running {
+		return ErrRunning
+	}
+
+	// Make sure this really is a backup before we throw anything away
+	for _, store := range []string{HistoryFilename, StateFilename, SyncFilename} {
+		_, err := os.Stat(filepath.Join(dir, store))
+		if err != nil {
+			return err
+		}
+	}
+
+	err := accord.removeStores()
+	if err != nil {
+		return err
+	}
+	err = copyTree(dir, filepath.Clean(accord.dataDir))
+	if err != nil {
+		return err
+	}
+
+	accord.Logger.WithField("dir", dir).Info("Backup restored")
+	return nil
+}
+
+// removeStores deletes all of our stores from our data directory
+func (accord *Accord) removeStores() error {
+	stores := []string{HistoryFilename, StateFilename, DeadLetterFilename, ChannelsDirname, SyncFilename}
+	shards, err := filepath.Glob(filepath.Join(accord.dataDir, SyncFilename+".*"))
+	if err != nil {
+		return err
+	}
+
+	for _, store := range stores {
+		err := os.RemoveAll(filepath.Join(accord.dataDir, store))
+		if err != nil {
+			return err
+		}
+	}
+	for _, shard := range shards {
+		err := os.RemoveAll(shard)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyTree copies every file under src into dest, creating directories as it goes
+func copyTree(src string, dest string) error {
+	return filepath.Walk(src, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, name)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		return copyFile(name, target)
+	})
+}
+
+// copyFile copies a single file
+func copyFile(src string, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
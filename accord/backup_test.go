@@ -0,0 +1,61 @@
+package accord
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackupAndRestore(t *testing.T) {
+	defer AccordCleanup()
+	dir, err := ioutil.TempDir("", "accord-backup")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	accord := DummyAccord()
+	accord.QueueShards = 2
+	assert.Nil(t, accord.Start())
+	peer := accord.AddPeer("edge")
+	for i := 0; i < 3; i++ {
+		msg, _ := NewMessage([]byte("backed up"))
+		assert.Nil(t, accord.HandleNewMessage(msg))
+	}
+	for _, shard := range peer.Shards() {
+		if shard.pending() > 0 {
+			assert.Nil(t, shard.Advance(1))
+			break
+		}
+	}
+	current := accord.CurrentState()
+
+	assert.Nil(t, accord.Backup(dir))
+	assert.Equal(t, ErrBackupExists, accord.Backup(dir))
+	assert.Equal(t, ErrRunning, accord.RestoreBackup(dir))
+
+	// Anything after the backup was taken is left out of it
+	msg, _ := NewMessage([]byte("too late"))
+	assert.Nil(t, accord.HandleNewMessage(msg))
+	accord.Stop()
+
+	accord = DummyAccord()
+	accord.QueueShards = 2
+	assert.Nil(t, accord.RestoreBackup(dir))
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	assert.Equal(t, current, accord.CurrentState())
+	assert.Equal(t, uint64(3), accord.History().Len())
+	assert.Equal(t, uint64(2), accord.QueuedMessages())
+	// Our peers' cursors point into the restored queues just as they did before
+	accord.AddPeer("edge")
+	lag, err := accord.PeerLag("edge")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), lag)
+
+	// The backup is left as it was, so it can be restored again
+	_, err = os.Stat(filepath.Join(dir, StateFilename))
+	assert.Nil(t, err)
+}
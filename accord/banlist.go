@@ -0,0 +1,242 @@
+package accord
+
+import (
+	"encoding/binary"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// banPrefix is where we persist our bans in the state database
+const banPrefix = "ban/"
+
+// Event kinds emitted by our peer policy
+const (
+	EventPeerViolation = "peer_violation"
+	EventPeerBanned    = "peer_banned"
+	EventPeerUnbanned  = "peer_unbanned"
+)
+
+// Violation is a kind of misbehaviour a Component can report a peer for
+type Violation string
+
+const (
+	// ViolationBadSignature is reported when a peer sends something that fails verification
+	ViolationBadSignature Violation = "bad_signature"
+
+	// ViolationRateLimit is reported when a peer keeps sending more than it's allowed to
+	ViolationRateLimit Violation = "rate_limit"
+
+	// ViolationMalformed is reported when a peer sends something we can't make sense of
+	ViolationMalformed Violation = "malformed"
+
+	// ViolationUnauthorized is reported when a peer doesn't present a token we accept (see PeerAuth)
+	ViolationUnauthorized Violation = "unauthorized"
+)
+
+// PeerPolicy decides which peers Components should talk to. Peers are identified by their address; any
+// port is ignored, so every connection from the same host is treated the same
+type PeerPolicy struct {
+	// Allow, if it isn't empty, lists the only peers we'll talk to. Entries can be host names, IP addresses
+	// or CIDR ranges ("10.0.0.0/8")
+	Allow []string
+
+	// BanThreshold is how many violations a peer can rack up within BanWindow before it gets banned,
+	// defaulting to 5. Set it to a negative number to never ban automatically
+	BanThreshold int
+
+	// BanWindow is how far back violations are counted, defaulting to one minute
+	BanWindow time.Duration
+
+	// BanDuration is how long automatic bans last, defaulting to ten minutes
+	BanDuration time.Duration
+}
+
+// Ban describes a peer that we're refusing to talk to
+type Ban struct {
+	Peer  string
+	Until time.Time
+}
+
+// banList keeps track of recent violations and our current bans. Bans are kept in memory for quick checks
+// and persisted in our state database so that they survive a restart
+type banList struct {
+	mutex      sync.Mutex
+	violations map[string][]time.Time
+	bans       map[string]time.Time
+	now        func() time.Time
+}
+
+// peerHost strips the port, if any, from a peer's address
+func peerHost(peer string) string {
+	if host, _, err := net.SplitHostPort(peer); err == nil {
+		return host
+	}
+	return strings.Trim(peer, "[]")
+}
+
+// loadBans reads our persisted bans back out of the state database, forgetting any that have expired
+func (accord *Accord) loadBans() error {
+	accord.banList.mutex.Lock()
+	defer accord.banList.mutex.Unlock()
+
+	if accord.banList.now == nil {
+		accord.banList.now = time.Now
+	}
+	accord.banList.violations = map[string][]time.Time{}
+	accord.banList.bans = map[string]time.Time{}
+
+	now := accord.banList.now()
+	iter := accord.state.db.NewIterator(util.BytesPrefix([]byte(banPrefix)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		peer := strings.TrimPrefix(string(iter.Key()), banPrefix)
+		until := time.Unix(0, int64(binary.BigEndian.Uint64(iter.Value())))
+		if until.After(now) {
+			accord.banList.bans[peer] = until
+		} else {
+			accord.state.db.Delete(iter.Key(), nil)
+		}
+	}
+	return iter.Error()
+}
+
+// AllowPeer reports whether Components should accept traffic from, or send traffic to, the given peer. It
+// checks the peer against our allowlist and our current bans
+func (accord *Accord) AllowPeer(peer string) bool {
+	host := peerHost(peer)
+
+	if len(accord.PeerPolicy.Allow) > 0 && !peerAllowed(host, accord.PeerPolicy.Allow) {
+		return false
+	}
+
+	accord.banList.mutex.Lock()
+	defer accord.banList.mutex.Unlock()
+
+	until, ok := accord.banList.bans[host]
+	if !ok {
+		return true
+	}
+	if accord.banList.now().Before(until) {
+		return false
+	}
+
+	// The ban has run out, so forget about it
+	delete(accord.banList.bans, host)
+	accord.state.db.Delete([]byte(banPrefix+host), nil)
+	return true
+}
+
+// peerAllowed checks a host against an allowlist
+func peerAllowed(host string, allow []string) bool {
+	ip := net.ParseIP(host)
+	for _, entry := range allow {
+		if entry == host {
+			return true
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil && ip != nil && network.Contains(ip) {
+			return true
+		}
+		if allowed := net.ParseIP(entry); allowed != nil && ip != nil && allowed.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReportViolation records that a peer misbehaved. Once a peer has racked up BanThreshold violations within
+// BanWindow it gets banned for BanDuration. It returns whether the peer is now banned
+func (accord *Accord) ReportViolation(peer string, violation Violation, detail string) bool {
+	host := peerHost(peer)
+	policy := accord.PeerPolicy
+
+	threshold := policy.BanThreshold
+	if threshold == 0 {
+		threshold = 5
+	}
+	window := policy.BanWindow
+	if window <= 0 {
+		window = time.Minute
+	}
+	duration := policy.BanDuration
+	if duration <= 0 {
+		duration = 10 * time.Minute
+	}
+
+	accord.Logger.WithField("peer", host).WithField("violation", violation).WithField("detail", detail).Warn("Peer violation")
+	accord.Emit(EventPeerViolation, "A peer misbehaved", map[string]interface{}{"peer": host, "violation": string(violation), "detail": detail})
+
+	accord.banList.mutex.Lock()
+	now := accord.banList.now()
+	recent := []time.Time{now}
+	for _, at := range accord.banList.violations[host] {
+		if now.Sub(at) < window {
+			recent = append(recent, at)
+		}
+	}
+	accord.banList.violations[host] = recent
+	accord.banList.mutex.Unlock()
+
+	if threshold < 0 || len(recent) < threshold {
+		return false
+	}
+
+	err := accord.Ban(host, duration)
+	if err != nil {
+		accord.Logger.WithError(err).Warn("Unable to persist a ban")
+	}
+	return true
+}
+
+// Ban refuses all traffic with a peer for the given duration
+func (accord *Accord) Ban(peer string, duration time.Duration) error {
+	host := peerHost(peer)
+
+	accord.banList.mutex.Lock()
+	until := accord.banList.now().Add(duration)
+	accord.banList.bans[host] = until
+	delete(accord.banList.violations, host)
+	accord.banList.mutex.Unlock()
+
+	accord.Logger.WithField("peer", host).WithField("until", until).Warn("Banning peer")
+	accord.Emit(EventPeerBanned, "A peer was banned", map[string]interface{}{"peer": host, "until": until})
+
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, uint64(until.UnixNano()))
+	return accord.state.db.Put([]byte(banPrefix+host), data, nil)
+}
+
+// Unban lifts a ban on a peer early
+func (accord *Accord) Unban(peer string) error {
+	host := peerHost(peer)
+
+	accord.banList.mutex.Lock()
+	delete(accord.banList.bans, host)
+	delete(accord.banList.violations, host)
+	accord.banList.mutex.Unlock()
+
+	accord.Logger.WithField("peer", host).Info("Unbanning peer")
+	accord.Emit(EventPeerUnbanned, "A peer was unbanned", map[string]interface{}{"peer": host})
+
+	return accord.state.db.Delete([]byte(banPrefix+host), nil)
+}
+
+// Bans returns every peer we're currently refusing to talk to, sorted by peer
+func (accord *Accord) Bans() []Ban {
+	accord.banList.mutex.Lock()
+	defer accord.banList.mutex.Unlock()
+
+	now := accord.banList.now()
+	bans := []Ban{}
+	for peer, until := range accord.banList.bans {
+		if now.Before(until) {
+			bans = append(bans, Ban{Peer: peer, Until: until})
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].Peer < bans[j].Peer })
+	return bans
+}
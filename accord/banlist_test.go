@@ -0,0 +1,80 @@
+package accord
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeerAllowlist(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	accord.PeerPolicy.Allow = []string{"10.0.0.0/8", "192.168.1.5", "peer.example.com"}
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	assert.True(t, accord.AllowPeer("10.1.2.3:4000"))
+	assert.True(t, accord.AllowPeer("192.168.1.5"))
+	assert.True(t, accord.AllowPeer("peer.example.com:80"))
+	assert.False(t, accord.AllowPeer("192.168.1.6:4000"))
+}
+
+func TestPeerAutomaticBan(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	accord.PeerPolicy = PeerPolicy{BanThreshold: 3, BanWindow: time.Minute, BanDuration: time.Hour}
+	assert.Nil(t, accord.Start())
+
+	// Bans are checked against the real clock when they're loaded back from disk, so start from it
+	now := time.Now().Round(0)
+	accord.banList.now = func() time.Time { return now }
+
+	assert.False(t, accord.ReportViolation("10.0.0.1:5000", ViolationMalformed, "garbage"))
+	assert.False(t, accord.ReportViolation("10.0.0.1:5001", ViolationMalformed, "garbage"))
+	assert.True(t, accord.AllowPeer("10.0.0.1"))
+	assert.True(t, accord.ReportViolation("10.0.0.1:5002", ViolationBadSignature, "forged"))
+	assert.False(t, accord.AllowPeer("10.0.0.1:6000"))
+	assert.Equal(t, []Ban{{Peer: "10.0.0.1", Until: now.Add(time.Hour)}}, accord.Bans())
+
+	// Bans should survive a restart
+	accord.Stop()
+	accord = DummyAccord()
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+	accord.banList.now = func() time.Time { return now }
+	assert.False(t, accord.AllowPeer("10.0.0.1"))
+
+	// And run out on their own
+	accord.banList.now = func() time.Time { return now.Add(2 * time.Hour) }
+	assert.True(t, accord.AllowPeer("10.0.0.1"))
+	assert.Empty(t, accord.Bans())
+}
+
+func TestPeerViolationsExpire(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	accord.PeerPolicy = PeerPolicy{BanThreshold: 2, BanWindow: time.Minute}
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	now := time.Date(2017, time.April, 1, 0, 0, 0, 0, time.UTC)
+	accord.banList.now = func() time.Time { return now }
+	assert.False(t, accord.ReportViolation("10.0.0.1", ViolationRateLimit, ""))
+
+	now = now.Add(2 * time.Minute)
+	assert.False(t, accord.ReportViolation("10.0.0.1", ViolationRateLimit, ""))
+	assert.True(t, accord.AllowPeer("10.0.0.1"))
+}
+
+func TestPeerUnban(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	assert.Nil(t, accord.Ban("10.0.0.1", time.Hour))
+	assert.False(t, accord.AllowPeer("10.0.0.1"))
+	assert.Nil(t, accord.Unban("10.0.0.1"))
+	assert.True(t, accord.AllowPeer("10.0.0.1"))
+}
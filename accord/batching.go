@@ -0,0 +1,307 @@
+package accord
+
+import (
+	"encoding/binary"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/beeker1121/goque"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// appliedKey counts the Messages our state has been updated with, which is what lets us tell how much of
+// our history it's missing after a crash (see FlushInterval)
+const appliedKey = "applied"
+
+// DefaultFlushCount is how many state updates, and Messages waiting to be queued on each shard, we buffer
+// at most when FlushInterval is set but FlushCount isn't
+const DefaultFlushCount = 1000
+
+// stateBuffer holds the state updates that haven't been written to disk yet while we batch our writes
+type stateBuffer struct {
+	mutex sync.Mutex
+
+	// batch is everything waiting to be written, updates is how many state updates went into it, and
+	// values holds what it writes (nil for a delete) so that reads can see it before it's flushed
+	batch   *leveldb.Batch
+	updates int
+	values  map[string][]byte
+
+	// max is how many updates we buffer before flushing, flush writes out everything we've buffered
+	// (our queues' included, see Accord.flush) and stop ends our background flushes
+	max   int
+	flush func() error
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// Put implements leveldb.BatchReplay. The caller must hold the buffer's mutex
+func (buffer *stateBuffer) Put(key, value []byte) {
+	buffer.batch.Put(key, value)
+	buffer.values[string(key)] = append([]byte{}, value...)
+}
+
+// Delete implements leveldb.BatchReplay. The caller must hold the buffer's mutex
+func (buffer *stateBuffer) Delete(key []byte) {
+	buffer.batch.Delete(key)
+	buffer.values[string(key)] = nil
+}
+
+// flushCount returns how many state updates, and Messages waiting to be queued on each shard, we buffer at
+// most
+func (accord *Accord) flushCount() int {
+	if accord.FlushCount <= 0 {
+		return DefaultFlushCount
+	}
+	return accord.FlushCount
+}
+
+// startBatching makes our state buffer its writes, calling flush every interval to write them along with
+// whatever else is buffered, or writing them once max updates have been buffered, whichever comes first
+func (state *State) startBatching(interval time.Duration, max int, flush func() error) {
+	buffer := &stateBuffer{
+		batch:  new(leveldb.Batch),
+		values: map[string][]byte{},
+		max:    max,
+		flush:  flush,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	state.buffer = buffer
+
+	go func() {
+		defer close(buffer.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				buffer.flush()
+			case <-buffer.stop:
+				return
+			}
+		}
+	}()
+}
+
+// stopBatching stops our background flushes and writes whatever is still buffered. It's safe to call more
+// than once
+func (state *State) stopBatching() error {
+	buffer := state.buffer
+	if buffer == nil {
+		return nil
+	}
+	if buffer.stop != nil {
+		close(buffer.stop)
+		<-buffer.done
+		buffer.stop = nil
+	}
+	return buffer.flush()
+}
+
+// flush writes everything we've buffered while batching our writes (see FlushInterval). The Messages
+// waiting to be queued go first, so that going down part way through leaves our state behind our queues
+// rather than ahead of them, which recoverState knows how to catch up
+func (accord *Accord) flush() error {
+	err := accord.flushQueues()
+	if err != nil {
+		return err
+	}
+	return accord.state.Flush()
+}
+
+// flushQueues writes the Messages waiting to be queued on each of our shards
+func (accord *Accord) flushQueues() error {
+	for _, shard := range accord.allShards() {
+		err := accord.flushShard(shard)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flushShard writes the Messages waiting to be queued on a shard
+func (accord *Accord) flushShard(shard *shard) error {
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	return accord.flushShardLocked(shard)
+}
+
+// flushShardLocked is flushShard for a caller that already holds the shard's mutex. Whatever couldn't be
+// written is left waiting for the next flush
+func (accord *Accord) flushShardLocked(shard *shard) error {
+	if len(shard.pending) == 0 {
+		return nil
+	}
+
+	defer accord.syncQueueWrite(shard.path)
+	for len(shard.pending) > 0 {
+		item, err := shard.queue.Enqueue(shard.pending[0])
+		if err != nil {
+			return err
+		}
+		shard.tail = item.ID
+		shard.pending = shard.pending[1:]
+	}
+	shard.pending = nil
+	accord.recordQueue()
+	return nil
+}
+
+// bufferLocked holds on to serialized Message data to be added to the back of a shard on our next flush
+// while we batch our writes (see FlushInterval), handing back the item it's going to be. The shard is
+// written straight away once FlushCount Messages are waiting on it. The caller must hold the shard's mutex
+func (accord *Accord) bufferLocked(shard *shard, data []byte) (*goque.Item, error) {
+	shard.pending = append(shard.pending, data)
+	item := &goque.Item{ID: shard.tail + uint64(len(shard.pending)), Value: data}
+	accord.addQueuedBytes(shard.channel, int64(len(data)))
+	if len(shard.pending) >= accord.flushCount() {
+		err := accord.flushShardLocked(shard)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return item, nil
+}
+
+// Flush writes any buffered state updates to disk. It does nothing unless our writes are being batched
+func (state *State) Flush() error {
+	buffer := state.buffer
+	if buffer == nil {
+		return nil
+	}
+
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	if buffer.batch.Len() == 0 {
+		return nil
+	}
+
+	err := state.db.Write(buffer.batch, state.writeOptions)
+	if err != nil {
+		return err
+	}
+	buffer.batch = new(leveldb.Batch)
+	buffer.updates = 0
+	buffer.values = map[string][]byte{}
+	return nil
+}
+
+// write writes a batch of state updates, or adds it to our buffer if we're batching our writes
+func (state *State) write(batch *leveldb.Batch) error {
+	buffer := state.buffer
+	if buffer == nil {
+		return state.db.Write(batch, state.writeOptions)
+	}
+
+	buffer.mutex.Lock()
+	err := batch.Replay(buffer)
+	buffer.updates++
+	full := buffer.updates >= buffer.max
+	buffer.mutex.Unlock()
+	if err != nil || !full {
+		return err
+	}
+	return state.Flush()
+}
+
+// get reads a key from our state database, including any buffered writes that haven't been flushed yet
+func (state *State) get(key []byte) ([]byte, error) {
+	if buffer := state.buffer; buffer != nil {
+		buffer.mutex.Lock()
+		value, ok := buffer.values[string(key)]
+		buffer.mutex.Unlock()
+		if ok {
+			if value == nil {
+				return nil, leveldb.ErrNotFound
+			}
+			return value, nil
+		}
+	}
+	return state.db.Get(key, nil)
+}
+
+// buffered returns the buffered writes to keys starting with prefix that haven't been flushed yet, with nil
+// for a delete
+func (state *State) buffered(prefix []byte) map[string][]byte {
+	found := map[string][]byte{}
+	buffer := state.buffer
+	if buffer == nil {
+		return found
+	}
+
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	for key, value := range buffer.values {
+		if strings.HasPrefix(key, string(prefix)) {
+			found[key] = value
+		}
+	}
+	return found
+}
+
+// loadApplied reads how many Messages our state has been updated with. Databases from before we kept count
+// (brand new ones included) are assumed to be in step with our history, which we write down straight away
+// so that it's already on disk if we crash before our first flush
+func (state *State) loadApplied(historyLen uint64) error {
+	val, err := state.db.Get([]byte(appliedKey), nil)
+	if err == leveldb.ErrNotFound {
+		state.applied = historyLen
+		data := make([]byte, 8)
+		binary.BigEndian.PutUint64(data, historyLen)
+		return state.db.Put([]byte(appliedKey), data, nil)
+	}
+	if err != nil {
+		return err
+	}
+	state.applied = binary.BigEndian.Uint64(val)
+	return nil
+}
+
+// recoverState brings our state back in step with our history after a crash lost some of its buffered
+// updates, by updating it again with every Message in our history it's missing. Messages that were only
+// marked as delivered (because they were skipped or rejected) aren't in our history, so our peers will
+// send them to us again
+func (accord *Accord) recoverState() error {
+	// Messages pruned from our history still count (see PruneHistory)
+	pruned, stale, err := accord.historyBaseline()
+	if err != nil {
+		return err
+	}
+	history := accord.History()
+	kept := history.Len() - stale
+	err = accord.state.loadApplied(kept + pruned.Count)
+	if err != nil {
+		return err
+	}
+
+	if kept+pruned.Count <= accord.state.applied {
+		return nil
+	}
+
+	missing := kept + pruned.Count - accord.state.applied
+	if missing > kept {
+		missing = kept
+	}
+	accord.Logger.WithField("missing", missing).Warn("Our state is behind our history, most likely because we weren't stopped cleanly. Catching it up")
+	for offset := missing; offset > 0; offset-- {
+		item, err := accord.historyStack.PeekByOffset(offset - 1)
+		if err != nil {
+			return err
+		}
+		msg, err := accord.sealer.decodeMessage(item.Value)
+		if err != nil {
+			return err
+		}
+		// Our indexes are written along with our state, so they've fallen just as far behind
+		batch := new(leveldb.Batch)
+		accord.indexMessage(batch, msg, item.ID)
+		err = accord.state.update([]*Message{msg}, batch, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return accord.state.Flush()
+}
@@ -0,0 +1,116 @@
+package accord
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchedStateWrites(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	accord.FlushInterval = time.Hour
+	accord.FlushCount = 3
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	for i := 0; i < 2; i++ {
+		msg, _ := NewMessage([]byte("buffered"))
+		msg.Key = "user"
+		assert.Nil(t, accord.HandleNewMessage(msg))
+	}
+
+	applied := func() uint64 {
+		val, err := accord.state.db.Get([]byte(appliedKey), nil)
+		assert.Nil(t, err)
+		return binary.BigEndian.Uint64(val)
+	}
+
+	// Nothing has been written yet, but reads see the buffered updates anyway
+	assert.Equal(t, uint64(0), applied())
+	seq, err := accord.state.KeySeq(accord.NodeID, "user")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), seq)
+
+	msg, _ := NewMessage([]byte("flushed"))
+	assert.Nil(t, accord.HandleNewMessage(msg))
+	assert.Equal(t, uint64(3), applied())
+}
+
+func TestBatchedStateRecovery(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	accord.FlushInterval = time.Hour
+	assert.Nil(t, accord.Start())
+
+	for i := 0; i < 3; i++ {
+		msg, _ := NewMessage([]byte("lost"))
+		assert.Nil(t, accord.HandleNewMessage(msg))
+	}
+	current, clock := accord.CurrentState(), accord.Clock()
+
+	// Crashing loses whatever was buffered
+	accord.state.buffer.mutex.Lock()
+	accord.state.buffer.batch.Reset()
+	accord.state.buffer.values = map[string][]byte{}
+	accord.state.buffer.mutex.Unlock()
+	accord.Stop()
+
+	accord = DummyAccord()
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+	assert.Equal(t, current, accord.CurrentState())
+	assert.Equal(t, clock, accord.Clock())
+	assert.Equal(t, uint64(3), accord.state.applied)
+}
+
+func TestBatchedQueueAppends(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	accord.FlushInterval = time.Hour
+	accord.FlushCount = 3
+	assert.Nil(t, accord.Start())
+
+	queued := []queuedItem{}
+	for i := 0; i < 2; i++ {
+		msg, _ := NewMessage([]byte("buffered"))
+		item, err := accord.handleNewMessage(context.Background(), msg)
+		assert.Nil(t, err)
+		queued = append(queued, item)
+	}
+
+	// Nothing has been queued yet, but each Message already knows which item it's going to be
+	assert.Equal(t, uint64(0), accord.Queue().Len())
+	assert.Equal(t, uint64(1), queued[0].id)
+	assert.Equal(t, uint64(2), queued[1].id)
+
+	msg, _ := NewMessage([]byte("flushed"))
+	assert.Nil(t, accord.HandleNewMessage(msg))
+	assert.Equal(t, uint64(3), accord.Queue().Len())
+	item, err := accord.Queue().Get(2)
+	assert.Nil(t, err)
+	assert.Equal(t, msg.ID, item.ID)
+
+	// Stopping writes whatever is still buffered, and we carry on counting from where we were
+	msg, _ = NewMessage([]byte("stopped"))
+	assert.Nil(t, accord.HandleNewMessage(msg))
+	assert.Equal(t, uint64(3), accord.Queue().Len())
+	accord.Stop()
+
+	accord = DummyAccord()
+	accord.FlushInterval = time.Hour
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+	assert.Equal(t, uint64(4), accord.Queue().Len())
+	next, _ := NewMessage([]byte("next"))
+	item5, err := accord.handleNewMessage(context.Background(), next)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(5), item5.id)
+	assert.Nil(t, accord.flush())
+	item, err = accord.Queue().Get(4)
+	assert.Nil(t, err)
+	assert.Equal(t, next.ID, item.ID)
+}
@@ -0,0 +1,203 @@
+package accord
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// benchPayload is the payload of the Messages we benchmark with, about the size of a small JSON document
+var benchPayload = []byte(`{"user":"1234","name":"Ada Lovelace","email":"ada@example.com","active":true}`)
+
+// benchAccord starts an Accord in a data directory of its own for a benchmark, configured by configure if
+// it's given. It's stopped, and its directory removed, once the benchmark is done
+func benchAccord(b *testing.B, configure func(*Accord)) *Accord {
+	dir, err := ioutil.TempDir("", "accord-bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { os.RemoveAll(dir) })
+
+	accord := NewAccord(NewDummerManager(), nil, dir, DummyAccord().Logger)
+	if configure != nil {
+		configure(accord)
+	}
+	err = accord.Start()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(accord.Stop)
+	return accord
+}
+
+// benchOptions are the tuning options our benchmarks are compared across
+var benchOptions = []struct {
+	name      string
+	configure func(*Accord)
+}{
+	{"default", nil},
+	{"batched", func(accord *Accord) { accord.FlushInterval = 10 * time.Millisecond }},
+	{"sharded", func(accord *Accord) { accord.QueueShards = 4 }},
+	{"synced", func(accord *Accord) { accord.StateSyncMode = SyncAlways; accord.QueueSyncMode = SyncAlways }},
+}
+
+// benchRemoteMessage creates a Message as though it had been created by another node
+func benchRemoteMessage(b *testing.B) *Message {
+	msg, err := NewMessage(benchPayload)
+	if err != nil {
+		b.Fatal(err)
+	}
+	msg.Origin = "elsewhere"
+	return msg
+}
+
+func BenchmarkHandleNewMessage(b *testing.B) {
+	for _, option := range benchOptions {
+		b.Run(option.name, func(b *testing.B) {
+			accord := benchAccord(b, option.configure)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				msg, _ := NewMessage(benchPayload)
+				err := accord.HandleNewMessage(msg)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkHandleNewMessageParallel(b *testing.B) {
+	for _, option := range benchOptions {
+		b.Run(option.name, func(b *testing.B) {
+			accord := benchAccord(b, option.configure)
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					msg, _ := NewMessage(benchPayload)
+					err := accord.HandleNewMessage(msg)
+					if err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkHandleRemoteMessage(b *testing.B) {
+	for _, option := range benchOptions {
+		b.Run(option.name, func(b *testing.B) {
+			accord := benchAccord(b, option.configure)
+			msgs := make([]*Message, b.N)
+			for i := range msgs {
+				msgs[i] = benchRemoteMessage(b)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for _, msg := range msgs {
+				err := accord.HandleRemoteMessage(msg)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkQueueAppend measures our queue on its own, without the rest of handling a Message around it
+func BenchmarkQueueAppend(b *testing.B) {
+	accord := benchAccord(b, nil)
+	shard := accord.channelFor("").shards[0]
+	data, err := benchRemoteMessage(b).Serialize()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := shard.queue.Enqueue(data)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkQueueDequeue(b *testing.B) {
+	for _, size := range []struct {
+		name  string
+		batch int
+	}{{"single", 1}, {"batches", 100}} {
+		batch := size.batch
+		b.Run(size.name, func(b *testing.B) {
+			accord := benchAccord(b, nil)
+			cursor := accord.AddPeer("peer")
+			for i := 0; i < b.N; i++ {
+				msg, _ := NewMessage(benchPayload)
+				err := accord.HandleNewMessage(msg)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for sent := 0; sent < b.N; {
+				msgs, err := cursor.Peek(batch)
+				if err != nil {
+					b.Fatal(err)
+				}
+				err = cursor.Advance(len(msgs))
+				if err != nil {
+					b.Fatal(err)
+				}
+				sent += len(msgs)
+			}
+		})
+	}
+}
+
+func BenchmarkStateUpdate(b *testing.B) {
+	for _, batched := range []bool{false, true} {
+		name := "direct"
+		if batched {
+			name = "batched"
+		}
+		b.Run(name, func(b *testing.B) {
+			dir, err := ioutil.TempDir("", "accord-bench")
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			state, err := OpenState(path.Join(dir, StateFilename))
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer state.Close()
+			if batched {
+				state.startBatching(10*time.Millisecond, DefaultFlushCount, state.Flush)
+			}
+
+			// Every Message needs an ID of its own, whichever goroutine updates our state with it
+			id := uint64(0)
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					msg := &Message{ID: atomic.AddUint64(&id, 1), Origin: "elsewhere", Payload: benchPayload}
+					err := state.Update(msg)
+					if err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
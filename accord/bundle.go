@@ -0,0 +1,204 @@
+package accord
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// BundleOptions customizes what goes into a support bundle
+type BundleOptions struct {
+	// HistoryLimit is how many of the most recent history entries to include, defaulting to 100. Only
+	// digests of each Message are included, never their payloads
+	HistoryLimit int
+
+	// LogLocation tells whoever reads the bundle where to find our logs. If it's left empty we'll try to
+	// figure it out from our logger
+	LogLocation string
+
+	// Config is any additional application configuration that should be included in the bundle. It must
+	// be serializable as JSON; be careful not to include secrets
+	Config interface{}
+}
+
+// bundleHistoryEntry is what we include in a support bundle for each history entry
+type bundleHistoryEntry struct {
+	ID            uint64
+	Type          string
+	Key           string
+	Origin        string
+	Timestamp     time.Time
+	HLC           string
+	StateAt       uint64
+	PayloadSize   int
+	PayloadSHA256 string
+}
+
+// WriteSupportBundle writes a zip file describing this Accord process to w, so that everything needed to
+// look into a problem can be attached to a ticket as a single file. It includes a snapshot of our
+// configuration, a status report, our recent events, a summary of our state, digests of our most recent
+// history and a pointer to where our logs are
+func (accord *Accord) WriteSupportBundle(w io.Writer, opts BundleOptions) error {
+	if opts.HistoryLimit <= 0 {
+		opts.HistoryLimit = 100
+	}
+
+	archive := zip.NewWriter(w)
+
+	components := []string{}
+	accord.componentsMutex.Lock()
+	for _, comp := range accord.components {
+		components = append(components, fmt.Sprintf("%T", comp))
+	}
+	accord.componentsMutex.Unlock()
+	indexes := []string{}
+	for name := range accord.indexes {
+		indexes = append(indexes, name)
+	}
+	sort.Strings(indexes)
+	pipelines := []string{}
+	for msgType := range accord.pipelines {
+		pipelines = append(pipelines, msgType)
+	}
+	sort.Strings(pipelines)
+	handlers := map[string]string{}
+	for msgType, manager := range accord.typeManagers {
+		handlers[msgType] = fmt.Sprintf("%T", manager)
+	}
+
+	files := []struct {
+		name    string
+		content interface{}
+	}{
+		{"config.json", map[string]interface{}{
+			"nodeID":      accord.NodeID,
+			"dataDir":     accord.dataDir,
+			"manager":     fmt.Sprintf("%T", accord.manager),
+			"handlers":    handlers,
+			"components":  components,
+			"indexes":     indexes,
+			"pipelines":   pipelines,
+			"application": opts.Config,
+		}},
+		{"status.json", map[string]interface{}{
+			"generatedAt":   time.Now().UTC(),
+			"queueLength":   accord.QueuedMessages(),
+			"historyLength": accord.History().Len(),
+			"state":         accord.state.GetCurrent(),
+			"digestRoot":    accord.Digest().RootString(),
+			"leader":        accord.Leader(),
+		}},
+		{"events.json", accord.RecentEvents()},
+		{"state.json", accord.stateSummary()},
+	}
+
+	for _, file := range files {
+		err := writeBundleJSON(archive, file.name, file.content)
+		if err != nil {
+			return err
+		}
+	}
+
+	history := []bundleHistoryEntry{}
+	iter := accord.History().Query(HistoryFilter{Limit: opts.HistoryLimit})
+	for iter.Next() {
+		msg := iter.Message()
+		sum := sha256.Sum256(msg.Payload)
+		history = append(history, bundleHistoryEntry{
+			ID:            msg.ID,
+			Type:          msg.Type,
+			Key:           msg.Key,
+			Origin:        msg.Origin,
+			Timestamp:     msg.Timestamp,
+			HLC:           msg.HLC.String(),
+			StateAt:       msg.StateAt,
+			PayloadSize:   len(msg.Payload),
+			PayloadSHA256: hex.EncodeToString(sum[:]),
+		})
+	}
+	if iter.Err() != nil {
+		return iter.Err()
+	}
+	err := writeBundleJSON(archive, "history.json", history)
+	if err != nil {
+		return err
+	}
+
+	logs, err := archive.Create("logs.txt")
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(logs, accord.logLocation(opts.LogLocation)+"\n")
+	if err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
+
+// WriteSupportBundleFile is a convenience wrapper around WriteSupportBundle that writes the bundle to a
+// file at the given path
+func (accord *Accord) WriteSupportBundleFile(path string, opts BundleOptions) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	err = accord.WriteSupportBundle(file, opts)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// stateSummary describes our state in a way that's readable by a person
+func (accord *Accord) stateSummary() map[string]interface{} {
+	digest := accord.Digest()
+	buckets := map[string]interface{}{}
+	for i := 0; i < DigestBuckets; i++ {
+		if digest.Counts[i] > 0 {
+			buckets[fmt.Sprint(i)] = map[string]uint64{"sum": digest.Sums[i], "count": digest.Counts[i]}
+		}
+	}
+
+	return map[string]interface{}{
+		"current":       accord.state.GetCurrent(),
+		"clock":         accord.Clock(),
+		"digestRoot":    digest.RootString(),
+		"digestBuckets": buckets,
+	}
+}
+
+// logLocation describes where our logs are going
+func (accord *Accord) logLocation(override string) string {
+	if override != "" {
+		return override
+	}
+
+	if stringer, ok := accord.Logger.(fmt.Stringer); ok {
+		return stringer.String()
+	}
+	if accord.Logger == nil {
+		return "unknown"
+	}
+	return fmt.Sprintf("%T", accord.Logger)
+}
+
+func writeBundleJSON(archive *zip.Writer, name string, content interface{}) error {
+	file, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(content)
+}
@@ -0,0 +1,60 @@
+package accord
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteSupportBundle(t *testing.T) {
+	defer AccordCleanup()
+
+	accord := DummyAccord()
+	accord.NodeID = "local"
+	accord.Start()
+	defer accord.Stop()
+
+	for i := uint64(1); i <= 3; i++ {
+		assert.Nil(t, accord.HandleNewMessage(&Message{ID: i, Type: "test", Payload: []byte("secret")}))
+	}
+
+	var buf bytes.Buffer
+	err := accord.WriteSupportBundle(&buf, BundleOptions{HistoryLimit: 2, Config: map[string]string{"env": "test"}})
+	assert.Nil(t, err)
+
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.Nil(t, err)
+
+	files := map[string][]byte{}
+	for _, file := range archive.File {
+		reader, err := file.Open()
+		assert.Nil(t, err)
+		files[file.Name], err = ioutil.ReadAll(reader)
+		assert.Nil(t, err)
+		reader.Close()
+	}
+
+	for _, name := range []string{"config.json", "status.json", "events.json", "state.json", "history.json", "logs.txt"} {
+		assert.Contains(t, files, name)
+	}
+
+	config := map[string]interface{}{}
+	assert.Nil(t, json.Unmarshal(files["config.json"], &config))
+	assert.Equal(t, "local", config["nodeID"])
+	assert.Equal(t, map[string]interface{}{"env": "test"}, config["application"])
+
+	history := []bundleHistoryEntry{}
+	assert.Nil(t, json.Unmarshal(files["history.json"], &history))
+	assert.Len(t, history, 2)
+	assert.Equal(t, uint64(3), history[0].ID)
+	assert.Equal(t, 6, history[0].PayloadSize)
+	assert.NotContains(t, string(files["history.json"]), "secret")
+
+	events := []Event{}
+	assert.Nil(t, json.Unmarshal(files["events.json"], &events))
+	assert.Equal(t, EventStarted, events[0].Kind)
+}
@@ -0,0 +1,214 @@
+package accord
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/beeker1121/goque"
+)
+
+// ChannelsDirname is the directory inside our data directory where each named channel keeps its queue
+const ChannelsDirname = "channels"
+
+// ErrUnknownChannel is returned for a Message sent on a channel that isn't one of our Channels
+var ErrUnknownChannel = errors.New("no channel with that name has been configured")
+
+// channelNamePattern is what a channel's name can look like. Names end up as directory names, so we're
+// strict about them
+var channelNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// channelSeparator separates a peer's name from a channel's in the keys of our peers' cursors. Cursors in
+// the default channel are keyed by the peer's name alone, which keeps them where they always were
+const channelSeparator = "\x00"
+
+// channel is one of our synchronization queues, split across its shards, along with the Manager that
+// performs its Messages. The default channel (named "") is our original syncQueue
+type channel struct {
+	name    string
+	manager Manager
+	shards  []*shard
+
+	// bytes is (atomically) the size of the Messages in the channel's queue
+	bytes int64
+}
+
+// openChannels opens the queue of every one of our Channels, alongside the default one in syncQueue
+func (accord *Accord) openChannels() error {
+	atomic.StoreInt64(&accord.queuedBytes, 0)
+	defaultChannel := &channel{name: "", manager: accord.manager}
+	accord.channels = map[string]*channel{"": defaultChannel}
+	err := accord.openShards(defaultChannel, path.Join(accord.dataDir, SyncFilename))
+	if err != nil {
+		return err
+	}
+	accord.syncQueue = defaultChannel.shards[0].queue
+
+	for name, manager := range accord.Channels {
+		if !channelNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid channel name %q", name)
+		}
+		if manager == nil {
+			manager = accord.manager
+		}
+
+		dir := path.Join(accord.dataDir, ChannelsDirname)
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			return err
+		}
+		ch := &channel{name: name, manager: manager}
+		accord.channels[name] = ch
+		err = accord.openShards(ch, path.Join(dir, name+".queue"))
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, ch := range accord.channels {
+		for _, shard := range ch.shards {
+			err := accord.countQueuedBytes(shard)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// closeChannels closes the queues opened by openChannels
+func (accord *Accord) closeChannels() {
+	for _, ch := range accord.channels {
+		for _, shard := range ch.shards {
+			shard.queue.Close()
+		}
+	}
+}
+
+// channelFor returns the channel with the given name, or nil if it isn't one of ours
+func (accord *Accord) channelFor(name string) *channel {
+	return accord.channels[name]
+}
+
+// sortedChannels returns our channels in a stable order, the default one first
+func (accord *Accord) sortedChannels() []*channel {
+	channels := []*channel{}
+	for _, ch := range accord.channels {
+		channels = append(channels, ch)
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i].name < channels[j].name })
+	return channels
+}
+
+// ChannelNames returns the names of our Channels, sorted. The default channel isn't included
+func (accord *Accord) ChannelNames() []string {
+	names := []string{}
+	for name := range accord.channels {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ChannelQueue returns a read-only view over the Messages waiting to be synchronized on a channel, or nil
+// if it isn't one of ours. The default channel's is the same as Queue's
+func (accord *Accord) ChannelQueue(name string) *Queue {
+	ch := accord.channelFor(name)
+	if ch == nil {
+		return nil
+	}
+	return ch.view(accord.sealer)
+}
+
+// view returns a read-only view over the channel's queue, decrypting its Messages with s
+func (ch *channel) view(s *sealer) *Queue {
+	queues := []*goque.Queue{}
+	for _, shard := range ch.shards {
+		queues = append(queues, shard.queue)
+	}
+	return &Queue{queues: queues, sealer: s}
+}
+
+// managerFor returns the Manager that performs a Message: the one registered for its Type if there is one
+// (see Handle), otherwise the one of the channel it was sent on
+func (accord *Accord) managerFor(msg *Message) Manager {
+	if manager, ok := accord.typeManagers[msg.Type]; ok {
+		return manager
+	}
+
+	manager := accord.manager
+	if ch := accord.channelFor(msg.Channel); ch != nil {
+		manager = ch.manager
+	}
+	if manager == nil {
+		return unhandledManager{}
+	}
+	return manager
+}
+
+// Channel returns the peer's cursor into one of our other channels, or nil if it isn't one of ours. Each
+// channel has a queue of its own, so a peer works its way through each of them independently: a backlog on
+// one doesn't hold up the others. The default channel is named ""
+func (cursor *PeerCursor) Channel(name string) *PeerCursor {
+	if cursor.accord.channelFor(name) == nil {
+		return nil
+	}
+	return &PeerCursor{name: cursor.name, channel: name, accord: cursor.accord}
+}
+
+// Pending returns roughly how many queued Messages the peer still has to be sent on the cursor's channel,
+// across all of its shards
+func (cursor *PeerCursor) Pending() uint64 {
+	total := uint64(0)
+	for _, shard := range cursor.Shards() {
+		total += shard.pending()
+	}
+	return total
+}
+
+// ChannelName returns the name of the channel the cursor is into
+func (cursor *PeerCursor) ChannelName() string {
+	return cursor.channel
+}
+
+// key is where the cursor's position is kept amongst our peers' cursors
+func (cursor *PeerCursor) key() string {
+	return cursorKey(cursor.name, cursor.channel, cursor.shard)
+}
+
+// cursorKey returns the key of a peer's cursor into a shard of a channel
+func cursorKey(peer string, channel string, shard int) string {
+	if shard > 0 {
+		return peer + channelSeparator + channel + channelSeparator + strconv.Itoa(shard)
+	}
+	if channel == "" {
+		return peer
+	}
+	return peer + channelSeparator + channel
+}
+
+// splitCursorKey splits the key of a cursor back into the peer's name, the channel's and the shard's index
+func splitCursorKey(key string) (peer string, channel string, shard int) {
+	parts := strings.SplitN(key, channelSeparator, 3)
+	if len(parts) == 3 {
+		shard, _ = strconv.Atoi(parts[2])
+	}
+	if len(parts) == 1 {
+		return key, "", 0
+	}
+	return parts[0], parts[1], shard
+}
+
+// addQueuedBytes keeps track of a change in size of a channel's queue, as well as of all of them together
+func (accord *Accord) addQueuedBytes(ch *channel, delta int64) {
+	atomic.AddInt64(&ch.bytes, delta)
+	atomic.AddInt64(&accord.queuedBytes, delta)
+}
@@ -0,0 +1,95 @@
+package accord
+
+import (
+	"testing"
+
+	"github.com/beeker1121/goque"
+	"github.com/stretchr/testify/assert"
+)
+
+type countingManager struct {
+	processed int
+}
+
+func (manager *countingManager) Process(msg *Message, fromRemote bool) error {
+	manager.processed++
+	return nil
+}
+
+func (manager *countingManager) ShouldProcess(msg Message, history *goque.Stack) bool {
+	return true
+}
+
+func channelMessage(channel string) *Message {
+	msg, _ := NewMessage([]byte(channel))
+	msg.Channel = channel
+	return msg
+}
+
+func TestChannels(t *testing.T) {
+	defer AccordCleanup()
+	telemetry := &countingManager{}
+	accord := DummyAccord()
+	accord.Channels = map[string]Manager{"telemetry": telemetry, "config": nil}
+	accord.MaxQueueLength = 3
+	assert.Nil(t, accord.Start())
+
+	assert.Equal(t, []string{"config", "telemetry"}, accord.ChannelNames())
+	edge := accord.AddPeer("edge")
+	assert.Equal(t, []string{"edge"}, accord.Peers())
+
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, accord.HandleNewMessage(channelMessage("telemetry")))
+	}
+	assert.Equal(t, 3, telemetry.processed)
+
+	// A full channel doesn't stop the others from taking Messages
+	assert.Equal(t, ErrQueueFull, accord.HandleNewMessage(channelMessage("telemetry")))
+	assert.Nil(t, accord.HandleNewMessage(channelMessage("")))
+	assert.Nil(t, accord.HandleNewMessage(channelMessage("config")))
+	assert.Equal(t, uint64(1), accord.Queue().Len())
+	assert.Equal(t, uint64(3), accord.ChannelQueue("telemetry").Len())
+	assert.Equal(t, uint64(5), accord.QueuedMessages())
+
+	// Every channel is worked through separately
+	msgs, err := edge.Peek(10)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(msgs))
+	telemetryCursor := edge.Channel("telemetry")
+	msgs, err = telemetryCursor.Peek(10)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(msgs))
+	assert.Equal(t, "telemetry", msgs[0].Channel)
+	assert.Nil(t, telemetryCursor.Advance(3))
+	assert.Equal(t, uint64(0), accord.ChannelQueue("telemetry").Len())
+	lag, err := accord.PeerLag("edge")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), lag)
+
+	assert.Equal(t, ErrUnknownChannel, accord.HandleNewMessage(channelMessage("metrics")))
+	assert.Nil(t, edge.Channel("metrics"))
+	assert.Nil(t, accord.ChannelQueue("metrics"))
+
+	// Remote Messages on a channel we don't have are rejected, but still count as delivered
+	remote := channelMessage("metrics")
+	remote.Origin = "remote"
+	remote.Clock = VectorClock{"remote": 1}
+	_, rejected := accord.HandleRemoteMessage(remote).(*StageError)
+	assert.True(t, rejected)
+	assert.Equal(t, uint64(1), accord.state.Delivered("remote"))
+	assert.Equal(t, uint64(5), accord.History().Len())
+
+	// Queues and cursors survive a restart
+	accord.Stop()
+	accord = DummyAccord()
+	accord.Channels = map[string]Manager{"telemetry": telemetry, "config": nil}
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	assert.Equal(t, uint64(1), accord.ChannelQueue("config").Len())
+	edge = accord.AddPeer("edge")
+	assert.Equal(t, uint64(1), edge.Channel("config").Pending())
+	assert.Equal(t, uint64(0), edge.Channel("telemetry").Pending())
+	assert.Nil(t, accord.RemovePeer("edge"))
+	assert.Nil(t, accord.Peer("edge"))
+}
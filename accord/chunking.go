@@ -0,0 +1,345 @@
+package accord
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/beeker1121/goque"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// ChunkType is the Type of the Messages a large Message is split into to be synchronized (see ChunkSize)
+const ChunkType = "accord.chunk"
+
+// The headers of a chunk, saying which Message it's a part of (by ID), which part it is (counting from 0)
+// and how many parts there are
+const (
+	ChunkOfHeader    = "chunk-of"
+	ChunkIndexHeader = "chunk-index"
+	ChunkCountHeader = "chunk-count"
+)
+
+// chunkPrefix is where the chunks we've received are kept until the rest of their Message arrives. Each
+// is kept behind when it arrived (as big endian Unix nanoseconds), so that sweepChunks can tell how long
+// its Message has been waiting
+const chunkPrefix = "chunk/"
+
+// DefaultMaxChunkedSize is how large a Message we put back together from chunks can be by default (see
+// Accord.MaxChunkedSize)
+const DefaultMaxChunkedSize = 64 << 20
+
+// DefaultChunkTTL is how long we hold on to the chunks of a Message by default (see Accord.ChunkTTL)
+const DefaultChunkTTL = time.Hour
+
+// EventChunksExpired is emitted when we give up waiting for the rest of one or more chunked Messages
+const EventChunksExpired = "chunks_expired"
+
+// chunkID is the ID of a Message's chunk, derived from the Message's ID so that a chunk queued again (when
+// the Message is relayed, say) is the same chunk
+func chunkID(of uint64, index int) uint64 {
+	var buf [12]byte
+	binary.BigEndian.PutUint64(buf[:8], of)
+	binary.BigEndian.PutUint32(buf[8:], uint32(index))
+	hash := sha256.Sum256(buf[:])
+	return binary.LittleEndian.Uint64(hash[:])
+}
+
+// chunkKey is where a chunk of the Message with the given ID is kept. Chunks of the same Message sort by
+// their index
+func chunkKey(of uint64, index int) []byte {
+	key := make([]byte, len(chunkPrefix)+12)
+	copy(key, chunkPrefix)
+	binary.BigEndian.PutUint64(key[len(chunkPrefix):], of)
+	binary.BigEndian.PutUint32(key[len(chunkPrefix)+8:], uint32(index))
+	return key
+}
+
+// chunks splits a Message whose Payload is larger than our ChunkSize into the chunks it's synchronized as,
+// returning nil for any other Message. Each chunk carries ChunkSize bytes of the serialized Message, along
+// with its Origin, Channel, Key and clocks, so that it's routed, sharded and checked for duplicates just
+// like the Message would be
+func (accord *Accord) chunks(msg *Message) ([]*Message, error) {
+	size := accord.ChunkSize
+	if size <= 0 || len(msg.Payload) <= size {
+		return nil, nil
+	}
+
+	data, err := msg.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	count := (len(data) + size - 1) / size
+	chunks := make([]*Message, 0, count)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * size
+		if end > len(data) {
+			end = len(data)
+		}
+		chunks = append(chunks, &Message{
+			ID:        chunkID(msg.ID, i),
+			Timestamp: msg.Timestamp,
+			Type:      ChunkType,
+			Origin:    msg.Origin,
+			Key:       msg.Key,
+			KeySeq:    msg.KeySeq,
+			Channel:   msg.Channel,
+			Clock:     msg.Clock,
+			HLC:       msg.HLC,
+			Headers: map[string]string{
+				ChunkOfHeader:    strconv.FormatUint(msg.ID, 10),
+				ChunkIndexHeader: strconv.Itoa(i),
+				ChunkCountHeader: strconv.Itoa(count),
+			},
+			Payload: data[i*size : end],
+		})
+	}
+	return chunks, nil
+}
+
+// enqueueMessage is enqueue for a Message that may need to be split into chunks (see ChunkSize). data is
+// the Message as it's stored, which is queued as is when it doesn't. The item returned is the Message's
+// last chunk
+func (accord *Accord) enqueueMessage(shard *shard, msg *Message, data []byte) (*goque.Item, error) {
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	return accord.enqueueMessageLocked(shard, msg, data)
+}
+
+// enqueueMessageLocked is enqueueMessage for a caller that already holds the shard's mutex
+func (accord *Accord) enqueueMessageLocked(shard *shard, msg *Message, data []byte) (*goque.Item, error) {
+	chunks, err := accord.chunks(msg)
+	if err != nil {
+		return nil, err
+	}
+	if chunks == nil {
+		return accord.enqueueLocked(shard, data)
+	}
+
+	WithMessage(accord.Logger, msg).WithField("chunks", len(chunks)).Debug("Splitting a large message into chunks to synchronize it")
+	var item *goque.Item
+	for _, chunk := range chunks {
+		data, err := accord.sealer.encodeMessage(chunk)
+		if err != nil {
+			return nil, err
+		}
+		item, err = accord.enqueueLocked(shard, data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return item, nil
+}
+
+// maxChunkedSize returns how large a Message we'll put back together from chunks can be
+func (accord *Accord) maxChunkedSize() int {
+	if accord.MaxChunkedSize <= 0 {
+		return DefaultMaxChunkedSize
+	}
+	return accord.MaxChunkedSize
+}
+
+// chunkTTL returns how long we hold on to the chunks of a Message waiting for the rest of them, or 0 for
+// forever
+func (accord *Accord) chunkTTL() time.Duration {
+	if accord.ChunkTTL < 0 {
+		return 0
+	}
+	if accord.ChunkTTL == 0 {
+		return DefaultChunkTTL
+	}
+	return accord.ChunkTTL
+}
+
+// chunkHeaders reads which Message a chunk is part of, which part it is and how many parts there are
+func chunkHeaders(msg *Message) (of uint64, index int, count int, err error) {
+	of, err = strconv.ParseUint(msg.Headers[ChunkOfHeader], 10, 64)
+	if err == nil {
+		index, err = strconv.Atoi(msg.Headers[ChunkIndexHeader])
+	}
+	if err == nil {
+		count, err = strconv.Atoi(msg.Headers[ChunkCountHeader])
+	}
+	if err == nil && (index < 0 || count <= index) {
+		err = fmt.Errorf("chunk %d of %d is out of range", index, count)
+	}
+	return of, index, count, err
+}
+
+// receiveChunk holds on to a remote chunk until every chunk of its Message has arrived, at which point the
+// Message is put back together and returned. Until then it returns nil, as it does for a chunk of a
+// Message we've already delivered. The chunks are kept until forgetChunks is called, so that a Message
+// that can't be handled yet can be put back together again when its last chunk is sent again, or until
+// sweepChunks gives up on them. Chunks of a Message that would be larger than our MaxChunkedSize are
+// rejected, along with any we were already holding on to for it. The caller must hold processMutex
+func (accord *Accord) receiveChunk(msg *Message) (*Message, error) {
+	log := WithMessage(accord.Logger, msg)
+	of, index, count, err := chunkHeaders(msg)
+	// Every chunk but the last is the same size, so we can tell a Message is going to be too large before
+	// we've seen all of it, however many chunks it claims to have
+	max := accord.maxChunkedSize()
+	if err == nil && (count > max || len(msg.Payload) > max || (index < count-1 && len(msg.Payload)*(count-1) > max)) {
+		err = fmt.Errorf("a message in %d chunks of %d bytes is larger than %d bytes", count, len(msg.Payload), max)
+	}
+	if err != nil {
+		log.WithError(err).Warn("Rejecting a malformed chunk")
+		return nil, &StageError{Stage: "chunk", Err: err}
+	}
+
+	check, err := accord.checkOrder(msg)
+	if err != nil {
+		return nil, err
+	}
+	if check == orderDuplicate {
+		accord.SampledDebug(log, "Dropping a chunk of a message that was already delivered")
+		return nil, nil
+	}
+
+	data, err := accord.sealer.seal(msg.Payload)
+	if err != nil {
+		return nil, err
+	}
+	value := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint64(value, uint64(time.Now().UnixNano()))
+	err = accord.state.db.Put(chunkKey(of, index), append(value, data...), accord.state.writeOptions)
+	if err != nil {
+		return nil, accord.failWrite(err, "We could not store a chunk of a remote message")
+	}
+
+	parts := [][]byte{}
+	size := 0
+	iter := accord.state.db.NewIterator(util.BytesPrefix(chunkKey(of, 0)[:len(chunkPrefix)+8]), nil)
+	for iter.Next() {
+		parts = append(parts, append([]byte{}, iter.Value()[8:]...))
+		size += len(iter.Value()) - 8
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	if size > max {
+		err = fmt.Errorf("the chunks of message %d add up to more than %d bytes", of, max)
+		log.WithError(err).Warn("Rejecting the chunks of a message that's too large")
+		accord.forgetChunks(of)
+		return nil, &StageError{Stage: "chunk", Err: err}
+	}
+	if len(parts) < count {
+		accord.SampledDebug(log.WithField("chunks", len(parts)).WithField("of", count), "Holding on to a chunk until the rest of its message arrives")
+		return nil, nil
+	}
+
+	whole := []byte{}
+	for _, part := range parts {
+		part, err = accord.sealer.open(part)
+		if err != nil {
+			return nil, err
+		}
+		whole = append(whole, part...)
+	}
+	reassembled, err := DeserializeMessage(whole)
+	if err == nil && reassembled.ID != of {
+		err = errors.New("the chunks make up a different message")
+	}
+	if err != nil {
+		log.WithError(err).Warn("Rejecting chunks that don't make up a message")
+		accord.forgetChunks(of)
+		return nil, &StageError{Stage: "chunk", Err: err}
+	}
+	return reassembled, nil
+}
+
+// forgetChunks drops the chunks we were holding on to for a Message, once it's been handled
+func (accord *Accord) forgetChunks(of uint64) {
+	batch := new(leveldb.Batch)
+	iter := accord.state.db.NewIterator(util.BytesPrefix(chunkKey(of, 0)[:len(chunkPrefix)+8]), nil)
+	for iter.Next() {
+		batch.Delete(append([]byte{}, iter.Key()...))
+	}
+	iter.Release()
+	err := accord.state.db.Write(batch, accord.state.writeOptions)
+	if err != nil {
+		accord.Logger.WithError(err).WithField("id", of).Warn("We could not drop the chunks of a message")
+	}
+}
+
+// watchChunks sweeps away the chunks of Messages that have been waiting too long for the rest of them
+// until we stop
+func (accord *Accord) watchChunks() {
+	ttl := accord.chunkTTL()
+	if ttl == 0 {
+		return
+	}
+	stopped := accord.stopContext.Done()
+	go func() {
+		ticker := time.NewTicker(ttl / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				accord.sweepChunks(time.Now())
+			case <-stopped:
+				return
+			}
+		}
+	}()
+}
+
+// sweepChunks drops the chunks of every Message that hasn't had a chunk arrive in over our ChunkTTL as of
+// now, on the basis that the rest of it is never going to: its sender lost it, or it was never a real
+// Message in the first place. Otherwise they'd sit in our state forever. It returns how many Messages'
+// chunks were dropped
+func (accord *Accord) sweepChunks(now time.Time) int {
+	ttl := accord.chunkTTL()
+	if ttl == 0 {
+		return 0
+	}
+	accord.processMutex.Lock()
+	defer accord.processMutex.Unlock()
+
+	// Chunks of the same Message sit next to each other, so we only need to remember the latest arrival
+	// of the Message we're currently looking at
+	expired := []uint64{}
+	var current uint64
+	var latest int64
+	seen := false
+	check := func() {
+		if seen && now.Sub(time.Unix(0, latest)) > ttl {
+			expired = append(expired, current)
+		}
+	}
+	iter := accord.state.db.NewIterator(util.BytesPrefix([]byte(chunkPrefix)), nil)
+	for iter.Next() {
+		key, value := iter.Key(), iter.Value()
+		if len(key) != len(chunkPrefix)+12 || len(value) < 8 {
+			continue
+		}
+		of := binary.BigEndian.Uint64(key[len(chunkPrefix):])
+		if !seen || of != current {
+			check()
+			current, latest, seen = of, 0, true
+		}
+		if arrived := int64(binary.BigEndian.Uint64(value)); arrived > latest {
+			latest = arrived
+		}
+	}
+	check()
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		accord.Logger.WithError(err).Warn("Unable to look through the chunks we're holding on to")
+		return 0
+	}
+
+	for _, of := range expired {
+		accord.forgetChunks(of)
+	}
+	if len(expired) > 0 {
+		accord.Logger.WithField("messages", len(expired)).WithField("ttl", ttl).Warn("Giving up on the rest of the chunks of messages that stopped arriving")
+		accord.metrics().Count(MetricChunksExpired, int64(len(expired)))
+		accord.Emit(EventChunksExpired, "Gave up waiting for the rest of chunked messages", map[string]interface{}{"messages": len(expired), "ids": expired})
+	}
+	return len(expired)
+}
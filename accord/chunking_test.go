@@ -0,0 +1,141 @@
+package accord
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+func TestChunking(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	accord.ChunkSize = 256
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	// Small Messages are queued as they are
+	assert.Nil(t, accord.HandleNewMessage(&Message{ID: 1, Payload: []byte("small")}))
+	assert.Equal(t, uint64(1), accord.Queue().Len())
+
+	// Large ones are queued in chunks, but recorded in our history whole
+	payload := bytes.Repeat([]byte("0123456789"), 100)
+	assert.Nil(t, accord.HandleNewMessage(&Message{ID: 2, Key: "large", Payload: payload}))
+	msg, err := accord.History().Get(0)
+	assert.Nil(t, err)
+	assert.Equal(t, payload, msg.Payload)
+	queued := accord.Queue().Len() - 1
+	assert.True(t, queued > 3)
+	for i := uint64(0); i < queued; i++ {
+		chunk, err := accord.Queue().Get(1 + i)
+		assert.Nil(t, err)
+		assert.Equal(t, ChunkType, chunk.Type)
+		assert.Equal(t, accord.NodeID, chunk.Origin)
+		assert.Equal(t, "large", chunk.Key)
+		assert.True(t, len(chunk.Payload) <= 256)
+	}
+}
+
+func TestChunkReassembly(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	assert.Nil(t, accord.Start())
+	defer func() { accord.Stop() }()
+
+	remote := &Message{ID: 7, Origin: "remote", Type: "upload", Payload: bytes.Repeat([]byte("abcdefgh"), 200)}
+	accord.ChunkSize = 256
+	chunks, err := accord.chunks(remote)
+	assert.Nil(t, err)
+	assert.True(t, len(chunks) > 3)
+
+	// Chunks can arrive in any order, and nothing is handled until all of them have
+	last := len(chunks) - 1
+	assert.Nil(t, accord.HandleRemoteMessage(chunks[last]))
+	for _, chunk := range chunks[1 : last-1] {
+		assert.Nil(t, accord.HandleRemoteMessage(chunk))
+	}
+	assert.Nil(t, accord.HandleRemoteMessage(chunks[0]))
+	assert.Equal(t, uint64(0), accord.History().Len())
+
+	// The chunks we're holding on to survive a restart
+	accord.Stop()
+	accord = DummyAccord()
+	assert.Nil(t, accord.Start())
+	assert.Nil(t, accord.HandleRemoteMessage(chunks[last-1]))
+	assert.Equal(t, uint64(1), accord.History().Len())
+	msg, err := accord.History().Get(0)
+	assert.Nil(t, err)
+	assert.Equal(t, remote.ID, msg.ID)
+	assert.Equal(t, "upload", msg.Type)
+	assert.Equal(t, remote.Payload, msg.Payload)
+
+	// Its chunks were dropped once it was handled, so one sent again on its own doesn't bring it back
+	assert.Nil(t, accord.HandleRemoteMessage(chunks[0]))
+	assert.Equal(t, uint64(1), accord.History().Len())
+
+	// Chunks that don't make up a Message are rejected
+	chunk := *chunks[0]
+	chunk.Headers = map[string]string{ChunkOfHeader: "7", ChunkIndexHeader: "2", ChunkCountHeader: "2"}
+	assert.IsType(t, &StageError{}, accord.HandleRemoteMessage(&chunk))
+	chunk.Headers = map[string]string{ChunkOfHeader: "8", ChunkIndexHeader: "0", ChunkCountHeader: "1"}
+	assert.IsType(t, &StageError{}, accord.HandleRemoteMessage(&chunk))
+	assert.Equal(t, uint64(1), accord.History().Len())
+}
+
+func TestChunkLimits(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	accord.MaxChunkedSize = 1000
+	accord.ChunkTTL = time.Minute
+	expired := []Event{}
+	accord.Subscribe(func(event Event) {
+		if event.Kind == EventChunksExpired {
+			expired = append(expired, event)
+		}
+	})
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	chunk := func(of uint64, index int, count string, size int) *Message {
+		return &Message{ID: chunkID(of, index), Origin: "remote", Type: ChunkType, Payload: make([]byte, size), Headers: map[string]string{
+			ChunkOfHeader: strconv.FormatUint(of, 10), ChunkIndexHeader: strconv.Itoa(index), ChunkCountHeader: count,
+		}}
+	}
+	held := func() int {
+		count := 0
+		iter := accord.state.db.NewIterator(util.BytesPrefix([]byte(chunkPrefix)), nil)
+		for iter.Next() {
+			count++
+		}
+		iter.Release()
+		return count
+	}
+
+	// However many chunks a peer claims a Message has, it can't be larger than our MaxChunkedSize
+	assert.IsType(t, &StageError{}, accord.HandleRemoteMessage(chunk(1, 0, "18446744073709551615", 10)))
+	assert.IsType(t, &StageError{}, accord.HandleRemoteMessage(chunk(1, 0, "5000", 1)))
+	assert.IsType(t, &StageError{}, accord.HandleRemoteMessage(chunk(1, 0, "3", 600)))
+	assert.IsType(t, &StageError{}, accord.HandleRemoteMessage(chunk(1, 1, "2", 1001)))
+	assert.Equal(t, 0, held())
+
+	// Nor can chunks that lie about their size add up to more than it
+	assert.Nil(t, accord.HandleRemoteMessage(chunk(2, 0, "3", 400)))
+	assert.Nil(t, accord.HandleRemoteMessage(chunk(2, 2, "3", 400)))
+	assert.Equal(t, 2, held())
+	assert.IsType(t, &StageError{}, accord.HandleRemoteMessage(chunk(2, 1, "3", 400)))
+	assert.Equal(t, 0, held())
+
+	// Chunks whose Message stops arriving are dropped once they've waited longer than our ChunkTTL
+	assert.Nil(t, accord.HandleRemoteMessage(chunk(3, 0, "3", 10)))
+	assert.Nil(t, accord.HandleRemoteMessage(chunk(4, 0, "3", 10)))
+	assert.Equal(t, 0, accord.sweepChunks(time.Now()))
+	assert.Equal(t, 2, held())
+	assert.Equal(t, 2, accord.sweepChunks(time.Now().Add(2*time.Minute)))
+	assert.Equal(t, 0, held())
+	if assert.Len(t, expired, 1) {
+		assert.Equal(t, 2, expired[0].Fields["messages"])
+	}
+}
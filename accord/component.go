@@ -2,8 +2,8 @@ package accord
 
 import (
 	"sync"
-
-	"github.com/sirupsen/logrus"
+	"sync/atomic"
+	"time"
 )
 
 // A Component is a background process that Accord is responsible for handling. Generally they are used for listening on a port
@@ -37,9 +37,15 @@ type ComponentRunner struct {
 	stopping bool
 
 	// Allow users of ComponentRunner to specify custom fields to be logged
-	log *logrus.Entry
+	log Logger
+
+	// paused is set (atomically) while the tick function shouldn't be called (see Pause)
+	paused int32
 }
 
+// pausedPollInterval is how often a paused ComponentRunner checks whether it has been resumed or stopped
+const pausedPollInterval = 50 * time.Millisecond
+
 // Init takes a pointer reference to an accord struct and two functions which can make use of it.
 // The 'tick' function will be called in an infinite loop in a goroutine, care should be given to this
 // function to make sure it it plays fair with system resources (if left unchecked it will run unbound
@@ -53,7 +59,7 @@ type ComponentRunner struct {
 //
 // This function should generally be called as part of the embedding struct's Start function to get the
 // process running
-func (runner *ComponentRunner) Init(accord *Accord, tick func(*Accord), cleanup func(*Accord), log *logrus.Entry) {
+func (runner *ComponentRunner) Init(accord *Accord, tick func(*Accord), cleanup func(*Accord), log Logger) {
 
 	// We're currently only writing for cases where the runner is started and stopped once in an application
 	// (start on app init, stopped on app close), but should we consider the case of somebody starting and
@@ -65,13 +71,23 @@ func (runner *ComponentRunner) Init(accord *Accord, tick func(*Accord), cleanup
 	if log != nil {
 		runner.log = log
 	} else {
-		runner.log = accord.Logger.WithFields(logrus.Fields{})
+		runner.log = accord.Logger
 	}
 
 	// All the real work that ComponentRunner does happens in a goroutine, this Init function is only
 	// responsible for initializing the variables and starting it
 	go func() {
 
+		// A tick that panics leaves the Component in who knows what state, so rather than carry on we stop
+		// the loop and report the failure to our supervisor (see RestartPolicy). That has to happen last,
+		// once we've been marked as stopped, as the supervisor waits for us to stop
+		var failure *PanicError
+		defer func() {
+			if failure != nil {
+				accord.componentFailed(runner, failure)
+			}
+		}()
+
 		// Before this goroutine returns we need to set our internal state and broadcast out to our conditional
 		// variable to make anybody waiting wake up
 		defer func() {
@@ -96,19 +112,57 @@ func (runner *ComponentRunner) Init(accord *Accord, tick func(*Accord), cleanup
 			select {
 			case <-runner.stopSignal:
 				runner.log.Info("Received stop signal")
-				if cleanup != nil {
-					runner.log.Info("Cleaning up")
-					cleanup(accord)
-				}
+				runner.cleanup(accord, cleanup)
 				return
 
 			default:
-				tick(accord)
+				if atomic.LoadInt32(&runner.paused) == 1 {
+					time.Sleep(pausedPollInterval)
+					continue
+				}
+				err := runner.tick(accord, tick)
+				if err != nil {
+					failure = err.(*PanicError)
+					runner.log.WithError(err).WithField("stack", string(failure.Stack)).Error("Component panicked, stopping it")
+					accord.Emit(EventPanic, "A component panicked", map[string]interface{}{"where": failure.Where, "error": err.Error()})
+					runner.cleanup(accord, cleanup)
+					return
+				}
 			}
 		}
 	}()
 }
 
+// componentRunner lets our supervisor find which Component a runner is embedded in
+func (runner *ComponentRunner) componentRunner() *ComponentRunner {
+	return runner
+}
+
+// tick calls the tick function, recovering from any panic
+func (runner *ComponentRunner) tick(accord *Accord, tick func(*Accord)) (err error) {
+	defer recoverPanic("component tick", &err)
+	tick(accord)
+	return nil
+}
+
+// cleanup calls the cleanup function if there is one. A panic there is only logged, as we're on our way
+// out anyway
+func (runner *ComponentRunner) cleanup(accord *Accord, cleanup func(*Accord)) {
+	if cleanup == nil {
+		return
+	}
+
+	runner.log.Info("Cleaning up")
+	err := func() (err error) {
+		defer recoverPanic("component cleanup", &err)
+		cleanup(accord)
+		return nil
+	}()
+	if err != nil {
+		runner.log.WithError(err).WithField("stack", string(err.(*PanicError).Stack)).Error("Component panicked while cleaning up")
+	}
+}
+
 // Stop implements Component's Stop method. Upon being called it will send a message to the running goroutine
 // that it should start shutting down. This function returns immediately but does *not* ensure that the thread
 // is actually stopped when it returns
@@ -135,3 +189,33 @@ func (runner *ComponentRunner) WaitForStop() {
 	runner.doneSignal.L.Unlock()
 	runner.log.Info("Component stopped")
 }
+
+// Pause stops the tick function from being called until Resume is called, without stopping the goroutine.
+// A tick that's already running is allowed to finish. Stopping a paused runner works as usual
+func (runner *ComponentRunner) Pause() {
+	if atomic.CompareAndSwapInt32(&runner.paused, 0, 1) && runner.log != nil {
+		runner.log.Info("Pausing component")
+	}
+}
+
+// Resume picks back up after Pause
+func (runner *ComponentRunner) Resume() {
+	if atomic.CompareAndSwapInt32(&runner.paused, 1, 0) && runner.log != nil {
+		runner.log.Info("Resuming component")
+	}
+}
+
+// Paused reports whether the runner has been paused
+func (runner *ComponentRunner) Paused() bool {
+	return atomic.LoadInt32(&runner.paused) == 1
+}
+
+// Running reports whether the runner's goroutine has been started and hasn't stopped yet
+func (runner *ComponentRunner) Running() bool {
+	if runner.doneSignal == nil {
+		return false
+	}
+	runner.doneSignal.L.Lock()
+	defer runner.doneSignal.L.Unlock()
+	return !runner.stopped
+}
@@ -0,0 +1,88 @@
+package accord
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrDependencyCycle is returned by Start when our Components' dependencies go round in a circle
+var ErrDependencyCycle = errors.New("the components' dependencies form a cycle")
+
+// DependentComponent can be implemented by Components that need others to be started before them (and
+// stopped after them), like a transport that relies on a discovery Component. Dependencies are named the
+// way they're listed in Components
+type DependentComponent interface {
+	Component
+	DependsOn() []string
+}
+
+// PhasedComponent can be implemented by Components that should be started in a particular phase: lower
+// phases are started first and stopped last. Components that don't implement it are in phase 0.
+// Dependencies always win over phases
+type PhasedComponent interface {
+	Component
+	StartPhase() int
+}
+
+// orderComponents works out the order our Components should be started in: every Component comes after
+// the ones it depends on, and otherwise they're ordered by phase and then by the order they were passed
+// in. The caller must hold componentsMutex
+func (accord *Accord) orderComponents() ([]Component, error) {
+	byName, names := accord.namedComponents()
+	index := map[string]int{}
+	for i, name := range names {
+		index[name] = i
+	}
+
+	// waitingOn counts how many unstarted dependencies each Component has, and dependents lists who's
+	// waiting on each of them
+	waitingOn := make([]int, len(names))
+	dependents := make([][]int, len(names))
+	for i, name := range names {
+		dependent, ok := byName[name].(DependentComponent)
+		if !ok {
+			continue
+		}
+		for _, dependency := range dependent.DependsOn() {
+			j, ok := index[dependency]
+			if !ok {
+				return nil, fmt.Errorf("%s depends on %q: %w", name, dependency, ErrUnknownComponent)
+			}
+			waitingOn[i]++
+			dependents[j] = append(dependents[j], i)
+		}
+	}
+
+	phase := func(i int) int {
+		if phased, ok := byName[names[i]].(PhasedComponent); ok {
+			return phased.StartPhase()
+		}
+		return 0
+	}
+
+	order := []Component{}
+	started := make([]bool, len(names))
+	for len(order) < len(names) {
+		// Of everything that's ready, the lowest phase goes first, and within a phase the first passed in
+		next := -1
+		for i := range names {
+			if started[i] || waitingOn[i] > 0 {
+				continue
+			}
+			if next == -1 || phase(i) < phase(next) {
+				next = i
+			}
+		}
+		if next == -1 {
+			return nil, ErrDependencyCycle
+		}
+
+		started[next] = true
+		order = append(order, byName[names[next]])
+		for _, dependent := range dependents[next] {
+			waitingOn[dependent]--
+		}
+	}
+
+	return order, nil
+}
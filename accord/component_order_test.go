@@ -0,0 +1,63 @@
+package accord
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// orderedComponent records when it's started and stopped
+type orderedComponent struct {
+	name      string
+	phase     int
+	dependsOn []string
+	log       *[]string
+}
+
+func (comp *orderedComponent) Name() string        { return comp.name }
+func (comp *orderedComponent) StartPhase() int     { return comp.phase }
+func (comp *orderedComponent) DependsOn() []string { return comp.dependsOn }
+func (comp *orderedComponent) Stop(int)            { *comp.log = append(*comp.log, "stop "+comp.name) }
+func (comp *orderedComponent) WaitForStop()        {}
+func (comp *orderedComponent) Start(*Accord) error {
+	*comp.log = append(*comp.log, "start "+comp.name)
+	return nil
+}
+
+func TestComponentOrder(t *testing.T) {
+	defer AccordCleanup()
+	log := []string{}
+	accord := DummyAccord()
+	accord.components = []Component{
+		&orderedComponent{name: "transport", dependsOn: []string{"discovery"}, log: &log},
+		&orderedComponent{name: "admin", phase: 1, log: &log},
+		&orderedComponent{name: "discovery", phase: 2, log: &log},
+		&orderedComponent{name: "metrics", phase: -1, log: &log},
+	}
+	assert.Nil(t, accord.Start())
+	accord.Stop()
+
+	assert.Equal(t, []string{
+		"start metrics", "start admin", "start discovery", "start transport",
+		"stop transport", "stop discovery", "stop admin", "stop metrics",
+	}, log)
+
+	// Components are still listed in the order they were passed in
+	assert.Equal(t, "transport", accord.Components()[0].Name)
+}
+
+func TestComponentOrderErrors(t *testing.T) {
+	log := []string{}
+	accord := DummyAccord()
+	accord.components = []Component{
+		&orderedComponent{name: "a", dependsOn: []string{"b"}, log: &log},
+		&orderedComponent{name: "b", dependsOn: []string{"a"}, log: &log},
+	}
+	_, err := accord.orderComponents()
+	assert.Equal(t, ErrDependencyCycle, err)
+
+	accord.components = []Component{&orderedComponent{name: "a", dependsOn: []string{"missing"}, log: &log}}
+	_, err = accord.orderComponents()
+	assert.True(t, errors.Is(err, ErrUnknownComponent))
+}
@@ -0,0 +1,98 @@
+package accord
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrComponentDependedOn is returned by RemoveComponent when other Components depend on the one being
+// removed (see DependentComponent)
+var ErrComponentDependedOn = errors.New("other components depend on that component")
+
+// AddComponent registers another Component. Before Start it's simply added to the ones we were created
+// with; once we're running it's started straight away, so a long running process can attach a new
+// transport (say, when a peer is provisioned) without restarting. Anything the Component depends on has
+// to be registered already. If the Component fails to start it isn't added
+func (accord *Accord) AddComponent(comp Component) error {
+	accord.componentsMutex.Lock()
+	defer accord.componentsMutex.Unlock()
+
+	if !accord.started {
+		accord.components = append(accord.components, comp)
+		return nil
+	}
+
+	if dependent, ok := comp.(DependentComponent); ok {
+		byName, _ := accord.namedComponents()
+		for _, dependency := range dependent.DependsOn() {
+			if _, ok := byName[dependency]; !ok {
+				return fmt.Errorf("depends on %q: %w", dependency, ErrUnknownComponent)
+			}
+		}
+	}
+
+	err := accord.startComponent(comp)
+	if err != nil {
+		if panicErr, ok := err.(*PanicError); ok {
+			accord.logPanic(panicErr)
+		}
+		return err
+	}
+
+	accord.components = append(accord.components, comp)
+	accord.startOrder = append(accord.startOrder, comp)
+	name, _ := accord.nameOf(comp)
+	accord.Logger.WithField("component", name).Info("Added a component")
+	return nil
+}
+
+// RemoveComponent stops one of our Components by name (see Components) and forgets about it. Note that
+// taking away a Component can change the names of others of the same type, as "HTTPSync#3" becomes
+// "HTTPSync#2" once the first "HTTPSync#2" is gone. Like Stop, it only waits up to StopTimeout for the
+// Component to stop, leaving one that's stuck running in the background
+func (accord *Accord) RemoveComponent(name string) error {
+	accord.componentsMutex.Lock()
+	byName, names := accord.namedComponents()
+	comp, ok := byName[name]
+	if !ok {
+		accord.componentsMutex.Unlock()
+		return ErrUnknownComponent
+	}
+	for _, other := range names {
+		if dependent, ok := byName[other].(DependentComponent); ok && other != name {
+			for _, dependency := range dependent.DependsOn() {
+				if dependency == name {
+					accord.componentsMutex.Unlock()
+					return fmt.Errorf("%s: %w", other, ErrComponentDependedOn)
+				}
+			}
+		}
+	}
+
+	// It's forgotten about before it's stopped, so that nothing waits on componentsMutex while it stops
+	// and the supervisor doesn't start it again if it fails on the way down
+	accord.components = withoutComponent(accord.components, comp)
+	accord.startOrder = withoutComponent(accord.startOrder, comp)
+	delete(accord.restarts, name)
+	started := accord.started
+	accord.componentsMutex.Unlock()
+
+	if started {
+		accord.stopWithin(name, accord.stopDeadline(), func() {
+			comp.Stop(0)
+			comp.WaitForStop()
+		})
+	}
+	accord.Logger.WithField("component", name).Info("Removed a component")
+	return nil
+}
+
+func withoutComponent(components []Component, comp Component) []Component {
+	remaining := []Component{}
+	for _, other := range components {
+		if other != comp {
+			remaining = append(remaining, other)
+		}
+	}
+	return remaining
+}
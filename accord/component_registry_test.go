@@ -0,0 +1,62 @@
+package accord
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddAndRemoveComponents(t *testing.T) {
+	defer AccordCleanup()
+	log := []string{}
+	accord := DummyAccord()
+	assert.Nil(t, accord.AddComponent(&orderedComponent{name: "discovery", log: &log}))
+	assert.Equal(t, []string{}, log)
+
+	assert.Nil(t, accord.Start())
+	assert.Nil(t, accord.AddComponent(&orderedComponent{name: "transport", dependsOn: []string{"discovery"}, log: &log}))
+	assert.Equal(t, []string{"start discovery", "start transport"}, log)
+	assert.Equal(t, 2, len(accord.Components()))
+
+	err := accord.AddComponent(&orderedComponent{name: "orphan", dependsOn: []string{"missing"}, log: &log})
+	assert.True(t, errors.Is(err, ErrUnknownComponent))
+
+	err = accord.RemoveComponent("discovery")
+	assert.True(t, errors.Is(err, ErrComponentDependedOn))
+	assert.Equal(t, ErrUnknownComponent, accord.RemoveComponent("missing"))
+
+	assert.Nil(t, accord.RemoveComponent("transport"))
+	assert.Equal(t, "stop transport", log[len(log)-1])
+	assert.Equal(t, 1, len(accord.Components()))
+
+	accord.Stop()
+	assert.Equal(t, []string{"start discovery", "start transport", "stop transport", "stop discovery"}, log)
+}
+
+func TestRemoveStuckComponent(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	// A Component that's slow to stop is forgotten about straight away, so it doesn't hold up anything else
+	// that needs our Components in the meantime
+	slow := &stuckComponent{release: make(chan struct{})}
+	assert.Nil(t, accord.AddComponent(slow))
+	removed := make(chan error)
+	go func() { removed <- accord.RemoveComponent("stuck") }()
+	assert.True(t, waitUntil(func() bool { return len(accord.Components()) == 0 }))
+	assert.True(t, accord.IsLeader())
+	close(slow.release)
+	assert.Nil(t, <-removed)
+
+	// And one that never stops is given up on after StopTimeout
+	accord.StopTimeout = 10 * time.Millisecond
+	stuck := &stuckComponent{release: make(chan struct{})}
+	defer close(stuck.release)
+	assert.Nil(t, accord.AddComponent(stuck))
+	assert.Nil(t, accord.RemoveComponent("stuck"))
+	assert.Equal(t, 0, len(accord.Components()))
+}
@@ -1,6 +1,7 @@
 package accord
 
 import (
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -111,3 +112,51 @@ func TestZMQRunnerStop(t *testing.T) {
 
 	assert.True(t, comp.runOnce)
 }
+
+func TestComponentRunnerPause(t *testing.T) {
+	accord := DummyAccord()
+	ticks := int32(0)
+
+	runner := &ComponentRunner{}
+	assert.False(t, runner.Running())
+	runner.Init(accord, func(*Accord) {
+		atomic.AddInt32(&ticks, 1)
+		time.Sleep(time.Millisecond)
+	}, nil, nil)
+	assert.True(t, runner.Running())
+
+	runner.Pause()
+	assert.True(t, runner.Paused())
+	time.Sleep(5 * time.Millisecond)
+	paused := atomic.LoadInt32(&ticks)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, paused, atomic.LoadInt32(&ticks))
+
+	runner.Resume()
+	assert.True(t, waitUntil(func() bool { return atomic.LoadInt32(&ticks) > paused }))
+
+	runner.Stop(0)
+	runner.WaitForStop()
+	assert.False(t, runner.Running())
+}
+
+// runnerComponent is the least a Component built on ComponentRunner can be
+type runnerComponent struct {
+	ComponentRunner
+}
+
+func (comp *runnerComponent) Start(*Accord) error { return nil }
+
+func TestComponentNames(t *testing.T) {
+	accord := DummyAccord()
+	accord.components = []Component{&runnerComponent{}, &runnerComponent{}}
+
+	statuses := accord.Components()
+	assert.Equal(t, "runnerComponent", statuses[0].Name)
+	assert.Equal(t, "runnerComponent#2", statuses[1].Name)
+	assert.True(t, statuses[1].Pausable)
+
+	assert.Equal(t, ErrUnknownComponent, accord.PauseComponent("Missing"))
+	assert.Nil(t, accord.PauseComponent("runnerComponent#2"))
+	assert.True(t, accord.Components()[1].Paused)
+}
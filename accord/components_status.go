@@ -0,0 +1,149 @@
+package accord
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrUnknownComponent is returned when referring to a Component by a name none of ours has
+var ErrUnknownComponent = errors.New("no component with that name is registered")
+
+// ErrNotPausable is returned when trying to pause a Component that doesn't support it
+var ErrNotPausable = errors.New("the component can't be paused")
+
+// NamedComponent can be implemented by Components that want to choose the name they're listed under (see
+// Components). Otherwise they're named after their type
+type NamedComponent interface {
+	Component
+	Name() string
+}
+
+// PausableComponent is implemented by Components that can be paused without being stopped. Every
+// Component built on ComponentRunner is one
+type PausableComponent interface {
+	Component
+	Pause()
+	Resume()
+	Paused() bool
+}
+
+// runningComponent is implemented by Components that know whether they're running. Again, that's
+// every Component built on ComponentRunner
+type runningComponent interface {
+	Running() bool
+}
+
+// ComponentStatus describes one of our Components
+type ComponentStatus struct {
+	// Name identifies the Component; see Components
+	Name string `json:"name"`
+
+	// Type is the Component's Go type
+	Type string `json:"type"`
+
+	// Running is whether the Component's goroutine is going. It's always true for Components that
+	// don't use ComponentRunner, as we've no way of knowing
+	Running bool `json:"running"`
+
+	// Pausable and Paused report whether the Component can be paused, and whether it is
+	Pausable bool `json:"pausable"`
+	Paused   bool `json:"paused"`
+
+	// Restarts is how many times our supervisor has restarted the Component (see RestartPolicy)
+	Restarts int `json:"restarts"`
+}
+
+// componentName works out what a Component is called. Components that don't name themselves are named
+// after their type (like "HTTPSync"), with the second of a type becoming "HTTPSync#2" and so on
+func componentName(comp Component, taken map[string]bool) string {
+	var name string
+	if named, ok := comp.(NamedComponent); ok {
+		name = named.Name()
+	} else {
+		name = fmt.Sprintf("%T", comp)
+		name = name[strings.LastIndex(name, ".")+1:]
+	}
+
+	unique := name
+	for i := 2; taken[unique]; i++ {
+		unique = fmt.Sprintf("%s#%d", name, i)
+	}
+	taken[unique] = true
+	return unique
+}
+
+// namedComponents returns our Components keyed by name, along with their names in the order they were
+// registered. The caller must hold componentsMutex
+func (accord *Accord) namedComponents() (map[string]Component, []string) {
+	byName := map[string]Component{}
+	names := []string{}
+	taken := map[string]bool{}
+	for _, comp := range accord.components {
+		name := componentName(comp, taken)
+		byName[name] = comp
+		names = append(names, name)
+	}
+	return byName, names
+}
+
+// Components describes each of our Components, in the order they were registered
+func (accord *Accord) Components() []ComponentStatus {
+	accord.componentsMutex.Lock()
+	byName, names := accord.namedComponents()
+	restarts := map[string]int{}
+	for name, count := range accord.restarts {
+		restarts[name] = count
+	}
+	accord.componentsMutex.Unlock()
+
+	statuses := []ComponentStatus{}
+	for _, name := range names {
+		comp := byName[name]
+		status := ComponentStatus{Name: name, Type: fmt.Sprintf("%T", comp), Running: true, Restarts: restarts[name]}
+		if running, ok := comp.(runningComponent); ok {
+			status.Running = running.Running()
+		}
+		if pausable, ok := comp.(PausableComponent); ok {
+			status.Pausable = true
+			status.Paused = pausable.Paused()
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// PauseComponent pauses one of our Components by name (see Components), leaving the rest running
+func (accord *Accord) PauseComponent(name string) error {
+	pausable, err := accord.pausableComponent(name)
+	if err != nil {
+		return err
+	}
+	pausable.Pause()
+	return nil
+}
+
+// ResumeComponent resumes a Component paused with PauseComponent
+func (accord *Accord) ResumeComponent(name string) error {
+	pausable, err := accord.pausableComponent(name)
+	if err != nil {
+		return err
+	}
+	pausable.Resume()
+	return nil
+}
+
+func (accord *Accord) pausableComponent(name string) (PausableComponent, error) {
+	accord.componentsMutex.Lock()
+	byName, _ := accord.namedComponents()
+	accord.componentsMutex.Unlock()
+	comp, ok := byName[name]
+	if !ok {
+		return nil, ErrUnknownComponent
+	}
+	pausable, ok := comp.(PausableComponent)
+	if !ok {
+		return nil, ErrNotPausable
+	}
+	return pausable, nil
+}
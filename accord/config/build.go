@@ -0,0 +1,634 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/Ssawa/accord/accord"
+	"github.com/Ssawa/accord/accord/logging"
+	"github.com/Ssawa/accord/components"
+	"github.com/sirupsen/logrus"
+)
+
+// Build sets up an Accord as configured, ready to be started. Our Components are passed to it ahead of any
+// extra ones the application needs. Anything that can't be written in a file, like a ShedFunc, Metrics or
+// a Tracer, can still be set on the Accord before it's started.
+//
+// When we were loaded from a file the Accord's OnReload is set to read it again (see accord.Reload), which
+// applies the log level and sampling, filters, ACL, peer tokens, rate limits, backlog alerts, disk quota,
+// restart policies and HTTPSync's peers without restarting. Everything else only changes on the next start
+func (cfg *Config) Build(manager accord.Manager, extra ...accord.Component) (*accord.Accord, error) {
+	logger := logrus.New()
+	level, err := cfg.logLevel()
+	if err != nil {
+		return nil, err
+	}
+	logger.SetLevel(logging.LogrusLevel(level))
+
+	comps, httpSync, err := cfg.components()
+	if err != nil {
+		return nil, err
+	}
+	dataDir := cfg.DataDir
+	if dataDir == "" {
+		dataDir = "data"
+	}
+
+	acc := accord.NewAccord(manager, append(comps, extra...), dataDir, logging.NewLogrus(logrus.NewEntry(logger)))
+	if cfg.NodeID != "" {
+		acc.NodeID = cfg.NodeID
+	}
+	acc.Relay = cfg.Relay
+	acc.MaxHeldBack = cfg.MaxHeldBack
+	acc.AckQuorum = cfg.AckQuorum
+	acc.AckTimeout = time.Duration(cfg.AckTimeout)
+	acc.DedupWindow = time.Duration(cfg.DedupWindow)
+	acc.InFlightTimeout = time.Duration(cfg.InFlightTimeout)
+	acc.ProgressReporting = accord.ProgressReporting{Threshold: cfg.ProgressReporting.Threshold, Interval: time.Duration(cfg.ProgressReporting.Interval)}
+	acc.FlushInterval = time.Duration(cfg.FlushInterval)
+	acc.FlushCount = cfg.FlushCount
+	acc.SyncEvery = time.Duration(cfg.SyncEvery)
+	acc.ProcessTimeout = time.Duration(cfg.ProcessTimeout)
+	acc.PeerTimeout = time.Duration(cfg.PeerTimeout)
+	acc.MaxClockDrift = time.Duration(cfg.MaxClockDrift)
+	acc.BacklogAlerts = cfg.BacklogAlerts.build()
+	acc.DiskQuota, err = cfg.DiskQuota.build()
+	if err != nil {
+		return nil, err
+	}
+	acc.StopTimeout = time.Duration(cfg.StopTimeout)
+	acc.SystemdNotify = cfg.SystemdNotify
+	acc.AutoRepair = cfg.AutoRepair
+	acc.Replica = cfg.Replica
+	acc.AuditFile = cfg.AuditFile
+	if len(cfg.Channels) > 0 {
+		acc.Channels = map[string]accord.Manager{}
+		for _, name := range cfg.Channels {
+			acc.Channels[name] = nil
+		}
+	}
+	acc.MaxQueueLength = cfg.Queue.MaxLength
+	acc.MaxQueueBytes = cfg.Queue.MaxBytes
+	acc.QueueShards = cfg.Queue.Shards
+	acc.ChunkSize = cfg.Queue.ChunkSize
+	acc.MaxChunkedSize = cfg.Queue.MaxChunkedSize
+	acc.ChunkTTL = time.Duration(cfg.Queue.ChunkTTL)
+	acc.PeerPolicy = accord.PeerPolicy{
+		Allow:        cfg.PeerPolicy.Allow,
+		BanThreshold: cfg.PeerPolicy.BanThreshold,
+		BanWindow:    time.Duration(cfg.PeerPolicy.BanWindow),
+		BanDuration:  time.Duration(cfg.PeerPolicy.BanDuration),
+	}
+
+	acc.ACL = accord.ACL{Origins: cfg.ACL.Origins, Default: cfg.ACL.Default}
+	acc.PeerAuth, err = cfg.PeerAuth.build()
+	if err != nil {
+		return nil, err
+	}
+
+	acc.Ordering, err = parseOrdering(cfg.Ordering)
+	if err != nil {
+		return nil, err
+	}
+	acc.QueueFullPolicy, err = parseQueueFullPolicy(cfg.Queue.FullPolicy)
+	if err != nil {
+		return nil, err
+	}
+	acc.PanicPolicy, err = parsePanicPolicy(cfg.PanicPolicy)
+	if err != nil {
+		return nil, err
+	}
+	acc.Filters, err = cfg.filters()
+	if err != nil {
+		return nil, err
+	}
+	acc.QueueSyncMode, err = parseSyncMode(cfg.QueueSync)
+	if err != nil {
+		return nil, err
+	}
+	acc.StateSyncMode, err = parseSyncMode(cfg.StateSync)
+	if err != nil {
+		return nil, err
+	}
+	acc.DefaultRestartPolicy, err = cfg.RestartPolicy.build()
+	if err != nil {
+		return nil, err
+	}
+	acc.RestartPolicies, err = cfg.restartPolicies()
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.EncryptionKeyFile != "" {
+		acc.EncryptionKey, err = accord.LoadKeyFile(cfg.EncryptionKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load encryption key: %v", err)
+		}
+	}
+	if acc.EncryptionKey != nil && len(cfg.RetiredEncryptionKeyFiles) > 0 {
+		keys := [][]byte{}
+		for _, file := range cfg.RetiredEncryptionKeyFiles {
+			key, err := accord.LoadKeyFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("unable to load retired encryption key: %v", err)
+			}
+			keys = append(keys, key)
+		}
+		acc.EncryptionKeys, err = accord.NewKeyring(append(keys, acc.EncryptionKey)...)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load retired encryption keys: %v", err)
+		}
+	}
+
+	acc.LogSampling = cfg.LogSampling.build()
+	acc.RateLimit = cfg.RateLimit.build()
+	for name, limit := range cfg.PeerRateLimits {
+		acc.SetPeerRateLimit(name, limit.build())
+	}
+	windows, peerWindows, err := cfg.syncWindows()
+	if err != nil {
+		return nil, err
+	}
+	acc.SyncWindows = windows
+	for name, peer := range peerWindows {
+		acc.SetPeerSyncWindows(name, peer)
+	}
+
+	if cfg.path != "" {
+		path := cfg.path
+		acc.OnReload = func(acc *accord.Accord) error {
+			return reload(acc, path, httpSync)
+		}
+	}
+	return acc, nil
+}
+
+// reload reads our file again and applies whatever can be changed while we're running
+func reload(acc *accord.Accord, path string, httpSync *components.HTTPSync) error {
+	cfg, err := Load(path)
+	if err != nil {
+		return err
+	}
+
+	level, err := cfg.logLevel()
+	if err != nil {
+		return err
+	}
+	policies, err := cfg.restartPolicies()
+	if err != nil {
+		return err
+	}
+	filters, err := cfg.filters()
+	if err != nil {
+		return err
+	}
+	auth, err := cfg.PeerAuth.build()
+	if err != nil {
+		return err
+	}
+	windows, peerWindows, err := cfg.syncWindows()
+	if err != nil {
+		return err
+	}
+	quota, err := cfg.DiskQuota.build()
+	if err != nil {
+		return err
+	}
+
+	acc.SetLogLevel(level)
+	acc.SetLogSampling(cfg.LogSampling.build())
+	acc.SetFilters(filters)
+	acc.SetPeerAuth(auth)
+	acc.SetACL(accord.ACL{Origins: cfg.ACL.Origins, Default: cfg.ACL.Default})
+	acc.SetRateLimit(cfg.RateLimit.build())
+	acc.SetBacklogAlerts(cfg.BacklogAlerts.build())
+	acc.SetDiskQuota(quota)
+	for name, limit := range cfg.PeerRateLimits {
+		acc.SetPeerRateLimit(name, limit.build())
+	}
+	acc.SetSyncWindows(windows)
+	for name, peer := range peerWindows {
+		acc.SetPeerSyncWindows(name, peer)
+	}
+	for name, policy := range policies {
+		acc.SetRestartPolicy(name, policy)
+	}
+	if httpSync != nil && cfg.HTTPSync != nil {
+		httpSync.SetPeers(cfg.HTTPSync.peers())
+	}
+	return nil
+}
+
+// components sets up the Components that are present, returning HTTPSync separately as well (or nil) so
+// that its peers can be reloaded
+func (cfg *Config) components() ([]accord.Component, *components.HTTPSync, error) {
+	comps := []accord.Component{}
+	var httpSync *components.HTTPSync
+
+	if cfg.HTTPSync != nil {
+		httpSync = &components.HTTPSync{
+			BindAddress:       cfg.HTTPSync.BindAddress,
+			Peers:             cfg.HTTPSync.peers(),
+			PollingPeers:      cfg.HTTPSync.PollingPeers,
+			BatchSize:         cfg.HTTPSync.BatchSize,
+			RetryInterval:     time.Duration(cfg.HTTPSync.RetryInterval),
+			HeartbeatInterval: time.Duration(cfg.HTTPSync.HeartbeatInterval),
+			DeltaSync:         cfg.HTTPSync.DeltaSync,
+			BootstrapFrom:     cfg.HTTPSync.BootstrapFrom,
+			Compress:          cfg.HTTPSync.Compress,
+		}
+		comps = append(comps, httpSync)
+	}
+	if cfg.AntiEntropy != nil {
+		comps = append(comps, &components.AntiEntropy{
+			BindAddress: cfg.AntiEntropy.BindAddress,
+			Peers:       cfg.AntiEntropy.Peers,
+			Interval:    time.Duration(cfg.AntiEntropy.Interval),
+		})
+	}
+	if cfg.Gossip != nil {
+		comps = append(comps, &components.Gossip{
+			BindAddress:      cfg.Gossip.BindAddress,
+			AdvertiseURL:     cfg.Gossip.AdvertiseURL,
+			Seeds:            cfg.Gossip.Seeds,
+			Fanout:           cfg.Gossip.Fanout,
+			Interval:         time.Duration(cfg.Gossip.Interval),
+			RetransmitMult:   cfg.Gossip.RetransmitMult,
+			SuspicionTimeout: time.Duration(cfg.Gossip.SuspicionTimeout),
+		})
+	}
+	if cfg.RaftElection != nil {
+		comps = append(comps, &components.RaftElection{
+			BindAddress:       cfg.RaftElection.BindAddress,
+			Peers:             cfg.RaftElection.Peers,
+			ElectionTimeout:   time.Duration(cfg.RaftElection.ElectionTimeout),
+			HeartbeatInterval: time.Duration(cfg.RaftElection.HeartbeatInterval),
+		})
+	}
+	if cfg.WebReceiver != nil {
+		comps = append(comps, &components.WebReceiver{BindAddress: cfg.WebReceiver.BindAddress})
+	}
+	if cfg.Admin != nil {
+		comps = append(comps, &components.Admin{BindAddress: cfg.Admin.BindAddress})
+	}
+	if cfg.Probes != nil {
+		comps = append(comps, &components.Probes{BindAddress: cfg.Probes.BindAddress, MaxBacklog: uint64(cfg.Probes.MaxBacklog)})
+	}
+	if cfg.Poller != nil {
+		poller := &components.Poller{
+			URL:       cfg.Poller.URL,
+			Interval:  time.Duration(cfg.Poller.Interval),
+			BatchSize: cfg.Poller.BatchSize,
+			Compress:  cfg.Poller.Compress,
+		}
+		poller.Backoff = cfg.Poller.Backoff.build()
+		comps = append(comps, poller)
+	}
+	if cfg.Chaos != nil {
+		comps = append(comps, &components.Chaos{
+			Seed:                 cfg.Chaos.Seed,
+			DelayProbability:     cfg.Chaos.DelayProbability,
+			MaxDelay:             time.Duration(cfg.Chaos.MaxDelay),
+			DuplicateProbability: cfg.Chaos.DuplicateProbability,
+			FailureProbability:   cfg.Chaos.FailureProbability,
+		})
+	}
+	if cfg.Webhook != nil {
+		comps = append(comps, &components.Webhook{
+			Name:        cfg.Webhook.Name,
+			URL:         cfg.Webhook.URL,
+			Secret:      []byte(cfg.Webhook.Secret),
+			MaxAttempts: cfg.Webhook.MaxAttempts,
+			Backoff:     time.Duration(cfg.Webhook.Backoff),
+			MaxBackoff:  time.Duration(cfg.Webhook.MaxBackoff),
+		})
+	}
+	if cfg.Cron != nil {
+		cron, err := cfg.Cron.build()
+		if err != nil {
+			return nil, nil, err
+		}
+		comps = append(comps, cron)
+	}
+	if cfg.MessageAPI != nil {
+		api, err := cfg.MessageAPI.build()
+		if err != nil {
+			return nil, nil, err
+		}
+		comps = append(comps, api)
+	}
+	if cfg.GRPC != nil {
+		tokens, err := loadAPITokens(cfg.GRPC.Tokens)
+		if err != nil {
+			return nil, nil, err
+		}
+		comps = append(comps, &components.GRPCService{
+			BindAddress: cfg.GRPC.BindAddress,
+			Tokens:      tokens,
+			Types:       cfg.GRPC.Types,
+			MaxMessages: cfg.GRPC.MaxMessages,
+		})
+	}
+	return comps, httpSync, nil
+}
+
+func (api *MessageAPI) build() (*components.MessageAPI, error) {
+	built := &components.MessageAPI{
+		BindAddress:  api.BindAddress,
+		Types:        api.Types,
+		MaxBodyBytes: api.MaxBodyBytes,
+		MaxMessages:  api.MaxMessages,
+	}
+	tokens, err := loadAPITokens(api.Tokens)
+	if err != nil {
+		return nil, err
+	}
+	built.Tokens = tokens
+	return built, nil
+}
+
+// loadAPITokens reads the tokens MessageAPI or GRPCService accepts from their files
+func loadAPITokens(accepted []MessageAPIToken) ([]components.MessageAPIToken, error) {
+	tokens := []components.MessageAPIToken{}
+	for _, token := range accepted {
+		value, err := loadToken(token.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, components.MessageAPIToken{Client: token.Client, Token: value})
+	}
+	return tokens, nil
+}
+
+func (httpSync *HTTPSync) peers() []components.HTTPPeer {
+	peers := []components.HTTPPeer{}
+	for _, peer := range httpSync.Peers {
+		peers = append(peers, components.HTTPPeer{Name: peer.Name, URL: peer.URL})
+	}
+	return peers
+}
+
+func (cfg *Config) logLevel() (accord.LogLevel, error) {
+	if cfg.LogLevel == "" {
+		return accord.LogInfo, nil
+	}
+	return accord.ParseLogLevel(cfg.LogLevel)
+}
+
+func (cfg *Config) restartPolicies() (map[string]accord.RestartPolicy, error) {
+	policies := map[string]accord.RestartPolicy{}
+	for name, policy := range cfg.RestartPolicies {
+		built, err := policy.build()
+		if err != nil {
+			return nil, fmt.Errorf("restart policy for %s: %v", name, err)
+		}
+		policies[name] = built
+	}
+	return policies, nil
+}
+
+// syncWindows parses our SyncWindows, and PeerSyncWindows by peer
+func (cfg *Config) syncWindows() ([]accord.SyncWindow, map[string][]accord.SyncWindow, error) {
+	location := time.Local
+	if cfg.SyncWindowZone != "" {
+		var err error
+		location, err = time.LoadLocation(cfg.SyncWindowZone)
+		if err != nil {
+			return nil, nil, fmt.Errorf("sync window zone: %v", err)
+		}
+	}
+	parse := func(written []string) ([]accord.SyncWindow, error) {
+		windows := []accord.SyncWindow{}
+		for _, window := range written {
+			parsed, err := accord.ParseSyncWindow(window)
+			if err != nil {
+				return nil, err
+			}
+			parsed.Location = location
+			windows = append(windows, parsed)
+		}
+		return windows, nil
+	}
+
+	windows, err := parse(cfg.SyncWindows)
+	if err != nil {
+		return nil, nil, err
+	}
+	peers := map[string][]accord.SyncWindow{}
+	for name, written := range cfg.PeerSyncWindows {
+		peers[name], err = parse(written)
+		if err != nil {
+			return nil, nil, fmt.Errorf("sync windows for %s: %v", name, err)
+		}
+	}
+	return windows, peers, nil
+}
+
+func (cfg *Config) filters() ([]accord.FilterRule, error) {
+	rules := []accord.FilterRule{}
+	for _, filter := range cfg.Filters {
+		rule := accord.FilterRule{Name: filter.Name, Types: filter.Types, Origins: filter.Origins, Headers: filter.Headers}
+		switch filter.Action {
+		case "", "drop":
+			rule.Action = accord.FilterDrop
+		case "quarantine":
+			rule.Action = accord.FilterQuarantine
+		default:
+			return nil, fmt.Errorf("filter %s: unknown action %q", filter.Name, filter.Action)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func (auth PeerAuth) build() (accord.PeerAuth, error) {
+	built := accord.PeerAuth{PeerTokens: map[string]string{}}
+	var err error
+	if auth.TokenFile != "" {
+		built.Token, err = loadToken(auth.TokenFile)
+		if err != nil {
+			return built, err
+		}
+	}
+	for address, file := range auth.PeerTokenFiles {
+		built.PeerTokens[address], err = loadToken(file)
+		if err != nil {
+			return built, err
+		}
+	}
+	for _, accepted := range auth.Accept {
+		token, err := loadToken(accepted.TokenFile)
+		if err != nil {
+			return built, err
+		}
+		built.Tokens = append(built.Tokens, accord.PeerToken{Peer: accepted.Peer, Token: token})
+	}
+	return built, nil
+}
+
+// loadToken reads a token (a peer's, or one MessageAPI or GRPCService accepts) from a file, ignoring any whitespace around
+// it
+func loadToken(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("unable to load token: %v", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("token file %s is empty", path)
+	}
+	return token, nil
+}
+
+func (sampling LogSampling) build() accord.LogSampling {
+	return accord.LogSampling{First: sampling.First, Thereafter: sampling.Thereafter, Interval: time.Duration(sampling.Interval)}
+}
+
+func (limit RateLimit) build() accord.RateLimit {
+	return accord.RateLimit{MessagesPerSecond: limit.MessagesPerSecond, BytesPerSecond: limit.BytesPerSecond}
+}
+
+func (alerts BacklogAlerts) build() accord.BacklogAlerts {
+	return accord.BacklogAlerts{
+		MaxQueueDepth: alerts.MaxQueueDepth,
+		MaxPendingAge: time.Duration(alerts.MaxPendingAge),
+		CheckInterval: time.Duration(alerts.CheckInterval),
+	}
+}
+
+func (quota DiskQuota) build() (accord.DiskQuota, error) {
+	built := accord.DiskQuota{
+		MaxBytes:      quota.MaxBytes,
+		CheckInterval: time.Duration(quota.CheckInterval),
+		PruneBatch:    quota.PruneBatch,
+	}
+	switch quota.Policy {
+	case "", "alert":
+		built.Policy = accord.DiskQuotaAlert
+	case "reject":
+		built.Policy = accord.DiskQuotaReject
+	case "prune":
+		built.Policy = accord.DiskQuotaPrune
+	default:
+		return built, fmt.Errorf("unknown disk quota policy %q", quota.Policy)
+	}
+	return built, nil
+}
+
+func (cron *Cron) build() (*components.Cron, error) {
+	comp := &components.Cron{Name: cron.Name, MaxCatchUp: cron.MaxCatchUp}
+	if cron.Location != "" {
+		location, err := time.LoadLocation(cron.Location)
+		if err != nil {
+			return nil, err
+		}
+		comp.Location = location
+	}
+	for _, job := range cron.Jobs {
+		catchUp, err := parseCronCatchUp(job.CatchUp)
+		if err != nil {
+			return nil, err
+		}
+		comp.Jobs = append(comp.Jobs, components.CronJob{
+			Name:     job.Name,
+			Schedule: job.Schedule,
+			Type:     job.Type,
+			Key:      job.Key,
+			Channel:  job.Channel,
+			Headers:  job.Headers,
+			Payload:  []byte(job.Payload),
+			CatchUp:  catchUp,
+		})
+	}
+	return comp, nil
+}
+
+func (backoff Backoff) build() accord.Backoff {
+	return accord.Backoff{
+		Initial:    time.Duration(backoff.Initial),
+		Max:        time.Duration(backoff.Max),
+		Jitter:     backoff.Jitter,
+		MaxRetries: backoff.MaxRetries,
+	}
+}
+
+func (policy RestartPolicy) build() (accord.RestartPolicy, error) {
+	built := accord.RestartPolicy{
+		MaxRestarts: policy.MaxRestarts,
+		Backoff:     time.Duration(policy.Backoff),
+		MaxBackoff:  time.Duration(policy.MaxBackoff),
+	}
+	switch policy.Mode {
+	case "", "shutdown":
+		built.Mode = accord.EscalateToShutdown
+	case "always":
+		built.Mode = accord.RestartAlways
+	case "limited":
+		built.Mode = accord.RestartLimited
+	default:
+		return built, fmt.Errorf("unknown restart mode %q", policy.Mode)
+	}
+	return built, nil
+}
+
+func parseOrdering(ordering string) (accord.OrderingMode, error) {
+	switch ordering {
+	case "", "none":
+		return accord.OrderNone, nil
+	case "fifo":
+		return accord.OrderFIFO, nil
+	case "causal":
+		return accord.OrderCausal, nil
+	case "per_key":
+		return accord.OrderPerKey, nil
+	}
+	return accord.OrderNone, fmt.Errorf("unknown ordering %q", ordering)
+}
+
+func parseQueueFullPolicy(policy string) (accord.QueueFullPolicy, error) {
+	switch policy {
+	case "", "reject":
+		return accord.QueueFullReject, nil
+	case "block":
+		return accord.QueueFullBlock, nil
+	case "shed":
+		return accord.QueueFullShed, nil
+	}
+	return accord.QueueFullReject, fmt.Errorf("unknown queue full policy %q", policy)
+}
+
+func parsePanicPolicy(policy string) (accord.PanicPolicy, error) {
+	switch policy {
+	case "", "shutdown":
+		return accord.PanicShutdown, nil
+	case "dead_letter":
+		return accord.PanicDeadLetter, nil
+	}
+	return accord.PanicShutdown, fmt.Errorf("unknown panic policy %q", policy)
+}
+
+func parseSyncMode(mode string) (accord.SyncMode, error) {
+	switch mode {
+	case "", "never":
+		return accord.SyncNever, nil
+	case "interval":
+		return accord.SyncInterval, nil
+	case "always":
+		return accord.SyncAlways, nil
+	}
+	return accord.SyncNever, fmt.Errorf("unknown sync mode %q", mode)
+}
+
+func parseCronCatchUp(catchUp string) (components.CronCatchUp, error) {
+	switch catchUp {
+	case "", "latest":
+		return components.CronCatchUpLatest, nil
+	case "all":
+		return components.CronCatchUpAll, nil
+	case "skip":
+		return components.CronCatchUpSkip, nil
+	}
+	return components.CronCatchUpLatest, fmt.Errorf("unknown cron catch up policy %q", catchUp)
+}
@@ -0,0 +1,523 @@
+// Package config sets up an Accord from a configuration file, so that deployments don't have to encode
+// their data directory, Components, peers and limits in Go code:
+//
+//	cfg, err := config.Load("/etc/accord.yaml")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	acc, err := cfg.Build(manager)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	acc.StartAndListen(os.Interrupt)
+//
+// Files can be written in YAML (".yaml" or ".yml") or TOML (".toml"), using the snake_case names in the
+// tags of Config. Every setting that isn't a map can also be overridden through the environment, which
+// Load does for us using EnvPrefix (see ApplyEnv). A small YAML file might look like:
+//
+//	node_id: edge-1
+//	data_dir: /var/lib/accord
+//	rate_limit:
+//	  messages_per_second: 500
+//	http_sync:
+//	  bind_address: ":8080"
+//	  peers:
+//	    - name: hub
+//	      url: http://hub:8080
+//	admin: {}
+//
+// Components are only set up when their section is present, even if it's empty like admin's above
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Format is the language a configuration file is written in
+type Format string
+
+// The formats we can read
+const (
+	YAML Format = "yaml"
+	TOML Format = "toml"
+)
+
+// Config is everything about an Accord that can be configured from a file. The zero value of any setting
+// leaves Accord's own default in place
+type Config struct {
+	// NodeID is our Accord's NodeID
+	NodeID string `yaml:"node_id" toml:"node_id"`
+
+	// DataDir is where our Accord keeps its data, defaulting to "data"
+	DataDir string `yaml:"data_dir" toml:"data_dir"`
+
+	// LogLevel is "debug", "info", "warn" or "error" (see accord.ParseLogLevel), defaulting to "info"
+	LogLevel string `yaml:"log_level" toml:"log_level"`
+
+	// LogSampling thins out the Debug lines logged for every Message (see accord.LogSampling)
+	LogSampling LogSampling `yaml:"log_sampling" toml:"log_sampling"`
+
+	// Ordering is one of "none", "fifo", "causal" or "per_key" (see accord.OrderingMode)
+	Ordering    string `yaml:"ordering" toml:"ordering"`
+	MaxHeldBack int    `yaml:"max_held_back" toml:"max_held_back"`
+
+	Relay      bool     `yaml:"relay" toml:"relay"`
+	AckQuorum  int      `yaml:"ack_quorum" toml:"ack_quorum"`
+	AckTimeout Duration `yaml:"ack_timeout" toml:"ack_timeout"`
+
+	// InFlightTimeout is how long peers have to acknowledge what they're sent before it's sent again
+	InFlightTimeout Duration `yaml:"in_flight_timeout" toml:"in_flight_timeout"`
+
+	// ProgressReporting decides when peers catching up on a backlog are reported on (see
+	// accord.ProgressReporting)
+	ProgressReporting ProgressReporting `yaml:"progress_reporting" toml:"progress_reporting"`
+
+	// DedupWindow is how long idempotency keys are remembered for, or negative to not deduplicate at all
+	DedupWindow Duration `yaml:"dedup_window" toml:"dedup_window"`
+
+	// FlushInterval batches state and queue writes, flushing them this often or every FlushCount updates
+	// (see accord.Accord.FlushInterval)
+	FlushInterval Duration `yaml:"flush_interval" toml:"flush_interval"`
+	FlushCount    int      `yaml:"flush_count" toml:"flush_count"`
+
+	// QueueSync and StateSync are each one of "never", "interval" or "always" (see accord.SyncMode), and
+	// SyncEvery is how often stores under "interval" are synced
+	QueueSync string   `yaml:"queue_sync" toml:"queue_sync"`
+	StateSync string   `yaml:"state_sync" toml:"state_sync"`
+	SyncEvery Duration `yaml:"sync_every" toml:"sync_every"`
+
+	// AutoRepair repairs damaged stores on startup rather than refusing to start (see accord.Accord.AutoRepair)
+	AutoRepair bool `yaml:"auto_repair" toml:"auto_repair"`
+
+	// EncryptionKeyFile holds the key our data directory is encrypted with (see accord.Accord.EncryptionKey
+	// and accord.LoadKeyFile). The key itself is best kept out of the configuration file
+	EncryptionKeyFile string `yaml:"encryption_key_file" toml:"encryption_key_file"`
+
+	// RetiredEncryptionKeyFiles hold keys that EncryptionKeyFile's has replaced (see
+	// accord.Accord.RotateEncryptionKey), which are still accepted when reading what they encrypted
+	RetiredEncryptionKeyFiles []string `yaml:"retired_encryption_key_files" toml:"retired_encryption_key_files"`
+
+	// AuditFile keeps a tamper evident audit log of every Message the node processes (see
+	// accord.Accord.AuditFile)
+	AuditFile string `yaml:"audit_file" toml:"audit_file"`
+
+	// Replica makes the node a read-only replica that never originates Messages (see accord.Accord.Replica)
+	Replica bool `yaml:"replica" toml:"replica"`
+
+	// Channels names the channels to synchronize besides the default one (see accord.Accord.Channels). They
+	// all share the Manager passed to Build; set Accord.Channels yourself for Managers of their own
+	Channels []string `yaml:"channels" toml:"channels"`
+
+	// PanicPolicy is either "shutdown" or "dead_letter" (see accord.PanicPolicy)
+	PanicPolicy string `yaml:"panic_policy" toml:"panic_policy"`
+
+	// SystemdNotify integrates with systemd's notifications and watchdog (see accord.Accord.SystemdNotify)
+	SystemdNotify bool `yaml:"systemd_notify" toml:"systemd_notify"`
+
+	// StopTimeout is how long stopping waits, all told, on anything that's stuck (see accord.Accord.StopTimeout)
+	StopTimeout Duration `yaml:"stop_timeout" toml:"stop_timeout"`
+
+	// ProcessTimeout is how long the Manager gets to process each Message (see accord.Accord.ProcessTimeout)
+	ProcessTimeout Duration `yaml:"process_timeout" toml:"process_timeout"`
+
+	// PeerTimeout is how long a peer can go quiet before it's considered down (see accord.Accord.PeerTimeout)
+	PeerTimeout Duration `yaml:"peer_timeout" toml:"peer_timeout"`
+
+	// MaxClockDrift is how far ahead of ours a peer's clock can be before we ignore its timestamps (see
+	// accord.Accord.MaxClockDrift)
+	MaxClockDrift Duration `yaml:"max_clock_drift" toml:"max_clock_drift"`
+
+	// BacklogAlerts are when we report that we're lagged or offline (see accord.BacklogAlerts)
+	BacklogAlerts BacklogAlerts `yaml:"backlog_alerts" toml:"backlog_alerts"`
+
+	// DiskQuota keeps our data directory from filling its disk (see accord.DiskQuota)
+	DiskQuota DiskQuota `yaml:"disk_quota" toml:"disk_quota"`
+
+	Queue      Queue      `yaml:"queue" toml:"queue"`
+	PeerPolicy PeerPolicy `yaml:"peer_policy" toml:"peer_policy"`
+	PeerAuth   PeerAuth   `yaml:"peer_auth" toml:"peer_auth"`
+
+	// RateLimit is the limit for every peer that isn't listed in PeerRateLimits
+	RateLimit      RateLimit            `yaml:"rate_limit" toml:"rate_limit"`
+	PeerRateLimits map[string]RateLimit `yaml:"peer_rate_limits" toml:"peer_rate_limits"`
+
+	// SyncWindows are the times of day every peer that isn't listed in PeerSyncWindows can be synchronized
+	// with, written like "01:00-05:00" (see accord.SyncWindow). They're in SyncWindowZone, an IANA time
+	// zone like "Europe/London", defaulting to the local one
+	SyncWindows     []string            `yaml:"sync_windows" toml:"sync_windows"`
+	PeerSyncWindows map[string][]string `yaml:"peer_sync_windows" toml:"peer_sync_windows"`
+	SyncWindowZone  string              `yaml:"sync_window_zone" toml:"sync_window_zone"`
+
+	// RestartPolicy is the policy for every Component that isn't listed in RestartPolicies, by name
+	RestartPolicy   RestartPolicy            `yaml:"restart_policy" toml:"restart_policy"`
+	RestartPolicies map[string]RestartPolicy `yaml:"restart_policies" toml:"restart_policies"`
+
+	// Filters drop or quarantine classes of remote Messages (see accord.FilterRule). They're applied again
+	// when the file is reloaded, and can only be set in the file
+	Filters []Filter `yaml:"filters" toml:"filters"`
+
+	// ACL limits the Message types each peer may originate (see accord.ACL). It's applied again when the
+	// file is reloaded
+	ACL ACL `yaml:"acl" toml:"acl"`
+
+	// Our Components, which are only set up when they're present
+	HTTPSync     *HTTPSync     `yaml:"http_sync" toml:"http_sync"`
+	AntiEntropy  *AntiEntropy  `yaml:"anti_entropy" toml:"anti_entropy"`
+	Gossip       *Gossip       `yaml:"gossip" toml:"gossip"`
+	RaftElection *RaftElection `yaml:"raft_election" toml:"raft_election"`
+	WebReceiver  *WebReceiver  `yaml:"web_receiver" toml:"web_receiver"`
+	Admin        *Admin        `yaml:"admin" toml:"admin"`
+	Probes       *Probes       `yaml:"probes" toml:"probes"`
+	Webhook      *Webhook      `yaml:"webhook" toml:"webhook"`
+	Poller       *Poller       `yaml:"poller" toml:"poller"`
+	Chaos        *Chaos        `yaml:"chaos" toml:"chaos"`
+	Cron         *Cron         `yaml:"cron" toml:"cron"`
+	MessageAPI   *MessageAPI   `yaml:"message_api" toml:"message_api"`
+	GRPC         *GRPC         `yaml:"grpc" toml:"grpc"`
+
+	// path is the file we were loaded from, which is read again whenever we're reloaded
+	path string
+}
+
+// Queue bounds our synchronization queue (see accord.Accord's MaxQueueLength)
+type Queue struct {
+	MaxLength int   `yaml:"max_length" toml:"max_length"`
+	MaxBytes  int64 `yaml:"max_bytes" toml:"max_bytes"`
+
+	// FullPolicy is one of "reject", "block" or "shed" (see accord.QueueFullPolicy). Shedding needs a
+	// ShedFunc, which has to be set in code once the Accord is built
+	FullPolicy string `yaml:"full_policy" toml:"full_policy"`
+
+	// Shards is how many queues each channel is split across (see accord.Accord.QueueShards)
+	Shards int `yaml:"shards" toml:"shards"`
+
+	// ChunkSize is the payload size above which Messages are synchronized in chunks (see
+	// accord.Accord.ChunkSize)
+	ChunkSize int `yaml:"chunk_size" toml:"chunk_size"`
+
+	// MaxChunkedSize and ChunkTTL bound the chunks we hold on to for a peer's Messages (see
+	// accord.Accord.MaxChunkedSize and ChunkTTL)
+	MaxChunkedSize int      `yaml:"max_chunked_size" toml:"max_chunked_size"`
+	ChunkTTL       Duration `yaml:"chunk_ttl" toml:"chunk_ttl"`
+}
+
+// PeerPolicy is an accord.PeerPolicy
+type PeerPolicy struct {
+	Allow        []string `yaml:"allow" toml:"allow"`
+	BanThreshold int      `yaml:"ban_threshold" toml:"ban_threshold"`
+	BanWindow    Duration `yaml:"ban_window" toml:"ban_window"`
+	BanDuration  Duration `yaml:"ban_duration" toml:"ban_duration"`
+}
+
+// PeerAuth is an accord.PeerAuth. Tokens are read from files, to keep them out of the configuration file
+// itself, and are picked up again when the file is reloaded, which is how they're rotated: list a peer's new
+// token alongside its old one, and drop the old one once the peer has switched over
+type PeerAuth struct {
+	// TokenFile holds the token we present to every peer that isn't listed in PeerTokenFiles
+	TokenFile string `yaml:"token_file" toml:"token_file"`
+
+	// PeerTokenFiles hold the tokens we present to particular peers, by their address
+	PeerTokenFiles map[string]string `yaml:"peer_token_files" toml:"peer_token_files"`
+
+	// Accept lists the tokens we accept from our peers
+	Accept []AcceptedToken `yaml:"accept" toml:"accept"`
+}
+
+// AcceptedToken is an accord.PeerToken
+type AcceptedToken struct {
+	Peer      string `yaml:"peer" toml:"peer"`
+	TokenFile string `yaml:"token_file" toml:"token_file"`
+}
+
+// LogSampling is an accord.LogSampling
+type LogSampling struct {
+	First      int      `yaml:"first" toml:"first"`
+	Thereafter int      `yaml:"thereafter" toml:"thereafter"`
+	Interval   Duration `yaml:"interval" toml:"interval"`
+}
+
+// ProgressReporting is an accord.ProgressReporting
+type ProgressReporting struct {
+	Threshold uint64   `yaml:"threshold" toml:"threshold"`
+	Interval  Duration `yaml:"interval" toml:"interval"`
+}
+
+// RateLimit is an accord.RateLimit
+type RateLimit struct {
+	MessagesPerSecond float64 `yaml:"messages_per_second" toml:"messages_per_second"`
+	BytesPerSecond    float64 `yaml:"bytes_per_second" toml:"bytes_per_second"`
+}
+
+// Filter is an accord.FilterRule
+type Filter struct {
+	Name string `yaml:"name" toml:"name"`
+
+	// Action is either "drop" or "quarantine" (see accord.FilterAction)
+	Action  string            `yaml:"action" toml:"action"`
+	Types   []string          `yaml:"types" toml:"types"`
+	Origins []string          `yaml:"origins" toml:"origins"`
+	Headers map[string]string `yaml:"headers" toml:"headers"`
+}
+
+// ACL is an accord.ACL
+type ACL struct {
+	Origins map[string][]string `yaml:"origins" toml:"origins"`
+	Default []string            `yaml:"default" toml:"default"`
+}
+
+// RestartPolicy is an accord.RestartPolicy
+type RestartPolicy struct {
+	// Mode is one of "shutdown", "always" or "limited" (see accord.RestartMode)
+	Mode        string   `yaml:"mode" toml:"mode"`
+	MaxRestarts int      `yaml:"max_restarts" toml:"max_restarts"`
+	Backoff     Duration `yaml:"backoff" toml:"backoff"`
+	MaxBackoff  Duration `yaml:"max_backoff" toml:"max_backoff"`
+}
+
+// Peer is another node we send our Messages to over HTTPSync. In the environment a list of them is written
+// as "name=url,name=url"
+type Peer struct {
+	Name string `yaml:"name" toml:"name"`
+	URL  string `yaml:"url" toml:"url"`
+}
+
+// HTTPSync configures a components.HTTPSync
+type HTTPSync struct {
+	BindAddress       string   `yaml:"bind_address" toml:"bind_address"`
+	Peers             []Peer   `yaml:"peers" toml:"peers"`
+	PollingPeers      []string `yaml:"polling_peers" toml:"polling_peers"`
+	BatchSize         int      `yaml:"batch_size" toml:"batch_size"`
+	RetryInterval     Duration `yaml:"retry_interval" toml:"retry_interval"`
+	HeartbeatInterval Duration `yaml:"heartbeat_interval" toml:"heartbeat_interval"`
+	DeltaSync         bool     `yaml:"delta_sync" toml:"delta_sync"`
+	BootstrapFrom     string   `yaml:"bootstrap_from" toml:"bootstrap_from"`
+	Compress          bool     `yaml:"compress" toml:"compress"`
+}
+
+// Poller configures a components.Poller
+type Poller struct {
+	URL       string   `yaml:"url" toml:"url"`
+	Interval  Duration `yaml:"interval" toml:"interval"`
+	BatchSize int      `yaml:"batch_size" toml:"batch_size"`
+	Compress  bool     `yaml:"compress" toml:"compress"`
+	Backoff   Backoff  `yaml:"backoff" toml:"backoff"`
+}
+
+// BacklogAlerts is an accord.BacklogAlerts
+type BacklogAlerts struct {
+	MaxQueueDepth uint64   `yaml:"max_queue_depth" toml:"max_queue_depth"`
+	MaxPendingAge Duration `yaml:"max_pending_age" toml:"max_pending_age"`
+	CheckInterval Duration `yaml:"check_interval" toml:"check_interval"`
+}
+
+// DiskQuota is an accord.DiskQuota
+type DiskQuota struct {
+	MaxBytes int64 `yaml:"max_bytes" toml:"max_bytes"`
+
+	// Policy is one of "alert", "reject" or "prune" (see accord.DiskQuotaPolicy)
+	Policy string `yaml:"policy" toml:"policy"`
+
+	CheckInterval Duration `yaml:"check_interval" toml:"check_interval"`
+	PruneBatch    uint64   `yaml:"prune_batch" toml:"prune_batch"`
+}
+
+// Backoff is an accord.Backoff, for Components that reconnect to their peers
+type Backoff struct {
+	Initial    Duration `yaml:"initial" toml:"initial"`
+	Max        Duration `yaml:"max" toml:"max"`
+	Jitter     float64  `yaml:"jitter" toml:"jitter"`
+	MaxRetries int      `yaml:"max_retries" toml:"max_retries"`
+}
+
+// AntiEntropy configures a components.AntiEntropy
+type AntiEntropy struct {
+	BindAddress string   `yaml:"bind_address" toml:"bind_address"`
+	Peers       []string `yaml:"peers" toml:"peers"`
+	Interval    Duration `yaml:"interval" toml:"interval"`
+}
+
+// Gossip configures a components.Gossip
+type Gossip struct {
+	BindAddress      string   `yaml:"bind_address" toml:"bind_address"`
+	AdvertiseURL     string   `yaml:"advertise_url" toml:"advertise_url"`
+	Seeds            []string `yaml:"seeds" toml:"seeds"`
+	Fanout           int      `yaml:"fanout" toml:"fanout"`
+	Interval         Duration `yaml:"interval" toml:"interval"`
+	RetransmitMult   int      `yaml:"retransmit_mult" toml:"retransmit_mult"`
+	SuspicionTimeout Duration `yaml:"suspicion_timeout" toml:"suspicion_timeout"`
+}
+
+// RaftElection configures a components.RaftElection
+type RaftElection struct {
+	BindAddress       string   `yaml:"bind_address" toml:"bind_address"`
+	Peers             []string `yaml:"peers" toml:"peers"`
+	ElectionTimeout   Duration `yaml:"election_timeout" toml:"election_timeout"`
+	HeartbeatInterval Duration `yaml:"heartbeat_interval" toml:"heartbeat_interval"`
+}
+
+// WebReceiver configures a components.WebReceiver
+type WebReceiver struct {
+	BindAddress string `yaml:"bind_address" toml:"bind_address"`
+}
+
+// MessageAPI configures a components.MessageAPI. Like PeerAuth's, its tokens are read from files
+type MessageAPI struct {
+	BindAddress  string            `yaml:"bind_address" toml:"bind_address"`
+	Tokens       []MessageAPIToken `yaml:"tokens" toml:"tokens"`
+	Types        []string          `yaml:"types" toml:"types"`
+	MaxBodyBytes int64             `yaml:"max_body_bytes" toml:"max_body_bytes"`
+	MaxMessages  int               `yaml:"max_messages" toml:"max_messages"`
+}
+
+// GRPC configures a components.GRPCService, whose tokens are read from files like MessageAPI's
+type GRPC struct {
+	BindAddress string            `yaml:"bind_address" toml:"bind_address"`
+	Tokens      []MessageAPIToken `yaml:"tokens" toml:"tokens"`
+	Types       []string          `yaml:"types" toml:"types"`
+	MaxMessages int               `yaml:"max_messages" toml:"max_messages"`
+}
+
+// MessageAPIToken is a components.MessageAPIToken
+type MessageAPIToken struct {
+	Client    string `yaml:"client" toml:"client"`
+	TokenFile string `yaml:"token_file" toml:"token_file"`
+}
+
+// Admin configures a components.Admin
+type Admin struct {
+	BindAddress string `yaml:"bind_address" toml:"bind_address"`
+}
+
+// Probes configures a components.Probes
+type Probes struct {
+	BindAddress string `yaml:"bind_address" toml:"bind_address"`
+	MaxBacklog  int    `yaml:"max_backlog" toml:"max_backlog"`
+}
+
+// Chaos configures a components.Chaos, which is only meant for staging environments
+type Chaos struct {
+	Seed                 int64    `yaml:"seed" toml:"seed"`
+	DelayProbability     float64  `yaml:"delay_probability" toml:"delay_probability"`
+	MaxDelay             Duration `yaml:"max_delay" toml:"max_delay"`
+	DuplicateProbability float64  `yaml:"duplicate_probability" toml:"duplicate_probability"`
+	FailureProbability   float64  `yaml:"failure_probability" toml:"failure_probability"`
+}
+
+// Cron configures a components.Cron. Location is a time zone name like "Europe/London"
+type Cron struct {
+	Name       string    `yaml:"name" toml:"name"`
+	Location   string    `yaml:"location" toml:"location"`
+	MaxCatchUp int       `yaml:"max_catch_up" toml:"max_catch_up"`
+	Jobs       []CronJob `yaml:"jobs" toml:"jobs"`
+}
+
+// CronJob is a components.CronJob. Its payload is written as a string, and its catch_up is one of
+// "latest" (the default), "all" or "skip"
+type CronJob struct {
+	Name     string            `yaml:"name" toml:"name"`
+	Schedule string            `yaml:"schedule" toml:"schedule"`
+	Type     string            `yaml:"type" toml:"type"`
+	Key      string            `yaml:"key" toml:"key"`
+	Channel  string            `yaml:"channel" toml:"channel"`
+	Headers  map[string]string `yaml:"headers" toml:"headers"`
+	Payload  string            `yaml:"payload" toml:"payload"`
+	CatchUp  string            `yaml:"catch_up" toml:"catch_up"`
+}
+
+// Webhook configures a components.Webhook. The secret is best kept out of the file and set through the
+// environment instead
+type Webhook struct {
+	Name        string   `yaml:"name" toml:"name"`
+	URL         string   `yaml:"url" toml:"url"`
+	Secret      string   `yaml:"secret" toml:"secret"`
+	MaxAttempts int      `yaml:"max_attempts" toml:"max_attempts"`
+	Backoff     Duration `yaml:"backoff" toml:"backoff"`
+	MaxBackoff  Duration `yaml:"max_backoff" toml:"max_backoff"`
+}
+
+// Duration is a time.Duration written the way time.ParseDuration reads it, like "1m30s"
+type Duration time.Duration
+
+// UnmarshalText parses a Duration
+func (duration *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*duration = Duration(parsed)
+	return nil
+}
+
+// Load reads a configuration file, working out its Format from its extension, and then applies any
+// overrides from the environment using EnvPrefix
+func Load(path string) (*Config, error) {
+	var format Format
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		format = YAML
+	case ".toml":
+		format = TOML
+	default:
+		return nil, fmt.Errorf("unable to tell what format %s is written in", path)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := Parse(data, format)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	err = cfg.ApplyEnv(EnvPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.path = path
+	return cfg, nil
+}
+
+// Parse reads a configuration that's already in memory. Settings we don't know about are treated as errors,
+// so that typos don't go unnoticed
+func Parse(data []byte, format Format) (*Config, error) {
+	cfg := &Config{}
+	switch format {
+	case YAML:
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err := decoder.Decode(cfg)
+		// An empty file is a perfectly good configuration, it just leaves everything as the default
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+	case TOML:
+		meta, err := toml.Decode(string(data), cfg)
+		if err != nil {
+			return nil, err
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			unknown := []string{}
+			for _, key := range undecoded {
+				unknown = append(unknown, key.String())
+			}
+			sort.Strings(unknown)
+			return nil, fmt.Errorf("unknown settings: %s", strings.Join(unknown, ", "))
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+	return cfg, nil
+}
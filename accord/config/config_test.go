@@ -0,0 +1,356 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Ssawa/accord/accord"
+	"github.com/Ssawa/accord/accord/logging"
+	"github.com/Ssawa/accord/components"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+const yamlConfig = `
+node_id: edge-1
+data_dir: /var/lib/accord
+log_level: warning
+ordering: causal
+ack_timeout: 2s
+progress_reporting:
+  threshold: 5000
+backlog_alerts:
+  max_queue_depth: 10000
+  max_pending_age: 1h
+disk_quota:
+  max_bytes: 1073741824
+  policy: prune
+  prune_batch: 500
+sync_windows: ["01:00-05:00"]
+peer_sync_windows:
+  hub: ["22:00-06:00", "12:00-13:00"]
+sync_window_zone: UTC
+channels: [config, telemetry]
+queue:
+  max_length: 1000
+  full_policy: block
+  shards: 4
+rate_limit:
+  messages_per_second: 500
+peer_rate_limits:
+  hub:
+    bytes_per_second: 1024
+restart_policies:
+  HTTPSync:
+    mode: limited
+    max_restarts: 3
+    backoff: 5s
+http_sync:
+  bind_address: ":8080"
+  peers:
+    - name: hub
+      url: http://hub:8080
+admin: {}
+`
+
+const tomlConfig = `
+node_id = "edge-1"
+data_dir = "/var/lib/accord"
+log_level = "warning"
+ordering = "causal"
+ack_timeout = "2s"
+channels = ["config", "telemetry"]
+
+[queue]
+max_length = 1000
+full_policy = "block"
+shards = 4
+
+[rate_limit]
+messages_per_second = 500.0
+
+[peer_rate_limits.hub]
+bytes_per_second = 1024.0
+
+[restart_policies.HTTPSync]
+mode = "limited"
+max_restarts = 3
+backoff = "5s"
+
+[http_sync]
+bind_address = ":8080"
+
+[[http_sync.peers]]
+name = "hub"
+url = "http://hub:8080"
+
+[admin]
+`
+
+func TestParse(t *testing.T) {
+	for format, data := range map[Format]string{YAML: yamlConfig, TOML: tomlConfig} {
+		cfg, err := Parse([]byte(data), format)
+		assert.Nil(t, err, string(format))
+
+		assert.Equal(t, "edge-1", cfg.NodeID)
+		assert.Equal(t, "/var/lib/accord", cfg.DataDir)
+		assert.Equal(t, Duration(2*time.Second), cfg.AckTimeout)
+		assert.Equal(t, []string{"config", "telemetry"}, cfg.Channels)
+		assert.Equal(t, Queue{MaxLength: 1000, FullPolicy: "block", Shards: 4}, cfg.Queue)
+		assert.Equal(t, 500.0, cfg.RateLimit.MessagesPerSecond)
+		assert.Equal(t, 1024.0, cfg.PeerRateLimits["hub"].BytesPerSecond)
+		assert.Equal(t, RestartPolicy{Mode: "limited", MaxRestarts: 3, Backoff: Duration(5 * time.Second)}, cfg.RestartPolicies["HTTPSync"])
+		assert.Equal(t, []Peer{{Name: "hub", URL: "http://hub:8080"}}, cfg.HTTPSync.Peers)
+		assert.NotNil(t, cfg.Admin, string(format))
+		assert.Nil(t, cfg.Gossip)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	_, err := Parse([]byte("nod_id: typo"), YAML)
+	assert.NotNil(t, err)
+	_, err = Parse([]byte(`nod_id = "typo"`), TOML)
+	assert.NotNil(t, err)
+	_, err = Parse([]byte("ack_timeout: soon"), YAML)
+	assert.NotNil(t, err)
+	_, err = Parse(nil, Format("ini"))
+	assert.NotNil(t, err)
+
+	cfg, err := Parse(nil, YAML)
+	assert.Nil(t, err)
+	assert.Equal(t, &Config{}, cfg)
+}
+
+func TestApplyEnv(t *testing.T) {
+	env := map[string]string{
+		"TEST_DATA_DIR":                    "/data",
+		"TEST_RELAY":                       "true",
+		"TEST_QUEUE_MAX_BYTES":             "4096",
+		"TEST_RATE_LIMIT_BYTES_PER_SECOND": "10.5",
+		"TEST_PEER_POLICY_ALLOW":           "10.0.0.0/8, hub",
+		"TEST_HTTP_SYNC_PEERS":             "hub=http://hub:8080,edge=http://edge:8080",
+		"TEST_ADMIN_BIND_ADDRESS":          ":7070",
+		"TEST_WEBHOOK_SECRET":              "s3cret",
+	}
+	for name, value := range env {
+		os.Setenv(name, value)
+		defer os.Unsetenv(name)
+	}
+
+	cfg := &Config{DataDir: "/overridden", HTTPSync: &HTTPSync{BatchSize: 10}}
+	assert.Nil(t, cfg.ApplyEnv("TEST"))
+	assert.Equal(t, "/data", cfg.DataDir)
+	assert.True(t, cfg.Relay)
+	assert.Equal(t, int64(4096), cfg.Queue.MaxBytes)
+	assert.Equal(t, 10.5, cfg.RateLimit.BytesPerSecond)
+	assert.Equal(t, []string{"10.0.0.0/8", "hub"}, cfg.PeerPolicy.Allow)
+	assert.Equal(t, 10, cfg.HTTPSync.BatchSize)
+	assert.Equal(t, []Peer{{Name: "hub", URL: "http://hub:8080"}, {Name: "edge", URL: "http://edge:8080"}}, cfg.HTTPSync.Peers)
+
+	// Overriding a Component that isn't configured sets it up, but the rest are left alone
+	assert.Equal(t, &Admin{BindAddress: ":7070"}, cfg.Admin)
+	assert.Equal(t, "s3cret", cfg.Webhook.Secret)
+	assert.Nil(t, cfg.Gossip)
+
+	os.Setenv("TEST_QUEUE_MAX_LENGTH", "lots")
+	defer os.Unsetenv("TEST_QUEUE_MAX_LENGTH")
+	assert.NotNil(t, cfg.ApplyEnv("TEST"))
+}
+
+func TestBuild(t *testing.T) {
+	cfg, err := Parse([]byte(yamlConfig), YAML)
+	assert.Nil(t, err)
+
+	acc, err := cfg.Build(accord.NewDummerManager())
+	assert.Nil(t, err)
+	assert.Equal(t, "edge-1", acc.NodeID)
+	assert.Equal(t, logrus.WarnLevel, acc.Logger.(*logging.Logrus).Entry().Logger.Level)
+	assert.Equal(t, accord.OrderCausal, acc.Ordering)
+	assert.Equal(t, 2*time.Second, acc.AckTimeout)
+	assert.Equal(t, accord.ProgressReporting{Threshold: 5000}, acc.ProgressReporting)
+	assert.Equal(t, accord.BacklogAlerts{MaxQueueDepth: 10000, MaxPendingAge: time.Hour}, acc.BacklogAlerts)
+	assert.Equal(t, accord.DiskQuota{MaxBytes: 1 << 30, Policy: accord.DiskQuotaPrune, PruneBatch: 500}, acc.DiskQuota)
+	assert.Equal(t, []accord.SyncWindow{{Start: time.Hour, End: 5 * time.Hour, Location: time.UTC}}, acc.SyncWindows)
+	assert.Equal(t, accord.QueueFullBlock, acc.QueueFullPolicy)
+	assert.Equal(t, 4, acc.QueueShards)
+	assert.Equal(t, accord.RateLimit{MessagesPerSecond: 500}, acc.RateLimit)
+	assert.Equal(t, accord.RestartLimited, acc.RestartPolicies["HTTPSync"].Mode)
+	assert.Equal(t, map[string]accord.Manager{"config": nil, "telemetry": nil}, acc.Channels)
+	assert.Equal(t, accord.SyncNever, acc.QueueSyncMode)
+	assert.Nil(t, acc.OnReload)
+
+	cfg.StateSync = "always"
+	acc, err = cfg.Build(accord.NewDummerManager())
+	assert.Nil(t, err)
+	assert.Equal(t, accord.SyncAlways, acc.StateSyncMode)
+
+	cfg.QueueSync = "sometimes"
+	_, err = cfg.Build(accord.NewDummerManager())
+	assert.NotNil(t, err)
+
+	cfg.QueueSync = ""
+	cfg.Filters = []Filter{{Name: "odd", Action: "shred"}}
+	_, err = cfg.Build(accord.NewDummerManager())
+	assert.NotNil(t, err)
+
+	cfg.Filters = nil
+	cfg.Ordering = "sideways"
+	_, err = cfg.Build(accord.NewDummerManager())
+	assert.NotNil(t, err)
+}
+
+func TestBuildCron(t *testing.T) {
+	cfg, err := Parse([]byte(`
+cron:
+  location: UTC
+  jobs:
+    - name: nightly
+      schedule: "0 0 * * *"
+      type: config.apply
+      payload: reload
+      catch_up: all
+`), YAML)
+	assert.Nil(t, err)
+
+	comps, _, err := cfg.components()
+	assert.Nil(t, err)
+	if assert.Len(t, comps, 1) {
+		cron := comps[0].(*components.Cron)
+		assert.Equal(t, time.UTC, cron.Location)
+		assert.Equal(t, []components.CronJob{{Name: "nightly", Schedule: "0 0 * * *", Type: "config.apply", Payload: []byte("reload"), CatchUp: components.CronCatchUpAll}}, cron.Jobs)
+	}
+
+	cfg.Cron.Jobs[0].CatchUp = "eventually"
+	_, _, err = cfg.components()
+	assert.NotNil(t, err)
+	cfg.Cron.Jobs[0].CatchUp = ""
+	cfg.Cron.Location = "Nowhere/Special"
+	_, _, err = cfg.components()
+	assert.NotNil(t, err)
+}
+
+func TestBuildMessageAPI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "accord-config")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "billing.token")
+	assert.Nil(t, ioutil.WriteFile(tokenFile, []byte("s3cret\n"), 0600))
+
+	cfg, err := Parse([]byte(`
+message_api:
+  bind_address: 127.0.0.1:9000
+  types: [invoice.created]
+  max_messages: 10
+  tokens:
+    - client: billing
+      token_file: `+tokenFile+`
+`), YAML)
+	assert.Nil(t, err)
+
+	comps, _, err := cfg.components()
+	assert.Nil(t, err)
+	if assert.Len(t, comps, 1) {
+		api := comps[0].(*components.MessageAPI)
+		assert.Equal(t, "127.0.0.1:9000", api.BindAddress)
+		assert.Equal(t, []string{"invoice.created"}, api.Types)
+		assert.Equal(t, 10, api.MaxMessages)
+		assert.Equal(t, []components.MessageAPIToken{{Client: "billing", Token: "s3cret"}}, api.Tokens)
+	}
+
+	cfg.MessageAPI.Tokens[0].TokenFile = filepath.Join(dir, "missing.token")
+	_, _, err = cfg.components()
+	assert.NotNil(t, err)
+}
+
+func TestBuildGRPC(t *testing.T) {
+	dir, err := ioutil.TempDir("", "accord-config")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "sidecar.token")
+	assert.Nil(t, ioutil.WriteFile(tokenFile, []byte("s3cret\n"), 0600))
+
+	cfg, err := Parse([]byte(`
+[grpc]
+bind_address = "127.0.0.1:9001"
+max_messages = 5
+
+[[grpc.tokens]]
+client = "sidecar"
+token_file = "`+tokenFile+`"
+`), TOML)
+	assert.Nil(t, err)
+
+	comps, _, err := cfg.components()
+	assert.Nil(t, err)
+	if assert.Len(t, comps, 1) {
+		service := comps[0].(*components.GRPCService)
+		assert.Equal(t, "127.0.0.1:9001", service.BindAddress)
+		assert.Equal(t, 5, service.MaxMessages)
+		assert.Equal(t, []components.MessageAPIToken{{Client: "sidecar", Token: "s3cret"}}, service.Tokens)
+	}
+}
+
+func TestBuildAndReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "accord-config")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "accord.toml")
+	write := func(config string) {
+		assert.Nil(t, ioutil.WriteFile(path, []byte(config), 0644))
+	}
+	write(`
+data_dir = "` + filepath.Join(dir, "data") + `"
+
+[http_sync]
+bind_address = "127.0.0.1:0"
+peers = [{name = "first", url = "http://127.0.0.1:1"}]
+`)
+
+	cfg, err := Load(path)
+	assert.Nil(t, err)
+	acc, err := cfg.Build(accord.NewDummerManager())
+	assert.Nil(t, err)
+	assert.Nil(t, acc.Start())
+	defer acc.Stop()
+	assert.Equal(t, "HTTPSync", acc.Components()[0].Name)
+
+	write(`
+data_dir = "` + filepath.Join(dir, "data") + `"
+log_level = "debug"
+
+[log_sampling]
+first = 1
+interval = "1m"
+
+[http_sync]
+bind_address = "127.0.0.1:0"
+peers = [{name = "second", url = "http://127.0.0.1:2"}]
+
+[[filters]]
+name = "no deletes"
+action = "quarantine"
+types = ["user.delete"]
+
+[acl]
+default = ["reading.*"]
+origins = {hub = ["*"]}
+`)
+	assert.Nil(t, acc.Reload())
+	assert.Equal(t, logrus.DebugLevel, acc.Logger.(*logging.Logrus).Entry().Logger.Level)
+	assert.Equal(t, accord.LogSampling{First: 1, Interval: time.Minute}, acc.LogSampling)
+	assert.Equal(t, []accord.FilterRule{{Name: "no deletes", Action: accord.FilterQuarantine, Types: []string{"user.delete"}}}, acc.Filters)
+	assert.Equal(t, accord.ACL{Origins: map[string][]string{"hub": {"*"}}, Default: []string{"reading.*"}}, acc.ACL)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && !(len(acc.Peers()) == 1 && acc.Peers()[0] == "second") {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, []string{"second"}, acc.Peers())
+
+	write("log_level = \"loud\"")
+	assert.NotNil(t, acc.Reload())
+}
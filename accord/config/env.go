@@ -0,0 +1,143 @@
+package config
+
+import (
+	"encoding"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix is the prefix Load looks for overrides under
+const EnvPrefix = "ACCORD"
+
+// ApplyEnv overrides our settings with any environment variables named after them: the prefix followed by
+// the path to the setting in upper case, like ACCORD_DATA_DIR, ACCORD_QUEUE_MAX_LENGTH or
+// ACCORD_HTTP_SYNC_BATCH_SIZE. Lists are comma separated, and maps (like RestartPolicies) and Filters can
+// only be set in the file. Overriding a setting of a Component that isn't in the file sets that Component up
+func (cfg *Config) ApplyEnv(prefix string) error {
+	return applyEnv(prefix, reflect.ValueOf(cfg).Elem())
+}
+
+// applyEnv overrides the fields of a struct, recursing into any nested ones
+func applyEnv(prefix string, value reflect.Value) error {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		tag := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if field.PkgPath != "" || tag == "" {
+			continue
+		}
+		name := prefix + "_" + strings.ToUpper(tag)
+		fieldValue := value.Field(i)
+
+		switch {
+		case field.Type.Kind() == reflect.Map, field.Type == reflect.TypeOf([]Filter{}), field.Type == reflect.TypeOf([]AcceptedToken{}):
+			continue
+
+		case field.Type.Kind() == reflect.Struct:
+			err := applyEnv(name, fieldValue)
+			if err != nil {
+				return err
+			}
+
+		case field.Type.Kind() == reflect.Ptr && field.Type.Elem().Kind() == reflect.Struct:
+			if fieldValue.IsNil() {
+				if !envHasPrefix(name + "_") {
+					continue
+				}
+				fieldValue.Set(reflect.New(field.Type.Elem()))
+			}
+			err := applyEnv(name, fieldValue.Elem())
+			if err != nil {
+				return err
+			}
+
+		default:
+			text, ok := os.LookupEnv(name)
+			if !ok {
+				continue
+			}
+			err := setFromText(fieldValue, text)
+			if err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// envHasPrefix tells us if any environment variable starts with prefix
+func envHasPrefix(prefix string) bool {
+	for _, env := range os.Environ() {
+		if strings.HasPrefix(env, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// setFromText parses a setting out of an environment variable
+func setFromText(value reflect.Value, text string) error {
+	if unmarshaler, ok := value.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return unmarshaler.UnmarshalText([]byte(text))
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(text)
+
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(text)
+		if err != nil {
+			return err
+		}
+		value.SetBool(parsed)
+
+	case reflect.Int, reflect.Int64:
+		parsed, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return err
+		}
+		value.SetInt(parsed)
+
+	case reflect.Float64:
+		parsed, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return err
+		}
+		value.SetFloat(parsed)
+
+	case reflect.Slice:
+		items := []string{}
+		for _, item := range strings.Split(text, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		slice := reflect.MakeSlice(value.Type(), len(items), len(items))
+		for i, item := range items {
+			err := setFromText(slice.Index(i), item)
+			if err != nil {
+				return err
+			}
+		}
+		value.Set(slice)
+
+	case reflect.Struct:
+		// The only struct we have in a list is a Peer, written as "name=url"
+		peer, ok := value.Addr().Interface().(*Peer)
+		if !ok {
+			return fmt.Errorf("unable to set a %s from the environment", value.Type())
+		}
+		parts := strings.SplitN(text, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("peers should be written as name=url, not %q", text)
+		}
+		peer.Name, peer.URL = parts[0], parts[1]
+
+	default:
+		return fmt.Errorf("unable to set a %s from the environment", value.Type())
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package accord
+
+import (
+	"context"
+	"strconv"
+)
+
+// causeContextKey is the context key WithCause records the causing Message under
+type causeContextKey struct{}
+
+// WithCause returns a context recording that whatever is done with it was caused by msg. ContextManagers
+// are handed one in ProcessContext, so a Message they create and pass to HandleNewMessageContext with it
+// is automatically part of the same chain of operations as the one they're processing.
+//
+// That Message is still being processed until ProcessContext returns, and new ones can't be handled until
+// it is, so hand them off to a goroutine, with context.WithoutCancel so that they aren't cancelled along
+// with the context they came from
+func WithCause(ctx context.Context, msg *Message) context.Context {
+	return context.WithValue(ctx, causeContextKey{}, msg)
+}
+
+// CauseFromContext returns the Message recorded in ctx by WithCause, if there is one
+func CauseFromContext(ctx context.Context) (*Message, bool) {
+	msg, ok := ctx.Value(causeContextKey{}).(*Message)
+	return msg, ok && msg != nil
+}
+
+// CausedBy makes the Message the next link in cause's chain of operations. Managers that aren't
+// ContextManagers can use it to do what WithCause does for them
+func (msg *Message) CausedBy(cause *Message) {
+	msg.CorrelationID = cause.CorrelationID
+	if msg.CorrelationID == "" {
+		msg.CorrelationID = strconv.FormatUint(cause.ID, 10)
+	}
+	msg.CausationID = cause.ID
+}
+
+// correlate fills in a new Message's CorrelationID and CausationID, unless the application already did
+func correlate(ctx context.Context, msg *Message) {
+	if msg.CorrelationID != "" {
+		return
+	}
+	if cause, ok := CauseFromContext(ctx); ok {
+		msg.CausedBy(cause)
+		return
+	}
+	msg.CorrelationID = strconv.FormatUint(msg.ID, 10)
+}
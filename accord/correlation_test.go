@@ -0,0 +1,75 @@
+package accord
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/beeker1121/goque"
+	"github.com/stretchr/testify/assert"
+)
+
+// followingManager creates a "shipment" Message for every "order" Message it processes, the way a
+// ContextManager is meant to
+type followingManager struct {
+	accord   *Accord
+	followed chan *Message
+}
+
+func (manager *followingManager) ProcessContext(ctx context.Context, msg *Message, fromRemote bool) error {
+	if msg.Type != "order" {
+		return nil
+	}
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		shipment, _ := NewMessage([]byte("ship it"))
+		shipment.Type = "shipment"
+		manager.accord.HandleNewMessageContext(ctx, shipment)
+		manager.followed <- shipment
+	}()
+	return nil
+}
+
+func (manager *followingManager) ShouldProcessContext(ctx context.Context, msg Message, history *goque.Stack) bool {
+	return true
+}
+
+func TestCorrelation(t *testing.T) {
+	defer AccordCleanup()
+	manager := &followingManager{followed: make(chan *Message, 1)}
+	accord := DummyAccord()
+	accord.manager = AdaptContextManager(manager)
+	manager.accord = accord
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	// Messages caused by nothing start chains of their own
+	msg, _ := NewMessage([]byte("hello"))
+	assert.Nil(t, accord.HandleNewMessage(msg))
+	assert.Equal(t, strconv.FormatUint(msg.ID, 10), msg.CorrelationID)
+	assert.Equal(t, uint64(0), msg.CausationID)
+
+	// A remote Message's chain is carried on by the ones created while it's processed
+	order, _ := NewMessage([]byte("order"))
+	order.Type = "order"
+	order.Origin = "shop"
+	order.CorrelationID = "checkout-42"
+	assert.Nil(t, accord.HandleRemoteMessage(order))
+	shipment := <-manager.followed
+	assert.Equal(t, "checkout-42", shipment.CorrelationID)
+	assert.Equal(t, order.ID, shipment.CausationID)
+
+	iter := accord.History().Query(HistoryFilter{CorrelationID: "checkout-42"})
+	found := []uint64{}
+	for iter.Next() {
+		found = append(found, iter.Message().ID)
+	}
+	assert.Nil(t, iter.Err())
+	assert.Equal(t, []uint64{shipment.ID, order.ID}, found)
+
+	// Managers without a context can correlate Messages themselves
+	reply, _ := NewMessage([]byte("reply"))
+	reply.CausedBy(msg)
+	assert.Equal(t, msg.CorrelationID, reply.CorrelationID)
+	assert.Equal(t, msg.ID, reply.CausationID)
+}
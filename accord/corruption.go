@@ -0,0 +1,164 @@
+package accord
+
+import (
+	"errors"
+	"fmt"
+	"path"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	leveldbErrors "github.com/syndtr/goleveldb/leveldb/errors"
+)
+
+// EventRepaired is emitted for each of our stores we repair while opening our data directory
+const EventRepaired = "repaired"
+
+// openKey is set in our state database while our data directory is open, so that finding it there when
+// we open it tells us we weren't stopped cleanly the last time
+const openKey = "open"
+
+// errDigestMismatch is what's wrong with a state whose digest doesn't match our history
+var errDigestMismatch = errors.New("the state digest does not match our history")
+
+// ErrCorrupted is returned by Open (and so by Start) when one of our stores is damaged, which generally
+// happens when the machine goes down while we're writing to it. Unless AutoRepair is set, we refuse to
+// start rather than carry on with data we can't trust; Repair fixes what it can once the cause has been
+// looked into
+type ErrCorrupted struct {
+	// Store is the path of the damaged store
+	Store string
+	Err   error
+}
+
+func (err *ErrCorrupted) Error() string {
+	return fmt.Sprintf("store %s is corrupted: %s", err.Store, err.Err)
+}
+
+// Unwrap returns what was found to be wrong with the store
+func (err *ErrCorrupted) Unwrap() error {
+	return err.Err
+}
+
+// repairing reports whether we should repair our stores when we find them damaged rather than fail
+func (accord *Accord) repairing() bool {
+	return accord.AutoRepair || accord.repairRequested
+}
+
+// openStore opens one of our LevelDB backed stores using open. If it turns out to be corrupted we either
+// recover what LevelDB can of it and open it again, or return an ErrCorrupted
+func (accord *Accord) openStore(dir string, open func() error) error {
+	err := open()
+	if err == nil || !leveldbErrors.IsCorrupted(err) {
+		return err
+	}
+	if !accord.repairing() {
+		return &ErrCorrupted{Store: dir, Err: err}
+	}
+
+	accord.Logger.WithError(err).WithField("store", dir).Warn("A store is corrupted. Recovering what we can of it")
+	db, recoverErr := leveldb.RecoverFile(dir, nil)
+	if recoverErr != nil {
+		return &ErrCorrupted{Store: dir, Err: recoverErr}
+	}
+	db.Close()
+	accord.repaired(dir, err)
+
+	return open()
+}
+
+// repaired records that we repaired one of our stores
+func (accord *Accord) repaired(dir string, err error) {
+	accord.repairedStores = append(accord.repairedStores, dir)
+	accord.Emit(EventRepaired, "A corrupted store was repaired", map[string]interface{}{"store": dir, "error": err.Error()})
+}
+
+// markOpen records that our data directory is open, returning whether it already was: if so, we weren't
+// stopped cleanly and our stores should be checked
+func (state *State) markOpen() (unclean bool, err error) {
+	unclean, err = state.db.Has([]byte(openKey), nil)
+	if err != nil {
+		return false, err
+	}
+	return unclean, state.db.Put([]byte(openKey), nil, state.writeOptions)
+}
+
+// markClosed records that our data directory was closed cleanly
+func (state *State) markClosed() error {
+	return state.db.Delete([]byte(openKey), state.writeOptions)
+}
+
+// verifyState checks our state against our history, which every Message our state was updated with is
+// recorded in: if we were updated with exactly the Messages in our history, our digests will match. A
+// state that doesn't match is rebuilt from our history if we're repairing
+func (accord *Accord) verifyState() error {
+	statePath := path.Join(accord.dataDir, StateFilename)
+
+	// Whatever was pruned from our history is accounted for separately (see PruneHistory)
+	pruned, stale, err := accord.historyBaseline()
+	if err != nil {
+		return &ErrCorrupted{Store: statePath, Err: err}
+	}
+	digest := pruned.Digest
+	clock := pruned.Clock
+	iter := accord.History().Query(HistoryFilter{})
+	for iter.Next() && (pruned.Through == 0 || iter.currentID > pruned.Through) {
+		digest.add(iter.Message().ID)
+		clock.Merge(iter.Message().Clock)
+	}
+	if iter.Err() != nil {
+		return &ErrCorrupted{Store: path.Join(accord.dataDir, HistoryFilename), Err: iter.Err()}
+	}
+
+	if accord.state.Digest().Root() == digest.Root() {
+		return nil
+	}
+	if !accord.repairing() {
+		return &ErrCorrupted{Store: statePath, Err: errDigestMismatch}
+	}
+
+	accord.Logger.WithField("store", statePath).Warn("Our state does not match our history. Rebuilding it from our history")
+	err = accord.state.rebuild(digest, clock, accord.historyStack.Length()-stale+pruned.Count)
+	if err != nil {
+		return err
+	}
+	accord.repaired(statePath, errDigestMismatch)
+	return nil
+}
+
+// rebuild replaces our counter, digest and clock with ones rebuilt from our history. Which Messages we
+// delivered can't be rebuilt, as skipped and rejected ones aren't in our history, so those are left be
+func (state *State) rebuild(digest Digest, clock VectorClock, applied uint64) error {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	cached := uint64(0)
+	for _, sum := range digest.Sums {
+		cached += sum
+	}
+
+	state.cached = cached
+	state.digest = digest
+	state.clock = clock
+	state.applied = applied
+	state.digestMissing = false
+	err := state.saveToDisk()
+	if err != nil {
+		return err
+	}
+	return state.Flush()
+}
+
+// Repair opens our data directory and repairs whatever is wrong with it: stores LevelDB reports as
+// corrupted are recovered (which can lose whatever was in their damaged parts) and a state that doesn't
+// match our history is rebuilt from it. It returns the paths of the stores it repaired. Like Open, the
+// node must not be running; start it normally once it's been repaired
+func (accord *Accord) Repair() ([]string, error) {
+	accord.repairRequested = true
+	defer func() { accord.repairRequested = false }()
+
+	err := accord.Open()
+	if err != nil {
+		return accord.repairedStores, err
+	}
+	accord.Close()
+	return accord.repairedStores, nil
+}
@@ -0,0 +1,93 @@
+package accord
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// crashWithBadDigest makes it look as if we went down uncleanly, leaving a state behind that doesn't match
+// our history
+func crashWithBadDigest(t *testing.T) {
+	db, err := leveldb.OpenFile(StateFilename, nil)
+	assert.Nil(t, err)
+	digest := Digest{}
+	digest.add(12345)
+	assert.Nil(t, db.Put([]byte(digestKey), digest.marshal(), nil))
+	assert.Nil(t, db.Put([]byte(openKey), nil, nil))
+	assert.Nil(t, db.Close())
+}
+
+func TestStateVerification(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	assert.Nil(t, accord.Start())
+	for i := 0; i < 3; i++ {
+		msg, _ := NewMessage([]byte("hello"))
+		assert.Nil(t, accord.HandleNewMessage(msg))
+	}
+	current, digest := accord.CurrentState(), accord.Digest()
+	accord.Stop()
+
+	// A clean stop leaves nothing to check
+	accord = DummyAccord()
+	assert.Nil(t, accord.Start())
+	accord.Stop()
+
+	crashWithBadDigest(t)
+	accord = DummyAccord()
+	err := accord.Start()
+	corrupted, ok := err.(*ErrCorrupted)
+	assert.True(t, ok)
+	assert.Equal(t, StateFilename, corrupted.Store)
+	assert.Equal(t, errDigestMismatch, corrupted.Err)
+
+	// Failing to start mustn't leave our stores locked
+	repaired, err := accord.Repair()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{StateFilename}, repaired)
+
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+	assert.Equal(t, current, accord.CurrentState())
+	assert.Equal(t, digest, accord.Digest())
+	assert.Equal(t, uint64(3), accord.Clock()[accord.NodeID])
+}
+
+func TestAutoRepair(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	assert.Nil(t, accord.Start())
+	msg, _ := NewMessage([]byte("hello"))
+	assert.Nil(t, accord.HandleNewMessage(msg))
+	accord.Stop()
+
+	crashWithBadDigest(t)
+
+	// Mangle the dead letters' manifest as well, which LevelDB itself reports as corrupted
+	manifests, err := filepath.Glob(filepath.Join(DeadLetterFilename, "MANIFEST-*"))
+	assert.Nil(t, err)
+	assert.Len(t, manifests, 1)
+	assert.Nil(t, ioutil.WriteFile(manifests[0], []byte("not a manifest"), 0644))
+
+	accord = DummyAccord()
+	err = accord.Start()
+	_, ok := err.(*ErrCorrupted)
+	assert.True(t, ok)
+
+	accord = DummyAccord()
+	accord.AutoRepair = true
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+	assert.Equal(t, []string{DeadLetterFilename, StateFilename}, accord.repairedStores)
+	assert.Equal(t, msg.ID, accord.CurrentState())
+
+	kinds := []string{}
+	for _, event := range accord.RecentEvents() {
+		kinds = append(kinds, event.Kind)
+	}
+	assert.Contains(t, kinds, EventRepaired)
+}
@@ -0,0 +1,125 @@
+// Package crdt provides conflict-free replicated data types built on top of Accord. Every operation on one
+// of these types is turned into an accord.Message, and every Message is merged in a way that is commutative
+// and idempotent, so replicas converge on the same value no matter what order (or how many times) they
+// receive the operations in. Applications that model their data this way never need to write any
+// ShouldProcess logic at all.
+//
+// Using them looks something like:
+//
+//	manager := crdt.NewManager()
+//	visits := crdt.NewGCounter()
+//	manager.Register("visits", visits)
+//
+//	node := accord.NewAccord(manager, components, dataDir, logger)
+//	...
+//	msg, err := visits.Increment("visits", node.NodeID, 1)
+//	err = node.HandleNewMessage(msg)
+package crdt
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/Ssawa/accord/accord"
+	"github.com/beeker1121/goque"
+)
+
+// TypePrefix is prepended to the kind of a CRDT to get the Type of the Messages carrying its operations
+const TypePrefix = "crdt."
+
+// CRDT is implemented by every replicated data type in this package
+type CRDT interface {
+	// Kind returns the name of the data type, which is used to tag its Messages
+	Kind() string
+
+	// Apply merges an encoded operation into the data type
+	Apply(op []byte) error
+}
+
+// Manager is an accord.Manager that routes operations to the CRDTs registered with it. The Key of every
+// Message is the name its CRDT was registered under
+type Manager struct {
+	mutex sync.RWMutex
+	crdts map[string]CRDT
+}
+
+// NewManager creates a Manager with nothing registered
+func NewManager() *Manager {
+	return &Manager{crdts: make(map[string]CRDT)}
+}
+
+// Register makes a CRDT available under the given name. The same name has to be used on every node
+func (manager *Manager) Register(name string, value CRDT) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	manager.crdts[name] = value
+}
+
+// Get returns the CRDT registered under the given name, or nil
+func (manager *Manager) Get(name string) CRDT {
+	manager.mutex.RLock()
+	defer manager.mutex.RUnlock()
+	return manager.crdts[name]
+}
+
+// Process implements accord.Manager by merging the operation into its CRDT
+func (manager *Manager) Process(msg *accord.Message, fromRemote bool) error {
+	if !strings.HasPrefix(msg.Type, TypePrefix) {
+		return fmt.Errorf("crdt: message type %q is not a CRDT operation", msg.Type)
+	}
+
+	value := manager.Get(msg.Key)
+	if value == nil {
+		return fmt.Errorf("crdt: no CRDT registered as %q", msg.Key)
+	}
+
+	if TypePrefix+value.Kind() != msg.Type {
+		return fmt.Errorf("crdt: %q is a %s, not a %s", msg.Key, value.Kind(), strings.TrimPrefix(msg.Type, TypePrefix))
+	}
+
+	return value.Apply(msg.Payload)
+}
+
+// ShouldProcess implements accord.Manager. As every operation merges deterministically there's never a
+// conflict to resolve
+func (manager *Manager) ShouldProcess(msg accord.Message, history *goque.Stack) bool {
+	return true
+}
+
+// Restore rebuilds every registered CRDT from the operations stored in a history. As CRDTs live in memory
+// this should be called after Accord has started, before any new operations are created
+func (manager *Manager) Restore(history *accord.History) error {
+	iter := history.Query(accord.HistoryFilter{})
+	for iter.Next() {
+		msg := iter.Message()
+		if !strings.HasPrefix(msg.Type, TypePrefix) || manager.Get(msg.Key) == nil {
+			continue
+		}
+
+		err := manager.Process(msg, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	return iter.Err()
+}
+
+// newOpMessage wraps an operation into a Message
+func newOpMessage(kind string, name string, op interface{}) (*accord.Message, error) {
+	payload, err := json.Marshal(op)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := accord.NewMessage(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	msg.Type = TypePrefix + kind
+	msg.Key = name
+	return msg, nil
+}
@@ -0,0 +1,122 @@
+package crdt
+
+import (
+	"testing"
+
+	"github.com/Ssawa/accord/accord"
+	"github.com/stretchr/testify/assert"
+)
+
+// replicate applies the same messages to a fresh manager in the given order
+func replicate(t *testing.T, manager *Manager, msgs ...*accord.Message) {
+	for _, msg := range msgs {
+		assert.Nil(t, manager.Process(msg, true))
+	}
+}
+
+func TestGCounterConverges(t *testing.T) {
+	counterA, counterB := NewGCounter(), NewGCounter()
+	managerA, managerB := NewManager(), NewManager()
+	managerA.Register("visits", counterA)
+	managerB.Register("visits", counterB)
+
+	inc1, err := counterA.Increment("visits", "a", 2)
+	assert.Nil(t, err)
+	inc2, err := counterA.Increment("visits", "a", 3)
+	assert.Nil(t, err)
+	inc3, err := counterB.Increment("visits", "b", 1)
+	assert.Nil(t, err)
+
+	replicate(t, managerA, inc1, inc2, inc3)
+	replicate(t, managerB, inc3, inc2, inc1, inc2)
+
+	assert.Equal(t, uint64(6), counterA.Value())
+	assert.Equal(t, uint64(6), counterB.Value())
+}
+
+func TestLWWRegisterConverges(t *testing.T) {
+	registerA, registerB := NewLWWRegister(), NewLWWRegister()
+	managerA, managerB := NewManager(), NewManager()
+	managerA.Register("color", registerA)
+	managerB.Register("color", registerB)
+
+	first, _ := registerA.Set("color", "a", []byte("red"), accord.HLCTimestamp{Wall: 1})
+	second, _ := registerB.Set("color", "b", []byte("blue"), accord.HLCTimestamp{Wall: 2})
+	tie, _ := registerA.Set("color", "c", []byte("green"), accord.HLCTimestamp{Wall: 2})
+
+	replicate(t, managerA, first, second, tie)
+	replicate(t, managerB, tie, second, first)
+
+	assert.Equal(t, "green", string(registerA.Get()))
+	assert.Equal(t, "green", string(registerB.Get()))
+}
+
+func TestORSetConverges(t *testing.T) {
+	setA, setB := NewORSet(), NewORSet()
+	managerA, managerB := NewManager(), NewManager()
+	managerA.Register("tags", setA)
+	managerB.Register("tags", setB)
+
+	addX, _ := setA.Add("tags", "x")
+	addY, _ := setA.Add("tags", "y")
+	replicate(t, managerA, addX, addY)
+
+	removeX, err := setA.Remove("tags", "x")
+	assert.Nil(t, err)
+	_, err = setA.Remove("tags", "z")
+	assert.NotNil(t, err)
+
+	// Concurrently with the remove, B adds x again. That add wasn't observed by the remove so it survives
+	addXAgain, _ := setB.Add("tags", "x")
+
+	replicate(t, managerA, removeX, addXAgain)
+	replicate(t, managerB, addXAgain, removeX, addY, addX)
+
+	assert.Equal(t, []string{"x", "y"}, setA.Elements())
+	assert.Equal(t, []string{"x", "y"}, setB.Elements())
+
+	removeAll, _ := setA.Remove("tags", "x")
+	replicate(t, managerA, removeAll)
+	replicate(t, managerB, removeAll)
+	assert.False(t, setA.Contains("x"))
+	assert.False(t, setB.Contains("x"))
+}
+
+func TestManagerErrors(t *testing.T) {
+	manager := NewManager()
+	manager.Register("visits", NewGCounter())
+
+	assert.NotNil(t, manager.Process(&accord.Message{Type: "user.update", Key: "visits"}, false))
+	assert.NotNil(t, manager.Process(&accord.Message{Type: TypePrefix + "gcounter", Key: "missing"}, false))
+	assert.NotNil(t, manager.Process(&accord.Message{Type: TypePrefix + "orset", Key: "visits"}, false))
+}
+
+func TestManagerWithAccord(t *testing.T) {
+	accord.AccordCleanup()
+	defer accord.AccordCleanup()
+
+	manager := NewManager()
+	visits := NewGCounter()
+	manager.Register("visits", visits)
+
+	node := accord.NewAccord(manager, nil, "", accord.NopLogger())
+	node.NodeID = "local"
+	assert.Nil(t, node.Start())
+
+	msg, err := visits.Increment("visits", node.NodeID, 5)
+	assert.Nil(t, err)
+	assert.Nil(t, node.HandleNewMessage(msg))
+	assert.Equal(t, uint64(5), visits.Value())
+	node.Stop()
+
+	// After a restart the counter can be rebuilt from the history
+	manager = NewManager()
+	visits = NewGCounter()
+	manager.Register("visits", visits)
+
+	node = accord.NewAccord(manager, nil, "", accord.NopLogger())
+	assert.Nil(t, node.Start())
+	defer node.Stop()
+	assert.Nil(t, manager.Restore(node.History()))
+	assert.Equal(t, uint64(5), visits.Value())
+}
@@ -0,0 +1,79 @@
+package crdt
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/Ssawa/accord/accord"
+)
+
+// GCounter is a grow-only counter. Each node only ever increases its own count and the value of the
+// counter is the sum of every node's count
+type GCounter struct {
+	mutex  sync.RWMutex
+	counts map[string]uint64
+
+	// issued keeps track of the highest count we've created an operation for, so that two increments
+	// created before either is processed don't overwrite each other
+	issued map[string]uint64
+}
+
+// gcounterOp carries a node's new count. Sending the absolute count rather than the delta is what makes
+// applying the same operation twice harmless
+type gcounterOp struct {
+	Node  string
+	Count uint64
+}
+
+// NewGCounter creates a counter starting at zero
+func NewGCounter() *GCounter {
+	return &GCounter{counts: make(map[string]uint64), issued: make(map[string]uint64)}
+}
+
+// Kind implements CRDT
+func (counter *GCounter) Kind() string {
+	return "gcounter"
+}
+
+// Increment creates the Message that increases the given node's count by delta. The counter itself isn't
+// changed until the Message is processed
+func (counter *GCounter) Increment(name string, node string, delta uint64) (*accord.Message, error) {
+	counter.mutex.Lock()
+	base := counter.counts[node]
+	if counter.issued[node] > base {
+		base = counter.issued[node]
+	}
+	counter.issued[node] = base + delta
+	counter.mutex.Unlock()
+
+	return newOpMessage(counter.Kind(), name, gcounterOp{Node: node, Count: base + delta})
+}
+
+// Apply implements CRDT
+func (counter *GCounter) Apply(data []byte) error {
+	op := gcounterOp{}
+	err := json.Unmarshal(data, &op)
+	if err != nil {
+		return err
+	}
+
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+	if op.Count > counter.counts[op.Node] {
+		counter.counts[op.Node] = op.Count
+	}
+
+	return nil
+}
+
+// Value returns the current total of the counter
+func (counter *GCounter) Value() uint64 {
+	counter.mutex.RLock()
+	defer counter.mutex.RUnlock()
+
+	var total uint64
+	for _, count := range counter.counts {
+		total += count
+	}
+	return total
+}
@@ -0,0 +1,67 @@
+package crdt
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/Ssawa/accord/accord"
+)
+
+// LWWRegister holds a single value, where the write with the latest hybrid logical clock timestamp wins.
+// Ties are broken by the writing node so that every replica picks the same value
+type LWWRegister struct {
+	mutex sync.RWMutex
+	value lwwOp
+}
+
+type lwwOp struct {
+	Value []byte
+	HLC   accord.HLCTimestamp
+	Node  string
+}
+
+// NewLWWRegister creates an empty register
+func NewLWWRegister() *LWWRegister {
+	return &LWWRegister{}
+}
+
+// Kind implements CRDT
+func (register *LWWRegister) Kind() string {
+	return "lwwregister"
+}
+
+// Set creates the Message that writes a new value into the register. The timestamp should come from
+// Accord.Now so that it's ordered correctly against every other write this node has seen
+func (register *LWWRegister) Set(name string, node string, value []byte, at accord.HLCTimestamp) (*accord.Message, error) {
+	return newOpMessage(register.Kind(), name, lwwOp{Value: value, HLC: at, Node: node})
+}
+
+// Apply implements CRDT
+func (register *LWWRegister) Apply(data []byte) error {
+	op := lwwOp{}
+	err := json.Unmarshal(data, &op)
+	if err != nil {
+		return err
+	}
+
+	register.mutex.Lock()
+	defer register.mutex.Unlock()
+
+	switch op.HLC.Compare(register.value.HLC) {
+	case 1:
+		register.value = op
+	case 0:
+		if op.Node > register.value.Node {
+			register.value = op
+		}
+	}
+
+	return nil
+}
+
+// Get returns the current value of the register, which is nil if it was never set
+func (register *LWWRegister) Get() []byte {
+	register.mutex.RLock()
+	defer register.mutex.RUnlock()
+	return register.value.Value
+}
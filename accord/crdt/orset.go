@@ -0,0 +1,121 @@
+package crdt
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/Ssawa/accord/accord"
+)
+
+// ORSet is an observed-remove set. Every add is tagged with a unique identifier and a remove only removes
+// the tags it has observed, so an element that is concurrently added and removed stays in the set (adds
+// win). Elements are strings; encode anything more complicated before adding it
+type ORSet struct {
+	mutex   sync.RWMutex
+	adds    map[string]map[string]bool
+	removed map[string]bool
+}
+
+type orsetOp struct {
+	Remove  bool
+	Element string
+	Tags    []string
+}
+
+// NewORSet creates an empty set
+func NewORSet() *ORSet {
+	return &ORSet{adds: make(map[string]map[string]bool), removed: make(map[string]bool)}
+}
+
+// Kind implements CRDT
+func (set *ORSet) Kind() string {
+	return "orset"
+}
+
+// Add creates the Message that adds an element to the set
+func (set *ORSet) Add(name string, element string) (*accord.Message, error) {
+	tag := make([]byte, 16)
+	_, err := rand.Read(tag)
+	if err != nil {
+		return nil, err
+	}
+
+	return newOpMessage(set.Kind(), name, orsetOp{Element: element, Tags: []string{hex.EncodeToString(tag)}})
+}
+
+// Remove creates the Message that removes an element from the set, as it's currently observed by this
+// replica
+func (set *ORSet) Remove(name string, element string) (*accord.Message, error) {
+	set.mutex.RLock()
+	tags := []string{}
+	for tag := range set.adds[element] {
+		tags = append(tags, tag)
+	}
+	set.mutex.RUnlock()
+
+	if len(tags) == 0 {
+		return nil, errors.New("crdt: element is not in the set")
+	}
+
+	sort.Strings(tags)
+	return newOpMessage(set.Kind(), name, orsetOp{Remove: true, Element: element, Tags: tags})
+}
+
+// Apply implements CRDT
+func (set *ORSet) Apply(data []byte) error {
+	op := orsetOp{}
+	err := json.Unmarshal(data, &op)
+	if err != nil {
+		return err
+	}
+
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+
+	for _, tag := range op.Tags {
+		if op.Remove {
+			set.removed[tag] = true
+			delete(set.adds[op.Element], tag)
+			continue
+		}
+
+		// A remove for this tag may have arrived before its add did
+		if set.removed[tag] {
+			continue
+		}
+		if set.adds[op.Element] == nil {
+			set.adds[op.Element] = make(map[string]bool)
+		}
+		set.adds[op.Element][tag] = true
+	}
+
+	if len(set.adds[op.Element]) == 0 {
+		delete(set.adds, op.Element)
+	}
+
+	return nil
+}
+
+// Contains returns whether an element is currently in the set
+func (set *ORSet) Contains(element string) bool {
+	set.mutex.RLock()
+	defer set.mutex.RUnlock()
+	return len(set.adds[element]) > 0
+}
+
+// Elements returns every element currently in the set, sorted
+func (set *ORSet) Elements() []string {
+	set.mutex.RLock()
+	defer set.mutex.RUnlock()
+
+	elements := make([]string, 0, len(set.adds))
+	for element := range set.adds {
+		elements = append(elements, element)
+	}
+	sort.Strings(elements)
+	return elements
+}
@@ -0,0 +1,69 @@
+package accord
+
+import (
+	"encoding/binary"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// cursorPrefix is where we persist each peer's cursor in the state database. Cursors into channels other
+// than the default one, or into shards other than the first, are saved under their cursorKey
+const cursorPrefix = "cursor/"
+
+// LoadCursor returns the cursor we last saved for a peer: the queue item ID of the next Message it should
+// be sent, which is everything before it having been confirmed. ok is false if we never saved one
+func (state *State) LoadCursor(peer string) (next uint64, ok bool, err error) {
+	val, err := state.db.Get([]byte(cursorPrefix+peer), nil)
+	if err == leveldb.ErrNotFound {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return binary.BigEndian.Uint64(val), true, nil
+}
+
+// SaveCursor persists a peer's cursor, so it picks up where it left off after a restart
+func (state *State) SaveCursor(peer string, next uint64) error {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, next)
+	return state.db.Put([]byte(cursorPrefix+peer), data, state.writeOptions)
+}
+
+// DeleteCursor forgets a peer's cursor
+func (state *State) DeleteCursor(peer string) error {
+	return state.db.Delete([]byte(cursorPrefix+peer), nil)
+}
+
+// moveCursor moves the cursor with the given key (see cursorKey) forward and persists it. The caller must
+// hold the peers mutex
+func (accord *Accord) moveCursor(key string, next uint64) error {
+	if next <= accord.peers.cursors[key] {
+		return nil
+	}
+	accord.peers.cursors[key] = next
+	accord.forgetKnown(key, next)
+	err := accord.state.SaveCursor(key, next)
+	if err != nil {
+		peer, channel, shard := splitCursorKey(key)
+		accord.Logger.WithError(err).WithField("peer", peer).WithField("channel", channel).WithField("shard", shard).Warn("We could not save a peer's cursor")
+	}
+	return err
+}
+
+// PeerLag returns how many queued Messages a peer hasn't confirmed yet across all of our channels, which is
+// how far behind it is.
+// Messages that will be skipped because the peer sent them to us in the first place are included, as are
+// ones that have been shipped to it but not acknowledged (see PeerCursor.Ship)
+func (accord *Accord) PeerLag(name string) (uint64, error) {
+	cursor := accord.Peer(name)
+	if cursor == nil {
+		return 0, ErrUnknownPeer
+	}
+
+	lag := uint64(0)
+	for _, ch := range accord.sortedChannels() {
+		lag += cursor.Channel(ch.name).Pending()
+	}
+	return lag, nil
+}
@@ -0,0 +1,47 @@
+package accord
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeerCursorsAreSaved(t *testing.T) {
+	defer AccordCleanup()
+	accord := historyTestAccord(t)
+
+	edgeA := accord.AddPeer("edge-a")
+	edgeB := accord.AddPeer("edge-b")
+	assert.Nil(t, edgeA.Advance(1))
+	msgs, err := edgeB.Ship(2)
+	assert.Nil(t, err)
+	assert.Nil(t, edgeB.Ack(peerIDs(msgs)...))
+
+	lag, err := accord.PeerLag("edge-a")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), lag)
+	_, err = accord.PeerLag("nobody")
+	assert.Equal(t, ErrUnknownPeer, err)
+	accord.Stop()
+
+	// After a restart each peer resumes from what it had confirmed
+	accord = DummyAccord()
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	msgs, err = accord.AddPeer("edge-a").Peek(10)
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{2, 3, 4}, peerIDs(msgs))
+	msgs, err = accord.AddPeer("edge-b").Peek(10)
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{3, 4}, peerIDs(msgs))
+	lag, err = accord.PeerLag("edge-b")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), lag)
+
+	// A removed peer starts from scratch if it's ever added again
+	assert.Nil(t, accord.RemovePeer("edge-b"))
+	_, saved, err := accord.state.LoadCursor("edge-b")
+	assert.Nil(t, err)
+	assert.False(t, saved)
+}
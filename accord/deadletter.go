@@ -0,0 +1,183 @@
+package accord
+
+import (
+	"bytes"
+	"encoding/gob"
+	"path"
+	"time"
+)
+
+// EventDeadLettered is emitted when a transport gives up on delivering queued Messages to a peer
+const EventDeadLettered = "dead_lettered"
+
+// DeadLetter is a queued Message a transport gave up on delivering to a peer, because the peer refused it
+// in a way that retrying won't fix (or one our own Manager couldn't process, under PanicDeadLetter or
+// because of a ConflictError). Dead letters are kept aside rather than dropped so that an operator can
+// look into them and, once the problem is fixed, requeue them (see RequeueDeadLetters, or accordctl)
+type DeadLetter struct {
+	// Peer is the peer the Message couldn't be delivered to
+	Peer string
+
+	// Reason is why the transport gave up
+	Reason string
+
+	// Time is when the Message was dead lettered
+	Time time.Time
+
+	// Conflict is whether it's a Message our Manager reported a ConflictError for that OnConflict didn't
+	// settle, in which case Reason is the conflict and Peer is our own NodeID
+	Conflict bool
+
+	Message Message
+}
+
+// DeadLetter moves the cursor past the next count Messages like Advance does, but records them as dead
+// letters rather than as delivered. Transports should use this for Messages the peer will never accept,
+// so that they stop holding everything after them up
+func (cursor *PeerCursor) DeadLetter(count int, reason string) error {
+	accord := cursor.accord
+	accord.peers.mutex.Lock()
+	defer accord.peers.mutex.Unlock()
+
+	msgs := []*Message{}
+	next, err := accord.scanForPeer(cursor, count, func(msg *Message) {
+		msgs = append(msgs, msg)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = accord.recordDeadLetters(cursor.name, reason, msgs)
+	if err != nil {
+		return err
+	}
+
+	err = accord.moveCursor(cursor.key(), next)
+	if err != nil {
+		return err
+	}
+	accord.notifyAcks()
+	return accord.trimQueue(cursor.queue())
+}
+
+// recordDeadLetters sets Messages aside as dead letters
+func (accord *Accord) recordDeadLetters(peer string, reason string, msgs []*Message) error {
+	return accord.recordLetters(DeadLetter{Peer: peer, Reason: reason}, msgs)
+}
+
+// recordLetters sets Messages aside as dead letters like the one given, which is filled in with each of
+// them in turn
+func (accord *Accord) recordLetters(letter DeadLetter, msgs []*Message) error {
+	peer, reason := letter.Peer, letter.Reason
+	letter.Time = time.Now().UTC()
+	for _, msg := range msgs {
+		var buf bytes.Buffer
+		letter.Message = *msg
+		err := gob.NewEncoder(&buf).Encode(letter)
+		if err != nil {
+			return err
+		}
+		data, err := accord.sealer.seal(buf.Bytes())
+		if err != nil {
+			return err
+		}
+
+		_, err = accord.deadLetters.Enqueue(data)
+		if err != nil {
+			return accord.failWrite(err, "We could not record a dead letter")
+		}
+	}
+	accord.syncQueueWrite(path.Join(accord.dataDir, DeadLetterFilename))
+
+	accord.Logger.WithField("peer", peer).WithField("count", len(msgs)).WithField("reason", reason).Warn("Dead lettering messages")
+	accord.metrics().Count(MetricDeadLetters, int64(len(msgs)))
+	accord.Emit(EventDeadLettered, "Messages were dead lettered", map[string]interface{}{"peer": peer, "count": len(msgs), "reason": reason})
+	return nil
+}
+
+// DeadLetters returns every dead letter, oldest first
+func (accord *Accord) DeadLetters() ([]DeadLetter, error) {
+	letters := []DeadLetter{}
+	for offset := uint64(0); offset < accord.deadLetters.Length(); offset++ {
+		item, err := accord.deadLetters.PeekByOffset(offset)
+		if err != nil {
+			return nil, err
+		}
+
+		letter, err := accord.decodeDeadLetter(item.Value)
+		if err != nil {
+			return nil, err
+		}
+		letters = append(letters, letter)
+	}
+	return letters, nil
+}
+
+// RequeueDeadLetters puts dead letters back on the end of our synchronization queue, returning how many
+// were requeued. If peer isn't empty only the ones that couldn't be delivered to it are requeued. Every
+// peer is sent a requeued Message again, so peers that already had it will see it as a duplicate.
+//
+// Letters under our own NodeID are Messages our Manager couldn't process (see PanicDeadLetter and
+// ConflictError). Sending them on to our peers wouldn't fix anything, so they're always left where they are
+func (accord *Accord) RequeueDeadLetters(peer string) (int, error) {
+	accord.peers.mutex.Lock()
+	defer accord.peers.mutex.Unlock()
+
+	requeued := 0
+	for remaining := accord.deadLetters.Length(); remaining > 0; remaining-- {
+		item, err := accord.deadLetters.Dequeue()
+		if err != nil {
+			return requeued, err
+		}
+
+		letter, err := accord.decodeDeadLetter(item.Value)
+		if err != nil {
+			return requeued, err
+		}
+
+		// Letters we're leaving alone go to the back of the line, so they come out in the same order
+		if (peer != "" && letter.Peer != peer) || letter.Peer == accord.NodeID {
+			_, err = accord.deadLetters.Enqueue(item.Value)
+			if err != nil {
+				return requeued, err
+			}
+			continue
+		}
+
+		ch := accord.channelFor(letter.Message.Channel)
+		if ch == nil {
+			accord.Logger.WithField("channel", letter.Message.Channel).Warn("Leaving a dead letter on a channel we no longer have where it is")
+			_, err = accord.deadLetters.Enqueue(item.Value)
+			if err != nil {
+				return requeued, err
+			}
+			continue
+		}
+
+		data, err := accord.sealer.encodeMessage(&letter.Message)
+		if err != nil {
+			return requeued, err
+		}
+		_, err = accord.enqueue(accord.shardFor(ch, &letter.Message), data)
+		if err != nil {
+			return requeued, err
+		}
+		requeued++
+	}
+
+	if requeued > 0 {
+		accord.Logger.WithField("peer", peer).WithField("count", requeued).Info("Requeued dead letters")
+	}
+	return requeued, nil
+}
+
+// decodeDeadLetter decrypts and decodes a dead letter we stored
+func (accord *Accord) decodeDeadLetter(data []byte) (DeadLetter, error) {
+	letter := DeadLetter{}
+	data, err := accord.sealer.open(data)
+	if err != nil {
+		return letter, err
+	}
+	err = gob.NewDecoder(bytes.NewReader(data)).Decode(&letter)
+	return letter, err
+}
@@ -0,0 +1,52 @@
+package accord
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeadLetters(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	assert.Nil(t, accord.Start())
+
+	peerA := accord.AddPeer("a")
+	peerB := accord.AddPeer("b")
+	for _, payload := range []string{"one", "two", "three"} {
+		msg, _ := NewMessage([]byte(payload))
+		assert.Nil(t, accord.HandleNewMessage(msg))
+	}
+
+	assert.Nil(t, peerA.Advance(1))
+	assert.Nil(t, peerA.DeadLetter(1, "refused"))
+	assert.Nil(t, peerB.DeadLetter(1, "also refused"))
+	assert.Equal(t, uint64(1), peerA.Pending())
+
+	letters, err := accord.DeadLetters()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(letters))
+	assert.Equal(t, "a", letters[0].Peer)
+	assert.Equal(t, "two", string(letters[0].Message.Payload))
+	assert.Equal(t, "refused", letters[0].Reason)
+	assert.Equal(t, "one", string(letters[1].Message.Payload))
+
+	// Dead letters survive restarts, and can be requeued offline
+	accord.Stop()
+	accord = DummyAccord()
+	assert.Nil(t, accord.Open())
+	defer accord.Close()
+
+	requeued, err := accord.RequeueDeadLetters("a")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, requeued)
+
+	letters, err = accord.DeadLetters()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(letters))
+	assert.Equal(t, "b", letters[0].Peer)
+
+	last, err := accord.Queue().Get(accord.Queue().Len() - 1)
+	assert.Nil(t, err)
+	assert.Equal(t, "two", string(last.Payload))
+}
@@ -0,0 +1,54 @@
+package accord
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// EventProcessTimeout is emitted when our Manager takes longer than ProcessTimeout to process a Message
+const EventProcessTimeout = "process_timeout"
+
+// ErrProcessTimeout is what a Message fails with when our Manager takes longer than ProcessTimeout to
+// process it
+var ErrProcessTimeout = errors.New("the Manager took too long to process a message")
+
+// processWithin calls process, giving up on it once ProcessTimeout has passed. A ContextManager's context
+// is cancelled when that happens, but a Manager that doesn't notice is left to finish in the background
+// while we move on without it, so that a single stuck handler can't hold up every Message behind it
+func (accord *Accord) processWithin(ctx context.Context, msg *Message, fromRemote bool) error {
+	if accord.ProcessTimeout <= 0 {
+		return accord.process(ctx, msg, fromRemote)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, accord.ProcessTimeout)
+	defer cancel()
+
+	result := make(chan error, 1)
+	go func() {
+		result <- accord.process(ctx, msg, fromRemote)
+	}()
+
+	timer := time.NewTimer(accord.ProcessTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-result:
+		// A ContextManager giving up because its deadline passed is no different from us giving up on it
+		if err != nil && ctx.Err() == context.DeadlineExceeded {
+			return ErrProcessTimeout
+		}
+		return err
+	case <-timer.C:
+		return ErrProcessTimeout
+	}
+}
+
+// handleProcessTimeout deals with our Manager taking too long over a Message according to our
+// PanicPolicy, just as if it had panicked, returning the error the Message should be failed with
+func (accord *Accord) handleProcessTimeout(msg *Message) error {
+	WithMessage(accord.Logger, msg).WithField("timeout", accord.ProcessTimeout).Warn("The manager took too long to process a message")
+	accord.metrics().Count(MetricProcessTimeouts, 1)
+	accord.recordError(ErrProcessTimeout)
+	accord.Emit(EventProcessTimeout, "The manager took too long to process a message", map[string]interface{}{"id": msg.ID, "origin": msg.Origin, "timeout": accord.ProcessTimeout.String()})
+	return accord.failManager(msg, ErrProcessTimeout)
+}
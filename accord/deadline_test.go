@@ -0,0 +1,89 @@
+package accord
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/beeker1121/goque"
+	"github.com/stretchr/testify/assert"
+)
+
+// stuckManager never finishes processing a Message whose payload is "stuck" until it's released, taking no
+// notice of its context
+type stuckManager struct {
+	release chan struct{}
+}
+
+func (manager stuckManager) Process(msg *Message, fromRemote bool) error {
+	if string(msg.Payload) == "stuck" {
+		<-manager.release
+	}
+	return nil
+}
+
+func (manager stuckManager) ShouldProcess(msg Message, history *goque.Stack) bool {
+	return true
+}
+
+// deadlineManager gives up as soon as its context is done
+type deadlineManager struct{}
+
+func (deadlineManager) ProcessContext(ctx context.Context, msg *Message, fromRemote bool) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (deadlineManager) ShouldProcessContext(ctx context.Context, msg Message, history *goque.Stack) bool {
+	return true
+}
+
+func TestProcessTimeout(t *testing.T) {
+	defer AccordCleanup()
+	manager := stuckManager{release: make(chan struct{})}
+	defer close(manager.release)
+	accord := NewAccord(manager, nil, "", DummyAccord().Logger)
+	accord.NodeID = "local"
+	accord.PanicPolicy = PanicDeadLetter
+	accord.ProcessTimeout = 10 * time.Millisecond
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	msg, _ := NewMessage([]byte("stuck"))
+	err := accord.HandleNewMessage(msg)
+	rejected, ok := err.(*StageError)
+	if assert.True(t, ok) {
+		assert.Equal(t, "manager", rejected.Stage)
+		assert.Equal(t, ErrProcessTimeout, rejected.Err)
+	}
+
+	// We carry on without it, and it's set aside for an operator
+	fine, _ := NewMessage([]byte("fine"))
+	assert.Nil(t, accord.HandleNewMessage(fine))
+	assert.Equal(t, uint64(1), accord.History().Len())
+
+	letters, err := accord.DeadLetters()
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(letters)) {
+		assert.Equal(t, "stuck", string(letters[0].Message.Payload))
+	}
+}
+
+func TestProcessTimeoutContext(t *testing.T) {
+	defer AccordCleanup()
+	accord := NewAccord(AdaptContextManager(deadlineManager{}), nil, "", DummyAccord().Logger)
+	accord.NodeID = "local"
+	accord.PanicPolicy = PanicDeadLetter
+	accord.ProcessTimeout = 10 * time.Millisecond
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	remote, _ := NewMessage([]byte("slow"))
+	remote.Origin = "remote"
+	remote.Clock = VectorClock{"remote": 1}
+	rejected, ok := accord.HandleRemoteMessage(remote).(*StageError)
+	if assert.True(t, ok) {
+		assert.Equal(t, ErrProcessTimeout, rejected.Err)
+	}
+	assert.Equal(t, uint64(1), accord.state.Delivered("remote"))
+}
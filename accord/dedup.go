@@ -0,0 +1,132 @@
+package accord
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Where we keep the idempotency keys we've seen in the state database. dedupPrefix maps each key to when
+// we saw it, and dedupAtPrefix lists them again in the order we saw them so old ones can be pruned cheaply
+const (
+	dedupPrefix   = "dedup/"
+	dedupAtPrefix = "dedupat/"
+)
+
+// DefaultDedupWindow is how long we remember idempotency keys for when DedupWindow isn't set
+const DefaultDedupWindow = 24 * time.Hour
+
+// dedupPruneInterval is how often we forget the idempotency keys that have fallen out of our window
+const dedupPruneInterval = time.Minute
+
+// ErrDuplicate is returned by HandleNewMessage for a Message whose IdempotencyKey we've already seen within
+// our DedupWindow. The Message it duplicates was already performed, so a client retrying an operation can
+// treat it as a success
+var ErrDuplicate = errors.New("a message with the same idempotency key was already handled")
+
+// dedupWindow returns how long we remember idempotency keys for, or 0 if we don't deduplicate at all
+func (accord *Accord) dedupWindow() time.Duration {
+	if accord.DedupWindow < 0 {
+		return 0
+	}
+	if accord.DedupWindow == 0 {
+		return DefaultDedupWindow
+	}
+	return accord.DedupWindow
+}
+
+// isDuplicate reports whether we've already handled a Message with the same IdempotencyKey within our
+// window. Every so often it also forgets the keys that have fallen out of it. The caller must hold our
+// processMutex
+func (accord *Accord) isDuplicate(msg *Message) (bool, error) {
+	window := accord.state.dedupWindow
+	if window == 0 || msg.IdempotencyKey == "" {
+		return false, nil
+	}
+
+	if time.Since(accord.dedupPrunedAt) >= dedupPruneInterval {
+		accord.dedupPrunedAt = time.Now()
+		pruned, err := accord.state.PruneIdempotencyKeys(time.Now().Add(-window))
+		if err != nil {
+			accord.Logger.WithError(err).Warn("We could not forget old idempotency keys")
+		} else if pruned > 0 {
+			accord.Logger.WithField("pruned", pruned).Debug("Forgot old idempotency keys")
+		}
+	}
+
+	seen, err := accord.state.Seen(msg.IdempotencyKey, window)
+	if err != nil {
+		WithMessage(accord.Logger, msg).WithError(err).Warn("We could not check a message's idempotency key")
+		return false, err
+	}
+	if seen {
+		accord.metrics().Count(MetricMessagesDuplicate, 1)
+	}
+	return seen, nil
+}
+
+// Seen reports whether a Message with the given idempotency key was delivered within the window
+func (state *State) Seen(key string, window time.Duration) (bool, error) {
+	val, err := state.get([]byte(dedupPrefix + key))
+	if err == leveldb.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	seenAt := time.Unix(0, int64(binary.BigEndian.Uint64(val)))
+	return time.Since(seenAt) < window, nil
+}
+
+// rememberIdempotencyKey adds a delivered Message's idempotency key to the batch, unless it's already
+// remembered. The caller must hold our mutex
+func (state *State) rememberIdempotencyKey(msg *Message, batch *leveldb.Batch) error {
+	if state.dedupWindow == 0 || msg.IdempotencyKey == "" {
+		return nil
+	}
+
+	seen, err := state.Seen(msg.IdempotencyKey, state.dedupWindow)
+	if err != nil || seen {
+		return err
+	}
+
+	now := make([]byte, 8)
+	binary.BigEndian.PutUint64(now, uint64(time.Now().UnixNano()))
+	batch.Put([]byte(dedupPrefix+msg.IdempotencyKey), now)
+	batch.Put(append(append([]byte(dedupAtPrefix), now...), msg.IdempotencyKey...), nil)
+	return nil
+}
+
+// PruneIdempotencyKeys forgets every idempotency key that was seen before the given time, returning how
+// many were forgotten
+func (state *State) PruneIdempotencyKeys(before time.Time) (int, error) {
+	batch := new(leveldb.Batch)
+	pruned := 0
+
+	iter := state.db.NewIterator(util.BytesPrefix([]byte(dedupAtPrefix)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		entry := iter.Key()[len(dedupAtPrefix):]
+		seenAt := entry[:8]
+		if time.Unix(0, int64(binary.BigEndian.Uint64(seenAt))).After(before) {
+			break
+		}
+
+		// The key may have been seen again since, in which case it's only this listing that's stale
+		key := []byte(dedupPrefix + string(entry[8:]))
+		val, err := state.get(key)
+		if err == nil && string(val) == string(seenAt) {
+			batch.Delete(key)
+			pruned++
+		}
+		batch.Delete(append([]byte{}, iter.Key()...))
+	}
+	if iter.Error() != nil {
+		return 0, iter.Error()
+	}
+
+	return pruned, state.db.Write(batch, nil)
+}
@@ -0,0 +1,101 @@
+package accord
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDedupNewMessages(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	assert.Nil(t, accord.Start())
+
+	first, _ := NewMessage([]byte("charge card"))
+	first.IdempotencyKey = "order-1"
+	assert.Nil(t, accord.HandleNewMessage(first))
+
+	// A retry creates a brand new Message for the same operation
+	retry, _ := NewMessage([]byte("charge card again"))
+	retry.IdempotencyKey = "order-1"
+	assert.Equal(t, ErrDuplicate, accord.HandleNewMessage(retry))
+
+	other, _ := NewMessage([]byte("charge another card"))
+	other.IdempotencyKey = "order-2"
+	assert.Nil(t, accord.HandleNewMessage(other))
+	assert.Equal(t, uint64(2), accord.History().Len())
+
+	// Keys are remembered across restarts
+	accord.Stop()
+	accord = DummyAccord()
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+	assert.Equal(t, ErrDuplicate, accord.HandleNewMessage(retry))
+}
+
+func TestDedupRemoteMessages(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	accord.Ordering = OrderFIFO
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	remote := func(seq uint64, key string) *Message {
+		msg, _ := NewMessage([]byte(key))
+		msg.Origin = "remote"
+		msg.Clock = VectorClock{"remote": seq}
+		msg.IdempotencyKey = key
+		return msg
+	}
+
+	assert.Nil(t, accord.HandleRemoteMessage(remote(1, "a")))
+	assert.Nil(t, accord.HandleRemoteMessage(remote(2, "a")))
+	assert.Equal(t, uint64(1), accord.History().Len())
+
+	// The dropped Message still counts as delivered, so the ones after it aren't held back
+	assert.Equal(t, uint64(2), accord.state.Delivered("remote"))
+	assert.Nil(t, accord.HandleRemoteMessage(remote(3, "b")))
+	assert.Equal(t, uint64(2), accord.History().Len())
+	assert.Equal(t, 0, accord.HeldBack())
+}
+
+func TestDedupWindow(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	accord.DedupWindow = 10 * time.Millisecond
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	msg, _ := NewMessage([]byte("once"))
+	msg.IdempotencyKey = "key"
+	assert.Nil(t, accord.HandleNewMessage(msg))
+
+	time.Sleep(20 * time.Millisecond)
+	seen, err := accord.state.Seen("key", accord.DedupWindow)
+	assert.Nil(t, err)
+	assert.False(t, seen)
+
+	pruned, err := accord.state.PruneIdempotencyKeys(time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, pruned)
+
+	msg, _ = NewMessage([]byte("twice"))
+	msg.IdempotencyKey = "key"
+	assert.Nil(t, accord.HandleNewMessage(msg))
+}
+
+func TestDedupDisabled(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	accord.DedupWindow = -1
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	for _, payload := range []string{"one", "two"} {
+		msg, _ := NewMessage([]byte(payload))
+		msg.IdempotencyKey = "key"
+		assert.Nil(t, accord.HandleNewMessage(msg))
+	}
+	assert.Equal(t, uint64(2), accord.History().Len())
+}
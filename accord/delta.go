@@ -0,0 +1,154 @@
+package accord
+
+import (
+	"sort"
+
+	"github.com/beeker1121/goque"
+)
+
+// deltaBatchSize is how many Message IDs SkipKnown asks a peer about at a time
+const deltaBatchSize = 1000
+
+// SkipKnown passes over the Messages waiting on the cursor that the peer already has, so that a peer coming
+// back after a long time away (or one that was caught up some other way, by AntiEntropy or from a backup)
+// is only sent the ones it's missing rather than our whole backlog.
+//
+// remote is the peer's digest. Messages in buckets where it matches ours are ones the peer has already;
+// for the rest, has is called with batches of Message IDs and should return the ones the peer has (asking
+// the peer, generally). It returns how many Messages will be skipped. Messages queued while it runs are
+// left alone
+func (cursor *PeerCursor) SkipKnown(remote Digest, has func(ids []uint64) ([]uint64, error)) (int, error) {
+	accord := cursor.accord
+	queue := cursor.queue().queue
+
+	accord.peers.mutex.Lock()
+	next, ok := accord.peers.cursors[cursor.key()]
+	accord.peers.mutex.Unlock()
+	if !ok {
+		return 0, ErrUnknownPeer
+	}
+
+	// Everything up to the end of the queue as it is now was performed before we look at our digest, so
+	// our digest covers it. That's what makes it safe to compare against the peer's
+	length := queue.Length()
+	if length == 0 {
+		return 0, nil
+	}
+	tail, err := queue.PeekByOffset(length - 1)
+	if err != nil {
+		return 0, err
+	}
+	comparison := accord.CompareDigest(remote)
+	differs := map[int]bool{}
+	for _, bucket := range comparison.Buckets {
+		differs[bucket] = true
+	}
+
+	known := []uint64{}
+	items := map[uint64]uint64{}
+	asking := []uint64{}
+	ask := func() error {
+		if len(asking) == 0 {
+			return nil
+		}
+		found, err := has(asking)
+		if err != nil {
+			return err
+		}
+		for _, id := range found {
+			if item, ok := items[id]; ok {
+				known = append(known, item)
+			}
+		}
+		items, asking = map[uint64]uint64{}, []uint64{}
+		return nil
+	}
+
+	head, err := queue.Peek()
+	if err == goque.ErrEmpty {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if next < head.ID {
+		next = head.ID
+	}
+	for item := next; item <= tail.ID; item++ {
+		queued, err := queue.PeekByID(item)
+		if err != nil {
+			// Whatever was at the front of the queue has been trimmed in the meantime
+			continue
+		}
+		msg, err := cursor.accord.sealer.decodeMessage(queued.Value)
+		if err != nil {
+			return 0, err
+		}
+		if msg.Origin == cursor.name {
+			// It isn't going to be sent anyway
+			continue
+		}
+
+		if !comparison.Equal && differs[DigestBucket(msg.ID)] {
+			items[msg.ID] = item
+			asking = append(asking, msg.ID)
+			if len(asking) >= deltaBatchSize {
+				err = ask()
+				if err != nil {
+					return 0, err
+				}
+			}
+			continue
+		}
+		known = append(known, item)
+	}
+	err = ask()
+	if err != nil {
+		return 0, err
+	}
+
+	accord.peers.mutex.Lock()
+	defer accord.peers.mutex.Unlock()
+	if accord.peers.known == nil {
+		accord.peers.known = map[string][]uint64{}
+	}
+	merged := append(accord.peers.known[cursor.key()], known...)
+	sort.Slice(merged, func(i, j int) bool { return merged[i] < merged[j] })
+	unique := merged[:0]
+	for i, item := range merged {
+		if i == 0 || item != merged[i-1] {
+			unique = append(unique, item)
+		}
+	}
+	accord.peers.known[cursor.key()] = unique
+
+	accord.Logger.WithField("peer", cursor.name).WithField("channel", cursor.channel).WithField("shard", cursor.shard).WithField("skipped", len(known)).Debug("Skipping messages the peer already has")
+	return len(known), nil
+}
+
+// skipsPeer reports whether a queued Message is never sent to the peer a cursor belongs to, either because
+// it came from the peer in the first place or because the peer is known to have it already (see
+// SkipKnown). The caller must hold the peers mutex
+func (accord *Accord) skipsPeer(cursor *PeerCursor, item uint64, msg *Message) bool {
+	if msg.Origin == cursor.name {
+		return true
+	}
+	known := accord.peers.known[cursor.key()]
+	index := sort.Search(len(known), func(i int) bool { return known[i] >= item })
+	return index < len(known) && known[index] == item
+}
+
+// forgetKnown drops what we know the peer has from before the cursor's position, which it'll never be sent
+// again anyway. The caller must hold the peers mutex
+func (accord *Accord) forgetKnown(key string, next uint64) {
+	known := accord.peers.known[key]
+	if len(known) == 0 {
+		return
+	}
+	index := sort.Search(len(known), func(i int) bool { return known[i] >= next })
+	if index == len(known) {
+		delete(accord.peers.known, key)
+		return
+	}
+	accord.peers.known[key] = known[index:]
+}
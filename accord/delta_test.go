@@ -0,0 +1,54 @@
+package accord
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSkipKnown(t *testing.T) {
+	defer AccordCleanup()
+	accord := historyTestAccord(t)
+	defer accord.Stop()
+
+	// The peer has 2 but not 1 or 4, and it sent us 3 itself. Every one of them falls into the same bucket,
+	// so the peer is asked about the ones that would be sent
+	remote := accord.AddPeer("remote")
+	digest := Digest{}
+	digest.add(2)
+	digest.add(3)
+	asked := []uint64{}
+	skipped, err := remote.SkipKnown(digest, func(ids []uint64) ([]uint64, error) {
+		asked = append(asked, ids...)
+		return []uint64{2}, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, skipped)
+	assert.Equal(t, []uint64{1, 2, 4}, asked)
+
+	msgs, err := remote.Ship(10)
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{1, 4}, peerIDs(msgs))
+	assert.Nil(t, remote.Ack(1, 4))
+	assert.Equal(t, uint64(0), accord.Queue().Len())
+
+	// A peer with the same digest as ours has everything already, so it isn't asked about anything
+	assert.Nil(t, accord.HandleNewMessage(&Message{ID: 5, Type: "user.create"}))
+	digest = accord.Digest()
+	skipped, err = remote.SkipKnown(digest, func(ids []uint64) ([]uint64, error) {
+		assert.Fail(t, "the peer shouldn't be asked")
+		return nil, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, skipped)
+	msgs, err = remote.Ship(10)
+	assert.Nil(t, err)
+	assert.Empty(t, msgs)
+	assert.Equal(t, uint64(0), accord.Queue().Len())
+
+	// Messages queued afterwards are sent as usual
+	assert.Nil(t, accord.HandleNewMessage(&Message{ID: 6, Type: "user.create"}))
+	msgs, err = remote.Ship(10)
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{6}, peerIDs(msgs))
+}
@@ -0,0 +1,145 @@
+package accord
+
+import (
+	"fmt"
+	"io"
+)
+
+// HistoryDiff describes how two histories (generally the exported histories of two separate Accord nodes)
+// have diverged from each other. Both histories are expected to be ordered the way they were applied,
+// oldest message first.
+type HistoryDiff struct {
+	// OnlyLocal contains the Messages that are present in the local history but that the remote never applied
+	OnlyLocal []Message
+
+	// OnlyRemote contains the Messages that are present in the remote history but that we never applied
+	OnlyRemote []Message
+
+	// OrderMismatches lists the places where both sides applied the same Messages, but in a different order
+	OrderMismatches []OrderMismatch
+
+	// FirstChecksumMismatch is the first position (zero indexed) at which the running checksum of the two
+	// histories stopped matching, or -1 if they never did
+	FirstChecksumMismatch int
+}
+
+// OrderMismatch represents a single position, counted only over the Messages both sides have in common,
+// where the two histories applied a different Message
+type OrderMismatch struct {
+	Position int
+	LocalID  uint64
+	RemoteID uint64
+}
+
+// DiffHistories compares two histories and computes everything that differs between them. The checksum
+// we compare is the same cumulative function State uses, which means that two histories containing the
+// same Messages will eventually agree on their checksum even if their apply order differs; the first
+// mismatching position is still useful however for figuring out *when* the two nodes started to drift
+func DiffHistories(local, remote []Message) *HistoryDiff {
+	diff := &HistoryDiff{FirstChecksumMismatch: -1}
+
+	localIDs := make(map[uint64]bool, len(local))
+	for _, msg := range local {
+		localIDs[msg.ID] = true
+	}
+
+	remoteIDs := make(map[uint64]bool, len(remote))
+	for _, msg := range remote {
+		remoteIDs[msg.ID] = true
+	}
+
+	// Collect the messages that only one side knows about, while also keeping track of the order
+	// in which each side applied the messages they have in common
+	var localCommon, remoteCommon []uint64
+	for _, msg := range local {
+		if remoteIDs[msg.ID] {
+			localCommon = append(localCommon, msg.ID)
+		} else {
+			diff.OnlyLocal = append(diff.OnlyLocal, msg)
+		}
+	}
+	for _, msg := range remote {
+		if localIDs[msg.ID] {
+			remoteCommon = append(remoteCommon, msg.ID)
+		} else {
+			diff.OnlyRemote = append(diff.OnlyRemote, msg)
+		}
+	}
+
+	// Both common slices contain exactly the same IDs (barring duplicates), so any position where they
+	// disagree means the two sides applied them in a different order
+	for i := 0; i < len(localCommon) && i < len(remoteCommon); i++ {
+		if localCommon[i] != remoteCommon[i] {
+			diff.OrderMismatches = append(diff.OrderMismatches, OrderMismatch{
+				Position: i,
+				LocalID:  localCommon[i],
+				RemoteID: remoteCommon[i],
+			})
+		}
+	}
+
+	// Now walk both histories side by side, accumulating the checksum as we go, and record the first
+	// position where they disagree. If one history is longer than the other then the position right after
+	// the shorter one ends is where they diverged
+	var localSum, remoteSum uint64
+	for i := 0; i < len(local) || i < len(remote); i++ {
+		if i >= len(local) || i >= len(remote) {
+			diff.FirstChecksumMismatch = i
+			break
+		}
+
+		localSum += local[i].ID
+		remoteSum += remote[i].ID
+		if localSum != remoteSum {
+			diff.FirstChecksumMismatch = i
+			break
+		}
+	}
+
+	return diff
+}
+
+// Diverged returns whether there was any difference found between the two histories
+func (diff *HistoryDiff) Diverged() bool {
+	return len(diff.OnlyLocal) > 0 || len(diff.OnlyRemote) > 0 ||
+		len(diff.OrderMismatches) > 0 || diff.FirstChecksumMismatch >= 0
+}
+
+// WriteReport prints out a human readable version of the diff, suitable for a terminal
+func (diff *HistoryDiff) WriteReport(w io.Writer) error {
+	if !diff.Diverged() {
+		_, err := fmt.Fprintln(w, "Histories are identical")
+		return err
+	}
+
+	lines := []string{}
+
+	if diff.FirstChecksumMismatch >= 0 {
+		lines = append(lines, fmt.Sprintf("Checksums first diverge at position %d", diff.FirstChecksumMismatch))
+	} else {
+		lines = append(lines, "Checksums match")
+	}
+
+	lines = append(lines, fmt.Sprintf("Messages only in local history: %d", len(diff.OnlyLocal)))
+	for _, msg := range diff.OnlyLocal {
+		lines = append(lines, fmt.Sprintf("  %d\t%s", msg.ID, msg.Timestamp))
+	}
+
+	lines = append(lines, fmt.Sprintf("Messages only in remote history: %d", len(diff.OnlyRemote)))
+	for _, msg := range diff.OnlyRemote {
+		lines = append(lines, fmt.Sprintf("  %d\t%s", msg.ID, msg.Timestamp))
+	}
+
+	lines = append(lines, fmt.Sprintf("Apply order mismatches: %d", len(diff.OrderMismatches)))
+	for _, mismatch := range diff.OrderMismatches {
+		lines = append(lines, fmt.Sprintf("  position %d: local %d, remote %d", mismatch.Position, mismatch.LocalID, mismatch.RemoteID))
+	}
+
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package accord
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffHistoriesIdentical(t *testing.T) {
+	history := []Message{{ID: 1}, {ID: 2}, {ID: 3}}
+
+	diff := DiffHistories(history, history)
+	assert.False(t, diff.Diverged())
+	assert.Equal(t, -1, diff.FirstChecksumMismatch)
+
+	var buf bytes.Buffer
+	assert.Nil(t, diff.WriteReport(&buf))
+	assert.Equal(t, "Histories are identical\n", buf.String())
+}
+
+func TestDiffHistoriesMissingMessages(t *testing.T) {
+	local := []Message{{ID: 1}, {ID: 2}, {ID: 3}}
+	remote := []Message{{ID: 1}, {ID: 4}}
+
+	diff := DiffHistories(local, remote)
+	assert.True(t, diff.Diverged())
+	assert.Equal(t, []Message{{ID: 2}, {ID: 3}}, diff.OnlyLocal)
+	assert.Equal(t, []Message{{ID: 4}}, diff.OnlyRemote)
+	assert.Empty(t, diff.OrderMismatches)
+	assert.Equal(t, 1, diff.FirstChecksumMismatch)
+}
+
+func TestDiffHistoriesOrder(t *testing.T) {
+	local := []Message{{ID: 1}, {ID: 2}, {ID: 3}}
+	remote := []Message{{ID: 1}, {ID: 3}, {ID: 2}}
+
+	diff := DiffHistories(local, remote)
+	assert.True(t, diff.Diverged())
+	assert.Empty(t, diff.OnlyLocal)
+	assert.Empty(t, diff.OnlyRemote)
+	assert.Equal(t, []OrderMismatch{{1, 2, 3}, {2, 3, 2}}, diff.OrderMismatches)
+	assert.Equal(t, 1, diff.FirstChecksumMismatch)
+}
+
+func TestDiffHistoriesLengthMismatch(t *testing.T) {
+	local := []Message{{ID: 1}, {ID: 2}}
+	remote := []Message{{ID: 1}}
+
+	diff := DiffHistories(local, remote)
+	assert.Equal(t, 1, diff.FirstChecksumMismatch)
+}
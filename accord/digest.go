@@ -0,0 +1,102 @@
+package accord
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+)
+
+// DigestBuckets is the number of leaves in a Digest. Message IDs are already uniformly distributed hashes,
+// so we just use their top bits to decide which bucket they fall into
+const DigestBuckets = 64
+
+// digestShift is how far a Message ID has to be shifted to get its bucket
+const digestShift = 64 - 6
+
+// Digest is a small, two level Merkle tree summarizing every Message a State has processed. Each bucket
+// holds the cumulative sum and count of the Message IDs that fall into it (the same order independent
+// function State has always used, just split up) and the root hashes all of the buckets together.
+//
+// Two nodes that have processed the same set of Messages will have identical digests, regardless of the
+// order they processed them in. When they don't match, the differing buckets narrow down which Messages
+// need to be looked at, so peers don't have to exchange their entire histories to find out what's missing
+type Digest struct {
+	Sums   [DigestBuckets]uint64
+	Counts [DigestBuckets]uint64
+}
+
+// DigestComparison is the result of comparing two Digests
+type DigestComparison struct {
+	// Equal is true when both digests cover exactly the same Messages
+	Equal bool
+
+	// Buckets lists the indexes of the buckets that differ
+	Buckets []int
+}
+
+// DigestBucket returns which bucket a Message ID belongs to
+func DigestBucket(id uint64) int {
+	return int(id >> digestShift)
+}
+
+// add folds a Message ID into the digest
+func (digest *Digest) add(id uint64) {
+	bucket := DigestBucket(id)
+	digest.Sums[bucket] += id
+	digest.Counts[bucket]++
+}
+
+// Root returns the hash of every bucket in the digest. If two roots match it's safe to assume the digests
+// (and the Messages they cover) do as well
+func (digest Digest) Root() [sha256.Size]byte {
+	return sha256.Sum256(digest.marshal())
+}
+
+// RootString returns the root hash encoded as hex, which is handy for logs and status pages
+func (digest Digest) RootString() string {
+	root := digest.Root()
+	return hex.EncodeToString(root[:])
+}
+
+// Compare checks this digest against another one (generally from a remote peer) and reports which
+// buckets, if any, differ
+func (digest Digest) Compare(remote Digest) DigestComparison {
+	if digest.Root() == remote.Root() {
+		return DigestComparison{Equal: true}
+	}
+
+	comparison := DigestComparison{}
+	for i := 0; i < DigestBuckets; i++ {
+		if digest.Sums[i] != remote.Sums[i] || digest.Counts[i] != remote.Counts[i] {
+			comparison.Buckets = append(comparison.Buckets, i)
+		}
+	}
+
+	return comparison
+}
+
+// marshal encodes the digest into a fixed size byte slice so that it can be hashed and persisted
+func (digest Digest) marshal() []byte {
+	data := make([]byte, DigestBuckets*16)
+	for i := 0; i < DigestBuckets; i++ {
+		binary.LittleEndian.PutUint64(data[i*16:], digest.Sums[i])
+		binary.LittleEndian.PutUint64(data[i*16+8:], digest.Counts[i])
+	}
+	return data
+}
+
+// unmarshalDigest is the reverse of marshal
+func unmarshalDigest(data []byte) (Digest, error) {
+	digest := Digest{}
+	if len(data) != DigestBuckets*16 {
+		return digest, errors.New("digest data is the wrong size")
+	}
+
+	for i := 0; i < DigestBuckets; i++ {
+		digest.Sums[i] = binary.LittleEndian.Uint64(data[i*16:])
+		digest.Counts[i] = binary.LittleEndian.Uint64(data[i*16+8:])
+	}
+
+	return digest, nil
+}
@@ -0,0 +1,89 @@
+package accord
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDigestOrderIndependent(t *testing.T) {
+	digest1 := Digest{}
+	digest1.add(1)
+	digest1.add(1 << 60)
+	digest1.add(5)
+
+	digest2 := Digest{}
+	digest2.add(5)
+	digest2.add(1)
+	digest2.add(1 << 60)
+
+	assert.Equal(t, digest1.Root(), digest2.Root())
+	assert.True(t, digest1.Compare(digest2).Equal)
+}
+
+func TestDigestCompare(t *testing.T) {
+	local := Digest{}
+	local.add(1)
+	local.add(1 << 60)
+
+	remote := Digest{}
+	remote.add(1)
+	remote.add(3 << 59)
+
+	comparison := local.Compare(remote)
+	assert.False(t, comparison.Equal)
+	assert.Equal(t, []int{DigestBucket(1 << 60), DigestBucket(3 << 59)}, comparison.Buckets)
+}
+
+func TestDigestMarshal(t *testing.T) {
+	digest := Digest{}
+	digest.add(12345)
+	digest.add(1 << 63)
+
+	decoded, err := unmarshalDigest(digest.marshal())
+	assert.Nil(t, err)
+	assert.Equal(t, digest, decoded)
+
+	_, err = unmarshalDigest([]byte{1, 2, 3})
+	assert.NotNil(t, err)
+}
+
+func TestStateDigestPersisted(t *testing.T) {
+	stateFile := "state-test"
+	defer os.RemoveAll(stateFile)
+	os.RemoveAll(stateFile)
+
+	state1, err := OpenState(stateFile)
+	assert.Nil(t, err)
+	assert.Nil(t, state1.Update(&Message{ID: 20}))
+	assert.Nil(t, state1.Update(&Message{ID: 1 << 62}))
+	digest := state1.Digest()
+	state1.Close()
+
+	state2, err := OpenState(stateFile)
+	assert.Nil(t, err)
+	defer state2.Close()
+	assert.Equal(t, digest, state2.Digest())
+	assert.False(t, state2.digestMissing)
+}
+
+func TestAccordDigestRebuild(t *testing.T) {
+	defer AccordCleanup()
+
+	accord := DummyAccord()
+	accord.Start()
+	accord.HandleNewMessage(&Message{ID: 7})
+	accord.HandleNewMessage(&Message{ID: 1 << 61})
+	expected := accord.Digest()
+
+	// Pretend we're loading a database from before digests were persisted
+	accord.state.db.Delete([]byte(digestKey), nil)
+	accord.Stop()
+
+	accord = DummyAccord()
+	accord.Start()
+	defer accord.Stop()
+	assert.Equal(t, expected, accord.Digest())
+	assert.True(t, accord.CompareDigest(expected).Equal)
+}
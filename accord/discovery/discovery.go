@@ -0,0 +1,135 @@
+// Package discovery finds the peers an Accord node should synchronize with, so that transport Components
+// don't need every address baked into them when they're constructed. Peers can come from a static list,
+// DNS SRV records (which is how most service registries and Kubernetes headless services publish
+// addresses) or mDNS on a local network.
+//
+// Components that support discovery take a Discoverer and ask it for peers again every so often, picking up
+// new nodes as they come along
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Peer is a node we discovered
+type Peer struct {
+	// Name is the peer's NodeID
+	Name string
+
+	// URL is the base URL the peer's HTTP endpoints are served at (for instance "http://10.0.0.2:7000")
+	URL string
+}
+
+// Discoverer finds peers. Discover may be called any number of times and should return every peer it
+// currently knows of, not just the new ones
+type Discoverer interface {
+	Discover() ([]Peer, error)
+}
+
+// Static is a fixed list of peers, for when addresses are known up front and just need to come from
+// configuration rather than code
+type Static []Peer
+
+// Discover returns the list
+func (static Static) Discover() ([]Peer, error) {
+	return append([]Peer{}, static...), nil
+}
+
+// Multi combines several Discoverers, returning the peers all of them find. A peer found by more than one
+// is only returned once, by the first that found it. Errors are only returned if every Discoverer fails,
+// so that one broken source doesn't hide the peers the others found
+type Multi []Discoverer
+
+// Discover asks each of our Discoverers in turn
+func (multi Multi) Discover() ([]Peer, error) {
+	peers := []Peer{}
+	seen := map[string]bool{}
+	var lastErr error
+	failed := 0
+
+	for _, discoverer := range multi {
+		found, err := discoverer.Discover()
+		if err != nil {
+			lastErr = err
+			failed++
+			continue
+		}
+		for _, peer := range found {
+			if !seen[peer.Name] {
+				seen[peer.Name] = true
+				peers = append(peers, peer)
+			}
+		}
+	}
+
+	if failed > 0 && failed == len(multi) {
+		return nil, lastErr
+	}
+	return peers, nil
+}
+
+// DNSSRV finds peers through DNS SRV records, looking up "_Service._Proto.Domain". Each target the
+// records point at becomes a peer named after its host name (without the trailing dot), which matches the
+// NodeID Accord picks when none is given
+type DNSSRV struct {
+	// Service and Proto make up the record name along with Domain, for instance "accord" and "tcp". If both
+	// are left empty, Domain is looked up directly
+	Service string
+	Proto   string
+	Domain  string
+
+	// Scheme is used to build our peers' URLs, defaulting to "http"
+	Scheme string
+
+	// Timeout is how long a lookup may take, defaulting to 5 seconds
+	Timeout time.Duration
+
+	// Resolver defaults to net.DefaultResolver
+	Resolver *net.Resolver
+}
+
+// Discover looks up the SRV records
+func (srv DNSSRV) Discover() ([]Peer, error) {
+	resolver := srv.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	timeout := srv.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	_, records, err := resolver.LookupSRV(ctx, srv.Service, srv.Proto, srv.Domain)
+	if err != nil {
+		return nil, err
+	}
+
+	peers := []Peer{}
+	for _, record := range records {
+		host := strings.TrimSuffix(record.Target, ".")
+		peers = append(peers, Peer{Name: host, URL: peerURL(srv.Scheme, host, int(record.Port))})
+	}
+	sortPeers(peers)
+	return peers, nil
+}
+
+// peerURL builds the base URL of a peer
+func peerURL(scheme string, host string, port int) string {
+	if scheme == "" {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(host, strconv.Itoa(port)))
+}
+
+func sortPeers(peers []Peer) {
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Name < peers[j].Name })
+}
@@ -0,0 +1,105 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+type failing struct{}
+
+func (failing) Discover() ([]Peer, error) { return nil, errors.New("broken") }
+
+func TestStaticAndMulti(t *testing.T) {
+	static := Static{{Name: "a", URL: "http://a:7000"}, {Name: "b", URL: "http://b:7000"}}
+	peers, err := static.Discover()
+	assert.Nil(t, err)
+	assert.Equal(t, []Peer(static), peers)
+
+	multi := Multi{failing{}, static, Static{{Name: "a", URL: "http://elsewhere"}, {Name: "c", URL: "http://c:7000"}}}
+	peers, err = multi.Discover()
+	assert.Nil(t, err)
+	assert.Equal(t, []Peer{static[0], static[1], {Name: "c", URL: "http://c:7000"}}, peers)
+
+	_, err = Multi{failing{}}.Discover()
+	assert.NotNil(t, err)
+}
+
+// srvServer answers every query it gets with the same SRV records
+func srvServer(t *testing.T, records ...dnsmessage.SRVResource) *net.UDPConn {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.Nil(t, err)
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			query := dnsmessage.Message{}
+			if query.Unpack(buf[:n]) != nil || len(query.Questions) == 0 {
+				continue
+			}
+
+			response := dnsmessage.Message{Header: dnsmessage.Header{ID: query.ID, Response: true}, Questions: query.Questions}
+			if query.Questions[0].Type == dnsmessage.TypeSRV {
+				for i := range records {
+					response.Answers = append(response.Answers, dnsmessage.Resource{
+						Header: dnsmessage.ResourceHeader{Name: query.Questions[0].Name, Type: dnsmessage.TypeSRV, Class: dnsmessage.ClassINET, TTL: 60},
+						Body:   &records[i],
+					})
+				}
+			}
+			packet, _ := response.Pack()
+			conn.WriteTo(packet, from)
+		}
+	}()
+	return conn
+}
+
+func TestDNSSRV(t *testing.T) {
+	server := srvServer(t,
+		dnsmessage.SRVResource{Target: dnsmessage.MustNewName("node-b.example.com."), Port: 7001},
+		dnsmessage.SRVResource{Target: dnsmessage.MustNewName("node-a.example.com."), Port: 7000},
+	)
+	defer server.Close()
+
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return net.Dial("udp", server.LocalAddr().String())
+		},
+	}
+
+	peers, err := DNSSRV{Service: "accord", Proto: "tcp", Domain: "example.com", Resolver: resolver}.Discover()
+	assert.Nil(t, err)
+	assert.Equal(t, []Peer{
+		{Name: "node-a.example.com", URL: "http://node-a.example.com:7000"},
+		{Name: "node-b.example.com", URL: "http://node-b.example.com:7001"},
+	}, peers)
+}
+
+func TestMDNS(t *testing.T) {
+	// Multicast isn't available everywhere tests run, so we point everything at a unicast address instead
+	mdns := MDNS{Address: "127.0.0.1:0", Timeout: 20 * time.Millisecond}
+	advertiser, err := mdns.Advertise("node.with.dots", 7000, net.IPv4(10, 0, 0, 2))
+	assert.Nil(t, err)
+	defer advertiser.Close()
+
+	mdns.Address = advertiser.Addr().String()
+	peers, err := mdns.Discover()
+	assert.Nil(t, err)
+	assert.Equal(t, []Peer{{Name: "node.with.dots", URL: "http://10.0.0.2:7000"}}, peers)
+
+	// Nobody is advertising other services
+	mdns.Service = "_other._tcp"
+	peers, err = mdns.Discover()
+	assert.Nil(t, err)
+	assert.Empty(t, peers)
+}
@@ -0,0 +1,331 @@
+package discovery
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// MDNSAddress is the multicast address mDNS queries are sent to
+	MDNSAddress = "224.0.0.251:5353"
+
+	// DefaultMDNSService is the service Accord nodes advertise themselves as
+	DefaultMDNSService = "_accord._tcp"
+)
+
+// MDNS finds peers on the local network using multicast DNS, the way printers and file shares are found,
+// so a handful of nodes on a LAN can find each other with no configuration at all. Every node advertises
+// itself with Advertise and discovers the others with Discover.
+//
+// We speak just enough mDNS for that: Discover sends a single query and collects whatever answers come
+// back within Timeout, and Advertise answers queries for our service directly to whoever asked
+type MDNS struct {
+	// Service is the service type that's queried and advertised, defaulting to DefaultMDNSService
+	Service string
+
+	// Domain defaults to "local"
+	Domain string
+
+	// Scheme is used to build our peers' URLs, defaulting to "http"
+	Scheme string
+
+	// Timeout is how long Discover waits for answers, defaulting to 1 second
+	Timeout time.Duration
+
+	// Address is where queries are sent and where Advertise listens, defaulting to MDNSAddress. A unicast
+	// address can be used to test without a multicast capable network
+	Address string
+}
+
+func (mdns MDNS) address() (*net.UDPAddr, error) {
+	address := mdns.Address
+	if address == "" {
+		address = MDNSAddress
+	}
+	return net.ResolveUDPAddr("udp4", address)
+}
+
+// domain returns our domain as a fully qualified name
+func (mdns MDNS) domain() string {
+	domain := mdns.Domain
+	if domain == "" {
+		domain = "local"
+	}
+	return strings.TrimSuffix(domain, ".") + "."
+}
+
+// serviceName is the name we query for, like "_accord._tcp.local."
+func (mdns MDNS) serviceName() string {
+	service := mdns.Service
+	if service == "" {
+		service = DefaultMDNSService
+	}
+	return strings.ToLower(service + "." + mdns.domain())
+}
+
+// mdnsInstance is what we learn about a single advertised node while collecting answers
+type mdnsInstance struct {
+	node   string
+	target string
+	port   uint16
+}
+
+// Discover queries the network for other nodes
+func (mdns MDNS) Discover() ([]Peer, error) {
+	addr, err := mdns.address()
+	if err != nil {
+		return nil, err
+	}
+	service, err := dnsmessage.NewName(mdns.serviceName())
+	if err != nil {
+		return nil, err
+	}
+
+	// Sending from an ephemeral port rather than 5353 makes responders answer us directly
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	query := dnsmessage.Message{
+		Questions: []dnsmessage.Question{{Name: service, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET}},
+	}
+	packet, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+	_, err = conn.WriteTo(packet, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := mdns.Timeout
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+
+	instances := map[string]*mdnsInstance{}
+	hosts := map[string]net.IP{}
+	buf := make([]byte, 65536)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			// Running out of time is how we know we're done
+			break
+		}
+
+		msg := dnsmessage.Message{}
+		if msg.Unpack(buf[:n]) != nil || !msg.Response {
+			continue
+		}
+		records := append(msg.Answers, msg.Additionals...)
+		for _, record := range records {
+			name := strings.ToLower(record.Header.Name.String())
+			switch body := record.Body.(type) {
+			case *dnsmessage.PTRResource:
+				if name == mdns.serviceName() {
+					instanceFor(instances, strings.ToLower(body.PTR.String()))
+				}
+			case *dnsmessage.SRVResource:
+				instance := instanceFor(instances, name)
+				instance.target = strings.ToLower(body.Target.String())
+				instance.port = body.Port
+			case *dnsmessage.TXTResource:
+				for _, txt := range body.TXT {
+					if strings.HasPrefix(txt, "node=") {
+						instanceFor(instances, name).node = strings.TrimPrefix(txt, "node=")
+					}
+				}
+			case *dnsmessage.AResource:
+				hosts[name] = net.IP(body.A[:])
+			}
+		}
+	}
+
+	peers := []Peer{}
+	for name, instance := range instances {
+		if instance.target == "" {
+			continue
+		}
+		if instance.node == "" {
+			instance.node = strings.SplitN(name, ".", 2)[0]
+		}
+
+		host := strings.TrimSuffix(instance.target, ".")
+		if ip, ok := hosts[instance.target]; ok {
+			host = ip.String()
+		}
+		peers = append(peers, Peer{Name: instance.node, URL: peerURL(mdns.Scheme, host, int(instance.port))})
+	}
+	sortPeers(peers)
+	return peers, nil
+}
+
+func instanceFor(instances map[string]*mdnsInstance, name string) *mdnsInstance {
+	instance, ok := instances[name]
+	if !ok {
+		instance = &mdnsInstance{}
+		instances[name] = instance
+	}
+	return instance
+}
+
+// Advertiser answers mDNS queries for a node until it's closed
+type Advertiser struct {
+	conn     *net.UDPConn
+	response func(query dnsmessage.Message) ([]byte, error)
+	service  string
+	wg       sync.WaitGroup
+}
+
+// Advertise announces a node with the given NodeID, serving on port, to anybody who queries for our
+// service. If no IPs are given, the IPv4 addresses of every interface that's up are advertised
+func (mdns MDNS) Advertise(node string, port int, ips ...net.IP) (*Advertiser, error) {
+	addr, err := mdns.address()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ips) == 0 {
+		ips = localIPs()
+	}
+
+	// Our instance and host names have to be single DNS labels, so NodeIDs with dots in them are mangled.
+	// The real NodeID is carried in a TXT record
+	label := strings.ToLower(strings.Replace(node, ".", "-", -1))
+	service, err := dnsmessage.NewName(mdns.serviceName())
+	if err != nil {
+		return nil, err
+	}
+	instance, err := dnsmessage.NewName(label + "." + mdns.serviceName())
+	if err != nil {
+		return nil, err
+	}
+	host, err := dnsmessage.NewName(label + "." + mdns.domain())
+	if err != nil {
+		return nil, err
+	}
+
+	var conn *net.UDPConn
+	if addr.IP.IsMulticast() {
+		conn, err = net.ListenMulticastUDP("udp4", nil, addr)
+	} else {
+		conn, err = net.ListenUDP("udp4", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	header := func(name dnsmessage.Name, kind dnsmessage.Type) dnsmessage.ResourceHeader {
+		return dnsmessage.ResourceHeader{Name: name, Type: kind, Class: dnsmessage.ClassINET, TTL: 120}
+	}
+
+	advertiser := &Advertiser{conn: conn, service: mdns.serviceName()}
+	advertiser.response = func(query dnsmessage.Message) ([]byte, error) {
+		response := dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: query.ID, Response: true, Authoritative: true},
+			Questions: query.Questions,
+			Answers: []dnsmessage.Resource{
+				{Header: header(service, dnsmessage.TypePTR), Body: &dnsmessage.PTRResource{PTR: instance}},
+			},
+			Additionals: []dnsmessage.Resource{
+				{Header: header(instance, dnsmessage.TypeSRV), Body: &dnsmessage.SRVResource{Target: host, Port: uint16(port)}},
+				{Header: header(instance, dnsmessage.TypeTXT), Body: &dnsmessage.TXTResource{TXT: []string{"node=" + node}}},
+			},
+		}
+		for _, ip := range ips {
+			if ip4 := ip.To4(); ip4 != nil {
+				a := dnsmessage.AResource{}
+				copy(a.A[:], ip4)
+				response.Additionals = append(response.Additionals, dnsmessage.Resource{Header: header(host, dnsmessage.TypeA), Body: &a})
+			}
+		}
+		return response.Pack()
+	}
+
+	advertiser.wg.Add(1)
+	go advertiser.serve()
+	return advertiser, nil
+}
+
+// Addr returns the address we're listening for queries on
+func (advertiser *Advertiser) Addr() net.Addr {
+	return advertiser.conn.LocalAddr()
+}
+
+// Close stops answering queries
+func (advertiser *Advertiser) Close() error {
+	err := advertiser.conn.Close()
+	advertiser.wg.Wait()
+	return err
+}
+
+func (advertiser *Advertiser) serve() {
+	defer advertiser.wg.Done()
+
+	buf := make([]byte, 65536)
+	for {
+		n, from, err := advertiser.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		query := dnsmessage.Message{}
+		if query.Unpack(buf[:n]) != nil || query.Response || !advertiser.asked(query) {
+			continue
+		}
+
+		packet, err := advertiser.response(query)
+		if err != nil {
+			continue
+		}
+		advertiser.conn.WriteTo(packet, from)
+	}
+}
+
+// asked reports whether a query is asking about our service
+func (advertiser *Advertiser) asked(query dnsmessage.Message) bool {
+	for _, question := range query.Questions {
+		if (question.Type == dnsmessage.TypePTR || question.Type == dnsmessage.TypeALL) &&
+			strings.ToLower(question.Name.String()) == advertiser.service {
+			return true
+		}
+	}
+	return false
+}
+
+// localIPs returns the IPv4 addresses of every interface that's up, preferring ones that aren't loopback
+func localIPs() []net.IP {
+	ips := []net.IP{}
+	loopback := []net.IP{}
+
+	interfaces, _ := net.Interfaces()
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		addrs, _ := iface.Addrs()
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if !ok || ipnet.IP.To4() == nil {
+				continue
+			}
+			if ipnet.IP.IsLoopback() {
+				loopback = append(loopback, ipnet.IP)
+			} else {
+				ips = append(ips, ipnet.IP)
+			}
+		}
+	}
+
+	if len(ips) == 0 {
+		return loopback
+	}
+	return ips
+}
@@ -0,0 +1,214 @@
+package accord
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Event kinds emitted as our data directory goes over its DiskQuota and comes back under it
+const (
+	EventDiskQuotaExceeded  = "disk_quota_exceeded"
+	EventDiskQuotaRecovered = "disk_quota_recovered"
+)
+
+// DefaultDiskCheckInterval is how often we measure our data directory, when our DiskQuota's CheckInterval
+// isn't set
+const DefaultDiskCheckInterval = 30 * time.Second
+
+// DefaultPruneBatch is how many Messages we prune from our history at a time to get back under our
+// DiskQuota, when its PruneBatch isn't set
+const DefaultPruneBatch = 1000
+
+// ErrDiskQuota is returned by HandleNewMessage while our data directory is over its DiskQuota under
+// DiskQuotaReject
+var ErrDiskQuota = errors.New("the data directory is over its disk quota")
+
+// DiskQuotaPolicy decides what happens when our data directory goes over its DiskQuota
+type DiskQuotaPolicy int
+
+const (
+	// DiskQuotaAlert only reports it, through an EventDiskQuotaExceeded and our Health
+	DiskQuotaAlert DiskQuotaPolicy = iota
+
+	// DiskQuotaReject refuses new Messages with ErrDiskQuota until we're back under the quota. Messages
+	// from our peers are still handled, so that they can catch us up and so that our own queue can drain
+	DiskQuotaReject
+
+	// DiskQuotaPrune prunes the oldest Messages from our history until we're back under the quota (see
+	// PruneHistory), or until there's nothing left to prune
+	DiskQuotaPrune
+)
+
+// DiskQuota keeps our data directory from filling the disk it's on, which LevelDB doesn't cope with well.
+// We measure the directory every CheckInterval whether there's a quota or not, and report how big it is
+// through our Health and Metrics
+type DiskQuota struct {
+	// MaxBytes is how big our data directory can get. There's no quota if it's 0
+	MaxBytes int64
+
+	Policy DiskQuotaPolicy
+
+	// CheckInterval is how often we measure our data directory, defaulting to DefaultDiskCheckInterval
+	CheckInterval time.Duration
+
+	// PruneBatch is how many Messages we prune at a time under DiskQuotaPrune, defaulting to
+	// DefaultPruneBatch
+	PruneBatch uint64
+}
+
+// diskUsage keeps track of how big our data directory was when we last measured it
+type diskUsage struct {
+	mutex sync.Mutex
+	bytes int64
+	over  bool
+
+	// rejecting is set (atomically) while we're refusing new Messages under DiskQuotaReject
+	rejecting int32
+}
+
+// SetDiskQuota changes our DiskQuota while we're running. A new CheckInterval only takes effect the next
+// time we start
+func (accord *Accord) SetDiskQuota(quota DiskQuota) {
+	accord.disk.mutex.Lock()
+	defer accord.disk.mutex.Unlock()
+	accord.DiskQuota = quota
+}
+
+// DataDirBytes returns how big our data directory was when we last measured it
+func (accord *Accord) DataDirBytes() int64 {
+	accord.disk.mutex.Lock()
+	defer accord.disk.mutex.Unlock()
+	return accord.disk.bytes
+}
+
+// OverDiskQuota reports whether our data directory was over its DiskQuota when we last measured it
+func (accord *Accord) OverDiskQuota() bool {
+	accord.disk.mutex.Lock()
+	defer accord.disk.mutex.Unlock()
+	return accord.disk.over
+}
+
+// diskQuotaRejecting reports whether new Messages should be refused with ErrDiskQuota
+func (accord *Accord) diskQuotaRejecting() bool {
+	return atomic.LoadInt32(&accord.disk.rejecting) == 1
+}
+
+// diskQuota returns our DiskQuota, with its defaults filled in
+func (accord *Accord) diskQuota() DiskQuota {
+	accord.disk.mutex.Lock()
+	defer accord.disk.mutex.Unlock()
+	quota := accord.DiskQuota
+	if quota.CheckInterval <= 0 {
+		quota.CheckInterval = DefaultDiskCheckInterval
+	}
+	if quota.PruneBatch == 0 {
+		quota.PruneBatch = DefaultPruneBatch
+	}
+	return quota
+}
+
+// watchDisk measures our data directory straight away, and then every CheckInterval until we stop
+func (accord *Accord) watchDisk() {
+	accord.disk.mutex.Lock()
+	accord.disk.over = false
+	accord.disk.mutex.Unlock()
+	atomic.StoreInt32(&accord.disk.rejecting, 0)
+	accord.checkDisk()
+
+	stopped := accord.stopContext.Done()
+	go func() {
+		ticker := time.NewTicker(accord.diskQuota().CheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				accord.checkDisk()
+			case <-stopped:
+				return
+			}
+		}
+	}()
+}
+
+// checkDisk measures our data directory and enforces our DiskQuota
+func (accord *Accord) checkDisk() {
+	size, err := accord.dataDirSize()
+	if err != nil {
+		accord.Logger.WithError(err).Warn("Unable to measure our data directory")
+		return
+	}
+
+	quota := accord.diskQuota()
+	over := quota.MaxBytes > 0 && size > quota.MaxBytes
+	if over && quota.Policy == DiskQuotaPrune {
+		size, over = accord.pruneToQuota(quota, size)
+	}
+	accord.metrics().Gauge(MetricDataDirBytes, float64(size))
+
+	rejecting := int32(0)
+	if over && quota.Policy == DiskQuotaReject {
+		rejecting = 1
+	}
+	atomic.StoreInt32(&accord.disk.rejecting, rejecting)
+
+	accord.disk.mutex.Lock()
+	wasOver := accord.disk.over
+	accord.disk.bytes = size
+	accord.disk.over = over
+	accord.disk.mutex.Unlock()
+
+	fields := map[string]interface{}{"data_dir": accord.dataDir, "bytes": size, "max_bytes": quota.MaxBytes}
+	log := accord.Logger.WithField("bytes", size).WithField("max_bytes", quota.MaxBytes)
+	if over && !wasOver {
+		log.Error("Our data directory is over its disk quota")
+		accord.Emit(EventDiskQuotaExceeded, "The data directory is over its disk quota", fields)
+	} else if !over && wasOver {
+		log.Info("Our data directory is back under its disk quota")
+		accord.Emit(EventDiskQuotaRecovered, "The data directory is back under its disk quota", fields)
+	}
+}
+
+// pruneToQuota prunes our history a batch at a time until our data directory is back under its quota,
+// returning how big it ended up and whether it's still over
+func (accord *Accord) pruneToQuota(quota DiskQuota, size int64) (int64, bool) {
+	for size > quota.MaxBytes {
+		pruned, err := accord.PruneHistory(quota.PruneBatch)
+		if err != nil || pruned == 0 {
+			break
+		}
+		size, err = accord.dataDirSize()
+		if err != nil {
+			accord.Logger.WithError(err).Warn("Unable to measure our data directory")
+			break
+		}
+	}
+	return size, size > quota.MaxBytes
+}
+
+// dataDirSize adds up the size of every file in our data directory
+func (accord *Accord) dataDirSize() (int64, error) {
+	dir := accord.dataDir
+	if dir == "" {
+		dir = "."
+	}
+
+	size := int64(0)
+	err := filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			// LevelDB removes files as it compacts, which isn't worth failing over
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
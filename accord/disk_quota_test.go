@@ -0,0 +1,65 @@
+package accord
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiskQuotaReject(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	accord.DiskQuota = DiskQuota{MaxBytes: 1, Policy: DiskQuotaReject, CheckInterval: time.Hour}
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	assert.True(t, accord.OverDiskQuota())
+	assert.True(t, accord.DataDirBytes() > 0)
+	assert.Equal(t, ErrDiskQuota, accord.HandleNewMessage(&Message{ID: 1}))
+	report := accord.Health()
+	assert.True(t, report.OverDiskQuota)
+	assert.Equal(t, HealthDegraded, report.Status)
+
+	// Lifting the quota lets new Messages through again the next time we check
+	accord.SetDiskQuota(DiskQuota{})
+	accord.checkDisk()
+	assert.False(t, accord.OverDiskQuota())
+	assert.Nil(t, accord.HandleNewMessage(&Message{ID: 1}))
+
+	kinds := []string{}
+	for _, event := range accord.RecentEvents() {
+		if event.Kind == EventDiskQuotaExceeded || event.Kind == EventDiskQuotaRecovered {
+			kinds = append(kinds, event.Kind)
+		}
+	}
+	assert.Equal(t, []string{EventDiskQuotaExceeded, EventDiskQuotaRecovered}, kinds)
+}
+
+func TestDiskQuotaPrune(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	accord.DiskQuota = DiskQuota{CheckInterval: time.Hour, PruneBatch: 2}
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+	for i := uint64(1); i <= 5; i++ {
+		assert.Nil(t, accord.HandleNewMessage(&Message{ID: i}))
+	}
+	digest := accord.Digest()
+
+	// There's always more in our data directory than one byte, so we prune everything we can and stay over
+	accord.SetDiskQuota(DiskQuota{MaxBytes: 1, Policy: DiskQuotaPrune, PruneBatch: 2})
+	accord.checkDisk()
+	assert.True(t, accord.OverDiskQuota())
+	assert.Equal(t, uint64(1), accord.History().Len())
+	assert.Equal(t, digest, accord.Digest())
+	assert.Nil(t, accord.HandleNewMessage(&Message{ID: 6}))
+
+	pruned := 0
+	for _, event := range accord.RecentEvents() {
+		if event.Kind == EventHistoryPruned {
+			pruned++
+		}
+	}
+	assert.Equal(t, 2, pruned)
+}
@@ -0,0 +1,54 @@
+package accord
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// EventDraining is emitted when we start draining
+const EventDraining = "draining"
+
+// drainPollInterval is how often Drain checks whether our queue has been flushed
+const drainPollInterval = 50 * time.Millisecond
+
+// ErrDraining is returned for any new Message we're asked to handle while we're draining
+var ErrDraining = errors.New("accord is draining, so no new messages can be handled")
+
+// Drain decommissions us without losing anything: new Messages are refused with ErrDraining, our
+// Components carry on sending what's left in our queue to our peers, and once every one of them has
+// acknowledged all of it we Stop. Remote Messages are still accepted while we drain, so peers that are
+// sending us their own don't get stuck.
+//
+// If the context is done before our queue has been flushed we return its error without stopping, leaving
+// the rest of the queue on disk; we keep refusing new Messages, so Drain can simply be called again (or
+// Stop, to give up). A node without any peers has nobody to flush its queue to, so it'll only drain if
+// one is added in the meantime
+func (accord *Accord) Drain(ctx context.Context) error {
+	if atomic.CompareAndSwapInt32(&accord.draining, 0, 1) {
+		accord.Logger.WithField("queued", accord.QueuedMessages()).Info("Draining")
+		accord.Emit(EventDraining, "Accord is draining", map[string]interface{}{"queued": accord.QueuedMessages()})
+	}
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for accord.QueuedMessages() > 0 {
+		select {
+		case <-ctx.Done():
+			accord.Logger.WithError(ctx.Err()).WithField("queued", accord.QueuedMessages()).Warn("Gave up waiting for our queue to drain")
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	accord.Logger.Info("Our queue has drained, stopping")
+	accord.Stop()
+	return nil
+}
+
+// Draining reports whether Drain has been called
+func (accord *Accord) Draining() bool {
+	return atomic.LoadInt32(&accord.draining) == 1
+}
@@ -0,0 +1,47 @@
+package accord
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDrain(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	assert.Nil(t, accord.Start())
+
+	stopped := false
+	accord.Subscribe(func(event Event) {
+		if event.Kind == EventStopped {
+			stopped = true
+		}
+	})
+
+	peer := accord.AddPeer("remote")
+	msg, _ := NewMessage([]byte("hello"))
+	assert.Nil(t, accord.HandleNewMessage(msg))
+
+	// Our peer never acknowledges anything, so we can't finish draining
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, accord.Drain(ctx))
+	assert.True(t, accord.Draining())
+	assert.False(t, stopped)
+
+	msg, _ = NewMessage([]byte("too late"))
+	assert.Equal(t, ErrDraining, accord.HandleNewMessage(msg))
+	remote, _ := NewMessage([]byte("remote"))
+	remote.Origin = "remote"
+	assert.Nil(t, accord.HandleRemoteMessage(remote))
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		msgs, _ := peer.Peek(10)
+		peer.Advance(len(msgs))
+	}()
+	assert.Nil(t, accord.Drain(context.Background()))
+	assert.True(t, stopped)
+}
@@ -0,0 +1,155 @@
+package accord
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// DefaultSyncInterval is how often our stores are synced under SyncInterval when SyncEvery isn't set
+const DefaultSyncInterval = time.Second
+
+// SyncMode decides how hard we try to make sure what we write has actually reached the disk before we
+// carry on. Every write still goes to the operating system straight away; what differs is how much of
+// it is lost if the whole machine goes down (as opposed to just our process)
+type SyncMode int
+
+const (
+	// SyncNever leaves it to the operating system to write things out whenever it sees fit, which is the
+	// fastest but can lose the last few seconds of writes to a power cut
+	SyncNever SyncMode = iota
+
+	// SyncInterval syncs every SyncEvery, bounding how much can be lost to a power cut
+	SyncInterval
+
+	// SyncAlways syncs every write before we carry on. Nothing we've acknowledged is ever lost, but every
+	// Message waits on the disk
+	SyncAlways
+)
+
+// syncEvery returns how often our stores are synced under SyncInterval
+func (accord *Accord) syncEvery() time.Duration {
+	if accord.SyncEvery <= 0 {
+		return DefaultSyncInterval
+	}
+	return accord.SyncEvery
+}
+
+// stateWriteOptions returns the options state writes are made with under the given mode
+func stateWriteOptions(mode SyncMode) *opt.WriteOptions {
+	if mode == SyncAlways {
+		return &opt.WriteOptions{Sync: true}
+	}
+	return nil
+}
+
+// queuePaths returns where each of the stores QueueSyncMode applies to is kept: the shards of every
+// channel, our history and our dead letters
+func (accord *Accord) queuePaths() []string {
+	paths := []string{path.Join(accord.dataDir, HistoryFilename), path.Join(accord.dataDir, DeadLetterFilename)}
+	for _, shard := range accord.allShards() {
+		paths = append(paths, shard.path)
+	}
+	return paths
+}
+
+// syncQueueWrite syncs a queue store that was just written to under SyncAlways. goque doesn't let us ask
+// for synced writes, so we sync its files ourselves
+func (accord *Accord) syncQueueWrite(dir string) {
+	if accord.QueueSyncMode != SyncAlways {
+		return
+	}
+	err := syncStore(dir)
+	if err != nil {
+		accord.Logger.WithError(err).WithField("store", dir).Warn("We could not sync a store to disk")
+	}
+}
+
+// startSyncing syncs whichever of our stores are under SyncInterval every SyncEvery, until stopSyncing
+func (accord *Accord) startSyncing() {
+	if accord.QueueSyncMode != SyncInterval && accord.StateSyncMode != SyncInterval {
+		return
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	accord.syncStop, accord.syncDone = stop, done
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(accord.syncEvery())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				accord.syncStores()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// stopSyncing stops syncing our stores every SyncEvery, syncing them one last time
+func (accord *Accord) stopSyncing() {
+	if accord.syncStop == nil {
+		return
+	}
+	close(accord.syncStop)
+	<-accord.syncDone
+	accord.syncStop, accord.syncDone = nil, nil
+	accord.syncStores()
+}
+
+// syncStores syncs every one of our stores that's under SyncInterval
+func (accord *Accord) syncStores() {
+	paths := []string{}
+	if accord.QueueSyncMode == SyncInterval {
+		paths = append(paths, accord.queuePaths()...)
+	}
+	if accord.StateSyncMode == SyncInterval {
+		paths = append(paths, path.Join(accord.dataDir, StateFilename))
+	}
+
+	for _, dir := range paths {
+		err := syncStore(dir)
+		if err != nil {
+			accord.Logger.WithError(err).WithField("store", dir).Warn("We could not sync a store to disk")
+		}
+	}
+}
+
+// syncStore flushes a LevelDB store's journal and manifest to disk. Those are all that's needed to recover
+// every write, as everything else in the store is synced by LevelDB itself when it's written
+func syncStore(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, ".log") && !strings.HasPrefix(name, "MANIFEST-") && name != "CURRENT" {
+			continue
+		}
+		err = syncFile(filepath.Join(dir, name))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncFile syncs a single file to disk
+func syncFile(name string) error {
+	file, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
@@ -0,0 +1,61 @@
+package accord
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyncAlways(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	accord.QueueSyncMode = SyncAlways
+	accord.StateSyncMode = SyncAlways
+	assert.Nil(t, accord.Start())
+
+	assert.True(t, accord.state.writeOptions.Sync)
+	assert.Nil(t, accord.syncStop)
+
+	msg, _ := NewMessage([]byte("durable"))
+	assert.Nil(t, accord.HandleNewMessage(msg))
+	assert.Equal(t, uint64(1), accord.Queue().Len())
+	assert.Equal(t, uint64(1), accord.History().Len())
+	accord.Stop()
+
+	accord = DummyAccord()
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+	assert.Equal(t, uint64(1), accord.History().Len())
+}
+
+func TestSyncInterval(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	accord.QueueSyncMode = SyncInterval
+	accord.SyncEvery = 10 * time.Millisecond
+	assert.Nil(t, accord.Start())
+
+	// Only the queues are synced, our state is left to the operating system
+	assert.Nil(t, accord.state.writeOptions)
+	assert.NotNil(t, accord.syncStop)
+
+	msg, _ := NewMessage([]byte("eventually durable"))
+	assert.Nil(t, accord.HandleNewMessage(msg))
+	time.Sleep(30 * time.Millisecond)
+
+	accord.Stop()
+	assert.Nil(t, accord.syncStop)
+}
+
+func TestSyncStore(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	for _, dir := range accord.queuePaths() {
+		assert.Nil(t, syncStore(dir))
+	}
+	assert.NotNil(t, syncStore("missing.store"))
+}
@@ -0,0 +1,202 @@
+package accord
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"sync"
+
+	"github.com/beeker1121/goque"
+)
+
+// EncryptionKeySize is how long the keys we encrypt our data directory with are (they're AES-256 keys)
+const EncryptionKeySize = 32
+
+var (
+	// ErrEncryptionKeySize is returned by Start when one of our encryption keys isn't EncryptionKeySize bytes
+	// long
+	ErrEncryptionKeySize = errors.New("encryption keys must be 32 bytes long")
+
+	// ErrEncrypted is returned when reading encrypted data without an EncryptionKey to decrypt it with
+	ErrEncrypted = errors.New("the data is encrypted, but we have no key to decrypt it with")
+
+	// ErrDecryption is returned when encrypted data can't be decrypted, because it was encrypted with
+	// another key or because it has been tampered with
+	ErrDecryption = errors.New("the data could not be decrypted with our key")
+)
+
+// sealedMagic starts everything we encrypt. Neither gob encoded Messages nor dead letters can start with a
+// zero byte, so it's what tells encrypted items apart from ones written before we were encrypting
+var sealedMagic = []byte("\x00enc")
+
+// sealer encrypts and decrypts what we store with AES-GCM, using the keys on our EncryptionKeys.
+// Encrypted items are laid out as sealedMagic, the ID of the key they were encrypted with (see KeyID), a
+// nonce, and the ciphertext. A nil sealer leaves items as they are, and refuses encrypted ones with
+// ErrEncrypted
+type sealer struct {
+	keys *Keyring
+
+	// aeads caches the cipher of each of our keys, by ID
+	aeads sync.Map
+}
+
+// newSealer creates a sealer encrypting with the active key on keys
+func newSealer(keys *Keyring) (*sealer, error) {
+	if _, ok := keys.Active(); !ok {
+		return nil, ErrNoKeys
+	}
+	for _, version := range keys.Versions() {
+		if len(version.Key) != EncryptionKeySize {
+			return nil, ErrEncryptionKeySize
+		}
+	}
+	return &sealer{keys: keys}, nil
+}
+
+// aead returns the cipher of the key with the given ID, as long as it's still on our keyring
+func (s *sealer) aead(id uint32) (cipher.AEAD, error) {
+	key, ok := s.keys.Key(id)
+	if !ok {
+		return nil, ErrDecryption
+	}
+	if aead, ok := s.aeads.Load(id); ok {
+		return aead.(cipher.AEAD), nil
+	}
+
+	if len(key) != EncryptionKeySize {
+		return nil, ErrEncryptionKeySize
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	s.aeads.Store(id, aead)
+	return aead, nil
+}
+
+// seal encrypts data with our active key, if we have one
+func (s *sealer) seal(data []byte) ([]byte, error) {
+	if s == nil {
+		return data, nil
+	}
+	active, ok := s.keys.Active()
+	if !ok {
+		return nil, ErrNoKeys
+	}
+	aead, err := s.aead(active.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	header := len(sealedMagic) + 4
+	sealed := make([]byte, header+aead.NonceSize(), header+aead.NonceSize()+len(data)+aead.Overhead())
+	copy(sealed, sealedMagic)
+	binary.BigEndian.PutUint32(sealed[len(sealedMagic):], active.ID)
+	nonce := sealed[header:]
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(sealed, nonce, data, nil), nil
+}
+
+// open decrypts what seal encrypted, with whichever of our keys it was encrypted with. Anything else was
+// stored before we were encrypting and is returned as it is
+func (s *sealer) open(data []byte) ([]byte, error) {
+	id, sealed := sealedWith(data)
+	if !sealed {
+		return data, nil
+	}
+	if s == nil {
+		return nil, ErrEncrypted
+	}
+	aead, err := s.aead(id)
+	if err != nil {
+		return nil, err
+	}
+
+	header := len(sealedMagic) + 4
+	if len(data) < header+aead.NonceSize() {
+		return nil, ErrDecryption
+	}
+	nonce := data[header : header+aead.NonceSize()]
+	plain, err := aead.Open(nil, nonce, data[header+aead.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrDecryption
+	}
+	return plain, nil
+}
+
+// sealedWith returns the ID of the key data was encrypted with, if it was encrypted at all
+func sealedWith(data []byte) (uint32, bool) {
+	header := len(sealedMagic) + 4
+	if len(data) < header || !bytes.HasPrefix(data, sealedMagic) {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(data[len(sealedMagic):header]), true
+}
+
+// decodeMessage decrypts and deserializes a Message we stored
+func (s *sealer) decodeMessage(data []byte) (*Message, error) {
+	data, err := s.open(data)
+	if err != nil {
+		return nil, err
+	}
+	return DeserializeMessage(data)
+}
+
+// encodeMessage serializes and encrypts a Message to be stored
+func (s *sealer) encodeMessage(msg *Message) ([]byte, error) {
+	data, err := msg.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	return s.seal(data)
+}
+
+// checkEncryptionKey makes sure our key can read what's already in our history, so that we don't start
+// with the wrong key and only find out once our peers ask us for something
+func (accord *Accord) checkEncryptionKey() error {
+	item, err := accord.historyStack.Peek()
+	if err == goque.ErrEmpty {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = accord.sealer.open(item.Value)
+	return err
+}
+
+// LoadKeyFile reads an encryption key (see EncryptionKey) from a file. The file can hold the key's raw
+// bytes, or the key encoded as hex or base64, which is easier to generate and copy around:
+//
+//	openssl rand -hex 32 > /etc/accord/key
+func LoadKeyFile(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == EncryptionKeySize {
+		return data, nil
+	}
+
+	text := string(bytes.TrimSpace(data))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == EncryptionKeySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == EncryptionKeySize {
+		return key, nil
+	}
+	return nil, ErrEncryptionKeySize
+}
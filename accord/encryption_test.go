@@ -0,0 +1,128 @@
+package accord
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testSealer creates a sealer with a single key
+func testSealer(t *testing.T, key []byte) (*sealer, error) {
+	keys, err := NewKeyring(key)
+	assert.Nil(t, err)
+	return newSealer(keys)
+}
+
+func TestSealer(t *testing.T) {
+	s, err := testSealer(t, bytes.Repeat([]byte{1}, EncryptionKeySize))
+	assert.Nil(t, err)
+	_, err = testSealer(t, []byte("short"))
+	assert.Equal(t, ErrEncryptionKeySize, err)
+	_, err = newSealer(&Keyring{})
+	assert.Equal(t, ErrNoKeys, err)
+
+	sealed, err := s.seal([]byte("secret"))
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(sealed, []byte("secret")))
+	opened, err := s.open(sealed)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("secret"), opened)
+
+	// Data from before we were encrypting is read as it is
+	opened, err = s.open([]byte("plain"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("plain"), opened)
+
+	var none *sealer
+	_, err = none.open(sealed)
+	assert.Equal(t, ErrEncrypted, err)
+
+	other, _ := testSealer(t, bytes.Repeat([]byte{2}, EncryptionKeySize))
+	_, err = other.open(sealed)
+	assert.Equal(t, ErrDecryption, err)
+	sealed[len(sealed)-1] ^= 1
+	_, err = s.open(sealed)
+	assert.Equal(t, ErrDecryption, err)
+}
+
+func TestEncryptionAtRest(t *testing.T) {
+	defer AccordCleanup()
+	key := bytes.Repeat([]byte{7}, EncryptionKeySize)
+	accord := DummyAccord()
+	accord.EncryptionKey = key
+	assert.Nil(t, accord.Start())
+
+	accord.AddPeer("remote")
+	msg, _ := NewMessage([]byte("top secret"))
+	assert.Nil(t, accord.HandleNewMessage(msg))
+	assert.Nil(t, accord.recordDeadLetters("remote", "testing", []*Message{msg}))
+	assert.Nil(t, accord.State().Put([]byte("note"), []byte("classified")))
+
+	// Nothing we stored can be read without the key
+	item, err := accord.historyStack.Peek()
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(item.Value, []byte("top secret")))
+	item, err = accord.syncQueue.Peek()
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(item.Value, []byte("top secret")))
+	item, err = accord.deadLetters.Peek()
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(item.Value, []byte("top secret")))
+	value, err := accord.state.db.Get(userKey([]byte("note")), nil)
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(value, []byte("classified")))
+
+	// But it reads just fine with it, even from the raw stack Managers are handed
+	got, err := accord.History().Get(0)
+	assert.Nil(t, err)
+	assert.Equal(t, msg.Payload, got.Payload)
+	got, err = NewHistory(accord.historyStack).Get(0)
+	assert.Nil(t, err)
+	assert.Equal(t, msg.Payload, got.Payload)
+	got, err = accord.Queue().Get(0)
+	assert.Nil(t, err)
+	assert.Equal(t, msg.Payload, got.Payload)
+	msgs, err := accord.Peer("remote").Peek(1)
+	assert.Nil(t, err)
+	assert.Equal(t, msg.Payload, msgs[0].Payload)
+	letters, err := accord.DeadLetters()
+	assert.Nil(t, err)
+	assert.Equal(t, msg.Payload, letters[0].Message.Payload)
+	value, err = accord.State().Get([]byte("note"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("classified"), value)
+	accord.Stop()
+
+	// We refuse to start without the key, or with the wrong one
+	accord.EncryptionKey = nil
+	assert.Equal(t, ErrEncrypted, accord.Start())
+	accord.EncryptionKey = bytes.Repeat([]byte{8}, EncryptionKeySize)
+	assert.Equal(t, ErrDecryption, accord.Start())
+	accord.EncryptionKey = key
+	assert.Nil(t, accord.Start())
+	accord.Stop()
+}
+
+func TestLoadKeyFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "accord-key")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	key := bytes.Repeat([]byte{3}, EncryptionKeySize)
+	path := filepath.Join(dir, "key")
+	for _, contents := range [][]byte{key, []byte(hex.EncodeToString(key) + "\n")} {
+		assert.Nil(t, ioutil.WriteFile(path, contents, 0600))
+		loaded, err := LoadKeyFile(path)
+		assert.Nil(t, err)
+		assert.Equal(t, key, loaded)
+	}
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte("too short"), 0600))
+	_, err = LoadKeyFile(path)
+	assert.Equal(t, ErrEncryptionKeySize, err)
+}
@@ -0,0 +1,79 @@
+package accord
+
+import (
+	"sync"
+	"time"
+)
+
+// RecentEventsLimit is how many events Accord keeps around in memory for RecentEvents
+const RecentEventsLimit = 200
+
+// Event kinds emitted by Accord itself
+const (
+	EventStarted         = "started"
+	EventStopped         = "stopped"
+	EventShutdown        = "shutdown"
+	EventMessageRejected = "message_rejected"
+)
+
+// Event is a notable thing that happened inside of Accord. Events are meant for operators and monitoring
+// rather than application logic: they're kept in memory for support bundles and status pages, and can be
+// subscribed to for alerting
+type Event struct {
+	Time    time.Time
+	Kind    string
+	Message string
+	Fields  map[string]interface{}
+}
+
+// eventLog keeps track of our subscribers and a ring buffer of our most recent events
+type eventLog struct {
+	mutex       sync.Mutex
+	recent      []Event
+	next        int
+	subscribers []func(Event)
+}
+
+// Subscribe registers a function to be called for every event Accord emits. Subscribers are called
+// synchronously, sometimes while a Message is being processed, so they should return quickly
+func (accord *Accord) Subscribe(fn func(Event)) {
+	accord.events.mutex.Lock()
+	defer accord.events.mutex.Unlock()
+	accord.events.subscribers = append(accord.events.subscribers, fn)
+}
+
+// RecentEvents returns the most recent events Accord has emitted, oldest first
+func (accord *Accord) RecentEvents() []Event {
+	accord.events.mutex.Lock()
+	defer accord.events.mutex.Unlock()
+
+	events := make([]Event, 0, len(accord.events.recent))
+	if len(accord.events.recent) == RecentEventsLimit {
+		events = append(events, accord.events.recent[accord.events.next:]...)
+		events = append(events, accord.events.recent[:accord.events.next]...)
+	} else {
+		events = append(events, accord.events.recent...)
+	}
+	return events
+}
+
+// Emit records an event and passes it along to every subscriber. Components can use this to surface their
+// own events alongside Accord's
+func (accord *Accord) Emit(kind string, message string, fields map[string]interface{}) {
+	event := Event{Time: time.Now().UTC(), Kind: kind, Message: message, Fields: fields}
+
+	accord.events.mutex.Lock()
+	if len(accord.events.recent) < RecentEventsLimit {
+		accord.events.recent = append(accord.events.recent, event)
+	} else {
+		accord.events.recent[accord.events.next] = event
+		accord.events.next = (accord.events.next + 1) % RecentEventsLimit
+	}
+	subscribers := make([]func(Event), len(accord.events.subscribers))
+	copy(subscribers, accord.events.subscribers)
+	accord.events.mutex.Unlock()
+
+	for _, subscriber := range subscribers {
+		subscriber(event)
+	}
+}
@@ -0,0 +1,36 @@
+package accord
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventsSubscribe(t *testing.T) {
+	defer AccordCleanup()
+
+	received := []string{}
+	accord := DummyAccord()
+	accord.Subscribe(func(event Event) {
+		received = append(received, event.Kind)
+	})
+
+	accord.Start()
+	accord.Emit("custom", "Something happened", nil)
+	accord.Stop()
+
+	assert.Equal(t, []string{EventStarted, "custom", EventStopped}, received)
+}
+
+func TestRecentEventsRingBuffer(t *testing.T) {
+	accord := DummyAccord()
+	for i := 0; i < RecentEventsLimit+10; i++ {
+		accord.Emit("custom", fmt.Sprint(i), nil)
+	}
+
+	events := accord.RecentEvents()
+	assert.Len(t, events, RecentEventsLimit)
+	assert.Equal(t, "10", events[0].Message)
+	assert.Equal(t, fmt.Sprint(RecentEventsLimit+9), events[len(events)-1].Message)
+}
@@ -0,0 +1,116 @@
+package accord
+
+import (
+	"errors"
+	"fmt"
+)
+
+// EventMessageConflict is emitted when our Manager reports that a Message conflicts with what it already has
+const EventMessageConflict = "message_conflict"
+
+// RetryableError can be returned by a Manager's Process for a failure that may well go away if the Message
+// is tried again later, like a database that's briefly unavailable. Nothing about the Message is recorded
+// and we carry on as normal; the error is simply handed back to whoever gave us the Message. For remote
+// Messages that's the transport, which has the sending peer try again later (the Messages after it from
+// the same origin wait for it under OrderCausal), and for local ones it's up to the caller
+type RetryableError struct {
+	Err error
+}
+
+func (err *RetryableError) Error() string {
+	return fmt.Sprintf("retryable: %s", err.Err)
+}
+
+// Unwrap returns the error that made the Message fail
+func (err *RetryableError) Unwrap() error {
+	return err.Err
+}
+
+// FatalError can be returned by a Manager's Process when it's been left in a state it can't recover from,
+// and always blows us up, whatever our PanicPolicy
+type FatalError struct {
+	Err error
+}
+
+func (err *FatalError) Error() string {
+	return fmt.Sprintf("fatal: %s", err.Err)
+}
+
+// Unwrap returns the error that made the Message fail
+func (err *FatalError) Unwrap() error {
+	return err.Err
+}
+
+// ConflictError can be returned by a Manager's Process for a Message that conflicts with what it already
+// has in a way it can't settle on its own (see Resolver for the ones it can). The Message is rejected
+// and handed to OnConflict, or set aside as a dead letter under our own NodeID for an operator to settle if
+// there isn't one
+type ConflictError struct {
+	Err error
+}
+
+func (err *ConflictError) Error() string {
+	return fmt.Sprintf("conflict: %s", err.Err)
+}
+
+// Unwrap returns what the Message conflicts with
+func (err *ConflictError) Unwrap() error {
+	return err.Err
+}
+
+// ConflictFunc settles a Message our Manager reported a ConflictError for, whether by merging it in some
+// other way or by recording it for someone to look at. If it returns an error the Message is dead lettered
+// as though there were no ConflictFunc
+type ConflictFunc func(msg *Message, err *ConflictError) error
+
+// handleProcessError deals with an error our Manager returned from Process according to what kind of
+// error it is, returning the error the Message should be failed with. Errors that aren't one of ours are
+// handled according to our PanicPolicy, as we can't know whether the Manager left anything half done
+func (accord *Accord) handleProcessError(msg *Message, err error) error {
+	log := WithMessage(accord.Logger, msg).WithError(err)
+	accord.recordError(err)
+
+	var retryable *RetryableError
+	if errors.As(err, &retryable) {
+		log.Info("The manager could not process a message for now")
+		return err
+	}
+
+	var fatal *FatalError
+	if errors.As(err, &fatal) {
+		log.Warn("The manager had a fatal error while processing a message. Blowing up our application")
+		accord.Shutdown(err)
+		return err
+	}
+
+	var conflict *ConflictError
+	if errors.As(err, &conflict) {
+		return accord.handleConflict(msg, conflict)
+	}
+
+	log.Warn("The manager had an error while processing a message")
+	return accord.failManager(msg, err)
+}
+
+// handleConflict hands a Message our Manager found a conflict in to OnConflict, dead lettering it if
+// that doesn't settle it
+func (accord *Accord) handleConflict(msg *Message, conflict *ConflictError) error {
+	log := WithMessage(accord.Logger, msg).WithError(conflict)
+	log.Warn("The manager found a conflict while processing a message")
+	accord.metrics().Count(MetricMessagesConflicted, 1)
+	accord.Emit(EventMessageConflict, "The manager found a conflict while processing a message", map[string]interface{}{"id": msg.ID, "origin": msg.Origin, "error": conflict.Error()})
+
+	if accord.OnConflict != nil {
+		err := accord.OnConflict(msg, conflict)
+		if err == nil {
+			return &StageError{Stage: "conflict", Err: conflict}
+		}
+		log.WithError(err).Warn("We could not settle a conflict, so the message will be dead lettered")
+	}
+
+	err := accord.recordLetters(DeadLetter{Peer: accord.NodeID, Reason: conflict.Error(), Conflict: true}, []*Message{msg})
+	if err != nil {
+		return err
+	}
+	return &StageError{Stage: "conflict", Err: conflict}
+}
@@ -0,0 +1,121 @@
+package accord
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/beeker1121/goque"
+	"github.com/stretchr/testify/assert"
+)
+
+// failingManager fails Messages whose payload names a kind of error, until it's told to stop failing
+type failingManager struct {
+	failing bool
+}
+
+func (manager *failingManager) Process(msg *Message, fromRemote bool) error {
+	if !manager.failing {
+		return nil
+	}
+	switch string(msg.Payload) {
+	case "retryable":
+		return &RetryableError{Err: errors.New("database unavailable")}
+	case "fatal":
+		return &FatalError{Err: errors.New("database corrupt")}
+	case "conflict":
+		return &ConflictError{Err: errors.New("row was changed")}
+	}
+	return nil
+}
+
+func (manager *failingManager) ShouldProcess(msg Message, history *goque.Stack) bool {
+	return true
+}
+
+func TestRetryableError(t *testing.T) {
+	defer AccordCleanup()
+	manager := &failingManager{failing: true}
+	accord := NewAccord(manager, nil, "", DummyAccord().Logger)
+	accord.NodeID = "local"
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	remote, _ := NewMessage([]byte("retryable"))
+	remote.Origin = "remote"
+	remote.Clock = VectorClock{"remote": 1}
+	err := accord.HandleRemoteMessage(remote)
+	_, ok := err.(*RetryableError)
+	assert.True(t, ok)
+
+	// Nothing was recorded, so once it's sent again it goes through
+	assert.Equal(t, uint64(0), accord.state.Delivered("remote"))
+	assert.Equal(t, uint64(0), accord.History().Len())
+	manager.failing = false
+	assert.Nil(t, accord.HandleRemoteMessage(remote))
+	assert.Equal(t, uint64(1), accord.state.Delivered("remote"))
+}
+
+func TestFatalError(t *testing.T) {
+	defer AccordCleanup()
+	accord := NewAccord(&failingManager{failing: true}, nil, "", DummyAccord().Logger)
+	accord.PanicPolicy = PanicDeadLetter
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- <-accord.shutdown }()
+
+	msg, _ := NewMessage([]byte("fatal"))
+	err := accord.HandleNewMessage(msg)
+	_, ok := err.(*FatalError)
+	assert.True(t, ok)
+	assert.Equal(t, err, <-shutdown)
+}
+
+func TestConflictError(t *testing.T) {
+	defer AccordCleanup()
+	accord := NewAccord(&failingManager{failing: true}, nil, "", DummyAccord().Logger)
+	accord.NodeID = "local"
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	// Without OnConflict the Message is dead lettered
+	msg, _ := NewMessage([]byte("conflict"))
+	err := accord.HandleNewMessage(msg)
+	rejected, ok := err.(*StageError)
+	if assert.True(t, ok) {
+		assert.Equal(t, "conflict", rejected.Stage)
+	}
+	letters, err := accord.DeadLetters()
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(letters)) {
+		assert.Equal(t, "local", letters[0].Peer)
+		assert.Equal(t, "conflict: row was changed", letters[0].Reason)
+	}
+
+	// OnConflict can settle it instead, and if it can't it's dead lettered after all
+	settled := []uint64{}
+	accord.OnConflict = func(msg *Message, err *ConflictError) error {
+		if msg.Origin == "remote" {
+			return errors.New("can't settle that")
+		}
+		settled = append(settled, msg.ID)
+		return nil
+	}
+	msg, _ = NewMessage([]byte("conflict"))
+	_, ok = accord.HandleNewMessage(msg).(*StageError)
+	assert.True(t, ok)
+	assert.Equal(t, []uint64{msg.ID}, settled)
+
+	remote, _ := NewMessage([]byte("conflict"))
+	remote.Origin = "remote"
+	remote.Clock = VectorClock{"remote": 1}
+	_, ok = accord.HandleRemoteMessage(remote).(*StageError)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(1), accord.state.Delivered("remote"))
+
+	letters, err = accord.DeadLetters()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(letters))
+	assert.Equal(t, uint64(0), accord.History().Len())
+}
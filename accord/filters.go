@@ -0,0 +1,119 @@
+package accord
+
+import (
+	"fmt"
+	"path"
+)
+
+// EventMessageFiltered is emitted when a remote Message is dropped or quarantined by one of our Filters
+const EventMessageFiltered = "message_filtered"
+
+// FilterAction is what happens to a remote Message one of our Filters matches
+type FilterAction int
+
+const (
+	// FilterDrop drops the Message as though the Manager's ShouldProcess had refused it
+	FilterDrop FilterAction = iota
+
+	// FilterQuarantine sets the Message aside as a dead letter under our own NodeID instead of processing
+	// it, so that an operator can look into it
+	FilterQuarantine
+)
+
+func (action FilterAction) String() string {
+	switch action {
+	case FilterDrop:
+		return "drop"
+	case FilterQuarantine:
+		return "quarantine"
+	}
+	return fmt.Sprintf("FilterAction(%d)", int(action))
+}
+
+// FilterRule picks out a class of remote Messages to keep away from our Manager, which operators can
+// change while we're running (see SetFilters) rather than deploying a new Manager. A rule matches a
+// Message when every one of its conditions does; conditions that are left empty match anything. Patterns
+// are shell patterns (see path.Match), so "user.*" matches every type starting with "user."
+type FilterRule struct {
+	// Name identifies the rule in our logs, events and dead letters
+	Name string
+
+	// Action is what happens to the Messages the rule matches
+	Action FilterAction
+
+	// Types and Origins match a Message whose Type, or Origin, matches any one of their patterns
+	Types   []string
+	Origins []string
+
+	// Headers match a Message whose header values match the pattern given for every one of them. A header
+	// the Message doesn't have is matched as if it were empty
+	Headers map[string]string
+}
+
+// Matches reports whether the rule matches a Message
+func (rule FilterRule) Matches(msg *Message) bool {
+	if len(rule.Types) > 0 && !matchAny(rule.Types, msg.Type) {
+		return false
+	}
+	if len(rule.Origins) > 0 && !matchAny(rule.Origins, msg.Origin) {
+		return false
+	}
+	for name, pattern := range rule.Headers {
+		if matched, _ := path.Match(pattern, msg.Headers[name]); !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// matchAny reports whether a value matches any one of the patterns
+func matchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, value); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// SetFilters replaces our Filters while we're running. Remote Messages we receive from then on are checked
+// against the new rules
+func (accord *Accord) SetFilters(rules []FilterRule) {
+	accord.filtersMutex.Lock()
+	defer accord.filtersMutex.Unlock()
+	accord.Filters = append([]FilterRule{}, rules...)
+}
+
+// matchFilter returns the first of our Filters matching a Message, if any does
+func (accord *Accord) matchFilter(msg *Message) (FilterRule, bool) {
+	accord.filtersMutex.RLock()
+	defer accord.filtersMutex.RUnlock()
+
+	for _, rule := range accord.Filters {
+		if rule.Matches(msg) {
+			return rule, true
+		}
+	}
+	return FilterRule{}, false
+}
+
+// filterRemote checks a remote Message against our Filters, dealing with it if one of them matches.
+// It returns whether the Message was filtered out
+func (accord *Accord) filterRemote(msg *Message) (bool, error) {
+	rule, matched := accord.matchFilter(msg)
+	if !matched {
+		return false, nil
+	}
+
+	WithMessage(accord.Logger, msg).WithField("rule", rule.Name).WithField("action", rule.Action.String()).Info("A remote message was filtered out")
+	accord.metrics().Count(MetricMessagesFiltered, 1)
+	accord.Emit(EventMessageFiltered, "A remote message was filtered out", map[string]interface{}{"id": msg.ID, "origin": msg.Origin, "rule": rule.Name, "action": rule.Action.String()})
+
+	if rule.Action == FilterQuarantine {
+		err := accord.recordDeadLetters(accord.NodeID, fmt.Sprintf("quarantined by filter %q", rule.Name), []*Message{msg})
+		if err != nil {
+			return true, err
+		}
+	}
+	return true, accord.markDelivered(msg)
+}
@@ -0,0 +1,50 @@
+package accord
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterRuleMatches(t *testing.T) {
+	msg := &Message{Type: "user.update", Origin: "edge-1", Headers: map[string]string{"tenant": "acme"}}
+
+	assert.True(t, FilterRule{}.Matches(msg))
+	assert.True(t, FilterRule{Types: []string{"order.*", "user.*"}}.Matches(msg))
+	assert.False(t, FilterRule{Types: []string{"order.*"}}.Matches(msg))
+	assert.True(t, FilterRule{Types: []string{"user.*"}, Origins: []string{"edge-*"}}.Matches(msg))
+	assert.False(t, FilterRule{Types: []string{"user.*"}, Origins: []string{"hub"}}.Matches(msg))
+	assert.True(t, FilterRule{Headers: map[string]string{"tenant": "acme"}}.Matches(msg))
+	assert.False(t, FilterRule{Headers: map[string]string{"tenant": "acme", "region": "eu"}}.Matches(msg))
+}
+
+func TestFilters(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	accord.NodeID = "local"
+	accord.Filters = []FilterRule{{Name: "no deletes", Action: FilterDrop, Types: []string{"user.delete"}}}
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	assert.Nil(t, accord.HandleRemoteMessage(&Message{ID: 1, Type: "user.delete", Origin: "remote"}))
+	assert.Nil(t, accord.HandleRemoteMessage(&Message{ID: 2, Type: "user.update", Origin: "remote"}))
+	assert.Equal(t, uint64(1), accord.History().Len())
+
+	// Filters can be changed while we're running, and quarantined Messages are kept as dead letters
+	accord.SetFilters([]FilterRule{{Name: "suspect edge", Action: FilterQuarantine, Origins: []string{"remote"}}})
+	assert.Nil(t, accord.HandleRemoteMessage(&Message{ID: 3, Type: "user.delete", Origin: "remote"}))
+	assert.Nil(t, accord.HandleRemoteMessage(&Message{ID: 4, Type: "user.create", Origin: "other"}))
+	assert.Equal(t, uint64(2), accord.History().Len())
+
+	letters, err := accord.DeadLetters()
+	assert.Nil(t, err)
+	if assert.Len(t, letters, 1) {
+		assert.Equal(t, uint64(3), letters[0].Message.ID)
+		assert.Equal(t, "local", letters[0].Peer)
+		assert.Equal(t, `quarantined by filter "suspect edge"`, letters[0].Reason)
+	}
+
+	// Our own Messages are never filtered
+	assert.Nil(t, accord.HandleNewMessage(&Message{ID: 5, Type: "user.delete"}))
+	assert.Equal(t, uint64(3), accord.History().Len())
+}
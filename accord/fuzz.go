@@ -0,0 +1,96 @@
+package accord
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// FuzzDecodeMessage is an entry point for fuzzing (in the style of go-fuzz) the decoding of Messages we
+// receive over the wire. It returns 1 for input that decodes to a Message and 0 for input that doesn't,
+// which should simply be refused. Whatever it's given it mustn't crash; the only time it panics is when a
+// Message it decoded comes out different after being serialized again, which would mean Messages change
+// as they're passed along from peer to peer
+func FuzzDecodeMessage(data []byte) int {
+	msg, err := DeserializeMessage(data)
+	if err != nil {
+		return 0
+	}
+
+	// A Message that can't be serialized again is refused when we try to store it, which is fine
+	data, err = msg.Serialize()
+	if err != nil {
+		return 0
+	}
+	again, err := DeserializeMessage(data)
+	if err != nil {
+		panic(fmt.Sprintf("a serialized message could not be decoded again: %s", err))
+	}
+	if !sameMessage(msg, again) {
+		panic(fmt.Sprintf("message %d changed when it was serialized again", msg.ID))
+	}
+	return 1
+}
+
+// sameMessage reports whether two Messages hold the same fields. Empty maps and nil ones are considered the
+// same, as they can't be told apart once serialized
+func sameMessage(a *Message, b *Message) bool {
+	if a.ID != b.ID || !a.Timestamp.Equal(b.Timestamp) || a.StateAt != b.StateAt || a.Type != b.Type ||
+		a.Origin != b.Origin || a.Key != b.Key || a.KeySeq != b.KeySeq || a.IdempotencyKey != b.IdempotencyKey ||
+		a.CorrelationID != b.CorrelationID || a.CausationID != b.CausationID || a.Channel != b.Channel || a.HLC != b.HLC || !bytes.Equal(a.Payload, b.Payload) {
+		return false
+	}
+	if len(a.Headers) != len(b.Headers) || len(a.Clock) != len(b.Clock) {
+		return false
+	}
+	for name, value := range a.Headers {
+		if other, ok := b.Headers[name]; !ok || other != value {
+			return false
+		}
+	}
+	for node, count := range a.Clock {
+		if other, ok := b.Clock[node]; !ok || other != count {
+			return false
+		}
+	}
+	return true
+}
+
+// FuzzApplyRemote is an entry point for fuzzing the handling of remote Messages. It decodes its input like
+// FuzzDecodeMessage and hands whatever Message comes out to HandleRemoteMessage, as a transport would,
+// returning 1 if it got that far and 0 otherwise. We must already have been started.
+//
+// A Message is free to be refused, but whatever happens to it our state must still match our history
+// afterwards: every Message added to our history must have been added to our state, and nothing else.
+// FuzzApplyRemote panics when that isn't the case, as it means our state database has been corrupted. As
+// it's looking at our state, it shouldn't be run alongside anything else handling Messages
+func (accord *Accord) FuzzApplyRemote(data []byte) int {
+	msg, err := DeserializeMessage(data)
+	if err != nil {
+		return 0
+	}
+
+	history := accord.History()
+	length := history.Len()
+	digest := accord.state.Digest()
+
+	// Whether the Message was refused doesn't matter, only what it did to our state
+	accord.HandleRemoteMessage(msg)
+
+	// Depending on our Ordering, handling the Message may release others that were held back for it, so
+	// our history may have grown by more than one
+	grown := history.Len()
+	if grown < length {
+		panic(fmt.Sprintf("handling message %d removed messages from our history", msg.ID))
+	}
+	for offset := uint64(0); offset < grown-length; offset++ {
+		applied, err := history.Get(offset)
+		if err != nil {
+			panic(fmt.Sprintf("a message added to our history could not be read back: %s", err))
+		}
+		digest.add(applied.ID)
+	}
+	if accord.state.Digest() != digest {
+		panic(fmt.Sprintf("our state no longer matches our history after handling message %d", msg.ID))
+	}
+	return 1
+}
@@ -0,0 +1,61 @@
+package accord
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fuzzSeeds returns a few well formed Messages to start fuzzing from
+func fuzzSeeds(f *testing.F) {
+	msg := Message{Timestamp: time.Date(1985, time.October, 10, 26, 0, 0, 0, time.UTC), Payload: []byte("hello")}
+	msg.genID()
+	data, _ := msg.Serialize()
+	f.Add(data)
+
+	msg = Message{
+		Timestamp: time.Date(2016, time.March, 1, 12, 0, 0, 0, time.FixedZone("EST", -5*60*60)),
+		Type:      "user.update",
+		Origin:    "elsewhere",
+		Key:       "user-1",
+		KeySeq:    1,
+		Channel:   "telemetry",
+		Headers:   map[string]string{"trace": "abc"},
+		Clock:     VectorClock{"elsewhere": 1},
+		HLC:       HLCTimestamp{Wall: 1, Logical: 2},
+		Payload:   []byte("{}"),
+	}
+	msg.genID()
+	data, _ = msg.Serialize()
+	f.Add(data)
+
+	f.Add([]byte{})
+	f.Add([]byte("not a message"))
+	f.Add(data[:len(data)/2])
+}
+
+func FuzzDeserialize(f *testing.F) {
+	fuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		FuzzDecodeMessage(data)
+	})
+}
+
+func FuzzHandleRemote(f *testing.F) {
+	// Each of the fuzzing processes needs a data directory of its own
+	dir, err := ioutil.TempDir("", "accord-fuzz")
+	assert.Nil(f, err)
+	defer os.RemoveAll(dir)
+
+	accord := NewAccord(NewDummerManager(), nil, dir, DummyAccord().Logger)
+	assert.Nil(f, accord.Start())
+	defer accord.Stop()
+
+	fuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		accord.FuzzApplyRemote(data)
+	})
+}
@@ -0,0 +1,392 @@
+package accord
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"path"
+
+	"github.com/beeker1121/goque"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// groupKey marks a group of Messages being recorded in our history (see HandleNewMessages). It holds the
+// ID of the newest history item from before the group, and is cleared along with our state being updated
+// with the group, so if it's still there when we start the group never made it and is rolled back
+const groupKey = "group"
+
+// groupQueueKey marks a group of Messages that's been recorded and applied to our state but not yet
+// completely queued to be synchronized. It holds how many of them have been queued so far, followed by
+// the history item IDs of every one of them, and is swapped in for groupKey along with our state being
+// updated, so if it's still there when we start the rest of the group is queued then
+const groupQueueKey = "group-queue"
+
+// HandleNewMessages is HandleNewMessage for a group of Messages making up a single operation, which are
+// handled atomically: either every one of them is applied to our state, recorded in our history and
+// queued to be synchronized, or none of them are. If any of them would be refused (a duplicate, say, or
+// one rejected by its pipeline) none of them are processed, and the error is returned for the first.
+//
+// The Messages are processed by their Managers in the order they're given, and only once all of them
+// have been is our state updated, so a Manager failing on one leaves none of them in our state or history.
+// The Managers have already processed the ones before it by then, however, so it's up to them to undo
+// that if it matters, which a TransactionalManager does by rolling them back. Our state is updated with
+// the whole group at once, and should we go down part way through recording it in our history the part
+// that was recorded is rolled back when we start again. Once our state has the group, the group is
+// queued as a whole too: should we fail or go down part way through queueing it, whatever wasn't queued
+// is queued when we start again
+func (accord *Accord) HandleNewMessages(msgs ...*Message) error {
+	return accord.HandleNewMessagesContext(context.Background(), msgs...)
+}
+
+// HandleNewMessagesContext is HandleNewMessages for a group of Messages created as part of a larger
+// operation. Their spans are recorded as children of the span in ctx, if there is one
+func (accord *Accord) HandleNewMessagesContext(ctx context.Context, msgs ...*Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	channels := make([]*channel, len(msgs))
+	for i, msg := range msgs {
+		err := accord.refuseIfReplica(msg)
+		if err != nil {
+			return err
+		}
+		channels[i] = accord.channelFor(msg.Channel)
+		if channels[i] == nil {
+			return ErrUnknownChannel
+		}
+	}
+	for _, msg := range msgs {
+		err := accord.validateNew(msg)
+		if err != nil {
+			return err
+		}
+	}
+	for i, ch := range channels {
+		// Shedding only part of a group would break it up, so a group that doesn't fit is refused instead
+		if accord.QueueFullPolicy == QueueFullShed && accord.queueFull(ch) {
+			return ErrQueueFull
+		}
+		done, err := accord.waitForRoom(ch, msgs[i])
+		if done {
+			return err
+		}
+	}
+
+	accord.processMutex.Lock()
+	defer accord.processMutex.Unlock()
+
+	if accord.Paused() {
+		return ErrPaused
+	}
+	if accord.Draining() {
+		return ErrDraining
+	}
+	err := accord.checkWritable()
+	if err != nil {
+		return err
+	}
+	if accord.diskQuotaRejecting() {
+		return ErrDiskQuota
+	}
+
+	err = accord.stampGroup(ctx, msgs)
+	if err != nil {
+		return err
+	}
+
+	pipes := make([]*pipeline, len(msgs))
+	for i, msg := range msgs {
+		pipes[i] = accord.pipelineFor(msg)
+		err = pipes[i].runBefore(msg, false)
+		if err != nil {
+			WithMessage(accord.Logger, msg).WithError(err).Info("A new message was rejected by its pipeline, along with the rest of its group")
+			accord.metrics().Count(MetricMessagesRejected, 1)
+			accord.Emit(EventMessageRejected, "A new message was rejected by its pipeline", map[string]interface{}{"id": msg.ID, "error": err.Error()})
+			accord.recordAudit(msg, false, AuditRejected, err)
+			return err
+		}
+	}
+
+	accord.SampledDebug(accord.Logger.WithField("messages", len(msgs)), "Processing a new group of messages")
+	txs := []*managerTx{}
+	for _, msg := range msgs {
+		tx, err := accord.runManager(ctx, msg, false)
+		if err != nil {
+			rollbackAll(txs)
+			accord.recordAudit(msg, false, AuditApplied, err)
+			return err
+		}
+		txs = append(txs, tx)
+	}
+
+	data, ids, err := accord.recordGroup(msgs, txs)
+	if err != nil {
+		return err
+	}
+	for i, msg := range msgs {
+		accord.recordAudit(msg, false, AuditApplied, nil)
+		for _, err := range pipes[i].runAfter(msg, false) {
+			WithMessage(accord.Logger, msg).WithError(err).Warn("A pipeline stage failed after a message was applied")
+		}
+	}
+
+	err = accord.queueGroup(msgs, channels, data, ids, 0)
+	if err != nil {
+		return err
+	}
+	accord.metrics().Count(MetricMessagesCreated, int64(len(msgs)))
+	return nil
+}
+
+// groupQueueMarker encodes a groupQueueKey marker
+func groupQueueMarker(queued int, ids []uint64) []byte {
+	marker := make([]byte, 8*(len(ids)+1))
+	binary.BigEndian.PutUint64(marker, uint64(queued))
+	for i, id := range ids {
+		binary.BigEndian.PutUint64(marker[8*(i+1):], id)
+	}
+	return marker
+}
+
+// queueGroup queues a recorded group of Messages to be synchronized, starting from the one at index from
+// (as the ones before it already are), keeping its groupQueueKey marker up to date as it goes and clearing
+// it once the whole group is queued. Going down between queueing a Message and updating the marker means
+// that Message is queued again when we start, which our remotes drop as a duplicate. The caller must hold
+// processMutex
+func (accord *Accord) queueGroup(msgs []*Message, channels []*channel, data [][]byte, ids []uint64, from int) error {
+	for i := from; i < len(msgs); i++ {
+		shard := accord.shardFor(channels[i], msgs[i])
+		_, err := accord.enqueueMessage(shard, msgs[i], data[i])
+		if err == nil {
+			// The marker can't get ahead of what's actually in our queues, even while we batch our writes
+			err = accord.flushShard(shard)
+		}
+		if err == nil {
+			if i < len(msgs)-1 {
+				err = accord.state.db.Put([]byte(groupQueueKey), groupQueueMarker(i+1, ids), accord.state.writeOptions)
+			} else {
+				err = accord.state.db.Delete([]byte(groupQueueKey), accord.state.writeOptions)
+			}
+		}
+		if err != nil {
+			return accord.failWrite(err, "We could not queue a message for synchronization")
+		}
+	}
+	return nil
+}
+
+// stampGroup checks a group of new Messages for duplicates and stamps them the way handleNewMessage stamps
+// a single one, as though each was applied in turn. The caller must hold processMutex
+func (accord *Accord) stampGroup(ctx context.Context, msgs []*Message) error {
+	clock := accord.state.Clock()
+	stateAt := accord.state.GetCurrent()
+	keySeqs := map[string]uint64{}
+	idempotencyKeys := map[string]bool{}
+
+	for _, msg := range msgs {
+		duplicate, err := accord.isDuplicate(msg)
+		if err != nil {
+			return err
+		}
+		if msg.IdempotencyKey != "" && idempotencyKeys[msg.IdempotencyKey] {
+			duplicate = true
+		}
+		if duplicate {
+			accord.SampledDebug(WithMessage(accord.Logger, msg).WithField("idempotency_key", msg.IdempotencyKey), "Refusing a group of new messages with one that duplicates another")
+			return ErrDuplicate
+		}
+		idempotencyKeys[msg.IdempotencyKey] = true
+
+		if msg.Origin == "" {
+			msg.Origin = accord.NodeID
+		}
+		correlate(ctx, msg)
+
+		clock.Increment(accord.NodeID)
+		msg.Clock = clock.Copy()
+		msg.HLC = accord.hlc.Now()
+		msg.StateAt = stateAt
+		stateAt += msg.ID
+		if msg.Key != "" {
+			id := msg.Origin + "\x00" + msg.Key
+			seq, ok := keySeqs[id]
+			if !ok {
+				seq, err = accord.state.KeySeq(msg.Origin, msg.Key)
+				if err != nil {
+					WithMessage(accord.Logger, msg).WithError(err).Warn("We could not read the sequence for a message's key")
+					return err
+				}
+			}
+			msg.KeySeq = seq + 1
+			keySeqs[id] = msg.KeySeq
+		}
+	}
+	return nil
+}
+
+// recordGroup records a processed group of Messages in our history and updates our state with them,
+// committing their transactions (see TransactionalManager) and returning their serialized data along
+// with their history item IDs. If anything fails the transactions that haven't been committed are rolled
+// back. Once they've all been committed there's no going back on them: failing to write our state down
+// after that blows up our application with our Manager ahead of us, and the group, which is still marked
+// as part way through being recorded, is taken back out of our history when we start up again. The caller
+// must hold processMutex
+func (accord *Accord) recordGroup(msgs []*Message, txs []*managerTx) ([][]byte, []uint64, error) {
+	data := make([][]byte, len(msgs))
+	for i, msg := range msgs {
+		encoded, err := accord.sealer.encodeMessage(msg)
+		if err != nil {
+			rollbackAll(txs)
+			WithMessage(accord.Logger, msg).WithError(err).Warn("We could not serialize a processed message. Blowing up our application")
+			accord.Shutdown(err)
+			return nil, nil, err
+		}
+		data[i] = encoded
+	}
+
+	top := uint64(0)
+	if item, err := accord.historyStack.Peek(); err == nil {
+		top = item.ID
+	}
+	marker := make([]byte, 8)
+	binary.BigEndian.PutUint64(marker, top)
+	err := accord.state.db.Put([]byte(groupKey), marker, accord.state.writeOptions)
+	if err != nil {
+		rollbackAll(txs)
+		return nil, nil, accord.failWrite(err, "We could not mark the start of a group of messages")
+	}
+
+	ids := make([]uint64, len(msgs))
+	for i := range msgs {
+		item, err := accord.historyStack.Push(data[i])
+		if err != nil {
+			rollbackAll(txs)
+			return nil, nil, accord.failWrite(err, "We could not record a message in our history")
+		}
+		ids[i] = item.ID
+	}
+	accord.syncQueueWrite(path.Join(accord.dataDir, HistoryFilename))
+
+	// Our state is updated with the whole group at once, swapping its marker for the one that sees it
+	// queued along with it, rather than being left for a later flush
+	var failed *managerTx
+	var commitErr error
+	committed := false
+	batch := new(leveldb.Batch)
+	for i, msg := range msgs {
+		accord.indexMessage(batch, msg, ids[i])
+	}
+	batch.Delete([]byte(groupKey))
+	batch.Put([]byte(groupQueueKey), groupQueueMarker(0, ids))
+	err = accord.state.update(msgs, batch, func() error {
+		failed, commitErr = commitAll(txs)
+		committed = commitErr == nil
+		return commitErr
+	})
+	if err == nil {
+		err = accord.state.Flush()
+	}
+	if commitErr != nil {
+		_, err = accord.rollbackGroup(top)
+		if err != nil {
+			return nil, nil, accord.failWrite(err, "We could not roll back a group of messages")
+		}
+		return nil, nil, accord.handleManagerError(failed.msg, commitErr)
+	}
+	if err != nil {
+		if !committed {
+			rollbackAll(txs)
+		}
+		return nil, nil, accord.failWrite(err, "We could not update our internal state")
+	}
+
+	accord.metrics().Count(MetricMessagesApplied, int64(len(msgs)))
+	return data, ids, nil
+}
+
+// recoverGroup rolls back a group of Messages we went down part way through recording (see
+// HandleNewMessages), removing whatever of it made it into our history. Our state is only updated with a
+// group once it's completely recorded, so it never saw any of it. A group we went down part way through
+// queueing is queued the rest of the way instead
+func (accord *Accord) recoverGroup() error {
+	marker, err := accord.state.db.Get([]byte(groupKey), nil)
+	if err == leveldb.ErrNotFound {
+		return accord.recoverGroupQueue()
+	}
+	if err != nil {
+		return err
+	}
+
+	removed, err := accord.rollbackGroup(binary.BigEndian.Uint64(marker))
+	if err != nil {
+		return err
+	}
+	accord.Logger.WithField("removed", removed).Warn("We went down part way through recording a group of messages. Rolling it back")
+	return nil
+}
+
+// recoverGroupQueue queues whatever of a recorded group of Messages we failed or went down before queueing
+// (see queueGroup), reading them back from our history
+func (accord *Accord) recoverGroupQueue() error {
+	marker, err := accord.state.db.Get([]byte(groupQueueKey), nil)
+	if err == leveldb.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	queued := int(binary.BigEndian.Uint64(marker))
+	ids := []uint64{}
+	for offset := 8; offset+8 <= len(marker); offset += 8 {
+		ids = append(ids, binary.BigEndian.Uint64(marker[offset:]))
+	}
+	msgs := make([]*Message, len(ids))
+	channels := make([]*channel, len(ids))
+	data := make([][]byte, len(ids))
+	for i := queued; i < len(ids); i++ {
+		item, err := accord.historyStack.PeekByID(ids[i])
+		if err != nil {
+			return err
+		}
+		msgs[i], err = accord.sealer.decodeMessage(item.Value)
+		if err != nil {
+			return err
+		}
+		data[i] = item.Value
+		channels[i] = accord.channelFor(msgs[i].Channel)
+		if channels[i] == nil {
+			return fmt.Errorf("message %d of an interrupted group is on channel %q, which we no longer have", msgs[i].ID, msgs[i].Channel)
+		}
+	}
+
+	accord.Logger.WithField("remaining", len(ids)-queued).Warn("We went down part way through queueing a group of messages. Queueing the rest of it")
+	accord.processMutex.Lock()
+	defer accord.processMutex.Unlock()
+	return accord.queueGroup(msgs, channels, data, ids, queued)
+}
+
+// rollbackGroup removes a group of Messages from our history, given the ID of the newest history item
+// from before it, and clears its marker, returning how many were removed
+func (accord *Accord) rollbackGroup(top uint64) (int, error) {
+	removed := 0
+	for {
+		item, err := accord.historyStack.Peek()
+		if err == goque.ErrEmpty {
+			break
+		}
+		if err != nil {
+			return removed, err
+		}
+		if item.ID <= top {
+			break
+		}
+		_, err = accord.historyStack.Pop()
+		if err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, accord.state.db.Delete([]byte(groupKey), accord.state.writeOptions)
+}
@@ -0,0 +1,130 @@
+package accord
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleNewMessages(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	accord.NodeID = "local"
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	assert.Nil(t, accord.HandleNewMessage(&Message{ID: 1, Key: "user"}))
+	assert.Nil(t, accord.HandleNewMessages(&Message{ID: 2, Key: "user"}, &Message{ID: 3}, &Message{ID: 4, Key: "user"}))
+	assert.Equal(t, uint64(4), accord.History().Len())
+	assert.Equal(t, uint64(4), accord.QueueDepth())
+	assert.Equal(t, uint64(10), accord.CurrentState())
+	assert.Equal(t, VectorClock{"local": 4}, accord.Clock())
+
+	// Each Message is stamped as though they were handled one after the other
+	msgs := []*Message{}
+	for offset := uint64(3); offset > 0; offset-- {
+		msg, err := accord.History().Get(offset - 1)
+		assert.Nil(t, err)
+		msgs = append(msgs, msg)
+	}
+	assert.Equal(t, []uint64{1, 3, 6}, []uint64{msgs[0].StateAt, msgs[1].StateAt, msgs[2].StateAt})
+	assert.Equal(t, VectorClock{"local": 3}, msgs[1].Clock)
+	assert.Equal(t, []uint64{2, 0, 3}, []uint64{msgs[0].KeySeq, msgs[1].KeySeq, msgs[2].KeySeq})
+	assert.Equal(t, "local", msgs[2].Origin)
+
+	// Nothing afterwards notices the group was handled any differently
+	assert.Nil(t, accord.HandleNewMessage(&Message{ID: 5, Key: "user"}))
+	latest, err := accord.History().Get(0)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(4), latest.KeySeq)
+	assert.Equal(t, uint64(10), latest.StateAt)
+}
+
+func TestHandleNewMessagesRefused(t *testing.T) {
+	defer AccordCleanup()
+	manager := &failingManager{failing: true}
+	accord := NewAccord(manager, nil, "", DummyAccord().Logger)
+	accord.RegisterStage(Stage{Name: "nonempty", Phase: ValidatePhase, Run: func(msg *Message, fromRemote bool) error {
+		if len(msg.Payload) == 0 {
+			return errors.New("empty payload")
+		}
+		return nil
+	}})
+	assert.Nil(t, accord.SetPipeline("greeting", "nonempty"))
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	good := func(id uint64) *Message { return &Message{ID: id, Payload: []byte("fine")} }
+	assert.Equal(t, ErrDuplicate, accord.HandleNewMessages(&Message{ID: 1, IdempotencyKey: "once"}, &Message{ID: 2, IdempotencyKey: "once"}))
+	assert.NotNil(t, accord.HandleNewMessages(good(1), &Message{ID: 2, Type: "greeting"}))
+	assert.Equal(t, ErrUnknownChannel, accord.HandleNewMessages(good(1), &Message{ID: 2, Channel: "missing"}))
+
+	// A Manager failing on one leaves none of them recorded
+	_, ok := accord.HandleNewMessages(good(1), &Message{ID: 2, Payload: []byte("retryable")}).(*RetryableError)
+	assert.True(t, ok)
+
+	assert.Equal(t, uint64(0), accord.History().Len())
+	assert.Equal(t, uint64(0), accord.QueueDepth())
+	assert.Equal(t, uint64(0), accord.CurrentState())
+	assert.Empty(t, accord.Clock())
+}
+
+func TestHandleNewMessagesCrash(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	assert.Nil(t, accord.Start())
+	assert.Nil(t, accord.HandleNewMessage(&Message{ID: 1}))
+	digest := accord.Digest()
+
+	// Going down part way through recording a group leaves its marker behind along with part of the group
+	top, err := accord.historyStack.Peek()
+	assert.Nil(t, err)
+	marker := make([]byte, 8)
+	binary.BigEndian.PutUint64(marker, top.ID)
+	assert.Nil(t, accord.state.db.Put([]byte(groupKey), marker, nil))
+	data, err := (&Message{ID: 2}).Serialize()
+	assert.Nil(t, err)
+	_, err = accord.historyStack.Push(data)
+	assert.Nil(t, err)
+	accord.Stop()
+	crashUncleanly(t)
+
+	accord = DummyAccord()
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+	assert.Equal(t, uint64(1), accord.History().Len())
+	assert.Equal(t, digest, accord.Digest())
+	_, err = accord.state.db.Get([]byte(groupKey), nil)
+	assert.NotNil(t, err)
+}
+
+func TestHandleNewMessagesEnqueueFailure(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	accord.Channels = map[string]Manager{"config": nil}
+	assert.Nil(t, accord.Start())
+
+	// Failing to queue part of a group once it's applied blows us up, with the rest of it left waiting
+	assert.Nil(t, accord.channelFor("config").shards[0].queue.Close())
+	err := accord.HandleNewMessages(&Message{ID: 1}, &Message{ID: 2, Channel: "config"}, &Message{ID: 3})
+	assert.NotNil(t, err)
+	assert.NotNil(t, <-accord.shutdown)
+	assert.Equal(t, uint64(3), accord.History().Len())
+	assert.Equal(t, uint64(1), accord.Queue().Len())
+	accord.Stop()
+
+	// The rest of the group is queued when we start again, and nothing is queued twice
+	accord = DummyAccord()
+	accord.Channels = map[string]Manager{"config": nil}
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+	assert.Equal(t, uint64(2), accord.Queue().Len())
+	assert.Equal(t, uint64(1), accord.ChannelQueue("config").Len())
+	msg, err := accord.ChannelQueue("config").Get(0)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), msg.ID)
+	_, err = accord.state.db.Get([]byte(groupQueueKey), nil)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,112 @@
+package accord
+
+import (
+	"fmt"
+)
+
+// ProtocolVersion is the version of the protocol our transports speak, and MinProtocolVersion the oldest
+// one we can still speak to peers that haven't caught up with us. Nodes that predate handshakes altogether
+// speak version 1
+const (
+	ProtocolVersion    = 1
+	MinProtocolVersion = 1
+)
+
+// EventPeerIncompatible is emitted by transports when a handshake with a peer fails because it's
+// incompatible with us
+const EventPeerIncompatible = "peer_incompatible"
+
+// Hello is what a node introduces itself with when a transport connects it to a peer: who it is, the
+// protocol versions it speaks, and the codecs and compression it can read, in the order it prefers them.
+// Two nodes that can't agree on all of those (see Negotiate) shouldn't synchronize at all, rather than have
+// one of them dead letter everything the other sends as unreadable
+type Hello struct {
+	NodeID             string   `json:"nodeId"`
+	ProtocolVersion    int      `json:"protocolVersion"`
+	MinProtocolVersion int      `json:"minProtocolVersion"`
+	Codecs             []string `json:"codecs"`
+	Compression        []string `json:"compression"`
+}
+
+// Agreement is what a handshake settled on for talking to a peer
+type Agreement struct {
+	Peer            string
+	ProtocolVersion int
+	Codec           string
+	Compression     string
+}
+
+// IncompatibleError is returned by Negotiate when we can't talk to a peer. Retrying won't help until one
+// of us is upgraded or reconfigured
+type IncompatibleError struct {
+	Peer   string
+	Reason string
+}
+
+func (err *IncompatibleError) Error() string {
+	return fmt.Sprintf("peer %s is incompatible with us: %s", err.Peer, err.Reason)
+}
+
+// Hello returns our side of a handshake, for a transport that can read the given codecs and compression
+func (accord *Accord) Hello(codecs []string, compression []string) Hello {
+	return Hello{
+		NodeID:             accord.NodeID,
+		ProtocolVersion:    ProtocolVersion,
+		MinProtocolVersion: MinProtocolVersion,
+		Codecs:             codecs,
+		Compression:        compression,
+	}
+}
+
+// Negotiate settles on how we talk to a peer, given our Hello and theirs: the newest protocol version we
+// both speak, along with the first of our codecs and of our compression they can read too. Both sides
+// come to the same Agreement as long as they prefer the same things
+func Negotiate(ours Hello, theirs Hello) (Agreement, error) {
+	incompatible := func(format string, args ...interface{}) (Agreement, error) {
+		return Agreement{}, &IncompatibleError{Peer: theirs.NodeID, Reason: fmt.Sprintf(format, args...)}
+	}
+
+	if theirs.NodeID == "" {
+		return incompatible("it didn't say who it is")
+	}
+	if theirs.NodeID == ours.NodeID {
+		return incompatible("it has our NodeID, so it's either us or a copy of us")
+	}
+
+	version := ours.ProtocolVersion
+	if theirs.ProtocolVersion < version {
+		version = theirs.ProtocolVersion
+	}
+	if version < ours.MinProtocolVersion || version < theirs.MinProtocolVersion {
+		return incompatible("we speak protocol versions %d to %d, and it speaks %d to %d",
+			ours.MinProtocolVersion, ours.ProtocolVersion, theirs.MinProtocolVersion, theirs.ProtocolVersion)
+	}
+
+	codec := firstShared(ours.Codecs, theirs.Codecs)
+	if codec == "" {
+		return incompatible("we read %v, and it reads %v", ours.Codecs, theirs.Codecs)
+	}
+	compression := firstShared(ours.Compression, theirs.Compression)
+	if compression == "" {
+		return incompatible("we accept %v compression, and it accepts %v", ours.Compression, theirs.Compression)
+	}
+
+	return Agreement{Peer: theirs.NodeID, ProtocolVersion: version, Codec: codec, Compression: compression}, nil
+}
+
+// SpeaksProtocol reports whether we can make sense of what a peer sends us under a protocol version
+func SpeaksProtocol(version int) bool {
+	return version >= MinProtocolVersion && version <= ProtocolVersion
+}
+
+// firstShared returns the first of ours that's in theirs as well, or an empty string if there isn't one
+func firstShared(ours []string, theirs []string) string {
+	for _, mine := range ours {
+		for _, other := range theirs {
+			if mine == other {
+				return mine
+			}
+		}
+	}
+	return ""
+}
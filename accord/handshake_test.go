@@ -0,0 +1,40 @@
+package accord
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiate(t *testing.T) {
+	ours := Hello{NodeID: "hub", ProtocolVersion: 3, MinProtocolVersion: 2, Codecs: []string{"gob", "json"}, Compression: []string{"gzip", "identity"}}
+	theirs := Hello{NodeID: "edge", ProtocolVersion: 2, MinProtocolVersion: 1, Codecs: []string{"json", "gob"}, Compression: []string{"identity"}}
+
+	agreement, err := Negotiate(ours, theirs)
+	assert.Nil(t, err)
+	assert.Equal(t, Agreement{Peer: "edge", ProtocolVersion: 2, Codec: "gob", Compression: "identity"}, agreement)
+
+	incompatible := func(change func(*Hello)) string {
+		other := theirs
+		change(&other)
+		_, err := Negotiate(ours, other)
+		assert.IsType(t, &IncompatibleError{}, err)
+		if err == nil {
+			return ""
+		}
+		return err.Error()
+	}
+	assert.Contains(t, incompatible(func(hello *Hello) { hello.ProtocolVersion = 1 }), "we speak protocol versions 2 to 3, and it speaks 1 to 1")
+	assert.Contains(t, incompatible(func(hello *Hello) { hello.MinProtocolVersion = 4; hello.ProtocolVersion = 5 }), "it speaks 4 to 5")
+	assert.Contains(t, incompatible(func(hello *Hello) { hello.Codecs = []string{"protobuf"} }), "it reads [protobuf]")
+	assert.Contains(t, incompatible(func(hello *Hello) { hello.Compression = nil }), "compression")
+	assert.Contains(t, incompatible(func(hello *Hello) { hello.NodeID = "hub" }), "copy of us")
+	assert.Contains(t, incompatible(func(hello *Hello) { hello.NodeID = "" }), "didn't say who it is")
+}
+
+func TestSpeaksProtocol(t *testing.T) {
+	assert.True(t, SpeaksProtocol(ProtocolVersion))
+	assert.True(t, SpeaksProtocol(MinProtocolVersion))
+	assert.False(t, SpeaksProtocol(ProtocolVersion+1))
+	assert.False(t, SpeaksProtocol(0))
+}
@@ -0,0 +1,169 @@
+package accord
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HealthStatus is how healthy a node, or one of its Components, is
+type HealthStatus string
+
+const (
+	// HealthOK means everything is working as it should
+	HealthOK HealthStatus = "ok"
+
+	// HealthDegraded means we're still up but something needs looking at, like a Component reporting a
+	// problem or our data directory having gone read-only
+	HealthDegraded HealthStatus = "degraded"
+
+	// HealthDown means we aren't running, or a Component has stopped
+	HealthDown HealthStatus = "down"
+)
+
+// worse returns whichever of two statuses is the less healthy
+func (status HealthStatus) worse(other HealthStatus) HealthStatus {
+	rank := map[HealthStatus]int{HealthOK: 0, HealthDegraded: 1, HealthDown: 2}
+	if rank[other] > rank[status] {
+		return other
+	}
+	return status
+}
+
+// HealthyComponent can be implemented by Components that know more about their own health than whether
+// they're running, like a transport that can't reach any of its peers. Health returns nil while the
+// Component is healthy, and what's wrong with it otherwise
+type HealthyComponent interface {
+	Component
+	Health() error
+}
+
+// ComponentHealth is the health of one of our Components
+type ComponentHealth struct {
+	// Name identifies the Component; see Components
+	Name string `json:"name"`
+
+	Status HealthStatus `json:"status"`
+
+	// Detail says what's wrong, if anything is
+	Detail string `json:"detail,omitempty"`
+}
+
+// HealthReport describes how a node is doing, for orchestrators and load balancers to probe (see Health)
+type HealthReport struct {
+	// Status is the least healthy of the node itself and every one of its Components
+	Status HealthStatus `json:"status"`
+
+	Components []ComponentHealth `json:"components"`
+
+	// QueuedMessages is how many Messages are waiting to be synchronized, across every channel
+	QueuedMessages uint64 `json:"queued_messages"`
+
+	// LastSync is when we last sent Messages to a peer or received them from one, which is zero if we
+	// haven't since we started
+	LastSync time.Time `json:"last_sync"`
+
+	// LastError and LastErrorTime are the most recent error that went wrong inside of us, whether in a
+	// Component, our Manager or our stores
+	LastError     string    `json:"last_error,omitempty"`
+	LastErrorTime time.Time `json:"last_error_time"`
+
+	ReadOnly bool `json:"read_only"`
+	Paused   bool `json:"paused"`
+	Draining bool `json:"draining"`
+
+	// DataDirBytes is how big our data directory was when we last measured it, and OverDiskQuota whether
+	// that was over our DiskQuota
+	DataDirBytes  int64 `json:"data_dir_bytes"`
+	OverDiskQuota bool  `json:"over_disk_quota"`
+
+	// Peers is whether we've heard from each of our peers recently (see PeerLiveness)
+	Peers []PeerLiveness `json:"peers"`
+}
+
+// healthTracker keeps track of the parts of our health that aren't kept anywhere else
+type healthTracker struct {
+	// lastSync is (atomically) when we last synchronized with a peer, in Unix nanoseconds
+	lastSync int64
+
+	mutex         sync.Mutex
+	lastError     string
+	lastErrorTime time.Time
+}
+
+// recordSync records that we just synchronized with a peer
+func (accord *Accord) recordSync() {
+	atomic.StoreInt64(&accord.health.lastSync, time.Now().UnixNano())
+}
+
+// recordError records an error that went wrong inside of us for Health to report
+func (accord *Accord) recordError(err error) {
+	accord.health.mutex.Lock()
+	defer accord.health.mutex.Unlock()
+	accord.health.lastError = err.Error()
+	accord.health.lastErrorTime = time.Now().UTC()
+}
+
+// Health reports how we're doing. We're down while we aren't running or when any of our Components has
+// stopped, and degraded while a HealthyComponent reports a problem, our data directory is read-only or over
+// its DiskQuota, or we haven't heard from one of our peers in a while. Being paused or draining is done on
+// purpose, so it's reported but doesn't count against us
+func (accord *Accord) Health() HealthReport {
+	accord.componentsMutex.Lock()
+	started := accord.started
+	byName, names := accord.namedComponents()
+	accord.componentsMutex.Unlock()
+
+	report := HealthReport{
+		Status:     HealthOK,
+		Components: []ComponentHealth{},
+		ReadOnly:   accord.ReadOnly(),
+		Paused:     accord.Paused(),
+		Draining:   accord.Draining(),
+	}
+	if !started {
+		report.Status = HealthDown
+	} else {
+		report.QueuedMessages = accord.QueuedMessages()
+	}
+	if report.ReadOnly {
+		report.Status = report.Status.worse(HealthDegraded)
+	}
+	report.DataDirBytes = accord.DataDirBytes()
+	report.OverDiskQuota = accord.OverDiskQuota()
+	if report.OverDiskQuota {
+		report.Status = report.Status.worse(HealthDegraded)
+	}
+
+	for _, name := range names {
+		health := ComponentHealth{Name: name, Status: HealthOK}
+		comp := byName[name]
+		if running, ok := comp.(runningComponent); ok && !running.Running() {
+			health.Status = HealthDown
+			health.Detail = "not running"
+		} else if healthy, ok := comp.(HealthyComponent); ok {
+			if err := healthy.Health(); err != nil {
+				health.Status = HealthDegraded
+				health.Detail = err.Error()
+			}
+		}
+		report.Status = report.Status.worse(health.Status)
+		report.Components = append(report.Components, health)
+	}
+
+	report.Peers = accord.PeerLiveness()
+	for _, peer := range report.Peers {
+		if !peer.Healthy {
+			report.Status = report.Status.worse(HealthDegraded)
+		}
+	}
+
+	if lastSync := atomic.LoadInt64(&accord.health.lastSync); lastSync != 0 {
+		report.LastSync = time.Unix(0, lastSync).UTC()
+	}
+	accord.health.mutex.Lock()
+	report.LastError = accord.health.lastError
+	report.LastErrorTime = accord.health.lastErrorTime
+	accord.health.mutex.Unlock()
+	return report
+}
@@ -0,0 +1,49 @@
+package accord
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// unhealthyComponent reports whatever problem it's given
+type unhealthyComponent struct {
+	noopComponent
+	problem error
+}
+
+func (comp *unhealthyComponent) Health() error {
+	return comp.problem
+}
+
+func TestHealth(t *testing.T) {
+	defer AccordCleanup()
+	comp := &unhealthyComponent{}
+	accord := NewAccord(&failingManager{failing: true}, []Component{comp}, "", DummyAccord().Logger)
+	assert.Equal(t, HealthDown, accord.Health().Status)
+
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	report := accord.Health()
+	assert.Equal(t, HealthOK, report.Status)
+	assert.Equal(t, []ComponentHealth{{Name: "unhealthyComponent", Status: HealthOK}}, report.Components)
+	assert.True(t, report.LastSync.IsZero())
+	assert.Equal(t, "", report.LastError)
+
+	comp.problem = errors.New("no peers reachable")
+	msg, _ := NewMessage([]byte("hello"))
+	assert.Nil(t, accord.HandleNewMessage(msg))
+	msg, _ = NewMessage([]byte("retryable"))
+	assert.NotNil(t, accord.HandleNewMessage(msg))
+	accord.RecordSent(1)
+
+	report = accord.Health()
+	assert.Equal(t, HealthDegraded, report.Status)
+	assert.Equal(t, []ComponentHealth{{Name: "unhealthyComponent", Status: HealthDegraded, Detail: "no peers reachable"}}, report.Components)
+	assert.Equal(t, uint64(1), report.QueuedMessages)
+	assert.False(t, report.LastSync.IsZero())
+	assert.Equal(t, "retryable: database unavailable", report.LastError)
+	assert.False(t, report.LastErrorTime.IsZero())
+}
@@ -0,0 +1,250 @@
+package accord
+
+import (
+	"sync"
+	"time"
+
+	"github.com/beeker1121/goque"
+)
+
+// History is a read-only view over the history stack. It lets Managers and tooling look through the
+// Messages we've performed without having to pop items off of the goque.Stack and push them back
+// (which, besides being awkward, isn't safe to do while Accord is running)
+type History struct {
+	stack *goque.Stack
+
+	// state gives us access to our secondary indexes, it's only available on the History returned by
+	// Accord.History
+	state *State
+
+	// sealer decrypts our Messages when our data directory is encrypted (see EncryptionKey)
+	sealer *sealer
+}
+
+// openStacks holds the Accord every history stack we have open belongs to, so that NewHistory can give the
+// raw stack Managers are handed in ShouldProcess everything Accord.History would: its sealer and indexes
+var openStacks sync.Map
+
+// NewHistory wraps a history stack in a read-only view. This is mainly useful inside of ShouldProcess,
+// which is handed the raw stack
+func NewHistory(stack *goque.Stack) *History {
+	if accord, ok := openStacks.Load(stack); ok {
+		return accord.(*Accord).History()
+	}
+	return &History{stack: stack}
+}
+
+// History returns a read-only view over the Messages this Accord process has performed
+func (accord *Accord) History() *History {
+	return &History{stack: accord.historyStack, state: accord.state, sealer: accord.sealer}
+}
+
+// Len returns how many Messages are stored in our history
+func (history *History) Len() uint64 {
+	return history.stack.Length()
+}
+
+// Get returns the Message at the given offset from the top of the stack, where an offset of 0 is
+// the most recently performed Message
+func (history *History) Get(offset uint64) (*Message, error) {
+	item, err := history.stack.PeekByOffset(offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return history.sealer.decodeMessage(item.Value)
+}
+
+// Contains returns whether a Message with the given ID is in our history. This has to scan the history,
+// so it shouldn't be used in a hot path
+func (history *History) Contains(id uint64) (bool, error) {
+	iter := history.Query(HistoryFilter{Buckets: []int{DigestBucket(id)}})
+	for iter.Next() {
+		if iter.Message().ID == id {
+			return true, nil
+		}
+	}
+	return false, iter.Err()
+}
+
+// Query returns an iterator over every Message in our history that matches the passed in filter,
+// from the most recent to the oldest
+func (history *History) Query(filter HistoryFilter) *HistoryIterator {
+	iter := &HistoryIterator{stack: history.stack, sealer: history.sealer, filter: filter}
+
+	// We walk the stack by item ID rather than by offset so that Messages being pushed while we're
+	// iterating don't shift everything around underneath us
+	top, err := history.stack.Peek()
+	if err != nil {
+		if err != goque.ErrEmpty {
+			iter.err = err
+		}
+		iter.done = true
+		return iter
+	}
+	iter.nextID = top.ID
+
+	return iter
+}
+
+// HistoryFilter describes which Messages a HistoryIterator should return. Any field left as its zero
+// value doesn't filter anything
+type HistoryFilter struct {
+	// Since and Until bound the Message's Timestamp (inclusively)
+	Since time.Time
+	Until time.Time
+
+	// Types restricts the results to Messages with any of these Types
+	Types []string
+
+	// Origins restricts the results to Messages created by any of these nodes
+	Origins []string
+
+	// Buckets restricts the results to Messages whose IDs fall into any of these Digest buckets
+	Buckets []int
+
+	// CorrelationID restricts the results to Messages in this chain of operations
+	CorrelationID string
+
+	// Limit stops the iteration after this many matching Messages have been returned
+	Limit int
+}
+
+// Matches returns whether a Message passes the filter
+func (filter HistoryFilter) Matches(msg *Message) bool {
+	if !filter.Since.IsZero() && msg.Timestamp.Before(filter.Since) {
+		return false
+	}
+
+	if !filter.Until.IsZero() && msg.Timestamp.After(filter.Until) {
+		return false
+	}
+
+	if len(filter.Types) > 0 && !containsString(filter.Types, msg.Type) {
+		return false
+	}
+
+	if len(filter.Origins) > 0 && !containsString(filter.Origins, msg.Origin) {
+		return false
+	}
+
+	if len(filter.Buckets) > 0 && !containsInt(filter.Buckets, DigestBucket(msg.ID)) {
+		return false
+	}
+
+	if filter.CorrelationID != "" && msg.CorrelationID != filter.CorrelationID {
+		return false
+	}
+
+	return true
+}
+
+// HistoryIterator steps through the history stack from the most recent Message to the oldest. It
+// should be used like a bufio.Scanner:
+//
+//	iter := accord.History().Query(HistoryFilter{Types: []string{"user.update"}})
+//	for iter.Next() {
+//		msg := iter.Message()
+//	}
+//	if err := iter.Err(); err != nil {
+//		...
+//	}
+type HistoryIterator struct {
+	stack  *goque.Stack
+	sealer *sealer
+	filter HistoryFilter
+
+	// nextID is the next item we'll look at when walking the whole stack. If ids is set we walk through
+	// those item IDs instead (as is the case when using an index)
+	nextID uint64
+	ids    []uint64
+
+	current   *Message
+	currentID uint64
+	count     int
+	done      bool
+	err       error
+}
+
+// Next advances the iterator to the next matching Message, returning false once there are no more
+// (or an error was encountered, which can be checked with Err)
+func (iter *HistoryIterator) Next() bool {
+	for !iter.done {
+		if iter.filter.Limit > 0 && iter.count >= iter.filter.Limit {
+			iter.done = true
+			break
+		}
+
+		var id uint64
+		if iter.ids != nil {
+			if len(iter.ids) == 0 {
+				iter.done = true
+				break
+			}
+			id, iter.ids = iter.ids[0], iter.ids[1:]
+		} else {
+			// Item IDs start at 1, so reaching 0 means we've gone past the bottom of the stack
+			if iter.nextID == 0 {
+				iter.done = true
+				break
+			}
+			id = iter.nextID
+			iter.nextID--
+		}
+
+		item, err := iter.stack.PeekByID(id)
+		if err != nil {
+			if err != goque.ErrOutOfBounds && err != goque.ErrEmpty {
+				iter.err = err
+			}
+			iter.done = true
+			break
+		}
+
+		msg, err := iter.sealer.decodeMessage(item.Value)
+		if err != nil {
+			iter.err = err
+			iter.done = true
+			break
+		}
+
+		if iter.filter.Matches(msg) {
+			iter.current = msg
+			iter.currentID = id
+			iter.count++
+			return true
+		}
+	}
+
+	iter.current = nil
+	iter.currentID = 0
+	return false
+}
+
+// Message returns the Message the iterator is currently pointing at
+func (iter *HistoryIterator) Message() *Message {
+	return iter.current
+}
+
+// Err returns the first error the iterator ran into, if any
+func (iter *HistoryIterator) Err() error {
+	return iter.err
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(list []int, value int) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,296 @@
+package accord
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/beeker1121/goque"
+)
+
+// ErrExportFormat is returned for an ExportFormat we don't know about
+var ErrExportFormat = errors.New("unknown history export format")
+
+// ExportFormat is a format our history can be exported in (see ExportHistory)
+type ExportFormat int
+
+const (
+	// ExportJSON writes one JSON encoded Message per line, with its Payload encoded as base64
+	ExportJSON ExportFormat = iota
+
+	// ExportCSV writes a CSV file with a header row and one Message per row (see exportColumns). Headers,
+	// Clock and HLC are written as JSON, and Payload as base64, so that nothing's lost
+	ExportCSV
+)
+
+func (format ExportFormat) String() string {
+	switch format {
+	case ExportJSON:
+		return "json"
+	case ExportCSV:
+		return "csv"
+	}
+	return fmt.Sprintf("ExportFormat(%d)", int(format))
+}
+
+// ParseExportFormat parses the name of an ExportFormat, "json" or "csv"
+func ParseExportFormat(name string) (ExportFormat, error) {
+	switch name {
+	case "json":
+		return ExportJSON, nil
+	case "csv":
+		return ExportCSV, nil
+	}
+	return 0, ErrExportFormat
+}
+
+// exportColumns are the columns of a CSV export
+var exportColumns = []string{"id", "timestamp", "state_at", "type", "origin", "key", "key_seq", "idempotency_key", "correlation_id", "causation_id", "channel", "headers", "clock", "hlc", "payload"}
+
+// ExportHistory writes every Message in our history to w, from the oldest to the most recent, so that
+// analysts can look through what we did with the tools they already have, and engineers can reproduce a
+// problem by replaying it elsewhere (see ImportHistory). Messages performed while we're exporting may or
+// may not be included
+func (accord *Accord) ExportHistory(w io.Writer, format ExportFormat) error {
+	var write func(msg *Message) error
+	var flush func() error
+	switch format {
+	case ExportJSON:
+		encoder := json.NewEncoder(w)
+		write = func(msg *Message) error { return encoder.Encode(msg) }
+		flush = func() error { return nil }
+	case ExportCSV:
+		writer := csv.NewWriter(w)
+		err := writer.Write(exportColumns)
+		if err != nil {
+			return err
+		}
+		write = func(msg *Message) error {
+			row, err := exportRow(msg)
+			if err != nil {
+				return err
+			}
+			return writer.Write(row)
+		}
+		flush = func() error {
+			writer.Flush()
+			return writer.Error()
+		}
+	default:
+		return ErrExportFormat
+	}
+
+	err := accord.walkHistory(write)
+	if err != nil {
+		return err
+	}
+	return flush()
+}
+
+// walkHistory calls fn with every Message in our history, from the oldest to the most recent, up to the
+// one that was most recent when we started
+func (accord *Accord) walkHistory(fn func(msg *Message) error) error {
+	accord.historyMutex.RLock()
+	defer accord.historyMutex.RUnlock()
+
+	stack := accord.historyStack
+	top, err := stack.Peek()
+	if err == goque.ErrEmpty {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	bottom, err := stack.PeekByOffset(stack.Length() - 1)
+	if err != nil {
+		return err
+	}
+
+	for id := bottom.ID; id <= top.ID; id++ {
+		item, err := stack.PeekByID(id)
+		if err != nil {
+			return err
+		}
+		msg, err := accord.sealer.decodeMessage(item.Value)
+		if err != nil {
+			return err
+		}
+		err = fn(msg)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportRow turns a Message into a row of a CSV export
+func exportRow(msg *Message) ([]string, error) {
+	headers, err := json.Marshal(msg.Headers)
+	if err != nil {
+		return nil, err
+	}
+	clock, err := json.Marshal(msg.Clock)
+	if err != nil {
+		return nil, err
+	}
+	hlc, err := json.Marshal(msg.HLC)
+	if err != nil {
+		return nil, err
+	}
+	return []string{
+		strconv.FormatUint(msg.ID, 10),
+		msg.Timestamp.Format(time.RFC3339Nano),
+		strconv.FormatUint(msg.StateAt, 10),
+		msg.Type,
+		msg.Origin,
+		msg.Key,
+		strconv.FormatUint(msg.KeySeq, 10),
+		msg.IdempotencyKey,
+		msg.CorrelationID,
+		strconv.FormatUint(msg.CausationID, 10),
+		msg.Channel,
+		string(headers),
+		string(clock),
+		string(hlc),
+		base64.StdEncoding.EncodeToString(msg.Payload),
+	}, nil
+}
+
+// parseRow reads a Message back out of a row of a CSV export, given where each of the export's columns is
+func parseRow(row []string, columns map[string]int) (*Message, error) {
+	cell := func(name string) string {
+		if i, ok := columns[name]; ok && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+	number := func(name string) (uint64, error) {
+		if cell(name) == "" {
+			return 0, nil
+		}
+		return strconv.ParseUint(cell(name), 10, 64)
+	}
+	object := func(name string, value interface{}) error {
+		if cell(name) == "" {
+			return nil
+		}
+		return json.Unmarshal([]byte(cell(name)), value)
+	}
+
+	msg := &Message{Type: cell("type"), Origin: cell("origin"), Key: cell("key"), IdempotencyKey: cell("idempotency_key"), CorrelationID: cell("correlation_id"), Channel: cell("channel")}
+	var err error
+	if msg.ID, err = number("id"); err != nil {
+		return nil, err
+	}
+	if msg.StateAt, err = number("state_at"); err != nil {
+		return nil, err
+	}
+	if msg.KeySeq, err = number("key_seq"); err != nil {
+		return nil, err
+	}
+	if msg.CausationID, err = number("causation_id"); err != nil {
+		return nil, err
+	}
+	if cell("timestamp") != "" {
+		if msg.Timestamp, err = time.Parse(time.RFC3339Nano, cell("timestamp")); err != nil {
+			return nil, err
+		}
+	}
+	if err = object("headers", &msg.Headers); err != nil {
+		return nil, err
+	}
+	if err = object("clock", &msg.Clock); err != nil {
+		return nil, err
+	}
+	if err = object("hlc", &msg.HLC); err != nil {
+		return nil, err
+	}
+	if msg.Payload, err = base64.StdEncoding.DecodeString(cell("payload")); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// ReadHistoryExport reads the Messages written by ExportHistory back out, calling fn with each of them in
+// the order they were written. It stops at the first error, fn's included
+func ReadHistoryExport(r io.Reader, format ExportFormat, fn func(msg *Message) error) error {
+	switch format {
+	case ExportJSON:
+		decoder := json.NewDecoder(r)
+		for {
+			msg := &Message{}
+			err := decoder.Decode(msg)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			err = fn(msg)
+			if err != nil {
+				return err
+			}
+		}
+
+	case ExportCSV:
+		reader := csv.NewReader(r)
+		header, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		columns := map[string]int{}
+		for i, name := range header {
+			columns[name] = i
+		}
+		for {
+			row, err := reader.Read()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			msg, err := parseRow(row, columns)
+			if err != nil {
+				return err
+			}
+			err = fn(msg)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return ErrExportFormat
+}
+
+// ImportHistory replays an export written by ExportHistory, handing each of its Messages to
+// HandleRemoteMessage in turn, just as though the node that exported them had sent them to us. Starting
+// a node with a blank data directory and the Manager in question, and importing a customer's export
+// into it, reproduces whatever the Manager did with their Messages. The node shouldn't share its NodeID
+// with the one that was exported.
+//
+// It returns how many Messages were replayed. Messages the Manager (or a pipeline stage, Filter or our
+// ACL) refuses are replayed all the same, but anything else going wrong stops the import
+func (accord *Accord) ImportHistory(r io.Reader, format ExportFormat) (int, error) {
+	count := 0
+	err := ReadHistoryExport(r, format, func(msg *Message) error {
+		err := accord.HandleRemoteMessage(msg)
+		if _, rejected := err.(*StageError); err != nil && !rejected {
+			return fmt.Errorf("unable to replay message %d: %v", msg.ID, err)
+		}
+		count++
+		return nil
+	})
+	if err == nil {
+		accord.Logger.WithField("messages", count).Info("Replayed an exported history")
+	}
+	return count, err
+}
@@ -0,0 +1,93 @@
+package accord
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportHistory(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	accord.NodeID = "customer"
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	msgs := []*Message{}
+	for _, payload := range []string{`{"name":"Ada"}`, "with, commas\nand \"quotes\"", ""} {
+		msg, _ := NewMessage([]byte(payload))
+		msg.Type = "user.update"
+		msg.Key = "ada"
+		msg.Headers = map[string]string{"tenant": "acme"}
+		assert.Nil(t, accord.HandleNewMessage(msg))
+		msgs = append(msgs, msg)
+	}
+
+	for _, format := range []ExportFormat{ExportJSON, ExportCSV} {
+		var buf bytes.Buffer
+		assert.Nil(t, accord.ExportHistory(&buf, format))
+
+		// Messages come back out oldest first, with nothing lost along the way
+		read := []*Message{}
+		assert.Nil(t, ReadHistoryExport(bytes.NewReader(buf.Bytes()), format, func(msg *Message) error {
+			read = append(read, msg)
+			return nil
+		}))
+		if assert.Len(t, read, len(msgs), format.String()) {
+			for i, msg := range msgs {
+				assert.True(t, sameMessage(msg, read[i]), "%s: message %d", format, i)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	assert.Equal(t, ErrExportFormat, accord.ExportHistory(&buf, ExportFormat(7)))
+	format, err := ParseExportFormat("csv")
+	assert.Nil(t, err)
+	assert.Equal(t, ExportCSV, format)
+	_, err = ParseExportFormat("xml")
+	assert.Equal(t, ErrExportFormat, err)
+}
+
+func TestImportHistory(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	accord.NodeID = "customer"
+	assert.Nil(t, accord.Start())
+	for _, kind := range []string{"user.create", "user.update", "user.delete"} {
+		msg, _ := NewMessage([]byte(kind))
+		msg.Type = kind
+		assert.Nil(t, accord.HandleNewMessage(msg))
+	}
+	var export bytes.Buffer
+	assert.Nil(t, accord.ExportHistory(&export, ExportCSV))
+	accord.Stop()
+
+	// Replaying the export elsewhere hands the same Messages to the Manager, in the same order
+	dir, err := ioutil.TempDir("", "accord-replay")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	manager := &recordingManager{}
+	local := NewAccord(manager, nil, dir, accord.Logger)
+	local.NodeID = "engineer"
+	local.ACL = ACL{Default: []string{"user.create", "user.update"}}
+	assert.Nil(t, local.Start())
+	defer local.Stop()
+
+	count, err := local.ImportHistory(strings.NewReader(export.String()), ExportCSV)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, count)
+	types := []string{}
+	for _, msg := range manager.processed {
+		types = append(types, msg.Type)
+	}
+	assert.Equal(t, []string{"user.create", "user.update"}, types)
+	assert.Equal(t, uint64(2), local.History().Len())
+
+	_, err = local.ImportHistory(strings.NewReader("not json"), ExportJSON)
+	assert.NotNil(t, err)
+}
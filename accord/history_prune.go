@@ -0,0 +1,253 @@
+package accord
+
+import (
+	"bytes"
+	"encoding/gob"
+	"os"
+	"path"
+
+	"github.com/beeker1121/goque"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// prunedKey holds what we know about the Messages that have been pruned from our history
+const prunedKey = "pruned"
+
+// EventHistoryPruned is emitted whenever Messages are pruned from our history
+const EventHistoryPruned = "history_pruned"
+
+// While our history is being pruned the pruned copy is written next to it, and the original is moved out
+// of the way before the copy takes its place (see recoverPrune)
+const (
+	pruningSuffix = ".pruning"
+	prunedSuffix  = ".old"
+)
+
+// prunedHistory sums up the Messages that have been pruned from our history, so that what's left of it can
+// still be checked against our state (see verifyState)
+type prunedHistory struct {
+	// Count is how many Messages were pruned, and Digest and Clock what they added up to
+	Count  uint64
+	Digest Digest
+	Clock  VectorClock
+
+	// Through is the item ID of the most recent Message that was pruned. We write it down before our
+	// history is actually replaced, so anything at or below it that's still in our history after a crash
+	// has already been counted here
+	Through uint64
+}
+
+// loadPruned reads what's been pruned from our history, which is nothing if it's never been pruned
+func (state *State) loadPruned() (prunedHistory, error) {
+	pruned := prunedHistory{Clock: VectorClock{}}
+	data, err := state.db.Get([]byte(prunedKey), nil)
+	if err == leveldb.ErrNotFound {
+		return pruned, nil
+	}
+	if err != nil {
+		return pruned, err
+	}
+	err = gob.NewDecoder(bytes.NewReader(data)).Decode(&pruned)
+	if pruned.Clock == nil {
+		pruned.Clock = VectorClock{}
+	}
+	return pruned, err
+}
+
+// savePruned writes down what's been pruned from our history, straight to disk
+func (state *State) savePruned(pruned prunedHistory) error {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(pruned)
+	if err != nil {
+		return err
+	}
+	return state.db.Put([]byte(prunedKey), buf.Bytes(), &opt.WriteOptions{Sync: true})
+}
+
+// historyBaseline returns what's been pruned from our history, along with how many of the oldest items
+// still in it were already counted as pruned (which is only ever the case after a crash while pruning)
+func (accord *Accord) historyBaseline() (prunedHistory, uint64, error) {
+	pruned, err := accord.state.loadPruned()
+	if err != nil || pruned.Through == 0 {
+		return pruned, 0, err
+	}
+
+	length := accord.historyStack.Length()
+	if length == 0 {
+		return pruned, 0, nil
+	}
+	bottom, err := accord.historyStack.PeekByOffset(length - 1)
+	if err != nil {
+		return pruned, 0, err
+	}
+	if bottom.ID > pruned.Through {
+		return pruned, 0, nil
+	}
+	stale := pruned.Through - bottom.ID + 1
+	if stale > length {
+		stale = length
+	}
+	return pruned, stale, nil
+}
+
+// PruneHistory removes up to count of the oldest Messages from our history, to reclaim the space they take
+// up (see DiskQuota), returning how many were removed. The most recent Message is always kept. Our state,
+// and so our digest and clock, still counts the ones removed, and the Messages left keep their place, but
+// pruned Messages are gone for good: they can't be queried, exported, sent to a peer that's missing them
+// or used to reconstruct our state at an earlier point.
+//
+// The rest of our history is copied into a new store, which replaces the old one, so new Messages are held
+// up while that happens and a Component reading our history at the time may have to try again
+func (accord *Accord) PruneHistory(count uint64) (uint64, error) {
+	// Backups copy our history without holding up new Messages, so they're waited on first
+	accord.historyMutex.Lock()
+	defer accord.historyMutex.Unlock()
+	accord.processMutex.Lock()
+	defer accord.processMutex.Unlock()
+
+	pruned, err := accord.pruneHistory(count)
+	if err != nil {
+		accord.Logger.WithError(err).Error("Unable to prune our history")
+		return 0, err
+	}
+	if pruned > 0 {
+		accord.Logger.WithField("pruned", pruned).Info("Pruned the oldest messages from our history")
+		accord.Emit(EventHistoryPruned, "Pruned the oldest messages from our history", map[string]interface{}{"pruned": pruned})
+	}
+	return pruned, nil
+}
+
+// pruneHistory does the work of PruneHistory. The caller must hold historyMutex and processMutex
+func (accord *Accord) pruneHistory(count uint64) (uint64, error) {
+	length := accord.historyStack.Length()
+	pruned, stale, err := accord.historyBaseline()
+	if err != nil {
+		return 0, err
+	}
+	// The most recent Message is always kept, as new ones carry on numbering from it
+	prunable := length - stale
+	if prunable > 0 {
+		prunable--
+	}
+	if count > prunable {
+		count = prunable
+	}
+	if count == 0 && stale == 0 {
+		return 0, nil
+	}
+
+	// Fold the Messages we're about to drop into what's been pruned before, oldest first
+	drop := stale + count
+	for i := stale; i < drop; i++ {
+		item, err := accord.historyStack.PeekByOffset(length - 1 - i)
+		if err != nil {
+			return 0, err
+		}
+		msg, err := accord.sealer.decodeMessage(item.Value)
+		if err != nil {
+			return 0, err
+		}
+		pruned.Count++
+		pruned.Digest.add(msg.ID)
+		pruned.Clock.Merge(msg.Clock)
+		pruned.Through = item.ID
+	}
+	err = accord.state.savePruned(pruned)
+	if err != nil {
+		return 0, accord.failWrite(err, "We could not record what we pruned from our history")
+	}
+
+	// Items keep their IDs in the copy, which is what our indexes point at
+	historyPath := path.Join(accord.dataDir, HistoryFilename)
+	dest := historyPath + pruningSuffix
+	err = os.RemoveAll(dest)
+	if err != nil {
+		return 0, err
+	}
+	err = accord.copyHistory(dest, length-drop)
+	if err != nil {
+		// Our history is untouched, and what we dropped is skipped over until we try again
+		os.RemoveAll(dest)
+		return 0, err
+	}
+
+	err = accord.swapHistory(historyPath, dest)
+	if err != nil {
+		return 0, accord.failWrite(err, "We could not replace our history with its pruned copy")
+	}
+	return count, nil
+}
+
+// copyHistory copies the newest keep items of our history into a new store at dest
+func (accord *Accord) copyHistory(dest string, keep uint64) error {
+	db, err := openBackupStore(dest)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	batch := new(leveldb.Batch)
+	for offset := keep; offset > 0; offset-- {
+		item, err := accord.historyStack.PeekByOffset(offset - 1)
+		if err != nil {
+			return err
+		}
+		err = writeBackupItem(db, batch, item.Key, item.Value)
+		if err != nil {
+			return err
+		}
+	}
+	return db.Write(batch, nil)
+}
+
+// swapHistory replaces our history with the pruned copy at dest
+func (accord *Accord) swapHistory(historyPath string, dest string) error {
+	openStacks.Delete(accord.historyStack)
+	accord.historyStack.Close()
+
+	old := historyPath + prunedSuffix
+	err := os.RemoveAll(old)
+	if err == nil {
+		err = os.Rename(historyPath, old)
+	}
+	if err == nil {
+		err = os.Rename(dest, historyPath)
+	}
+	if err != nil {
+		return err
+	}
+
+	accord.historyStack, err = goque.OpenStack(historyPath)
+	if err != nil {
+		return err
+	}
+	openStacks.Store(accord.historyStack, accord)
+	return os.RemoveAll(old)
+}
+
+// recoverPrune cleans up after a crash while our history was being pruned, before it's opened. A pruned
+// copy is only ever moved into place once it's complete, so if our history is missing whichever of the
+// copy or the original is still around takes its place
+func recoverPrune(historyPath string) error {
+	dest := historyPath + pruningSuffix
+	old := historyPath + prunedSuffix
+
+	if _, err := os.Stat(historyPath); os.IsNotExist(err) {
+		for _, candidate := range []string{dest, old} {
+			if _, err := os.Stat(candidate); err == nil {
+				err = os.Rename(candidate, historyPath)
+				if err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
+
+	err := os.RemoveAll(dest)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(old)
+}
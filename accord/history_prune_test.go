@@ -0,0 +1,146 @@
+package accord
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// crashUncleanly makes it look as if we went down without being stopped, so that our state is checked
+// against our history the next time we start
+func crashUncleanly(t *testing.T) {
+	db, err := leveldb.OpenFile(StateFilename, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte(openKey), nil, nil))
+	assert.Nil(t, db.Close())
+}
+
+func TestPruneHistory(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	accord.AddIndex("type", func(msg *Message) []string { return []string{msg.Type} })
+	assert.Nil(t, accord.Start())
+	for i := uint64(1); i <= 5; i++ {
+		assert.Nil(t, accord.HandleNewMessage(&Message{ID: i, Type: "user.update"}))
+	}
+	current, digest, clock := accord.CurrentState(), accord.Digest(), accord.Clock()
+
+	pruned, err := accord.PruneHistory(3)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), pruned)
+	assert.Equal(t, []uint64{5, 4}, collectHistory(t, accord.History().Query(HistoryFilter{})))
+	assert.Equal(t, []uint64{5, 4}, collectHistory(t, accord.History().Lookup("type", "user.update")))
+
+	// Our state still counts everything we pruned
+	assert.Equal(t, current, accord.CurrentState())
+	assert.Equal(t, digest, accord.Digest())
+	assert.Equal(t, clock, accord.Clock())
+
+	// The most recent Message is always kept, and new ones carry on from it
+	pruned, err = accord.PruneHistory(100)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), pruned)
+	assert.Nil(t, accord.HandleNewMessage(&Message{ID: 6, Type: "user.update"}))
+	assert.Equal(t, []uint64{6, 5}, collectHistory(t, accord.History().Query(HistoryFilter{})))
+	digest = accord.Digest()
+	accord.Stop()
+
+	// A pruned history still matches our state
+	crashUncleanly(t)
+	accord = DummyAccord()
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+	assert.Equal(t, digest, accord.Digest())
+	assert.Equal(t, uint64(6), accord.state.applied)
+}
+
+func TestPruneHistoryCrash(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	assert.Nil(t, accord.Start())
+	for i := uint64(1); i <= 4; i++ {
+		assert.Nil(t, accord.HandleNewMessage(&Message{ID: i}))
+	}
+
+	// Going down after writing down what we pruned, but before our history was replaced, leaves the
+	// pruned Messages in our history
+	pruned := prunedHistory{Count: 2, Clock: VectorClock{}, Through: 2}
+	for offset := uint64(4); offset > 2; offset-- {
+		msg, err := accord.History().Get(offset - 1)
+		assert.Nil(t, err)
+		pruned.Digest.add(msg.ID)
+		pruned.Clock.Merge(msg.Clock)
+	}
+	assert.Nil(t, accord.state.savePruned(pruned))
+	digest := accord.Digest()
+	accord.Stop()
+	crashUncleanly(t)
+
+	accord = DummyAccord()
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+	assert.Equal(t, digest, accord.Digest())
+	assert.Equal(t, uint64(4), accord.History().Len())
+
+	// They're dropped the next time we prune
+	count, err := accord.PruneHistory(0)
+	assert.Nil(t, err)
+	assert.Zero(t, count)
+	assert.Equal(t, []uint64{4, 3}, collectHistory(t, accord.History().Query(HistoryFilter{})))
+}
+
+func TestRecoverPrune(t *testing.T) {
+	dir, err := ioutil.TempDir("", "accord-prune")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	historyPath := dir + "/" + HistoryFilename
+
+	// A complete copy that never made it into place takes our history's place
+	assert.Nil(t, os.MkdirAll(historyPath+pruningSuffix, 0755))
+	assert.Nil(t, ioutil.WriteFile(historyPath+pruningSuffix+"/CURRENT", []byte("copy"), 0644))
+	assert.Nil(t, os.MkdirAll(historyPath+prunedSuffix, 0755))
+	assert.Nil(t, recoverPrune(historyPath))
+	data, err := ioutil.ReadFile(historyPath + "/CURRENT")
+	assert.Nil(t, err)
+	assert.Equal(t, "copy", string(data))
+	_, err = os.Stat(historyPath + prunedSuffix)
+	assert.True(t, os.IsNotExist(err))
+
+	// A copy left behind while our history is still in place was never finished
+	assert.Nil(t, os.MkdirAll(historyPath+pruningSuffix, 0755))
+	assert.Nil(t, recoverPrune(historyPath))
+	_, err = os.Stat(historyPath + pruningSuffix)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(historyPath)
+	assert.Nil(t, err)
+}
+
+func TestSnapshotPrunedHistory(t *testing.T) {
+	defer AccordCleanup()
+	source := historyTestAccord(t)
+	defer source.Stop()
+	_, err := source.PruneHistory(2)
+	assert.Nil(t, err)
+
+	var buf bytes.Buffer
+	assert.Nil(t, source.ExportSnapshot(&buf))
+
+	dir, err := ioutil.TempDir("", "accord-snapshot")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	target := NewAccord(NewDummerManager(), nil, dir, DummyAccord().Logger)
+	target.NodeID = "target"
+	assert.Nil(t, target.Start())
+	assert.Nil(t, target.ImportSnapshot(&buf))
+	assert.Equal(t, uint64(2), target.History().Len())
+	assert.Equal(t, source.Digest(), target.Digest())
+	assert.Equal(t, uint64(4), target.state.applied)
+
+	// The imported history only has what was left of the source's, which still matches its state
+	assert.Nil(t, target.verifyState())
+	target.Stop()
+}
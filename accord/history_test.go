@@ -0,0 +1,84 @@
+package accord
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func historyTestAccord(t *testing.T) *Accord {
+	accord := DummyAccord()
+	accord.NodeID = "local"
+	err := accord.Start()
+	assert.Nil(t, err)
+
+	base := time.Date(2017, time.April, 1, 0, 0, 0, 0, time.UTC)
+	messages := []*Message{
+		{ID: 1, Timestamp: base, Type: "user.create"},
+		{ID: 2, Timestamp: base.Add(time.Hour), Type: "user.update"},
+		{ID: 3, Timestamp: base.Add(2 * time.Hour), Type: "user.update", Origin: "remote"},
+		{ID: 4, Timestamp: base.Add(3 * time.Hour), Type: "user.delete"},
+	}
+	for _, msg := range messages {
+		assert.Nil(t, accord.HandleNewMessage(msg))
+	}
+
+	return accord
+}
+
+func collectHistory(t *testing.T, iter *HistoryIterator) []uint64 {
+	ids := []uint64{}
+	for iter.Next() {
+		ids = append(ids, iter.Message().ID)
+	}
+	assert.Nil(t, iter.Err())
+	return ids
+}
+
+func TestHistoryRecordsMessages(t *testing.T) {
+	defer AccordCleanup()
+	accord := historyTestAccord(t)
+	defer accord.Stop()
+
+	history := accord.History()
+	assert.Equal(t, uint64(4), history.Len())
+	assert.Equal(t, uint64(4), accord.syncQueue.Length())
+
+	latest, err := history.Get(0)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(4), latest.ID)
+	assert.Equal(t, "local", latest.Origin)
+
+	// Reading through the history shouldn't have consumed anything
+	assert.Equal(t, uint64(4), history.Len())
+}
+
+func TestHistoryQuery(t *testing.T) {
+	defer AccordCleanup()
+	accord := historyTestAccord(t)
+	defer accord.Stop()
+
+	base := time.Date(2017, time.April, 1, 0, 0, 0, 0, time.UTC)
+	history := accord.History()
+
+	assert.Equal(t, []uint64{4, 3, 2, 1}, collectHistory(t, history.Query(HistoryFilter{})))
+	assert.Equal(t, []uint64{3, 2}, collectHistory(t, history.Query(HistoryFilter{Types: []string{"user.update"}})))
+	assert.Equal(t, []uint64{3}, collectHistory(t, history.Query(HistoryFilter{Origins: []string{"remote"}})))
+	assert.Equal(t, []uint64{3, 2}, collectHistory(t, history.Query(HistoryFilter{
+		Since: base.Add(time.Hour),
+		Until: base.Add(2 * time.Hour),
+	})))
+	assert.Equal(t, []uint64{4}, collectHistory(t, history.Query(HistoryFilter{Limit: 1})))
+}
+
+func TestHistoryQueryEmpty(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	accord.Start()
+	defer accord.Stop()
+
+	iter := accord.History().Query(HistoryFilter{})
+	assert.False(t, iter.Next())
+	assert.Nil(t, iter.Err())
+}
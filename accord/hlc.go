@@ -0,0 +1,177 @@
+package accord
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultMaxClockDrift is how far ahead of our own wall clock a peer's HLC timestamp can be by default
+// before we stop letting it move our clock forward (see Accord.MaxClockDrift)
+const DefaultMaxClockDrift = time.Minute
+
+// EventClockDrift is emitted when a peer's Message carries an HLC timestamp further ahead of our wall
+// clock than MaxClockDrift, which we left out of our clock
+const EventClockDrift = "clock_drift"
+
+// HLCTimestamp is a hybrid logical clock timestamp. It is made up of a physical component, which stays
+// close to the wall clock, and a logical component, which breaks ties between events that happen within
+// the same physical instant. Unlike wall clock timestamps, HLC timestamps never go backwards and always
+// order an event after every event its node had already seen, even when machines have skewed clocks
+type HLCTimestamp struct {
+	// Wall is the physical component, in nanoseconds since the Unix epoch
+	Wall int64
+
+	// Logical counts events that happened at the same Wall time
+	Logical uint32
+}
+
+// IsZero returns whether the timestamp was never set
+func (ts HLCTimestamp) IsZero() bool {
+	return ts.Wall == 0 && ts.Logical == 0
+}
+
+// Compare returns -1 if this timestamp is before the other, 1 if it's after and 0 if they're equal
+func (ts HLCTimestamp) Compare(other HLCTimestamp) int {
+	switch {
+	case ts.Wall < other.Wall:
+		return -1
+	case ts.Wall > other.Wall:
+		return 1
+	case ts.Logical < other.Logical:
+		return -1
+	case ts.Logical > other.Logical:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Before returns whether this timestamp comes before the other
+func (ts HLCTimestamp) Before(other HLCTimestamp) bool {
+	return ts.Compare(other) < 0
+}
+
+// After returns whether this timestamp comes after the other
+func (ts HLCTimestamp) After(other HLCTimestamp) bool {
+	return ts.Compare(other) > 0
+}
+
+// Time returns the physical component of the timestamp as a time.Time
+func (ts HLCTimestamp) Time() time.Time {
+	return time.Unix(0, ts.Wall).UTC()
+}
+
+func (ts HLCTimestamp) String() string {
+	return fmt.Sprintf("%s+%d", ts.Time().Format(time.RFC3339Nano), ts.Logical)
+}
+
+// HybridClock generates HLCTimestamps. It is safe to use from multiple goroutines
+type HybridClock struct {
+	mutex sync.Mutex
+	last  HLCTimestamp
+
+	// wallClock is where we get our physical time from, it's only swapped out for testing
+	wallClock func() time.Time
+}
+
+// NewHybridClock creates a clock that uses the system time as its physical component
+func NewHybridClock() *HybridClock {
+	return &HybridClock{wallClock: time.Now}
+}
+
+// Now returns a timestamp for a new local event, guaranteed to be after every timestamp this clock has
+// previously generated or seen
+func (clock *HybridClock) Now() HLCTimestamp {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	return clock.nowLocked()
+}
+
+func (clock *HybridClock) nowLocked() HLCTimestamp {
+	wall := clock.wallClock().UnixNano()
+	if wall > clock.last.Wall {
+		clock.last = HLCTimestamp{Wall: wall}
+	} else {
+		clock.last.Logical++
+	}
+
+	return clock.last
+}
+
+// Update folds a timestamp we received from somewhere else into the clock, so that every timestamp we
+// generate from now on comes after it. It returns the timestamp for the receive event itself
+func (clock *HybridClock) Update(remote HLCTimestamp) HLCTimestamp {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	return clock.updateLocked(remote)
+}
+
+// UpdateWithin is Update for a timestamp we can't vouch for. One more than maxDrift ahead of our wall
+// clock is left out, so that a single peer with a badly wrong clock can't drag ours (and, through the
+// timestamps we hand out, everyone else's) into the future for good; only the receive event is counted,
+// as though it were a local one, and false is returned. A maxDrift of 0 or less lets any timestamp in
+func (clock *HybridClock) UpdateWithin(remote HLCTimestamp, maxDrift time.Duration) (HLCTimestamp, bool) {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	if maxDrift > 0 && remote.Wall-clock.wallClock().UnixNano() > int64(maxDrift) {
+		return clock.nowLocked(), false
+	}
+	return clock.updateLocked(remote), true
+}
+
+func (clock *HybridClock) updateLocked(remote HLCTimestamp) HLCTimestamp {
+	wall := clock.wallClock().UnixNano()
+
+	switch {
+	case wall > clock.last.Wall && wall > remote.Wall:
+		clock.last = HLCTimestamp{Wall: wall}
+	case remote.Wall > clock.last.Wall:
+		clock.last = HLCTimestamp{Wall: remote.Wall, Logical: remote.Logical + 1}
+	case clock.last.Wall > remote.Wall:
+		clock.last.Logical++
+	default:
+		// Our last timestamp and the remote one share the same physical component
+		if remote.Logical > clock.last.Logical {
+			clock.last.Logical = remote.Logical
+		}
+		clock.last.Logical++
+	}
+
+	return clock.last
+}
+
+// clockSeedScan is how many of the most recent Messages in our history seedClock looks through for one
+// whose timestamp it can trust
+const clockSeedScan = 1000
+
+// seedClock moves our hybrid logical clock past the newest Message in our history whose timestamp is within
+// MaxClockDrift of our wall clock, so that we never hand out timestamps earlier than ones we've handed out
+// before, even if the wall clock was moved backwards while we were stopped. Newer ones that are too far
+// ahead came from a peer we already refused to be dragged forward by
+func (accord *Accord) seedClock() {
+	history := accord.History()
+	for offset := uint64(0); offset < clockSeedScan; offset++ {
+		msg, err := history.Get(offset)
+		if err != nil {
+			return
+		}
+		if _, ok := accord.hlc.UpdateWithin(msg.HLC, accord.maxClockDrift()); ok {
+			return
+		}
+	}
+}
+
+// maxClockDrift returns how far ahead of our wall clock a peer's HLC timestamp can be before we leave it
+// out of our clock, or 0 for no limit
+func (accord *Accord) maxClockDrift() time.Duration {
+	switch {
+	case accord.MaxClockDrift < 0:
+		return 0
+	case accord.MaxClockDrift == 0:
+		return DefaultMaxClockDrift
+	default:
+		return accord.MaxClockDrift
+	}
+}
@@ -0,0 +1,126 @@
+package accord
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func fixedClock(wall *time.Time) *HybridClock {
+	return &HybridClock{wallClock: func() time.Time { return *wall }}
+}
+
+func TestHybridClockNow(t *testing.T) {
+	wall := time.Unix(100, 0)
+	clock := fixedClock(&wall)
+
+	first := clock.Now()
+	assert.Equal(t, HLCTimestamp{Wall: wall.UnixNano()}, first)
+
+	// The wall clock didn't move, so the logical counter should
+	second := clock.Now()
+	assert.Equal(t, HLCTimestamp{Wall: wall.UnixNano(), Logical: 1}, second)
+	assert.True(t, first.Before(second))
+
+	// Even if the wall clock goes backwards we should keep moving forwards
+	wall = time.Unix(50, 0)
+	third := clock.Now()
+	assert.True(t, second.Before(third))
+
+	wall = time.Unix(200, 0)
+	assert.Equal(t, HLCTimestamp{Wall: wall.UnixNano()}, clock.Now())
+}
+
+func TestHybridClockUpdate(t *testing.T) {
+	wall := time.Unix(100, 0)
+	clock := fixedClock(&wall)
+	clock.Now()
+
+	// A remote that's ahead of us should pull our clock forward
+	remote := HLCTimestamp{Wall: time.Unix(150, 0).UnixNano(), Logical: 3}
+	received := clock.Update(remote)
+	assert.True(t, remote.Before(received))
+	assert.True(t, received.Before(clock.Now()))
+
+	// A remote that's behind us shouldn't change anything but our logical counter
+	before := clock.Now()
+	after := clock.Update(HLCTimestamp{Wall: time.Unix(10, 0).UnixNano()})
+	assert.Equal(t, before.Wall, after.Wall)
+	assert.Equal(t, before.Logical+1, after.Logical)
+}
+
+func TestHybridClockUpdateWithin(t *testing.T) {
+	wall := time.Unix(100, 0)
+	clock := fixedClock(&wall)
+	clock.Now()
+
+	// A remote within the drift we allow moves our clock as Update would
+	near := HLCTimestamp{Wall: time.Unix(130, 0).UnixNano()}
+	received, ok := clock.UpdateWithin(near, time.Minute)
+	assert.True(t, ok)
+	assert.True(t, near.Before(received))
+
+	// One further ahead than that is left out, only counting as an event of our own
+	far := HLCTimestamp{Wall: time.Unix(1000, 0).UnixNano()}
+	received, ok = clock.UpdateWithin(far, time.Minute)
+	assert.False(t, ok)
+	assert.Equal(t, HLCTimestamp{Wall: near.Wall, Logical: 2}, received)
+	assert.True(t, clock.Now().Before(far))
+
+	// Unless there's no limit
+	received, ok = clock.UpdateWithin(far, 0)
+	assert.True(t, ok)
+	assert.True(t, far.Before(received))
+}
+
+func TestHLCTimestampCompare(t *testing.T) {
+	a := HLCTimestamp{Wall: 1, Logical: 5}
+	b := HLCTimestamp{Wall: 2}
+	assert.Equal(t, -1, a.Compare(b))
+	assert.Equal(t, 1, b.Compare(a))
+	assert.Equal(t, 0, a.Compare(a))
+	assert.True(t, b.After(a))
+	assert.True(t, HLCTimestamp{}.IsZero())
+}
+
+func TestAccordHLC(t *testing.T) {
+	defer AccordCleanup()
+
+	accord := DummyAccord()
+	drifted := []Event{}
+	accord.Subscribe(func(event Event) {
+		if event.Kind == EventClockDrift {
+			drifted = append(drifted, event)
+		}
+	})
+	accord.Start()
+
+	msg := &Message{ID: 1}
+	assert.Nil(t, accord.HandleNewMessage(msg))
+	assert.False(t, msg.HLC.IsZero())
+
+	// A remote from the future should push our clock past it
+	future := HLCTimestamp{Wall: time.Now().Add(30 * time.Second).UnixNano()}
+	assert.Nil(t, accord.HandleRemoteMessage(&Message{ID: 2, HLC: future}))
+	assert.True(t, future.Before(accord.Now()))
+
+	// But not one from further in the future than MaxClockDrift, although its Message is still handled
+	farFuture := HLCTimestamp{Wall: time.Now().Add(time.Hour).UnixNano()}
+	assert.Nil(t, accord.HandleRemoteMessage(&Message{ID: 3, Origin: "remote", HLC: farFuture}))
+	assert.True(t, accord.Now().Before(farFuture))
+	assert.Equal(t, uint64(3), accord.History().Len())
+	if assert.Len(t, drifted, 1) {
+		assert.Equal(t, "remote", drifted[0].Fields["origin"])
+	}
+	accord.Stop()
+
+	// And that should still be the case after a restart, even though the newest Message we have is the one
+	// from too far in the future
+	accord = DummyAccord()
+	accord.Start()
+	defer accord.Stop()
+	now := accord.Now()
+	assert.True(t, future.Before(now))
+	assert.True(t, now.Before(farFuture))
+}
@@ -0,0 +1,40 @@
+package accord
+
+// OnShutdown registers a function to be called as we stop, once our Components have stopped and any Message
+// being processed has finished but before our stores are closed. It's the place for an application to
+// flush and close its own resources, like the database its Manager writes to, knowing that nothing will
+// be handed to the Manager anymore. reason is the error we were shut down with (see Shutdown), or nil if
+// we were simply stopped.
+//
+// Hooks are called one at a time, in the reverse of the order they were registered in, so that something
+// registered later can still rely on whatever was registered before it
+func (accord *Accord) OnShutdown(hook func(reason error)) {
+	accord.hooksMutex.Lock()
+	defer accord.hooksMutex.Unlock()
+	accord.shutdownHooks = append(accord.shutdownHooks, hook)
+}
+
+// runShutdownHooks calls our OnShutdown hooks, recovering from any of them panicking so that the rest
+// are still called and our stores still get closed
+func (accord *Accord) runShutdownHooks(reason error) {
+	accord.hooksMutex.Lock()
+	hooks := append([]func(error){}, accord.shutdownHooks...)
+	accord.hooksMutex.Unlock()
+
+	if len(hooks) > 0 {
+		accord.Logger.WithField("hooks", len(hooks)).Info("Running shutdown hooks")
+	}
+	for i := len(hooks) - 1; i >= 0; i-- {
+		err := runShutdownHook(hooks[i], reason)
+		if panicErr, ok := err.(*PanicError); ok {
+			accord.logPanic(panicErr)
+		}
+	}
+}
+
+// runShutdownHook calls a single OnShutdown hook, turning a panic into a PanicError
+func runShutdownHook(hook func(reason error), reason error) (err error) {
+	defer recoverPanic("OnShutdown hook", &err)
+	hook(reason)
+	return nil
+}
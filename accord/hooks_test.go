@@ -0,0 +1,43 @@
+package accord
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOnShutdown(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	assert.Nil(t, accord.Start())
+
+	called := []string{}
+	accord.OnShutdown(func(reason error) {
+		assert.Nil(t, reason)
+		// Our stores are still open
+		_, err := accord.DeadLetters()
+		assert.Nil(t, err)
+		called = append(called, "first")
+	})
+	accord.OnShutdown(func(reason error) {
+		called = append(called, "second")
+		panic("the hook exploded")
+	})
+	accord.Stop()
+	assert.Equal(t, []string{"second", "first"}, called)
+}
+
+func TestOnShutdownReason(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	assert.Nil(t, accord.Start())
+
+	var reason error
+	accord.OnShutdown(func(err error) { reason = err })
+
+	failure := errors.New("something broke")
+	go accord.Shutdown(failure)
+	assert.Equal(t, failure, accord.Listen())
+	assert.Equal(t, failure, reason)
+}
@@ -0,0 +1,206 @@
+package accord
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const (
+	indexPrefix      = "index/"
+	indexBuiltPrefix = "index-built/"
+)
+
+// The indexes we always keep of our history, which are how a Resolver tells whether it's already performed
+// a Message and finds the most recent one for an entity without looking through our whole history.
+// IDIndex indexes each Message by its ID (in decimal) and KeyIndex by its Key
+const (
+	IDIndex  = "accord.id"
+	KeyIndex = "accord.key"
+)
+
+// IndexFunc extracts the values a Message should be indexed under. Returning no values simply leaves the
+// Message out of the index
+type IndexFunc func(msg *Message) []string
+
+// HeaderIndex indexes Messages by the value of one of their headers
+func HeaderIndex(header string) IndexFunc {
+	return func(msg *Message) []string {
+		if value, ok := msg.Headers[header]; ok {
+			return []string{value}
+		}
+		return nil
+	}
+}
+
+// JSONFieldIndex indexes Messages by a top level field of their payload, for applications whose payloads
+// are JSON objects. Messages whose payload isn't a JSON object, or is missing the field, aren't indexed
+func JSONFieldIndex(field string) IndexFunc {
+	return func(msg *Message) []string {
+		var payload map[string]interface{}
+		if json.Unmarshal(msg.Payload, &payload) != nil {
+			return nil
+		}
+
+		value, ok := payload[field]
+		if !ok || value == nil {
+			return nil
+		}
+
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			return nil
+		default:
+			return []string{fmt.Sprint(value)}
+		}
+	}
+}
+
+// AddIndex declares a secondary index over our history. Indexes are kept in the state database alongside
+// our history, so looking up every Message with a given value (all messages for customer 42, say) doesn't
+// require a full scan. Indexes should be added before Start; an index that hasn't been seen before gets
+// built from the existing history when Accord starts
+func (accord *Accord) AddIndex(name string, fn IndexFunc) {
+	if accord.indexes == nil {
+		accord.indexes = make(map[string]IndexFunc)
+	}
+	accord.indexes[name] = fn
+}
+
+// addBuiltinIndexes declares IDIndex and KeyIndex
+func (accord *Accord) addBuiltinIndexes() {
+	accord.AddIndex(IDIndex, func(msg *Message) []string {
+		return []string{strconv.FormatUint(msg.ID, 10)}
+	})
+	accord.AddIndex(KeyIndex, func(msg *Message) []string {
+		if msg.Key == "" {
+			return nil
+		}
+		return []string{msg.Key}
+	})
+}
+
+// buildIndexes builds any declared index that hasn't been built yet
+func (accord *Accord) buildIndexes() error {
+	for name, fn := range accord.indexes {
+		built, err := accord.state.indexBuilt(name)
+		if err != nil {
+			return err
+		}
+		if built {
+			continue
+		}
+
+		accord.Logger.WithField("index", name).Info("Building history index")
+
+		iter := accord.History().Query(HistoryFilter{})
+		for iter.Next() {
+			batch := new(leveldb.Batch)
+			addIndexEntries(batch, name, fn(iter.Message()), iter.currentID)
+			err = accord.state.write(batch)
+			if err != nil {
+				return err
+			}
+		}
+		if iter.Err() != nil {
+			return iter.Err()
+		}
+
+		batch := new(leveldb.Batch)
+		batch.Put([]byte(indexBuiltPrefix+name), nil)
+		err = accord.state.write(batch)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Whatever we built is written out now rather than being left to fill our buffer
+	return accord.state.Flush()
+}
+
+// indexMessage adds entries to a batch adding a history item to every declared index. The batch is the
+// one our state is updated with the Message it holds, so that the two are never written without each other
+func (accord *Accord) indexMessage(batch *leveldb.Batch, msg *Message, itemID uint64) {
+	for name, fn := range accord.indexes {
+		addIndexEntries(batch, name, fn(msg), itemID)
+	}
+}
+
+// Lookup returns an iterator over every Message in the history that was indexed under the given value,
+// from the most recent to the oldest. This is only available on the History returned by Accord.History
+func (history *History) Lookup(index string, value string) *HistoryIterator {
+	iter := &HistoryIterator{stack: history.stack, sealer: history.sealer, done: true}
+	if history.state == nil {
+		iter.err = fmt.Errorf("history index %q is not available", index)
+		return iter
+	}
+
+	ids, err := history.state.indexLookup(index, value)
+	if err != nil {
+		iter.err = err
+		return iter
+	}
+
+	// Our index is sorted oldest first, but the rest of History works newest first
+	for i, j := 0, len(ids)-1; i < j; i, j = i+1, j-1 {
+		ids[i], ids[j] = ids[j], ids[i]
+	}
+
+	iter.ids = ids
+	iter.done = false
+	return iter
+}
+
+// indexEntryPrefix returns the prefix every entry for the given index and value share
+func indexEntryPrefix(index string, value string) []byte {
+	return []byte(indexPrefix + index + "\x00" + value + "\x00")
+}
+
+// addIndexEntries adds entries to a batch recording that the history item with the given ID has each of the
+// passed in values
+func addIndexEntries(batch *leveldb.Batch, index string, values []string, itemID uint64) {
+	for _, value := range values {
+		key := indexEntryPrefix(index, value)
+		id := make([]byte, 8)
+		binary.BigEndian.PutUint64(id, itemID)
+		batch.Put(append(key, id...), nil)
+	}
+}
+
+// indexLookup returns the IDs of every history item indexed under the given value, oldest first, including
+// those whose entries are still buffered (see FlushInterval)
+func (state *State) indexLookup(index string, value string) ([]uint64, error) {
+	prefix := indexEntryPrefix(index, value)
+	buffered := state.buffered(prefix)
+	iter := state.db.NewIterator(util.BytesPrefix(prefix), nil)
+	defer iter.Release()
+
+	ids := []uint64{}
+	for iter.Next() {
+		if _, ok := buffered[string(iter.Key())]; !ok {
+			ids = append(ids, binary.BigEndian.Uint64(iter.Key()[len(prefix):]))
+		}
+	}
+	for key, value := range buffered {
+		if value != nil {
+			ids = append(ids, binary.BigEndian.Uint64([]byte(key[len(prefix):])))
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	return ids, iter.Error()
+}
+
+// indexBuilt returns whether the given index has already been built from our history
+func (state *State) indexBuilt(index string) (bool, error) {
+	_, err := state.get([]byte(indexBuiltPrefix + index))
+	if err == leveldb.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
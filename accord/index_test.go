@@ -0,0 +1,92 @@
+package accord
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIndexFuncs(t *testing.T) {
+	header := HeaderIndex("customer")
+	assert.Equal(t, []string{"42"}, header(&Message{Headers: map[string]string{"customer": "42"}}))
+	assert.Nil(t, header(&Message{}))
+
+	field := JSONFieldIndex("customer")
+	assert.Equal(t, []string{"42"}, field(&Message{Payload: []byte(`{"customer": 42}`)}))
+	assert.Equal(t, []string{"bob"}, field(&Message{Payload: []byte(`{"customer": "bob"}`)}))
+	assert.Nil(t, field(&Message{Payload: []byte(`{"customer": {"id": 42}}`)}))
+	assert.Nil(t, field(&Message{Payload: []byte(`not json`)}))
+}
+
+func TestHistoryLookup(t *testing.T) {
+	defer AccordCleanup()
+
+	accord := DummyAccord()
+	accord.AddIndex("customer", HeaderIndex("customer"))
+	accord.Start()
+
+	for i := uint64(1); i <= 5; i++ {
+		customer := "42"
+		if i%2 == 0 {
+			customer = "7"
+		}
+		msg := &Message{ID: i, Headers: map[string]string{"customer": customer}}
+		assert.Nil(t, accord.HandleNewMessage(msg))
+	}
+
+	assert.Equal(t, []uint64{5, 3, 1}, collectHistory(t, accord.History().Lookup("customer", "42")))
+	assert.Equal(t, []uint64{4, 2}, collectHistory(t, accord.History().Lookup("customer", "7")))
+	assert.Equal(t, []uint64{}, collectHistory(t, accord.History().Lookup("customer", "1")))
+	accord.Stop()
+
+	// A brand new index should be built from the existing history on startup
+	accord = DummyAccord()
+	accord.AddIndex("customer", HeaderIndex("customer"))
+	accord.AddIndex("id", func(msg *Message) []string {
+		if msg.ID > 3 {
+			return []string{"big"}
+		}
+		return nil
+	})
+	accord.Start()
+	defer accord.Stop()
+
+	assert.Equal(t, []uint64{5, 4}, collectHistory(t, accord.History().Lookup("id", "big")))
+	assert.Equal(t, []uint64{5, 3, 1}, collectHistory(t, accord.History().Lookup("customer", "42")))
+}
+
+func TestHistoryLookupUnavailable(t *testing.T) {
+	iter := NewHistory(nil).Lookup("customer", "42")
+	assert.False(t, iter.Next())
+	assert.NotNil(t, iter.Err())
+}
+
+func TestBatchedHistoryLookup(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	accord.FlushInterval = time.Hour
+	accord.AddIndex("customer", HeaderIndex("customer"))
+	assert.Nil(t, accord.Start())
+	assert.Nil(t, accord.flush())
+
+	for i := uint64(1); i <= 3; i++ {
+		assert.Nil(t, accord.HandleNewMessage(&Message{ID: i, Headers: map[string]string{"customer": "42"}}))
+	}
+
+	// Our index entries are buffered along with the rest of our state, and found all the same
+	assert.Equal(t, []uint64{3, 2, 1}, collectHistory(t, accord.History().Lookup("customer", "42")))
+
+	// Crashing loses them along with it, and they're caught up from our history just the same
+	accord.state.buffer.mutex.Lock()
+	accord.state.buffer.batch.Reset()
+	accord.state.buffer.values = map[string][]byte{}
+	accord.state.buffer.mutex.Unlock()
+	accord.Stop()
+
+	accord = DummyAccord()
+	accord.AddIndex("customer", HeaderIndex("customer"))
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+	assert.Equal(t, []uint64{3, 2, 1}, collectHistory(t, accord.History().Lookup("customer", "42")))
+}
@@ -0,0 +1,308 @@
+package accord
+
+import (
+	"sort"
+	"time"
+
+	"github.com/beeker1121/goque"
+)
+
+// DefaultInFlightTimeout is how long a shipped Message waits to be acknowledged when InFlightTimeout isn't
+// set
+const DefaultInFlightTimeout = 30 * time.Second
+
+// inFlight keeps track of the Messages that have been shipped to a peer but not acknowledged yet (see
+// PeerCursor.Ship)
+type inFlight struct {
+	// shipped is the item ID of the next Message that has never been shipped
+	shipped uint64
+
+	// flights are the Messages waiting to be acknowledged, keyed by their item IDs in our queue
+	flights map[uint64]*flight
+}
+
+// flight is a single shipped Message
+type flight struct {
+	msg      *Message
+	deadline time.Time
+}
+
+// inFlightTimeout returns how long shipped Messages wait to be acknowledged before they're shipped again
+func (accord *Accord) inFlightTimeout() time.Duration {
+	if accord.InFlightTimeout <= 0 {
+		return DefaultInFlightTimeout
+	}
+	return accord.InFlightTimeout
+}
+
+// inFlightFor returns the in-flight Messages of the cursor with the given key, forgetting any that the cursor
+// has been moved past some other way (by Advance or DeadLetter). The caller must hold the peers mutex
+func (accord *Accord) inFlightFor(key string) *inFlight {
+	if accord.peers.inFlight == nil {
+		accord.peers.inFlight = map[string]*inFlight{}
+	}
+	state, ok := accord.peers.inFlight[key]
+	if !ok {
+		state = &inFlight{flights: map[uint64]*flight{}}
+		accord.peers.inFlight[key] = state
+	}
+
+	cursor := accord.peers.cursors[key]
+	if state.shipped < cursor {
+		state.shipped = cursor
+	}
+	for item := range state.flights {
+		if item < cursor {
+			delete(state.flights, item)
+		}
+	}
+	return state
+}
+
+// Ship hands out up to max Messages to send to the peer, marking them as in flight. Unlike Peek, the
+// cursor remembers what it has handed out: the next call carries on after them, and a Message only counts
+// as delivered (and is only removed from the queue) once it's been acknowledged with Ack. Messages that
+// aren't acknowledged within InFlightTimeout, or that are handed back with Nack, are shipped again first.
+//
+// This is what gives transports at-least-once delivery, even ones that keep several batches in flight at
+// a time. Every shipped Message stays in our queue until it's acknowledged, so if we crash before then
+// it's shipped again once we're back. Peers should expect the odd duplicate (see Message.IdempotencyKey).
+//
+// Transports should use either Ship and Ack, or Peek and Advance, but not both
+func (cursor *PeerCursor) Ship(max int) ([]*Message, error) {
+	accord := cursor.accord
+	if accord.Paused() {
+		return []*Message{}, nil
+	}
+
+	accord.peers.mutex.Lock()
+	defer accord.peers.mutex.Unlock()
+
+	if _, ok := accord.peers.cursors[cursor.key()]; !ok {
+		return nil, ErrUnknownPeer
+	}
+	if !accord.inSyncWindow(cursor.name, time.Now()) {
+		return []*Message{}, nil
+	}
+	state := accord.inFlightFor(cursor.key())
+
+	// Anything that's timed out goes out again first, oldest first
+	now := time.Now()
+	items := []uint64{}
+	for item, flight := range state.flights {
+		if !flight.deadline.After(now) {
+			items = append(items, item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i] < items[j] })
+	if len(items) > max {
+		items = items[:max]
+	}
+	msgs := []*Message{}
+	for _, item := range items {
+		msgs = append(msgs, state.flights[item].msg)
+	}
+	resent := len(msgs)
+
+	shipped, err := accord.scanUnshipped(cursor, state.shipped, max-len(msgs), func(item uint64, msg *Message) {
+		items = append(items, item)
+		msgs = append(msgs, msg)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if limiter := accord.limiterFor(cursor.name); limiter != nil {
+		allowed := limiter.allowed(msgs)
+		if allowed < len(msgs) {
+			// The new Messages we can't send yet will be found again next time
+			if allowed > resent {
+				shipped = items[allowed]
+			} else {
+				shipped = state.shipped
+			}
+			items, msgs = items[:allowed], msgs[:allowed]
+		}
+
+		bytes := 0
+		for _, msg := range msgs {
+			bytes += len(msg.Payload)
+		}
+		limiter.take(len(msgs), bytes)
+	}
+
+	deadline := now.Add(accord.inFlightTimeout())
+	for i, item := range items {
+		state.flights[item] = &flight{msg: msgs[i], deadline: deadline}
+	}
+	if resent > len(msgs) {
+		resent = len(msgs)
+	}
+	if resent > 0 {
+		accord.Logger.WithField("peer", cursor.name).WithField("count", resent).Debug("Shipping unacknowledged messages again")
+	}
+	state.shipped = shipped
+
+	// Skipping over the peer's own Messages may have moved its cursor along
+	return msgs, accord.settleInFlight(cursor, state)
+}
+
+// Ack acknowledges that the peer received the shipped Messages with the given IDs. Once everything before
+// them has been acknowledged too, the cursor moves past them. Acknowledging a Message that isn't in flight
+// (because it was already acknowledged, say) does nothing
+func (cursor *PeerCursor) Ack(ids ...uint64) error {
+	count, bytes, err := cursor.ack(ids)
+	if err == nil {
+		cursor.accord.recordProgress(cursor.name, count, bytes)
+	}
+	return err
+}
+
+// ack does the work of Ack while holding the peers mutex, returning how many Messages were acknowledged
+// and the size of their Payloads
+func (cursor *PeerCursor) ack(ids []uint64) (int, int, error) {
+	accord := cursor.accord
+	accord.peers.mutex.Lock()
+	defer accord.peers.mutex.Unlock()
+
+	if _, ok := accord.peers.cursors[cursor.key()]; !ok {
+		return 0, 0, ErrUnknownPeer
+	}
+	state := accord.inFlightFor(cursor.key())
+	count, bytes := state.land(ids)
+	return count, bytes, accord.settleInFlight(cursor, state)
+}
+
+// Nack hands shipped Messages back because they couldn't be delivered, so that they're shipped again by the
+// next call to Ship rather than once they time out
+func (cursor *PeerCursor) Nack(ids ...uint64) error {
+	accord := cursor.accord
+	accord.peers.mutex.Lock()
+	defer accord.peers.mutex.Unlock()
+
+	if _, ok := accord.peers.cursors[cursor.key()]; !ok {
+		return ErrUnknownPeer
+	}
+	for _, flight := range accord.inFlightFor(cursor.key()).flights {
+		for _, id := range ids {
+			if flight.msg.ID == id {
+				flight.deadline = time.Time{}
+			}
+		}
+	}
+	return nil
+}
+
+// Reject sets shipped Messages the peer is never going to accept aside as dead letters (see DeadLetter),
+// which stops them from being in flight
+func (cursor *PeerCursor) Reject(reason string, ids ...uint64) error {
+	accord := cursor.accord
+	accord.peers.mutex.Lock()
+	defer accord.peers.mutex.Unlock()
+
+	if _, ok := accord.peers.cursors[cursor.key()]; !ok {
+		return ErrUnknownPeer
+	}
+	state := accord.inFlightFor(cursor.key())
+
+	rejected := []*Message{}
+	for _, flight := range state.flights {
+		for _, id := range ids {
+			if flight.msg.ID == id {
+				rejected = append(rejected, flight.msg)
+			}
+		}
+	}
+	err := accord.recordDeadLetters(cursor.name, reason, rejected)
+	if err != nil {
+		return err
+	}
+
+	state.land(ids)
+	return accord.settleInFlight(cursor, state)
+}
+
+// InFlight returns how many Messages have been shipped to the peer without being acknowledged yet
+func (cursor *PeerCursor) InFlight() int {
+	accord := cursor.accord
+	accord.peers.mutex.Lock()
+	defer accord.peers.mutex.Unlock()
+
+	if _, ok := accord.peers.cursors[cursor.key()]; !ok {
+		return 0
+	}
+	return len(accord.inFlightFor(cursor.key()).flights)
+}
+
+// land stops the Messages with the given IDs from being in flight, returning how many of them there were
+// and the size of their Payloads
+func (state *inFlight) land(ids []uint64) (count int, bytes int) {
+	landed := map[uint64]bool{}
+	for _, id := range ids {
+		landed[id] = true
+	}
+	for item, flight := range state.flights {
+		if landed[flight.msg.ID] {
+			count++
+			bytes += len(flight.msg.Payload)
+			delete(state.flights, item)
+		}
+	}
+	return count, bytes
+}
+
+// settleInFlight moves the cursor up to the first Message that's still in flight, or past everything that's
+// been shipped if nothing is. The caller must hold the peers mutex
+func (accord *Accord) settleInFlight(cursor *PeerCursor, state *inFlight) error {
+	next := state.shipped
+	for item := range state.flights {
+		if item < next {
+			next = item
+		}
+	}
+	err := accord.moveCursor(cursor.key(), next)
+	if err != nil {
+		return err
+	}
+
+	accord.notifyAcks()
+	return accord.trimQueue(cursor.queue())
+}
+
+// scanUnshipped walks the cursor's queue from the given item ID, calling fn for up to max Messages the peer
+// should be sent and skipping over any that originated from the peer itself or that it has already. It
+// returns the item ID of the next Message after those. The caller must hold the peers mutex
+func (accord *Accord) scanUnshipped(cursor *PeerCursor, next uint64, max int, fn func(uint64, *Message)) (uint64, error) {
+	queue := cursor.queue().queue
+	head, err := queue.Peek()
+	if err == goque.ErrEmpty {
+		return next, nil
+	}
+	if err != nil {
+		return next, err
+	}
+	if next < head.ID {
+		next = head.ID
+	}
+
+	for found := 0; found < max; next++ {
+		item, err := queue.PeekByID(next)
+		if err == goque.ErrOutOfBounds {
+			return next, nil
+		}
+		if err != nil {
+			return next, err
+		}
+
+		msg, err := accord.sealer.decodeMessage(item.Value)
+		if err != nil {
+			return next, err
+		}
+		if accord.skipsPeer(cursor, next, msg) {
+			continue
+		}
+		fn(next, msg)
+		found++
+	}
+	return next, nil
+}
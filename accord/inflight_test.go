@@ -0,0 +1,90 @@
+package accord
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShipAndAck(t *testing.T) {
+	defer AccordCleanup()
+	accord := historyTestAccord(t)
+	defer accord.Stop()
+
+	edge := accord.AddPeer("edge")
+	first, err := edge.Ship(2)
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{1, 2}, peerIDs(first))
+
+	// Shipping carries on after what's already in flight
+	second, err := edge.Ship(2)
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{3, 4}, peerIDs(second))
+	assert.Equal(t, 4, edge.InFlight())
+
+	// Acknowledgements can come back in any order, but the queue is only trimmed once everything before
+	// them has been acknowledged too
+	assert.Nil(t, edge.Ack(3, 4))
+	assert.Equal(t, uint64(4), accord.Queue().Len())
+	assert.Nil(t, edge.Ack(2, 1, 1))
+	assert.Equal(t, uint64(0), accord.Queue().Len())
+	assert.Equal(t, 0, edge.InFlight())
+
+	msgs, err := edge.Ship(10)
+	assert.Nil(t, err)
+	assert.Empty(t, msgs)
+	assert.Nil(t, accord.RemovePeer("edge"))
+	assert.Equal(t, ErrUnknownPeer, edge.Ack(1))
+}
+
+func TestShipAgain(t *testing.T) {
+	defer AccordCleanup()
+	accord := historyTestAccord(t)
+	accord.InFlightTimeout = 10 * time.Millisecond
+	defer accord.Stop()
+
+	// "remote" sent message 3 in the first place, so it's never shipped back
+	remote := accord.AddPeer("remote")
+	msgs, err := remote.Ship(10)
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{1, 2, 4}, peerIDs(msgs))
+
+	// Nothing's timed out yet
+	msgs, err = remote.Ship(10)
+	assert.Nil(t, err)
+	assert.Empty(t, msgs)
+
+	assert.Nil(t, remote.Nack(2))
+	msgs, err = remote.Ship(10)
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{2}, peerIDs(msgs))
+
+	// The rest are shipped again once they time out, oldest first
+	assert.Nil(t, remote.Ack(2))
+	time.Sleep(20 * time.Millisecond)
+	msgs, err = remote.Ship(1)
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{1}, peerIDs(msgs))
+
+	assert.Nil(t, remote.Ack(1, 4))
+	assert.Equal(t, uint64(0), accord.Queue().Len())
+}
+
+func TestShipReject(t *testing.T) {
+	defer AccordCleanup()
+	accord := historyTestAccord(t)
+	defer accord.Stop()
+
+	edge := accord.AddPeer("edge")
+	msgs, err := edge.Ship(2)
+	assert.Nil(t, err)
+	assert.Nil(t, edge.Reject("unreadable", peerIDs(msgs)...))
+	assert.Equal(t, 0, edge.InFlight())
+	assert.Equal(t, uint64(2), accord.Queue().Len())
+
+	letters, err := accord.DeadLetters()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(letters))
+	assert.Equal(t, "unreadable", letters[0].Reason)
+}
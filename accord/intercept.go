@@ -0,0 +1,33 @@
+package accord
+
+// RemoteInterceptor wraps the handling of the Messages handed to HandleRemoteMessage. It's given the
+// Message and next, which carries on handling it, and returns what HandleRemoteMessage should. It can
+// hold the Message up before calling next, call next more than once, or not call it at all and fail the
+// Message instead. Returning an error that isn't a StageError makes transports try the Message again later
+type RemoteInterceptor func(msg *Message, next func(*Message) error) error
+
+// InterceptRemote adds an interceptor around the handling of remote Messages, which is generally done by a
+// Component when it starts (like components.Chaos, which uses one to make our transports misbehave).
+// Interceptors are run in the order they were added in, the first one on the outside. They're run before
+// we take hold of our processing lock, so one that holds a Message up doesn't hold up any others
+func (accord *Accord) InterceptRemote(interceptor RemoteInterceptor) {
+	accord.interceptorsMutex.Lock()
+	defer accord.interceptorsMutex.Unlock()
+	accord.interceptors = append(accord.interceptors, interceptor)
+}
+
+// interceptRemote hands a remote Message to our interceptors, and then to handleRemoteMessage
+func (accord *Accord) interceptRemote(msg *Message) error {
+	accord.interceptorsMutex.RLock()
+	interceptors := accord.interceptors
+	accord.interceptorsMutex.RUnlock()
+
+	next := accord.handleRemoteMessage
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, inner := interceptors[i], next
+		next = func(msg *Message) error {
+			return interceptor(msg, inner)
+		}
+	}
+	return next(msg)
+}
@@ -0,0 +1,40 @@
+package accord
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInterceptRemote(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	called := []string{}
+	accord.InterceptRemote(func(msg *Message, next func(*Message) error) error {
+		called = append(called, "outer")
+		return next(msg)
+	})
+	refuse := errors.New("refused")
+	accord.InterceptRemote(func(msg *Message, next func(*Message) error) error {
+		called = append(called, "inner")
+		if string(msg.Payload) == "refuse" {
+			return refuse
+		}
+		return next(msg)
+	})
+
+	msg, _ := NewMessage([]byte("hello"))
+	msg.Origin = "elsewhere"
+	assert.Nil(t, accord.HandleRemoteMessage(msg))
+	assert.Equal(t, []string{"outer", "inner"}, called)
+	assert.Equal(t, uint64(1), accord.History().Len())
+
+	msg, _ = NewMessage([]byte("refuse"))
+	msg.Origin = "elsewhere"
+	assert.Equal(t, refuse, accord.HandleRemoteMessage(msg))
+	assert.Equal(t, uint64(1), accord.History().Len())
+}
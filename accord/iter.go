@@ -0,0 +1,73 @@
+package accord
+
+import (
+	"iter"
+)
+
+// All returns an iterator over every Message in the history, from the most recent to the oldest, so that
+// simple traversals can be written as:
+//
+//	for msg := range accord.History().All() {
+//		...
+//	}
+//
+// Any error reading the history simply ends the iteration early; use Query if you need to know about it
+func (history *History) All() iter.Seq[*Message] {
+	return history.Query(HistoryFilter{}).All()
+}
+
+// All adapts a HistoryIterator into a range-over-func iterator. Err can still be checked once the loop
+// is done
+func (iter *HistoryIterator) All() iter.Seq[*Message] {
+	return func(yield func(*Message) bool) {
+		for iter.Next() {
+			if !yield(iter.Message()) {
+				return
+			}
+		}
+	}
+}
+
+// All returns an iterator over every Message waiting in the queue, from the oldest to the newest. Any
+// error reading the queue simply ends the iteration early
+func (queue *Queue) All() iter.Seq[*Message] {
+	return func(yield func(*Message) bool) {
+		queue.walk(yield)
+	}
+}
+
+// AllDeadLetters returns an iterator over every dead letter, from the oldest to the newest, whether it's a
+// Message a transport gave up on or one our own Manager couldn't process (see DeadLetters). Like the rest
+// of these, any error reading the dead letters simply ends the iteration early, as does a dead letter
+// being requeued from under us (see RequeueDeadLetters)
+func (accord *Accord) AllDeadLetters() iter.Seq[DeadLetter] {
+	return func(yield func(DeadLetter) bool) {
+		head, err := accord.deadLetters.Peek()
+		if err != nil {
+			return
+		}
+		for id := head.ID; ; id++ {
+			item, err := accord.deadLetters.PeekByID(id)
+			if err != nil {
+				return
+			}
+			letter, err := accord.decodeDeadLetter(item.Value)
+			if err != nil || !yield(letter) {
+				return
+			}
+		}
+	}
+}
+
+// AllConflicts returns an iterator over the dead letters of Messages our Manager reported a ConflictError
+// for that OnConflict didn't settle, from the oldest to the newest, so they can be settled by hand. It ends
+// early just like AllDeadLetters
+func (accord *Accord) AllConflicts() iter.Seq[DeadLetter] {
+	return func(yield func(DeadLetter) bool) {
+		for letter := range accord.AllDeadLetters() {
+			if letter.Conflict && !yield(letter) {
+				return
+			}
+		}
+	}
+}
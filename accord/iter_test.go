@@ -0,0 +1,125 @@
+package accord
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistoryAll(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	accord.Start()
+	defer accord.Stop()
+
+	for i := uint64(1); i <= 3; i++ {
+		assert.Nil(t, accord.HandleNewMessage(&Message{ID: i}))
+	}
+
+	ids := []uint64{}
+	for msg := range accord.History().All() {
+		ids = append(ids, msg.ID)
+	}
+	assert.Equal(t, []uint64{3, 2, 1}, ids)
+
+	// Breaking out of the loop early shouldn't cause any problems
+	ids = []uint64{}
+	for msg := range accord.History().All() {
+		ids = append(ids, msg.ID)
+		break
+	}
+	assert.Equal(t, []uint64{3}, ids)
+}
+
+func TestQueueAll(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	accord.Start()
+	defer accord.Stop()
+
+	for i := uint64(1); i <= 3; i++ {
+		assert.Nil(t, accord.HandleNewMessage(&Message{ID: i}))
+	}
+
+	// Consume the front of the queue to make sure we start from wherever it currently is
+	_, err := accord.syncQueue.Dequeue()
+	assert.Nil(t, err)
+
+	ids := []uint64{}
+	for msg := range accord.Queue().All() {
+		ids = append(ids, msg.ID)
+	}
+	assert.Equal(t, []uint64{2, 3}, ids)
+	assert.Equal(t, uint64(2), accord.Queue().Len())
+
+	first, err := accord.Queue().Get(0)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), first.ID)
+}
+
+func TestAllDeadLetters(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	accord.Start()
+	defer accord.Stop()
+
+	for range accord.AllDeadLetters() {
+		t.Fatal("there shouldn't be any dead letters yet")
+	}
+
+	peer := accord.AddPeer("edge")
+	for i := uint64(1); i <= 3; i++ {
+		assert.Nil(t, accord.HandleNewMessage(&Message{ID: i}))
+	}
+	assert.Nil(t, peer.DeadLetter(2, "refused"))
+	assert.Nil(t, accord.recordDeadLetters(accord.NodeID, "conflict", []*Message{{ID: 4}}))
+
+	letters := []DeadLetter{}
+	for letter := range accord.AllDeadLetters() {
+		letters = append(letters, letter)
+	}
+	if assert.Len(t, letters, 3) {
+		assert.Equal(t, []uint64{1, 2, 4}, []uint64{letters[0].Message.ID, letters[1].Message.ID, letters[2].Message.ID})
+		assert.Equal(t, "edge", letters[0].Peer)
+		assert.Equal(t, "conflict", letters[2].Reason)
+	}
+
+	// Breaking out of the loop early shouldn't cause any problems
+	count := 0
+	for range accord.AllDeadLetters() {
+		count++
+		break
+	}
+	assert.Equal(t, 1, count)
+}
+
+func TestAllConflicts(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	accord.Start()
+	defer accord.Stop()
+
+	// Only the dead letters our Manager set aside because of a conflict are included
+	assert.Nil(t, accord.recordDeadLetters("edge", "refused", []*Message{{ID: 1}}))
+	accord.handleConflict(&Message{ID: 2}, &ConflictError{Err: errors.New("stale version")})
+	assert.Nil(t, accord.recordDeadLetters(accord.NodeID, "panicked", []*Message{{ID: 3}}))
+	accord.handleConflict(&Message{ID: 4}, &ConflictError{Err: errors.New("duplicate email")})
+
+	conflicts := []DeadLetter{}
+	for letter := range accord.AllConflicts() {
+		conflicts = append(conflicts, letter)
+	}
+	if assert.Len(t, conflicts, 2) {
+		assert.Equal(t, []uint64{2, 4}, []uint64{conflicts[0].Message.ID, conflicts[1].Message.ID})
+		assert.Equal(t, accord.NodeID, conflicts[0].Peer)
+		assert.Equal(t, "conflict: stale version", conflicts[0].Reason)
+	}
+
+	count := 0
+	for range accord.AllConflicts() {
+		count++
+		break
+	}
+	assert.Equal(t, 1, count)
+}
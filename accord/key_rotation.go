@@ -0,0 +1,215 @@
+package accord
+
+import (
+	"errors"
+
+	"github.com/beeker1121/goque"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// EventEncryptionKeyRotated is emitted once RotateEncryptionKey has encrypted everything we've stored with
+// our new key
+const EventEncryptionKeyRotated = "encryption_key_rotated"
+
+// ErrNotEncrypted is returned by RotateEncryptionKey when we weren't started with an encryption key
+var ErrNotEncrypted = errors.New("our data directory isn't encrypted")
+
+// RotateEncryptionKey makes key our active encryption key and encrypts everything we've stored with older
+// keys (or before we were encrypting at all) again with it, returning how many items it re-encrypted. We
+// carry on as normal meanwhile: the keys it replaces are still accepted until it's done, and for their
+// Keyring's Window after that, so everything can still be read.
+//
+// We must have been started with an encryption key. If it was set through EncryptionKey, EncryptionKeys
+// is set to a Keyring holding it and key. Make sure we're handed key the next time we're started, along
+// with the key it replaced in case we were stopped before we were done. Backups taken beforehand still
+// need the old key to be restored
+func (accord *Accord) RotateEncryptionKey(key []byte) (int, error) {
+	if accord.sealer == nil {
+		return 0, ErrNotEncrypted
+	}
+	if len(key) != EncryptionKeySize {
+		return 0, ErrEncryptionKeySize
+	}
+	err := accord.sealer.keys.Rotate(key)
+	if err != nil {
+		return 0, err
+	}
+	accord.EncryptionKeys = accord.sealer.keys
+
+	log := accord.Logger.WithField("key", KeyID(key))
+	log.Info("Rotating our encryption key")
+	count, err := accord.reencrypt()
+	if err != nil {
+		log.WithError(err).WithField("reencrypted", count).Error("We could not encrypt everything with our new key")
+		return count, err
+	}
+
+	log.WithField("reencrypted", count).Info("Everything we've stored is encrypted with our new key")
+	accord.Emit(EventEncryptionKeyRotated, "Everything we've stored is encrypted with our new key", map[string]interface{}{"key": KeyID(key), "reencrypted": count})
+	return count, nil
+}
+
+// reencrypt encrypts everything that isn't encrypted with our active key yet with it, returning how many
+// items that was
+func (accord *Accord) reencrypt() (int, error) {
+	total := 0
+	count, err := accord.reencryptStack(accord.historyStack)
+	total += count
+	if err != nil {
+		return total, err
+	}
+
+	queues := []*goque.Queue{}
+	if accord.deadLetters != nil {
+		queues = append(queues, accord.deadLetters)
+	}
+	for _, shard := range accord.allShards() {
+		queues = append(queues, shard.queue)
+	}
+	for _, queue := range queues {
+		count, err = accord.reencryptQueue(queue)
+		total += count
+		if err != nil {
+			return total, err
+		}
+	}
+
+	count, err = accord.state.reencrypt(accord.sealer)
+	total += count
+	return total, err
+}
+
+// reencryptStack re-encrypts every item in a stack that needs it. Items are only ever pushed onto our
+// history, and those are encrypted with our active key already, so we only need to go as far as its top
+// is now
+func (accord *Accord) reencryptStack(stack *goque.Stack) (int, error) {
+	top, err := stack.Peek()
+	if err == goque.ErrEmpty {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// Item IDs start at 1
+	count := 0
+	for id := top.ID; id > 0; id-- {
+		item, err := stack.PeekByID(id)
+		if err == goque.ErrOutOfBounds {
+			break
+		}
+		if err != nil {
+			return count, err
+		}
+		data, changed, err := accord.sealer.reseal(item.Value)
+		if err != nil {
+			return count, err
+		}
+		if !changed {
+			continue
+		}
+		_, err = stack.Update(id, data)
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// reencryptQueue re-encrypts every item in a queue that needs it. Queues are consumed from the front while
+// we're at it, so items that are gone by the time we get to them are skipped
+func (accord *Accord) reencryptQueue(queue *goque.Queue) (int, error) {
+	head, err := queue.Peek()
+	if err == goque.ErrEmpty {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for id := head.ID; ; id++ {
+		item, err := queue.PeekByID(id)
+		if err == goque.ErrOutOfBounds {
+			// Either it was consumed before we got to it, in which case we carry on from the queue's head,
+			// or we've reached the end of the queue
+			head, err = queue.Peek()
+			if err == nil && head.ID > id {
+				id = head.ID - 1
+				continue
+			}
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+		data, changed, err := accord.sealer.reseal(item.Value)
+		if err != nil {
+			return count, err
+		}
+		if !changed {
+			continue
+		}
+		_, err = queue.Update(id, data)
+		if err == goque.ErrOutOfBounds {
+			continue
+		}
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+}
+
+// reseal encrypts data with our active key if it isn't already, returning whether it needed it
+func (s *sealer) reseal(data []byte) ([]byte, bool, error) {
+	active, ok := s.keys.Active()
+	if !ok {
+		return nil, false, ErrNoKeys
+	}
+	if id, sealed := sealedWith(data); sealed && id == active.ID {
+		return data, false, nil
+	}
+	plain, err := s.open(data)
+	if err != nil {
+		return nil, false, err
+	}
+	data, err = s.seal(plain)
+	return data, true, err
+}
+
+// reencrypt re-encrypts the values Managers and Components keep in our state, and the Messages waiting
+// there to be handled (see HandleNewMessageAt), that need it. Values can't be set meanwhile, so that none are
+// overwritten with what they were before
+func (state *State) reencrypt(s *sealer) (int, error) {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	err := state.Flush()
+	if err != nil {
+		return 0, err
+	}
+
+	batch := new(leveldb.Batch)
+	for _, prefix := range []string{userPrefix, componentDataPrefix, scheduledPrefix} {
+		iter := state.db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+		for iter.Next() {
+			data, changed, err := s.reseal(iter.Value())
+			if err != nil {
+				iter.Release()
+				return 0, err
+			}
+			if changed {
+				batch.Put(append([]byte{}, iter.Key()...), data)
+			}
+		}
+		err = iter.Error()
+		iter.Release()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return batch.Len(), state.db.Write(batch, state.writeOptions)
+}
@@ -0,0 +1,79 @@
+package accord
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRotateEncryptionKey(t *testing.T) {
+	defer AccordCleanup()
+	oldKey := bytes.Repeat([]byte{7}, EncryptionKeySize)
+	newKey := bytes.Repeat([]byte{9}, EncryptionKeySize)
+
+	accord := DummyAccord()
+	assert.Nil(t, accord.Start())
+	_, err := accord.RotateEncryptionKey(newKey)
+	assert.Equal(t, ErrNotEncrypted, err)
+	accord.Stop()
+
+	// Start encrypted, with something stored from before we were, too
+	assert.Nil(t, accord.Start())
+	accord.AddPeer("remote")
+	plain, _ := NewMessage([]byte("from before"))
+	assert.Nil(t, accord.HandleNewMessage(plain))
+	accord.Stop()
+	accord.EncryptionKey = oldKey
+	assert.Nil(t, accord.Start())
+	msg, _ := NewMessage([]byte("top secret"))
+	assert.Nil(t, accord.HandleNewMessage(msg))
+	assert.Nil(t, accord.recordDeadLetters("remote", "testing", []*Message{msg}))
+	assert.Nil(t, accord.State().Put([]byte("note"), []byte("classified")))
+	assert.Nil(t, accord.SaveComponentData("test/data", []byte("ours")))
+
+	_, err = accord.RotateEncryptionKey([]byte("short"))
+	assert.Equal(t, ErrEncryptionKeySize, err)
+	count, err := accord.RotateEncryptionKey(newKey)
+	assert.Nil(t, err)
+	// Two Messages in our history and queue, a dead letter, and two values in our state
+	assert.Equal(t, 7, count)
+	assert.NotNil(t, accord.EncryptionKeys)
+
+	// Everything's encrypted with the new key, so the old one can go
+	assert.Nil(t, accord.EncryptionKeys.Remove(KeyID(oldKey)))
+	item, err := accord.historyStack.PeekByOffset(1)
+	assert.Nil(t, err)
+	id, sealed := sealedWith(item.Value)
+	assert.True(t, sealed)
+	assert.Equal(t, KeyID(newKey), id)
+
+	got, err := accord.History().Get(1)
+	assert.Nil(t, err)
+	assert.Equal(t, plain.Payload, got.Payload)
+	msgs, err := accord.Peer("remote").Peek(2)
+	assert.Nil(t, err)
+	assert.Len(t, msgs, 2)
+	letters, err := accord.DeadLetters()
+	assert.Nil(t, err)
+	assert.Equal(t, msg.Payload, letters[0].Message.Payload)
+	value, err := accord.State().Get([]byte("note"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("classified"), value)
+	value, err = accord.LoadComponentData("test/data")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("ours"), value)
+
+	accord.Stop()
+
+	// The new key is all we need from now on
+	accord.EncryptionKeys = nil
+	accord.EncryptionKey = oldKey
+	assert.Equal(t, ErrDecryption, accord.Start())
+	accord.EncryptionKey = newKey
+	assert.Nil(t, accord.Start())
+	got, err = accord.History().Get(1)
+	assert.Nil(t, err)
+	assert.Equal(t, plain.Payload, got.Payload)
+	accord.Stop()
+}
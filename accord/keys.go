@@ -0,0 +1,187 @@
+package accord
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrKeyExists is returned by Rotate when the new key is already on the Keyring, or is so similar to one
+// that's on it that they'd share an ID
+var ErrKeyExists = errors.New("the key is already on the keyring")
+
+// ErrNoKeys is returned when a Keyring without any keys is used to encrypt something
+var ErrNoKeys = errors.New("the keyring has no keys")
+
+// ErrActiveKey is returned by Remove for the Keyring's active key, which can only be replaced by Rotate
+var ErrActiveKey = errors.New("the active key can't be removed")
+
+// KeyID identifies a key without giving it away, from the first bytes of its SHA-256 hash. It's what's
+// stored alongside what a key encrypts, and sent alongside what it signs, so that the right one of a
+// Keyring's keys can be picked out to decrypt or verify it with
+func KeyID(key []byte) uint32 {
+	sum := sha256.Sum256(key)
+	return binary.BigEndian.Uint32(sum[:4])
+}
+
+// KeyVersion is one of the versions of a key on a Keyring
+type KeyVersion struct {
+	ID  uint32
+	Key []byte
+
+	// Added is when the version was added to the Keyring, and Retired when Rotate replaced it, which is
+	// zero for the active version
+	Added   time.Time
+	Retired time.Time
+}
+
+// Keyring holds every version of a key we still accept: the active one, which everything new is encrypted
+// or signed with, and the ones it has replaced. Rotating keys with Rotate is safe while they're in use, as
+// what was encrypted or signed with a retired key is still accepted for a while (see Window) rather than
+// suddenly turned away. The zero value is an empty Keyring, and it's safe to use from multiple goroutines
+type Keyring struct {
+	// Window is how long retired keys are still accepted for once they've been replaced, after which
+	// they're forgotten. Zero keeps them until they're removed with Remove
+	Window time.Duration
+
+	mutex  sync.RWMutex
+	keys   map[uint32]*KeyVersion
+	active uint32
+}
+
+// NewKeyring creates a Keyring with the given keys, the last of which is the active one. Keys before it
+// are treated as though they had just been retired
+func NewKeyring(keys ...[]byte) (*Keyring, error) {
+	ring := &Keyring{}
+	for _, key := range keys {
+		err := ring.Rotate(key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ring, nil
+}
+
+// Rotate makes key the active key, retiring the one it replaces
+func (ring *Keyring) Rotate(key []byte) error {
+	ring.mutex.Lock()
+	defer ring.mutex.Unlock()
+
+	if ring.keys == nil {
+		ring.keys = map[uint32]*KeyVersion{}
+	}
+	id := KeyID(key)
+	if _, ok := ring.keys[id]; ok {
+		return ErrKeyExists
+	}
+
+	now := time.Now()
+	if active, ok := ring.keys[ring.active]; ok {
+		active.Retired = now
+	}
+	ring.keys[id] = &KeyVersion{ID: id, Key: append([]byte{}, key...), Added: now}
+	ring.active = id
+	ring.forgetExpired(now)
+	return nil
+}
+
+// Active returns the active key, which everything new should be encrypted or signed with. It returns false
+// if the Keyring is empty
+func (ring *Keyring) Active() (KeyVersion, bool) {
+	ring.mutex.RLock()
+	defer ring.mutex.RUnlock()
+
+	active, ok := ring.keys[ring.active]
+	if !ok {
+		return KeyVersion{}, false
+	}
+	return *active, true
+}
+
+// Key returns the key with the given ID, as long as it's still accepted: it's either the active key or
+// one that was retired less than Window ago
+func (ring *Keyring) Key(id uint32) ([]byte, bool) {
+	ring.mutex.RLock()
+	defer ring.mutex.RUnlock()
+
+	version, ok := ring.keys[id]
+	if !ok || ring.expired(version, time.Now()) {
+		return nil, false
+	}
+	return version.Key, true
+}
+
+// Versions returns every version of the key that's still accepted, the active one first and the rest from
+// the most recently retired
+func (ring *Keyring) Versions() []KeyVersion {
+	ring.mutex.RLock()
+	defer ring.mutex.RUnlock()
+
+	now := time.Now()
+	versions := []KeyVersion{}
+	for _, version := range ring.keys {
+		if !ring.expired(version, now) {
+			versions = append(versions, *version)
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		if versions[i].Retired.IsZero() != versions[j].Retired.IsZero() {
+			return versions[i].Retired.IsZero()
+		}
+		return versions[i].Retired.After(versions[j].Retired)
+	})
+	return versions
+}
+
+// Remove stops accepting a retired key straight away, without waiting for its Window to be over
+func (ring *Keyring) Remove(id uint32) error {
+	ring.mutex.Lock()
+	defer ring.mutex.Unlock()
+
+	if id == ring.active {
+		return ErrActiveKey
+	}
+	delete(ring.keys, id)
+	return nil
+}
+
+// Sign returns the HMAC-SHA256 of data keyed with the active key, along with the ID of the key, which
+// should be sent along with it so that Verify knows which key to check it with
+func (ring *Keyring) Sign(data []byte) (uint32, []byte, bool) {
+	active, ok := ring.Active()
+	if !ok {
+		return 0, nil, false
+	}
+	mac := hmac.New(sha256.New, active.Key)
+	mac.Write(data)
+	return active.ID, mac.Sum(nil), true
+}
+
+// Verify checks a signature made by Sign, with any key that's still accepted
+func (ring *Keyring) Verify(id uint32, data []byte, signature []byte) bool {
+	key, ok := ring.Key(id)
+	if !ok {
+		return false
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hmac.Equal(mac.Sum(nil), signature)
+}
+
+// expired reports whether a retired key's Window is over. The caller must hold the mutex
+func (ring *Keyring) expired(version *KeyVersion, now time.Time) bool {
+	return ring.Window > 0 && !version.Retired.IsZero() && now.Sub(version.Retired) > ring.Window
+}
+
+// forgetExpired drops the keys whose Window is over. The caller must hold the mutex for writing
+func (ring *Keyring) forgetExpired(now time.Time) {
+	for id, version := range ring.keys {
+		if ring.expired(version, now) {
+			delete(ring.keys, id)
+		}
+	}
+}
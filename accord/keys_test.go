@@ -0,0 +1,71 @@
+package accord
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyring(t *testing.T) {
+	ring, err := NewKeyring([]byte("first"), []byte("second"))
+	assert.Nil(t, err)
+	active, ok := ring.Active()
+	assert.True(t, ok)
+	assert.Equal(t, KeyID([]byte("second")), active.ID)
+	assert.Equal(t, []byte("second"), active.Key)
+	assert.True(t, active.Retired.IsZero())
+
+	assert.Equal(t, ErrKeyExists, ring.Rotate([]byte("first")))
+	assert.Nil(t, ring.Rotate([]byte("third")))
+	versions := ring.Versions()
+	assert.Len(t, versions, 3)
+	assert.Equal(t, []byte("third"), versions[0].Key)
+	assert.Equal(t, []byte("second"), versions[1].Key)
+	assert.Equal(t, []byte("first"), versions[2].Key)
+
+	// Retired keys are still accepted until they're removed
+	key, ok := ring.Key(KeyID([]byte("first")))
+	assert.True(t, ok)
+	assert.Equal(t, []byte("first"), key)
+	assert.Equal(t, ErrActiveKey, ring.Remove(KeyID([]byte("third"))))
+	assert.Nil(t, ring.Remove(KeyID([]byte("first"))))
+	_, ok = ring.Key(KeyID([]byte("first")))
+	assert.False(t, ok)
+
+	_, ok = (&Keyring{}).Active()
+	assert.False(t, ok)
+}
+
+func TestKeyringWindow(t *testing.T) {
+	ring := &Keyring{Window: 10 * time.Millisecond}
+	assert.Nil(t, ring.Rotate([]byte("old")))
+	assert.Nil(t, ring.Rotate([]byte("new")))
+
+	_, ok := ring.Key(KeyID([]byte("old")))
+	assert.True(t, ok)
+	time.Sleep(20 * time.Millisecond)
+	_, ok = ring.Key(KeyID([]byte("old")))
+	assert.False(t, ok)
+	_, ok = ring.Key(KeyID([]byte("new")))
+	assert.True(t, ok)
+	assert.Len(t, ring.Versions(), 1)
+}
+
+func TestKeyringSign(t *testing.T) {
+	ring, err := NewKeyring([]byte("old"))
+	assert.Nil(t, err)
+	id, signature, ok := ring.Sign([]byte("payload"))
+	assert.True(t, ok)
+	assert.True(t, ring.Verify(id, []byte("payload"), signature))
+	assert.False(t, ring.Verify(id, []byte("tampered"), signature))
+
+	// What the old key signed is still accepted once it's been rotated, until it's removed
+	assert.Nil(t, ring.Rotate([]byte("new")))
+	newID, newSignature, _ := ring.Sign([]byte("payload"))
+	assert.NotEqual(t, id, newID)
+	assert.True(t, ring.Verify(newID, []byte("payload"), newSignature))
+	assert.True(t, ring.Verify(id, []byte("payload"), signature))
+	assert.Nil(t, ring.Remove(id))
+	assert.False(t, ring.Verify(id, []byte("payload"), signature))
+}
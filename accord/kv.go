@@ -0,0 +1,92 @@
+package accord
+
+import (
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/errors"
+)
+
+// userPrefix is where the keys set through State's Put live in our state database, out of the way of our
+// own
+const userPrefix = "user/"
+
+// State returns our state, which Managers can keep small amounts of their own data in (see State's Put).
+// It's only available while we're started
+func (accord *Accord) State() *State {
+	return accord.state
+}
+
+// userKey returns where a key set through Put is kept in our database
+func userKey(key []byte) []byte {
+	return append([]byte(userPrefix), key...)
+}
+
+// Get returns the value stored under key with Put, or nil if there isn't one
+func (state *State) Get(key []byte) ([]byte, error) {
+	value, err := state.get(userKey(key))
+	if err == errors.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	value, err = state.sealer.open(value)
+	if err != nil {
+		return nil, err
+	}
+	// Values we haven't flushed yet are our own, so they mustn't be handed out as they are
+	return append([]byte{}, value...), nil
+}
+
+// Put stores a value under key, for Managers that need to hold on to a little metadata of their own (the
+// last version of a record they've seen, say) without bringing a database of their own along. It's kept in
+// the same store as the rest of our state, under a namespace of its own so that it can't clash with
+// anything of ours, and so it's written just like the rest of our state (see FlushInterval and
+// StateSyncMode), encrypted along with it (see EncryptionKey), and is included in our snapshots and
+// backups. It isn't meant for large amounts of data
+func (state *State) Put(key []byte, value []byte) error {
+	// Our values are being re-encrypted while our mutex is held (see RotateEncryptionKey)
+	state.mutex.RLock()
+	defer state.mutex.RUnlock()
+
+	value, err := state.sealer.seal(value)
+	if err != nil {
+		return err
+	}
+	batch := new(leveldb.Batch)
+	batch.Put(userKey(key), value)
+	return state.write(batch)
+}
+
+// Delete removes the value stored under key, if there is one
+func (state *State) Delete(key []byte) error {
+	state.mutex.RLock()
+	defer state.mutex.RUnlock()
+
+	batch := new(leveldb.Batch)
+	batch.Delete(userKey(key))
+	return state.write(batch)
+}
+
+// GetTyped decodes the value stored under key with PutTyped into a T, using JSONCodec. It returns whether
+// there was a value, leaving the T empty when there wasn't
+func GetTyped[T any](state *State, key string) (T, bool, error) {
+	var value T
+	data, err := state.Get([]byte(key))
+	if err != nil || data == nil {
+		return value, false, err
+	}
+	err = JSONCodec.Unmarshal(data, &value)
+	if err != nil {
+		return value, false, err
+	}
+	return value, true, nil
+}
+
+// PutTyped encodes a value with JSONCodec and stores it under key
+func PutTyped[T any](state *State, key string, value T) error {
+	data, err := JSONCodec.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return state.Put([]byte(key), data)
+}
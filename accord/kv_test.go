@@ -0,0 +1,57 @@
+package accord
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStateKV(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	accord.FlushInterval = time.Hour
+	assert.Nil(t, accord.Start())
+
+	state := accord.State()
+	value, err := state.Get([]byte("missing"))
+	assert.Nil(t, err)
+	assert.Nil(t, value)
+
+	// What's buffered can be read back before it's flushed
+	assert.Nil(t, state.Put([]byte("version"), []byte("42")))
+	value, err = state.Get([]byte("version"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("42"), value)
+
+	// Our own keys are kept apart
+	assert.Nil(t, state.Put([]byte(stateKey), []byte("mine")))
+	assert.Nil(t, state.Put([]byte("gone"), []byte("soon")))
+	assert.Nil(t, state.Delete([]byte("gone")))
+
+	type cursor struct {
+		Table string
+		Row   int
+	}
+	assert.Nil(t, PutTyped(state, "cursor", cursor{"users", 7}))
+	accord.Stop()
+
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+	state = accord.State()
+	assert.Equal(t, uint64(0), state.GetCurrent())
+	value, err = state.Get([]byte("version"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("42"), value)
+	value, err = state.Get([]byte("gone"))
+	assert.Nil(t, err)
+	assert.Nil(t, value)
+
+	got, ok, err := GetTyped[cursor](state, "cursor")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, cursor{"users", 7}, got)
+	_, ok, err = GetTyped[cursor](state, "missing")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
@@ -0,0 +1,94 @@
+package accord
+
+import (
+	"github.com/syndtr/goleveldb/leveldb/errors"
+)
+
+// componentDataPrefix is where Components keep their own data in our state database (see
+// SaveComponentData)
+const componentDataPrefix = "component/"
+
+// Event kinds emitted by LeaderElectors
+const (
+	EventLeaderElected  = "leader_elected"
+	EventLeadershipLost = "leadership_lost"
+)
+
+// LeaderElector is implemented by Components that elect a single leader amongst a set of Accord nodes.
+// Register one like any other Component and Accord will defer to it in IsLeader
+type LeaderElector interface {
+	// IsLeader reports whether this node is currently the leader
+	IsLeader() bool
+
+	// Leader returns the NodeID of the current leader, or an empty string if there isn't one right now
+	Leader() string
+}
+
+// elector returns the first of our Components that elects leaders, if we have one. Our Components can be
+// added and removed while we're running, so we look through a copy of them rather than holding
+// componentsMutex while the elector is asked anything
+func (accord *Accord) elector() LeaderElector {
+	accord.componentsMutex.Lock()
+	components := append([]Component{}, accord.components...)
+	accord.componentsMutex.Unlock()
+
+	for _, comp := range components {
+		if elector, ok := comp.(LeaderElector); ok {
+			return elector
+		}
+	}
+	return nil
+}
+
+// IsLeader reports whether this node is the leader of its cluster. Applications can use this to make sure
+// that only one node generates certain kinds of Messages (scheduled jobs, for instance) while the rest stay
+// passive. Without a LeaderElector amongst our Components we're on our own, and so always the leader.
+//
+// Keep in mind that leadership can change at any moment, and that for a short while after a network
+// partition two nodes may both believe they're leading. Messages generated by a leader should still be
+// safe to apply twice
+func (accord *Accord) IsLeader() bool {
+	elector := accord.elector()
+	if elector == nil {
+		return true
+	}
+	return elector.IsLeader()
+}
+
+// Leader returns the NodeID of our cluster's current leader, or an empty string while there isn't one
+func (accord *Accord) Leader() string {
+	elector := accord.elector()
+	if elector == nil {
+		return accord.NodeID
+	}
+	return elector.Leader()
+}
+
+// LoadComponentData returns data a Component previously saved with SaveComponentData, or nil if it never
+// saved anything under that key
+func (accord *Accord) LoadComponentData(key string) ([]byte, error) {
+	data, err := accord.state.db.Get([]byte(componentDataPrefix+key), nil)
+	if err == errors.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return accord.sealer.open(data)
+}
+
+// SaveComponentData durably stores a small amount of data on behalf of a Component, so that it survives
+// restarts. Keys should be prefixed with the Component's name to keep them from clashing. Component data
+// is particular to a node, so it's left out of snapshots. Like the rest of our data, it's encrypted when we
+// have an EncryptionKey
+func (accord *Accord) SaveComponentData(key string, data []byte) error {
+	// Component data is being re-encrypted while our state's mutex is held (see RotateEncryptionKey)
+	accord.state.mutex.RLock()
+	defer accord.state.mutex.RUnlock()
+
+	data, err := accord.sealer.seal(data)
+	if err != nil {
+		return err
+	}
+	return accord.state.db.Put([]byte(componentDataPrefix+key), data, nil)
+}
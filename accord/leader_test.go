@@ -0,0 +1,48 @@
+package accord
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fixedElector struct {
+	Component
+	leader string
+}
+
+func (elector *fixedElector) IsLeader() bool { return elector.leader == "me" }
+func (elector *fixedElector) Leader() string { return elector.leader }
+
+func TestIsLeader(t *testing.T) {
+	accord := DummyAccord()
+	accord.NodeID = "me"
+
+	// On our own we're always the leader
+	assert.True(t, accord.IsLeader())
+	assert.Equal(t, "me", accord.Leader())
+
+	elector := &fixedElector{leader: "somebody else"}
+	accord.components = append(accord.components, elector)
+	assert.False(t, accord.IsLeader())
+	assert.Equal(t, "somebody else", accord.Leader())
+
+	elector.leader = "me"
+	assert.True(t, accord.IsLeader())
+}
+
+func TestComponentData(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	data, err := accord.LoadComponentData("test/key")
+	assert.Nil(t, err)
+	assert.Nil(t, data)
+
+	assert.Nil(t, accord.SaveComponentData("test/key", []byte("value")))
+	data, err = accord.LoadComponentData("test/key")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), data)
+}
@@ -0,0 +1,153 @@
+package accord
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Event kinds emitted as our peers come and go (see PeerTimeout)
+const (
+	EventPeerDown = "peer_down"
+	EventPeerUp   = "peer_up"
+)
+
+// DefaultPeerTimeout is how long we go without hearing from a peer before it's considered down, when
+// PeerTimeout isn't set
+const DefaultPeerTimeout = 30 * time.Second
+
+// PeerLiveness is whether we've heard from a peer recently
+type PeerLiveness struct {
+	Peer string `json:"peer"`
+
+	// LastSeen is when a transport last heard from the peer, which is zero if none has since we started
+	LastSeen time.Time `json:"last_seen"`
+
+	// Healthy means we've heard from the peer within our PeerTimeout (or that it hasn't been that long
+	// since we started)
+	Healthy bool `json:"healthy"`
+}
+
+// livenessTracker keeps track of when we last heard from each of our peers
+type livenessTracker struct {
+	mutex    sync.Mutex
+	started  time.Time
+	lastSeen map[string]time.Time
+
+	// down holds the peers we've emitted an EventPeerDown for and haven't heard from since
+	down map[string]bool
+}
+
+// peerTimeout returns how long we go without hearing from a peer before it's considered down
+func (accord *Accord) peerTimeout() time.Duration {
+	if accord.PeerTimeout <= 0 {
+		return DefaultPeerTimeout
+	}
+	return accord.PeerTimeout
+}
+
+// Heartbeat records that we just heard from a peer. Transports should call it whenever a peer gets in
+// touch with them or answers them, whether with Messages or just to say that it's still there, and
+// should get in touch with their peers often enough that it's called well within our PeerTimeout even
+// when there's nothing to synchronize
+func (accord *Accord) Heartbeat(peer string) {
+	accord.liveness.mutex.Lock()
+	if accord.liveness.lastSeen == nil {
+		accord.liveness.lastSeen = map[string]time.Time{}
+	}
+	accord.liveness.lastSeen[peer] = time.Now().UTC()
+	recovered := accord.liveness.down[peer]
+	delete(accord.liveness.down, peer)
+	accord.liveness.mutex.Unlock()
+
+	if recovered {
+		accord.Logger.WithField("peer", peer).Info("Peer is back")
+		accord.Emit(EventPeerUp, "Peer "+peer+" is back", map[string]interface{}{"peer": peer})
+	}
+}
+
+// PeerLiveness returns whether we've heard from each of our peers recently, sorted by name. Any peer a
+// transport has heard from is listed, along with every one we synchronize with
+func (accord *Accord) PeerLiveness() []PeerLiveness {
+	names := map[string]bool{}
+	for _, name := range accord.Peers() {
+		names[name] = true
+	}
+
+	accord.liveness.mutex.Lock()
+	defer accord.liveness.mutex.Unlock()
+
+	for name := range accord.liveness.lastSeen {
+		names[name] = true
+	}
+	now := time.Now()
+	liveness := []PeerLiveness{}
+	for name := range names {
+		liveness = append(liveness, accord.livenessOf(name, now))
+	}
+	sort.Slice(liveness, func(i, j int) bool { return liveness[i].Peer < liveness[j].Peer })
+	return liveness
+}
+
+// livenessOf returns a peer's liveness as of now. The caller must hold the liveness mutex
+func (accord *Accord) livenessOf(name string, now time.Time) PeerLiveness {
+	lastSeen := accord.liveness.lastSeen[name]
+	since := lastSeen
+	if since.Before(accord.liveness.started) {
+		since = accord.liveness.started
+	}
+	return PeerLiveness{Peer: name, LastSeen: lastSeen, Healthy: now.Sub(since) < accord.peerTimeout()}
+}
+
+// watchPeers checks on our peers' liveness a few times every PeerTimeout until we stop, emitting an
+// EventPeerDown for each one we stop hearing from
+func (accord *Accord) watchPeers() {
+	accord.liveness.mutex.Lock()
+	accord.liveness.started = time.Now()
+	accord.liveness.down = map[string]bool{}
+	accord.liveness.mutex.Unlock()
+
+	stopped := accord.stopContext.Done()
+	go func() {
+		ticker := time.NewTicker(accord.peerTimeout() / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				accord.checkPeers()
+			case <-stopped:
+				return
+			}
+		}
+	}()
+}
+
+// checkPeers emits an EventPeerDown for each peer that's gone quiet since we last checked
+func (accord *Accord) checkPeers() {
+	for _, peer := range accord.PeerLiveness() {
+		if peer.Healthy {
+			continue
+		}
+
+		// The peer may well have been heard from since we looked
+		accord.liveness.mutex.Lock()
+		peer = accord.livenessOf(peer.Peer, time.Now())
+		reported := accord.liveness.down[peer.Peer]
+		if !peer.Healthy {
+			accord.liveness.down[peer.Peer] = true
+		}
+		accord.liveness.mutex.Unlock()
+		if reported || peer.Healthy {
+			continue
+		}
+
+		fields := map[string]interface{}{"peer": peer.Peer}
+		log := accord.Logger.WithField("peer", peer.Peer)
+		if !peer.LastSeen.IsZero() {
+			fields["last_seen"] = peer.LastSeen
+			log = log.WithField("last_seen", peer.LastSeen)
+		}
+		log.Warn("We haven't heard from a peer in a while, so it's probably down")
+		accord.Emit(EventPeerDown, "Peer "+peer.Peer+" is down", fields)
+	}
+}
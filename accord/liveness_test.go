@@ -0,0 +1,72 @@
+package accord
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeerLiveness(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	accord.PeerTimeout = 30 * time.Millisecond
+	events := make(chan Event, 10)
+	accord.Subscribe(func(event Event) {
+		if event.Kind == EventPeerDown || event.Kind == EventPeerUp {
+			events <- event
+		}
+	})
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+	accord.AddPeer("quiet")
+	accord.AddPeer("chatty")
+
+	// Peers get the benefit of the doubt for a while after we start
+	liveness := accord.PeerLiveness()
+	assert.Len(t, liveness, 2)
+	assert.Equal(t, "chatty", liveness[0].Peer)
+	assert.True(t, liveness[0].LastSeen.IsZero())
+	assert.True(t, liveness[0].Healthy)
+	assert.Equal(t, HealthOK, accord.Health().Status)
+
+	// After that only the ones we've heard from are healthy
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(5 * time.Millisecond):
+				accord.Heartbeat("chatty")
+			}
+		}
+	}()
+
+	select {
+	case event := <-events:
+		assert.Equal(t, EventPeerDown, event.Kind)
+		assert.Equal(t, "quiet", event.Fields["peer"])
+	case <-time.After(2 * time.Second):
+		t.Fatal("the quiet peer was never reported down")
+	}
+	report := accord.Health()
+	assert.Equal(t, HealthDegraded, report.Status)
+	assert.Equal(t, "chatty", report.Peers[0].Peer)
+	assert.True(t, report.Peers[0].Healthy)
+	assert.False(t, report.Peers[0].LastSeen.IsZero())
+	assert.Equal(t, "quiet", report.Peers[1].Peer)
+	assert.False(t, report.Peers[1].Healthy)
+
+	// It's only reported once, until it's back
+	accord.Heartbeat("quiet")
+	select {
+	case event := <-events:
+		assert.Equal(t, EventPeerUp, event.Kind)
+		assert.Equal(t, "quiet", event.Fields["peer"])
+	case <-time.After(2 * time.Second):
+		t.Fatal("the quiet peer was never reported back")
+	}
+	assert.True(t, accord.PeerLiveness()[1].Healthy)
+}
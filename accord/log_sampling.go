@@ -0,0 +1,100 @@
+package accord
+
+import (
+	"sync"
+	"time"
+)
+
+// LogSampling thins out the Debug lines we log for every Message we handle ("Processing a remote message"
+// and the like), which drown out everything else once there are a lot of Messages. Each kind of line is
+// sampled separately: the first First of them in every Interval are logged, and after that only one in
+// every Thereafter. The line that is logged after some were dropped says how many with a "dropped" field.
+//
+// {Thereafter: 100} logs one line in a hundred, and {First: 1, Interval: time.Minute} the first of each
+// kind every minute. The zero value logs every line
+type LogSampling struct {
+	First      int
+	Thereafter int
+
+	// Interval is how often the count of each kind of line starts over, or zero for never
+	Interval time.Duration
+}
+
+// enabled reports whether any lines are sampled at all
+func (sampling LogSampling) enabled() bool {
+	return sampling.First > 0 || sampling.Thereafter > 0
+}
+
+// logSampler counts the lines we've logged of each kind, for our LogSampling
+type logSampler struct {
+	mutex  sync.Mutex
+	counts map[string]*sampleCount
+}
+
+// sampleCount is how many lines of a kind have come up since started, and how many of them were dropped
+// since one was last logged
+type sampleCount struct {
+	started time.Time
+	seen    int
+	dropped int
+}
+
+// SetLogSampling changes our LogSampling while we're running, starting every count over
+func (accord *Accord) SetLogSampling(sampling LogSampling) {
+	accord.logSampler.mutex.Lock()
+	defer accord.logSampler.mutex.Unlock()
+	accord.LogSampling = sampling
+	accord.logSampler.counts = nil
+}
+
+// sample decides whether a line should be logged under our LogSampling, returning how many lines of the
+// same kind were dropped since the last that was
+func (accord *Accord) sample(line string) (bool, int) {
+	sampler := &accord.logSampler
+	sampler.mutex.Lock()
+	defer sampler.mutex.Unlock()
+
+	sampling := accord.LogSampling
+	if !sampling.enabled() {
+		return true, 0
+	}
+	if sampler.counts == nil {
+		sampler.counts = map[string]*sampleCount{}
+	}
+	now := time.Now()
+	count, ok := sampler.counts[line]
+	if !ok || (sampling.Interval > 0 && now.Sub(count.started) >= sampling.Interval) {
+		dropped := 0
+		if ok {
+			dropped = count.dropped
+		}
+		count = &sampleCount{started: now, dropped: dropped}
+		sampler.counts[line] = count
+	}
+
+	count.seen++
+	logged := count.seen <= sampling.First
+	if !logged && sampling.Thereafter > 0 {
+		logged = (count.seen-sampling.First-1)%sampling.Thereafter == 0
+	}
+	if !logged {
+		count.dropped++
+		return false, 0
+	}
+	dropped := count.dropped
+	count.dropped = 0
+	return true, dropped
+}
+
+// SampledDebug logs a Debug line that's logged for every Message, unless our LogSampling drops it.
+// Components should log their own lines like that through it as well
+func (accord *Accord) SampledDebug(log Logger, line string) {
+	logged, dropped := accord.sample(line)
+	if !logged {
+		return
+	}
+	if dropped > 0 {
+		log = log.WithField("dropped", dropped)
+	}
+	log.Debug(line)
+}
@@ -0,0 +1,39 @@
+package accord
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogSampling(t *testing.T) {
+	accord := DummyAccord()
+	logged := func(count int, line string) []int {
+		dropped := []int{}
+		for i := 0; i < count; i++ {
+			if ok, n := accord.sample(line); ok {
+				dropped = append(dropped, n)
+			}
+		}
+		return dropped
+	}
+
+	// Everything is logged by default
+	assert.Equal(t, []int{0, 0, 0}, logged(3, "Processing a remote message"))
+
+	// One in every three, saying how many were dropped in between
+	accord.SetLogSampling(LogSampling{Thereafter: 3})
+	assert.Equal(t, []int{0, 2, 2}, logged(7, "Processing a remote message"))
+
+	// The first two, then one in five, with each kind of line counted separately
+	accord.SetLogSampling(LogSampling{First: 2, Thereafter: 5})
+	assert.Equal(t, []int{0, 0, 0, 4}, logged(8, "Processing a remote message"))
+	assert.Equal(t, []int{0, 0}, logged(2, "Processing a new message"))
+
+	// Only the first in every Interval
+	accord.SetLogSampling(LogSampling{First: 1, Interval: 10 * time.Millisecond})
+	assert.Equal(t, []int{0}, logged(5, "Processing a remote message"))
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, []int{4}, logged(5, "Processing a remote message"))
+}
@@ -0,0 +1,137 @@
+package accord
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// Logger is what we, and our Components, write our logs through. Fields added with WithField and
+// WithError are attached to everything logged through the Logger they return, leaving the one they were
+// called on as it was.
+//
+// It's deliberately small so that whatever an application already logs with can be adapted to it.
+// NewSlogLogger and NewZapLogger adapt the standard library's slog and zap, and the logging package adapts
+// logrus (which is kept out of this package, so that nobody has to build it who doesn't use it)
+type Logger interface {
+	WithField(key string, value interface{}) Logger
+	WithError(err error) Logger
+
+	Debug(args ...interface{})
+	Info(args ...interface{})
+	Warn(args ...interface{})
+	Error(args ...interface{})
+}
+
+// LogLevel is how much we log, from LogDebug (everything) up to LogError (only what went wrong)
+type LogLevel int
+
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogWarn
+	LogError
+)
+
+func (level LogLevel) String() string {
+	switch level {
+	case LogDebug:
+		return "debug"
+	case LogInfo:
+		return "info"
+	case LogWarn:
+		return "warn"
+	case LogError:
+		return "error"
+	}
+	return fmt.Sprintf("LogLevel(%d)", int(level))
+}
+
+// ParseLogLevel parses the name of a LogLevel: "debug", "info", "warn" (or "warning") or "error"
+func ParseLogLevel(name string) (LogLevel, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return LogDebug, nil
+	case "info":
+		return LogInfo, nil
+	case "warn", "warning":
+		return LogWarn, nil
+	case "error":
+		return LogError, nil
+	}
+	return 0, fmt.Errorf("not a valid log level: %q", name)
+}
+
+// LevelSetter is implemented by Loggers whose level can be changed while we're running (see SetLogLevel)
+type LevelSetter interface {
+	SetLevel(level LogLevel)
+}
+
+// NopLogger returns a Logger that throws away everything logged to it
+func NopLogger() Logger {
+	return nopLogger{}
+}
+
+type nopLogger struct{}
+
+func (logger nopLogger) WithField(string, interface{}) Logger { return logger }
+func (logger nopLogger) WithError(error) Logger               { return logger }
+func (logger nopLogger) Debug(...interface{})                 {}
+func (logger nopLogger) Info(...interface{})                  {}
+func (logger nopLogger) Warn(...interface{})                  {}
+func (logger nopLogger) Error(...interface{})                 {}
+
+// WithMessage adds the fields describing a Message to a log entry: "id" and "origin", along with "type",
+// "key", "channel", "correlation" and "causation" when it has them. Whatever's logged about a Message
+// should go through it, so that everything logged about one can be found by its ID, on every node
+func WithMessage(log Logger, msg *Message) Logger {
+	log = log.WithField("id", msg.ID).WithField("origin", msg.Origin)
+	if msg.Type != "" {
+		log = log.WithField("type", msg.Type)
+	}
+	if msg.Key != "" {
+		log = log.WithField("key", msg.Key)
+	}
+	if msg.Channel != "" {
+		log = log.WithField("channel", msg.Channel)
+	}
+	if msg.CorrelationID != "" {
+		log = log.WithField("correlation", msg.CorrelationID)
+	}
+	if msg.CausationID != 0 {
+		log = log.WithField("causation", msg.CausationID)
+	}
+	return log
+}
+
+// WithPeer adds the fields describing a peer's cursor to a log entry: "peer", "channel" unless it's the
+// default channel, and "queue_depth", how many Messages are still waiting to be sent to the peer on it
+func WithPeer(log Logger, cursor *PeerCursor) Logger {
+	log = log.WithField("peer", cursor.Name())
+	if cursor.ChannelName() != "" {
+		log = log.WithField("channel", cursor.ChannelName())
+	}
+	return log.WithField("queue_depth", cursor.Pending())
+}
+
+// LogValue has slog log a Message as a group of the same fields WithMessage adds, rather than every one of
+// its fields, Payload and all
+func (msg *Message) LogValue() slog.Value {
+	attrs := []slog.Attr{slog.Uint64("id", msg.ID), slog.String("origin", msg.Origin)}
+	if msg.Type != "" {
+		attrs = append(attrs, slog.String("type", msg.Type))
+	}
+	if msg.Key != "" {
+		attrs = append(attrs, slog.String("key", msg.Key))
+	}
+	if msg.Channel != "" {
+		attrs = append(attrs, slog.String("channel", msg.Channel))
+	}
+	if msg.CorrelationID != "" {
+		attrs = append(attrs, slog.String("correlation", msg.CorrelationID))
+	}
+	if msg.CausationID != 0 {
+		attrs = append(attrs, slog.Uint64("causation", msg.CausationID))
+	}
+	return slog.GroupValue(attrs...)
+}
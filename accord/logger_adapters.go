@@ -0,0 +1,106 @@
+package accord
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// SlogLogger adapts a logger from the standard library's log/slog to a Logger. Fields are added to its
+// records as attributes
+type SlogLogger struct {
+	logger *slog.Logger
+	level  *slog.LevelVar
+}
+
+// NewSlogLogger adapts logger. level, if it isn't nil, should be the LevelVar logger's handler was set up
+// with (see slog.HandlerOptions), which is what lets SetLogLevel change it
+func NewSlogLogger(logger *slog.Logger, level *slog.LevelVar) *SlogLogger {
+	return &SlogLogger{logger: logger, level: level}
+}
+
+// Logger returns the slog Logger we log to, fields and all
+func (logger *SlogLogger) Logger() *slog.Logger {
+	return logger.logger
+}
+
+func (logger *SlogLogger) WithField(key string, value interface{}) Logger {
+	return &SlogLogger{logger: logger.logger.With(key, value), level: logger.level}
+}
+
+func (logger *SlogLogger) WithError(err error) Logger {
+	return logger.WithField("error", err)
+}
+
+func (logger *SlogLogger) Debug(args ...interface{}) { logger.log(slog.LevelDebug, args) }
+func (logger *SlogLogger) Info(args ...interface{})  { logger.log(slog.LevelInfo, args) }
+func (logger *SlogLogger) Warn(args ...interface{})  { logger.log(slog.LevelWarn, args) }
+func (logger *SlogLogger) Error(args ...interface{}) { logger.log(slog.LevelError, args) }
+
+func (logger *SlogLogger) log(level slog.Level, args []interface{}) {
+	logger.logger.Log(context.Background(), level, fmt.Sprint(args...))
+}
+
+// SetLevel changes the level of our LevelVar, if we were given one
+func (logger *SlogLogger) SetLevel(level LogLevel) {
+	if logger.level == nil {
+		return
+	}
+	switch level {
+	case LogDebug:
+		logger.level.Set(slog.LevelDebug)
+	case LogInfo:
+		logger.level.Set(slog.LevelInfo)
+	case LogWarn:
+		logger.level.Set(slog.LevelWarn)
+	case LogError:
+		logger.level.Set(slog.LevelError)
+	}
+}
+
+// SugaredLogger is the part of zap's SugaredLogger (see zap.Logger.Sugar) we log through. We take it as
+// an interface so that nobody has to build zap who doesn't use it
+type SugaredLogger interface {
+	Debugw(msg string, keysAndValues ...interface{})
+	Infow(msg string, keysAndValues ...interface{})
+	Warnw(msg string, keysAndValues ...interface{})
+	Errorw(msg string, keysAndValues ...interface{})
+}
+
+// ZapLogger adapts a zap SugaredLogger to a Logger. Fields are added to its entries as key-value pairs.
+// Its level can't be changed through SetLogLevel, use the zap.AtomicLevel it was built with instead
+type ZapLogger struct {
+	logger SugaredLogger
+	fields []interface{}
+}
+
+// NewZapLogger adapts logger
+func NewZapLogger(logger SugaredLogger) *ZapLogger {
+	return &ZapLogger{logger: logger}
+}
+
+func (logger *ZapLogger) WithField(key string, value interface{}) Logger {
+	fields := make([]interface{}, len(logger.fields), len(logger.fields)+2)
+	copy(fields, logger.fields)
+	return &ZapLogger{logger: logger.logger, fields: append(fields, key, value)}
+}
+
+func (logger *ZapLogger) WithError(err error) Logger {
+	return logger.WithField("error", err)
+}
+
+func (logger *ZapLogger) Debug(args ...interface{}) {
+	logger.logger.Debugw(fmt.Sprint(args...), logger.fields...)
+}
+
+func (logger *ZapLogger) Info(args ...interface{}) {
+	logger.logger.Infow(fmt.Sprint(args...), logger.fields...)
+}
+
+func (logger *ZapLogger) Warn(args ...interface{}) {
+	logger.logger.Warnw(fmt.Sprint(args...), logger.fields...)
+}
+
+func (logger *ZapLogger) Error(args ...interface{}) {
+	logger.logger.Errorw(fmt.Sprint(args...), logger.fields...)
+}
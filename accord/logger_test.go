@@ -0,0 +1,122 @@
+package accord
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	for _, level := range []LogLevel{LogDebug, LogInfo, LogWarn, LogError} {
+		parsed, err := ParseLogLevel(level.String())
+		assert.Nil(t, err)
+		assert.Equal(t, level, parsed)
+	}
+	level, err := ParseLogLevel("WARNING")
+	assert.Nil(t, err)
+	assert.Equal(t, LogWarn, level)
+	_, err = ParseLogLevel("loud")
+	assert.NotNil(t, err)
+}
+
+func TestSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	level := &slog.LevelVar{}
+	var logger Logger = NewSlogLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: level})), level)
+
+	logger.WithField("id", 7).WithError(errors.New("boom")).Warn("Something ", "happened")
+	assert.Contains(t, buf.String(), `msg="Something happened"`)
+	assert.Contains(t, buf.String(), "id=7")
+	assert.Contains(t, buf.String(), "error=boom")
+
+	// Fields don't leak back into the Logger they were added to
+	buf.Reset()
+	logger.Info("Plain")
+	assert.NotContains(t, buf.String(), "id=7")
+
+	buf.Reset()
+	logger.Debug("Hidden")
+	assert.Empty(t, buf.String())
+	accord := DummyAccord()
+	accord.Logger = logger
+	accord.SetLogLevel(LogDebug)
+	logger.Debug("Shown")
+	assert.Contains(t, buf.String(), "Shown")
+}
+
+// sugaredRecorder records what's logged to it, the way zap's SugaredLogger would be called
+type sugaredRecorder struct {
+	lines []string
+}
+
+func (recorder *sugaredRecorder) record(level string, msg string, keysAndValues []interface{}) {
+	recorder.lines = append(recorder.lines, fmt.Sprint(level, " ", msg, keysAndValues))
+}
+
+func (recorder *sugaredRecorder) Debugw(msg string, kv ...interface{}) {
+	recorder.record("debug", msg, kv)
+}
+func (recorder *sugaredRecorder) Infow(msg string, kv ...interface{}) {
+	recorder.record("info", msg, kv)
+}
+func (recorder *sugaredRecorder) Warnw(msg string, kv ...interface{}) {
+	recorder.record("warn", msg, kv)
+}
+func (recorder *sugaredRecorder) Errorw(msg string, kv ...interface{}) {
+	recorder.record("error", msg, kv)
+}
+
+func TestZapLogger(t *testing.T) {
+	recorder := &sugaredRecorder{}
+	var logger Logger = NewZapLogger(recorder)
+
+	base := logger.WithField("component", "HTTPSync")
+	base.WithField("peer", "edge").Info("Synchronized")
+	base.WithError(errors.New("refused")).Error("Failed")
+	logger.Debug("Plain")
+	assert.Equal(t, []string{
+		"info Synchronized[component HTTPSync peer edge]",
+		"error Failed[component HTTPSync error refused]",
+		"debug Plain[]",
+	}, recorder.lines)
+}
+
+func TestStructuredFields(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+	peer := accord.AddPeer("edge")
+
+	var buf bytes.Buffer
+	logger := NewSlogLogger(slog.New(slog.NewJSONHandler(&buf, nil)), nil)
+	accord.Logger = logger
+
+	msg, _ := NewMessage([]byte("hello"))
+	msg.Type = "greeting"
+	assert.Nil(t, accord.HandleNewMessage(msg))
+
+	record := map[string]interface{}{}
+	WithPeer(WithMessage(logger, msg), peer).Info("Something happened")
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, float64(msg.ID), record["id"])
+	assert.Equal(t, msg.Origin, record["origin"])
+	assert.Equal(t, "greeting", record["type"])
+	assert.Equal(t, msg.CorrelationID, record["correlation"])
+	assert.Equal(t, "edge", record["peer"])
+	assert.Equal(t, float64(1), record["queue_depth"])
+	assert.NotContains(t, record, "key")
+	assert.NotContains(t, record, "channel")
+
+	// Messages logged through slog directly are logged as the same fields, without their Payload
+	buf.Reset()
+	logger.Logger().Info("Direct", "message", msg)
+	record = map[string]interface{}{}
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, map[string]interface{}{"id": float64(msg.ID), "origin": msg.Origin, "type": "greeting", "correlation": msg.CorrelationID}, record["message"])
+}
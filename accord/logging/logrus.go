@@ -0,0 +1,72 @@
+// Package logging adapts logrus to accord.Logger, for applications that log with it:
+//
+//	acc := accord.NewAccord(manager, components, "data", logging.NewLogrus(logrus.NewEntry(logrus.New())))
+//
+// It's kept apart from accord so that applications logging with something else (see accord.NewSlogLogger
+// and accord.NewZapLogger) don't have to build logrus
+package logging
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Ssawa/accord/accord"
+	"github.com/sirupsen/logrus"
+)
+
+// Logrus adapts a logrus Entry to an accord.Logger. Fields are added to it with its own WithField
+type Logrus struct {
+	entry *logrus.Entry
+}
+
+// NewLogrus adapts entry
+func NewLogrus(entry *logrus.Entry) *Logrus {
+	return &Logrus{entry: entry}
+}
+
+// Entry returns the logrus Entry we log to, fields and all
+func (logger *Logrus) Entry() *logrus.Entry {
+	return logger.entry
+}
+
+func (logger *Logrus) WithField(key string, value interface{}) accord.Logger {
+	return &Logrus{entry: logger.entry.WithField(key, value)}
+}
+
+func (logger *Logrus) WithError(err error) accord.Logger {
+	return &Logrus{entry: logger.entry.WithError(err)}
+}
+
+func (logger *Logrus) Debug(args ...interface{}) { logger.entry.Debug(args...) }
+func (logger *Logrus) Info(args ...interface{})  { logger.entry.Info(args...) }
+func (logger *Logrus) Warn(args ...interface{})  { logger.entry.Warn(args...) }
+func (logger *Logrus) Error(args ...interface{}) { logger.entry.Error(args...) }
+
+// SetLevel changes the level of the logrus Logger our Entry belongs to, which every other Entry made from
+// it shares
+func (logger *Logrus) SetLevel(level accord.LogLevel) {
+	logger.entry.Logger.SetLevel(LogrusLevel(level))
+}
+
+// String describes where our logs are going, for support bundles (see Accord.WriteSupportBundle)
+func (logger *Logrus) String() string {
+	out := logger.entry.Logger.Out
+	level := logger.entry.Logger.Level.String()
+	if file, ok := out.(*os.File); ok {
+		return fmt.Sprintf("%s (level %s)", file.Name(), level)
+	}
+	return fmt.Sprintf("%T (level %s)", out, level)
+}
+
+// LogrusLevel returns the logrus Level matching an accord.LogLevel
+func LogrusLevel(level accord.LogLevel) logrus.Level {
+	switch level {
+	case accord.LogDebug:
+		return logrus.DebugLevel
+	case accord.LogWarn:
+		return logrus.WarnLevel
+	case accord.LogError:
+		return logrus.ErrorLevel
+	}
+	return logrus.InfoLevel
+}
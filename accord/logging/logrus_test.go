@@ -0,0 +1,36 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/Ssawa/accord/accord"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogrus(t *testing.T) {
+	var buf bytes.Buffer
+	entry := logrus.New()
+	entry.Out = &buf
+	entry.Formatter = &logrus.TextFormatter{DisableColors: true}
+	var logger accord.Logger = NewLogrus(logrus.NewEntry(entry))
+
+	logger.WithField("id", 7).WithError(errors.New("boom")).Warn("Something happened")
+	assert.Contains(t, buf.String(), `msg="Something happened"`)
+	assert.Contains(t, buf.String(), "id=7")
+	assert.Contains(t, buf.String(), "error=boom")
+
+	buf.Reset()
+	logger.Debug("Hidden")
+	assert.Empty(t, buf.String())
+
+	// It's what SetLogLevel changes the level of
+	acc := accord.NewAccord(accord.NewDummerManager(), nil, "", logger)
+	acc.SetLogLevel(accord.LogDebug)
+	assert.Equal(t, logrus.DebugLevel, entry.Level)
+	logger.Debug("Shown")
+	assert.Contains(t, buf.String(), "Shown")
+	assert.Contains(t, logger.(*Logrus).String(), "level debug")
+}
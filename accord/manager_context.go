@@ -0,0 +1,88 @@
+package accord
+
+import (
+	"context"
+
+	"github.com/beeker1121/goque"
+)
+
+// ContextManager is a Manager that's handed a context along with each Message. The context carries the
+// span the Message is being processed under, for tracing whatever the Manager does in turn, and is
+// cancelled as soon as we start stopping, so that a Manager in the middle of long running database work
+// can give up on it rather than hold up our shutdown. Handing back an error because the context was
+// cancelled is treated like any other error from Process. It also records the Message itself (see
+// WithCause), so that Messages the Manager creates with it are correlated with the one that caused them.
+//
+// Accord calls ProcessContext and ShouldProcessContext in place of Process and ShouldProcess on any
+// Manager that has them. AdaptContextManager turns one that only has these into a Manager
+type ContextManager interface {
+	ProcessContext(ctx context.Context, msg *Message, fromRemote bool) error
+	ShouldProcessContext(ctx context.Context, msg Message, history *goque.Stack) bool
+}
+
+// AdaptContextManager turns a ContextManager into a Manager, which can then be passed to NewAccord, Handle
+// or Channels like any other. Process and ShouldProcess are only there to satisfy Manager, and call
+// through with a context that's never cancelled
+func AdaptContextManager(manager ContextManager) Manager {
+	return contextAdapter{manager}
+}
+
+type contextAdapter struct {
+	ContextManager
+}
+
+func (adapter contextAdapter) Process(msg *Message, fromRemote bool) error {
+	return adapter.ProcessContext(context.Background(), msg, fromRemote)
+}
+
+func (adapter contextAdapter) ShouldProcess(msg Message, history *goque.Stack) bool {
+	return adapter.ShouldProcessContext(context.Background(), msg, history)
+}
+
+// managerContext returns the context a Manager is called with, which is ctx cancelled once we start
+// stopping. The returned function must be called once the Manager is done with it
+func (accord *Accord) managerContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	if accord.stopContext == nil {
+		return ctx, cancel
+	}
+
+	stop := context.AfterFunc(accord.stopContext, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// waitForProcessing waits for any Message that's being processed to finish, queue and all, so that our
+// stores aren't closed out from under it
+func (accord *Accord) waitForProcessing() {
+	if accord.processMutex == nil {
+		return
+	}
+	accord.processMutex.Lock()
+	defer accord.processMutex.Unlock()
+	for _, shard := range accord.allShards() {
+		shard.mutex.Lock()
+		shard.mutex.Unlock()
+	}
+}
+
+// processingIdle reports whether no Message is being processed or queued right now, without waiting for one
+// that is
+func (accord *Accord) processingIdle() bool {
+	if accord.processMutex == nil {
+		return true
+	}
+	if !accord.processMutex.TryLock() {
+		return false
+	}
+	defer accord.processMutex.Unlock()
+	for _, shard := range accord.allShards() {
+		if !shard.mutex.TryLock() {
+			return false
+		}
+		shard.mutex.Unlock()
+	}
+	return true
+}
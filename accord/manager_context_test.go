@@ -0,0 +1,71 @@
+package accord
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/beeker1121/goque"
+	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// contextRecordingManager records the contexts it's called with. Once blocking is set, ProcessContext
+// signals it and then waits for its context to be cancelled
+type contextRecordingManager struct {
+	blocking  chan struct{}
+	processed []context.Context
+	checked   []context.Context
+}
+
+func (manager *contextRecordingManager) ProcessContext(ctx context.Context, msg *Message, fromRemote bool) error {
+	manager.processed = append(manager.processed, ctx)
+	if manager.blocking != nil {
+		close(manager.blocking)
+		<-ctx.Done()
+	}
+	return nil
+}
+
+func (manager *contextRecordingManager) ShouldProcessContext(ctx context.Context, msg Message, history *goque.Stack) bool {
+	manager.checked = append(manager.checked, ctx)
+	return true
+}
+
+func TestContextManager(t *testing.T) {
+	defer AccordCleanup()
+	manager := &contextRecordingManager{}
+	accord := DummyAccord()
+	accord.manager = AdaptContextManager(manager)
+	accord.Tracer = sdktrace.NewTracerProvider().Tracer("test")
+	assert.Nil(t, accord.Start())
+
+	msg, _ := NewMessage([]byte("local"))
+	assert.Nil(t, accord.HandleNewMessage(msg))
+	msg, _ = NewMessage([]byte("remote"))
+	msg.Origin = "remote"
+	assert.Nil(t, accord.HandleRemoteMessage(msg))
+	assert.Len(t, manager.processed, 2)
+	assert.Len(t, manager.checked, 1)
+
+	// The contexts carry the span the Message is processed under
+	assert.True(t, trace.SpanContextFromContext(manager.processed[0]).IsValid())
+	assert.True(t, trace.SpanContextFromContext(manager.checked[0]).IsValid())
+
+	// A Manager that's still busy when we stop is told to give up
+	manager.blocking = make(chan struct{})
+	done := make(chan error)
+	go func() {
+		msg, _ := NewMessage([]byte("slow"))
+		done <- accord.HandleNewMessage(msg)
+	}()
+	<-manager.blocking
+	go accord.Stop()
+	select {
+	case err := <-done:
+		assert.Nil(t, err)
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "the Manager's context was never cancelled")
+	}
+}
@@ -21,6 +21,56 @@ type Message struct {
 	// StateAt represents the state of the Message's originating Accord process when it was processed
 	StateAt uint64
 
+	// Type is an application defined label for what kind of operation this Message represents. Accord
+	// doesn't give it any meaning itself, but it lets Managers and tooling filter and route Messages
+	// without having to decode their payloads
+	Type string
+
+	// Origin is the NodeID of the Accord process that originally created this Message. It gets filled in
+	// automatically when the Message is first handled
+	Origin string
+
+	// Key identifies the entity (a user, a document, a configuration value...) the Message operates on, if
+	// any. Messages sharing a Key are the ones that can conflict with each other
+	Key string
+
+	// KeySeq numbers the Messages an origin creates for each Key, starting at 1. It's filled in
+	// automatically for Messages with a Key and lets the OrderPerKey ordering mode spot missing ones
+	KeySeq uint64
+
+	// IdempotencyKey identifies the operation the Message performs, for applications that may submit the
+	// same operation more than once (a client retrying a request, say). Once a Message with a given key
+	// has been delivered, any other with the same key is dropped for the next DedupWindow, so that it
+	// isn't applied twice even when an at-least-once transport redelivers it
+	IdempotencyKey string
+
+	// CorrelationID is shared by every Message in a chain of operations, which may span several nodes, so
+	// that the chain can be stitched back together in logs and traces. CausationID is the ID of the
+	// Message that directly caused this one, or zero if nothing did. Both are filled in automatically when
+	// the Message is first handled: one created while another is being processed (see WithCause) carries
+	// on that Message's chain, and any other starts a chain of its own, named after its ID
+	CorrelationID string
+	CausationID   uint64
+
+	// Channel is the name of the channel the Message is synchronized on (see Accord.Channels). Messages on
+	// different channels are queued separately and may be performed by different Managers. The default
+	// channel is named ""
+	Channel string
+
+	// Headers hold any application defined metadata that should travel along with the Message without
+	// being part of its payload. Like Type, Accord doesn't give them any meaning itself
+	Headers map[string]string
+
+	// Clock is the vector clock of the originating Accord process at the moment this Message was created
+	// (including the Message itself). Comparing it against the clocks of other Messages tells you whether
+	// they were created concurrently or one with the knowledge of the other
+	Clock VectorClock
+
+	// HLC is the hybrid logical clock timestamp the Message was created at. Unlike Timestamp it is
+	// guaranteed to be after every Message its originating process had already seen, which makes it
+	// suitable for ordering Messages across machines with skewed wall clocks
+	HLC HLCTimestamp
+
 	// The actual content of the message. Our system should make as little assumptions about this as possible
 	// and instead leave application specific logic to implementors
 	Payload []byte
@@ -0,0 +1,73 @@
+package accord
+
+import (
+	"time"
+)
+
+// Metrics is where we record numbers about what we're doing, so they can be graphed and alerted on. The
+// accord/metrics package ships implementations for Prometheus, statsd and expvar, but anything that can
+// take a named number will do. Implementations have to be safe to call from multiple goroutines.
+//
+// Names are plain snake_case like "messages_created"; it's up to each implementation to prefix or
+// otherwise adapt them to its backend's conventions
+type Metrics interface {
+	// Count adds delta to a counter
+	Count(name string, delta int64)
+
+	// Gauge sets a gauge to its current value
+	Gauge(name string, value float64)
+
+	// Timing records how long something took
+	Timing(name string, duration time.Duration)
+}
+
+// The names of the metrics Accord records itself
+const (
+	MetricMessagesCreated    = "messages_created"
+	MetricMessagesReceived   = "messages_received"
+	MetricMessagesApplied    = "messages_applied"
+	MetricMessagesSkipped    = "messages_skipped"
+	MetricMessagesRejected   = "messages_rejected"
+	MetricMessagesDuplicate  = "messages_duplicate"
+	MetricMessagesFiltered   = "messages_filtered"
+	MetricMessagesConflicted = "messages_conflicted"
+	MetricMessagesSent       = "messages_sent"
+	MetricDeadLetters        = "dead_letters"
+	MetricQueueFull          = "queue_full"
+	MetricQueueLength        = "queue_length"
+	MetricQueueBytes         = "queue_bytes"
+	MetricHeldBack           = "held_back"
+	MetricProcessTime        = "process_time"
+	MetricProcessTimeouts    = "process_timeouts"
+	MetricDataDirBytes       = "data_dir_bytes"
+	MetricChunksExpired      = "chunks_expired"
+	MetricClockDrift         = "clock_drift"
+)
+
+// noMetrics is what we record to when we haven't been given any Metrics
+type noMetrics struct{}
+
+func (noMetrics) Count(string, int64)          {}
+func (noMetrics) Gauge(string, float64)        {}
+func (noMetrics) Timing(string, time.Duration) {}
+
+// metrics returns where our metrics should be recorded
+func (accord *Accord) metrics() Metrics {
+	if accord.Metrics == nil {
+		return noMetrics{}
+	}
+	return accord.Metrics
+}
+
+// RecordSent records that Messages were sent to a peer. Transports should call it once the peer has
+// confirmed it received them
+func (accord *Accord) RecordSent(count int) {
+	accord.metrics().Count(MetricMessagesSent, int64(count))
+	accord.recordSync()
+}
+
+// recordQueue records how big our queue currently is
+func (accord *Accord) recordQueue() {
+	accord.metrics().Gauge(MetricQueueLength, float64(accord.QueuedMessages()))
+	accord.metrics().Gauge(MetricQueueBytes, float64(accord.QueuedBytes()))
+}
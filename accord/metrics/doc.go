@@ -0,0 +1,9 @@
+// Package metrics ships implementations of accord.Metrics for the monitoring systems people most often
+// run: Prometheus, statsd and Go's own expvar. Set one as an Accord's Metrics before starting it:
+//
+//	acc := accord.NewAccord(manager, components, "data", logger)
+//	acc.Metrics = metrics.NewPrometheus("accord", nil)
+//
+// The names Accord records under are listed alongside accord.Metrics (MetricMessagesCreated and friends).
+// Components are free to record their own as well
+package metrics
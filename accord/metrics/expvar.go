@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"expvar"
+	"time"
+)
+
+// Expvar records metrics into an expvar.Map, which the standard library serves as JSON on
+// "/debug/vars" of the default HTTP mux. Counters and gauges are recorded under their own names, and each
+// timing as a pair of "<name>_count" and "<name>_seconds" totals
+type Expvar struct {
+	vars *expvar.Map
+}
+
+// NewExpvar publishes a map with the given name (like "accord") and records into it. Asking for a name
+// that's already published reuses that map, as expvar doesn't let anything be unpublished
+func NewExpvar(name string) *Expvar {
+	if existing, ok := expvar.Get(name).(*expvar.Map); ok {
+		return &Expvar{vars: existing}
+	}
+	return &Expvar{vars: expvar.NewMap(name)}
+}
+
+// Map returns the map we're recording into
+func (metrics *Expvar) Map() *expvar.Map {
+	return metrics.vars
+}
+
+// Count adds delta to a counter
+func (metrics *Expvar) Count(name string, delta int64) {
+	metrics.vars.Add(name, delta)
+}
+
+// Gauge sets a gauge to its current value
+func (metrics *Expvar) Gauge(name string, value float64) {
+	gauge, ok := metrics.vars.Get(name).(*expvar.Float)
+	if !ok {
+		gauge = new(expvar.Float)
+		metrics.vars.Set(name, gauge)
+	}
+	gauge.Set(value)
+}
+
+// Timing records how long something took
+func (metrics *Expvar) Timing(name string, duration time.Duration) {
+	metrics.vars.Add(name+"_count", 1)
+	metrics.vars.AddFloat(name+"_seconds", duration.Seconds())
+}
@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ssawa/accord/accord"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+// They all have to be usable as accord.Metrics
+var _ = []accord.Metrics{&Expvar{}, &Statsd{}, &Prometheus{}}
+
+func TestExpvar(t *testing.T) {
+	metrics := NewExpvar("accord_test")
+	metrics.Count(accord.MetricMessagesCreated, 2)
+	metrics.Count(accord.MetricMessagesCreated, 1)
+	metrics.Gauge(accord.MetricQueueLength, 7)
+	metrics.Timing(accord.MetricProcessTime, 1500*time.Millisecond)
+
+	vars := metrics.Map()
+	assert.Equal(t, "3", vars.Get(accord.MetricMessagesCreated).String())
+	assert.Equal(t, "7", vars.Get(accord.MetricQueueLength).String())
+	assert.Equal(t, "1", vars.Get("process_time_count").String())
+	assert.Equal(t, "1.5", vars.Get("process_time_seconds").String())
+
+	assert.Equal(t, vars, NewExpvar("accord_test").Map())
+}
+
+func TestStatsd(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer server.Close()
+
+	metrics, err := NewStatsd(server.LocalAddr().String(), "accord")
+	assert.Nil(t, err)
+	defer metrics.Close()
+
+	metrics.Count(accord.MetricMessagesCreated, 2)
+	metrics.Gauge(accord.MetricQueueLength, 7)
+	metrics.Timing(accord.MetricProcessTime, 1500*time.Microsecond)
+
+	received := []string{}
+	buf := make([]byte, 512)
+	server.SetReadDeadline(time.Now().Add(time.Second))
+	for len(received) < 3 {
+		n, _, err := server.ReadFrom(buf)
+		if !assert.Nil(t, err) {
+			return
+		}
+		received = append(received, string(buf[:n]))
+	}
+	assert.Equal(t, []string{"accord.messages_created:2|c", "accord.queue_length:7|g", "accord.process_time:1.5|ms"}, received)
+}
+
+func TestPrometheus(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics := NewPrometheus("accord", registry)
+	metrics.Count(accord.MetricMessagesCreated, 2)
+	metrics.Count(accord.MetricMessagesCreated, 1)
+	metrics.Gauge(accord.MetricQueueLength, 7)
+	metrics.Timing(accord.MetricProcessTime, 1500*time.Millisecond)
+
+	// A second one sharing the registry records into the same collectors
+	NewPrometheus("accord", registry).Count(accord.MetricMessagesCreated, 1)
+
+	families, err := registry.Gather()
+	assert.Nil(t, err)
+	values := map[string]float64{}
+	names := []string{}
+	for _, family := range families {
+		names = append(names, family.GetName())
+		metric := family.GetMetric()[0]
+		switch {
+		case metric.Counter != nil:
+			values[family.GetName()] = metric.GetCounter().GetValue()
+		case metric.Gauge != nil:
+			values[family.GetName()] = metric.GetGauge().GetValue()
+		case metric.Histogram != nil:
+			values[family.GetName()] = metric.GetHistogram().GetSampleSum()
+		}
+	}
+	sort.Strings(names)
+	assert.Equal(t, "accord_messages_created_total accord_process_time_seconds accord_queue_length", strings.Join(names, " "))
+	assert.Equal(t, 4.0, values["accord_messages_created_total"])
+	assert.Equal(t, 7.0, values["accord_queue_length"])
+	assert.Equal(t, 1.5, values["accord_process_time_seconds"])
+}
@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Prometheus records metrics as Prometheus collectors, created the first time each name is recorded.
+// Counters are named "<namespace>_<name>_total", gauges "<namespace>_<name>" and timings are histograms
+// named "<namespace>_<name>_seconds". Serve them the usual way, with promhttp
+type Prometheus struct {
+	namespace  string
+	registerer prometheus.Registerer
+
+	mutex      sync.Mutex
+	counters   map[string]prometheus.Counter
+	gauges     map[string]prometheus.Gauge
+	histograms map[string]prometheus.Histogram
+}
+
+// NewPrometheus records metrics under namespace (like "accord") into registerer, which defaults to
+// prometheus.DefaultRegisterer when it's nil
+func NewPrometheus(namespace string, registerer prometheus.Registerer) *Prometheus {
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+	return &Prometheus{
+		namespace:  namespace,
+		registerer: registerer,
+		counters:   map[string]prometheus.Counter{},
+		gauges:     map[string]prometheus.Gauge{},
+		histograms: map[string]prometheus.Histogram{},
+	}
+}
+
+// Count adds delta to a counter. Prometheus counters can't go down, so negative deltas are ignored
+func (metrics *Prometheus) Count(name string, delta int64) {
+	if delta < 0 {
+		return
+	}
+
+	metrics.mutex.Lock()
+	counter, ok := metrics.counters[name]
+	if !ok {
+		counter = metrics.register(prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metrics.namespace,
+			Name:      name + "_total",
+			Help:      "Accord's " + name + " counter",
+		})).(prometheus.Counter)
+		metrics.counters[name] = counter
+	}
+	metrics.mutex.Unlock()
+
+	counter.Add(float64(delta))
+}
+
+// Gauge sets a gauge to its current value
+func (metrics *Prometheus) Gauge(name string, value float64) {
+	metrics.mutex.Lock()
+	gauge, ok := metrics.gauges[name]
+	if !ok {
+		gauge = metrics.register(prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metrics.namespace,
+			Name:      name,
+			Help:      "Accord's " + name + " gauge",
+		})).(prometheus.Gauge)
+		metrics.gauges[name] = gauge
+	}
+	metrics.mutex.Unlock()
+
+	gauge.Set(value)
+}
+
+// Timing records how long something took
+func (metrics *Prometheus) Timing(name string, duration time.Duration) {
+	metrics.mutex.Lock()
+	histogram, ok := metrics.histograms[name]
+	if !ok {
+		histogram = metrics.register(prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metrics.namespace,
+			Name:      name + "_seconds",
+			Help:      "How long Accord's " + name + " took",
+			Buckets:   prometheus.DefBuckets,
+		})).(prometheus.Histogram)
+		metrics.histograms[name] = histogram
+	}
+	metrics.mutex.Unlock()
+
+	histogram.Observe(duration.Seconds())
+}
+
+// register registers a new collector, falling back to the one that's already registered if another
+// Prometheus with the same namespace got there first. Should registering fail for any other reason, the
+// collector still works, it just isn't exported anywhere
+func (metrics *Prometheus) register(collector prometheus.Collector) prometheus.Collector {
+	err := metrics.registerer.Register(collector)
+	if existing, ok := err.(prometheus.AlreadyRegisteredError); ok {
+		return existing.ExistingCollector
+	}
+	return collector
+}
@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// Statsd sends metrics to a statsd server over UDP. Like every statsd client it fires and forgets, so a
+// statsd server that's gone away never slows us down; we just lose the numbers
+type Statsd struct {
+	conn   net.Conn
+	prefix string
+}
+
+// NewStatsd sends metrics to the statsd server at address (like "127.0.0.1:8125"), prefixing their names
+// with prefix and a dot (like "accord.messages_created"). An empty prefix leaves the names alone
+func NewStatsd(address string, prefix string) (*Statsd, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	if prefix != "" {
+		prefix += "."
+	}
+	return &Statsd{conn: conn, prefix: prefix}, nil
+}
+
+// Count adds delta to a counter
+func (metrics *Statsd) Count(name string, delta int64) {
+	metrics.send(name, fmt.Sprintf("%d|c", delta))
+}
+
+// Gauge sets a gauge to its current value
+func (metrics *Statsd) Gauge(name string, value float64) {
+	metrics.send(name, fmt.Sprintf("%g|g", value))
+}
+
+// Timing records how long something took, in milliseconds as statsd expects
+func (metrics *Statsd) Timing(name string, duration time.Duration) {
+	metrics.send(name, fmt.Sprintf("%g|ms", float64(duration)/float64(time.Millisecond)))
+}
+
+// Close closes our connection to the statsd server
+func (metrics *Statsd) Close() error {
+	return metrics.conn.Close()
+}
+
+func (metrics *Statsd) send(name string, value string) {
+	metrics.conn.Write([]byte(metrics.prefix + name + ":" + value))
+}
@@ -0,0 +1,68 @@
+package accord
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordedMetrics keeps whatever's recorded to it
+type recordedMetrics struct {
+	mutex   sync.Mutex
+	counts  map[string]int64
+	gauges  map[string]float64
+	timings map[string]int
+}
+
+func newRecordedMetrics() *recordedMetrics {
+	return &recordedMetrics{counts: map[string]int64{}, gauges: map[string]float64{}, timings: map[string]int{}}
+}
+
+func (metrics *recordedMetrics) Count(name string, delta int64) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	metrics.counts[name] += delta
+}
+
+func (metrics *recordedMetrics) Gauge(name string, value float64) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	metrics.gauges[name] = value
+}
+
+func (metrics *recordedMetrics) Timing(name string, duration time.Duration) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	metrics.timings[name]++
+}
+
+func TestMetrics(t *testing.T) {
+	defer AccordCleanup()
+	metrics := newRecordedMetrics()
+	accord := DummyAccord()
+	accord.NodeID = "local"
+	accord.Metrics = metrics
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	peer := accord.AddPeer("remote")
+	for _, payload := range []string{"one", "two"} {
+		msg, _ := NewMessage([]byte(payload))
+		assert.Nil(t, accord.HandleNewMessage(msg))
+	}
+	remote, _ := NewMessage([]byte("three"))
+	remote.Origin = "remote"
+	assert.Nil(t, accord.HandleRemoteMessage(remote))
+	assert.Nil(t, peer.Advance(1))
+	assert.Nil(t, peer.DeadLetter(1, "refused"))
+
+	assert.Equal(t, int64(2), metrics.counts[MetricMessagesCreated])
+	assert.Equal(t, int64(1), metrics.counts[MetricMessagesReceived])
+	assert.Equal(t, int64(3), metrics.counts[MetricMessagesApplied])
+	assert.Equal(t, int64(1), metrics.counts[MetricDeadLetters])
+	assert.Equal(t, 3, metrics.timings[MetricProcessTime])
+	assert.Equal(t, 0.0, metrics.gauges[MetricQueueLength])
+	assert.Equal(t, 0.0, metrics.gauges[MetricQueueBytes])
+}
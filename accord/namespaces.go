@@ -0,0 +1,214 @@
+package accord
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrUnknownNamespace is returned for a namespace that hasn't been added
+var ErrUnknownNamespace = errors.New("no namespace with that name has been added")
+
+// ErrNamespaceExists is returned by Namespaces.Add for a name that's already taken
+var ErrNamespaceExists = errors.New("a namespace with that name already exists")
+
+// ErrInvalidNamespace is returned by Namespaces.Add for a name that can't be used as a directory name
+var ErrInvalidNamespace = errors.New("namespace names can't be empty or contain path separators")
+
+// Namespaces runs any number of isolated Accord instances in one process, one per tenant of a SaaS host
+// for instance, rather than needing a process of their own each. Every namespace has its own Manager,
+// Components, queues, history and state, kept in a directory of its own under our data directory, and
+// knows nothing about the others. A Component failing or calling Shutdown only brings down its own
+// namespace.
+//
+// Transports can be shared between namespaces by serving their endpoints from one server (see
+// components.NamespaceMux), so that the namespaces of two hosts synchronize with each other over a single
+// port
+type Namespaces struct {
+	// Logger is what each namespace logs to, with the namespace's name added
+	Logger Logger
+
+	dataDir string
+	mutex   sync.Mutex
+	started bool
+	accords map[string]*Accord
+
+	// listening holds a channel for each running namespace that's closed once it has stopped
+	listening map[string]chan struct{}
+}
+
+// NewNamespaces creates a set of namespaces kept under dataDir
+func NewNamespaces(dataDir string, logger Logger) *Namespaces {
+	return &Namespaces{
+		Logger:    logger,
+		dataDir:   dataDir,
+		accords:   map[string]*Accord{},
+		listening: map[string]chan struct{}{},
+	}
+}
+
+// Add creates a new namespace. configure, if it isn't nil, is called with the namespace's Accord before
+// it's started, which is the place to set its NodeID, Channels and so on. If we're already running the
+// namespace is started straight away
+func (namespaces *Namespaces) Add(name string, manager Manager, components []Component, configure func(*Accord)) (*Accord, error) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return nil, ErrInvalidNamespace
+	}
+
+	namespaces.mutex.Lock()
+	defer namespaces.mutex.Unlock()
+
+	if _, ok := namespaces.accords[name]; ok {
+		return nil, ErrNamespaceExists
+	}
+
+	dir := filepath.Join(namespaces.dataDir, name)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	accord := NewAccord(manager, components, dir, namespaces.Logger.WithField("namespace", name))
+	if configure != nil {
+		configure(accord)
+	}
+
+	if namespaces.started {
+		err = namespaces.start(name, accord)
+		if err != nil {
+			return nil, err
+		}
+	}
+	namespaces.accords[name] = accord
+	return accord, nil
+}
+
+// Get returns a namespace's Accord, or nil if there's no such namespace
+func (namespaces *Namespaces) Get(name string) *Accord {
+	namespaces.mutex.Lock()
+	defer namespaces.mutex.Unlock()
+	return namespaces.accords[name]
+}
+
+// Names returns the names of all of our namespaces, sorted
+func (namespaces *Namespaces) Names() []string {
+	namespaces.mutex.Lock()
+	defer namespaces.mutex.Unlock()
+
+	names := []string{}
+	for name := range namespaces.accords {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Remove stops a namespace, if it's running, and forgets about it. Its data is left where it is, so adding
+// it again later picks up where it left off
+func (namespaces *Namespaces) Remove(name string) error {
+	namespaces.mutex.Lock()
+	defer namespaces.mutex.Unlock()
+
+	if _, ok := namespaces.accords[name]; !ok {
+		return ErrUnknownNamespace
+	}
+	namespaces.stop(name)
+	delete(namespaces.accords, name)
+	return nil
+}
+
+// Start starts every one of our namespaces. If one of them can't be started, the ones that were are stopped
+// again and its error is returned
+func (namespaces *Namespaces) Start() error {
+	namespaces.mutex.Lock()
+	defer namespaces.mutex.Unlock()
+
+	names := []string{}
+	for name := range namespaces.accords {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		err := namespaces.start(name, namespaces.accords[name])
+		if err != nil {
+			for name := range namespaces.listening {
+				namespaces.stop(name)
+			}
+			return err
+		}
+	}
+	namespaces.started = true
+	return nil
+}
+
+// Stop stops every one of our namespaces and waits for them to finish
+func (namespaces *Namespaces) Stop() {
+	namespaces.mutex.Lock()
+	defer namespaces.mutex.Unlock()
+
+	for name := range namespaces.listening {
+		namespaces.stop(name)
+	}
+	namespaces.started = false
+}
+
+// Listen hangs until one of the given signals comes in, and then stops every one of our namespaces
+func (namespaces *Namespaces) Listen(signals ...os.Signal) {
+	signalChannel := make(chan os.Signal, 1)
+	signal.Notify(signalChannel, signals...)
+	defer signal.Stop(signalChannel)
+
+	<-signalChannel
+	namespaces.Logger.Info("Received OS signal")
+	namespaces.Stop()
+}
+
+// start starts a namespace, listening for it to be shut down in the background. The caller must hold our
+// mutex
+func (namespaces *Namespaces) start(name string, accord *Accord) error {
+	err := accord.Start()
+	if err != nil {
+		return err
+	}
+
+	// Listen is what stops a namespace whose Components have called Shutdown, so every namespace needs one
+	// of its own
+	done := make(chan struct{})
+	namespaces.listening[name] = done
+	go func() {
+		defer close(done)
+		err := accord.Listen()
+		if err != nil {
+			namespaces.Logger.WithError(err).WithField("namespace", name).Warn("Namespace was shut down")
+		}
+	}()
+	return nil
+}
+
+// stop stops a namespace, if it's running, and waits for it to finish. The caller must hold our mutex
+func (namespaces *Namespaces) stop(name string) {
+	done, ok := namespaces.listening[name]
+	if !ok {
+		return
+	}
+	delete(namespaces.listening, name)
+
+	// A namespace that was shut down has already stopped itself
+	select {
+	case <-done:
+		return
+	default:
+	}
+
+	// Listen stops the namespace once it's sent a signal, just as it would for the real thing
+	select {
+	case namespaces.accords[name].signalChannel <- os.Interrupt:
+	default:
+	}
+	<-done
+}
@@ -0,0 +1,66 @@
+package accord
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamespaces(t *testing.T) {
+	dir, err := ioutil.TempDir("", "accord-namespaces")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	namespaces := NewNamespaces(dir, DummyAccord().Logger)
+	_, err = namespaces.Add("../escape", NewDummerManager(), nil, nil)
+	assert.Equal(t, ErrInvalidNamespace, err)
+
+	shutdown := &shutdownComponent{}
+	a, err := namespaces.Add("a", NewDummerManager(), []Component{shutdown}, func(acc *Accord) { acc.NodeID = "host" })
+	assert.Nil(t, err)
+	_, err = namespaces.Add("a", NewDummerManager(), nil, nil)
+	assert.Equal(t, ErrNamespaceExists, err)
+	assert.Nil(t, namespaces.Start())
+
+	// Namespaces added once we're running are started straight away
+	b, err := namespaces.Add("b", NewDummerManager(), nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b"}, namespaces.Names())
+	assert.Equal(t, b, namespaces.Get("b"))
+
+	// Each namespace keeps its own data
+	msg, _ := NewMessage([]byte("tenant a"))
+	assert.Nil(t, a.HandleNewMessage(msg))
+	assert.Equal(t, uint64(1), a.History().Len())
+	assert.Equal(t, uint64(0), b.History().Len())
+	_, err = os.Stat(filepath.Join(dir, "a", HistoryFilename))
+	assert.Nil(t, err)
+
+	// One namespace shutting down leaves the others running
+	shutdown.accord.Shutdown(errors.New("tenant a failed"))
+	<-namespaces.listening["a"]
+	msg, _ = NewMessage([]byte("tenant b"))
+	assert.Nil(t, b.HandleNewMessage(msg))
+
+	assert.Nil(t, namespaces.Remove("b"))
+	assert.Equal(t, ErrUnknownNamespace, namespaces.Remove("b"))
+	assert.Nil(t, namespaces.Get("b"))
+	namespaces.Stop()
+}
+
+// shutdownComponent hangs on to the Accord it's started with, so that tests can shut it down
+type shutdownComponent struct {
+	ComponentRunner
+	accord *Accord
+}
+
+func (comp *shutdownComponent) Start(acc *Accord) error {
+	comp.accord = acc
+	comp.ComponentRunner.Init(acc, func(*Accord) { time.Sleep(10 * time.Millisecond) }, nil, acc.Logger)
+	return nil
+}
@@ -0,0 +1,185 @@
+package accord
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// DefaultMaxHeldBack is how many out of order Messages we'll hold on to by default while waiting for the
+// ones they depend on
+const DefaultMaxHeldBack = 10000
+
+// ErrHoldBackFull is returned by HandleRemoteMessage when a Message has to be held back to preserve our
+// ordering guarantee but we're already holding back as many as we're allowed to. The Message is dropped, so
+// whoever sent it should try again later (anti-entropy will eventually catch it either way)
+var ErrHoldBackFull = errors.New("too many messages are being held back waiting for earlier ones")
+
+// OrderingMode is the guarantee we make about the order remote Messages get applied in. Locally created
+// Messages are always applied in the order they're handled; it's remote ones, which can arrive late, twice
+// or out of order depending on the transport, that the mode is about.
+//
+// Messages that arrive ahead of the ones they depend on are held back in memory and applied as soon as the
+// gap is filled. Held back Messages aren't persisted, so if we stop before the gap is filled they're lost
+// and have to be sent again, which anti-entropy takes care of. Every node in a cluster should use the same
+// mode; mixing them works but only gives you the weakest guarantee of the bunch
+type OrderingMode int
+
+const (
+	// OrderNone applies remote Messages as soon as they arrive, in whatever order that is. This is the
+	// cheapest mode, and the right one when your Manager is order insensitive (CRDTs, for instance) or
+	// resolves conflicts itself. It's the default
+	OrderNone OrderingMode = iota
+
+	// OrderFIFO applies the Messages from each origin in exactly the order that origin created them, with no
+	// gaps and no duplicates. Messages from different origins can still interleave in any order; a single
+	// global order across every node needs a single writer, such as a leader
+	OrderFIFO
+
+	// OrderCausal is OrderFIFO plus a guarantee that a Message is only applied after every Message its
+	// origin had seen when creating it, according to its vector clock. Concurrent Messages can still be
+	// applied in different orders on different nodes
+	OrderCausal
+
+	// OrderPerKey applies the Messages each origin creates for a Key in the order it created them, without
+	// making any guarantees across keys. Independent keys never wait on each other, so a gap in one doesn't
+	// hold up the rest. Messages without a Key are applied as soon as they arrive
+	OrderPerKey
+)
+
+// String returns a readable name for the mode
+func (mode OrderingMode) String() string {
+	switch mode {
+	case OrderNone:
+		return "none"
+	case OrderFIFO:
+		return "fifo"
+	case OrderCausal:
+		return "causal"
+	case OrderPerKey:
+		return "per-key"
+	default:
+		return fmt.Sprintf("OrderingMode(%d)", int(mode))
+	}
+}
+
+// orderCheck is what our ordering mode has to say about a remote Message
+type orderCheck int
+
+const (
+	orderReady orderCheck = iota
+	orderWait
+	orderDuplicate
+)
+
+// checkOrder determines whether a remote Message can be applied yet under our ordering mode
+func (accord *Accord) checkOrder(msg *Message) (orderCheck, error) {
+	switch accord.Ordering {
+	case OrderFIFO, OrderCausal:
+		seq := msg.Clock[msg.Origin]
+		// Messages without a clock predate ordering and can't be placed, so there's nothing to enforce
+		if seq == 0 {
+			return orderReady, nil
+		}
+
+		delivered := accord.state.Delivered(msg.Origin)
+		if seq <= delivered {
+			return orderDuplicate, nil
+		}
+		if seq > delivered+1 {
+			return orderWait, nil
+		}
+
+		if accord.Ordering == OrderCausal {
+			for node, count := range msg.Clock {
+				if node != msg.Origin && count > accord.state.Delivered(node) {
+					return orderWait, nil
+				}
+			}
+		}
+		return orderReady, nil
+
+	case OrderPerKey:
+		if msg.Key == "" || msg.KeySeq == 0 {
+			return orderReady, nil
+		}
+
+		seq, err := accord.state.KeySeq(msg.Origin, msg.Key)
+		if err != nil {
+			return orderReady, err
+		}
+		if msg.KeySeq <= seq {
+			return orderDuplicate, nil
+		}
+		if msg.KeySeq > seq+1 {
+			return orderWait, nil
+		}
+		return orderReady, nil
+
+	default:
+		return orderReady, nil
+	}
+}
+
+// holdBack keeps a Message around until the ones it depends on arrive
+func (accord *Accord) holdBack(msg *Message) error {
+	max := accord.MaxHeldBack
+	if max <= 0 {
+		max = DefaultMaxHeldBack
+	}
+
+	if _, ok := accord.heldBack[msg.ID]; ok {
+		return nil
+	}
+	if len(accord.heldBack) >= max {
+		return ErrHoldBackFull
+	}
+
+	accord.heldBack[msg.ID] = msg
+	accord.metrics().Gauge(MetricHeldBack, float64(len(accord.heldBack)))
+	return nil
+}
+
+// releaseHeldBack applies every held back Message that has become ready, repeating until none are left,
+// since each one we apply can unblock others
+func (accord *Accord) releaseHeldBack() error {
+	for released := true; released; {
+		released = false
+
+		for id, msg := range accord.heldBack {
+			check, err := accord.checkOrder(msg)
+			if err != nil {
+				return err
+			}
+			if check == orderWait {
+				continue
+			}
+
+			delete(accord.heldBack, id)
+			accord.metrics().Gauge(MetricHeldBack, float64(len(accord.heldBack)))
+			if check == orderDuplicate {
+				continue
+			}
+
+			released = true
+			err = accord.deliverRemote(MessageContext(context.Background(), msg), msg)
+			if err != nil {
+				// Whoever sent this Message is long gone, so a rejection is only worth a log line. Anything
+				// else has already shut us down
+				if _, ok := err.(*StageError); ok {
+					continue
+				}
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// HeldBack returns how many remote Messages are currently being held back waiting for earlier ones
+func (accord *Accord) HeldBack() int {
+	accord.processMutex.Lock()
+	defer accord.processMutex.Unlock()
+	return len(accord.heldBack)
+}
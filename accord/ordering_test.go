@@ -0,0 +1,127 @@
+package accord
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func orderingTestAccord(t *testing.T, mode OrderingMode) (*Accord, *recordingManager) {
+	manager := &recordingManager{}
+	accord := DummyAccord()
+	accord.manager = manager
+	accord.NodeID = "local"
+	accord.Ordering = mode
+	assert.Nil(t, accord.Start())
+	return accord, manager
+}
+
+func processedIDs(manager *recordingManager) []uint64 {
+	ids := []uint64{}
+	for _, msg := range manager.processed {
+		ids = append(ids, msg.ID)
+	}
+	return ids
+}
+
+func TestOrderFIFO(t *testing.T) {
+	defer AccordCleanup()
+	accord, manager := orderingTestAccord(t, OrderFIFO)
+	defer accord.Stop()
+
+	first := &Message{ID: 1, Origin: "a", Clock: VectorClock{"a": 1}}
+	second := &Message{ID: 2, Origin: "a", Clock: VectorClock{"a": 2}}
+	third := &Message{ID: 3, Origin: "a", Clock: VectorClock{"a": 3}}
+
+	assert.Nil(t, accord.HandleRemoteMessage(third))
+	assert.Nil(t, accord.HandleRemoteMessage(second))
+	assert.Equal(t, 2, accord.HeldBack())
+	assert.Empty(t, manager.processed)
+
+	assert.Nil(t, accord.HandleRemoteMessage(first))
+	assert.Equal(t, 0, accord.HeldBack())
+	assert.Equal(t, []uint64{1, 2, 3}, processedIDs(manager))
+
+	// Redelivering an old message should be a no-op
+	assert.Nil(t, accord.HandleRemoteMessage(second))
+	assert.Equal(t, []uint64{1, 2, 3}, processedIDs(manager))
+}
+
+func TestOrderCausal(t *testing.T) {
+	defer AccordCleanup()
+	accord, manager := orderingTestAccord(t, OrderCausal)
+	defer accord.Stop()
+
+	// b's message was created after it had seen a's, so it needs to wait for it even though it's the first
+	// message from b
+	fromA := &Message{ID: 1, Origin: "a", Clock: VectorClock{"a": 1}}
+	fromB := &Message{ID: 2, Origin: "b", Clock: VectorClock{"a": 1, "b": 1}}
+
+	assert.Nil(t, accord.HandleRemoteMessage(fromB))
+	assert.Empty(t, manager.processed)
+
+	assert.Nil(t, accord.HandleRemoteMessage(fromA))
+	assert.Equal(t, []uint64{1, 2}, processedIDs(manager))
+}
+
+func TestOrderPerKey(t *testing.T) {
+	defer AccordCleanup()
+	accord, manager := orderingTestAccord(t, OrderPerKey)
+	defer accord.Stop()
+
+	userTwo := &Message{ID: 1, Origin: "a", Key: "user/1", KeySeq: 2}
+	otherKey := &Message{ID: 2, Origin: "a", Key: "user/2", KeySeq: 1}
+	userOne := &Message{ID: 3, Origin: "a", Key: "user/1", KeySeq: 1}
+
+	assert.Nil(t, accord.HandleRemoteMessage(userTwo))
+	assert.Nil(t, accord.HandleRemoteMessage(otherKey))
+	assert.Equal(t, []uint64{2}, processedIDs(manager))
+
+	assert.Nil(t, accord.HandleRemoteMessage(userOne))
+	assert.Equal(t, []uint64{2, 3, 1}, processedIDs(manager))
+}
+
+func TestOrderKeySeqStamped(t *testing.T) {
+	defer AccordCleanup()
+	accord, _ := orderingTestAccord(t, OrderPerKey)
+	defer accord.Stop()
+
+	first := &Message{ID: 1, Key: "user/1"}
+	second := &Message{ID: 2, Key: "user/1"}
+	unkeyed := &Message{ID: 3}
+	assert.Nil(t, accord.HandleNewMessage(first))
+	assert.Nil(t, accord.HandleNewMessage(second))
+	assert.Nil(t, accord.HandleNewMessage(unkeyed))
+
+	assert.Equal(t, uint64(1), first.KeySeq)
+	assert.Equal(t, uint64(2), second.KeySeq)
+	assert.Equal(t, uint64(0), unkeyed.KeySeq)
+}
+
+func TestOrderSkippedMessagesDontBlock(t *testing.T) {
+	defer AccordCleanup()
+	accord, manager := orderingTestAccord(t, OrderFIFO)
+	defer accord.Stop()
+
+	accord.RegisterStage(Stage{Name: "reject", Phase: ValidatePhase, Run: func(msg *Message, fromRemote bool) error {
+		if msg.ID == 1 {
+			return assert.AnError
+		}
+		return nil
+	}})
+	assert.Nil(t, accord.SetPipeline(AnyType, "reject"))
+
+	assert.Nil(t, accord.HandleRemoteMessage(&Message{ID: 2, Origin: "a", Clock: VectorClock{"a": 2}}))
+	assert.NotNil(t, accord.HandleRemoteMessage(&Message{ID: 1, Origin: "a", Clock: VectorClock{"a": 1}}))
+	assert.Equal(t, []uint64{2}, processedIDs(manager))
+}
+
+func TestOrderHoldBackFull(t *testing.T) {
+	defer AccordCleanup()
+	accord, _ := orderingTestAccord(t, OrderFIFO)
+	accord.MaxHeldBack = 1
+	defer accord.Stop()
+
+	assert.Nil(t, accord.HandleRemoteMessage(&Message{ID: 2, Origin: "a", Clock: VectorClock{"a": 2}}))
+	assert.Equal(t, ErrHoldBackFull, accord.HandleRemoteMessage(&Message{ID: 3, Origin: "a", Clock: VectorClock{"a": 3}}))
+}
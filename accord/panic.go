@@ -0,0 +1,111 @@
+package accord
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+)
+
+// EventPanic is emitted when a Manager or Component panics
+const EventPanic = "panic"
+
+// PanicPolicy decides what happens when our Manager panics while handling a Message, takes longer than
+// ProcessTimeout over it or fails with an error that isn't a RetryableError, FatalError or ConflictError
+type PanicPolicy int
+
+const (
+	// PanicShutdown treats a panic like any other error from the Manager: we can no longer trust our
+	// state, so we blow ourselves up (through Shutdown, so it's at least a controlled one)
+	PanicShutdown PanicPolicy = iota
+
+	// PanicDeadLetter rejects the Message that caused the panic instead, setting it aside as a dead letter
+	// under our own NodeID for an operator to look into. Use it when the Manager is known to leave nothing
+	// half done when it panics, so that one bad Message can't keep a node crash looping
+	PanicDeadLetter
+)
+
+// PanicError is what a recovered panic is turned into
+type PanicError struct {
+	// Where is what was being called when the panic happened
+	Where string
+
+	// Value is what was passed to panic
+	Value interface{}
+
+	// Stack is the stack trace of the panicking goroutine
+	Stack []byte
+}
+
+func (err *PanicError) Error() string {
+	return fmt.Sprintf("panic in %s: %v", err.Where, err.Value)
+}
+
+// recoverPanic turns a panic into a PanicError stored in err. It has to be deferred directly
+func recoverPanic(where string, err *error) {
+	value := recover()
+	if value == nil {
+		return
+	}
+	*err = &PanicError{Where: where, Value: value, Stack: debug.Stack()}
+}
+
+// logPanic logs a recovered panic along with its stack trace and emits it
+func (accord *Accord) logPanic(err *PanicError) {
+	accord.Logger.WithError(err).WithField("stack", string(err.Stack)).Error("Recovered from a panic")
+	accord.Emit(EventPanic, "Recovered from a panic", map[string]interface{}{"where": err.Where, "error": err.Error()})
+}
+
+// handleManagerPanic deals with our Manager panicking over a Message according to our PanicPolicy,
+// returning the error the Message should be failed with
+func (accord *Accord) handleManagerPanic(msg *Message, err *PanicError) error {
+	accord.logPanic(err)
+	accord.recordError(err)
+	return accord.failManager(msg, err)
+}
+
+// failManager fails a Message our Manager couldn't be trusted to have handled, dead lettering it or
+// blowing ourselves up according to our PanicPolicy
+func (accord *Accord) failManager(msg *Message, err error) error {
+	if accord.PanicPolicy == PanicDeadLetter {
+		deadErr := accord.recordDeadLetters(accord.NodeID, err.Error(), []*Message{msg})
+		if deadErr != nil {
+			return deadErr
+		}
+		return &StageError{Stage: "manager", Err: err}
+	}
+
+	accord.Shutdown(err)
+	return err
+}
+
+// process calls the Process of the Manager of the Message (or its ProcessContext, if it's a
+// ContextManager), recovering from any panic
+func (accord *Accord) process(ctx context.Context, msg *Message, fromRemote bool) (err error) {
+	defer recoverPanic("Manager.Process", &err)
+	manager := accord.managerFor(msg)
+	if contextManager, ok := manager.(ContextManager); ok {
+		ctx, done := accord.managerContext(ctx)
+		defer done()
+		return contextManager.ProcessContext(ctx, msg, fromRemote)
+	}
+	return manager.Process(msg, fromRemote)
+}
+
+// shouldProcess calls the ShouldProcess of the Manager of the Message (or its ShouldProcessContext, if
+// it's a ContextManager), recovering from any panic
+func (accord *Accord) shouldProcess(ctx context.Context, msg *Message) (ok bool, err error) {
+	defer recoverPanic("Manager.ShouldProcess", &err)
+	manager := accord.managerFor(msg)
+	if contextManager, ok := manager.(ContextManager); ok {
+		ctx, done := accord.managerContext(ctx)
+		defer done()
+		return contextManager.ShouldProcessContext(ctx, *msg, accord.historyStack), nil
+	}
+	return manager.ShouldProcess(*msg, accord.historyStack), nil
+}
+
+// startComponent starts a Component, recovering from any panic
+func (accord *Accord) startComponent(comp Component) (err error) {
+	defer recoverPanic(fmt.Sprintf("%T.Start", comp), &err)
+	return comp.Start(accord)
+}
@@ -0,0 +1,107 @@
+package accord
+
+import (
+	"testing"
+
+	"github.com/beeker1121/goque"
+	"github.com/stretchr/testify/assert"
+)
+
+// panickyManager panics over any Message with a Payload of "boom"
+type panickyManager struct {
+	DummyManager
+}
+
+func (manager panickyManager) Process(msg *Message, fromRemote bool) error {
+	if string(msg.Payload) == "boom" {
+		panic("the manager exploded")
+	}
+	return nil
+}
+
+func (manager panickyManager) ShouldProcess(msg Message, history *goque.Stack) bool {
+	if string(msg.Payload) == "boom?" {
+		panic("the manager exploded while deciding")
+	}
+	return true
+}
+
+// panickyComponent panics the first time it ticks
+type panickyComponent struct {
+	ComponentRunner
+}
+
+func (comp *panickyComponent) Start(accord *Accord) error {
+	comp.Init(accord, func(*Accord) { panic("the component exploded") }, nil, nil)
+	return nil
+}
+
+func TestManagerPanicDeadLetter(t *testing.T) {
+	defer AccordCleanup()
+	accord := NewAccord(panickyManager{}, nil, "", DummyAccord().Logger)
+	accord.NodeID = "local"
+	accord.PanicPolicy = PanicDeadLetter
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	msg, _ := NewMessage([]byte("boom"))
+	err := accord.HandleNewMessage(msg)
+	rejected, ok := err.(*StageError)
+	if assert.True(t, ok) {
+		assert.Equal(t, "manager", rejected.Stage)
+		assert.Equal(t, "Manager.Process", rejected.Err.(*PanicError).Where)
+		assert.Contains(t, string(rejected.Err.(*PanicError).Stack), "panickyManager")
+	}
+	assert.Equal(t, uint64(0), accord.Queue().Len())
+
+	remote, _ := NewMessage([]byte("boom?"))
+	remote.Origin = "remote"
+	remote.Clock = VectorClock{"remote": 1}
+	_, ok = accord.HandleRemoteMessage(remote).(*StageError)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(1), accord.state.Delivered("remote"))
+
+	// Everything still works afterwards, and the panics are set aside for an operator
+	fine, _ := NewMessage([]byte("fine"))
+	assert.Nil(t, accord.HandleNewMessage(fine))
+
+	letters, err := accord.DeadLetters()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(letters))
+	assert.Equal(t, "local", letters[0].Peer)
+	assert.Equal(t, "boom", string(letters[0].Message.Payload))
+
+	requeued, err := accord.RequeueDeadLetters("")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, requeued)
+}
+
+func TestManagerPanicShutdown(t *testing.T) {
+	defer AccordCleanup()
+	accord := NewAccord(panickyManager{}, nil, "", DummyAccord().Logger)
+	assert.Nil(t, accord.Start())
+
+	listened := make(chan error)
+	go func() {
+		listened <- accord.Listen()
+	}()
+
+	msg, _ := NewMessage([]byte("boom"))
+	_, ok := accord.HandleNewMessage(msg).(*PanicError)
+	assert.True(t, ok)
+	_, ok = (<-listened).(*PanicError)
+	assert.True(t, ok)
+}
+
+func TestComponentPanic(t *testing.T) {
+	defer AccordCleanup()
+	comp := &panickyComponent{}
+	accord := NewAccord(NewDummerManager(), []Component{comp}, "", DummyAccord().Logger)
+	assert.Nil(t, accord.Start())
+
+	err := accord.Listen()
+	if assert.IsType(t, &PanicError{}, err) {
+		assert.Equal(t, "component tick", err.(*PanicError).Where)
+	}
+	assert.False(t, comp.Running())
+}
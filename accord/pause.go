@@ -0,0 +1,48 @@
+package accord
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// Event kinds emitted when we're paused and resumed
+const (
+	EventPaused  = "paused"
+	EventResumed = "resumed"
+)
+
+// ErrPaused is returned for any Message we're asked to handle while we're paused
+var ErrPaused = errors.New("accord is paused, so no messages can be handled")
+
+// Pause freezes us in place: every Message we're asked to handle, local or remote, is refused with
+// ErrPaused and our peers' cursors stop handing out queued Messages, but our Components keep running and
+// our history, queue and state can all still be read. It's meant for operators that need a node to hold
+// still during something risky, like a migration of the application's own data. Any Message that's already
+// being handled is finished before Pause returns, so nothing changes underneath the operator afterwards
+func (accord *Accord) Pause() {
+	if !atomic.CompareAndSwapInt32(&accord.paused, 0, 1) {
+		return
+	}
+
+	// Wait out whatever's in the middle of being processed
+	accord.processMutex.Lock()
+	accord.processMutex.Unlock()
+
+	accord.Logger.Info("Pausing. No messages will be handled until we're resumed")
+	accord.Emit(EventPaused, "Accord was paused", nil)
+}
+
+// Resume picks up where Pause left off
+func (accord *Accord) Resume() {
+	if !atomic.CompareAndSwapInt32(&accord.paused, 1, 0) {
+		return
+	}
+
+	accord.Logger.Info("Resuming")
+	accord.Emit(EventResumed, "Accord was resumed", nil)
+}
+
+// Paused reports whether we've been paused
+func (accord *Accord) Paused() bool {
+	return atomic.LoadInt32(&accord.paused) == 1
+}
@@ -0,0 +1,49 @@
+package accord
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPauseAndResume(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	kinds := []string{}
+	accord.Subscribe(func(event Event) {
+		kinds = append(kinds, event.Kind)
+	})
+
+	peer := accord.AddPeer("remote")
+	msg, _ := NewMessage([]byte("before"))
+	assert.Nil(t, accord.HandleNewMessage(msg))
+
+	accord.Pause()
+	accord.Pause()
+	assert.True(t, accord.Paused())
+
+	msg, _ = NewMessage([]byte("during"))
+	assert.Equal(t, ErrPaused, accord.HandleNewMessage(msg))
+	remote, _ := NewMessage([]byte("remote"))
+	remote.Origin = "remote"
+	assert.Equal(t, ErrPaused, accord.HandleRemoteMessage(remote))
+
+	// Nothing is handed out to our peers, but it's all still there
+	msgs, err := peer.Peek(10)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(msgs))
+	assert.Equal(t, uint64(1), accord.Queue().Len())
+
+	accord.Resume()
+	assert.False(t, accord.Paused())
+	assert.Nil(t, accord.HandleNewMessage(msg))
+	assert.Nil(t, accord.HandleRemoteMessage(remote))
+
+	msgs, err = peer.Peek(10)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(msgs))
+	assert.Equal(t, []string{EventPaused, EventResumed}, kinds)
+}
@@ -0,0 +1,105 @@
+package accord
+
+import (
+	"crypto/subtle"
+	"time"
+)
+
+// PeerToken is a bearer token a peer can authenticate itself with
+type PeerToken struct {
+	// Peer names the peer the token belongs to, which should be its NodeID so that Components can check it
+	// against who the peer claims to be
+	Peer  string
+	Token string
+
+	// Expires is when the token stops being accepted, or zero if it never does
+	Expires time.Time
+}
+
+// PeerAuth has our Components' peers authenticate themselves with bearer tokens, so that an endpoint that's
+// exposed to the internet can't be fed Messages by whoever finds it. Each peer can be given a token of its
+// own, or every peer can share the same secret.
+//
+// A peer can have several tokens at once, which is what lets them be rotated without turning the peer away
+// in the meantime: accept the new token alongside the old one (see RotatePeerToken), hand the peer the new
+// one, and let the old one expire. Tokens should be long random strings, and only sent over TLS
+type PeerAuth struct {
+	// Tokens are the tokens we accept from our peers. Peers aren't asked to authenticate at all while it's
+	// empty
+	Tokens []PeerToken
+
+	// Token is the token we present to every peer that isn't listed in PeerTokens
+	Token string
+
+	// PeerTokens are the tokens we present to particular peers, by their address (as with PeerPolicy, any
+	// port is ignored)
+	PeerTokens map[string]string
+}
+
+// SetPeerAuth replaces our PeerAuth while we're running, for instance when the configuration is reloaded
+// with new tokens (see Reload)
+func (accord *Accord) SetPeerAuth(auth PeerAuth) {
+	accord.peerAuthMutex.Lock()
+	defer accord.peerAuthMutex.Unlock()
+	accord.PeerAuth = auth
+}
+
+// RotatePeerToken starts accepting a new token from a peer, while the ones it has already keep being
+// accepted for the grace period, giving the peer time to switch over to the new one. A grace period of
+// zero stops accepting them straight away
+func (accord *Accord) RotatePeerToken(peer string, token string, grace time.Duration) {
+	accord.peerAuthMutex.Lock()
+	defer accord.peerAuthMutex.Unlock()
+
+	expires := time.Now().Add(grace)
+	tokens := []PeerToken{}
+	for _, existing := range accord.PeerAuth.Tokens {
+		if existing.Peer == peer && (existing.Expires.IsZero() || existing.Expires.After(expires)) {
+			existing.Expires = expires
+		}
+		tokens = append(tokens, existing)
+	}
+	accord.PeerAuth.Tokens = append(tokens, PeerToken{Peer: peer, Token: token})
+	accord.Logger.WithField("peer", peer).WithField("grace", grace).Info("Rotating a peer's token")
+}
+
+// AuthenticatePeer checks the token a peer at the given address presented, returning the name of the peer
+// it belongs to. Every peer is let through, with an empty name, when we have no Tokens. Peers that present
+// a token we don't accept (or none at all) are reported for a ViolationUnauthorized, so that one guessing
+// at tokens ends up banned
+func (accord *Accord) AuthenticatePeer(address string, token string) (string, bool) {
+	accord.peerAuthMutex.RLock()
+	tokens := accord.PeerAuth.Tokens
+	accord.peerAuthMutex.RUnlock()
+	if len(tokens) == 0 {
+		return "", true
+	}
+
+	now := time.Now()
+	for _, accepted := range tokens {
+		if !accepted.Expires.IsZero() && now.After(accepted.Expires) {
+			continue
+		}
+		if token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(accepted.Token)) == 1 {
+			return accepted.Peer, true
+		}
+	}
+
+	detail := "invalid token"
+	if token == "" {
+		detail = "missing token"
+	}
+	accord.ReportViolation(address, ViolationUnauthorized, detail)
+	return "", false
+}
+
+// PeerToken returns the token to present to the peer at the given address, if we have one
+func (accord *Accord) PeerToken(address string) string {
+	accord.peerAuthMutex.RLock()
+	defer accord.peerAuthMutex.RUnlock()
+
+	if token, ok := accord.PeerAuth.PeerTokens[peerHost(address)]; ok {
+		return token
+	}
+	return accord.PeerAuth.Token
+}
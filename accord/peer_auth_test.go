@@ -0,0 +1,67 @@
+package accord
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticatePeer(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	accord.PeerPolicy.BanThreshold = 2
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	// Everyone is let through until we have tokens
+	peer, ok := accord.AuthenticatePeer("10.0.0.1:5000", "")
+	assert.True(t, ok)
+	assert.Equal(t, "", peer)
+
+	accord.SetPeerAuth(PeerAuth{Tokens: []PeerToken{
+		{Peer: "edge-1", Token: "first"},
+		{Peer: "edge-2", Token: "expired", Expires: time.Now().Add(-time.Minute)},
+	}})
+	peer, ok = accord.AuthenticatePeer("10.0.0.1:5000", "first")
+	assert.True(t, ok)
+	assert.Equal(t, "edge-1", peer)
+	_, ok = accord.AuthenticatePeer("10.0.0.2:5000", "expired")
+	assert.False(t, ok)
+
+	// Guessing at tokens gets a peer banned
+	_, ok = accord.AuthenticatePeer("10.0.0.2:5000", "")
+	assert.False(t, ok)
+	assert.False(t, accord.AllowPeer("10.0.0.2"))
+	assert.True(t, accord.AllowPeer("10.0.0.1"))
+}
+
+func TestRotatePeerToken(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	accord.PeerAuth.Tokens = []PeerToken{{Peer: "edge-1", Token: "old"}, {Peer: "edge-2", Token: "other"}}
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	// The old token is still accepted while the peer switches over
+	accord.RotatePeerToken("edge-1", "new", 10*time.Millisecond)
+	for _, token := range []string{"old", "new", "other"} {
+		_, ok := accord.AuthenticatePeer("10.0.0.1", token)
+		assert.True(t, ok, token)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	_, ok := accord.AuthenticatePeer("10.0.0.1", "old")
+	assert.False(t, ok)
+	peer, ok := accord.AuthenticatePeer("10.0.0.1", "new")
+	assert.True(t, ok)
+	assert.Equal(t, "edge-1", peer)
+	_, ok = accord.AuthenticatePeer("10.0.0.1", "other")
+	assert.True(t, ok)
+}
+
+func TestPeerToken(t *testing.T) {
+	accord := &Accord{PeerAuth: PeerAuth{Token: "shared", PeerTokens: map[string]string{"10.0.0.1": "own"}}}
+	assert.Equal(t, "own", accord.PeerToken("10.0.0.1:7000"))
+	assert.Equal(t, "shared", accord.PeerToken("10.0.0.2:7000"))
+}
@@ -0,0 +1,331 @@
+package accord
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/beeker1121/goque"
+)
+
+// ErrUnknownPeer is returned when referring to a peer that was never added with AddPeer
+var ErrUnknownPeer = errors.New("no peer with that name has been added")
+
+// peerSet keeps track of the peers we synchronize with and how far along our queue each of them is
+type peerSet struct {
+	mutex sync.Mutex
+
+	// cursors holds the queue item ID each peer should be sent next in each of our channels, keyed by
+	// cursorKey. Zero means the peer starts at whatever is at the front of the queue
+	cursors map[string]uint64
+
+	// waiters are waiting for their Messages to be acknowledged (see HandleNewMessageSync)
+	waiters []*ackWaiter
+
+	// limiters rate limit how quickly Messages are handed out to each peer (see RateLimit), and
+	// customLimits marks the peers that were given limits of their own
+	limiters     map[string]*peerLimiter
+	customLimits map[string]bool
+
+	// inFlight holds the Messages shipped to each peer that it hasn't acknowledged yet (see Ship), keyed
+	// like cursors
+	inFlight map[string]*inFlight
+
+	// known holds the queue item IDs of Messages each peer is known to have already, sorted and keyed like
+	// cursors (see SkipKnown)
+	known map[string][]uint64
+
+	// windows holds the SyncWindows of the peers that were given windows of their own
+	windows map[string][]SyncWindow
+}
+
+// PeerCursor is a peer's own position in our synchronization queue. Rather than one transport consuming
+// the queue for everyone, each peer we synchronize with gets a cursor of its own, so a hub can feed a dozen
+// edge nodes at their own pace. Messages are only removed from the queue once every peer's cursor has moved
+// past them. A peer has a cursor into each of our channels as well (see Channel)
+type PeerCursor struct {
+	name    string
+	channel string
+	shard   int
+	accord  *Accord
+}
+
+// AddPeer registers a peer to synchronize with and returns its cursor into our default channel. A new peer starts at the front of
+// the queue, so it's sent everything that's still waiting to be synchronized. Cursors are saved as they
+// move, so a peer we synchronized with before we were last stopped resumes from where it left off instead.
+// Adding a peer that already exists simply returns its cursor.
+//
+// Peers should be named after their NodeID. Messages that originated from a peer are never sent back to
+// it, which is what makes relaying (see Relay) safe in a hub
+func (accord *Accord) AddPeer(name string) *PeerCursor {
+	accord.peers.mutex.Lock()
+	defer accord.peers.mutex.Unlock()
+
+	if accord.peers.cursors == nil {
+		accord.peers.cursors = map[string]uint64{}
+	}
+	if _, ok := accord.peers.cursors[name]; !ok {
+		accord.Logger.WithField("peer", name).Info("Adding peer")
+		for _, shard := range accord.allShards() {
+			// A peer we've synchronized with before picks up where it left off
+			key := cursorKey(name, shard.channel.name, shard.index)
+			log := accord.Logger.WithField("peer", name).WithField("channel", shard.channel.name).WithField("shard", shard.index)
+			next, saved, err := accord.state.LoadCursor(key)
+			if err != nil {
+				log.WithError(err).Warn("We could not load a peer's cursor, so it will be sent everything still queued")
+			}
+			log.WithField("resumed", saved).Debug("Opening a cursor for the peer")
+			accord.peers.cursors[key] = next
+		}
+	}
+
+	return &PeerCursor{name: name, accord: accord}
+}
+
+// RemovePeer stops tracking a peer and forgets its saved cursor. Anything only it was still waiting on is
+// dropped from the queue
+func (accord *Accord) RemovePeer(name string) error {
+	accord.peers.mutex.Lock()
+	defer accord.peers.mutex.Unlock()
+
+	if _, ok := accord.peers.cursors[name]; !ok {
+		return ErrUnknownPeer
+	}
+
+	accord.Logger.WithField("peer", name).Info("Removing peer")
+	defer accord.forgetProgress(name)
+	for _, shard := range accord.allShards() {
+		key := cursorKey(name, shard.channel.name, shard.index)
+		delete(accord.peers.cursors, key)
+		delete(accord.peers.inFlight, key)
+		delete(accord.peers.known, key)
+		err := accord.state.DeleteCursor(key)
+		if err != nil {
+			return err
+		}
+		err = accord.trimQueue(shard)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Peer returns the cursor of a peer added with AddPeer into our default channel, or nil if there isn't
+// one
+func (accord *Accord) Peer(name string) *PeerCursor {
+	accord.peers.mutex.Lock()
+	defer accord.peers.mutex.Unlock()
+
+	if _, ok := accord.peers.cursors[name]; !ok {
+		return nil
+	}
+	return &PeerCursor{name: name, accord: accord}
+}
+
+// Peers returns the names of every peer we're synchronizing with, sorted
+func (accord *Accord) Peers() []string {
+	accord.peers.mutex.Lock()
+	defer accord.peers.mutex.Unlock()
+
+	return accord.peerNames()
+}
+
+// peerNames returns the names of every peer we're synchronizing with, sorted. The caller must hold the
+// peers mutex
+func (accord *Accord) peerNames() []string {
+	names := []string{}
+	for key := range accord.peers.cursors {
+		if name, channel, shard := splitCursorKey(key); channel == "" && shard == 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Name returns the name of the peer the cursor belongs to
+func (cursor *PeerCursor) Name() string {
+	return cursor.name
+}
+
+// Peek returns up to max of the next Messages that should be sent to the peer, oldest first, without
+// moving the cursor. An empty slice means the peer is caught up (or that we're paused, that it's outside of
+// the peer's SyncWindows, or that the peer has used up its RateLimit for now). Once the Messages have been
+// delivered, call Advance
+func (cursor *PeerCursor) Peek(max int) ([]*Message, error) {
+	accord := cursor.accord
+	if accord.Paused() {
+		return []*Message{}, nil
+	}
+
+	accord.peers.mutex.Lock()
+	defer accord.peers.mutex.Unlock()
+
+	msgs := []*Message{}
+	if !accord.inSyncWindow(cursor.name, time.Now()) {
+		return msgs, nil
+	}
+	_, err := accord.scanForPeer(cursor, max, func(msg *Message) {
+		msgs = append(msgs, msg)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if limiter := accord.limiterFor(cursor.name); limiter != nil {
+		msgs = msgs[:limiter.allowed(msgs)]
+	}
+
+	// Skipping over the peer's own Messages may have moved its cursor along
+	accord.notifyAcks()
+	return msgs, accord.trimQueue(cursor.queue())
+}
+
+// Advance moves the cursor past the next count Messages, which should be the ones that were just
+// delivered after a call to Peek. Messages every other peer has moved past as well are removed from the
+// queue
+func (cursor *PeerCursor) Advance(count int) error {
+	bytes, err := cursor.advance(count)
+	if err == nil {
+		cursor.accord.recordProgress(cursor.name, count, bytes)
+	}
+	return err
+}
+
+// advance does the work of Advance while holding the peers mutex, returning the size of the Payloads of
+// the Messages the cursor moved past
+func (cursor *PeerCursor) advance(count int) (int, error) {
+	accord := cursor.accord
+	accord.peers.mutex.Lock()
+	defer accord.peers.mutex.Unlock()
+
+	bytes := 0
+	next, err := accord.scanForPeer(cursor, count, func(msg *Message) {
+		bytes += len(msg.Payload)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if limiter := accord.limiterFor(cursor.name); limiter != nil {
+		limiter.take(count, bytes)
+	}
+	err = accord.moveCursor(cursor.key(), next)
+	if err != nil {
+		return 0, err
+	}
+	accord.notifyAcks()
+	return bytes, accord.trimQueue(cursor.queue())
+}
+
+// pending returns roughly how many queued Messages the peer still has to be sent on the cursor's shard.
+// Messages that will be skipped because the peer sent them to us in the first place are included
+func (cursor *PeerCursor) pending() uint64 {
+	accord := cursor.accord
+	accord.peers.mutex.Lock()
+	defer accord.peers.mutex.Unlock()
+
+	queue := cursor.queue().queue
+	head, err := queue.Peek()
+	if err != nil {
+		return 0
+	}
+
+	next := accord.peers.cursors[cursor.key()]
+	tail := head.ID + queue.Length()
+	if next < head.ID {
+		next = head.ID
+	}
+	if next >= tail {
+		return 0
+	}
+	return tail - next
+}
+
+// scanForPeer walks the cursor's queue from where it is, calling fn for up to max Messages the peer should
+// be sent and skipping over any that originated from the peer itself or that it has already. It returns the
+// item ID of the next Message the peer should be sent after those. The caller must hold the peers mutex
+func (accord *Accord) scanForPeer(cursor *PeerCursor, max int, fn func(*Message)) (uint64, error) {
+	next, ok := accord.peers.cursors[cursor.key()]
+	if !ok {
+		return 0, ErrUnknownPeer
+	}
+
+	queue := cursor.queue().queue
+	head, err := queue.Peek()
+	if err == goque.ErrEmpty {
+		return next, nil
+	}
+	if err != nil {
+		return next, err
+	}
+	if next < head.ID {
+		next = head.ID
+	}
+
+	for found := 0; ; next++ {
+		item, err := queue.PeekByID(next)
+		if err == goque.ErrOutOfBounds {
+			// We're past the end of the queue, so the peer is caught up
+			return next, nil
+		}
+		if err != nil {
+			return next, err
+		}
+
+		msg, err := accord.sealer.decodeMessage(item.Value)
+		if err != nil {
+			return next, err
+		}
+
+		// Messages the peer doesn't need are skipped over even once we've found enough, so that they don't
+		// hold up the queue being trimmed
+		if accord.skipsPeer(cursor, next, msg) {
+			// Nothing before this needs sending either, so there's no reason to ever look at it again
+			if found == 0 {
+				err = accord.moveCursor(cursor.key(), next+1)
+				if err != nil {
+					return next, err
+				}
+			}
+			continue
+		}
+		if found == max {
+			return next, nil
+		}
+		fn(msg)
+		found++
+	}
+}
+
+// trimQueue removes every Message from the front of a shard that all of our peers have moved past. The
+// caller must hold the peers mutex
+func (accord *Accord) trimQueue(shard *shard) error {
+	peers := accord.peerNames()
+	if len(peers) == 0 {
+		return nil
+	}
+
+	for {
+		head, err := shard.queue.Peek()
+		if err == goque.ErrEmpty {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, peer := range peers {
+			if accord.peers.cursors[cursorKey(peer, shard.channel.name, shard.index)] <= head.ID {
+				return nil
+			}
+		}
+
+		err = accord.dequeue(shard)
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package accord
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func peerIDs(msgs []*Message) []uint64 {
+	ids := []uint64{}
+	for _, msg := range msgs {
+		ids = append(ids, msg.ID)
+	}
+	return ids
+}
+
+func TestPeerCursors(t *testing.T) {
+	defer AccordCleanup()
+	accord := historyTestAccord(t)
+	defer accord.Stop()
+
+	edgeA := accord.AddPeer("edge-a")
+	edgeB := accord.AddPeer("edge-b")
+	assert.Equal(t, []string{"edge-a", "edge-b"}, accord.Peers())
+
+	msgs, err := edgeA.Peek(2)
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{1, 2}, peerIDs(msgs))
+	assert.Nil(t, edgeA.Advance(2))
+	assert.Equal(t, uint64(2), edgeA.Pending())
+	assert.Equal(t, uint64(4), edgeB.Pending())
+
+	// Nothing can be dropped from the queue until edge-b has caught up as well
+	assert.Equal(t, uint64(4), accord.Queue().Len())
+	assert.Nil(t, edgeB.Advance(3))
+	assert.Equal(t, uint64(2), accord.Queue().Len())
+
+	// A new peer starts at the front of what's left. Message 3 came from "remote", so it should never be
+	// sent back there
+	remote := accord.AddPeer("remote")
+	msgs, err = remote.Peek(10)
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{4}, peerIDs(msgs))
+
+	assert.Nil(t, accord.RemovePeer("remote"))
+	assert.Equal(t, ErrUnknownPeer, accord.RemovePeer("remote"))
+	assert.Nil(t, accord.Peer("remote"))
+
+	// With edge-a's cursor the furthest behind, that's what the queue is trimmed to
+	assert.Equal(t, uint64(2), accord.Queue().Len())
+	assert.Nil(t, edgeA.Advance(10))
+	assert.Nil(t, edgeB.Advance(10))
+	assert.Equal(t, uint64(0), accord.Queue().Len())
+
+	msgs, err = edgeA.Peek(10)
+	assert.Nil(t, err)
+	assert.Empty(t, msgs)
+}
+
+func TestRelay(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	accord.Relay = true
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	edgeB := accord.AddPeer("edge-b")
+	edgeA := accord.AddPeer("edge-a")
+
+	assert.Nil(t, accord.HandleRemoteMessage(&Message{ID: 7, Origin: "edge-a"}))
+	msgs, err := edgeB.Peek(10)
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{7}, peerIDs(msgs))
+
+	msgs, err = edgeA.Peek(10)
+	assert.Nil(t, err)
+	assert.Empty(t, msgs)
+}
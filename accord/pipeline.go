@@ -0,0 +1,145 @@
+package accord
+
+import (
+	"fmt"
+)
+
+// StagePhase determines at which point of a pipeline a Stage gets run
+type StagePhase int
+
+const (
+	// ValidatePhase stages run first and are meant to reject Messages that shouldn't be processed at all
+	ValidatePhase StagePhase = iota
+
+	// TransformPhase stages run after validation and are allowed to modify the Message (enriching it,
+	// normalizing it, etc...) before it gets handed to the Manager
+	TransformPhase
+
+	// AfterApplyPhase stages run once the Manager has processed the Message and our state has been
+	// updated. As the Message has already been applied at this point, errors are only logged
+	AfterApplyPhase
+)
+
+// Stage is a single reusable step that can be assembled into per message type pipelines
+type Stage struct {
+	// Name is how the stage is referred to when declaring a pipeline
+	Name string
+
+	// Phase determines when the stage runs
+	Phase StagePhase
+
+	// Run does the actual work of the stage. Returning an error from a validate or transform stage
+	// stops the Message from being processed
+	Run func(msg *Message, fromRemote bool) error
+}
+
+// StageError is returned when a pipeline stage refuses a Message
+type StageError struct {
+	Stage string
+	Err   error
+}
+
+func (err *StageError) Error() string {
+	return fmt.Sprintf("pipeline stage %q rejected message: %s", err.Stage, err.Err)
+}
+
+func (err *StageError) Unwrap() error {
+	return err.Err
+}
+
+// AnyType can be used with SetPipeline to declare a pipeline for every Message Type that doesn't have
+// one of its own
+const AnyType = "*"
+
+// pipeline is a resolved list of stages, already split up by phase
+type pipeline struct {
+	before []Stage
+	after  []Stage
+}
+
+// RegisterStage makes a Stage available to be used in pipelines. Registering a stage with the same name
+// as an existing one replaces it (although pipelines that have already been declared keep using the
+// original). This, along with SetPipeline, should be called before Start
+func (accord *Accord) RegisterStage(stage Stage) {
+	if accord.stages == nil {
+		accord.stages = make(map[string]Stage)
+	}
+	accord.stages[stage.Name] = stage
+}
+
+// SetPipeline declares which registered stages should be run around Process for Messages of the given Type
+// (or AnyType). Stages within the same phase are run in the order they're listed here, but phases always run
+// in the order validate, transform, Process, after apply
+func (accord *Accord) SetPipeline(msgType string, stageNames ...string) error {
+	var validate, transform, after []Stage
+
+	for _, name := range stageNames {
+		stage, ok := accord.stages[name]
+		if !ok {
+			return fmt.Errorf("no pipeline stage named %q has been registered", name)
+		}
+
+		switch stage.Phase {
+		case ValidatePhase:
+			validate = append(validate, stage)
+		case TransformPhase:
+			transform = append(transform, stage)
+		case AfterApplyPhase:
+			after = append(after, stage)
+		default:
+			return fmt.Errorf("pipeline stage %q has an unknown phase", name)
+		}
+	}
+
+	if accord.pipelines == nil {
+		accord.pipelines = make(map[string]*pipeline)
+	}
+	accord.pipelines[msgType] = &pipeline{
+		before: append(validate, transform...),
+		after:  after,
+	}
+
+	return nil
+}
+
+// pipelineFor finds the pipeline that should be used for a Message, which may be nil if none was declared
+func (accord *Accord) pipelineFor(msg *Message) *pipeline {
+	if pipe, ok := accord.pipelines[msg.Type]; ok {
+		return pipe
+	}
+	return accord.pipelines[AnyType]
+}
+
+// runBefore runs the validate and transform stages, stopping at the first one to fail
+func (pipe *pipeline) runBefore(msg *Message, fromRemote bool) error {
+	if pipe == nil {
+		return nil
+	}
+
+	for _, stage := range pipe.before {
+		err := stage.Run(msg, fromRemote)
+		if err != nil {
+			return &StageError{Stage: stage.Name, Err: err}
+		}
+	}
+
+	return nil
+}
+
+// runAfter runs the after apply stages. Every stage is run regardless of whether the others fail, and all of
+// the errors are returned so they can be logged
+func (pipe *pipeline) runAfter(msg *Message, fromRemote bool) []error {
+	if pipe == nil {
+		return nil
+	}
+
+	var errs []error
+	for _, stage := range pipe.after {
+		err := stage.Run(msg, fromRemote)
+		if err != nil {
+			errs = append(errs, &StageError{Stage: stage.Name, Err: err})
+		}
+	}
+
+	return errs
+}
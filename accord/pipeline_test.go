@@ -0,0 +1,88 @@
+package accord
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/beeker1121/goque"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingManager struct {
+	processed []Message
+}
+
+func (manager *recordingManager) Process(msg *Message, fromRemote bool) error {
+	manager.processed = append(manager.processed, *msg)
+	return nil
+}
+
+func (manager *recordingManager) ShouldProcess(msg Message, history *goque.Stack) bool {
+	return true
+}
+
+func TestPipelineStages(t *testing.T) {
+	defer AccordCleanup()
+
+	manager := &recordingManager{}
+	accord := DummyAccord()
+	accord.manager = manager
+
+	order := []string{}
+	accord.RegisterStage(Stage{Name: "after", Phase: AfterApplyPhase, Run: func(msg *Message, fromRemote bool) error {
+		order = append(order, "after")
+		return nil
+	}})
+	accord.RegisterStage(Stage{Name: "upper", Phase: TransformPhase, Run: func(msg *Message, fromRemote bool) error {
+		order = append(order, "transform")
+		msg.Payload = []byte("HELLO")
+		return nil
+	}})
+	accord.RegisterStage(Stage{Name: "nonempty", Phase: ValidatePhase, Run: func(msg *Message, fromRemote bool) error {
+		order = append(order, "validate")
+		if len(msg.Payload) == 0 {
+			return errors.New("empty payload")
+		}
+		return nil
+	}})
+
+	// Phases should be run in order regardless of the order they're declared in
+	assert.Nil(t, accord.SetPipeline("greeting", "after", "upper", "nonempty"))
+	assert.NotNil(t, accord.SetPipeline("greeting", "missing"))
+
+	accord.Start()
+	defer accord.Stop()
+
+	err := accord.HandleNewMessage(&Message{ID: 1, Type: "greeting", Payload: []byte("hello")})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"validate", "transform", "after"}, order)
+	assert.Equal(t, "HELLO", string(manager.processed[0].Payload))
+
+	err = accord.HandleNewMessage(&Message{ID: 2, Type: "greeting"})
+	assert.NotNil(t, err)
+	stageErr, ok := err.(*StageError)
+	assert.True(t, ok)
+	assert.Equal(t, "nonempty", stageErr.Stage)
+	assert.Len(t, manager.processed, 1)
+	assert.Equal(t, uint64(1), accord.state.GetCurrent())
+
+	// Messages of other types shouldn't be touched
+	err = accord.HandleNewMessage(&Message{ID: 3, Type: "other"})
+	assert.Nil(t, err)
+	assert.Len(t, manager.processed, 2)
+}
+
+func TestPipelineAnyType(t *testing.T) {
+	defer AccordCleanup()
+
+	accord := DummyAccord()
+	accord.RegisterStage(Stage{Name: "reject", Phase: ValidatePhase, Run: func(msg *Message, fromRemote bool) error {
+		return errors.New("rejected")
+	}})
+	assert.Nil(t, accord.SetPipeline(AnyType, "reject"))
+
+	accord.Start()
+	defer accord.Stop()
+
+	assert.NotNil(t, accord.HandleNewMessage(&Message{ID: 1, Type: "anything"}))
+}
@@ -0,0 +1,181 @@
+package accord
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Event kinds emitted while a backlog is flushed to a peer (see ProgressReporting)
+const (
+	EventSyncProgress = "sync_progress"
+	EventSyncCaughtUp = "sync_caught_up"
+)
+
+// DefaultProgressThreshold and DefaultProgressInterval are what ProgressReporting falls back to
+const (
+	DefaultProgressThreshold = 1000
+	DefaultProgressInterval  = 10 * time.Second
+)
+
+// ProgressReporting decides when a peer is far enough behind for us to report on how it's catching up.
+// That happens once Messages are delivered to a peer with at least Threshold of them to go, typically
+// after it has been unreachable for a while, and carries on until it's caught up. Along the way we emit an
+// EventSyncProgress every Interval, and an EventSyncCaughtUp at the end, and SyncProgress reports where
+// each of them is at
+type ProgressReporting struct {
+	// Threshold is how many Messages a peer has to be behind by, defaulting to DefaultProgressThreshold
+	Threshold uint64
+
+	// Interval is how often we emit an EventSyncProgress, defaulting to DefaultProgressInterval. A peer
+	// that hasn't been delivered anything for longer than that is reported as Stalled
+	Interval time.Duration
+}
+
+// SyncProgress is how far along a peer is in catching up on a backlog
+type SyncProgress struct {
+	Peer string `json:"peer"`
+
+	// Sent is how many Messages have been delivered since the peer started catching up, out of Total,
+	// which grows along with the backlog if new Messages come in along the way. Bytes is the size of the
+	// Payloads of the ones that were sent
+	Sent  uint64 `json:"sent"`
+	Total uint64 `json:"total"`
+	Bytes uint64 `json:"bytes"`
+
+	Started  time.Time `json:"started"`
+	LastSent time.Time `json:"lastSent"`
+
+	// Rate is how many Messages a second have been delivered on average, and ETA how long the rest should
+	// take at that rate
+	Rate float64       `json:"rate"`
+	ETA  time.Duration `json:"eta"`
+
+	// Stalled means nothing has been delivered to the peer for longer than our ProgressReporting's
+	// Interval, so it's stuck rather than catching up
+	Stalled bool `json:"stalled"`
+}
+
+// flushes keeps track of the peers that are catching up, by name
+type flushes struct {
+	mutex    sync.Mutex
+	progress map[string]*flush
+}
+
+// flush is a single peer catching up
+type flush struct {
+	SyncProgress
+	reported time.Time
+}
+
+// progressThreshold returns how far behind a peer has to be for us to report on its progress
+func (accord *Accord) progressThreshold() uint64 {
+	if accord.ProgressReporting.Threshold == 0 {
+		return DefaultProgressThreshold
+	}
+	return accord.ProgressReporting.Threshold
+}
+
+// progressInterval returns how often we report on peers catching up
+func (accord *Accord) progressInterval() time.Duration {
+	if accord.ProgressReporting.Interval <= 0 {
+		return DefaultProgressInterval
+	}
+	return accord.ProgressReporting.Interval
+}
+
+// recordProgress records that Messages were delivered to a peer, keeping track of its progress if it's
+// catching up on a backlog. It takes the peers mutex, so it mustn't be called while that's held
+func (accord *Accord) recordProgress(peer string, count int, bytes int) {
+	if count == 0 {
+		return
+	}
+	remaining, err := accord.PeerLag(peer)
+	if err != nil {
+		return
+	}
+
+	accord.flushes.mutex.Lock()
+	now := time.Now()
+	current, ok := accord.flushes.progress[peer]
+	if !ok {
+		if remaining+uint64(count) < accord.progressThreshold() {
+			accord.flushes.mutex.Unlock()
+			return
+		}
+		if accord.flushes.progress == nil {
+			accord.flushes.progress = map[string]*flush{}
+		}
+		current = &flush{SyncProgress: SyncProgress{Peer: peer, Started: now}}
+		accord.flushes.progress[peer] = current
+	}
+	current.Sent += uint64(count)
+	current.Bytes += uint64(bytes)
+	current.Total = current.Sent + remaining
+	current.LastSent = now
+	progress := current.estimate(now, accord.progressInterval())
+
+	caughtUp := remaining == 0
+	report := caughtUp || now.Sub(current.reported) >= accord.progressInterval()
+	if caughtUp {
+		delete(accord.flushes.progress, peer)
+	} else if report {
+		current.reported = now
+	}
+	accord.flushes.mutex.Unlock()
+
+	if !report {
+		return
+	}
+	fields := map[string]interface{}{
+		"peer":  peer,
+		"sent":  progress.Sent,
+		"total": progress.Total,
+		"bytes": progress.Bytes,
+	}
+	log := accord.Logger.WithField("peer", peer).WithField("sent", progress.Sent).WithField("total", progress.Total)
+	if caughtUp {
+		fields["duration"] = now.Sub(progress.Started).String()
+		log.Info("Peer has caught up on its backlog")
+		accord.Emit(EventSyncCaughtUp, "Peer "+peer+" has caught up on its backlog", fields)
+		return
+	}
+	fields["eta"] = progress.ETA.String()
+	log.WithField("eta", progress.ETA).Info("Peer is catching up on its backlog")
+	accord.Emit(EventSyncProgress, "Peer "+peer+" is catching up on its backlog", fields)
+}
+
+// estimate fills in our Rate, ETA and whether we've Stalled as of now
+func (current *flush) estimate(now time.Time, stallAfter time.Duration) SyncProgress {
+	progress := current.SyncProgress
+	if elapsed := now.Sub(progress.Started).Seconds(); elapsed > 0 {
+		progress.Rate = float64(progress.Sent) / elapsed
+	}
+	if progress.Rate > 0 {
+		progress.ETA = time.Duration(float64(progress.Total-progress.Sent) / progress.Rate * float64(time.Second))
+	}
+	progress.Stalled = now.Sub(progress.LastSent) > stallAfter
+	return progress
+}
+
+// SyncProgress returns the progress of every peer that's currently catching up on a backlog (see
+// ProgressReporting), sorted by name. Peers that are up to date, or only a little behind, aren't listed
+func (accord *Accord) SyncProgress() []SyncProgress {
+	accord.flushes.mutex.Lock()
+	defer accord.flushes.mutex.Unlock()
+
+	now := time.Now()
+	all := []SyncProgress{}
+	for _, current := range accord.flushes.progress {
+		all = append(all, current.estimate(now, accord.progressInterval()))
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Peer < all[j].Peer })
+	return all
+}
+
+// forgetProgress stops reporting on a peer, once it's been removed
+func (accord *Accord) forgetProgress(peer string) {
+	accord.flushes.mutex.Lock()
+	defer accord.flushes.mutex.Unlock()
+	delete(accord.flushes.progress, peer)
+}
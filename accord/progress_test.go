@@ -0,0 +1,103 @@
+package accord
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyncProgress(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	accord.ProgressReporting = ProgressReporting{Threshold: 6, Interval: time.Hour}
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	events := []Event{}
+	accord.Subscribe(func(event Event) {
+		if event.Kind == EventSyncProgress || event.Kind == EventSyncCaughtUp {
+			events = append(events, event)
+		}
+	})
+
+	near := accord.AddPeer("near")
+	far := accord.AddPeer("far")
+	for _, payload := range []string{"one", "two", "three", "four", "five"} {
+		msg, _ := NewMessage([]byte(payload))
+		assert.Nil(t, accord.HandleNewMessage(msg))
+	}
+
+	// A peer that's only a little behind isn't reported on
+	msgs, err := near.Peek(5)
+	assert.Nil(t, err)
+	assert.Nil(t, near.Advance(len(msgs)))
+	msgs, err = near.Peek(5)
+	assert.Nil(t, err)
+	assert.Empty(t, msgs)
+	assert.Empty(t, events)
+
+	// One that's far enough behind is, from the first batch on
+	for _, payload := range []string{"six", "seven", "eight"} {
+		msg, _ := NewMessage([]byte(payload))
+		assert.Nil(t, accord.HandleNewMessage(msg))
+	}
+	shipped, err := far.Ship(2)
+	assert.Nil(t, err)
+	assert.Nil(t, far.Ack(shipped[0].ID, shipped[1].ID))
+	progress := accord.SyncProgress()
+	assert.Len(t, progress, 1)
+	assert.Equal(t, "far", progress[0].Peer)
+	assert.Equal(t, uint64(2), progress[0].Sent)
+	assert.Equal(t, uint64(8), progress[0].Total)
+	assert.Equal(t, uint64(len("one")+len("two")), progress[0].Bytes)
+	assert.True(t, progress[0].Rate > 0)
+	assert.False(t, progress[0].Stalled)
+	assert.Len(t, events, 1)
+	assert.Equal(t, EventSyncProgress, events[0].Kind)
+	assert.Equal(t, uint64(8), events[0].Fields["total"])
+
+	// Nothing more is emitted until our Interval is up, or the peer has caught up
+	shipped, err = far.Ship(2)
+	assert.Nil(t, err)
+	assert.Nil(t, far.Ack(shipped[0].ID, shipped[1].ID))
+	assert.Len(t, events, 1)
+	assert.Equal(t, uint64(4), accord.SyncProgress()[0].Sent)
+
+	shipped, err = far.Ship(10)
+	assert.Nil(t, err)
+	ids := []uint64{}
+	for _, msg := range shipped {
+		ids = append(ids, msg.ID)
+	}
+	assert.Nil(t, far.Ack(ids...))
+	assert.Len(t, events, 2)
+	assert.Equal(t, EventSyncCaughtUp, events[1].Kind)
+	assert.Equal(t, uint64(8), events[1].Fields["sent"])
+	assert.Empty(t, accord.SyncProgress())
+}
+
+func TestSyncProgressStalled(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	accord.ProgressReporting = ProgressReporting{Threshold: 2, Interval: 20 * time.Millisecond}
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	peer := accord.AddPeer("remote")
+	for _, payload := range []string{"one", "two", "three"} {
+		msg, _ := NewMessage([]byte(payload))
+		assert.Nil(t, accord.HandleNewMessage(msg))
+	}
+	msgs, err := peer.Peek(1)
+	assert.Nil(t, err)
+	assert.Nil(t, peer.Advance(len(msgs)))
+	assert.False(t, accord.SyncProgress()[0].Stalled)
+
+	time.Sleep(30 * time.Millisecond)
+	assert.True(t, accord.SyncProgress()[0].Stalled)
+
+	// Removing the peer stops reporting on it
+	assert.Nil(t, accord.RemovePeer("remote"))
+	assert.Empty(t, accord.SyncProgress())
+}
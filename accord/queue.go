@@ -0,0 +1,110 @@
+package accord
+
+import (
+	"github.com/beeker1121/goque"
+)
+
+// Queue is a read-only view over the synchronization queue, listing the Messages that are still waiting
+// to be sent off to our remotes. When the queue is split into shards (see QueueShards) they're listed one
+// after the other, so Messages are only in order within a shard
+type Queue struct {
+	queues []*goque.Queue
+
+	// sealer decrypts our Messages when our data directory is encrypted (see EncryptionKey)
+	sealer *sealer
+}
+
+// Queue returns a read-only view over the Messages waiting to be synchronized on our default channel
+func (accord *Accord) Queue() *Queue {
+	return accord.channelFor("").view(accord.sealer)
+}
+
+// Len returns how many Messages are waiting in the queue
+func (queue *Queue) Len() uint64 {
+	total := uint64(0)
+	for _, q := range queue.queues {
+		total += q.Length()
+	}
+	return total
+}
+
+// Get returns the Message at the given offset from the front of the queue, where an offset of 0 is the
+// oldest Message (the next one to be sent)
+func (queue *Queue) Get(offset uint64) (*Message, error) {
+	for _, q := range queue.queues {
+		if offset >= q.Length() {
+			offset -= q.Length()
+			continue
+		}
+
+		item, err := q.PeekByOffset(offset)
+		if err != nil {
+			return nil, err
+		}
+		return queue.sealer.decodeMessage(item.Value)
+	}
+	return nil, goque.ErrOutOfBounds
+}
+
+// walk calls fn for every Message in the queue from oldest to newest, stopping early if fn returns false
+func (queue *Queue) walk(fn func(*Message) bool) error {
+	stopped := false
+	for _, q := range queue.queues {
+		err := walkQueue(q, queue.sealer, func(msg *Message) bool {
+			stopped = !fn(msg)
+			return !stopped
+		})
+		if err != nil || stopped {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkQueue calls fn for every Message in a single queue from oldest to newest, stopping early if fn
+// returns false. Like History we walk by item ID so that Messages being added behind us don't throw
+// anything off, but unlike History our queue is also consumed from the front while we're walking it, in
+// which case we just skip ahead to whatever is now at the front
+func walkQueue(q *goque.Queue, s *sealer, fn func(*Message) bool) error {
+	head, err := q.Peek()
+	if err != nil {
+		if err == goque.ErrEmpty {
+			return nil
+		}
+		return err
+	}
+
+	for id := head.ID; ; id++ {
+		item, err := q.PeekByID(id)
+		if err == goque.ErrOutOfBounds {
+			head, err = q.Peek()
+			if err == goque.ErrEmpty {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if head.ID <= id {
+				// We've reached the end of the queue
+				return nil
+			}
+			id = head.ID - 1
+			continue
+		}
+		if err == goque.ErrEmpty {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		msg, err := s.decodeMessage(item.Value)
+		if err != nil {
+			return err
+		}
+
+		if !fn(msg) {
+			return nil
+		}
+	}
+}
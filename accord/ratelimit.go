@@ -0,0 +1,128 @@
+package accord
+
+import (
+	"time"
+)
+
+// RateLimit caps how quickly Messages are handed out to a peer through its cursor, so that flushing a
+// backlog after an outage doesn't saturate a slow or metered link. Zero for either rate means that one
+// isn't limited
+type RateLimit struct {
+	// MessagesPerSecond is how many Messages a second the peer can be sent
+	MessagesPerSecond float64
+
+	// BytesPerSecond is how many bytes of Payload a second the peer can be sent
+	BytesPerSecond float64
+}
+
+// tokenBucket is a classic token bucket that holds up to a second's worth of tokens. Rather than refusing
+// anything bigger than what's left, we let the bucket go into debt, which means a single Message bigger than
+// a second's worth of bytes still gets through, and whatever comes after it waits for the debt to be paid off
+type tokenBucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	return &tokenBucket{rate: rate, tokens: rate, last: time.Now()}
+}
+
+// refill adds the tokens that have accumulated since we last looked
+func (bucket *tokenBucket) refill() {
+	now := time.Now()
+	bucket.tokens += now.Sub(bucket.last).Seconds() * bucket.rate
+	if bucket.tokens > bucket.rate {
+		bucket.tokens = bucket.rate
+	}
+	bucket.last = now
+}
+
+// peerLimiter holds a peer's token buckets, either of which may be nil
+type peerLimiter struct {
+	messages *tokenBucket
+	bytes    *tokenBucket
+}
+
+func newPeerLimiter(limit RateLimit) *peerLimiter {
+	limiter := &peerLimiter{}
+	if limit.MessagesPerSecond > 0 {
+		limiter.messages = newTokenBucket(limit.MessagesPerSecond)
+	}
+	if limit.BytesPerSecond > 0 {
+		limiter.bytes = newTokenBucket(limit.BytesPerSecond)
+	}
+	return limiter
+}
+
+// allowed returns how many of the passed in Messages can be sent right now, without using up any tokens
+func (limiter *peerLimiter) allowed(msgs []*Message) int {
+	messages, bytes := 1.0, 1.0
+	if limiter.messages != nil {
+		limiter.messages.refill()
+		messages = limiter.messages.tokens
+	}
+	if limiter.bytes != nil {
+		limiter.bytes.refill()
+		bytes = limiter.bytes.tokens
+	}
+
+	for i, msg := range msgs {
+		if messages < 1 || bytes <= 0 {
+			return i
+		}
+		if limiter.messages != nil {
+			messages--
+		}
+		if limiter.bytes != nil {
+			bytes -= float64(len(msg.Payload))
+		}
+	}
+	return len(msgs)
+}
+
+// take uses up the tokens for Messages that were sent
+func (limiter *peerLimiter) take(count int, bytes int) {
+	if limiter.messages != nil {
+		limiter.messages.refill()
+		limiter.messages.tokens -= float64(count)
+	}
+	if limiter.bytes != nil {
+		limiter.bytes.refill()
+		limiter.bytes.tokens -= float64(bytes)
+	}
+}
+
+// SetPeerRateLimit limits how quickly Messages are handed out to a peer, overriding our RateLimit for it.
+// Passing a zero RateLimit lifts the peer's limits altogether
+func (accord *Accord) SetPeerRateLimit(name string, limit RateLimit) {
+	accord.peers.mutex.Lock()
+	defer accord.peers.mutex.Unlock()
+
+	if accord.peers.limiters == nil {
+		accord.peers.limiters = map[string]*peerLimiter{}
+	}
+	accord.peers.limiters[name] = newPeerLimiter(limit)
+	if accord.peers.customLimits == nil {
+		accord.peers.customLimits = map[string]bool{}
+	}
+	accord.peers.customLimits[name] = true
+}
+
+// limiterFor returns a peer's limiter, setting it up from our RateLimit the first time it's asked for. It
+// returns nil when the peer isn't limited. The caller must hold the peers mutex
+func (accord *Accord) limiterFor(name string) *peerLimiter {
+	limiter, ok := accord.peers.limiters[name]
+	if !ok {
+		if accord.peers.limiters == nil {
+			accord.peers.limiters = map[string]*peerLimiter{}
+		}
+		limiter = newPeerLimiter(accord.RateLimit)
+		accord.peers.limiters[name] = limiter
+	}
+
+	if limiter.messages == nil && limiter.bytes == nil {
+		return nil
+	}
+	return limiter
+}
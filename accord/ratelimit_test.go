@@ -0,0 +1,56 @@
+package accord
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucket(t *testing.T) {
+	limiter := newPeerLimiter(RateLimit{MessagesPerSecond: 2, BytesPerSecond: 10})
+	msgs := []*Message{{Payload: []byte("12345678")}, {Payload: []byte("1234")}, {Payload: []byte("1")}}
+
+	// The bytes run out after the second Message, which is allowed to go into debt
+	assert.Equal(t, 2, limiter.allowed(msgs))
+	limiter.take(2, 12)
+	assert.Equal(t, 0, limiter.allowed(msgs))
+
+	// Paying off the debt takes a little while
+	limiter.bytes.last = limiter.bytes.last.Add(-300 * time.Millisecond)
+	limiter.messages.last = limiter.messages.last.Add(-300 * time.Millisecond)
+	assert.Equal(t, 0, limiter.allowed(msgs))
+	limiter.bytes.last = limiter.bytes.last.Add(-time.Second)
+	limiter.messages.last = limiter.messages.last.Add(-time.Second)
+	assert.Equal(t, 2, limiter.allowed(msgs))
+
+	assert.Nil(t, (&Accord{}).limiterFor("unlimited"))
+}
+
+func TestPeerRateLimit(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	accord.RateLimit = RateLimit{MessagesPerSecond: 2}
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	limited := accord.AddPeer("limited")
+	unlimited := accord.AddPeer("unlimited")
+	accord.SetPeerRateLimit("unlimited", RateLimit{})
+	for i := 0; i < 5; i++ {
+		msg, _ := NewMessage([]byte{byte(i)})
+		assert.Nil(t, accord.HandleNewMessage(msg))
+	}
+
+	msgs, err := limited.Peek(10)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(msgs))
+	assert.Nil(t, limited.Advance(len(msgs)))
+	msgs, err = limited.Peek(10)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(msgs))
+
+	msgs, err = unlimited.Peek(10)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(msgs))
+}
@@ -0,0 +1,104 @@
+package accord
+
+import (
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// ReadOnlyProbeInterval is how often, while we're read-only, we check whether our data directory has
+// become writable again
+const ReadOnlyProbeInterval = 10 * time.Second
+
+// writeProbeFilename is the file we create and remove to check whether our data directory is writable
+const writeProbeFilename = ".accord-write-probe"
+
+// Event kinds emitted when our data directory stops or starts being writable
+const (
+	EventReadOnly = "read_only"
+	EventWritable = "writable"
+)
+
+// ErrReadOnly is returned for any Message we're asked to handle while our data directory is read-only
+var ErrReadOnly = errors.New("the data directory is read-only, so no messages can be handled")
+
+// isReadOnlyError reports whether an error was caused by writing to a read-only filesystem. Disks that
+// fill up or start failing are often remounted read-only by the operating system, which is what this is
+// meant to catch
+func isReadOnlyError(err error) bool {
+	if errors.Is(err, syscall.EROFS) {
+		return true
+	}
+	// Not every layer between us and the disk wraps its errors, so fall back to the message
+	return strings.Contains(err.Error(), "read-only file system")
+}
+
+// failWrite handles an error writing to one of our stores. Most of these leave us in an unknown state, so
+// we blow ourselves up. A read-only filesystem is different: restarting won't help and would only leave us
+// crash looping, so instead we stop handling Messages and carry on serving what we already have
+func (accord *Accord) failWrite(err error, message string) error {
+	accord.recordError(err)
+	if isReadOnlyError(err) {
+		accord.enterReadOnly(err)
+		return ErrReadOnly
+	}
+
+	accord.Logger.WithError(err).Warn(message + ". Blowing up our application")
+	accord.Shutdown(err)
+	return err
+}
+
+// enterReadOnly switches us into our degraded read-only mode
+func (accord *Accord) enterReadOnly(err error) {
+	if !atomic.CompareAndSwapInt32(&accord.readOnly, 0, 1) {
+		return
+	}
+	accord.lastWriteProbe = time.Now()
+
+	accord.Logger.WithError(err).Error("The data directory has become read-only. No messages will be handled until it's writable again")
+	accord.Emit(EventReadOnly, "The data directory has become read-only", map[string]interface{}{"dataDir": accord.dataDir, "error": err.Error()})
+}
+
+// ReadOnly reports whether we're in our degraded read-only mode. While we are, every Message we're asked
+// to handle is refused with ErrReadOnly, but our history, queue and state can all still be read, so
+// Components can keep serving what we already have
+func (accord *Accord) ReadOnly() bool {
+	return atomic.LoadInt32(&accord.readOnly) == 1
+}
+
+// checkWritable refuses to go any further while we're read-only, unless enough time has passed that it's
+// worth checking whether the data directory has become writable again. The caller must hold processMutex
+func (accord *Accord) checkWritable() error {
+	if !accord.ReadOnly() {
+		return nil
+	}
+	if time.Since(accord.lastWriteProbe) < ReadOnlyProbeInterval {
+		return ErrReadOnly
+	}
+
+	accord.lastWriteProbe = time.Now()
+	err := accord.probeWritable()
+	if err != nil {
+		accord.Logger.WithError(err).Debug("The data directory is still read-only")
+		return ErrReadOnly
+	}
+
+	atomic.StoreInt32(&accord.readOnly, 0)
+	accord.Logger.Info("The data directory is writable again, resuming")
+	accord.Emit(EventWritable, "The data directory is writable again", map[string]interface{}{"dataDir": accord.dataDir})
+	return nil
+}
+
+// probeWritable checks whether we can write to our data directory
+func (accord *Accord) probeWritable() error {
+	probe := path.Join(accord.dataDir, writeProbeFilename)
+	err := os.WriteFile(probe, []byte("probe"), 0600)
+	if err != nil {
+		return err
+	}
+	return os.Remove(probe)
+}
@@ -0,0 +1,46 @@
+package accord
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsReadOnlyError(t *testing.T) {
+	assert.True(t, isReadOnlyError(&os.PathError{Op: "open", Path: "state.db", Err: syscall.EROFS}))
+	assert.True(t, isReadOnlyError(errors.New("write history.stack/000001.log: read-only file system")))
+	assert.False(t, isReadOnlyError(errors.New("disk on fire")))
+}
+
+func TestReadOnlyMode(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	kinds := []string{}
+	accord.Subscribe(func(event Event) {
+		kinds = append(kinds, event.Kind)
+	})
+
+	err := accord.failWrite(&os.PathError{Op: "write", Path: "state.db", Err: syscall.EROFS}, "We could not update our internal state")
+	assert.Equal(t, ErrReadOnly, err)
+	assert.True(t, accord.ReadOnly())
+
+	msg, _ := NewMessage([]byte("hello"))
+	assert.Equal(t, ErrReadOnly, accord.HandleNewMessage(msg))
+	assert.Equal(t, ErrReadOnly, accord.HandleRemoteMessage(msg))
+
+	// Reads should carry on working
+	assert.Equal(t, uint64(0), accord.History().Len())
+
+	// Once it's time to check again, we should notice the directory is writable and resume
+	accord.lastWriteProbe = time.Now().Add(-ReadOnlyProbeInterval)
+	assert.Nil(t, accord.HandleNewMessage(msg))
+	assert.False(t, accord.ReadOnly())
+	assert.Equal(t, []string{EventReadOnly, EventWritable}, kinds)
+}
@@ -0,0 +1,263 @@
+package accord
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Event kinds emitted by ReconnectingComponents as they lose and regain their connections
+const (
+	EventConnected     = "connected"
+	EventDisconnected  = "disconnected"
+	EventReconnectFail = "reconnect_failed"
+)
+
+// DefaultReconnectBackoff and DefaultMaxReconnectBackoff are how long a ReconnectingComponent waits before
+// dialing again by default. The wait doubles with every failed dial, up to the maximum
+const (
+	DefaultReconnectBackoff    = time.Second
+	DefaultMaxReconnectBackoff = time.Minute
+)
+
+// Backoff decides how long a ReconnectingComponent waits between failed dials
+type Backoff struct {
+	// Initial is how long we wait after the first failed dial, defaulting to DefaultReconnectBackoff. It
+	// doubles with every failed dial after that, up to Max (defaulting to DefaultMaxReconnectBackoff)
+	Initial time.Duration
+	Max     time.Duration
+
+	// Jitter is the fraction of each wait that's randomized, between 0 and 1, so that a whole cluster that
+	// lost the same peer doesn't come knocking at the same moment when it's back. A Jitter of 0.2 waits
+	// anywhere from 80% to 120% of the backoff
+	Jitter float64
+
+	// MaxRetries is how many dials in a row can fail before we give up and report the Component as failed
+	// (see ReportFailure), so that its RestartPolicy decides what happens next. We never give up if it's 0
+	MaxRetries int
+}
+
+// delay returns how long to wait after the given failed dial (counting from 1)
+func (backoff Backoff) delay(failure int) time.Duration {
+	delay := backoff.Initial
+	if delay <= 0 {
+		delay = DefaultReconnectBackoff
+	}
+	max := backoff.Max
+	if max <= 0 {
+		max = DefaultMaxReconnectBackoff
+	}
+
+	for i := 1; i < failure && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+
+	jitter := backoff.Jitter
+	if jitter > 1 {
+		jitter = 1
+	}
+	if jitter > 0 {
+		delay += time.Duration((rand.Float64()*2 - 1) * jitter * float64(delay))
+	}
+	return delay
+}
+
+// ReconnectingComponent is a helper that's meant to be embedded in transports that keep a connection to a
+// peer, in place of ComponentRunner, so that each of them doesn't have to get reconnecting right on its own.
+// It dials the peer, hands the connection to the tick function for as long as that keeps working, and once
+// it doesn't closes it and dials again after a Backoff. Along the way we emit an EventConnected and an
+// EventDisconnected, and an EventReconnectFail if we give up on ever reaching the peer.
+//
+// Embedding it makes a Component a HealthyComponent that's degraded while it's disconnected
+type ReconnectingComponent struct {
+	ComponentRunner
+
+	// Backoff decides how long we wait between failed dials
+	Backoff Backoff
+
+	accord *Accord
+	name   string
+	log    Logger
+
+	dial    func(*Accord) (io.Closer, error)
+	tick    func(*Accord, io.Closer) error
+	cleanup func(*Accord)
+
+	mutex    sync.Mutex
+	conn     io.Closer
+	failures int
+	nextDial time.Time
+	gaveUp   bool
+
+	// lastErr is why we were last disconnected, or why we last failed to dial
+	lastErr error
+}
+
+// reconnectPollInterval is the longest a ReconnectingComponent sleeps in one tick while it waits to dial
+// again, so that it can still be stopped promptly
+const reconnectPollInterval = 50 * time.Millisecond
+
+// InitReconnecting starts the Component, much like ComponentRunner's Init. The 'dial' function connects to
+// the peer, and the 'tick' function is called in a loop with the connection it returned, the same as
+// ComponentRunner's. When tick returns an error the connection is considered lost: it's closed and we dial
+// again once our Backoff has passed. name identifies the connection in our logs and events.
+//
+// The 'cleanup' function is optional, and is called once the connection (if we have one) has been closed
+// on our way out
+func (comp *ReconnectingComponent) InitReconnecting(acc *Accord, name string, dial func(*Accord) (io.Closer, error),
+	tick func(*Accord, io.Closer) error, cleanup func(*Accord), log Logger) {
+
+	if log == nil {
+		log = acc.Logger
+	}
+
+	comp.mutex.Lock()
+	comp.accord = acc
+	comp.name = name
+	comp.log = log.WithField("connection", name)
+	comp.dial = dial
+	comp.tick = tick
+	comp.cleanup = cleanup
+	comp.conn = nil
+	comp.failures = 0
+	comp.nextDial = time.Time{}
+	comp.gaveUp = false
+	comp.lastErr = nil
+	comp.mutex.Unlock()
+
+	comp.ComponentRunner.Init(acc, comp.reconnectingTick, comp.reconnectingCleanup, log)
+}
+
+func (comp *ReconnectingComponent) reconnectingTick(acc *Accord) {
+	comp.mutex.Lock()
+	conn := comp.conn
+	wait := time.Until(comp.nextDial)
+	gaveUp := comp.gaveUp
+	comp.mutex.Unlock()
+
+	// Once we've given up it's up to our supervisor to restart us, or to shut everything down
+	if gaveUp {
+		time.Sleep(reconnectPollInterval)
+		return
+	}
+
+	if conn == nil {
+		if wait > 0 {
+			if wait > reconnectPollInterval {
+				wait = reconnectPollInterval
+			}
+			time.Sleep(wait)
+			return
+		}
+		comp.connect(acc)
+		return
+	}
+
+	err := comp.tick(acc, conn)
+	if err != nil {
+		comp.disconnect(conn, err)
+	}
+}
+
+// connect dials our peer, backing off if that fails and giving up after too many failures in a row
+func (comp *ReconnectingComponent) connect(acc *Accord) {
+	conn, err := comp.dial(acc)
+	fields := map[string]interface{}{"connection": comp.name}
+
+	comp.mutex.Lock()
+	if err == nil {
+		comp.conn = conn
+		comp.failures = 0
+		comp.lastErr = nil
+		comp.mutex.Unlock()
+
+		comp.log.Info("Connected")
+		acc.Emit(EventConnected, "Connected to "+comp.name, fields)
+		return
+	}
+
+	comp.failures++
+	failures := comp.failures
+	comp.lastErr = err
+	giveUp := comp.Backoff.MaxRetries > 0 && failures >= comp.Backoff.MaxRetries
+	delay := comp.Backoff.delay(failures)
+	comp.nextDial = time.Now().Add(delay)
+	comp.gaveUp = giveUp
+	comp.mutex.Unlock()
+
+	log := comp.log.WithError(err).WithField("failures", failures)
+	if !giveUp {
+		log.WithField("backoff", delay).Warn("Unable to connect, trying again after a backoff")
+		return
+	}
+
+	log.Error("Unable to connect, giving up")
+	fields["error"] = err.Error()
+	fields["failures"] = failures
+	acc.Emit(EventReconnectFail, "Gave up on connecting to "+comp.name, fields)
+
+	// Our supervisor stops us before it restarts us, so it can't be waited on from within our own tick
+	go acc.componentFailed(&comp.ComponentRunner, fmt.Errorf("gave up on connecting to %s after %d tries: %v", comp.name, failures, err))
+}
+
+// disconnect closes a connection that stopped working, dialing again straight away. If that fails too
+// we back off as usual
+func (comp *ReconnectingComponent) disconnect(conn io.Closer, err error) {
+	comp.mutex.Lock()
+	comp.conn = nil
+	comp.lastErr = err
+	comp.nextDial = time.Time{}
+	comp.mutex.Unlock()
+
+	closeErr := conn.Close()
+	log := comp.log.WithError(err)
+	if closeErr != nil {
+		log = log.WithField("close_error", closeErr.Error())
+	}
+	log.Warn("Lost our connection, reconnecting")
+	comp.accord.Emit(EventDisconnected, "Lost our connection to "+comp.name,
+		map[string]interface{}{"connection": comp.name, "error": err.Error()})
+}
+
+func (comp *ReconnectingComponent) reconnectingCleanup(acc *Accord) {
+	comp.mutex.Lock()
+	conn := comp.conn
+	comp.conn = nil
+	comp.mutex.Unlock()
+
+	if conn != nil {
+		err := conn.Close()
+		if err != nil {
+			comp.log.WithError(err).Warn("Unable to close our connection")
+		}
+	}
+	if comp.cleanup != nil {
+		comp.cleanup(acc)
+	}
+}
+
+// Connected reports whether we currently have a working connection
+func (comp *ReconnectingComponent) Connected() bool {
+	comp.mutex.Lock()
+	defer comp.mutex.Unlock()
+	return comp.conn != nil
+}
+
+// Health implements HealthyComponent, reporting why we're disconnected if we are. We're considered healthy
+// while we dial for the first time
+func (comp *ReconnectingComponent) Health() error {
+	comp.mutex.Lock()
+	defer comp.mutex.Unlock()
+	if comp.conn != nil || comp.lastErr == nil {
+		return nil
+	}
+	if comp.gaveUp {
+		return fmt.Errorf("gave up on connecting after %d tries: %v", comp.failures, comp.lastErr)
+	}
+	return fmt.Errorf("disconnected: %v", comp.lastErr)
+}
@@ -0,0 +1,154 @@
+package accord
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeConn is a connection that can be told to break
+type fakeConn struct {
+	broken int32
+	closed int32
+}
+
+func (conn *fakeConn) Close() error {
+	atomic.StoreInt32(&conn.closed, 1)
+	return nil
+}
+
+// dialingComponent fails to dial a few times before connecting, and keeps track of its connections
+type dialingComponent struct {
+	ReconnectingComponent
+	failDials int32
+	dials     int32
+
+	mutex sync.Mutex
+	conns []*fakeConn
+}
+
+func (comp *dialingComponent) Start(accord *Accord) error {
+	comp.InitReconnecting(accord, "fake", func(*Accord) (io.Closer, error) {
+		if atomic.AddInt32(&comp.dials, 1) <= atomic.LoadInt32(&comp.failDials) {
+			return nil, errors.New("unreachable")
+		}
+		conn := &fakeConn{}
+		comp.mutex.Lock()
+		comp.conns = append(comp.conns, conn)
+		comp.mutex.Unlock()
+		return conn, nil
+	}, func(_ *Accord, conn io.Closer) error {
+		time.Sleep(time.Millisecond)
+		if atomic.LoadInt32(&conn.(*fakeConn).broken) == 1 {
+			return errors.New("connection reset")
+		}
+		return nil
+	}, nil, nil)
+	return nil
+}
+
+func (comp *dialingComponent) latest() *fakeConn {
+	comp.mutex.Lock()
+	defer comp.mutex.Unlock()
+	if len(comp.conns) == 0 {
+		return nil
+	}
+	return comp.conns[len(comp.conns)-1]
+}
+
+func TestBackoffDelay(t *testing.T) {
+	backoff := Backoff{Initial: time.Second, Max: 5 * time.Second}
+	assert.Equal(t, time.Second, backoff.delay(1))
+	assert.Equal(t, 2*time.Second, backoff.delay(2))
+	assert.Equal(t, 4*time.Second, backoff.delay(3))
+	assert.Equal(t, 5*time.Second, backoff.delay(4))
+	assert.Equal(t, DefaultReconnectBackoff, Backoff{}.delay(1))
+
+	backoff.Jitter = 0.5
+	for i := 0; i < 20; i++ {
+		delay := backoff.delay(2)
+		assert.True(t, delay >= time.Second && delay <= 3*time.Second)
+	}
+}
+
+func TestReconnectingComponent(t *testing.T) {
+	defer AccordCleanup()
+	comp := &dialingComponent{failDials: 2}
+	comp.Backoff = Backoff{Initial: 5 * time.Millisecond, Jitter: 0.2}
+	accord := NewAccord(NewDummerManager(), []Component{comp}, "", DummyAccord().Logger)
+
+	var connected, disconnected int32
+	accord.Subscribe(func(event Event) {
+		switch event.Kind {
+		case EventConnected:
+			atomic.AddInt32(&connected, 1)
+		case EventDisconnected:
+			atomic.AddInt32(&disconnected, 1)
+		}
+	})
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	assert.True(t, waitUntil(comp.Connected))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&comp.dials))
+	assert.Nil(t, comp.Health())
+	first := comp.latest()
+
+	// A broken connection is closed and replaced
+	atomic.StoreInt32(&first.broken, 1)
+	assert.True(t, waitUntil(func() bool { return comp.latest() != first && comp.Connected() }))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&first.closed))
+	assert.True(t, waitUntil(func() bool { return atomic.LoadInt32(&connected) == 2 }))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&disconnected))
+
+	// Stopping closes whatever connection we have
+	second := comp.latest()
+	comp.Stop(0)
+	comp.WaitForStop()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&second.closed))
+}
+
+func TestReconnectingComponentGivesUp(t *testing.T) {
+	defer AccordCleanup()
+	comp := &dialingComponent{failDials: 1000}
+	comp.Backoff = Backoff{Initial: time.Millisecond, MaxRetries: 3}
+	accord := NewAccord(NewDummerManager(), []Component{comp}, "", DummyAccord().Logger)
+	accord.DefaultRestartPolicy = RestartPolicy{Mode: RestartAlways, Backoff: 20 * time.Millisecond}
+
+	gaveUp := make(chan Event, 10)
+	restarted := make(chan bool, 10)
+	accord.Subscribe(func(event Event) {
+		switch event.Kind {
+		case EventReconnectFail:
+			gaveUp <- event
+		case EventComponentRestarted:
+			restarted <- true
+		}
+	})
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	select {
+	case event := <-gaveUp:
+		assert.Equal(t, "fake", event.Fields["connection"])
+		assert.Equal(t, 3, event.Fields["failures"])
+	case <-time.After(time.Second):
+		t.Fatal("we never gave up")
+	}
+	assert.NotNil(t, comp.Health())
+	assert.False(t, comp.Connected())
+
+	// Giving up hands us over to our RestartPolicy, which starts us dialing all over again
+	select {
+	case <-restarted:
+	case <-time.After(time.Second):
+		t.Fatal("the component was never restarted")
+	}
+	atomic.StoreInt32(&comp.failDials, 0)
+	assert.True(t, waitUntil(comp.Connected))
+}
@@ -0,0 +1,111 @@
+package accord
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Event kinds emitted when we reload our configuration
+const (
+	EventReloaded     = "reloaded"
+	EventReloadFailed = "reload_failed"
+)
+
+// ReloadFunc re-reads an application's configuration and applies whatever can be changed on the fly to a
+// running Accord, through the likes of SetLogLevel, SetRateLimit, SetRestartPolicy and the setters of
+// the Components themselves (like HTTPSync's SetPeers)
+type ReloadFunc func(accord *Accord) error
+
+// ReloadableComponent can be implemented by Components that have configuration of their own to re-read
+// whenever we reload (see Reload)
+type ReloadableComponent interface {
+	Component
+	Reload(accord *Accord) error
+}
+
+// Reload re-applies our configuration without restarting: OnReload is called first, then every Component
+// that implements ReloadableComponent. It's called for us whenever we receive a SIGHUP, as long as
+// OnReload was set before Start. The errors of everything that failed are returned together, but a
+// failure doesn't stop the rest from being reloaded
+func (accord *Accord) Reload() error {
+	accord.Logger.Info("Reloading our configuration")
+	errs := []error{}
+
+	if accord.OnReload != nil {
+		err := accord.OnReload(accord)
+		if err != nil {
+			accord.Logger.WithError(err).Warn("Unable to reload our configuration")
+			errs = append(errs, err)
+		}
+	}
+
+	accord.componentsMutex.Lock()
+	byName, names := accord.namedComponents()
+	accord.componentsMutex.Unlock()
+	for _, name := range names {
+		reloadable, ok := byName[name].(ReloadableComponent)
+		if !ok {
+			continue
+		}
+		err := reloadable.Reload(accord)
+		if err != nil {
+			accord.Logger.WithError(err).WithField("component", name).Warn("Unable to reload a component")
+			errs = append(errs, err)
+		}
+	}
+
+	err := errors.Join(errs...)
+	if err != nil {
+		accord.Emit(EventReloadFailed, "Reloading our configuration failed", map[string]interface{}{"error": err.Error()})
+		return err
+	}
+	accord.Emit(EventReloaded, "Our configuration was reloaded", nil)
+	return nil
+}
+
+// listenForReloads has SIGHUP reload us, if there's anything to reload
+func (accord *Accord) listenForReloads() {
+	accord.reloadChannel = make(chan os.Signal, 1)
+	if accord.OnReload == nil {
+		return
+	}
+	accord.Logger.Info("Reloading our configuration on SIGHUP")
+	signal.Notify(accord.reloadChannel, syscall.SIGHUP)
+}
+
+// SetLogLevel changes how much we log. Only Loggers that are LevelSetters can be changed, anything else
+// is left alone
+func (accord *Accord) SetLogLevel(level LogLevel) {
+	if setter, ok := accord.Logger.(LevelSetter); ok {
+		setter.SetLevel(level)
+	}
+}
+
+// SetRateLimit changes RateLimit while we're running. Peers that were given limits of their own with
+// SetPeerRateLimit keep them
+func (accord *Accord) SetRateLimit(limit RateLimit) {
+	accord.peers.mutex.Lock()
+	defer accord.peers.mutex.Unlock()
+
+	accord.RateLimit = limit
+	for name := range accord.peers.limiters {
+		if !accord.peers.customLimits[name] {
+			delete(accord.peers.limiters, name)
+		}
+	}
+}
+
+// SetRestartPolicy changes a Component's RestartPolicy while we're running
+func (accord *Accord) SetRestartPolicy(name string, policy RestartPolicy) {
+	accord.componentsMutex.Lock()
+	defer accord.componentsMutex.Unlock()
+
+	policies := map[string]RestartPolicy{}
+	for other, existing := range accord.RestartPolicies {
+		policies[other] = existing
+	}
+	policies[name] = policy
+	accord.RestartPolicies = policies
+}
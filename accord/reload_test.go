@@ -0,0 +1,119 @@
+package accord
+
+import (
+	"errors"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// reloadableComponent counts how often it's been reloaded
+type reloadableComponent struct {
+	reloads int
+	err     error
+}
+
+func (comp *reloadableComponent) Start(*Accord) error { return nil }
+func (comp *reloadableComponent) Stop(int)            {}
+func (comp *reloadableComponent) WaitForStop()        {}
+func (comp *reloadableComponent) Reload(*Accord) error {
+	comp.reloads++
+	return comp.err
+}
+
+func TestReload(t *testing.T) {
+	defer AccordCleanup()
+	comp := &reloadableComponent{}
+	accord := DummyAccord()
+	accord.components = []Component{comp}
+	level := &slog.LevelVar{}
+	accord.Logger = NewSlogLogger(slog.New(slog.NewTextHandler(ioutil.Discard, &slog.HandlerOptions{Level: level})), level)
+
+	reloads := 0
+	accord.OnReload = func(acc *Accord) error {
+		reloads++
+		acc.SetLogLevel(LogDebug)
+		return nil
+	}
+	assert.Nil(t, accord.Reload())
+	assert.Equal(t, 1, reloads)
+	assert.Equal(t, 1, comp.reloads)
+	assert.Equal(t, slog.LevelDebug, level.Level())
+
+	// Everything is still reloaded when something fails, and all of the failures are returned
+	first, second := errors.New("bad config"), errors.New("bad component")
+	accord.OnReload = func(*Accord) error {
+		reloads++
+		return first
+	}
+	comp.err = second
+	err := accord.Reload()
+	assert.True(t, errors.Is(err, first))
+	assert.True(t, errors.Is(err, second))
+	assert.Equal(t, 2, reloads)
+	assert.Equal(t, 2, comp.reloads)
+}
+
+func TestReloadOnSignal(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+
+	reloaded := make(chan struct{}, 1)
+	accord.OnReload = func(*Accord) error {
+		reloaded <- struct{}{}
+		return nil
+	}
+	assert.Nil(t, accord.Start())
+
+	listening := make(chan error)
+	go func() {
+		listening <- accord.Listen()
+	}()
+
+	assert.Nil(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+	select {
+	case <-reloaded:
+	case <-time.After(2 * time.Second):
+		t.Fatal("we weren't reloaded")
+	}
+
+	// We should still be listening after a reload
+	accord.Shutdown(nil)
+	assert.Nil(t, <-listening)
+}
+
+func TestSetRateLimit(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	accord.SetPeerRateLimit("custom", RateLimit{MessagesPerSecond: 1})
+	assert.Nil(t, accord.limiterFor("default"))
+
+	// Peers pick up the new default, but keep limits of their own
+	accord.SetRateLimit(RateLimit{MessagesPerSecond: 5})
+	msgs := make([]*Message, 10)
+	for i := range msgs {
+		msgs[i], _ = NewMessage([]byte("limited"))
+	}
+	assert.Equal(t, 5, accord.limiterFor("default").allowed(msgs))
+	assert.Equal(t, 1, accord.limiterFor("custom").allowed(msgs))
+}
+
+func TestSetRestartPolicy(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	original := map[string]RestartPolicy{"Other": {Mode: RestartAlways}}
+	accord.RestartPolicies = original
+
+	accord.SetRestartPolicy("Flaky", RestartPolicy{Mode: RestartLimited, MaxRestarts: 3})
+	assert.Equal(t, RestartLimited, accord.restartPolicyFor("Flaky").Mode)
+	assert.Equal(t, RestartAlways, accord.restartPolicyFor("Other").Mode)
+	assert.Equal(t, 1, len(original))
+}
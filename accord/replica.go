@@ -0,0 +1,25 @@
+package accord
+
+import "fmt"
+
+// ReplicaError is returned by HandleNewMessage (and everything built on it) while we're a Replica. Nothing
+// is wrong with the Message itself; it has to be performed on a node that's allowed to originate it
+type ReplicaError struct {
+	// NodeID is the replica that refused the Message
+	NodeID string
+
+	// Type is the type of the Message that was refused
+	Type string
+}
+
+func (err *ReplicaError) Error() string {
+	return fmt.Sprintf("node %s is a read-only replica, so it can't originate %q messages", err.NodeID, err.Type)
+}
+
+// refuseIfReplica returns a ReplicaError for a new Message if we're a Replica
+func (accord *Accord) refuseIfReplica(msg *Message) error {
+	if !accord.Replica {
+		return nil
+	}
+	return &ReplicaError{NodeID: accord.NodeID, Type: msg.Type}
+}
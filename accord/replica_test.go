@@ -0,0 +1,29 @@
+package accord
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplica(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	accord.NodeID = "replica"
+	accord.Replica = true
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	msg, _ := NewMessage([]byte("local"))
+	msg.Type = "user.create"
+	err := accord.HandleNewMessage(msg)
+	assert.Equal(t, &ReplicaError{NodeID: "replica", Type: "user.create"}, err)
+	assert.Equal(t, uint64(0), accord.History().Len())
+	assert.Equal(t, uint64(0), accord.QueuedMessages())
+
+	// Our peers' Messages are performed all the same
+	msg, _ = NewMessage([]byte("remote"))
+	msg.Origin = "primary"
+	assert.Nil(t, accord.HandleRemoteMessage(msg))
+	assert.Equal(t, uint64(1), accord.History().Len())
+}
@@ -0,0 +1,226 @@
+package accord
+
+import (
+	"strconv"
+
+	"github.com/beeker1121/goque"
+)
+
+// Decision is the outcome of a ConflictStrategy
+type Decision int
+
+const (
+	// Abstain means the strategy has no opinion, leaving the decision to the next strategy
+	Abstain Decision = iota
+
+	// Accept means the incoming Message should be processed
+	Accept
+
+	// Reject means the incoming Message should be skipped
+	Reject
+)
+
+// ConflictStrategy decides what should happen when a remote Message conflicts with a Message we've
+// already performed locally (meaning both affect the same entity and neither was created with knowledge
+// of the other)
+type ConflictStrategy func(incoming Message, local Message) Decision
+
+// DefaultResolveScanLimit is how many Messages a Resolver looks through at most when it can't use our
+// KeyIndex and ScanLimit isn't set
+const DefaultResolveScanLimit = 1000
+
+// Resolver is a reusable implementation of the conflict resolution half of the Manager interface. It
+// finds the most recent Message in our history for the same entity as the incoming one and, if the two
+// were created concurrently, asks each of its strategies in turn what to do. Managers can embed a Resolver
+// to get a ShouldProcess implementation for free:
+//
+//	type MyManager struct {
+//		accord.Resolver
+//	}
+//
+//	manager := &MyManager{Resolver: accord.Resolver{Strategies: []accord.ConflictStrategy{accord.LastWriterWins()}}}
+//
+// Both the incoming Message and the most recent one for its entity are looked up in our indexes (see IDIndex
+// and KeyIndex), so resolving doesn't get any slower as our history grows
+type Resolver struct {
+	// Key groups Messages into the entities they affect. When left nil the Message's Key field is used.
+	// Messages with an empty key never conflict with anything
+	Key func(msg Message) string
+
+	// Strategies are asked, in order, what to do with a conflicting Message. The first one that doesn't
+	// abstain decides
+	Strategies []ConflictStrategy
+
+	// Fallback is the decision used when every strategy abstains. Its zero value (Abstain) is treated
+	// as Accept
+	Fallback Decision
+
+	// ScanLimit is how many of our most recent Messages we look through when our indexes can't be used,
+	// because the History isn't one of ours or, for finding the most recent Message for an entity, because
+	// Key is set. Anything further back than that is treated as something we know nothing about. It
+	// defaults to DefaultResolveScanLimit
+	ScanLimit int
+}
+
+// ShouldProcess implements the Manager method of the same name
+func (resolver Resolver) ShouldProcess(msg Message, history *goque.Stack) bool {
+	return resolver.Resolve(msg, NewHistory(history)) != Reject
+}
+
+// Resolve decides what should be done with an incoming Message given our history
+func (resolver Resolver) Resolve(incoming Message, history *History) Decision {
+	// We've already performed this exact Message, there's no sense in doing it again
+	if resolver.performed(incoming.ID, history) {
+		return Reject
+	}
+
+	// Either it isn't grouped into an entity at all, or nothing else has touched this one
+	key := resolver.keyOf(incoming)
+	if key == "" {
+		return Accept
+	}
+	local := resolver.latest(key, history)
+	if local == nil {
+		return Accept
+	}
+
+	switch local.Clock.Compare(incoming.Clock) {
+	case ClockBefore:
+		// The incoming Message was created knowing about ours, so it's simply newer
+		return Accept
+	case ClockAfter, ClockEqual:
+		// We've already moved past the incoming Message
+		return Reject
+	}
+
+	for _, strategy := range resolver.Strategies {
+		decision := strategy(incoming, *local)
+		if decision != Abstain {
+			return decision
+		}
+	}
+
+	if resolver.Fallback == Abstain {
+		return Accept
+	}
+	return resolver.Fallback
+}
+
+// performed returns whether the Message with the given ID is in our history
+func (resolver Resolver) performed(id uint64, history *History) bool {
+	if history.state != nil {
+		return history.Lookup(IDIndex, strconv.FormatUint(id, 10)).Next()
+	}
+
+	iter := resolver.scan(history)
+	for iter.Next() {
+		if iter.Message().ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// latest returns the most recent Message in our history with the given key, if we can find one
+func (resolver Resolver) latest(key string, history *History) *Message {
+	var iter *HistoryIterator
+	if resolver.Key == nil && history.state != nil {
+		iter = history.Lookup(KeyIndex, key)
+	} else {
+		iter = resolver.scan(history)
+	}
+
+	for iter.Next() {
+		if msg := iter.Message(); resolver.keyOf(*msg) == key {
+			return msg
+		}
+	}
+	return nil
+}
+
+// scan returns an iterator over as many of our most recent Messages as we look through when our indexes
+// can't be used
+func (resolver Resolver) scan(history *History) *HistoryIterator {
+	limit := resolver.ScanLimit
+	if limit <= 0 {
+		limit = DefaultResolveScanLimit
+	}
+	return history.Query(HistoryFilter{Limit: limit})
+}
+
+func (resolver Resolver) keyOf(msg Message) string {
+	if resolver.Key != nil {
+		return resolver.Key(msg)
+	}
+	return msg.Key
+}
+
+// LastWriterWins resolves conflicts by keeping whichever Message has the later hybrid logical clock
+// timestamp. Ties (which should only happen between separate nodes) are broken by Origin so that every
+// node makes the same choice
+func LastWriterWins() ConflictStrategy {
+	return func(incoming Message, local Message) Decision {
+		switch incoming.HLC.Compare(local.HLC) {
+		case 1:
+			return Accept
+		case -1:
+			return Reject
+		}
+
+		if incoming.Origin > local.Origin {
+			return Accept
+		}
+		return Reject
+	}
+}
+
+// OriginPriority resolves conflicts by ranking the nodes that created the Messages, listed from highest
+// priority to lowest. It abstains if neither node is listed, and treats an unlisted node as the lowest
+// priority otherwise
+func OriginPriority(origins ...string) ConflictStrategy {
+	rank := make(map[string]int, len(origins))
+	for i, origin := range origins {
+		rank[origin] = len(origins) - i
+	}
+
+	return func(incoming Message, local Message) Decision {
+		incomingRank, localRank := rank[incoming.Origin], rank[local.Origin]
+		switch {
+		case incomingRank == localRank:
+			return Abstain
+		case incomingRank > localRank:
+			return Accept
+		default:
+			return Reject
+		}
+	}
+}
+
+// FieldMerge resolves conflicts at the level of individual fields. The fields function reports which
+// fields of an entity a Message changes; if the two Messages change entirely separate fields they don't
+// really conflict and the incoming Message is accepted. Otherwise merge is called for every field both
+// changed: any rejection rejects the Message, and it's only accepted if every field was accepted
+func FieldMerge(fields func(msg Message) []string, merge func(field string, incoming Message, local Message) Decision) ConflictStrategy {
+	return func(incoming Message, local Message) Decision {
+		localFields := make(map[string]bool)
+		for _, field := range fields(local) {
+			localFields[field] = true
+		}
+
+		decision := Accept
+		for _, field := range fields(incoming) {
+			if !localFields[field] {
+				continue
+			}
+
+			switch merge(field, incoming, local) {
+			case Reject:
+				return Reject
+			case Abstain:
+				decision = Abstain
+			}
+		}
+
+		return decision
+	}
+}
@@ -0,0 +1,99 @@
+package accord
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLastWriterWins(t *testing.T) {
+	lww := LastWriterWins()
+	older := Message{HLC: HLCTimestamp{Wall: 1}, Origin: "a"}
+	newer := Message{HLC: HLCTimestamp{Wall: 2}, Origin: "a"}
+
+	assert.Equal(t, Accept, lww(newer, older))
+	assert.Equal(t, Reject, lww(older, newer))
+
+	// Ties are broken by origin so every node agrees
+	tieA := Message{HLC: HLCTimestamp{Wall: 1}, Origin: "a"}
+	tieB := Message{HLC: HLCTimestamp{Wall: 1}, Origin: "b"}
+	assert.Equal(t, Accept, lww(tieB, tieA))
+	assert.Equal(t, Reject, lww(tieA, tieB))
+}
+
+func TestOriginPriority(t *testing.T) {
+	priority := OriginPriority("hub", "edge")
+
+	assert.Equal(t, Accept, priority(Message{Origin: "hub"}, Message{Origin: "edge"}))
+	assert.Equal(t, Reject, priority(Message{Origin: "edge"}, Message{Origin: "hub"}))
+	assert.Equal(t, Accept, priority(Message{Origin: "edge"}, Message{Origin: "unknown"}))
+	assert.Equal(t, Abstain, priority(Message{Origin: "x"}, Message{Origin: "y"}))
+}
+
+func TestFieldMerge(t *testing.T) {
+	fields := func(msg Message) []string {
+		result := []string{}
+		for field := range msg.Headers {
+			result = append(result, field)
+		}
+		return result
+	}
+	merge := FieldMerge(fields, func(field string, incoming Message, local Message) Decision {
+		if field == "name" {
+			return Accept
+		}
+		return Reject
+	})
+
+	local := Message{Headers: map[string]string{"name": "", "email": ""}}
+	assert.Equal(t, Accept, merge(Message{Headers: map[string]string{"phone": ""}}, local))
+	assert.Equal(t, Accept, merge(Message{Headers: map[string]string{"name": ""}}, local))
+	assert.Equal(t, Reject, merge(Message{Headers: map[string]string{"name": "", "email": ""}}, local))
+}
+
+func TestResolver(t *testing.T) {
+	defer AccordCleanup()
+
+	accord := DummyAccord()
+	accord.NodeID = "local"
+	accord.Start()
+	defer accord.Stop()
+
+	local := &Message{ID: 1, Key: "doc"}
+	assert.Nil(t, accord.HandleNewMessage(local))
+
+	resolver := Resolver{Strategies: []ConflictStrategy{LastWriterWins()}}
+	history := accord.History()
+
+	// Already performed
+	assert.Equal(t, Reject, resolver.Resolve(*local, history))
+
+	// Different entity
+	assert.Equal(t, Accept, resolver.Resolve(Message{ID: 2, Key: "other", Clock: VectorClock{"remote": 1}}, history))
+
+	// Created with knowledge of our message
+	after := Message{ID: 3, Key: "doc", Clock: VectorClock{"local": 1, "remote": 1}, HLC: HLCTimestamp{Wall: 1}}
+	assert.Equal(t, Accept, resolver.Resolve(after, history))
+
+	// Concurrent, so the strategies get to decide
+	concurrentNewer := Message{ID: 4, Key: "doc", Clock: VectorClock{"remote": 1}, HLC: HLCTimestamp{Wall: local.HLC.Wall + 1}}
+	concurrentOlder := Message{ID: 5, Key: "doc", Clock: VectorClock{"remote": 1}, HLC: HLCTimestamp{Wall: 1}}
+	assert.Equal(t, Accept, resolver.Resolve(concurrentNewer, history))
+	assert.Equal(t, Reject, resolver.Resolve(concurrentOlder, history))
+	assert.False(t, resolver.ShouldProcess(concurrentOlder, accord.historyStack))
+
+	// With no strategies we fall back to accepting
+	assert.Equal(t, Accept, Resolver{}.Resolve(concurrentOlder, history))
+	assert.Equal(t, Reject, Resolver{Fallback: Reject}.Resolve(concurrentOlder, history))
+
+	// Our own keys can't be looked up in our indexes, so we only look through as far back as ScanLimit
+	assert.Nil(t, accord.HandleNewMessage(&Message{ID: 6, Key: "unrelated"}))
+	byKey := func(msg Message) string { return msg.Key }
+	assert.Equal(t, Reject, Resolver{Key: byKey, Fallback: Reject}.Resolve(concurrentOlder, history))
+	assert.Equal(t, Accept, Resolver{Key: byKey, Fallback: Reject, ScanLimit: 1}.Resolve(concurrentOlder, history))
+
+	// Neither can anything in a History that isn't ours
+	raw := &History{stack: accord.historyStack}
+	assert.Equal(t, Reject, resolver.Resolve(*local, raw))
+	assert.Equal(t, Accept, Resolver{ScanLimit: 1}.Resolve(*local, raw))
+}
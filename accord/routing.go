@@ -0,0 +1,27 @@
+package accord
+
+import "github.com/beeker1121/goque"
+
+// Handle routes every Message of the given Type to a Manager of its own, rather than having one Manager
+// deal with every type there is. A Manager registered for a Message's Type takes precedence over the
+// Manager of the channel it's on; Messages whose Type has no Manager of its own still go to the one passed
+// to NewAccord (or their channel's), which can be left nil when every type is routed. Registering a type
+// again replaces its Manager. Like RegisterStage, this should be called before Start
+func (accord *Accord) Handle(msgType string, manager Manager) {
+	if accord.typeManagers == nil {
+		accord.typeManagers = map[string]Manager{}
+	}
+	accord.typeManagers[msgType] = manager
+}
+
+// unhandledManager is the Manager for Messages nothing has been registered to deal with. It lets them
+// through ShouldProcess so that Process fails them with an UnhandledTypeError, just as a TypedManager would
+type unhandledManager struct{}
+
+func (unhandledManager) Process(msg *Message, fromRemote bool) error {
+	return &UnhandledTypeError{Type: msg.Type}
+}
+
+func (unhandledManager) ShouldProcess(msg Message, history *goque.Stack) bool {
+	return true
+}
@@ -0,0 +1,50 @@
+package accord
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandle(t *testing.T) {
+	defer AccordCleanup()
+	users, orders, telemetry := &countingManager{}, &countingManager{}, &countingManager{}
+	fallback := &recordingManager{}
+	accord := DummyAccord()
+	accord.manager = fallback
+	accord.Channels = map[string]Manager{"telemetry": telemetry}
+	accord.Handle("user.update", users)
+	accord.Handle("order.create", orders)
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	msg, _ := NewMessage([]byte("user"))
+	msg.Type = "user.update"
+	assert.Nil(t, accord.HandleNewMessage(msg))
+	msg, _ = NewMessage([]byte("order"))
+	msg.Type, msg.Origin = "order.create", "remote"
+	assert.Nil(t, accord.HandleRemoteMessage(msg))
+	msg, _ = NewMessage([]byte("other"))
+	msg.Type = "user.delete"
+	assert.Nil(t, accord.HandleNewMessage(msg))
+	assert.Equal(t, 1, users.processed)
+	assert.Equal(t, 1, orders.processed)
+	assert.Len(t, fallback.processed, 1)
+
+	// A Manager registered for a type takes precedence over the channel's
+	msg = channelMessage("telemetry")
+	msg.Type = "user.update"
+	assert.Nil(t, accord.HandleNewMessage(msg))
+	msg = channelMessage("telemetry")
+	msg.Type = "cpu"
+	assert.Nil(t, accord.HandleNewMessage(msg))
+	assert.Equal(t, 2, users.processed)
+	assert.Equal(t, 1, telemetry.processed)
+
+	// Without a Manager to fall back on, types nobody handles can't be processed
+	routed := NewAccord(nil, nil, "", accord.Logger)
+	routed.Handle("user.update", users)
+	assert.Equal(t, users, routed.managerFor(&Message{Type: "user.update"}))
+	msg = &Message{Type: "user.delete"}
+	assert.Equal(t, &UnhandledTypeError{Type: "user.delete"}, routed.managerFor(msg).Process(msg, false))
+}
@@ -0,0 +1,300 @@
+package accord
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// scheduledPrefix is where Messages waiting for their time to come (see HandleNewMessageAt) are kept in our
+// state database. Their keys start with when they're due, so that they're kept in the order they're due in
+const scheduledPrefix = "scheduled/"
+
+// Event kinds emitted as Messages are scheduled, released when they're due, and dropped because they
+// couldn't be handled when they were
+const (
+	EventMessageScheduled  = "message_scheduled"
+	EventScheduledReleased = "scheduled_released"
+	EventScheduledFailed   = "scheduled_failed"
+)
+
+// DefaultScheduleRetry is how long we wait before trying to release a due Message again, when handling it
+// failed for a reason that should pass (see HandleNewMessageAt)
+const DefaultScheduleRetry = time.Second
+
+// ErrNotScheduled is returned by CancelScheduled for a Message that isn't waiting to be handled
+var ErrNotScheduled = errors.New("no message with that ID is scheduled")
+
+// ScheduledMessage is a Message waiting to be handled at a later time (see HandleNewMessageAt)
+type ScheduledMessage struct {
+	At      time.Time
+	Message *Message
+}
+
+// schedule keeps track of what wakes up our scheduler
+type schedule struct {
+	mutex sync.Mutex
+
+	// wake is signalled whenever a Message is scheduled, in case it's due before the one we were waiting on,
+	// and done is closed once our scheduler has stopped
+	wake chan struct{}
+	done chan struct{}
+
+	// seq tells apart Messages scheduled for the same moment. It's seeded from the clock when we start so
+	// that it carries on past the ones scheduled before we were restarted
+	seq uint64
+}
+
+// scheduledTransient are the errors handling a due Message can fail with that should pass, so it's tried
+// again rather than dropped
+var scheduledTransient = []error{ErrPaused, ErrDraining, ErrReadOnly, ErrDiskQuota, ErrQueueFull}
+
+// HandleNewMessageAt is HandleNewMessage for a Message that shouldn't take effect until at, like a
+// configuration change that should be applied across the fleet at midnight. The Message is written down in
+// our state straight away, so that it survives a restart, but it isn't applied, stamped or queued to be
+// synchronized until it's due. A Message that's already due is simply handled straight away.
+//
+// Once it's due the Message is handled just like any other new Message. If that fails because we're
+// paused, draining, read-only, over our DiskQuota or our queue is full, it's tried again every
+// DefaultScheduleRetry; if it fails for any other reason it's dropped and an EventScheduledFailed is
+// emitted. Due Messages are only released while we're running, so ones that came due while we were
+// stopped are released as soon as we start. Going down just after a Message was handled but before it
+// was forgotten means it's handled again when we start, so give Messages that mustn't be applied twice an
+// IdempotencyKey
+func (accord *Accord) HandleNewMessageAt(msg *Message, at time.Time) error {
+	if !at.After(time.Now()) {
+		return accord.HandleNewMessage(msg)
+	}
+
+	// Whatever would be refused no matter when it's handled is refused now rather than when it's due
+	err := accord.refuseIfReplica(msg)
+	if err != nil {
+		return err
+	}
+	if accord.channelFor(msg.Channel) == nil {
+		return ErrUnknownChannel
+	}
+	err = accord.validateNew(msg)
+	if err != nil {
+		return err
+	}
+
+	// Scheduled Messages are re-encrypted along with the rest of our state (see RotateEncryptionKey)
+	accord.state.mutex.RLock()
+	data, err := accord.sealer.encodeMessage(msg)
+	if err == nil {
+		err = accord.state.db.Put(scheduledKey(at, atomic.AddUint64(&accord.schedule.seq, 1)), data, accord.state.writeOptions)
+	}
+	accord.state.mutex.RUnlock()
+	if err != nil {
+		WithMessage(accord.Logger, msg).WithError(err).Warn("We could not schedule a message")
+		return err
+	}
+
+	WithMessage(accord.Logger, msg).WithField("at", at).Debug("Scheduled a new message")
+	accord.Emit(EventMessageScheduled, "A new message was scheduled", map[string]interface{}{"id": msg.ID, "at": at.UTC()})
+	accord.wakeSchedule()
+	return nil
+}
+
+// ScheduledMessages returns every Message waiting to be handled, in the order they're due in
+func (accord *Accord) ScheduledMessages() ([]ScheduledMessage, error) {
+	scheduled := []ScheduledMessage{}
+	err := accord.eachScheduled(func(key []byte, at time.Time, msg *Message) bool {
+		scheduled = append(scheduled, ScheduledMessage{At: at, Message: msg})
+		return true
+	})
+	return scheduled, err
+}
+
+// CancelScheduled forgets about a Message waiting to be handled, so that it never is. It returns
+// ErrNotScheduled if no Message with the given ID is waiting, including one that's already been released
+func (accord *Accord) CancelScheduled(id uint64) error {
+	var found []byte
+	err := accord.eachScheduled(func(key []byte, at time.Time, msg *Message) bool {
+		if msg.ID == id {
+			found = append([]byte{}, key...)
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if found == nil {
+		return ErrNotScheduled
+	}
+
+	accord.Logger.WithField("id", id).Info("Cancelling a scheduled message")
+	return accord.state.db.Delete(found, accord.state.writeOptions)
+}
+
+// scheduledKey is where a Message due at the given time is kept
+func scheduledKey(at time.Time, seq uint64) []byte {
+	key := make([]byte, len(scheduledPrefix)+16)
+	copy(key, scheduledPrefix)
+	binary.BigEndian.PutUint64(key[len(scheduledPrefix):], uint64(at.UnixNano()))
+	binary.BigEndian.PutUint64(key[len(scheduledPrefix)+8:], seq)
+	return key
+}
+
+// scheduledAt returns when the Message kept at key is due
+func scheduledAt(key []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(key[len(scheduledPrefix):])))
+}
+
+// eachScheduled calls fn with every Message waiting to be handled, in the order they're due in, until fn
+// returns false
+func (accord *Accord) eachScheduled(fn func(key []byte, at time.Time, msg *Message) bool) error {
+	iter := accord.state.db.NewIterator(util.BytesPrefix([]byte(scheduledPrefix)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		if len(iter.Key()) != len(scheduledPrefix)+16 {
+			continue
+		}
+		msg, err := accord.sealer.decodeMessage(iter.Value())
+		if err != nil {
+			return err
+		}
+		if !fn(iter.Key(), scheduledAt(iter.Key()), msg) {
+			break
+		}
+	}
+	return iter.Error()
+}
+
+// wakeSchedule tells our scheduler to take another look at what's due, if it's running
+func (accord *Accord) wakeSchedule() {
+	accord.schedule.mutex.Lock()
+	defer accord.schedule.mutex.Unlock()
+	if accord.schedule.wake == nil {
+		return
+	}
+	select {
+	case accord.schedule.wake <- struct{}{}:
+	default:
+	}
+}
+
+// runSchedule releases scheduled Messages as they come due, until we stop
+func (accord *Accord) runSchedule() {
+	wake := make(chan struct{}, 1)
+	done := make(chan struct{})
+	accord.schedule.mutex.Lock()
+	accord.schedule.wake, accord.schedule.done = wake, done
+	accord.schedule.mutex.Unlock()
+	atomic.StoreUint64(&accord.schedule.seq, uint64(time.Now().UnixNano()))
+
+	stopped := accord.stopContext.Done()
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stopped:
+				return
+			default:
+			}
+			wait := accord.releaseDue(stopped)
+
+			// With nothing waiting there's no timer to fire, so we only wake up for a newly scheduled Message
+			var timer *time.Timer
+			var fired <-chan time.Time
+			if wait > 0 {
+				timer = time.NewTimer(wait)
+				fired = timer.C
+			}
+			select {
+			case <-fired:
+			case <-wake:
+			case <-stopped:
+			}
+			if timer != nil {
+				timer.Stop()
+			}
+		}
+	}()
+}
+
+// stopSchedule waits for our scheduler to notice we're stopping
+func (accord *Accord) stopSchedule() {
+	accord.schedule.mutex.Lock()
+	done := accord.schedule.done
+	accord.schedule.wake, accord.schedule.done = nil, nil
+	accord.schedule.mutex.Unlock()
+	if done != nil {
+		<-done
+	}
+}
+
+// releaseDue handles every scheduled Message that's due, returning how long until the next one is (or 0
+// if there's nothing left waiting)
+func (accord *Accord) releaseDue(stopped <-chan struct{}) time.Duration {
+	type due struct {
+		key []byte
+		msg *Message
+	}
+	now := time.Now()
+	ready := []due{}
+	var next time.Time
+	err := accord.eachScheduled(func(key []byte, at time.Time, msg *Message) bool {
+		if at.After(now) {
+			next = at
+			return false
+		}
+		ready = append(ready, due{key: append([]byte{}, key...), msg: msg})
+		return true
+	})
+	if err != nil {
+		accord.Logger.WithError(err).Warn("Unable to read our scheduled messages")
+		return DefaultScheduleRetry
+	}
+
+	for _, item := range ready {
+		select {
+		case <-stopped:
+			return 0
+		default:
+		}
+
+		err := accord.HandleNewMessage(item.msg)
+		if scheduledRetryable(err) {
+			WithMessage(accord.Logger, item.msg).WithError(err).Debug("A scheduled message is due but can't be handled yet")
+			return DefaultScheduleRetry
+		}
+		if err != nil {
+			WithMessage(accord.Logger, item.msg).WithError(err).Warn("Dropping a scheduled message that failed when it was due")
+			accord.Emit(EventScheduledFailed, "A scheduled message failed when it was due", map[string]interface{}{"id": item.msg.ID, "error": err.Error()})
+		} else {
+			accord.Emit(EventScheduledReleased, "A scheduled message was handled", map[string]interface{}{"id": item.msg.ID})
+		}
+
+		err = accord.state.db.Delete(item.key, accord.state.writeOptions)
+		if err != nil {
+			WithMessage(accord.Logger, item.msg).WithError(err).Warn("Unable to forget a scheduled message once it was handled")
+			return DefaultScheduleRetry
+		}
+	}
+
+	if next.IsZero() {
+		return 0
+	}
+	if wait := time.Until(next); wait > 0 {
+		return wait
+	}
+	// The next one came due while we were busy with the others
+	return time.Nanosecond
+}
+
+// scheduledRetryable reports whether a due Message that failed with err should be tried again later
+func scheduledRetryable(err error) bool {
+	for _, transient := range scheduledTransient {
+		if err == transient {
+			return true
+		}
+	}
+	return false
+}
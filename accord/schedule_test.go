@@ -0,0 +1,85 @@
+package accord
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleNewMessageAt(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	// Messages that are already due are handled straight away
+	assert.Nil(t, accord.HandleNewMessageAt(&Message{ID: 1}, time.Now().Add(-time.Minute)))
+	assert.Equal(t, uint64(1), accord.History().Len())
+
+	later := time.Now().Add(time.Hour)
+	soon := time.Now().Add(20 * time.Millisecond)
+	assert.Nil(t, accord.HandleNewMessageAt(&Message{ID: 3}, later))
+	assert.Nil(t, accord.HandleNewMessageAt(&Message{ID: 2}, soon))
+	assert.Equal(t, uint64(1), accord.History().Len())
+
+	scheduled, err := accord.ScheduledMessages()
+	assert.Nil(t, err)
+	assert.Len(t, scheduled, 2)
+	assert.Equal(t, uint64(2), scheduled[0].Message.ID)
+	assert.Equal(t, soon.UnixNano(), scheduled[0].At.UnixNano())
+	assert.Equal(t, uint64(3), scheduled[1].Message.ID)
+
+	assert.True(t, waitUntil(func() bool { return accord.History().Len() == 2 }))
+	latest, err := accord.History().Get(0)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), latest.ID)
+	assert.Equal(t, accord.NodeID, latest.Origin)
+	scheduled, err = accord.ScheduledMessages()
+	assert.Nil(t, err)
+	assert.Len(t, scheduled, 1)
+
+	assert.Nil(t, accord.CancelScheduled(3))
+	assert.Equal(t, ErrNotScheduled, accord.CancelScheduled(3))
+	scheduled, err = accord.ScheduledMessages()
+	assert.Nil(t, err)
+	assert.Empty(t, scheduled)
+
+	assert.Equal(t, ErrUnknownChannel, accord.HandleNewMessageAt(&Message{ID: 4, Channel: "missing"}, later))
+}
+
+func TestScheduledMessagesSurviveRestart(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	assert.Nil(t, accord.Start())
+	assert.Nil(t, accord.HandleNewMessageAt(&Message{ID: 1}, time.Now().Add(10*time.Millisecond)))
+	accord.Stop()
+
+	// It came due while we were stopped, so it's released as soon as we start again
+	time.Sleep(20 * time.Millisecond)
+	accord = DummyAccord()
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+	assert.True(t, waitUntil(func() bool { return accord.History().Len() == 1 }))
+}
+
+func TestScheduledMessageWhilePaused(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	accord.Pause()
+	assert.Nil(t, accord.HandleNewMessageAt(&Message{ID: 1}, time.Now().Add(5*time.Millisecond)))
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, uint64(0), accord.History().Len())
+	scheduled, err := accord.ScheduledMessages()
+	assert.Nil(t, err)
+	assert.Len(t, scheduled, 1)
+
+	// Being paused doesn't last, so the Message is tried again rather than dropped. We wake the scheduler
+	// rather than waiting out DefaultScheduleRetry
+	accord.Resume()
+	accord.wakeSchedule()
+	assert.True(t, waitUntil(func() bool { return accord.History().Len() == 1 }))
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+
+package accord
+
+import (
+	"os"
+)
+
+// RunService runs us as a Windows service called name, taking the place of StartAndListen (see the Windows
+// version for the details). Everywhere else there's no such thing, so it's the same as StartAndListen
+func (accord *Accord) RunService(name string, signals ...os.Signal) error {
+	return accord.StartAndListen(signals...)
+}
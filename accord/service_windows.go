@@ -0,0 +1,100 @@
+//go:build windows
+
+package accord
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+// RunService runs us under the Windows service control manager as the service called name, taking the
+// place of StartAndListen. Stopping the service (or shutting down Windows) stops us, and pausing and
+// continuing it calls Pause and Resume. We're reported as having stopped with an error if one of our
+// Components shuts us down. When we aren't being run as a service, as when started from a console, it's
+// the same as StartAndListen. On other platforms it's always the same as StartAndListen
+func (accord *Accord) RunService(name string, signals ...os.Signal) error {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		return err
+	}
+	if !isService {
+		return accord.StartAndListen(signals...)
+	}
+
+	accord.Logger.WithField("service", name).Info("Running as a Windows service")
+	handler := &serviceHandler{accord: accord, signals: signals}
+	err = svc.Run(name, handler)
+	if err != nil {
+		return err
+	}
+	return handler.err
+}
+
+// serviceHandler maps the requests of the service control manager onto our lifecycle
+type serviceHandler struct {
+	accord  *Accord
+	signals []os.Signal
+
+	// err is why we stopped, if it was because of an error
+	err error
+}
+
+// Execute is called by the service control manager to run the service, which it considers stopped once
+// this returns
+func (handler *serviceHandler) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	accord := handler.accord
+	const accepts = svc.AcceptStop | svc.AcceptShutdown | svc.AcceptPauseAndContinue
+
+	status <- svc.Status{State: svc.StartPending}
+	err := accord.Start(handler.signals...)
+	if err != nil {
+		handler.err = err
+		return true, 1
+	}
+	status <- svc.Status{State: svc.Running, Accepts: accepts}
+
+	listening := make(chan error, 1)
+	go func() {
+		listening <- accord.Listen()
+	}()
+
+	for {
+		select {
+		case err := <-listening:
+			// One of our Components shut us down
+			handler.err = err
+			if err != nil {
+				return true, 1
+			}
+			return false, 0
+
+		case request := <-requests:
+			switch request.Cmd {
+			case svc.Interrogate:
+				status <- request.CurrentStatus
+
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				// Listen stops us once it's sent a signal, just as it would for the real thing
+				select {
+				case accord.signalChannel <- os.Interrupt:
+				default:
+				}
+				handler.err = <-listening
+				return false, 0
+
+			case svc.Pause:
+				accord.Pause()
+				status <- svc.Status{State: svc.Paused, Accepts: accepts}
+
+			case svc.Continue:
+				accord.Resume()
+				status <- svc.Status{State: svc.Running, Accepts: accepts}
+
+			default:
+				accord.Logger.WithField("request", request.Cmd).Warn("Ignoring an unexpected request from the service control manager")
+			}
+		}
+	}
+}
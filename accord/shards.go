@@ -0,0 +1,146 @@
+package accord
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/beeker1121/goque"
+)
+
+// shard is one of the queues a channel is split across (see QueueShards)
+type shard struct {
+	channel *channel
+	index   int
+	path    string
+	queue   *goque.Queue
+
+	// mutex serializes writes to the queue, so that Messages sharing a shard are queued in the order they
+	// were applied even once processMutex has been let go of (see handleNewMessage)
+	mutex sync.Mutex
+
+	// pending holds the Messages waiting to be written to the queue while we batch our writes (see
+	// FlushInterval), and tail is the ID of the last one that was. Both are guarded by mutex
+	pending [][]byte
+	tail    uint64
+}
+
+// queuedItem is where a Message was queued
+type queuedItem struct {
+	shard *shard
+	id    uint64
+}
+
+// queueShards returns how many shards new Messages are spread across
+func (accord *Accord) queueShards() int {
+	if accord.QueueShards <= 0 {
+		return 1
+	}
+	return accord.QueueShards
+}
+
+// shardPath returns where a queue's shard is stored. The first shard is the queue itself, so a node that
+// never sharded its queue carries on using the one it has
+func shardPath(base string, index int) string {
+	if index == 0 {
+		return base
+	}
+	return base + "." + strconv.Itoa(index)
+}
+
+// openShards opens the shards of a channel's queue. Any shards left over from when we were configured with
+// more of them are opened as well, so that their Messages still get sent; nothing new is queued on them
+func (accord *Accord) openShards(ch *channel, base string) error {
+	for index := 0; ; index++ {
+		if index >= accord.queueShards() {
+			if _, err := os.Stat(shardPath(base, index)); err != nil {
+				return nil
+			}
+			accord.Logger.WithField("channel", ch.name).WithField("shard", index).Warn("Opening a queue shard we no longer use so that it can drain")
+		}
+
+		dir := shardPath(base, index)
+		var queue *goque.Queue
+		err := accord.openStore(dir, func() (err error) {
+			queue, err = goque.OpenQueue(dir)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		tail, err := queueTail(queue)
+		if err != nil {
+			queue.Close()
+			return err
+		}
+		ch.shards = append(ch.shards, &shard{channel: ch, index: index, path: dir, queue: queue, tail: tail})
+	}
+}
+
+// queueTail returns the ID of the last item in a queue that was just opened. goque starts counting again
+// from the beginning when it opens an empty queue, so there's nothing to go on but what's in it
+func queueTail(queue *goque.Queue) (uint64, error) {
+	head, err := queue.Peek()
+	if err == goque.ErrEmpty {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return head.ID + queue.Length() - 1, nil
+}
+
+// shardFor returns the shard a new Message is queued on. Messages with the same Key always end up on the
+// same shard, which is what keeps them in order. Those without one are spread out by their ID
+func (accord *Accord) shardFor(ch *channel, msg *Message) *shard {
+	count := accord.queueShards()
+	if count == 1 {
+		return ch.shards[0]
+	}
+
+	hash := fnv.New32a()
+	if msg.Key != "" {
+		hash.Write([]byte(msg.Key))
+	} else {
+		id := make([]byte, 8)
+		binary.BigEndian.PutUint64(id, msg.ID)
+		hash.Write(id)
+	}
+	return ch.shards[int(hash.Sum32()%uint32(count))]
+}
+
+// allShards returns every shard of every one of our channels
+func (accord *Accord) allShards() []*shard {
+	shards := []*shard{}
+	for _, ch := range accord.sortedChannels() {
+		shards = append(shards, ch.shards...)
+	}
+	return shards
+}
+
+// length returns how many Messages are waiting across all of the channel's shards
+func (ch *channel) length() uint64 {
+	total := uint64(0)
+	for _, shard := range ch.shards {
+		total += shard.queue.Length()
+	}
+	return total
+}
+
+// Shards returns a cursor into each shard of the cursor's channel (see QueueShards). Each shard is a queue
+// of its own that a peer works its way through independently, so transports need to go through all of
+// them; a cursor returned by AddPeer, Peer or Channel only covers the first
+func (cursor *PeerCursor) Shards() []*PeerCursor {
+	cursors := []*PeerCursor{}
+	for _, shard := range cursor.accord.channelFor(cursor.channel).shards {
+		cursors = append(cursors, &PeerCursor{name: cursor.name, channel: cursor.channel, shard: shard.index, accord: cursor.accord})
+	}
+	return cursors
+}
+
+// queue returns the shard the cursor is into
+func (cursor *PeerCursor) queue() *shard {
+	return cursor.accord.channelFor(cursor.channel).shards[cursor.shard]
+}
@@ -0,0 +1,59 @@
+package accord
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueueShards(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	accord.QueueShards = 4
+	assert.Nil(t, accord.Start())
+
+	edge := accord.AddPeer("edge")
+	for i := 0; i < 20; i++ {
+		msg, _ := NewMessage([]byte(fmt.Sprint(i)))
+		msg.Key = fmt.Sprintf("user-%d", i%5)
+		assert.Nil(t, accord.HandleNewMessage(msg))
+	}
+	assert.Equal(t, uint64(20), accord.Queue().Len())
+	assert.Equal(t, uint64(20), edge.Pending())
+
+	// Every shard is worked through on its own, and each Key stays on a single shard, in order
+	shards := edge.Shards()
+	assert.Equal(t, 4, len(shards))
+	lastSeq := map[string]uint64{}
+	shardOf := map[string]int{}
+	for i, cursor := range shards {
+		msgs, err := cursor.Peek(100)
+		assert.Nil(t, err)
+		for _, msg := range msgs {
+			if shard, ok := shardOf[msg.Key]; ok {
+				assert.Equal(t, shard, i)
+			}
+			assert.Equal(t, lastSeq[msg.Key]+1, msg.KeySeq)
+			shardOf[msg.Key] = i
+			lastSeq[msg.Key] = msg.KeySeq
+		}
+		assert.Nil(t, cursor.Advance(len(msgs)))
+	}
+	assert.Equal(t, 5, len(lastSeq))
+	assert.Equal(t, uint64(0), accord.Queue().Len())
+
+	// Shards we no longer use are still opened, so whatever is left on them gets sent
+	msg, _ := NewMessage([]byte("left behind"))
+	msg.Key = "user-1"
+	assert.Nil(t, accord.HandleNewMessage(msg))
+	accord.Stop()
+
+	accord = DummyAccord()
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+	assert.Equal(t, uint64(1), accord.Queue().Len())
+	edge = accord.AddPeer("edge")
+	assert.Equal(t, 4, len(edge.Shards()))
+	assert.Equal(t, uint64(1), edge.Pending())
+}
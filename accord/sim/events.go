@@ -0,0 +1,60 @@
+package sim
+
+import (
+	"time"
+
+	"github.com/Ssawa/accord/accord"
+)
+
+// eventKind is something that happens on the network
+type eventKind int
+
+const (
+	// eventTick is a node shipping Messages to its peers
+	eventTick eventKind = iota
+
+	// eventDeliver is a Message arriving at a node
+	eventDeliver
+
+	// eventAck and eventNack are a node hearing whether a Message it shipped arrived
+	eventAck
+	eventNack
+)
+
+// event is something that happens at a particular point in virtual time. Events happening at the same time
+// happen in the order they were scheduled in
+type event struct {
+	kind eventKind
+	at   time.Duration
+	seq  uint64
+
+	// from and to are the nodes involved, from being the one that sent the Message
+	from string
+	to   string
+
+	msg *accord.Message
+	id  uint64
+}
+
+// eventQueue is a heap of events, soonest first
+type eventQueue []*event
+
+func (queue eventQueue) Len() int { return len(queue) }
+
+func (queue eventQueue) Less(i, j int) bool {
+	if queue[i].at != queue[j].at {
+		return queue[i].at < queue[j].at
+	}
+	return queue[i].seq < queue[j].seq
+}
+
+func (queue eventQueue) Swap(i, j int) { queue[i], queue[j] = queue[j], queue[i] }
+
+func (queue *eventQueue) Push(x interface{}) { *queue = append(*queue, x.(*event)) }
+
+func (queue *eventQueue) Pop() interface{} {
+	old := *queue
+	last := old[len(old)-1]
+	*queue = old[:len(old)-1]
+	return last
+}
@@ -0,0 +1,348 @@
+// Package sim runs any number of Accord nodes in one process over a simulated network, for testing
+// synchronization and conflict resolution deterministically. The network delivers Messages with a
+// configurable latency, jitter (which reorders them), chance of being dropped and partitions between
+// nodes, all driven by a seeded random number generator and a virtual clock. Running the same simulation
+// with the same seed makes every one of the same decisions in the same order, so a failure it turns up
+// can be replayed.
+//
+// A simulation looks something like:
+//
+//	simulation := sim.New(dir, sim.Config{Seed: 42, Latency: 5 * time.Millisecond, DropRate: 0.1}, logger)
+//	defer simulation.Close()
+//	a, _ := simulation.AddNode("a", managerA)
+//	b, _ := simulation.AddNode("b", managerB)
+//
+//	a.HandleNewMessage(msg)
+//	simulation.Partition([]string{"a"}, []string{"b"})
+//	simulation.Run(time.Second)
+//	simulation.Heal()
+//	err := simulation.RunUntilSettled(time.Minute)
+//
+// Nodes don't run any Components; the simulation plays the part of their transport, shipping Messages to
+// each of their peers (see accord.PeerCursor.Ship) on every tick
+package sim
+
+import (
+	"container/heap"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/Ssawa/accord/accord"
+)
+
+// ErrNodeExists is returned by AddNode for a name that's already taken
+var ErrNodeExists = errors.New("a node with that name already exists")
+
+// ErrNotSettled is returned by RunUntilSettled when the nodes still haven't caught up with each other
+var ErrNotSettled = errors.New("the nodes didn't settle in time")
+
+// Config describes a simulated network
+type Config struct {
+	// Seed seeds every random decision the simulation makes
+	Seed int64
+
+	// Latency is how long every Message (and acknowledgement) takes to arrive, plus a random amount up to
+	// Jitter. Jitter is what lets Messages overtake each other
+	Latency time.Duration
+	Jitter  time.Duration
+
+	// DropRate is the chance, from 0 to 1, of any one Message or acknowledgement being lost
+	DropRate float64
+
+	// TickInterval is how often each node ships Messages to its peers, defaulting to 10ms
+	TickInterval time.Duration
+
+	// RetryAfter is how long a node waits to hear back about a Message before shipping it again, defaulting
+	// to four times Latency plus Jitter (or TickInterval, if that's longer)
+	RetryAfter time.Duration
+
+	// BatchSize is how many Messages a node ships to each peer per tick, defaulting to 10
+	BatchSize int
+
+	// Configure, if it's set, is called with each node before it's started, which is the place to set its
+	// Ordering and so on. The network delivers Messages at least once, so nodes see duplicates unless
+	// they're set up to drop them
+	Configure func(node *accord.Accord)
+}
+
+// Stats counts what happened on the simulated network
+type Stats struct {
+	// Sent is how many Messages were shipped, including ones that were sent again
+	Sent int
+
+	// Delivered is how many arrived, Dropped how many were lost on the way (whether to DropRate or a
+	// partition) and AcksDropped how many acknowledgements were
+	Delivered   int
+	Dropped     int
+	AcksDropped int
+}
+
+// Simulation is a set of Accord nodes on a simulated network. It isn't safe to use from multiple
+// goroutines
+type Simulation struct {
+	config Config
+	dir    string
+	logger accord.Logger
+	rng    *rand.Rand
+
+	now    time.Duration
+	seq    uint64
+	events eventQueue
+
+	nodes map[string]*accord.Accord
+	names []string
+
+	// groups holds which side of a partition each node is on, if we're partitioned
+	groups map[string]int
+
+	stats Stats
+}
+
+// New creates a simulation whose nodes keep their data under dir
+func New(dir string, config Config, logger accord.Logger) *Simulation {
+	if config.TickInterval <= 0 {
+		config.TickInterval = 10 * time.Millisecond
+	}
+	if config.RetryAfter <= 0 {
+		config.RetryAfter = 4 * (config.Latency + config.Jitter)
+		if config.RetryAfter < config.TickInterval {
+			config.RetryAfter = config.TickInterval
+		}
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 10
+	}
+
+	return &Simulation{
+		config: config,
+		dir:    dir,
+		logger: logger,
+		rng:    rand.New(rand.NewSource(config.Seed)),
+		nodes:  map[string]*accord.Accord{},
+	}
+}
+
+// AddNode creates and starts a node with the given name as its NodeID, peered with every other node. It's
+// started with no Components, as the simulation is its transport
+func (sim *Simulation) AddNode(name string, manager accord.Manager) (*accord.Accord, error) {
+	if _, ok := sim.nodes[name]; ok {
+		return nil, ErrNodeExists
+	}
+
+	dir := filepath.Join(sim.dir, name)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	node := accord.NewAccord(manager, nil, dir, sim.logger.WithField("node", name))
+	node.NodeID = name
+	// Acknowledgements are timed on our virtual clock, not the wall clock
+	node.InFlightTimeout = 24 * time.Hour
+	if sim.config.Configure != nil {
+		sim.config.Configure(node)
+	}
+	err = node.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, other := range sim.names {
+		node.AddPeer(other)
+		sim.nodes[other].AddPeer(name)
+	}
+	sim.nodes[name] = node
+	sim.names = append(sim.names, name)
+	sort.Strings(sim.names)
+
+	sim.schedule(sim.config.TickInterval, &event{kind: eventTick, from: name})
+	return node, nil
+}
+
+// Node returns the node with the given name, or nil if there isn't one
+func (sim *Simulation) Node(name string) *accord.Accord {
+	return sim.nodes[name]
+}
+
+// Now returns how much virtual time has passed since the simulation began
+func (sim *Simulation) Now() time.Duration {
+	return sim.now
+}
+
+// Stats returns what's happened on the network so far
+func (sim *Simulation) Stats() Stats {
+	return sim.stats
+}
+
+// Partition splits the network into groups of nodes that can only reach the other nodes of their own
+// group. Nodes that aren't listed can't reach anyone
+func (sim *Simulation) Partition(groups ...[]string) {
+	sim.groups = map[string]int{}
+	for i, group := range groups {
+		for _, name := range group {
+			sim.groups[name] = i + 1
+		}
+	}
+}
+
+// Heal ends a partition, so that every node can reach every other again
+func (sim *Simulation) Heal() {
+	sim.groups = nil
+}
+
+// reachable reports whether a node can currently reach another
+func (sim *Simulation) reachable(from string, to string) bool {
+	if sim.groups == nil {
+		return true
+	}
+	return sim.groups[from] != 0 && sim.groups[from] == sim.groups[to]
+}
+
+// Step runs the next thing that happens on the network, moving the virtual clock along to when it does. It
+// returns false if there's nothing left to happen, which is only the case without any nodes
+func (sim *Simulation) Step() (bool, error) {
+	if sim.events.Len() == 0 {
+		return false, nil
+	}
+	next := heap.Pop(&sim.events).(*event)
+	sim.now = next.at
+	return true, sim.handle(next)
+}
+
+// Run runs the simulation until d more virtual time has passed
+func (sim *Simulation) Run(d time.Duration) error {
+	until := sim.now + d
+	for sim.events.Len() > 0 && sim.events[0].at <= until {
+		_, err := sim.Step()
+		if err != nil {
+			return err
+		}
+	}
+	sim.now = until
+	return nil
+}
+
+// RunUntilSettled runs the simulation until every node has caught up with every other: nothing is left in
+// anyone's queue or held back, and nothing is in flight. It gives up with ErrNotSettled once max virtual
+// time has passed
+func (sim *Simulation) RunUntilSettled(max time.Duration) error {
+	until := sim.now + max
+	for !sim.settled() {
+		if sim.events.Len() == 0 || sim.events[0].at > until {
+			return ErrNotSettled
+		}
+		_, err := sim.Step()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// settled reports whether every node has caught up with every other
+func (sim *Simulation) settled() bool {
+	for _, event := range sim.events {
+		if event.kind != eventTick {
+			return false
+		}
+	}
+	for _, name := range sim.names {
+		node := sim.nodes[name]
+		if node.QueuedMessages() > 0 || node.HeldBack() > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Close stops every node
+func (sim *Simulation) Close() {
+	for _, name := range sim.names {
+		sim.nodes[name].Stop()
+	}
+}
+
+// handle does whatever an event calls for
+func (sim *Simulation) handle(next *event) error {
+	switch next.kind {
+	case eventTick:
+		sim.schedule(sim.config.TickInterval, &event{kind: eventTick, from: next.from})
+		return sim.ship(next.from)
+
+	case eventDeliver:
+		err := sim.nodes[next.to].HandleRemoteMessage(next.msg)
+		if _, rejected := err.(*accord.StageError); err != nil && !rejected {
+			// The sender hears about it the same way it would over a real transport, by not hearing back
+			sim.logger.WithError(err).WithField("from", next.from).WithField("to", next.to).Debug("A simulated delivery failed")
+			sim.schedule(sim.config.RetryAfter, &event{kind: eventNack, from: next.from, to: next.to, id: next.msg.ID})
+			return nil
+		}
+		if sim.lost(next.to, next.from) {
+			sim.stats.AcksDropped++
+			sim.schedule(sim.config.RetryAfter, &event{kind: eventNack, from: next.from, to: next.to, id: next.msg.ID})
+			return nil
+		}
+		sim.schedule(sim.latency(), &event{kind: eventAck, from: next.from, to: next.to, id: next.msg.ID})
+		return nil
+
+	case eventAck:
+		return sim.nodes[next.from].Peer(next.to).Ack(next.id)
+
+	case eventNack:
+		return sim.nodes[next.from].Peer(next.to).Nack(next.id)
+	}
+	return nil
+}
+
+// ship sends whatever a node has for each of its peers
+func (sim *Simulation) ship(from string) error {
+	node := sim.nodes[from]
+	for _, to := range sim.names {
+		if to == from {
+			continue
+		}
+		msgs, err := node.Peer(to).Ship(sim.config.BatchSize)
+		if err != nil {
+			return err
+		}
+		for _, msg := range msgs {
+			sim.stats.Sent++
+			if sim.lost(from, to) {
+				sim.stats.Dropped++
+				sim.schedule(sim.config.RetryAfter, &event{kind: eventNack, from: from, to: to, id: msg.ID})
+				continue
+			}
+			// The receiver gets a copy of its own, as it would off the wire
+			copied := *msg
+			sim.schedule(sim.latency(), &event{kind: eventDeliver, from: from, to: to, msg: &copied})
+		}
+	}
+	return nil
+}
+
+// lost decides whether something sent from one node to another is lost on the way
+func (sim *Simulation) lost(from string, to string) bool {
+	// The random number is drawn either way, so that partitions don't change the decisions made after them
+	dropped := sim.rng.Float64() < sim.config.DropRate
+	return dropped || !sim.reachable(from, to)
+}
+
+// latency decides how long something takes to arrive
+func (sim *Simulation) latency() time.Duration {
+	latency := sim.config.Latency
+	if sim.config.Jitter > 0 {
+		latency += time.Duration(sim.rng.Int63n(int64(sim.config.Jitter)))
+	}
+	return latency
+}
+
+// schedule queues up an event to happen after d
+func (sim *Simulation) schedule(d time.Duration, next *event) {
+	sim.seq++
+	next.at = sim.now + d
+	next.seq = sim.seq
+	heap.Push(&sim.events, next)
+}
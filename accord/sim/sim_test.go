@@ -0,0 +1,76 @@
+package sim
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/Ssawa/accord/accord"
+	"github.com/Ssawa/accord/accord/accordtest"
+	"github.com/stretchr/testify/assert"
+)
+
+// simulate runs three nodes that each create messages, with one of them partitioned away for a while, and
+// returns what every node ended up processing along with the network's stats
+func simulate(t *testing.T, seed int64) (map[string][]string, Stats) {
+	dir, err := ioutil.TempDir("", "accord-sim")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	config := Config{Seed: seed, Latency: 5 * time.Millisecond, Jitter: 20 * time.Millisecond, DropRate: 0.2}
+	config.Configure = func(node *accord.Accord) { node.Ordering = accord.OrderCausal }
+	simulation := New(dir, config, accordtest.Logger())
+	defer simulation.Close()
+
+	managers := map[string]*accordtest.Manager{}
+	for _, name := range []string{"a", "b", "c"} {
+		managers[name] = &accordtest.Manager{}
+		_, err := simulation.AddNode(name, managers[name])
+		assert.Nil(t, err)
+	}
+	_, err = simulation.AddNode("a", &accordtest.Manager{})
+	assert.Equal(t, ErrNodeExists, err)
+
+	create := func(round int) {
+		for _, name := range []string{"a", "b", "c"} {
+			msg, _ := accord.NewMessage([]byte(fmt.Sprintf("%s-%d", name, round)))
+			assert.Nil(t, simulation.Node(name).HandleNewMessage(msg))
+		}
+	}
+
+	create(1)
+	simulation.Partition([]string{"a", "b"}, []string{"c"})
+	assert.Nil(t, simulation.Run(200*time.Millisecond))
+	create(2)
+	assert.Nil(t, simulation.Run(200*time.Millisecond))
+	simulation.Heal()
+	create(3)
+	assert.Nil(t, simulation.RunUntilSettled(time.Minute))
+
+	processed := map[string][]string{}
+	for name, manager := range managers {
+		payloads := manager.Payloads()
+		sort.Strings(payloads)
+		processed[name] = payloads
+	}
+	return processed, simulation.Stats()
+}
+
+func TestSimulation(t *testing.T) {
+	processed, stats := simulate(t, 1)
+
+	// Every node ends up with every message exactly once, whatever the network did to them
+	expected := []string{"a-1", "a-2", "a-3", "b-1", "b-2", "b-3", "c-1", "c-2", "c-3"}
+	for _, name := range []string{"a", "b", "c"} {
+		assert.Equal(t, expected, processed[name], name)
+	}
+	assert.True(t, stats.Dropped > 0)
+	assert.True(t, stats.Sent >= 18)
+
+	// The same seed makes the same decisions
+	_, again := simulate(t, 1)
+	assert.Equal(t, stats, again)
+}
@@ -0,0 +1,390 @@
+package accord
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// SnapshotVersion is the version of the snapshot format we write. We refuse to import snapshots written
+// with a newer version than we understand
+const SnapshotVersion = 1
+
+// ErrNotEmpty is returned by ImportSnapshot when there's already data in our stores
+var ErrNotEmpty = errors.New("snapshots can only be imported into an empty node")
+
+// SnapshotHeader describes the node a snapshot was taken from
+type SnapshotHeader struct {
+	Version   int
+	NodeID    string
+	CreatedAt time.Time
+
+	// State, Digest and Clock are the node's state when the snapshot was taken
+	State  uint64
+	Digest Digest
+	Clock  VectorClock
+
+	HistoryLen uint64
+	QueueLen   uint64
+}
+
+// Snapshot is a fully read snapshot, as returned by ReadSnapshot
+type Snapshot struct {
+	Header SnapshotHeader
+
+	// History holds the Messages in the node's history, from the oldest to the most recent
+	History []Message
+
+	// Queue holds the Messages that were waiting to be synchronized, from the oldest to the newest
+	Queue []Message
+}
+
+// snapshotRecord is a single entry following the header in a snapshot stream. Exactly one of its fields
+// is set, except for the final record which has none and marks the end of the snapshot
+type snapshotRecord struct {
+	History *Message
+	Queued  *Message
+	State   *snapshotStateEntry
+}
+
+// snapshotStateEntry is a raw entry out of our state database
+type snapshotStateEntry struct {
+	Key   []byte
+	Value []byte
+}
+
+// snapshotSkippedPrefixes are the parts of our state database that don't belong in a snapshot. Indexes
+// point at history item IDs, which won't be the same once imported, so they're rebuilt instead. Bans and
+// Component data are particular to the node that made them, as are the Messages it has scheduled (which it
+// still creates itself once they're due), and peer cursors point at queue item IDs too. The chunks we're
+// holding on to (see ChunkSize) make up Messages we haven't handled yet, which aren't in it either.
+// How many Messages our state has been updated with is simply the length of the imported history (plus
+// whatever was pruned from it), and whether the exporting node was stopped cleanly has nothing to do with us
+var snapshotSkippedPrefixes = []string{indexPrefix, indexBuiltPrefix, banPrefix, componentDataPrefix, scheduledPrefix, cursorPrefix, chunkPrefix, appliedKey, openKey}
+
+// ExportSnapshot writes our history, synchronization queue and state to w, so that a new node can be
+// bootstrapped from this one instead of replaying every Message from the beginning of time. We stop
+// processing Messages while the snapshot is written, so the history and state it captures are always
+// consistent with each other. Our Components keep running, however, so a Message being sent off while we
+// export might make it into the snapshot's queue as well; remotes will simply drop it as a duplicate
+func (accord *Accord) ExportSnapshot(w io.Writer) error {
+	accord.processMutex.Lock()
+	defer accord.processMutex.Unlock()
+
+	// The state and queues are read straight from disk, so nothing can be left in our buffers
+	err := accord.flush()
+	if err != nil {
+		return err
+	}
+
+	// Anything pruned from our history is only counted in our state, which the importing node takes on
+	// as its own (see PruneHistory)
+	pruned, stale, err := accord.historyBaseline()
+	if err != nil {
+		return err
+	}
+	pruned.Through = 0
+
+	history := accord.History()
+	header := SnapshotHeader{
+		Version:    SnapshotVersion,
+		NodeID:     accord.NodeID,
+		CreatedAt:  time.Now().UTC(),
+		State:      accord.state.GetCurrent(),
+		Digest:     accord.state.Digest(),
+		Clock:      accord.state.Clock(),
+		HistoryLen: history.Len() - stale,
+		QueueLen:   accord.QueuedMessages(),
+	}
+
+	encoder := gob.NewEncoder(w)
+	err = encoder.Encode(header)
+	if err != nil {
+		return err
+	}
+
+	// The history is written oldest first, so that it can be pushed straight back onto a stack when it's
+	// imported
+	for offset := header.HistoryLen; offset > 0; offset-- {
+		msg, err := history.Get(offset - 1)
+		if err != nil {
+			return err
+		}
+		err = encoder.Encode(snapshotRecord{History: msg})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Queued Messages know which channel they're on, so every channel's queue is written one after the other
+	for _, ch := range accord.sortedChannels() {
+		var encodeErr error
+		err = ch.view(accord.sealer).walk(func(msg *Message) bool {
+			encodeErr = encoder.Encode(snapshotRecord{Queued: msg})
+			return encodeErr == nil
+		})
+		if err != nil {
+			return err
+		}
+		if encodeErr != nil {
+			return encodeErr
+		}
+	}
+
+	iter := accord.state.db.NewIterator(nil, nil)
+	defer iter.Release()
+	for iter.Next() {
+		if skippedInSnapshot(string(iter.Key())) {
+			continue
+		}
+
+		// The iterator reuses its buffers, so we need our own copies. Snapshots are for other nodes, which
+		// have keys of their own, so they're never encrypted
+		entry := &snapshotStateEntry{
+			Key:   append([]byte{}, iter.Key()...),
+			Value: append([]byte{}, iter.Value()...),
+		}
+		if strings.HasPrefix(string(entry.Key), userPrefix) {
+			entry.Value, err = accord.sealer.open(entry.Value)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Our history's item IDs won't be the same once imported, so what was pruned from it starts over
+		if string(entry.Key) == prunedKey {
+			var buf bytes.Buffer
+			err = gob.NewEncoder(&buf).Encode(pruned)
+			if err != nil {
+				return err
+			}
+			entry.Value = buf.Bytes()
+		}
+		err = encoder.Encode(snapshotRecord{State: entry})
+		if err != nil {
+			return err
+		}
+	}
+	if iter.Error() != nil {
+		return iter.Error()
+	}
+
+	return encoder.Encode(snapshotRecord{})
+}
+
+// ExportSnapshotFile is a convenience wrapper around ExportSnapshot that writes the snapshot to a file at
+// the given path
+func (accord *Accord) ExportSnapshotFile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	err = accord.ExportSnapshot(file)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// ImportSnapshot loads a snapshot written by ExportSnapshot into this node. It's meant for bootstrapping
+// a brand new node, so our history and queue must be empty, otherwise ErrNotEmpty is returned. It should
+// be called after Start, before we've been sent any Messages.
+//
+// The snapshot's queue is imported along with everything else, which is what you want when restoring a
+// node from its own snapshot. When bootstrapping a different node from it, the queued Messages will be
+// sent off a second time; our remotes will simply drop them as duplicates. Use Bootstrap to leave the
+// queue behind
+func (accord *Accord) ImportSnapshot(r io.Reader) error {
+	return accord.importSnapshot(r, true)
+}
+
+// Bootstrap loads a snapshot taken on another node into this brand new one, so that it can join a cluster
+// that's been running for a long time without every Message being replayed to it. It's ImportSnapshot,
+// except that the other node's queue is left out: the other node sends those Messages on itself, and
+// there's no reason for us to send them all over again. From then on we only need the Messages performed
+// after the snapshot was taken, which we synchronize as usual (see HTTPSync's BootstrapFrom)
+func (accord *Accord) Bootstrap(r io.Reader) error {
+	return accord.importSnapshot(r, false)
+}
+
+// importSnapshot imports a snapshot, along with its queue if withQueue is set
+func (accord *Accord) importSnapshot(r io.Reader, withQueue bool) error {
+	accord.processMutex.Lock()
+	defer accord.processMutex.Unlock()
+
+	if accord.historyStack.Length() > 0 || accord.QueuedMessages() > 0 {
+		return ErrNotEmpty
+	}
+
+	decoder := gob.NewDecoder(r)
+	header := SnapshotHeader{}
+	err := decoder.Decode(&header)
+	if err != nil {
+		return err
+	}
+	if header.Version > SnapshotVersion {
+		return fmt.Errorf("snapshot version %d is newer than the version we understand (%d)", header.Version, SnapshotVersion)
+	}
+
+	log := accord.Logger.WithField("source", header.NodeID).WithField("createdAt", header.CreatedAt)
+	log.Info("Importing snapshot")
+
+	batch := new(leveldb.Batch)
+	for {
+		record := snapshotRecord{}
+		err = decoder.Decode(&record)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case record.History != nil:
+			data, err := accord.sealer.encodeMessage(record.History)
+			if err != nil {
+				return err
+			}
+			_, err = accord.historyStack.Push(data)
+			if err != nil {
+				return err
+			}
+
+		case record.Queued != nil:
+			if !withQueue {
+				continue
+			}
+			ch := accord.channelFor(record.Queued.Channel)
+			if ch == nil {
+				return ErrUnknownChannel
+			}
+			data, err := accord.sealer.encodeMessage(record.Queued)
+			if err != nil {
+				return err
+			}
+			_, err = accord.enqueue(accord.shardFor(ch, record.Queued), data)
+			if err != nil {
+				return err
+			}
+
+		case record.State != nil:
+			if skippedInSnapshot(string(record.State.Key)) {
+				continue
+			}
+			value := record.State.Value
+			if strings.HasPrefix(string(record.State.Key), userPrefix) {
+				value, err = accord.sealer.seal(value)
+				if err != nil {
+					return err
+				}
+			}
+			batch.Put(record.State.Key, value)
+
+		default:
+			return accord.finishImport(batch, header)
+		}
+	}
+}
+
+// finishImport writes the imported state and brings everything we keep derived from it back up to date
+func (accord *Accord) finishImport(batch *leveldb.Batch, header SnapshotHeader) error {
+	// Our indexes were built over an empty history, so they need to be built again
+	for name := range accord.indexes {
+		batch.Delete([]byte(indexBuiltPrefix + name))
+	}
+
+	err := accord.state.Flush()
+	if err != nil {
+		return err
+	}
+	err = accord.state.db.Write(batch, nil)
+	if err != nil {
+		return err
+	}
+
+	pruned, err := accord.state.loadPruned()
+	if err != nil {
+		return err
+	}
+	accord.state.mutex.Lock()
+	err = accord.state.loadFromDisk()
+	accord.state.applied = accord.historyStack.Length() + pruned.Count
+	accord.state.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if accord.state.digestMissing {
+		err = accord.state.rebuildDigest(accord.History())
+		if err != nil {
+			return err
+		}
+	}
+
+	err = accord.buildIndexes()
+	if err != nil {
+		return err
+	}
+
+	accord.seedClock()
+
+	accord.Logger.WithField("history", header.HistoryLen).WithField("queue", header.QueueLen).Info("Snapshot imported")
+	return nil
+}
+
+// ReadSnapshot reads a snapshot written by ExportSnapshot into memory without importing it anywhere, which
+// is useful for tooling that wants to inspect or compare snapshots
+func ReadSnapshot(r io.Reader) (*Snapshot, error) {
+	decoder := gob.NewDecoder(r)
+	snapshot := &Snapshot{}
+	err := decoder.Decode(&snapshot.Header)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		record := snapshotRecord{}
+		err = decoder.Decode(&record)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case record.History != nil:
+			snapshot.History = append(snapshot.History, *record.History)
+		case record.Queued != nil:
+			snapshot.Queue = append(snapshot.Queue, *record.Queued)
+		case record.State != nil:
+		default:
+			return snapshot, nil
+		}
+	}
+}
+
+// ReadSnapshotFile is a convenience wrapper around ReadSnapshot that reads a snapshot from a file
+func ReadSnapshotFile(path string) (*Snapshot, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReadSnapshot(file)
+}
+
+func skippedInSnapshot(key string) bool {
+	for _, prefix := range snapshotSkippedPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
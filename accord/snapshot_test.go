@@ -0,0 +1,98 @@
+package accord
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	defer AccordCleanup()
+	source := historyTestAccord(t)
+
+	var buf bytes.Buffer
+	assert.Nil(t, source.ExportSnapshot(&buf))
+	data := buf.Bytes()
+
+	expectedHistory := collectHistory(t, source.History().Query(HistoryFilter{}))
+	expectedDigest := source.Digest()
+	expectedState := source.state.GetCurrent()
+	expectedClock := source.Clock()
+	expectedQueue := source.Queue().Len()
+	source.Stop()
+
+	dir, err := ioutil.TempDir("", "accord-snapshot")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	target := NewAccord(NewDummerManager(), nil, dir, DummyAccord().Logger)
+	target.NodeID = "target"
+	target.AddIndex("type", func(msg *Message) []string { return []string{msg.Type} })
+	assert.Nil(t, target.Start())
+	defer target.Stop()
+
+	assert.Nil(t, target.ImportSnapshot(bytes.NewReader(data)))
+	assert.Equal(t, expectedHistory, collectHistory(t, target.History().Query(HistoryFilter{})))
+	assert.Equal(t, expectedDigest, target.Digest())
+	assert.Equal(t, expectedState, target.state.GetCurrent())
+	assert.Equal(t, expectedClock, target.Clock())
+	assert.Equal(t, expectedQueue, target.Queue().Len())
+
+	// Indexes should be rebuilt over the imported history
+	updates := collectHistory(t, target.History().Lookup("type", "user.update"))
+	assert.Len(t, updates, 2)
+
+	// A node that already has data can't be bootstrapped
+	assert.Equal(t, ErrNotEmpty, target.ImportSnapshot(bytes.NewReader(data)))
+}
+
+func TestReadSnapshot(t *testing.T) {
+	defer AccordCleanup()
+	accord := historyTestAccord(t)
+	defer accord.Stop()
+
+	var buf bytes.Buffer
+	assert.Nil(t, accord.ExportSnapshot(&buf))
+
+	snapshot, err := ReadSnapshot(&buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "local", snapshot.Header.NodeID)
+	assert.Equal(t, accord.Digest(), snapshot.Header.Digest)
+	assert.Len(t, snapshot.History, 4)
+	assert.Len(t, snapshot.Queue, 4)
+
+	// The history should be oldest first
+	assert.Equal(t, uint64(1), snapshot.History[0].ID)
+	assert.Equal(t, uint64(4), snapshot.History[3].ID)
+}
+
+func TestBootstrap(t *testing.T) {
+	defer AccordCleanup()
+	source := historyTestAccord(t)
+	defer source.Stop()
+
+	var buf bytes.Buffer
+	assert.Nil(t, source.ExportSnapshot(&buf))
+
+	dir, err := ioutil.TempDir("", "accord-bootstrap")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	target := NewAccord(NewDummerManager(), nil, dir, DummyAccord().Logger)
+	target.NodeID = "target"
+	assert.Nil(t, target.Start())
+	defer target.Stop()
+
+	// Everything but the source's queue is brought over, which the source sends on itself
+	assert.Nil(t, target.Bootstrap(&buf))
+	assert.Equal(t, uint64(4), target.History().Len())
+	assert.True(t, target.CompareDigest(source.Digest()).Equal)
+	assert.Equal(t, uint64(0), target.Queue().Len())
+
+	// Messages performed afterwards are synchronized as usual
+	assert.Nil(t, target.HandleRemoteMessage(&Message{ID: 5, Type: "user.create", Origin: "local"}))
+	assert.Equal(t, uint64(5), target.History().Len())
+}
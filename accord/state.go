@@ -2,13 +2,22 @@ package accord
 
 import (
 	"encoding/binary"
+	"sync"
+	"time"
 
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/syndtr/goleveldb/leveldb/opt"
 )
 
 const (
-	stateKey = "state"
+	stateKey  = "state"
+	digestKey = "digest"
+	clockKey  = "clock"
+
+	// deliveredKey and keySeqPrefix hold what we need to enforce message ordering (see OrderingMode)
+	deliveredKey = "delivered"
+	keySeqPrefix = "keyseq/"
 )
 
 // State represents the internal history of Accord. Our state is essentially just a cumulative function of
@@ -25,6 +34,42 @@ type State struct {
 	// Theres no point for us to go to the disk everytime we want to know our state as long as we can ensure
 	// we're the only ones updating it
 	cached uint64
+
+	// digest breaks our cached state down into a Merkle tree, so that we can narrow down *where* we've
+	// diverged from a remote rather than just *if* we have
+	digest Digest
+
+	// digestMissing is set when we load a database that was created before we kept track of a digest, in
+	// which case it needs to be rebuilt from our history
+	digestMissing bool
+
+	// clock is our vector clock, tracking how many Messages from each node we've processed
+	clock VectorClock
+
+	// delivered counts how many Messages from each origin we've delivered. Unlike our clock it only ever
+	// moves forward because of an origin's own Messages, which is what lets us spot gaps in them
+	delivered VectorClock
+
+	// dedupWindow is how long we remember the idempotency keys of the Messages we deliver for, if at all
+	// (see Accord's DedupWindow)
+	dedupWindow time.Duration
+
+	// applied counts the Messages we've been updated with, so that we can tell whether we've fallen behind
+	// our history (see recoverState)
+	applied uint64
+
+	// writeOptions are what our state updates are written with, which is how they're synced to disk under
+	// SyncAlways (see StateSyncMode)
+	writeOptions *opt.WriteOptions
+
+	// buffer holds the writes we haven't flushed yet when they're being batched (see FlushInterval)
+	buffer *stateBuffer
+
+	// sealer encrypts the values set through Put when our data directory is encrypted (see EncryptionKey)
+	sealer *sealer
+
+	// mutex protects our cached values from being read while they're being updated
+	mutex sync.RWMutex
 }
 
 // OpenState will open or create a LevelDB database that stores our state information and then load and cache
@@ -39,14 +84,17 @@ func OpenState(path string) (*State, error) {
 
 	err = state.loadFromDisk()
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
 
 	return &state, nil
 }
 
-// Close and clean up our LevelDB connection
+// Close and clean up our LevelDB connection, flushing anything we've buffered first
 func (state *State) Close() {
+	state.stopBatching()
+	state.markClosed()
 	state.db.Close()
 }
 
@@ -68,38 +116,275 @@ func (state *State) loadFromDisk() error {
 		state.cached = binary.LittleEndian.Uint64(val)
 	}
 
+	val, err = state.db.Get([]byte(digestKey), nil)
+	if err != nil {
+		if err != errors.ErrNotFound {
+			return err
+		}
+		state.digest = Digest{}
+		state.digestMissing = state.cached != 0
+	} else {
+		state.digest, err = unmarshalDigest(val)
+		if err != nil {
+			return err
+		}
+	}
+
+	val, err = state.db.Get([]byte(clockKey), nil)
+	if err != nil {
+		if err != errors.ErrNotFound {
+			return err
+		}
+		state.clock = VectorClock{}
+	} else {
+		state.clock, err = unmarshalVectorClock(val)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Databases created before we tracked deliveries only have their clock to go by, which is the best
+	// guess we have
+	val, err = state.db.Get([]byte(deliveredKey), nil)
+	if err != nil {
+		if err != errors.ErrNotFound {
+			return err
+		}
+		state.delivered = state.clock.Copy()
+	} else {
+		state.delivered, err = unmarshalVectorClock(val)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
 // saveToDisk saves our instance to disk as it currently is so that it can
 // be persisted
 func (state *State) saveToDisk() error {
+	return state.saveBatch(new(leveldb.Batch))
+}
+
+// saveBatch saves our instance to disk along with anything else that's already been put in the batch
+func (state *State) saveBatch(batch *leveldb.Batch) error {
 	data := make([]byte, 8)
 	binary.LittleEndian.PutUint64(data, state.cached)
 
-	return state.db.Put([]byte(stateKey), data, nil)
+	clock, err := state.clock.marshal()
+	if err != nil {
+		return err
+	}
+
+	delivered, err := state.delivered.marshal()
+	if err != nil {
+		return err
+	}
+
+	applied := make([]byte, 8)
+	binary.BigEndian.PutUint64(applied, state.applied)
+
+	// Our counter, digest and clocks need to be kept in lock step, so make sure they're written atomically
+	batch.Put([]byte(stateKey), data)
+	batch.Put([]byte(digestKey), state.digest.marshal())
+	batch.Put([]byte(clockKey), clock)
+	batch.Put([]byte(deliveredKey), delivered)
+	batch.Put([]byte(appliedKey), applied)
+
+	return state.write(batch)
 }
 
 // GetCurrent returns our current state
 func (state *State) GetCurrent() uint64 {
+	state.mutex.RLock()
+	defer state.mutex.RUnlock()
 	return state.cached
 }
 
+// Digest returns the Merkle tree summarizing every Message we've processed
+func (state *State) Digest() Digest {
+	state.mutex.RLock()
+	defer state.mutex.RUnlock()
+	return state.digest
+}
+
+// Clock returns a copy of our vector clock
+func (state *State) Clock() VectorClock {
+	state.mutex.RLock()
+	defer state.mutex.RUnlock()
+	return state.clock.Copy()
+}
+
+// NextClock returns what our vector clock will look like after the given node creates a new Message. It
+// doesn't change our clock, that only happens once the Message has actually been processed
+func (state *State) NextClock(node string) VectorClock {
+	clock := state.Clock()
+	clock.Increment(node)
+	return clock
+}
+
+// Compare checks our digest against a remote one, reporting whether we've diverged and which buckets
+// are responsible
+func (state *State) Compare(remote Digest) DigestComparison {
+	return state.Digest().Compare(remote)
+}
+
+// rebuildDigest recomputes our digest from scratch using every Message in our history. This is only needed
+// for databases created before we started keeping a digest
+func (state *State) rebuildDigest(history *History) error {
+	digest := Digest{}
+	iter := history.Query(HistoryFilter{})
+	for iter.Next() {
+		digest.add(iter.Message().ID)
+	}
+	if iter.Err() != nil {
+		return iter.Err()
+	}
+
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	original := state.digest
+	state.digest = digest
+	err := state.saveToDisk()
+	if err != nil {
+		state.digest = original
+		return err
+	}
+
+	state.digestMissing = false
+	return nil
+}
+
 // Update updates our current state to signify that a message has been
 // processed by our system. We also set the Message's "StateAt" field
-// to make sure it's correct
+// to make sure it's correct, and merge the Message's clock into our own
 func (state *State) Update(msg *Message) error {
+	return state.update([]*Message{msg}, nil, nil)
+}
+
+// update is Update for any number of Messages, whose updates are written together along with whatever's
+// in batch (which may be nil). If commit is given it's called once our state has been updated in memory
+// but before any of it has been written, without holding our mutex, and if it fails our state is put back
+// as it was. Our callers all hold processMutex, so nothing else updates our state in the meantime
+func (state *State) update(msgs []*Message, batch *leveldb.Batch, commit func() error) error {
+	state.mutex.Lock()
+	locked := true
+	defer func() {
+		if locked {
+			state.mutex.Unlock()
+		}
+	}()
+
 	original := state.cached
+	originalDigest := state.digest
+	originalClock := state.clock.Copy()
+	originalDelivered := state.delivered.Copy()
+	originalApplied := state.applied
+	restore := func() {
+		state.cached = original
+		state.digest = originalDigest
+		state.clock = originalClock
+		state.delivered = originalDelivered
+		state.applied = originalApplied
+	}
 
-	msg.StateAt = state.cached
+	if batch == nil {
+		batch = new(leveldb.Batch)
+	}
+	for _, msg := range msgs {
+		msg.StateAt = state.cached
 
-	state.cached += msg.ID
+		state.cached += msg.ID
+		state.digest.add(msg.ID)
+		state.clock.Merge(msg.Clock)
+		state.applied++
 
-	err := state.saveToDisk()
+		err := state.deliver(msg, batch)
+		if err != nil {
+			restore()
+			return err
+		}
+	}
+
+	if commit != nil {
+		state.mutex.Unlock()
+		locked = false
+		err := commit()
+		state.mutex.Lock()
+		locked = true
+		if err != nil {
+			restore()
+			return err
+		}
+	}
+
+	err := state.saveBatch(batch)
 	if err != nil {
-		state.cached = original
+		restore()
+		return err
+	}
+	return nil
+}
+
+// Delivered returns how many Messages from the given origin we've delivered
+func (state *State) Delivered(origin string) uint64 {
+	state.mutex.RLock()
+	defer state.mutex.RUnlock()
+	return state.delivered[origin]
+}
+
+// KeySeq returns the KeySeq of the last Message we delivered from the given origin for the given key
+func (state *State) KeySeq(origin string, key string) (uint64, error) {
+	val, err := state.get(keySeqKey(origin, key))
+	if err == errors.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(val), nil
+}
+
+// MarkDelivered records that a Message has been delivered without it having been applied, which is what
+// happens to Messages that are skipped or rejected. Without this, an ordering mode would hold back every
+// Message that came after them forever
+func (state *State) MarkDelivered(msg *Message) error {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	originalDelivered := state.delivered.Copy()
+
+	batch := new(leveldb.Batch)
+	err := state.deliver(msg, batch)
+	if err == nil {
+		err = state.saveBatch(batch)
+	}
+	if err != nil {
+		state.delivered = originalDelivered
 		return err
 	}
 
 	return nil
 }
+
+// deliver moves our delivery counters forward for the Message, adding its KeySeq and idempotency key to the
+// batch. The caller must hold our mutex
+func (state *State) deliver(msg *Message, batch *leveldb.Batch) error {
+	if seq := msg.Clock[msg.Origin]; seq > state.delivered[msg.Origin] {
+		state.delivered[msg.Origin] = seq
+	}
+
+	if msg.Key != "" && msg.KeySeq > 0 {
+		data := make([]byte, 8)
+		binary.BigEndian.PutUint64(data, msg.KeySeq)
+		batch.Put(keySeqKey(msg.Origin, msg.Key), data)
+	}
+
+	return state.rememberIdempotencyKey(msg, batch)
+}
+
+func keySeqKey(origin string, key string) []byte {
+	return []byte(keySeqPrefix + origin + "\x00" + key)
+}
@@ -0,0 +1,121 @@
+package accord
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotInHistory is returned by StateAtMessage when the Message asked for was never performed
+var ErrNotInHistory = errors.New("the message isn't in our history")
+
+// errFound stops walking a history once we've found the Message we were after
+var errFound = errors.New("found")
+
+// PointInTime is what a node's state looked like at some point in the past, as reconstructed from its
+// history by StateAt or StateAtMessage. Comparing one with what a peer reconstructs for the same point
+// narrows down when (and with which Messages) the two of them diverged
+type PointInTime struct {
+	// MessageID is the ID of the last Message the reconstruction covers, in the order they were performed,
+	// or zero if it covers none
+	MessageID uint64
+
+	// Latest is the Timestamp of the most recently created Message the reconstruction covers
+	Latest time.Time
+
+	// Applied is how many Messages had been performed
+	Applied uint64
+
+	// State, Digest and Clock are what State.GetCurrent, State.Digest and State.Clock would have returned.
+	// Messages that were skipped or rejected never make it into a history, so they can't move Clock on here
+	State  uint64
+	Digest Digest
+	Clock  VectorClock
+}
+
+// apply moves the reconstruction on past a Message, the same way State.Update does
+func (point *PointInTime) apply(msg *Message) {
+	point.MessageID = msg.ID
+	if msg.Timestamp.After(point.Latest) {
+		point.Latest = msg.Timestamp
+	}
+	point.Applied++
+	point.State += msg.ID
+	point.Digest.add(msg.ID)
+	point.Clock.Merge(msg.Clock)
+}
+
+// historyWalker calls fn with every Message in a history, from the oldest to the most recent
+type historyWalker func(fn func(msg *Message) error) error
+
+// stateAt reconstructs a history's state from every Message in it that was created at or before the given
+// time. Neither our state nor our digest or clock depend on the order Messages are performed in, so this
+// is what we would have had if every one of them had reached us by then, whenever they actually did. Two
+// nodes that agree should therefore agree on this too, even though their histories are ordered differently
+func stateAt(walk historyWalker, at time.Time) (*PointInTime, error) {
+	point := &PointInTime{Clock: VectorClock{}}
+	err := walk(func(msg *Message) error {
+		if !msg.Timestamp.After(at) {
+			point.apply(msg)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return point, nil
+}
+
+// stateAtMessage reconstructs a history's state right after the Message with the given ID was performed
+func stateAtMessage(walk historyWalker, id uint64) (*PointInTime, error) {
+	point := &PointInTime{Clock: VectorClock{}}
+	err := walk(func(msg *Message) error {
+		point.apply(msg)
+		if msg.ID == id {
+			return errFound
+		}
+		return nil
+	})
+	if err == errFound {
+		return point, nil
+	}
+	if err == nil {
+		err = ErrNotInHistory
+	}
+	return nil, err
+}
+
+// StateAt reconstructs what our state looked like at the given time from our history, counting every
+// Message that had been created by then. Messages performed while we're reconstructing are left out
+func (accord *Accord) StateAt(at time.Time) (*PointInTime, error) {
+	return stateAt(accord.walkHistory, at)
+}
+
+// StateAtMessage reconstructs what our state looked like right after we performed the Message with the
+// given ID, rewinding our history to that point. It returns ErrNotInHistory if we never performed it
+func (accord *Accord) StateAtMessage(id uint64) (*PointInTime, error) {
+	return stateAtMessage(accord.walkHistory, id)
+}
+
+// walkHistory calls fn with every Message in the snapshot's history, from the oldest to the most recent
+func (snapshot *Snapshot) walkHistory(fn func(msg *Message) error) error {
+	for i := range snapshot.History {
+		err := fn(&snapshot.History[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StateAt reconstructs what the state of the node a snapshot was taken from looked like at the given time,
+// just like Accord.StateAt. A peer's snapshot can be read with ReadSnapshotFile and compared with what we
+// reconstruct ourselves without either of us importing anything
+func (snapshot *Snapshot) StateAt(at time.Time) (*PointInTime, error) {
+	return stateAt(snapshot.walkHistory, at)
+}
+
+// StateAtMessage reconstructs what the state of the node a snapshot was taken from looked like right after
+// it performed the Message with the given ID, just like Accord.StateAtMessage
+func (snapshot *Snapshot) StateAtMessage(id uint64) (*PointInTime, error) {
+	return stateAtMessage(snapshot.walkHistory, id)
+}
@@ -0,0 +1,68 @@
+package accord
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStateAt(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	start := time.Now().Add(-time.Hour)
+	msgs := []*Message{}
+	for i, payload := range []string{"one", "two", "three"} {
+		msg, _ := NewMessage([]byte(payload))
+		msg.Timestamp = start.Add(time.Duration(i) * time.Minute)
+		assert.Nil(t, accord.HandleNewMessage(msg))
+		msgs = append(msgs, msg)
+	}
+
+	// Nothing had been created yet
+	point, err := accord.StateAt(start.Add(-time.Second))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), point.Applied)
+	assert.Equal(t, uint64(0), point.State)
+
+	// Only the first two Messages had been created
+	point, err = accord.StateAt(start.Add(time.Minute))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), point.Applied)
+	assert.Equal(t, msgs[1].ID, point.MessageID)
+	assert.Equal(t, msgs[2].StateAt, point.State)
+	assert.True(t, point.Latest.Equal(msgs[1].Timestamp))
+
+	// Everything, which is where we are now
+	point, err = accord.StateAt(time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), point.Applied)
+	assert.Equal(t, accord.CurrentState(), point.State)
+	assert.Equal(t, accord.Digest(), point.Digest)
+	assert.Equal(t, accord.Clock(), point.Clock)
+
+	point, err = accord.StateAtMessage(msgs[0].ID)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), point.Applied)
+	assert.Equal(t, msgs[1].StateAt, point.State)
+
+	_, err = accord.StateAtMessage(12345)
+	assert.Equal(t, ErrNotInHistory, err)
+
+	// A snapshot of us reconstructs to the same thing
+	var buf bytes.Buffer
+	assert.Nil(t, accord.ExportSnapshot(&buf))
+	snapshot, err := ReadSnapshot(&buf)
+	assert.Nil(t, err)
+	fromSnapshot, err := snapshot.StateAtMessage(msgs[0].ID)
+	assert.Nil(t, err)
+	assert.Equal(t, point, fromSnapshot)
+	fromSnapshot, err = snapshot.StateAt(time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, snapshot.Header.State, fromSnapshot.State)
+	assert.Equal(t, snapshot.Header.Digest, fromSnapshot.Digest)
+}
@@ -0,0 +1,44 @@
+package accord
+
+import (
+	"time"
+)
+
+// EventStopTimeout is emitted when Stop gives up on waiting for something that's stuck (see StopTimeout)
+const EventStopTimeout = "stop_timeout"
+
+// stopDeadline returns when a Stop starting now has to be done by, going by StopTimeout. It's the zero Time
+// when it can take as long as it needs
+func (accord *Accord) stopDeadline() time.Time {
+	if accord.StopTimeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(accord.StopTimeout)
+}
+
+// stopWithin calls fn, which waits for what's named to stop, giving up on it once deadline (see
+// stopDeadline) has passed. Whatever is stuck is left running in the background while we carry on stopping
+// without it. It returns whether fn finished in time
+func (accord *Accord) stopWithin(what string, deadline time.Time, fn func()) bool {
+	if deadline.IsZero() {
+		fn()
+		return true
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn()
+	}()
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+	}
+	accord.Logger.WithField("stuck", what).WithField("timeout", accord.StopTimeout).Error("Gave up waiting for something to stop, abandoning it")
+	accord.Emit(EventStopTimeout, "Gave up waiting for something to stop", map[string]interface{}{"stuck": what, "timeout": accord.StopTimeout.String()})
+	return false
+}
@@ -0,0 +1,72 @@
+package accord
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// stuckComponent never finishes stopping
+type stuckComponent struct {
+	release chan struct{}
+}
+
+func (comp *stuckComponent) Start(*Accord) error { return nil }
+func (comp *stuckComponent) Stop(int)            {}
+func (comp *stuckComponent) WaitForStop()        { <-comp.release }
+func (comp *stuckComponent) Name() string        { return "stuck" }
+
+func TestStopTimeout(t *testing.T) {
+	defer AccordCleanup()
+	comp := &stuckComponent{release: make(chan struct{})}
+	defer close(comp.release)
+	accord := NewAccord(NewDummerManager(), []Component{comp}, "", DummyAccord().Logger)
+	accord.StopTimeout = 10 * time.Millisecond
+	assert.Nil(t, accord.Start())
+
+	stuck := []interface{}{}
+	accord.Subscribe(func(event Event) {
+		if event.Kind == EventStopTimeout {
+			stuck = append(stuck, event.Fields["stuck"])
+		}
+	})
+
+	stopped := make(chan struct{})
+	go func() {
+		accord.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "Stop waited on a stuck Component")
+	}
+	assert.Equal(t, []interface{}{"stuck"}, stuck)
+}
+
+func TestStopTimeoutOverall(t *testing.T) {
+	defer AccordCleanup()
+	release := make(chan struct{})
+	defer close(release)
+	comps := []Component{}
+	for i := 0; i < 4; i++ {
+		comps = append(comps, &stuckComponent{release: release})
+	}
+	accord := NewAccord(NewDummerManager(), comps, "", DummyAccord().Logger)
+	accord.StopTimeout = 50 * time.Millisecond
+	assert.Nil(t, accord.Start())
+
+	stuck := 0
+	accord.Subscribe(func(event Event) {
+		if event.Kind == EventStopTimeout {
+			stuck++
+		}
+	})
+
+	// However many Components are stuck, we only wait out StopTimeout once
+	start := time.Now()
+	accord.Stop()
+	assert.True(t, time.Since(start) < 4*accord.StopTimeout, "Stop took %s", time.Since(start))
+	assert.Equal(t, 4, stuck)
+}
@@ -0,0 +1,182 @@
+package accord
+
+import (
+	"time"
+)
+
+// Event kinds emitted as Components fail and are restarted
+const (
+	EventComponentFailed    = "component_failed"
+	EventComponentRestarted = "component_restarted"
+)
+
+// DefaultRestartBackoff and DefaultMaxRestartBackoff are how long we wait before restarting a failed
+// Component by default. The wait doubles with every restart, up to the maximum
+const (
+	DefaultRestartBackoff    = time.Second
+	DefaultMaxRestartBackoff = time.Minute
+)
+
+// RestartMode is what our supervisor does when a Component fails
+type RestartMode int
+
+const (
+	// EscalateToShutdown shuts the whole application down, the same as if the Component had called
+	// Shutdown itself
+	EscalateToShutdown RestartMode = iota
+
+	// RestartAlways restarts the Component however many times it fails
+	RestartAlways
+
+	// RestartLimited restarts the Component up to MaxRestarts times, after which it's escalated to a
+	// shutdown
+	RestartLimited
+)
+
+// RestartPolicy decides what happens when a Component fails (see ReportFailure). A Component built on
+// ComponentRunner fails when its tick function panics
+type RestartPolicy struct {
+	Mode RestartMode
+
+	// MaxRestarts is how many times RestartLimited restarts the Component
+	MaxRestarts int
+
+	// Backoff is how long we wait before the first restart, defaulting to DefaultRestartBackoff. It
+	// doubles with every restart after that, up to MaxBackoff (defaulting to DefaultMaxRestartBackoff)
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// backoff returns how long to wait before the given restart (counting from 1)
+func (policy RestartPolicy) backoff(restart int) time.Duration {
+	backoff := policy.Backoff
+	if backoff <= 0 {
+		backoff = DefaultRestartBackoff
+	}
+	max := policy.MaxBackoff
+	if max <= 0 {
+		max = DefaultMaxRestartBackoff
+	}
+
+	for i := 1; i < restart && backoff < max; i++ {
+		backoff *= 2
+	}
+	if backoff > max {
+		backoff = max
+	}
+	return backoff
+}
+
+// restartPolicyFor returns the RestartPolicy for the Component with the given name
+func (accord *Accord) restartPolicyFor(name string) RestartPolicy {
+	if policy, ok := accord.RestartPolicies[name]; ok {
+		return policy
+	}
+	return accord.DefaultRestartPolicy
+}
+
+// ReportFailure tells our supervisor that one of our Components has failed and stopped working, so that
+// it's dealt with according to the Component's RestartPolicy: it's either stopped and started again after
+// a backoff, or escalated to a Shutdown (in which case, like Shutdown, this blocks until Listen picks it
+// up). Components built on ComponentRunner are reported automatically when their tick function panics
+func (accord *Accord) ReportFailure(comp Component, err error) {
+	accord.componentsMutex.Lock()
+	name, registered := accord.nameOf(comp)
+	if accord.restarts == nil {
+		accord.restarts = map[string]int{}
+	}
+	restart := accord.restarts[name] + 1
+	policy := accord.restartPolicyFor(name)
+	stopping := accord.stopping
+	escalate := !registered || policy.Mode == EscalateToShutdown || (policy.Mode == RestartLimited && restart > policy.MaxRestarts)
+	if !escalate {
+		accord.restarts[name] = restart
+	}
+	accord.componentsMutex.Unlock()
+
+	log := accord.Logger.WithError(err).WithField("component", name)
+	accord.recordError(err)
+	accord.Emit(EventComponentFailed, "A component failed", map[string]interface{}{"component": name, "error": err.Error()})
+
+	if stopping {
+		log.Info("A component failed while we were stopping")
+		return
+	}
+	if escalate {
+		log.Error("A component failed, shutting down")
+		accord.Shutdown(err)
+		return
+	}
+
+	backoff := policy.backoff(restart)
+	log.WithField("restart", restart).WithField("backoff", backoff).Warn("A component failed, restarting it")
+	go accord.restartComponent(comp, name, backoff)
+}
+
+// restartComponent stops and starts a failed Component again once the backoff has passed, unless we're
+// stopping or the Component has been removed in the meantime
+func (accord *Accord) restartComponent(comp Component, name string, backoff time.Duration) {
+	time.Sleep(backoff)
+
+	// We don't hold componentsMutex while waiting for the Component to stop, as its loop may need it (to
+	// ask IsLeader, say) before it can, so whether it's still ours to start is checked again afterwards
+	accord.componentsMutex.Lock()
+	_, registered := accord.nameOf(comp)
+	stopping := accord.stopping
+	accord.componentsMutex.Unlock()
+	if !registered || stopping {
+		return
+	}
+
+	comp.Stop(0)
+	comp.WaitForStop()
+
+	accord.componentsMutex.Lock()
+	if _, registered := accord.nameOf(comp); !registered || accord.stopping {
+		accord.componentsMutex.Unlock()
+		return
+	}
+	err := accord.startComponent(comp)
+	accord.componentsMutex.Unlock()
+
+	if err != nil {
+		accord.ReportFailure(comp, err)
+		return
+	}
+
+	accord.Logger.WithField("component", name).Info("Restarted a component")
+	accord.Emit(EventComponentRestarted, "A component was restarted", map[string]interface{}{"component": name})
+}
+
+// componentFailed is how a ComponentRunner reports a failure, as it doesn't know which of our Components
+// it belongs to. A runner that doesn't belong to any of them can only shut us down
+func (accord *Accord) componentFailed(runner *ComponentRunner, err error) {
+	accord.componentsMutex.Lock()
+	var failed Component
+	for _, comp := range accord.components {
+		if embedded, ok := comp.(interface{ componentRunner() *ComponentRunner }); ok && embedded.componentRunner() == runner {
+			failed = comp
+			break
+		}
+	}
+	accord.componentsMutex.Unlock()
+
+	if failed == nil {
+		accord.Shutdown(err)
+		return
+	}
+	accord.ReportFailure(failed, err)
+}
+
+// nameOf returns the name of one of our Components, and whether it's one of ours at all. The caller must
+// hold componentsMutex
+func (accord *Accord) nameOf(comp Component) (string, bool) {
+	taken := map[string]bool{}
+	for _, other := range accord.components {
+		name := componentName(other, taken)
+		if other == comp {
+			return name, true
+		}
+	}
+	return componentName(comp, map[string]bool{}), false
+}
@@ -0,0 +1,78 @@
+package accord
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyComponent panics on its first few ticks. Like Cron, it asks whether we're the leader as it ticks,
+// which looks through our Components while they're being restarted
+type flakyComponent struct {
+	ComponentRunner
+	panics int32
+	ticks  int32
+}
+
+func (comp *flakyComponent) Start(accord *Accord) error {
+	comp.Init(accord, func(accord *Accord) {
+		accord.IsLeader()
+		if atomic.AddInt32(&comp.ticks, 1) <= atomic.LoadInt32(&comp.panics) {
+			panic("flaked")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}, nil, nil)
+	return nil
+}
+
+func TestRestartBackoff(t *testing.T) {
+	policy := RestartPolicy{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	assert.Equal(t, time.Second, policy.backoff(1))
+	assert.Equal(t, 2*time.Second, policy.backoff(2))
+	assert.Equal(t, 4*time.Second, policy.backoff(3))
+	assert.Equal(t, 5*time.Second, policy.backoff(4))
+	assert.Equal(t, DefaultRestartBackoff, RestartPolicy{}.backoff(1))
+}
+
+func TestSupervisorRestarts(t *testing.T) {
+	defer AccordCleanup()
+	comp := &flakyComponent{panics: 2}
+	accord := NewAccord(NewDummerManager(), []Component{comp}, "", DummyAccord().Logger)
+	accord.RestartPolicies = map[string]RestartPolicy{"flakyComponent": {Mode: RestartLimited, MaxRestarts: 3, Backoff: time.Millisecond}}
+
+	restarted := make(chan bool, 10)
+	accord.Subscribe(func(event Event) {
+		if event.Kind == EventComponentRestarted {
+			restarted <- true
+		}
+	})
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-restarted:
+		case <-time.After(time.Second):
+			t.Fatal("the component was never restarted")
+		}
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	status := accord.Components()[0]
+	assert.True(t, status.Running)
+	assert.Equal(t, 2, status.Restarts)
+}
+
+func TestSupervisorEscalates(t *testing.T) {
+	defer AccordCleanup()
+	comp := &flakyComponent{panics: 100}
+	accord := NewAccord(NewDummerManager(), []Component{comp}, "", DummyAccord().Logger)
+	accord.DefaultRestartPolicy = RestartPolicy{Mode: RestartLimited, MaxRestarts: 1, Backoff: 10 * time.Millisecond}
+	assert.Nil(t, accord.Start())
+
+	err := accord.Listen()
+	assert.IsType(t, &PanicError{}, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&comp.ticks))
+}
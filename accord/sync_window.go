@@ -0,0 +1,105 @@
+package accord
+
+import (
+	"fmt"
+	"time"
+)
+
+// SyncWindow is a time of day during which a peer can be sent Messages, for peers on metered or shared
+// links that mustn't be synchronized with during business hours. Outside of every one of a peer's windows
+// its cursors hand out nothing, exactly as if we were paused, and whatever comes in meanwhile waits in the
+// queue for the next window to open. Combine it with a RateLimit to cap the bandwidth used inside of it.
+//
+// Start and End are how long after midnight the window opens and closes. A window that ends before it
+// starts runs past midnight, so {Start: 22 * time.Hour, End: 2 * time.Hour} is from ten at night until two
+// in the morning
+type SyncWindow struct {
+	Start time.Duration
+	End   time.Duration
+
+	// Location is the time zone the window is in, defaulting to the local one
+	Location *time.Location
+}
+
+// ParseSyncWindow parses a window written like "01:00-05:00", in the local time zone
+func ParseSyncWindow(window string) (SyncWindow, error) {
+	var startHour, startMinute, endHour, endMinute int
+	_, err := fmt.Sscanf(window, "%d:%d-%d:%d", &startHour, &startMinute, &endHour, &endMinute)
+	if err != nil || startHour < 0 || startHour > 24 || endHour < 0 || endHour > 24 ||
+		startMinute < 0 || startMinute > 59 || endMinute < 0 || endMinute > 59 {
+		return SyncWindow{}, fmt.Errorf("sync window %q should look like 01:00-05:00", window)
+	}
+	return SyncWindow{
+		Start: time.Duration(startHour)*time.Hour + time.Duration(startMinute)*time.Minute,
+		End:   time.Duration(endHour)*time.Hour + time.Duration(endMinute)*time.Minute,
+	}, nil
+}
+
+// Contains reports whether the window is open at a given time
+func (window SyncWindow) Contains(at time.Time) bool {
+	if window.Location != nil {
+		at = at.In(window.Location)
+	}
+	// Going by the clock rather than the time since midnight keeps windows where they are on the days
+	// daylight saving time starts or ends
+	hour, minute, second := at.Clock()
+	offset := time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute + time.Duration(second)*time.Second
+
+	if window.Start <= window.End {
+		return offset >= window.Start && offset < window.End
+	}
+	return offset >= window.Start || offset < window.End
+}
+
+// String writes the window the way ParseSyncWindow reads it
+func (window SyncWindow) String() string {
+	clock := func(offset time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(offset/time.Hour), int(offset%time.Hour/time.Minute))
+	}
+	return clock(window.Start) + "-" + clock(window.End)
+}
+
+// SetSyncWindows changes our SyncWindows while we're running. Peers given windows of their own with
+// SetPeerSyncWindows keep them
+func (accord *Accord) SetSyncWindows(windows []SyncWindow) {
+	accord.peers.mutex.Lock()
+	defer accord.peers.mutex.Unlock()
+	accord.SyncWindows = windows
+}
+
+// SetPeerSyncWindows restricts when a peer can be sent Messages, overriding our SyncWindows for it. Passing
+// no windows lets the peer be synchronized with at any time
+func (accord *Accord) SetPeerSyncWindows(name string, windows []SyncWindow) {
+	accord.peers.mutex.Lock()
+	defer accord.peers.mutex.Unlock()
+
+	if accord.peers.windows == nil {
+		accord.peers.windows = map[string][]SyncWindow{}
+	}
+	accord.peers.windows[name] = append([]SyncWindow{}, windows...)
+}
+
+// InSyncWindow reports whether a peer can be sent Messages right now, going by its SyncWindows
+func (accord *Accord) InSyncWindow(name string) bool {
+	accord.peers.mutex.Lock()
+	defer accord.peers.mutex.Unlock()
+	return accord.inSyncWindow(name, time.Now())
+}
+
+// inSyncWindow reports whether a peer can be sent Messages at a given time. The caller must hold the peers
+// mutex
+func (accord *Accord) inSyncWindow(name string, at time.Time) bool {
+	windows, ok := accord.peers.windows[name]
+	if !ok {
+		windows = accord.SyncWindows
+	}
+	if len(windows) == 0 {
+		return true
+	}
+	for _, window := range windows {
+		if window.Contains(at) {
+			return true
+		}
+	}
+	return false
+}
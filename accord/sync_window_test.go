@@ -0,0 +1,84 @@
+package accord
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSyncWindow(t *testing.T) {
+	window, err := ParseSyncWindow("01:00-05:30")
+	assert.Nil(t, err)
+	assert.Equal(t, SyncWindow{Start: time.Hour, End: 5*time.Hour + 30*time.Minute}, window)
+	assert.Equal(t, "01:00-05:30", window.String())
+
+	for _, bad := range []string{"", "1am-5am", "01:00", "25:00-05:00", "01:60-05:00"} {
+		_, err = ParseSyncWindow(bad)
+		assert.NotNil(t, err, bad)
+	}
+}
+
+func TestSyncWindowContains(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2020, 3, 1, hour, minute, 0, 0, time.UTC)
+	}
+
+	night := SyncWindow{Start: time.Hour, End: 5 * time.Hour, Location: time.UTC}
+	assert.False(t, night.Contains(at(0, 59)))
+	assert.True(t, night.Contains(at(1, 0)))
+	assert.True(t, night.Contains(at(4, 59)))
+	assert.False(t, night.Contains(at(5, 0)))
+	assert.False(t, night.Contains(at(12, 0)))
+
+	// Windows can run past midnight
+	late := SyncWindow{Start: 22 * time.Hour, End: 2 * time.Hour, Location: time.UTC}
+	assert.True(t, late.Contains(at(23, 0)))
+	assert.True(t, late.Contains(at(1, 0)))
+	assert.False(t, late.Contains(at(2, 0)))
+	assert.False(t, late.Contains(at(21, 59)))
+
+	// And are in their own time zone
+	tokyo := time.FixedZone("JST", 9*60*60)
+	local := SyncWindow{Start: time.Hour, End: 5 * time.Hour, Location: tokyo}
+	assert.True(t, local.Contains(at(17, 0)))
+	assert.False(t, local.Contains(at(1, 0)))
+}
+
+func TestSyncWindows(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	open := SyncWindow{Start: 0, End: 24 * time.Hour}
+	closed := SyncWindow{Start: time.Hour, End: time.Hour}
+	accord.SyncWindows = []SyncWindow{closed}
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	hub := accord.AddPeer("hub")
+	edge := accord.AddPeer("edge")
+	msg, _ := NewMessage([]byte("hello"))
+	assert.Nil(t, accord.HandleNewMessage(msg))
+
+	// Outside of its windows a peer isn't handed anything, however it asks
+	assert.False(t, accord.InSyncWindow("hub"))
+	msgs, err := hub.Peek(10)
+	assert.Nil(t, err)
+	assert.Empty(t, msgs)
+	msgs, err = hub.Ship(10)
+	assert.Nil(t, err)
+	assert.Empty(t, msgs)
+	assert.Equal(t, uint64(1), hub.Pending())
+
+	// Peers can have windows of their own
+	accord.SetPeerSyncWindows("edge", []SyncWindow{closed, open})
+	assert.True(t, accord.InSyncWindow("edge"))
+	msgs, err = edge.Ship(10)
+	assert.Nil(t, err)
+	assert.Len(t, msgs, 1)
+
+	// And the rest follow ours
+	accord.SetSyncWindows(nil)
+	msgs, err = hub.Peek(10)
+	assert.Nil(t, err)
+	assert.Len(t, msgs, 1)
+}
@@ -0,0 +1,53 @@
+package accord
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sdNotify sends a notification to systemd (see sd_notify(3)) if we've been asked to and were started by
+// systemd as a Type=notify service. It's a no-op otherwise
+func (accord *Accord) sdNotify(state string) {
+	if !accord.SystemdNotify {
+		return
+	}
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+
+	// A leading @ stands for Linux's abstract namespace
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		accord.Logger.WithError(err).Warn("We could not notify systemd")
+		return
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	if err != nil {
+		accord.Logger.WithError(err).Warn("We could not notify systemd")
+	}
+}
+
+// watchdogInterval returns how often we should tell systemd we're still alive, which is half of the
+// WatchdogSec it's configured with, or zero if its watchdog isn't watching us
+func (accord *Accord) watchdogInterval() time.Duration {
+	if !accord.SystemdNotify || os.Getenv("NOTIFY_SOCKET") == "" {
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	// The watchdog may be meant for another process, like a shell script that started us
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
@@ -0,0 +1,56 @@
+package accord
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSystemdNotify(t *testing.T) {
+	defer AccordCleanup()
+	dir, err := ioutil.TempDir("", "accord-systemd")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	assert.Nil(t, err)
+	defer conn.Close()
+	os.Setenv("NOTIFY_SOCKET", socket)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+	os.Setenv("WATCHDOG_USEC", "20000")
+	defer os.Unsetenv("WATCHDOG_USEC")
+
+	received := func() string {
+		buf := make([]byte, 64)
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, err := conn.Read(buf)
+		assert.Nil(t, err)
+		return string(buf[:n])
+	}
+
+	accord := DummyAccord()
+	accord.SystemdNotify = true
+	assert.Nil(t, accord.Start())
+	assert.Equal(t, "READY=1", received())
+
+	done := make(chan error, 1)
+	go func() { done <- accord.Listen() }()
+	assert.Equal(t, "WATCHDOG=1", received())
+	assert.Equal(t, "WATCHDOG=1", received())
+
+	accord.Shutdown(errors.New("going down"))
+	<-done
+	// The watchdog may have been fed once more on the way down
+	state := received()
+	if state == "WATCHDOG=1" {
+		state = received()
+	}
+	assert.Equal(t, "STOPPING=1", state)
+}
@@ -1,17 +1,25 @@
 package accord
 
 import (
-	"io/ioutil"
 	"os"
+	"path/filepath"
 
 	"github.com/beeker1121/goque"
-	"github.com/sirupsen/logrus"
 )
 
 func AccordCleanup() {
 	os.RemoveAll(SyncFilename)
 	os.RemoveAll(HistoryFilename)
+	os.RemoveAll(HistoryFilename + pruningSuffix)
+	os.RemoveAll(HistoryFilename + prunedSuffix)
 	os.RemoveAll(StateFilename)
+	os.RemoveAll(DeadLetterFilename)
+	os.RemoveAll(ChannelsDirname)
+
+	shards, _ := filepath.Glob(SyncFilename + ".*")
+	for _, shard := range shards {
+		os.RemoveAll(shard)
+	}
 }
 
 type DummyManager struct {
@@ -40,12 +48,5 @@ func (manager DummyManager) ShouldProcess(msg Message, history *goque.Stack) boo
 }
 
 func DummyAccord() *Accord {
-	blankLogger := &logrus.Logger{
-		Out:       ioutil.Discard,
-		Formatter: new(logrus.TextFormatter),
-		Hooks:     make(logrus.LevelHooks),
-		Level:     logrus.InfoLevel,
-	}
-
-	return NewAccord(NewDummerManager(), nil, "", blankLogger.WithFields(nil))
+	return NewAccord(NewDummerManager(), nil, "", NopLogger())
 }
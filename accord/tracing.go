@@ -0,0 +1,84 @@
+package accord
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation name our spans are recorded under
+const tracerName = "github.com/Ssawa/accord"
+
+// tracePropagator carries trace context in a Message's Headers, using the W3C "traceparent" and
+// "tracestate" headers. We always use it rather than the global propagator, which does nothing unless the
+// application sets one up, because the whole point is for the context to make it to the other side
+var tracePropagator = propagation.TraceContext{}
+
+// tracer returns the Tracer our spans should be recorded with: our own Tracer if one was set, otherwise
+// one from the global TracerProvider (which doesn't record anything unless the application configured one)
+func (accord *Accord) tracer() trace.Tracer {
+	if accord.Tracer != nil {
+		return accord.Tracer
+	}
+	return otel.Tracer(tracerName)
+}
+
+// injectTrace records the span in ctx onto a Message's Headers, so that it travels with the Message
+func injectTrace(ctx context.Context, msg *Message) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return
+	}
+	if msg.Headers == nil {
+		msg.Headers = map[string]string{}
+	}
+	tracePropagator.Inject(ctx, propagation.MapCarrier(msg.Headers))
+}
+
+// MessageContext returns a context carrying the trace context a Message was created under, if it has one
+func MessageContext(ctx context.Context, msg *Message) context.Context {
+	if msg.Headers == nil {
+		return ctx
+	}
+	return tracePropagator.Extract(ctx, propagation.MapCarrier(msg.Headers))
+}
+
+// TraceMessage starts a span for something being done with a Message, as a child of the span the Message
+// was created under. Components use it to record transporting Messages, so that an operation can be
+// followed from the node it was created on all the way to being processed by its peers. The span must be
+// ended by the caller
+func (accord *Accord) TraceMessage(msg *Message, name string, attributes ...attribute.KeyValue) trace.Span {
+	_, span := accord.startSpan(MessageContext(context.Background(), msg), name, msg, attributes...)
+	return span
+}
+
+// startSpan starts one of our spans about a Message
+func (accord *Accord) startSpan(ctx context.Context, name string, msg *Message, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	attributes = append(attributes,
+		attribute.String("accord.node", accord.NodeID),
+		attribute.Int64("accord.message.id", int64(msg.ID)),
+		attribute.String("accord.message.origin", msg.Origin),
+	)
+	if msg.Type != "" {
+		attributes = append(attributes, attribute.String("accord.message.type", msg.Type))
+	}
+	if msg.CorrelationID != "" {
+		attributes = append(attributes, attribute.String("accord.message.correlation_id", msg.CorrelationID))
+	}
+	if msg.CausationID != 0 {
+		attributes = append(attributes, attribute.Int64("accord.message.causation_id", int64(msg.CausationID)))
+	}
+	return accord.tracer().Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// endSpan ends a span, recording the error it ended with if there was one
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
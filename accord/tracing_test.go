@@ -0,0 +1,57 @@
+package accord
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracing(t *testing.T) {
+	defer AccordCleanup()
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+
+	local := DummyAccord()
+	local.NodeID = "local"
+	local.Tracer = tracer
+	assert.Nil(t, local.Start())
+	defer local.Stop()
+
+	dir, err := ioutil.TempDir("", "accord-tracing")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	remote := NewAccord(NewDummerManager(), nil, dir, local.Logger)
+	remote.NodeID = "remote"
+	remote.Tracer = tracer
+	assert.Nil(t, remote.Start())
+	defer remote.Stop()
+
+	ctx, operation := tracer.Start(context.Background(), "operation")
+	msg, _ := NewMessage([]byte("hello"))
+	assert.Nil(t, local.HandleNewMessageContext(ctx, msg))
+	operation.End()
+	assert.NotEmpty(t, msg.Headers["traceparent"])
+
+	transport := local.TraceMessage(msg, "accord.transport")
+	transport.End()
+	assert.Nil(t, remote.HandleRemoteMessage(msg))
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+		assert.Equal(t, operation.SpanContext().TraceID(), span.SpanContext().TraceID(), span.Name())
+	}
+
+	enqueue := spans["accord.enqueue"].SpanContext().SpanID()
+	receive := spans["accord.receive"].SpanContext().SpanID()
+	assert.Equal(t, operation.SpanContext().SpanID(), spans["accord.enqueue"].Parent().SpanID())
+	assert.Equal(t, enqueue, spans["accord.transport"].Parent().SpanID())
+	assert.Equal(t, enqueue, spans["accord.receive"].Parent().SpanID())
+	assert.Equal(t, receive, spans["accord.should_process"].Parent().SpanID())
+	assert.Equal(t, receive, spans["accord.process"].Parent().SpanID())
+}
@@ -0,0 +1,110 @@
+package accord
+
+// TransactionalManager is a Manager whose work on a Message can be held open until we've updated our own
+// state with it, so that the application's database and our bookkeeping can't get out of step when one of
+// them fails. Accord calls Begin before Process, and once Process has succeeded and our state has been
+// updated in memory calls Commit, only writing our state down once Commit has succeeded. If Process, our
+// state update or Commit fails, Rollback is called (except after a failed Commit) and our state is left
+// as it was, so the Message can be retried, dead lettered or blown up over as it would be without a
+// transaction. A Begin or Commit that fails is dealt with like Process failing: a RetryableError from
+// Commit is just as retryable. Rollback is never called once Commit has succeeded: if we then fail to
+// write our state down we blow up our application, leaving it ahead of us as though we'd gone down in
+// between.
+//
+// Accord calls these on any Manager that has them (and on the ContextManager behind AdaptContextManager),
+// one after the other for each Message, with the one exception that when Process is given up on after
+// ProcessTimeout, Rollback is called without waiting for it to return. Our state is only written
+// down after Commit, so going down in between leaves the application ahead of us; Process should be
+// idempotent for Messages it's already committed (the Message's ID is a good thing to record with them),
+// since they'll be processed again.
+//
+// Each Message in a group (see HandleNewMessages) gets its own transaction, begun just before it's
+// processed, so the ones before it are already open (and processed) when it's begun. None of them are
+// committed until the whole group has been processed, so a failure part way through rolls back all of those
+// begun so far, but they're committed one after the other: one failing to commit after others have means
+// ours is rolled back while theirs stay committed
+type TransactionalManager interface {
+	Begin(msg *Message, fromRemote bool) error
+	Commit(msg *Message) error
+	Rollback(msg *Message) error
+}
+
+// managerTx is a Message's transaction with its TransactionalManager. A nil one is a Message whose
+// Manager isn't transactional, for which committing and rolling back do nothing
+type managerTx struct {
+	accord  *Accord
+	manager TransactionalManager
+	msg     *Message
+}
+
+// transactionalManager returns the Manager as a TransactionalManager, if it is one
+func transactionalManager(manager Manager) (TransactionalManager, bool) {
+	if adapter, ok := manager.(contextAdapter); ok {
+		txManager, ok := adapter.ContextManager.(TransactionalManager)
+		return txManager, ok
+	}
+	txManager, ok := manager.(TransactionalManager)
+	return txManager, ok
+}
+
+// beginTx begins a transaction for a Message with its Manager, if it's a TransactionalManager, recovering
+// from any panic
+func (accord *Accord) beginTx(msg *Message, fromRemote bool) (tx *managerTx, err error) {
+	defer recoverPanic("Manager.Begin", &err)
+	manager, ok := transactionalManager(accord.managerFor(msg))
+	if !ok {
+		return nil, nil
+	}
+	err = manager.Begin(msg, fromRemote)
+	if err != nil {
+		return nil, err
+	}
+	return &managerTx{accord: accord, manager: manager, msg: msg}, nil
+}
+
+// commit commits the transaction, recovering from any panic
+func (tx *managerTx) commit() (err error) {
+	if tx == nil {
+		return nil
+	}
+	defer recoverPanic("Manager.Commit", &err)
+	return tx.manager.Commit(tx.msg)
+}
+
+// rollback rolls the transaction back, recovering from any panic. There's nothing more we can do about a
+// Rollback failing, so it's only logged
+func (tx *managerTx) rollback() {
+	if tx == nil {
+		return
+	}
+	var err error
+	func() {
+		defer recoverPanic("Manager.Rollback", &err)
+		err = tx.manager.Rollback(tx.msg)
+	}()
+	if err != nil {
+		WithMessage(tx.accord.Logger, tx.msg).WithError(err).Warn("The manager could not roll back its transaction for a message")
+	}
+}
+
+// commitAll commits each of a group of transactions in turn, rolling back the rest if one fails, which is
+// returned along with its error
+func commitAll(txs []*managerTx) (*managerTx, error) {
+	for i, tx := range txs {
+		err := tx.commit()
+		if err != nil {
+			for _, rest := range txs[i+1:] {
+				rest.rollback()
+			}
+			return tx, err
+		}
+	}
+	return nil, nil
+}
+
+// rollbackAll rolls back each of a group of transactions
+func rollbackAll(txs []*managerTx) {
+	for _, tx := range txs {
+		tx.rollback()
+	}
+}
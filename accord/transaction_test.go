@@ -0,0 +1,140 @@
+package accord
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/beeker1121/goque"
+	"github.com/stretchr/testify/assert"
+)
+
+// txManager is a TransactionalManager that records each call it gets, failing to process or commit the
+// Messages it's told to
+type txManager struct {
+	calls       []string
+	failProcess uint64
+	failCommit  uint64
+	afterCommit func()
+}
+
+func (manager *txManager) Begin(msg *Message, fromRemote bool) error {
+	manager.calls = append(manager.calls, fmt.Sprintf("begin %d", msg.ID))
+	return nil
+}
+
+func (manager *txManager) Process(msg *Message, fromRemote bool) error {
+	manager.calls = append(manager.calls, fmt.Sprintf("process %d", msg.ID))
+	if msg.ID == manager.failProcess {
+		return &RetryableError{Err: errors.New("database unavailable")}
+	}
+	return nil
+}
+
+func (manager *txManager) Commit(msg *Message) error {
+	manager.calls = append(manager.calls, fmt.Sprintf("commit %d", msg.ID))
+	if msg.ID == manager.failCommit {
+		return &RetryableError{Err: errors.New("serialization failure")}
+	}
+	if manager.afterCommit != nil {
+		manager.afterCommit()
+	}
+	return nil
+}
+
+func (manager *txManager) Rollback(msg *Message) error {
+	manager.calls = append(manager.calls, fmt.Sprintf("rollback %d", msg.ID))
+	return nil
+}
+
+func (manager *txManager) ShouldProcess(msg Message, history *goque.Stack) bool {
+	return true
+}
+
+func TestTransactionalManager(t *testing.T) {
+	defer AccordCleanup()
+	manager := &txManager{failProcess: 2, failCommit: 3}
+	accord := NewAccord(manager, nil, "", DummyAccord().Logger)
+	accord.NodeID = "local"
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	assert.Nil(t, accord.HandleNewMessage(&Message{ID: 1, Key: "user"}))
+	assert.Equal(t, []string{"begin 1", "process 1", "commit 1"}, manager.calls)
+
+	manager.calls = nil
+	_, ok := accord.HandleNewMessage(&Message{ID: 2}).(*RetryableError)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"begin 2", "process 2", "rollback 2"}, manager.calls)
+
+	// A failed commit leaves our state as it was, so the Message can be retried as though it never happened
+	manager.calls = nil
+	_, ok = accord.HandleNewMessage(&Message{ID: 3, Key: "user", IdempotencyKey: "once"}).(*RetryableError)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"begin 3", "process 3", "commit 3"}, manager.calls)
+	assert.Equal(t, uint64(1), accord.History().Len())
+	assert.Equal(t, uint64(1), accord.CurrentState())
+	assert.Equal(t, VectorClock{"local": 1}, accord.Clock())
+
+	manager.failCommit = 0
+	msg := &Message{ID: 3, Key: "user", IdempotencyKey: "once"}
+	assert.Nil(t, accord.HandleNewMessage(msg))
+	assert.Equal(t, uint64(2), msg.KeySeq)
+	assert.Equal(t, uint64(4), accord.CurrentState())
+}
+
+func TestTransactionalManagerGroup(t *testing.T) {
+	defer AccordCleanup()
+	manager := &txManager{failProcess: 2}
+	accord := NewAccord(manager, nil, "", DummyAccord().Logger)
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	// Failing part way through rolls back the ones that were already processed
+	_, ok := accord.HandleNewMessages(&Message{ID: 1}, &Message{ID: 2}, &Message{ID: 3}).(*RetryableError)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"begin 1", "process 1", "begin 2", "process 2", "rollback 2", "rollback 1"}, manager.calls)
+
+	// As does failing to commit, taking the group back out of our history
+	manager.calls = nil
+	manager.failProcess = 0
+	manager.failCommit = 1
+	_, ok = accord.HandleNewMessages(&Message{ID: 1}, &Message{ID: 2}).(*RetryableError)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"begin 1", "process 1", "begin 2", "process 2", "commit 1", "rollback 2"}, manager.calls)
+	assert.Equal(t, uint64(0), accord.History().Len())
+	assert.Equal(t, uint64(0), accord.CurrentState())
+
+	manager.calls = nil
+	manager.failCommit = 0
+	assert.Nil(t, accord.HandleNewMessages(&Message{ID: 1}, &Message{ID: 2}))
+	assert.Equal(t, []string{"begin 1", "process 1", "begin 2", "process 2", "commit 1", "commit 2"}, manager.calls)
+	assert.Equal(t, uint64(2), accord.History().Len())
+	assert.Equal(t, uint64(3), accord.CurrentState())
+}
+
+func TestTransactionalManagerStateWriteFails(t *testing.T) {
+	for _, group := range []bool{false, true} {
+		func() {
+			defer AccordCleanup()
+			manager := &txManager{}
+			accord := NewAccord(manager, nil, "", DummyAccord().Logger)
+			assert.Nil(t, accord.Start())
+			defer accord.Stop()
+
+			// Once committed, a transaction isn't rolled back when our state can't be written down after it;
+			// we blow up instead, with our Manager ahead of us
+			manager.afterCommit = func() { accord.state.db.Close() }
+			var err error
+			if group {
+				err = accord.HandleNewMessages(&Message{ID: 1}, &Message{ID: 2})
+				assert.Equal(t, []string{"begin 1", "process 1", "begin 2", "process 2", "commit 1", "commit 2"}, manager.calls)
+			} else {
+				err = accord.HandleNewMessage(&Message{ID: 1})
+				assert.Equal(t, []string{"begin 1", "process 1", "commit 1"}, manager.calls)
+			}
+			assert.NotNil(t, err)
+			assert.Equal(t, err, accord.Listen())
+		}()
+	}
+}
@@ -0,0 +1,199 @@
+package accord
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/beeker1121/goque"
+)
+
+// Codec converts values to and from Message payloads
+type Codec interface {
+	Marshal(value interface{}) ([]byte, error)
+	Unmarshal(data []byte, value interface{}) error
+}
+
+// JSONCodec encodes payloads as JSON, it's what the typed helpers use unless told otherwise
+var JSONCodec Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(value interface{}) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (jsonCodec) Unmarshal(data []byte, value interface{}) error {
+	return json.Unmarshal(data, value)
+}
+
+// UnhandledTypeError is returned by TypedManager when it's asked to process a Message whose Type has no
+// handler registered
+type UnhandledTypeError struct {
+	Type string
+}
+
+func (err *UnhandledTypeError) Error() string {
+	return fmt.Sprintf("no handler registered for message type %q", err.Type)
+}
+
+// TypedHandler processes a Message whose payload has already been decoded into a T
+type TypedHandler[T any] func(value T, msg *Message, fromRemote bool) error
+
+// TypedManager is a Manager that takes care of the boilerplate most Managers end up duplicating: it decodes
+// payloads into a T, dispatches them to a handler registered for the Message's Type and encodes new values
+// into Messages. As the handlers are typed, mismatches between what gets sent and what gets processed are
+// caught by the compiler instead of at runtime
+//
+//	manager := accord.NewTypedManager[UserEvent]()
+//	manager.Handle("user.update", func(event UserEvent, msg *accord.Message, fromRemote bool) error {
+//		return db.UpdateUser(event.ID, event.Name)
+//	})
+type TypedManager[T any] struct {
+	// Codec is used to encode and decode payloads, it defaults to JSONCodec
+	Codec Codec
+
+	// Resolver is used for ShouldProcess. Its zero value accepts every Message we haven't already performed
+	Resolver Resolver
+
+	// Fallback, if set, is called for Messages whose Type has no handler registered. Otherwise they cause
+	// Process to return an UnhandledTypeError
+	Fallback func(msg *Message, fromRemote bool) error
+
+	handlers map[string]TypedHandler[T]
+}
+
+// NewTypedManager creates a TypedManager with no handlers registered
+func NewTypedManager[T any]() *TypedManager[T] {
+	return &TypedManager[T]{handlers: make(map[string]TypedHandler[T])}
+}
+
+// Handle registers the handler for a Message Type, replacing any handler already registered for it
+func (manager *TypedManager[T]) Handle(msgType string, handler TypedHandler[T]) {
+	if manager.handlers == nil {
+		manager.handlers = make(map[string]TypedHandler[T])
+	}
+	manager.handlers[msgType] = handler
+}
+
+// NewMessage encodes a value into a brand new Message of the given Type, ready to be passed to
+// HandleNewMessage
+func (manager *TypedManager[T]) NewMessage(msgType string, value T) (*Message, error) {
+	return encodeTyped(manager.Codec, msgType, value)
+}
+
+// Decode decodes a Message's payload into a T
+func (manager *TypedManager[T]) Decode(msg *Message) (T, error) {
+	return decodeTyped[T](manager.Codec, msg)
+}
+
+// Process implements Manager by decoding the payload and dispatching it to the handler for its Type
+func (manager *TypedManager[T]) Process(msg *Message, fromRemote bool) error {
+	handler, ok := manager.handlers[msg.Type]
+	if !ok {
+		if manager.Fallback != nil {
+			return manager.Fallback(msg, fromRemote)
+		}
+		return &UnhandledTypeError{Type: msg.Type}
+	}
+
+	value, err := manager.Decode(msg)
+	if err != nil {
+		return err
+	}
+
+	return handler(value, msg, fromRemote)
+}
+
+// ShouldProcess implements Manager using the configured Resolver
+func (manager *TypedManager[T]) ShouldProcess(msg Message, history *goque.Stack) bool {
+	return manager.Resolver.ShouldProcess(msg, history)
+}
+
+// Typed is a Manager for a single Message Type whose payload is a T, for use with Accord.Handle: it
+// decodes each payload and hands it to a typed callback, and encodes values into new Messages of its
+// Type, so that none of that has to be written by hand. Use HandleTyped to create and register one in one
+// go
+//
+//	users := accord.HandleTyped(acc, "user.update", func(event UserEvent, msg *accord.Message, fromRemote bool) error {
+//		return db.UpdateUser(event.ID, event.Name)
+//	})
+//	msg, err := users.NewMessage(UserEvent{ID: 1, Name: "Ada"})
+type Typed[T any] struct {
+	// Type is the Message Type we deal with
+	Type string
+
+	// Codec is used to encode and decode payloads, it defaults to JSONCodec
+	Codec Codec
+
+	// Resolver is used for ShouldProcess. Its zero value accepts every Message we haven't already performed
+	Resolver Resolver
+
+	handler TypedHandler[T]
+}
+
+// NewTyped creates a Typed for a Message Type, calling handler with the decoded payload of each Message
+// it processes
+func NewTyped[T any](msgType string, handler TypedHandler[T]) *Typed[T] {
+	return &Typed[T]{Type: msgType, handler: handler}
+}
+
+// HandleTyped creates a Typed for a Message Type and registers it with Accord.Handle. Like Handle, this
+// should be called before Start
+func HandleTyped[T any](accord *Accord, msgType string, handler TypedHandler[T]) *Typed[T] {
+	typed := NewTyped(msgType, handler)
+	accord.Handle(msgType, typed)
+	return typed
+}
+
+// NewMessage encodes a value into a brand new Message of our Type, ready to be passed to HandleNewMessage
+func (typed *Typed[T]) NewMessage(value T) (*Message, error) {
+	return encodeTyped(typed.Codec, typed.Type, value)
+}
+
+// Decode decodes a Message's payload into a T
+func (typed *Typed[T]) Decode(msg *Message) (T, error) {
+	return decodeTyped[T](typed.Codec, msg)
+}
+
+// Process implements Manager by decoding the payload and passing it to our handler
+func (typed *Typed[T]) Process(msg *Message, fromRemote bool) error {
+	value, err := typed.Decode(msg)
+	if err != nil {
+		return err
+	}
+	return typed.handler(value, msg, fromRemote)
+}
+
+// ShouldProcess implements Manager using the configured Resolver
+func (typed *Typed[T]) ShouldProcess(msg Message, history *goque.Stack) bool {
+	return typed.Resolver.ShouldProcess(msg, history)
+}
+
+// encodeTyped encodes a value into a new Message of the given Type, using JSONCodec if codec is nil
+func encodeTyped[T any](codec Codec, msgType string, value T) (*Message, error) {
+	if codec == nil {
+		codec = JSONCodec
+	}
+	payload, err := codec.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := NewMessage(payload)
+	if err != nil {
+		return nil, err
+	}
+	msg.Type = msgType
+
+	return msg, nil
+}
+
+// decodeTyped decodes a Message's payload into a T, using JSONCodec if codec is nil
+func decodeTyped[T any](codec Codec, msg *Message) (T, error) {
+	if codec == nil {
+		codec = JSONCodec
+	}
+	var value T
+	err := codec.Unmarshal(msg.Payload, &value)
+	return value, err
+}
@@ -0,0 +1,91 @@
+package accord
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type userEvent struct {
+	ID   int
+	Name string
+}
+
+func TestTypedManager(t *testing.T) {
+	defer AccordCleanup()
+
+	manager := NewTypedManager[userEvent]()
+	updated := []userEvent{}
+	manager.Handle("user.update", func(event userEvent, msg *Message, fromRemote bool) error {
+		updated = append(updated, event)
+		return nil
+	})
+
+	accord := DummyAccord()
+	accord.manager = manager
+	accord.Start()
+	defer accord.Stop()
+
+	msg, err := manager.NewMessage("user.update", userEvent{ID: 42, Name: "Bob"})
+	assert.Nil(t, err)
+	assert.Equal(t, "user.update", msg.Type)
+	assert.Nil(t, accord.HandleNewMessage(msg))
+	assert.Equal(t, []userEvent{{42, "Bob"}}, updated)
+
+	decoded, err := manager.Decode(msg)
+	assert.Nil(t, err)
+	assert.Equal(t, userEvent{42, "Bob"}, decoded)
+
+	// The zero Resolver should keep us from performing the same message twice
+	assert.False(t, manager.ShouldProcess(*msg, accord.historyStack))
+}
+
+func TestTypedManagerUnhandled(t *testing.T) {
+	manager := NewTypedManager[userEvent]()
+
+	err := manager.Process(&Message{Type: "user.delete", Payload: []byte("{}")}, false)
+	assert.Equal(t, &UnhandledTypeError{Type: "user.delete"}, err)
+
+	fellBack := false
+	manager.Fallback = func(msg *Message, fromRemote bool) error {
+		fellBack = true
+		return nil
+	}
+	assert.Nil(t, manager.Process(&Message{Type: "user.delete"}, false))
+	assert.True(t, fellBack)
+}
+
+func TestTypedManagerDecodeError(t *testing.T) {
+	manager := NewTypedManager[userEvent]()
+	manager.Handle("user.update", func(event userEvent, msg *Message, fromRemote bool) error {
+		return nil
+	})
+
+	assert.NotNil(t, manager.Process(&Message{Type: "user.update", Payload: []byte("not json")}, true))
+}
+
+func TestHandleTyped(t *testing.T) {
+	defer AccordCleanup()
+
+	accord := DummyAccord()
+	updated := []userEvent{}
+	users := HandleTyped(accord, "user.update", func(event userEvent, msg *Message, fromRemote bool) error {
+		updated = append(updated, event)
+		return nil
+	})
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	msg, err := users.NewMessage(userEvent{ID: 7, Name: "Ada"})
+	assert.Nil(t, err)
+	assert.Equal(t, "user.update", msg.Type)
+	assert.Nil(t, accord.HandleNewMessage(msg))
+	assert.Equal(t, []userEvent{{7, "Ada"}}, updated)
+
+	// Messages of other types are left to the Manager passed to NewAccord
+	other, err := NewMessage([]byte("not a user event"))
+	assert.Nil(t, err)
+	other.Type = "user.delete"
+	assert.Nil(t, accord.HandleNewMessage(other))
+	assert.Len(t, updated, 1)
+}
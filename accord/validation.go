@@ -0,0 +1,117 @@
+package accord
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// EventMessageInvalid is emitted when a Message is refused by one of the Validators for its Type
+const EventMessageInvalid = "message_invalid"
+
+// Validator checks that a Message is well formed, typically by decoding its payload, returning an error
+// describing what's wrong with it if it isn't. Validators are run before anything else is done with a
+// Message, so they shouldn't modify it or depend on anything but the Message itself (that's what pipeline
+// stages are for, see RegisterStage)
+type Validator func(msg *Message) error
+
+// ValidationError is returned when a Validator refuses a Message
+type ValidationError struct {
+	Type string
+	ID   uint64
+	Err  error
+}
+
+func (err *ValidationError) Error() string {
+	return fmt.Sprintf("message %d of type %q is invalid: %s", err.ID, err.Type, err.Err)
+}
+
+func (err *ValidationError) Unwrap() error {
+	return err.Err
+}
+
+// AddValidator registers a Validator for Messages of the given Type, or for every Message with AnyType.
+// Validators are run in the order they were added, those for AnyType first, and the first to refuse a
+// Message stops the rest from running.
+//
+// Unlike a pipeline's validate stages, which run once a Message has been stamped and while it's being
+// processed, Validators run before a new Message is even considered, so a malformed one is refused with
+// a ValidationError without costing us anything (a group, see HandleNewMessages, is refused as a whole).
+// Remote Messages are validated before the Manager sees them too, so that a Manager doesn't have to
+// defend itself against payloads it can't decode, where failing is fatal; an invalid remote Message is
+// rejected with a StageError wrapping the ValidationError, and like any other rejected Message isn't
+// retried. This should be called before Start
+func (accord *Accord) AddValidator(msgType string, validator Validator) {
+	if accord.validators == nil {
+		accord.validators = make(map[string][]Validator)
+	}
+	accord.validators[msgType] = append(accord.validators[msgType], validator)
+}
+
+// validate runs the Validators for a Message, returning a ValidationError if one of them refuses it
+func (accord *Accord) validate(msg *Message) error {
+	if len(accord.validators) == 0 {
+		return nil
+	}
+	for _, validators := range [][]Validator{accord.validators[AnyType], accord.validators[msg.Type]} {
+		for _, validator := range validators {
+			err := validator(msg)
+			if err != nil {
+				return &ValidationError{Type: msg.Type, ID: msg.ID, Err: err}
+			}
+		}
+	}
+	return nil
+}
+
+// validateNew runs the Validators for a new Message, recording its rejection if one of them refuses it
+func (accord *Accord) validateNew(msg *Message) error {
+	err := accord.validate(msg)
+	if err != nil {
+		WithMessage(accord.Logger, msg).WithError(err).Info("A new message was refused as invalid")
+		accord.metrics().Count(MetricMessagesRejected, 1)
+		accord.Emit(EventMessageInvalid, "A new message was refused as invalid", map[string]interface{}{"id": msg.ID, "type": msg.Type, "error": err.Error()})
+		accord.recordAudit(msg, false, AuditRejected, err)
+	}
+	return err
+}
+
+// validateRemote runs the Validators for a remote Message, returning a StageError if one of them refuses it
+func (accord *Accord) validateRemote(msg *Message) error {
+	err := accord.validate(msg)
+	if err == nil {
+		return nil
+	}
+
+	WithMessage(accord.Logger, msg).WithError(err).Warn("Rejecting a remote message as invalid")
+	accord.metrics().Count(MetricMessagesRejected, 1)
+	accord.Emit(EventMessageInvalid, "A remote message was rejected as invalid", map[string]interface{}{"id": msg.ID, "origin": msg.Origin, "type": msg.Type, "error": err.Error()})
+	return &StageError{Stage: "validator", Err: err}
+}
+
+// JSONSchema returns a Validator enforcing that payloads are a single JSON value describing a T, and
+// nothing more: fields T doesn't have and values of the wrong kind are refused. If T (or a pointer to it)
+// has a "Validate() error" method, it's called on the decoded value to check whatever a struct can't
+// express, such as required fields or ranges
+//
+//	acc.AddValidator("user.update", accord.JSONSchema[UserEvent]())
+func JSONSchema[T any]() Validator {
+	return func(msg *Message) error {
+		decoder := json.NewDecoder(bytes.NewReader(msg.Payload))
+		decoder.DisallowUnknownFields()
+		value := new(T)
+		err := decoder.Decode(value)
+		if err != nil {
+			return err
+		}
+		if _, err := decoder.Token(); err != io.EOF {
+			return errors.New("the payload holds more than a single JSON value")
+		}
+		if validatable, ok := interface{}(value).(interface{ Validate() error }); ok {
+			return validatable.Validate()
+		}
+		return nil
+	}
+}
@@ -0,0 +1,94 @@
+package accord
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type validatedUser struct {
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+}
+
+func (user validatedUser) Validate() error {
+	if user.Name == "" {
+		return errors.New("a name is required")
+	}
+	return nil
+}
+
+func TestJSONSchema(t *testing.T) {
+	validate := JSONSchema[validatedUser]()
+	assert.Nil(t, validate(&Message{Payload: []byte(`{"name":"ada","age":36}`)}))
+	assert.Nil(t, validate(&Message{Payload: []byte(` {"name":"ada"} `)}))
+
+	for _, payload := range []string{
+		``,
+		`not json`,
+		`{"name":"ada","admin":true}`,
+		`{"name":"ada","age":"36"}`,
+		`{"name":"ada"} {"name":"bob"}`,
+		`{"name":"ada"}]`,
+		`{"age":36}`,
+	} {
+		assert.NotNil(t, validate(&Message{Payload: []byte(payload)}), payload)
+	}
+}
+
+func TestValidators(t *testing.T) {
+	defer AccordCleanup()
+	accord := DummyAccord()
+	accord.AddValidator("user.create", JSONSchema[validatedUser]())
+	accord.AddValidator(AnyType, func(msg *Message) error {
+		if len(msg.Payload) > 64 {
+			return errors.New("the payload is too large")
+		}
+		return nil
+	})
+	invalid := []Event{}
+	accord.Subscribe(func(event Event) {
+		if event.Kind == EventMessageInvalid {
+			invalid = append(invalid, event)
+		}
+	})
+	assert.Nil(t, accord.Start())
+	defer accord.Stop()
+
+	assert.Nil(t, accord.HandleNewMessage(&Message{ID: 1, Type: "user.create", Payload: []byte(`{"name":"ada"}`)}))
+	assert.Nil(t, accord.HandleNewMessage(&Message{ID: 2, Type: "user.delete", Payload: []byte(`ada`)}))
+
+	err := accord.HandleNewMessage(&Message{ID: 3, Type: "user.create", Payload: []byte(`{"name":""}`)})
+	if assert.IsType(t, &ValidationError{}, err) {
+		assert.Equal(t, "user.create", err.(*ValidationError).Type)
+		assert.Equal(t, uint64(3), err.(*ValidationError).ID)
+	}
+	err = accord.HandleNewMessage(&Message{ID: 4, Type: "user.delete", Payload: make([]byte, 65)})
+	assert.IsType(t, &ValidationError{}, err)
+	assert.Equal(t, uint64(2), accord.History().Len())
+
+	// A group is refused as a whole
+	err = accord.HandleNewMessages(
+		&Message{ID: 5, Type: "user.create", Payload: []byte(`{"name":"bob"}`)},
+		&Message{ID: 6, Type: "user.create", Payload: []byte(`{"name":"bob","admin":true}`)},
+	)
+	assert.IsType(t, &ValidationError{}, err)
+	assert.Equal(t, uint64(2), accord.History().Len())
+
+	// Invalid remote Messages are rejected like any other, and don't hold back the ones after them
+	err = accord.HandleRemoteMessage(&Message{ID: 7, Type: "user.create", Origin: "remote", Payload: []byte(`{}`)})
+	if assert.IsType(t, &StageError{}, err) {
+		assert.Equal(t, "validator", err.(*StageError).Stage)
+	}
+	validationErr := &ValidationError{}
+	assert.True(t, errors.As(err, &validationErr))
+	assert.Equal(t, uint64(7), validationErr.ID)
+	assert.Nil(t, accord.HandleRemoteMessage(&Message{ID: 8, Type: "user.create", Origin: "remote", Payload: []byte(`{"name":"eve"}`)}))
+	assert.Equal(t, uint64(3), accord.History().Len())
+
+	if assert.Len(t, invalid, 4) {
+		assert.Equal(t, "remote", invalid[3].Fields["origin"])
+		assert.Equal(t, "user.create", invalid[3].Fields["type"])
+	}
+}
@@ -0,0 +1,113 @@
+package accord
+
+import (
+	"bytes"
+	"encoding/gob"
+)
+
+// ClockOrdering describes how two VectorClocks relate to each other
+type ClockOrdering int
+
+const (
+	// ClockEqual means both clocks have seen exactly the same events
+	ClockEqual ClockOrdering = iota
+
+	// ClockBefore means the first clock causally happened before the second
+	ClockBefore
+
+	// ClockAfter means the first clock causally happened after the second
+	ClockAfter
+
+	// ClockConcurrent means neither clock has seen everything the other has, so the events they
+	// represent happened concurrently (and are likely in conflict)
+	ClockConcurrent
+)
+
+// VectorClock keeps a counter of how many Messages each node has created, as far as the holder of the
+// clock knows. Accord maintains one per process and stamps a copy onto every Message it creates, so that
+// ShouldProcess implementations can tell whether a remote Message was created with knowledge of a local
+// one (and is therefore simply newer) or without it (and is a genuine conflict) rather than guessing from
+// wall clock timestamps
+type VectorClock map[string]uint64
+
+// Copy returns an independent copy of the clock
+func (clock VectorClock) Copy() VectorClock {
+	copied := make(VectorClock, len(clock))
+	for node, count := range clock {
+		copied[node] = count
+	}
+	return copied
+}
+
+// Increment bumps the counter for the given node
+func (clock VectorClock) Increment(node string) {
+	clock[node]++
+}
+
+// Merge updates the clock to include every event the other clock has seen
+func (clock VectorClock) Merge(other VectorClock) {
+	for node, count := range other {
+		if count > clock[node] {
+			clock[node] = count
+		}
+	}
+}
+
+// Compare determines the causal relationship between this clock and another
+func (clock VectorClock) Compare(other VectorClock) ClockOrdering {
+	before, after := false, false
+
+	for node, count := range clock {
+		if count > other[node] {
+			after = true
+		} else if count < other[node] {
+			before = true
+		}
+	}
+	for node, count := range other {
+		if _, ok := clock[node]; !ok && count > 0 {
+			before = true
+		}
+	}
+
+	switch {
+	case before && after:
+		return ClockConcurrent
+	case before:
+		return ClockBefore
+	case after:
+		return ClockAfter
+	default:
+		return ClockEqual
+	}
+}
+
+// HappenedBefore returns whether this clock causally precedes the other
+func (clock VectorClock) HappenedBefore(other VectorClock) bool {
+	return clock.Compare(other) == ClockBefore
+}
+
+// ConcurrentWith returns whether neither clock causally precedes the other
+func (clock VectorClock) ConcurrentWith(other VectorClock) bool {
+	return clock.Compare(other) == ClockConcurrent
+}
+
+// marshal encodes the clock so that it can be persisted
+func (clock VectorClock) marshal() ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(map[string]uint64(clock))
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// unmarshalVectorClock is the reverse of marshal
+func unmarshalVectorClock(data []byte) (VectorClock, error) {
+	clock := VectorClock{}
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&clock)
+	if err != nil {
+		return nil, err
+	}
+	return clock, nil
+}
@@ -0,0 +1,97 @@
+package accord
+
+import (
+	"testing"
+
+	"github.com/beeker1121/goque"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVectorClockCompare(t *testing.T) {
+	assert.Equal(t, ClockEqual, VectorClock{}.Compare(VectorClock{}))
+	assert.Equal(t, ClockEqual, VectorClock{"a": 1}.Compare(VectorClock{"a": 1, "b": 0}))
+	assert.Equal(t, ClockBefore, VectorClock{"a": 1}.Compare(VectorClock{"a": 2}))
+	assert.Equal(t, ClockBefore, VectorClock{"a": 1}.Compare(VectorClock{"a": 1, "b": 1}))
+	assert.Equal(t, ClockAfter, VectorClock{"a": 2, "b": 1}.Compare(VectorClock{"a": 1}))
+	assert.Equal(t, ClockConcurrent, VectorClock{"a": 2}.Compare(VectorClock{"a": 1, "b": 1}))
+
+	assert.True(t, VectorClock{"a": 1}.HappenedBefore(VectorClock{"a": 2}))
+	assert.True(t, VectorClock{"a": 1}.ConcurrentWith(VectorClock{"b": 1}))
+}
+
+func TestVectorClockMerge(t *testing.T) {
+	clock := VectorClock{"a": 3, "b": 1}
+	clock.Merge(VectorClock{"a": 1, "b": 4, "c": 2})
+	assert.Equal(t, VectorClock{"a": 3, "b": 4, "c": 2}, clock)
+
+	copied := clock.Copy()
+	copied.Increment("a")
+	assert.Equal(t, uint64(3), clock["a"])
+	assert.Equal(t, uint64(4), copied["a"])
+}
+
+func TestVectorClockMarshal(t *testing.T) {
+	clock := VectorClock{"a": 3, "b": 1}
+	data, err := clock.marshal()
+	assert.Nil(t, err)
+
+	decoded, err := unmarshalVectorClock(data)
+	assert.Nil(t, err)
+	assert.Equal(t, clock, decoded)
+}
+
+type rejectingManager struct {
+	recordingManager
+}
+
+func (manager *rejectingManager) ShouldProcess(msg Message, history *goque.Stack) bool {
+	return false
+}
+
+func TestAccordClocks(t *testing.T) {
+	defer AccordCleanup()
+
+	accord := DummyAccord()
+	accord.NodeID = "local"
+	accord.Start()
+
+	local := &Message{ID: 1}
+	assert.Nil(t, accord.HandleNewMessage(local))
+	assert.Equal(t, VectorClock{"local": 1}, local.Clock)
+
+	remote := &Message{ID: 2, Origin: "remote", Clock: VectorClock{"remote": 5}}
+	assert.Nil(t, accord.HandleRemoteMessage(remote))
+	assert.Equal(t, VectorClock{"local": 1, "remote": 5}, accord.Clock())
+
+	// Remote messages are recorded in our history but aren't sent back out
+	assert.Equal(t, uint64(2), accord.History().Len())
+	assert.Equal(t, uint64(1), accord.Queue().Len())
+
+	next := &Message{ID: 3}
+	assert.Nil(t, accord.HandleNewMessage(next))
+	assert.Equal(t, VectorClock{"local": 2, "remote": 5}, next.Clock)
+	assert.True(t, remote.Clock.HappenedBefore(next.Clock))
+	assert.True(t, local.Clock.ConcurrentWith(remote.Clock))
+	accord.Stop()
+
+	// Our clock should survive a restart
+	accord = DummyAccord()
+	accord.Start()
+	defer accord.Stop()
+	assert.Equal(t, VectorClock{"local": 2, "remote": 5}, accord.Clock())
+}
+
+func TestAccordRemoteShouldProcess(t *testing.T) {
+	defer AccordCleanup()
+
+	manager := &rejectingManager{}
+	accord := DummyAccord()
+	accord.manager = manager
+	accord.Start()
+	defer accord.Stop()
+
+	assert.Nil(t, accord.HandleRemoteMessage(&Message{ID: 1, Origin: "remote"}))
+	assert.Empty(t, manager.processed)
+	assert.Equal(t, uint64(0), accord.state.GetCurrent())
+	assert.Equal(t, uint64(0), accord.History().Len())
+}
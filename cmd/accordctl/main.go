@@ -0,0 +1,460 @@
+// Command accordctl inspects and repairs an Accord node's data directory while the node is stopped, taking
+// the place of the ad-hoc LevelDB scripts we used to pass around:
+//
+//	accordctl -data ./data queue                list the Messages waiting to be synchronized
+//	accordctl -data ./data history -limit 20    dump the most recently performed Messages
+//	accordctl -data ./data export -format csv   export every performed Message, to replay or analyze
+//	accordctl -data ./data state                show our counters, clocks and digest
+//	accordctl -data ./data state -message 42    show what they were right after Message 42 was performed
+//	accordctl -data ./data deadletters          list the Messages transports gave up on
+//	accordctl -data ./data requeue -peer edge   put dead letters back on the synchronization queue
+//	accordctl -data ./data repair               repair stores that were damaged by an unclean shutdown
+//	accordctl -data ./data backup -dir ./bak    copy the data directory's stores into a backup
+//	accordctl -data ./data restore -dir ./bak   replace the data directory's stores with a backup
+//	accordctl audit -file ./audit.log           check an audit log for tampering
+//	accordctl diff a.jsonl b.jsonl              show how two nodes' exported histories have diverged
+//
+// Listings are printed as a table, or as one JSON object per line with -json. The node must be stopped
+// first: its stores can only be opened by one process at a time. audit and diff only need the files
+// they're given, so they don't touch the data directory at all
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Ssawa/accord/accord"
+	"github.com/Ssawa/accord/accord/logging"
+	"github.com/sirupsen/logrus"
+)
+
+const usage = `usage: accordctl [-data dir] [-v] <command> [options]
+
+commands:
+  queue        list the Messages waiting to be synchronized, oldest first
+  history      dump performed Messages, newest first
+  export       export every performed Message, oldest first, as JSON lines or CSV (see -format)
+  state        show our counters, clocks and digest, now or as they were at -at or -message
+  deadletters  list the Messages transports gave up on delivering
+  requeue      put dead letters back on the synchronization queue
+  repair       repair stores that were damaged by an unclean shutdown
+  backup       copy the data directory's stores into a backup (running nodes can use Accord.Backup)
+  restore      replace the data directory's stores with a backup
+  audit        check an audit log (see Accord.AuditFile) for tampering
+  diff         compare two exports (see export, and -format) and report how they've diverged
+`
+
+func main() {
+	err := run(os.Args[1:], os.Stdout, os.Stderr)
+	if err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintln(os.Stderr, "accordctl:", err)
+		}
+		os.Exit(2)
+	}
+}
+
+// run carries out a whole invocation of accordctl
+func run(args []string, stdout io.Writer, stderr io.Writer) error {
+	global := flag.NewFlagSet("accordctl", flag.ContinueOnError)
+	global.SetOutput(stderr)
+	global.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		global.PrintDefaults()
+	}
+	dataDir := global.String("data", "data", "the node's data directory")
+	verbose := global.Bool("v", false, "log what Accord is doing while opening the data directory")
+	keyFile := global.String("key", "", "the file holding the data directory's encryption key, if it's encrypted")
+
+	err := global.Parse(args)
+	if err != nil {
+		return err
+	}
+	if global.NArg() == 0 {
+		global.Usage()
+		return flag.ErrHelp
+	}
+
+	command, commandArgs := global.Arg(0), global.Args()[1:]
+	flags := flag.NewFlagSet("accordctl "+command, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	asJSON := flags.Bool("json", false, "print one JSON object per line")
+	limit := flags.Int("limit", 0, "the most entries to print (0 for all of them)")
+	peer := flags.String("peer", "", "only requeue dead letters for this peer")
+	backupDir := flags.String("dir", "", "the backup directory to write or restore")
+	auditFile := flags.String("file", "", "the audit log to check")
+	format := flags.String("format", "json", "the format to export in, json or csv")
+	at := flags.String("at", "", "show the state as it was at this time (RFC 3339)")
+	messageID := flags.Uint64("message", 0, "show the state as it was right after this Message was performed")
+
+	err = flags.Parse(commandArgs)
+	if err != nil {
+		return err
+	}
+
+	// Audit logs can be kept anywhere, and checked without the data directory they came from
+	if command == "audit" {
+		return verifyAudit(*auditFile, stdout)
+	}
+	// As can exports, which are compared on their own
+	if command == "diff" {
+		return diffExports(flags.Args(), *format, stdout)
+	}
+
+	_, err = os.Stat(*dataDir)
+	if err != nil {
+		return err
+	}
+	if (command == "backup" || command == "restore") && *backupDir == "" {
+		return fmt.Errorf("%s needs a -dir", command)
+	}
+
+	logger := logrus.New()
+	if !*verbose {
+		logger.Out = ioutil.Discard
+	}
+	acc := accord.NewAccord(nil, nil, *dataDir, logging.NewLogrus(logrus.NewEntry(logger)))
+	if *keyFile != "" {
+		acc.EncryptionKey, err = accord.LoadKeyFile(*keyFile)
+		if err != nil {
+			return fmt.Errorf("unable to load encryption key: %v", err)
+		}
+	}
+	if command == "restore" {
+		err = acc.RestoreBackup(*backupDir)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "restored %s\n", *backupDir)
+		return nil
+	}
+	if command == "repair" {
+		repaired, err := acc.Repair()
+		if err != nil {
+			return fmt.Errorf("unable to repair %s (is the node still running?): %v", *dataDir, err)
+		}
+		for _, store := range repaired {
+			fmt.Fprintf(stdout, "repaired %s\n", store)
+		}
+		fmt.Fprintf(stdout, "repaired %d stores\n", len(repaired))
+		return nil
+	}
+
+	err = acc.Open()
+	if err != nil {
+		return fmt.Errorf("unable to open %s (is the node still running?): %v", *dataDir, err)
+	}
+	defer acc.Close()
+
+	out := &output{w: stdout, json: *asJSON}
+	switch command {
+	case "queue":
+		return listQueue(acc, out, *limit)
+	case "history":
+		return listHistory(acc, out, *limit)
+	case "export":
+		exportFormat, err := accord.ParseExportFormat(*format)
+		if err != nil {
+			return err
+		}
+		return acc.ExportHistory(stdout, exportFormat)
+	case "state":
+		if *at != "" || *messageID != 0 {
+			return showStateAt(acc, out, *at, *messageID)
+		}
+		return showState(acc, out)
+	case "deadletters":
+		return listDeadLetters(acc, out, *limit)
+	case "backup":
+		err = acc.Backup(*backupDir)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "backed up to %s\n", *backupDir)
+		return nil
+	case "requeue":
+		requeued, err := acc.RequeueDeadLetters(*peer)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "requeued %d dead letters\n", requeued)
+		return nil
+	default:
+		global.Usage()
+		return fmt.Errorf("unknown command %q", command)
+	}
+}
+
+// output prints either a table or JSON lines
+type output struct {
+	w     io.Writer
+	json  bool
+	table *tabwriter.Writer
+}
+
+// header starts a table. It's ignored when printing JSON
+func (out *output) header(columns ...string) {
+	if out.json {
+		return
+	}
+	out.table = tabwriter.NewWriter(out.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(out.table, strings.Join(columns, "\t"))
+}
+
+// row prints a table row, or value as JSON
+func (out *output) row(value interface{}, columns ...interface{}) error {
+	if out.json {
+		return json.NewEncoder(out.w).Encode(value)
+	}
+
+	cells := make([]string, len(columns))
+	for i, column := range columns {
+		cells[i] = fmt.Sprint(column)
+	}
+	_, err := fmt.Fprintln(out.table, strings.Join(cells, "\t"))
+	return err
+}
+
+func (out *output) flush() error {
+	if out.table != nil {
+		return out.table.Flush()
+	}
+	return nil
+}
+
+// messageColumns and messageCells describe Messages the same way in every listing
+var messageColumns = []string{"ID", "TIME", "ORIGIN", "TYPE", "KEY", "PAYLOAD"}
+
+func messageCells(msg *accord.Message) []interface{} {
+	payload := string(msg.Payload)
+	if len(payload) > 40 {
+		payload = payload[:37] + "..."
+	}
+	return []interface{}{msg.ID, msg.Timestamp.UTC().Format(time.RFC3339), msg.Origin, msg.Type, msg.Key, fmt.Sprintf("%q", payload)}
+}
+
+func listQueue(acc *accord.Accord, out *output, limit int) error {
+	out.header(messageColumns...)
+	printed := 0
+	for msg := range acc.Queue().All() {
+		if limit > 0 && printed == limit {
+			break
+		}
+		err := out.row(msg, messageCells(msg)...)
+		if err != nil {
+			return err
+		}
+		printed++
+	}
+	return out.flush()
+}
+
+func listHistory(acc *accord.Accord, out *output, limit int) error {
+	out.header(messageColumns...)
+	iter := acc.History().Query(accord.HistoryFilter{Limit: limit})
+	for iter.Next() {
+		msg := iter.Message()
+		err := out.row(msg, messageCells(msg)...)
+		if err != nil {
+			return err
+		}
+	}
+	if iter.Err() != nil {
+		return iter.Err()
+	}
+	return out.flush()
+}
+
+// stateReport is what the "state" command prints
+type stateReport struct {
+	State         uint64             `json:"state"`
+	QueueLength   uint64             `json:"queueLength"`
+	HistoryLength uint64             `json:"historyLength"`
+	DeadLetters   int                `json:"deadLetters"`
+	DigestRoot    string             `json:"digestRoot"`
+	Clock         accord.VectorClock `json:"clock"`
+	Bans          []accord.Ban       `json:"bans"`
+}
+
+func showState(acc *accord.Accord, out *output) error {
+	letters, err := acc.DeadLetters()
+	if err != nil {
+		return err
+	}
+
+	report := stateReport{
+		State:         acc.CurrentState(),
+		QueueLength:   acc.QueuedMessages(),
+		HistoryLength: acc.History().Len(),
+		DeadLetters:   len(letters),
+		DigestRoot:    acc.Digest().RootString(),
+		Clock:         acc.Clock(),
+		Bans:          acc.Bans(),
+	}
+	if out.json {
+		return out.row(report)
+	}
+
+	out.header("FIELD", "VALUE")
+	out.row(nil, "state", report.State)
+	out.row(nil, "queue length", report.QueueLength)
+	out.row(nil, "history length", report.HistoryLength)
+	out.row(nil, "dead letters", report.DeadLetters)
+	out.row(nil, "digest root", report.DigestRoot)
+	for _, node := range sortedNodes(report.Clock) {
+		out.row(nil, "clock["+node+"]", report.Clock[node])
+	}
+	for _, ban := range report.Bans {
+		out.row(nil, "banned "+ban.Peer, "until "+ban.Until.UTC().Format(time.RFC3339))
+	}
+	return out.flush()
+}
+
+// pointReport is what the "state" command prints for a point in the past
+type pointReport struct {
+	MessageID  uint64             `json:"messageId"`
+	Latest     time.Time          `json:"latest"`
+	Applied    uint64             `json:"applied"`
+	State      uint64             `json:"state"`
+	DigestRoot string             `json:"digestRoot"`
+	Clock      accord.VectorClock `json:"clock"`
+}
+
+func showStateAt(acc *accord.Accord, out *output, at string, messageID uint64) error {
+	var point *accord.PointInTime
+	var err error
+	if messageID != 0 {
+		point, err = acc.StateAtMessage(messageID)
+	} else {
+		var when time.Time
+		when, err = time.Parse(time.RFC3339, at)
+		if err != nil {
+			return err
+		}
+		point, err = acc.StateAt(when)
+	}
+	if err != nil {
+		return err
+	}
+
+	report := pointReport{
+		MessageID:  point.MessageID,
+		Latest:     point.Latest,
+		Applied:    point.Applied,
+		State:      point.State,
+		DigestRoot: point.Digest.RootString(),
+		Clock:      point.Clock,
+	}
+	if out.json {
+		return out.row(report)
+	}
+
+	out.header("FIELD", "VALUE")
+	out.row(nil, "last message", report.MessageID)
+	out.row(nil, "latest", report.Latest.UTC().Format(time.RFC3339))
+	out.row(nil, "applied", report.Applied)
+	out.row(nil, "state", report.State)
+	out.row(nil, "digest root", report.DigestRoot)
+	for _, node := range sortedNodes(report.Clock) {
+		out.row(nil, "clock["+node+"]", report.Clock[node])
+	}
+	return out.flush()
+}
+
+func listDeadLetters(acc *accord.Accord, out *output, limit int) error {
+	letters, err := acc.DeadLetters()
+	if err != nil {
+		return err
+	}
+	if limit > 0 && len(letters) > limit {
+		letters = letters[:limit]
+	}
+
+	out.header(append([]string{"PEER", "REASON"}, messageColumns...)...)
+	for _, letter := range letters {
+		cells := append([]interface{}{letter.Peer, letter.Reason}, messageCells(&letter.Message)...)
+		err = out.row(letter, cells...)
+		if err != nil {
+			return err
+		}
+	}
+	return out.flush()
+}
+
+func sortedNodes(clock accord.VectorClock) []string {
+	nodes := []string{}
+	for node := range clock {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// verifyAudit checks an audit log for tampering
+func verifyAudit(path string, stdout io.Writer) error {
+	if path == "" {
+		return fmt.Errorf("audit needs a -file")
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	count, err := accord.VerifyAudit(file)
+	if err == accord.ErrAuditTampered {
+		return fmt.Errorf("%s: the record after the first %d has been tampered with", path, count)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "%d records intact\n", count)
+	return nil
+}
+
+// readExport reads every Message in an export written by ExportHistory
+func readExport(path string, format accord.ExportFormat) ([]accord.Message, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	msgs := []accord.Message{}
+	err = accord.ReadHistoryExport(file, format, func(msg *accord.Message) error {
+		msgs = append(msgs, *msg)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return msgs, nil
+}
+
+// diffExports compares the exported histories of two nodes, the first taken as the local one
+func diffExports(paths []string, format string, stdout io.Writer) error {
+	if len(paths) != 2 {
+		return fmt.Errorf("diff needs two exports to compare")
+	}
+	exportFormat, err := accord.ParseExportFormat(format)
+	if err != nil {
+		return err
+	}
+	local, err := readExport(paths[0], exportFormat)
+	if err != nil {
+		return err
+	}
+	remote, err := readExport(paths[1], exportFormat)
+	if err != nil {
+		return err
+	}
+	return accord.DiffHistories(local, remote).WriteReport(stdout)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ssawa/accord/accord"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccordctl(t *testing.T) {
+	dir, err := ioutil.TempDir("", "accordctl")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// Fill a data directory the way a node would have left it
+	acc := accord.NewAccord(accord.NewDummerManager(), nil, dir, accord.DummyAccord().Logger)
+	acc.AuditFile = filepath.Join(dir, "audit.log")
+	assert.Nil(t, acc.Open())
+	peer := acc.AddPeer("edge")
+	for _, payload := range []string{"one", "two", "three"} {
+		msg, _ := accord.NewMessage([]byte(payload))
+		assert.Nil(t, acc.HandleNewMessage(msg))
+	}
+	assert.Nil(t, peer.DeadLetter(1, "refused"))
+	acc.Close()
+
+	ctl := func(args ...string) (string, error) {
+		var stdout, stderr bytes.Buffer
+		err := run(append([]string{"-data", dir}, args...), &stdout, &stderr)
+		return stdout.String(), err
+	}
+
+	out, err := ctl("queue")
+	assert.Nil(t, err)
+	assert.Contains(t, out, "PAYLOAD")
+	assert.Contains(t, out, `"two"`)
+	assert.NotContains(t, out, `"one"`)
+
+	out, err = ctl("deadletters", "-json")
+	assert.Nil(t, err)
+	var letter accord.DeadLetter
+	assert.Nil(t, json.Unmarshal([]byte(out), &letter))
+	assert.Equal(t, "edge", letter.Peer)
+	assert.Equal(t, "one", string(letter.Message.Payload))
+
+	out, err = ctl("requeue", "-peer", "edge")
+	assert.Nil(t, err)
+	assert.Equal(t, "requeued 1 dead letters\n", out)
+
+	out, err = ctl("state", "-json")
+	assert.Nil(t, err)
+	var report stateReport
+	assert.Nil(t, json.Unmarshal([]byte(out), &report))
+	assert.Equal(t, uint64(3), report.QueueLength)
+	assert.Equal(t, 0, report.DeadLetters)
+
+	out, err = ctl("state", "-json", "-at", time.Now().Add(time.Hour).Format(time.RFC3339))
+	assert.Nil(t, err)
+	var point pointReport
+	assert.Nil(t, json.Unmarshal([]byte(out), &point))
+	assert.Equal(t, uint64(3), point.Applied)
+	assert.Equal(t, report.State, point.State)
+
+	out, err = ctl("history", "-limit", "1")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(strings.Split(strings.TrimSpace(out), "\n")))
+
+	out, err = ctl("export", "-format", "csv")
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(strings.Split(strings.TrimSpace(out), "\n")))
+	assert.True(t, strings.HasPrefix(out, "id,timestamp,"))
+
+	// Exports of two nodes can be compared
+	exported, err := ctl("export")
+	assert.Nil(t, err)
+	local := filepath.Join(dir, "local.jsonl")
+	assert.Nil(t, ioutil.WriteFile(local, []byte(exported), 0644))
+	lines := strings.SplitAfter(exported, "\n")
+	remote := filepath.Join(dir, "remote.jsonl")
+	assert.Nil(t, ioutil.WriteFile(remote, []byte(lines[0]+lines[1]), 0644))
+	out, err = ctl("diff", local, local)
+	assert.Nil(t, err)
+	assert.Equal(t, "Histories are identical\n", out)
+	out, err = ctl("diff", local, remote)
+	assert.Nil(t, err)
+	assert.Contains(t, out, "Messages only in local history: 1")
+	_, err = ctl("diff", local)
+	assert.NotNil(t, err)
+
+	out, err = ctl("repair")
+	assert.Nil(t, err)
+	assert.Equal(t, "repaired 0 stores\n", out)
+
+	backup := dir + "-backup"
+	defer os.RemoveAll(backup)
+	_, err = ctl("backup", "-dir", backup)
+	assert.Nil(t, err)
+	_, err = ctl("restore", "-dir", backup)
+	assert.Nil(t, err)
+	out, err = ctl("state", "-json")
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal([]byte(out), &report))
+	assert.Equal(t, uint64(3), report.QueueLength)
+
+	out, err = ctl("audit", "-file", acc.AuditFile)
+	assert.Nil(t, err)
+	assert.Equal(t, "3 records intact\n", out)
+
+	_, err = ctl("bogus")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,270 @@
+package components
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Ssawa/accord/accord"
+)
+
+// Admin is an optional Component serving HTTP endpoints for operators to see what a node is doing, and to
+// pause and resume its Components, without attaching a debugger:
+//
+//	GET  /admin/status             queue depth, history size, digest, clock and peers, along with how
+//	                               far along any peers catching up on a backlog are
+//	GET  /admin/components         the status of every Component
+//	POST /admin/components/pause   pauses the Component named by the "name" parameter
+//	POST /admin/components/resume  resumes it again
+//	POST /admin/pause              pauses the whole node (see accord.Pause)
+//	POST /admin/resume             resumes it again
+//	POST /admin/reload             reloads our configuration (see accord.Reload)
+//	GET  /admin/events             our recent events
+//	GET  /admin/bans               the peers that are currently banned
+//	POST /admin/bans               bans the "peer" parameter for the "duration" parameter (like "10m")
+//	DELETE /admin/bans             lifts the ban on the "peer" parameter
+//
+// Like WebReceiver we don't do any authentication whatsoever, so make sure BindAddress is only reachable by
+// the people who should be able to do these things (the default is localhost only)
+type Admin struct {
+	accord.ComponentRunner
+
+	// BindAddress is the address our endpoints should be served on, defaulting to "127.0.0.1:7070". Set
+	// it to "-" to not start a server, and mount Handler on an existing one instead
+	BindAddress string
+
+	accord  *accord.Accord
+	log     accord.Logger
+	handler http.Handler
+	server  *backgroundServer
+}
+
+// AdminStatus is what "/admin/status" responds with
+type AdminStatus struct {
+	Node          string             `json:"node"`
+	QueueLength   uint64             `json:"queueLength"`
+	HistoryLength uint64             `json:"historyLength"`
+	DigestRoot    string             `json:"digestRoot"`
+	Clock         accord.VectorClock `json:"clock"`
+	HeldBack      int                `json:"heldBack"`
+	ReadOnly      bool               `json:"readOnly"`
+	Paused        bool               `json:"paused"`
+	Draining      bool               `json:"draining"`
+	Leader        string             `json:"leader"`
+	Peers         []AdminPeer        `json:"peers"`
+
+	// Backlog is whether we're lagged or offline, as of the last time it was checked (see
+	// accord.BacklogAlerts)
+	Backlog accord.BacklogStatus `json:"backlog"`
+}
+
+// AdminPeer is a peer as it's listed in AdminStatus. Progress is only there while the peer is catching up
+// on a backlog (see accord.ProgressReporting)
+type AdminPeer struct {
+	Name     string               `json:"name"`
+	Pending  uint64               `json:"pending"`
+	Progress *accord.SyncProgress `json:"progress,omitempty"`
+}
+
+// Start sets up our endpoints and starts serving them
+func (comp *Admin) Start(acc *accord.Accord) error {
+	comp.accord = acc
+	comp.log = acc.Logger.WithField("component", "Admin")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/status", comp.status)
+	mux.HandleFunc("/admin/components", comp.components)
+	mux.HandleFunc("/admin/components/pause", comp.pause)
+	mux.HandleFunc("/admin/components/resume", comp.resume)
+	mux.HandleFunc("/admin/pause", comp.pauseNode)
+	mux.HandleFunc("/admin/resume", comp.resumeNode)
+	mux.HandleFunc("/admin/reload", comp.reload)
+	mux.HandleFunc("/admin/events", comp.events)
+	mux.HandleFunc("/admin/bans", comp.bans)
+	comp.handler = mux
+
+	address := comp.BindAddress
+	if address == "" {
+		address = "127.0.0.1:7070"
+	}
+	if address != "-" {
+		comp.server = startServer(address, comp.handler, comp.log)
+	}
+
+	comp.ComponentRunner.Init(acc, comp.tick, comp.cleanup, comp.log)
+	return nil
+}
+
+// Handler returns our endpoints, for mounting on an existing HTTP server. It's only available after Start
+func (comp *Admin) Handler() http.Handler {
+	return comp.handler
+}
+
+// Our endpoints do all of our work, so our loop has nothing to do but wait to be stopped
+func (comp *Admin) tick(*accord.Accord) {
+	time.Sleep(tickResolution)
+}
+
+func (comp *Admin) cleanup(*accord.Accord) {
+	if comp.server != nil {
+		comp.server.stop()
+	}
+}
+
+func (comp *Admin) status(w http.ResponseWriter, r *http.Request) {
+	acc := comp.accord
+	status := AdminStatus{
+		Node:          acc.NodeID,
+		QueueLength:   acc.QueuedMessages(),
+		HistoryLength: acc.History().Len(),
+		DigestRoot:    acc.Digest().RootString(),
+		Clock:         acc.Clock(),
+		HeldBack:      acc.HeldBack(),
+		ReadOnly:      acc.ReadOnly(),
+		Paused:        acc.Paused(),
+		Draining:      acc.Draining(),
+		Leader:        acc.Leader(),
+		Peers:         []AdminPeer{},
+		Backlog:       acc.BacklogStatus(),
+	}
+	progress := map[string]accord.SyncProgress{}
+	for _, peer := range acc.SyncProgress() {
+		progress[peer.Peer] = peer
+	}
+	for _, name := range acc.Peers() {
+		lag, err := acc.PeerLag(name)
+		if err != nil {
+			continue
+		}
+		peer := AdminPeer{Name: name, Pending: lag}
+		if current, ok := progress[name]; ok {
+			peer.Progress = &current
+		}
+		status.Peers = append(status.Peers, peer)
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (comp *Admin) components(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, comp.accord.Components())
+}
+
+func (comp *Admin) pause(w http.ResponseWriter, r *http.Request) {
+	comp.control(w, r, comp.accord.PauseComponent)
+}
+
+func (comp *Admin) resume(w http.ResponseWriter, r *http.Request) {
+	comp.control(w, r, comp.accord.ResumeComponent)
+}
+
+// control pauses or resumes the Component named in the request
+func (comp *Admin) control(w http.ResponseWriter, r *http.Request, fn func(string) error) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := r.FormValue("name")
+	err := fn(name)
+	switch err {
+	case nil:
+	case accord.ErrUnknownComponent:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case accord.ErrNotPausable:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	comp.log.WithField("name", name).WithField("path", r.URL.Path).Info("Component controlled by an operator")
+	writeJSON(w, http.StatusOK, comp.accord.Components())
+}
+
+func (comp *Admin) pauseNode(w http.ResponseWriter, r *http.Request) {
+	comp.controlNode(w, r, comp.accord.Pause)
+}
+
+func (comp *Admin) resumeNode(w http.ResponseWriter, r *http.Request) {
+	comp.controlNode(w, r, comp.accord.Resume)
+}
+
+// controlNode pauses or resumes the whole node
+func (comp *Admin) controlNode(w http.ResponseWriter, r *http.Request, fn func()) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	fn()
+	comp.log.WithField("path", r.URL.Path).Info("Node controlled by an operator")
+	comp.status(w, r)
+}
+
+func (comp *Admin) reload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := comp.accord.Reload()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	comp.log.Info("Configuration reloaded by an operator")
+	comp.status(w, r)
+}
+
+func (comp *Admin) events(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, comp.accord.RecentEvents())
+}
+
+func (comp *Admin) bans(w http.ResponseWriter, r *http.Request) {
+	peer := r.FormValue("peer")
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, comp.accord.Bans())
+		return
+
+	case http.MethodPost:
+		duration, err := time.ParseDuration(r.FormValue("duration"))
+		if peer == "" || err != nil || duration <= 0 {
+			http.Error(w, "a peer and a positive duration are required", http.StatusBadRequest)
+			return
+		}
+		err = comp.accord.Ban(peer, duration)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+	case http.MethodDelete:
+		if peer == "" {
+			http.Error(w, "a peer is required", http.StatusBadRequest)
+			return
+		}
+		err := comp.accord.Unban(peer)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	comp.log.WithField("peer", peer).WithField("method", r.Method).Info("Peer ban changed by an operator")
+	writeJSON(w, http.StatusOK, comp.accord.Bans())
+}
+
+// writeJSON responds with a value encoded as JSON
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
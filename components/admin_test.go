@@ -0,0 +1,148 @@
+package components
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Ssawa/accord/accord"
+	"github.com/Ssawa/accord/accord/accordtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdmin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "accord-admin")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	antiEntropy := &AntiEntropy{}
+	admin := &Admin{BindAddress: "-"}
+	acc := accord.NewAccord(accord.NewDummerManager(), []accord.Component{antiEntropy, admin}, dir, accord.DummyAccord().Logger)
+	assert.Nil(t, acc.Start())
+	defer acc.Stop()
+	acc.AddPeer("edge")
+
+	server := httptest.NewServer(admin.Handler())
+	defer server.Close()
+
+	msg, err := accord.NewMessage([]byte("hello"))
+	assert.Nil(t, err)
+	assert.Nil(t, acc.HandleNewMessage(msg))
+
+	status := AdminStatus{}
+	resp, err := http.Get(server.URL + "/admin/status")
+	assert.Nil(t, err)
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&status))
+	resp.Body.Close()
+	assert.Equal(t, uint64(1), status.QueueLength)
+	assert.Equal(t, uint64(1), status.HistoryLength)
+	assert.Equal(t, []AdminPeer{{Name: "edge", Pending: 1}}, status.Peers)
+
+	resp, err = http.PostForm(server.URL+"/admin/components/pause", url.Values{"name": {"AntiEntropy"}})
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.True(t, antiEntropy.Paused())
+
+	statuses := []accord.ComponentStatus{}
+	resp, err = http.Get(server.URL + "/admin/components")
+	assert.Nil(t, err)
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&statuses))
+	resp.Body.Close()
+	assert.Equal(t, "AntiEntropy", statuses[0].Name)
+	assert.True(t, statuses[0].Paused)
+	assert.True(t, statuses[1].Running)
+	assert.False(t, statuses[1].Paused)
+
+	resp, err = http.PostForm(server.URL+"/admin/components/resume", url.Values{"name": {"AntiEntropy"}})
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.False(t, antiEntropy.Paused())
+
+	resp, err = http.PostForm(server.URL+"/admin/components/pause", url.Values{"name": {"Nope"}})
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 404, resp.StatusCode)
+
+	resp, err = http.PostForm(server.URL+"/admin/pause", nil)
+	assert.Nil(t, err)
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&status))
+	resp.Body.Close()
+	assert.True(t, status.Paused)
+	assert.True(t, acc.Paused())
+
+	resp, err = http.PostForm(server.URL+"/admin/resume", nil)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.False(t, acc.Paused())
+
+	reloads := 0
+	acc.OnReload = func(*accord.Accord) error {
+		reloads++
+		return nil
+	}
+	resp, err = http.PostForm(server.URL+"/admin/reload", nil)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, 1, reloads)
+
+	resp, err = http.PostForm(server.URL+"/admin/bans", url.Values{"peer": {"10.0.0.9"}, "duration": {"1m"}})
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.False(t, acc.AllowPeer("10.0.0.9:1234"))
+}
+
+func TestAdminProgress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "accord-admin")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	admin := &Admin{BindAddress: "-"}
+	acc := accord.NewAccord(accord.NewDummerManager(), []accord.Component{admin}, dir, accord.DummyAccord().Logger)
+	acc.ProgressReporting = accord.ProgressReporting{Threshold: 2}
+	acc.BacklogAlerts = accord.BacklogAlerts{MaxQueueDepth: 1, CheckInterval: 10 * time.Millisecond}
+	assert.Nil(t, acc.Start())
+	defer acc.Stop()
+	peer := acc.AddPeer("edge")
+
+	server := httptest.NewServer(admin.Handler())
+	defer server.Close()
+
+	for _, payload := range []string{"one", "two", "three"} {
+		msg, err := accord.NewMessage([]byte(payload))
+		assert.Nil(t, err)
+		assert.Nil(t, acc.HandleNewMessage(msg))
+	}
+	msgs, err := peer.Ship(1)
+	assert.Nil(t, err)
+	assert.Nil(t, peer.Ack(msgs[0].ID))
+
+	status := AdminStatus{}
+	resp, err := http.Get(server.URL + "/admin/status")
+	assert.Nil(t, err)
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&status))
+	resp.Body.Close()
+	assert.Equal(t, uint64(2), status.Peers[0].Pending)
+	progress := status.Peers[0].Progress
+	assert.NotNil(t, progress)
+	assert.Equal(t, uint64(1), progress.Sent)
+	assert.Equal(t, uint64(3), progress.Total)
+	assert.Equal(t, uint64(len("one")), progress.Bytes)
+	assert.False(t, progress.Stalled)
+
+	// The backlog is over our threshold as well
+	accordtest.Eventually(t, 500*time.Millisecond, func() bool { return acc.BacklogStatus().Lagged })
+	resp, err = http.Get(server.URL + "/admin/status")
+	assert.Nil(t, err)
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&status))
+	resp.Body.Close()
+	assert.True(t, status.Backlog.Lagged)
+	assert.Equal(t, uint64(2), status.Backlog.QueueDepth)
+}
@@ -0,0 +1,306 @@
+package components
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Ssawa/accord/accord"
+	"github.com/Ssawa/accord/accord/discovery"
+)
+
+// tickResolution is how long looping Components sleep between checking whether they have work to do. It
+// keeps them from flooding the CPU while still letting ComponentRunner handle Stop promptly. Our tests
+// shorten it (see TestMain) so that they aren't left waiting on it
+var tickResolution = 50 * time.Millisecond
+
+// AntiEntropy is a Component that periodically compares our state digest with each of our peers and, when
+// they don't match, pulls over whatever Messages we're missing. Normal synchronization only ever sends
+// Messages once, so if one is ever lost (a crashed transport, a restored backup, a bug) this is what
+// eventually heals the divergence.
+//
+// Every node should run one: each side only ever *pulls* what it's missing, so it's running it on both
+// sides that brings them fully back in line. The same HTTP endpoints that answer our peers' requests can
+// either be served on BindAddress or mounted elsewhere using Handler
+type AntiEntropy struct {
+	accord.ComponentRunner
+
+	// BindAddress is the address our endpoints should be served on. If it's left empty no server is started
+	// and Handler should be mounted on an existing one instead
+	BindAddress string
+
+	// Peers are the base URLs of our peers' AntiEntropy endpoints (for instance "http://10.0.0.2:7000").
+	// Once we've started, the peers Discovery finds are added to it from our loop, so it should only be read
+	// through KnownPeers
+	Peers []string
+
+	// Discovery finds more peers as they come along, on top of the ones listed in Peers. Peers are only
+	// ever added; one that disappears is simply retried until it comes back
+	Discovery discovery.Discoverer
+
+	// DiscoveryInterval is how often Discovery is asked for peers, defaulting to DefaultDiscoveryInterval
+	DiscoveryInterval time.Duration
+
+	// Interval is how long to wait between rounds, defaulting to 30 seconds
+	Interval time.Duration
+
+	// Client is used to talk to our peers, defaulting to a client with a 10 second timeout
+	Client *http.Client
+
+	accord    *accord.Accord
+	log       accord.Logger
+	handler   http.Handler
+	server    *backgroundServer
+	lastRound time.Time
+	discovery *peerDiscovery
+
+	// roundMutex keeps a manually triggered round from running at the same time as a scheduled one
+	roundMutex sync.Mutex
+
+	// peersMutex protects Peers while discovered peers are added to it. It's replaced rather than appended
+	// to, so a copy taken under the lock stays as it was
+	peersMutex sync.Mutex
+}
+
+// Start sets up our endpoints and begins our background loop
+func (comp *AntiEntropy) Start(acc *accord.Accord) error {
+	comp.accord = acc
+	comp.log = acc.Logger.WithField("component", "AntiEntropy")
+	comp.lastRound = time.Time{}
+	comp.discovery = newPeerDiscovery(comp.Discovery, comp.DiscoveryInterval, acc.NodeID, comp.log)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/antientropy/digest", comp.serveDigest)
+	mux.HandleFunc("/antientropy/messages", comp.serveMessages)
+	comp.handler = guardPeers(acc, mux)
+
+	if comp.BindAddress != "" {
+		comp.server = startServer(comp.BindAddress, comp.handler, comp.log)
+	}
+
+	comp.ComponentRunner.Init(acc, comp.tick, comp.cleanup, comp.log)
+	return nil
+}
+
+// Handler returns the endpoints our peers talk to, for mounting on an existing HTTP server. It's only
+// available after Start. Requests from peers our PeerPolicy doesn't allow are refused
+func (comp *AntiEntropy) Handler() http.Handler {
+	return comp.handler
+}
+
+// KnownPeers returns the peers we run rounds against: those we were given in Peers along with any our
+// Discovery has found since
+func (comp *AntiEntropy) KnownPeers() []string {
+	comp.peersMutex.Lock()
+	defer comp.peersMutex.Unlock()
+	return append([]string{}, comp.Peers...)
+}
+
+func (comp *AntiEntropy) tick(*accord.Accord) {
+	interval := comp.Interval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+
+	if time.Since(comp.lastRound) < interval {
+		time.Sleep(tickResolution)
+		return
+	}
+	comp.lastRound = time.Now()
+
+	comp.peersMutex.Lock()
+	for _, found := range comp.discovery.poll() {
+		known := false
+		for _, peer := range comp.Peers {
+			known = known || peer == found.URL
+		}
+		if !known {
+			comp.log.WithField("peer", found.Name).WithField("url", found.URL).Info("Discovered peer")
+			comp.Peers = append(append([]string{}, comp.Peers...), found.URL)
+		}
+	}
+	peers := comp.Peers
+	comp.peersMutex.Unlock()
+
+	for _, peer := range peers {
+		_, err := comp.SyncWith(peer)
+		if err != nil {
+			comp.log.WithError(err).WithField("peer", peer).Warn("Anti-entropy round failed")
+		}
+	}
+}
+
+func (comp *AntiEntropy) cleanup(*accord.Accord) {
+	if comp.server != nil {
+		comp.server.stop()
+	}
+}
+
+// SyncWith runs a single anti-entropy round against one peer, returning how many missing Messages were
+// applied
+func (comp *AntiEntropy) SyncWith(peer string) (int, error) {
+	comp.roundMutex.Lock()
+	defer comp.roundMutex.Unlock()
+
+	if !comp.accord.AllowPeer(peerAddress(peer)) {
+		return 0, fmt.Errorf("peer %s is not allowed", peer)
+	}
+
+	remote, err := comp.fetchDigest(peer)
+	if err != nil {
+		return 0, err
+	}
+
+	comparison := comp.accord.CompareDigest(remote)
+	if comparison.Equal {
+		return 0, nil
+	}
+
+	log := comp.log.WithField("peer", peer).WithField("buckets", comparison.Buckets)
+	log.Info("Divergence detected, fetching messages")
+
+	// Figure out what we already have in the buckets that differ, so that we only apply what's missing
+	have := map[uint64]bool{}
+	iter := comp.accord.History().Query(accord.HistoryFilter{Buckets: comparison.Buckets})
+	for iter.Next() {
+		have[iter.Message().ID] = true
+	}
+	if iter.Err() != nil {
+		return 0, iter.Err()
+	}
+
+	msgs, err := comp.fetchMessages(peer, comparison.Buckets)
+	if err != nil {
+		return 0, err
+	}
+
+	// Our peer sends their history newest first, so walk it backwards to apply the missing messages in
+	// the same order they did
+	applied := 0
+	for i := len(msgs) - 1; i >= 0; i-- {
+		msg := msgs[i]
+		if have[msg.ID] {
+			continue
+		}
+
+		err = comp.accord.HandleRemoteMessage(&msg)
+		if err != nil {
+			return applied, err
+		}
+		have[msg.ID] = true
+		applied++
+	}
+
+	log.WithField("applied", applied).Info("Anti-entropy round finished")
+	return applied, nil
+}
+
+func (comp *AntiEntropy) client() *http.Client {
+	if comp.Client != nil {
+		return comp.Client
+	}
+	return &http.Client{Timeout: 10 * time.Second}
+}
+
+func (comp *AntiEntropy) fetchDigest(peer string) (accord.Digest, error) {
+	digest := accord.Digest{}
+
+	resp, err := peerClient(comp.accord, comp.client(), peer).Get(strings.TrimRight(peer, "/") + "/antientropy/digest")
+	if err != nil {
+		return digest, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return digest, fmt.Errorf("peer responded with %s", resp.Status)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&digest)
+	if err != nil {
+		comp.accord.ReportViolation(peerAddress(peer), accord.ViolationMalformed, "unreadable digest")
+	}
+	return digest, err
+}
+
+func (comp *AntiEntropy) fetchMessages(peer string, buckets []int) ([]accord.Message, error) {
+	list := make([]string, len(buckets))
+	for i, bucket := range buckets {
+		list[i] = strconv.Itoa(bucket)
+	}
+
+	resp, err := peerClient(comp.accord, comp.client(), peer).Get(strings.TrimRight(peer, "/") + "/antientropy/messages?buckets=" + strings.Join(list, ","))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("peer responded with %s", resp.Status)
+	}
+
+	msgs := []accord.Message{}
+	err = gob.NewDecoder(resp.Body).Decode(&msgs)
+	if err != nil {
+		comp.accord.ReportViolation(peerAddress(peer), accord.ViolationMalformed, "unreadable messages")
+	}
+	return msgs, err
+}
+
+// serveDigest responds with our current digest
+func (comp *AntiEntropy) serveDigest(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(comp.accord.Digest())
+}
+
+// serveMessages responds with every Message in our history that falls into the requested buckets
+func (comp *AntiEntropy) serveMessages(w http.ResponseWriter, r *http.Request) {
+	buckets := []int{}
+	for _, value := range strings.Split(r.URL.Query().Get("buckets"), ",") {
+		bucket, err := strconv.Atoi(value)
+		if err != nil || bucket < 0 || bucket >= accord.DigestBuckets {
+			comp.accord.ReportViolation(r.RemoteAddr, accord.ViolationMalformed, "invalid bucket list")
+			http.Error(w, "invalid bucket list", http.StatusBadRequest)
+			return
+		}
+		buckets = append(buckets, bucket)
+	}
+	sort.Ints(buckets)
+
+	msgs := []accord.Message{}
+	iter := comp.accord.History().Query(accord.HistoryFilter{Buckets: buckets})
+	for iter.Next() {
+		msgs = append(msgs, *iter.Message())
+	}
+	if iter.Err() != nil {
+		comp.log.WithError(iter.Err()).Warn("Error reading history")
+		http.Error(w, iter.Err().Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(msgs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(buf.Bytes())
+}
+
+// peerAddress pulls the host out of a peer's base URL so that it can be checked against our PeerPolicy
+func peerAddress(peer string) string {
+	parsed, err := url.Parse(peer)
+	if err != nil || parsed.Host == "" {
+		return peer
+	}
+	return parsed.Host
+}
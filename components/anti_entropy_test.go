@@ -0,0 +1,115 @@
+package components
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Ssawa/accord/accord"
+	"github.com/Ssawa/accord/accord/accordtest"
+	"github.com/Ssawa/accord/accord/discovery"
+	"github.com/stretchr/testify/assert"
+)
+
+func antiEntropyNode(t *testing.T) (*accord.Accord, *AntiEntropy, *httptest.Server, func()) {
+	dir, err := ioutil.TempDir("", "accord-antientropy")
+	assert.Nil(t, err)
+
+	node := accord.NewAccord(accord.NewDummerManager(), nil, dir, accord.DummyAccord().Logger)
+	assert.Nil(t, node.Start())
+
+	comp := &AntiEntropy{Interval: time.Hour}
+	assert.Nil(t, comp.Start(node))
+	server := httptest.NewServer(comp.Handler())
+
+	return node, comp, server, func() {
+		server.Close()
+		comp.Stop(0)
+		comp.WaitForStop()
+		node.Stop()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestAntiEntropyHeals(t *testing.T) {
+	first, firstComp, firstServer, firstCleanup := antiEntropyNode(t)
+	defer firstCleanup()
+	second, secondComp, secondServer, secondCleanup := antiEntropyNode(t)
+	defer secondCleanup()
+
+	send := func(node *accord.Accord, payload string) {
+		msg, err := accord.NewMessage([]byte(payload))
+		assert.Nil(t, err)
+		assert.Nil(t, node.HandleNewMessage(msg))
+	}
+	send(first, "one")
+	send(first, "two")
+	send(first, "three")
+	send(second, "four")
+	assert.False(t, first.CompareDigest(second.Digest()).Equal)
+
+	applied, err := secondComp.SyncWith(firstServer.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, applied)
+
+	applied, err = firstComp.SyncWith(secondServer.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, applied)
+
+	assert.True(t, first.CompareDigest(second.Digest()).Equal)
+	assert.Equal(t, uint64(4), first.History().Len())
+
+	// Once we've converged there should be nothing left to do
+	applied, err = secondComp.SyncWith(firstServer.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, applied)
+}
+
+func TestAntiEntropyBadBuckets(t *testing.T) {
+	_, _, server, cleanup := antiEntropyNode(t)
+	defer cleanup()
+
+	resp, err := server.Client().Get(server.URL + "/antientropy/messages?buckets=1,nope")
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 400, resp.StatusCode)
+}
+
+func TestAntiEntropyBannedPeer(t *testing.T) {
+	node, _, server, cleanup := antiEntropyNode(t)
+	defer cleanup()
+
+	assert.Nil(t, node.Ban("127.0.0.1", time.Hour))
+
+	resp, err := server.Client().Get(server.URL + "/antientropy/digest")
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 403, resp.StatusCode)
+}
+
+func TestAntiEntropyDiscovery(t *testing.T) {
+	first, _, firstServer, firstCleanup := antiEntropyNode(t)
+	defer firstCleanup()
+	msg, err := accord.NewMessage([]byte("one"))
+	assert.Nil(t, err)
+	assert.Nil(t, first.HandleNewMessage(msg))
+
+	dir, err := ioutil.TempDir("", "accord-antientropy")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	second := accord.NewAccord(accord.NewDummerManager(), nil, dir, accord.DummyAccord().Logger)
+	assert.Nil(t, second.Start())
+	defer second.Stop()
+
+	// Peers our Discovery finds are added from our loop, and can be read while it's running
+	comp := &AntiEntropy{Interval: time.Hour, Discovery: discovery.Static{{Name: "first", URL: firstServer.URL}}}
+	assert.Nil(t, comp.Start(second))
+	defer func() {
+		comp.Stop(0)
+		comp.WaitForStop()
+	}()
+	accordtest.Eventually(t, 500*time.Millisecond, func() bool { return second.History().Len() == 1 })
+	assert.Equal(t, []string{firstServer.URL}, comp.KnownPeers())
+}
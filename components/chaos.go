@@ -0,0 +1,133 @@
+package components
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/Ssawa/accord/accord"
+)
+
+// ErrChaos is the transient failure Chaos makes remote Messages fail with
+var ErrChaos = errors.New("chaos: simulated transport failure")
+
+// EventChaos is emitted whenever Chaos interferes with a remote Message, with what it did as the "action"
+// field: "delay", "duplicate" or "fail"
+const EventChaos = "chaos"
+
+// Chaos is a Component for resilience drills in staging environments. Once it's started, every remote
+// Message we receive, whichever transport it comes in on, has a chance of being held up for a while, of
+// being handed to us twice, or of failing as if the transport had broken, in which case it's sent again
+// later like any other failed delivery. This shows whether the rest of the system copes with the network
+// misbehaving before production's network does it for real.
+//
+// Pausing it (see accord.PauseComponent) or stopping it lets Messages through untouched again. It should
+// never be run in production
+type Chaos struct {
+	accord.ComponentRunner
+
+	// DelayProbability is the chance, from 0 to 1, of a Message being held up, for a random time up to
+	// MaxDelay (which defaults to a second)
+	DelayProbability float64
+	MaxDelay         time.Duration
+
+	// DuplicateProbability is the chance of a Message being handed to us twice
+	DuplicateProbability float64
+
+	// FailureProbability is the chance of a Message failing with ErrChaos
+	FailureProbability float64
+
+	// Seed seeds our random decisions, so a drill can be repeated. Zero picks a seed from the time
+	Seed int64
+
+	accord *accord.Accord
+	log    accord.Logger
+	mutex  sync.Mutex
+	rng    *rand.Rand
+}
+
+// Start begins interfering with the remote Messages we receive
+func (comp *Chaos) Start(acc *accord.Accord) error {
+	comp.accord = acc
+	comp.log = acc.Logger.WithField("component", "Chaos")
+
+	seed := comp.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	comp.mutex.Lock()
+	if comp.rng == nil {
+		comp.rng = rand.New(rand.NewSource(seed))
+		// Interceptors can't be taken away again, so if we're restarted we keep the one we already have
+		acc.InterceptRemote(comp.intercept)
+	}
+	comp.mutex.Unlock()
+
+	comp.log.WithField("delay", comp.DelayProbability).WithField("duplicate", comp.DuplicateProbability).WithField("failure", comp.FailureProbability).Warn("Chaos is starting to interfere with remote messages")
+	comp.ComponentRunner.Init(acc, comp.tick, nil, comp.log)
+	return nil
+}
+
+// Our interceptor does all of our work, so our loop has nothing to do but wait to be stopped
+func (comp *Chaos) tick(*accord.Accord) {
+	time.Sleep(tickResolution)
+}
+
+// chance makes a random decision that comes out true with the given probability
+func (comp *Chaos) chance(probability float64) bool {
+	comp.mutex.Lock()
+	defer comp.mutex.Unlock()
+	return comp.rng.Float64() < probability
+}
+
+// delay picks how long to hold a Message up
+func (comp *Chaos) delay() time.Duration {
+	max := comp.MaxDelay
+	if max <= 0 {
+		max = time.Second
+	}
+	comp.mutex.Lock()
+	defer comp.mutex.Unlock()
+	return time.Duration(comp.rng.Int63n(int64(max)))
+}
+
+// intercept is our RemoteInterceptor
+func (comp *Chaos) intercept(msg *accord.Message, next func(*accord.Message) error) error {
+	if !comp.Running() || comp.Paused() {
+		return next(msg)
+	}
+
+	if comp.chance(comp.FailureProbability) {
+		comp.report(msg, "fail", "Chaos is failing a remote message", nil)
+		return ErrChaos
+	}
+	if comp.chance(comp.DelayProbability) {
+		delay := comp.delay()
+		comp.report(msg, "delay", "Chaos is holding up a remote message", map[string]interface{}{"delay": delay.String()})
+		time.Sleep(delay)
+	}
+	if comp.chance(comp.DuplicateProbability) {
+		comp.report(msg, "duplicate", "Chaos is duplicating a remote message", nil)
+		duplicate := *msg
+		err := next(&duplicate)
+		if err != nil {
+			return err
+		}
+	}
+	return next(msg)
+}
+
+// report logs and emits what we've done to a Message
+func (comp *Chaos) report(msg *accord.Message, action string, message string, extra map[string]interface{}) {
+	fields := map[string]interface{}{"id": msg.ID, "origin": msg.Origin, "action": action}
+	for key, value := range extra {
+		fields[key] = value
+	}
+	log := comp.log
+	for key, value := range fields {
+		log = log.WithField(key, value)
+	}
+	log.Info(message)
+	comp.accord.Emit(EventChaos, message, fields)
+}
@@ -0,0 +1,43 @@
+package components
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Ssawa/accord/accord"
+	"github.com/Ssawa/accord/accord/accordtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func remoteMessage(payload string) *accord.Message {
+	msg, _ := accord.NewMessage([]byte(payload))
+	msg.Origin = "elsewhere"
+	return msg
+}
+
+func TestChaos(t *testing.T) {
+	chaos := &Chaos{Seed: 1, FailureProbability: 1}
+	manager := &accordtest.Manager{}
+	acc := accordtest.New(t, manager, chaos)
+
+	assert.Equal(t, ErrChaos, acc.HandleRemoteMessage(remoteMessage("failed")))
+	accordtest.AssertPayloads(t, manager)
+
+	chaos.FailureProbability = 0
+	chaos.DuplicateProbability = 1
+	assert.Nil(t, acc.HandleRemoteMessage(remoteMessage("twice")))
+	accordtest.AssertPayloads(t, manager, "twice", "twice")
+
+	manager.Reset()
+	chaos.DuplicateProbability = 0
+	chaos.DelayProbability = 1
+	chaos.MaxDelay = 10 * time.Millisecond
+	assert.Nil(t, acc.HandleRemoteMessage(remoteMessage("late")))
+	accordtest.AssertPayloads(t, manager, "late")
+
+	// Once it's paused, Messages get through untouched
+	chaos.FailureProbability = 1
+	assert.Nil(t, acc.PauseComponent("Chaos"))
+	assert.Nil(t, acc.HandleRemoteMessage(remoteMessage("untouched")))
+	accordtest.AssertPayloads(t, manager, "late", "untouched")
+}
@@ -0,0 +1,562 @@
+package components
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Ssawa/accord/accord"
+)
+
+// Event kinds emitted by Cron as its jobs run, and when a run couldn't be handled
+const (
+	EventCronRun    = "cron_run"
+	EventCronFailed = "cron_failed"
+)
+
+// DefaultMaxCatchUp is the most runs a CronJob catches up on under CronCatchUpAll, when Cron's MaxCatchUp
+// isn't set
+const DefaultMaxCatchUp = 100
+
+// cronRetryInterval is how long a run that couldn't be handled for a reason that should pass (we're paused,
+// say) waits before it's tried again
+const cronRetryInterval = time.Second
+
+// Headers Cron adds to the Messages it creates, naming the job and the time the run was scheduled for
+const (
+	CronJobHeader = "cron-job"
+	CronAtHeader  = "cron-at"
+)
+
+// CronCatchUp decides what a CronJob does about the runs it missed while we were stopped
+type CronCatchUp int
+
+const (
+	// CronCatchUpLatest makes up for any number of missed runs with a single one, for the latest of them
+	CronCatchUpLatest CronCatchUp = iota
+
+	// CronCatchUpAll makes up every missed run, oldest first, up to Cron's MaxCatchUp most recent ones
+	CronCatchUpAll
+
+	// CronCatchUpSkip forgets about missed runs and carries on from the next one that's due
+	CronCatchUpSkip
+)
+
+// CronJob creates a Message on a schedule. Everything but the schedule and name is copied onto the Messages
+// it creates
+type CronJob struct {
+	// Name identifies the job, and has to be unique amongst a Cron's jobs
+	Name string
+
+	// Schedule is a standard five field cron expression ("minute hour day-of-month month day-of-week", so
+	// "0 0 * * *" is every midnight), which may use ranges, steps, lists and the names of months and days.
+	// "@yearly", "@monthly", "@weekly", "@daily" and "@hourly" are understood too, as is "@every 10m" for a
+	// fixed interval
+	Schedule string
+
+	Type    string
+	Key     string
+	Channel string
+	Headers map[string]string
+	Payload []byte
+
+	CatchUp CronCatchUp
+}
+
+// Cron is a Component that creates Messages on cron schedules, so that applications don't need a separate
+// scheduler process feeding us. Jobs come from Jobs, and can be added and removed while we're running
+// with AddJob and RemoveJob. When each job last ran, and the jobs added with AddJob, are kept in our state
+// (see accord.SaveComponentData), so after we've been stopped each job catches up on the runs it missed
+// according to its CatchUp.
+//
+// Only the leader runs jobs (see accord.IsLeader), so when every node in a cluster has the same jobs
+// they're still only run once between them; without a LeaderElector every node is its own leader. The
+// Messages a run creates also carry an IdempotencyKey naming the job and the time it was scheduled for,
+// so a run that was already handled (by a leader that's since been replaced, say) is dropped as a
+// duplicate within our DedupWindow
+type Cron struct {
+	accord.ComponentRunner
+
+	// Name is what our state is saved under (see accord.SaveComponentData), defaulting to "cron". Give
+	// each Cron a Name of its own
+	Name string
+
+	Jobs []CronJob
+
+	// Location is the time zone schedules are read in, defaulting to the local one
+	Location *time.Location
+
+	// MaxCatchUp is the most missed runs a job catches up on under CronCatchUpAll, defaulting to
+	// DefaultMaxCatchUp
+	MaxCatchUp int
+
+	accord *accord.Accord
+	log    accord.Logger
+
+	mutex   sync.Mutex
+	entries map[string]*cronEntry
+	added   []CronJob
+	lastRun map[string]time.Time
+
+	// started is when we were last started. Runs that were due before then were missed while we were
+	// stopped
+	started time.Time
+
+	// now tells the time, which is time.Now unless our tests want to move it along themselves
+	now func() time.Time
+}
+
+// cronEntry is a job we're running, along with its parsed schedule
+type cronEntry struct {
+	job        CronJob
+	schedule   *cronSchedule
+	retryAfter time.Time
+}
+
+// cronPersisted is what Cron keeps in our state
+type cronPersisted struct {
+	Added   []CronJob
+	LastRun map[string]time.Time
+}
+
+// Start loads our saved state and begins running our jobs
+func (comp *Cron) Start(acc *accord.Accord) error {
+	if comp.Name == "" {
+		comp.Name = "cron"
+	}
+	if comp.Location == nil {
+		comp.Location = time.Local
+	}
+	if comp.MaxCatchUp <= 0 {
+		comp.MaxCatchUp = DefaultMaxCatchUp
+	}
+	if comp.now == nil {
+		comp.now = time.Now
+	}
+	comp.accord = acc
+	comp.log = acc.Logger.WithField("component", "Cron").WithField("cron", comp.Name)
+
+	persisted := cronPersisted{}
+	data, err := acc.LoadComponentData(comp.Name)
+	if err != nil {
+		return err
+	}
+	if data != nil {
+		err = json.Unmarshal(data, &persisted)
+		if err != nil {
+			return err
+		}
+	}
+
+	comp.mutex.Lock()
+	defer comp.mutex.Unlock()
+	comp.entries = map[string]*cronEntry{}
+	comp.added = persisted.Added
+	comp.lastRun = persisted.LastRun
+	if comp.lastRun == nil {
+		comp.lastRun = map[string]time.Time{}
+	}
+	comp.started = comp.now()
+
+	// Jobs we were configured with take the place of any added earlier under the same name
+	for _, job := range append(append([]CronJob{}, comp.added...), comp.Jobs...) {
+		err = comp.addEntry(job)
+		if err != nil {
+			return err
+		}
+	}
+	for name := range comp.lastRun {
+		if _, ok := comp.entries[name]; !ok {
+			delete(comp.lastRun, name)
+		}
+	}
+
+	comp.ComponentRunner.Init(acc, comp.tick, nil, comp.log)
+	return nil
+}
+
+// addEntry parses a job's schedule and starts running it. A job we've never run before starts from now
+// rather than catching up on everything since the beginning of time. The caller must hold our mutex
+func (comp *Cron) addEntry(job CronJob) error {
+	if job.Name == "" {
+		return fmt.Errorf("cron jobs need a name")
+	}
+	schedule, err := parseCronSchedule(job.Schedule, comp.Location)
+	if err != nil {
+		return fmt.Errorf("cron job %q: %v", job.Name, err)
+	}
+	comp.entries[job.Name] = &cronEntry{job: job, schedule: schedule}
+	if _, ok := comp.lastRun[job.Name]; !ok {
+		comp.lastRun[job.Name] = comp.now()
+	}
+	return nil
+}
+
+// AddJob starts running a new job, which is kept in our state so that it's still run after we're restarted.
+// A job with the same name is replaced
+func (comp *Cron) AddJob(job CronJob) error {
+	comp.mutex.Lock()
+	defer comp.mutex.Unlock()
+
+	err := comp.addEntry(job)
+	if err != nil {
+		return err
+	}
+	added := []CronJob{}
+	for _, existing := range comp.added {
+		if existing.Name != job.Name {
+			added = append(added, existing)
+		}
+	}
+	comp.added = append(added, job)
+	comp.log.WithField("job", job.Name).WithField("schedule", job.Schedule).Info("Added a cron job")
+	return comp.persist()
+}
+
+// RemoveJob stops running a job. One we were configured with in Jobs comes back the next time we're started
+func (comp *Cron) RemoveJob(name string) error {
+	comp.mutex.Lock()
+	defer comp.mutex.Unlock()
+
+	if _, ok := comp.entries[name]; !ok {
+		return fmt.Errorf("no cron job is named %q", name)
+	}
+	delete(comp.entries, name)
+	delete(comp.lastRun, name)
+	added := []CronJob{}
+	for _, existing := range comp.added {
+		if existing.Name != name {
+			added = append(added, existing)
+		}
+	}
+	comp.added = added
+	comp.log.WithField("job", name).Info("Removed a cron job")
+	return comp.persist()
+}
+
+// NextRuns returns when each of the jobs we're running is next due, by name
+func (comp *Cron) NextRuns() map[string]time.Time {
+	comp.mutex.Lock()
+	defer comp.mutex.Unlock()
+	jobs := map[string]time.Time{}
+	for name, entry := range comp.entries {
+		jobs[name] = entry.schedule.next(comp.lastRun[name])
+	}
+	return jobs
+}
+
+// persist saves the jobs added with AddJob and when each job last ran. The caller must hold our mutex
+func (comp *Cron) persist() error {
+	data, err := json.Marshal(cronPersisted{Added: comp.added, LastRun: comp.lastRun})
+	if err != nil {
+		return err
+	}
+	return comp.accord.SaveComponentData(comp.Name, data)
+}
+
+func (comp *Cron) tick(*accord.Accord) {
+	time.Sleep(tickResolution)
+
+	comp.mutex.Lock()
+	defer comp.mutex.Unlock()
+
+	now := comp.now()
+	leader := comp.accord.IsLeader()
+	changed := false
+	for name, entry := range comp.entries {
+		if now.Before(entry.retryAfter) {
+			continue
+		}
+		runs, through := comp.due(entry, comp.lastRun[name], now)
+		if through.IsZero() {
+			continue
+		}
+		changed = true
+
+		// Followers keep up with the schedule without running anything, so that they don't catch up on
+		// what the leader already ran if they're elected
+		if !leader {
+			comp.lastRun[name] = through
+			continue
+		}
+		done := true
+		for _, at := range runs {
+			if !comp.run(entry, at) {
+				entry.retryAfter = now.Add(cronRetryInterval)
+				done = false
+				break
+			}
+			comp.lastRun[name] = at
+		}
+		if done {
+			comp.lastRun[name] = through
+		}
+	}
+
+	if changed {
+		err := comp.persist()
+		if err != nil {
+			comp.log.WithError(err).Warn("Unable to save when our cron jobs last ran")
+		}
+	}
+}
+
+// due returns the runs of a job that are due since it last ran, applying its CatchUp to the ones that were
+// missed while we were stopped, along with the latest run that's due (whether or not it's skipped), which
+// is the zero time if none are
+func (comp *Cron) due(entry *cronEntry, last time.Time, now time.Time) ([]time.Time, time.Time) {
+	missed := []time.Time{}
+	runs := []time.Time{}
+	var through time.Time
+	for at := entry.schedule.next(last); !at.IsZero() && !at.After(now); at = entry.schedule.next(at) {
+		through = at
+		if at.Before(comp.started) {
+			missed = append(missed, at)
+			// There's no need to remember more missed runs than we could ever catch up on
+			if len(missed) > comp.MaxCatchUp {
+				missed = missed[1:]
+			}
+		} else {
+			runs = append(runs, at)
+		}
+	}
+	if len(missed) == 0 {
+		return runs, through
+	}
+
+	log := comp.log.WithField("job", entry.job.Name).WithField("missed", len(missed))
+	switch entry.job.CatchUp {
+	case CronCatchUpAll:
+		log.Info("Catching up on cron runs missed while we were stopped")
+	case CronCatchUpSkip:
+		log.Info("Skipping cron runs missed while we were stopped")
+		missed = nil
+	default:
+		log.Info("Catching up on the latest cron run missed while we were stopped")
+		missed = missed[len(missed)-1:]
+	}
+	return append(missed, runs...), through
+}
+
+// run creates and handles the Message for a single run of a job, returning whether we're done with the run.
+// A run that fails for a reason that should pass is tried again later; any other failure is reported and
+// the run is dropped
+func (comp *Cron) run(entry *cronEntry, at time.Time) bool {
+	job := entry.job
+	msg, err := accord.NewMessage(job.Payload)
+	if err != nil {
+		comp.log.WithError(err).WithField("job", job.Name).Warn("Unable to create a message for a cron job")
+		return true
+	}
+	msg.Type = job.Type
+	msg.Key = job.Key
+	msg.Channel = job.Channel
+	msg.Headers = map[string]string{}
+	for key, value := range job.Headers {
+		msg.Headers[key] = value
+	}
+	msg.Headers[CronJobHeader] = job.Name
+	msg.Headers[CronAtHeader] = at.UTC().Format(time.RFC3339)
+	msg.IdempotencyKey = comp.Name + "/" + job.Name + "/" + strconv.FormatInt(at.Unix(), 10)
+
+	log := accord.WithMessage(comp.log, msg).WithField("job", job.Name).WithField("at", at)
+	fields := map[string]interface{}{"cron": comp.Name, "job": job.Name, "at": at.UTC(), "id": msg.ID}
+	err = comp.accord.HandleNewMessage(msg)
+	switch err {
+	case nil, accord.ErrDuplicate:
+		log.Debug("Ran a cron job")
+		comp.accord.Emit(EventCronRun, "A cron job ran", fields)
+		return true
+	case accord.ErrPaused, accord.ErrDraining, accord.ErrReadOnly, accord.ErrDiskQuota, accord.ErrQueueFull:
+		log.WithError(err).Debug("A cron job is due but its message can't be handled yet")
+		return false
+	}
+	log.WithError(err).Warn("A cron job's message failed")
+	fields["error"] = err.Error()
+	comp.accord.Emit(EventCronFailed, "A cron job's message failed", fields)
+	return true
+}
+
+// cronSchedule is a parsed CronJob Schedule: either a fixed interval, or the minutes, hours, days of the
+// month, months and days of the week it runs at, as bitsets
+type cronSchedule struct {
+	every time.Duration
+
+	minute, hour, dom, month, dow uint64
+
+	// A day only has to match one of the day of the month and the day of the week when both are
+	// restricted, as with every other cron
+	domAny, dowAny bool
+
+	location *time.Location
+}
+
+// cronDescriptors are the shorthands a schedule can be written as
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	cronMonths = []string{"", "jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	cronDays   = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+// parseCronSchedule parses a CronJob Schedule, read in the given time zone
+func parseCronSchedule(spec string, location *time.Location) (*cronSchedule, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "@every ") {
+		every, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+		if err != nil {
+			return nil, err
+		}
+		if every <= 0 {
+			return nil, fmt.Errorf("the interval in %q has to be positive", spec)
+		}
+		return &cronSchedule{every: every, location: location}, nil
+	}
+	if expanded, ok := cronDescriptors[spec]; ok {
+		spec = expanded
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%q should have five fields", spec)
+	}
+	schedule := &cronSchedule{location: location}
+	var err error
+	if schedule.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, err
+	}
+	if schedule.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, err
+	}
+	if schedule.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, err
+	}
+	if schedule.month, err = parseCronField(fields[3], 1, 12, cronMonths); err != nil {
+		return nil, err
+	}
+	if schedule.dow, err = parseCronField(fields[4], 0, 7, cronDays); err != nil {
+		return nil, err
+	}
+	// Sunday can be written as 7 as well as 0
+	if schedule.dow&(1<<7) != 0 {
+		schedule.dow |= 1
+	}
+	schedule.domAny = fields[2] == "*" || fields[2] == "?"
+	schedule.dowAny = fields[4] == "*" || fields[4] == "?"
+	return schedule, nil
+}
+
+// parseCronField parses a single comma separated field of a schedule into a bitset of the values it
+// matches, which have to be between min and max. Values can also be written as one of names, indexed by
+// the value they stand for
+func parseCronField(field string, min int, max int, names []string) (uint64, error) {
+	bits := uint64(0)
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			rangePart = part[:i]
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("%q has a bad step", part)
+			}
+		}
+
+		low, high := min, max
+		switch {
+		case rangePart == "*" || rangePart == "?":
+		case strings.Contains(rangePart, "-"):
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if low, err = parseCronValue(bounds[0], names); err != nil {
+				return 0, err
+			}
+			if high, err = parseCronValue(bounds[1], names); err != nil {
+				return 0, err
+			}
+		default:
+			var err error
+			if low, err = parseCronValue(rangePart, names); err != nil {
+				return 0, err
+			}
+			// "5/10" starts at 5 and carries on to the end of the field
+			if step == 1 {
+				high = low
+			}
+		}
+		if low < min || high > max || low > high {
+			return 0, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+		for value := low; value <= high; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+	return bits, nil
+}
+
+// parseCronValue parses a single value of a schedule field, either a number or one of names
+func parseCronValue(value string, names []string) (int, error) {
+	for i, name := range names {
+		if name != "" && strings.EqualFold(value, name) {
+			return i, nil
+		}
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%q isn't a valid value", value)
+	}
+	return parsed, nil
+}
+
+// next returns the first time after the given one that the schedule runs at, or the zero time if it never
+// does (like "0 0 30 2 *", the 30th of February)
+func (schedule *cronSchedule) next(after time.Time) time.Time {
+	if schedule.every > 0 {
+		return after.Add(schedule.every)
+	}
+
+	t := after.In(schedule.location)
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, schedule.location)
+	limit := t.Year() + 5
+	for t.Year() <= limit {
+		if schedule.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, schedule.location)
+			continue
+		}
+		if !schedule.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, schedule.location)
+			continue
+		}
+		if schedule.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, schedule.location)
+			continue
+		}
+		if schedule.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// matchesDay reports whether the schedule runs at all on t's day
+func (schedule *cronSchedule) matchesDay(t time.Time) bool {
+	dom := schedule.dom&(1<<uint(t.Day())) != 0
+	dow := schedule.dow&(1<<uint(t.Weekday())) != 0
+	if schedule.domAny || schedule.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -0,0 +1,159 @@
+package components
+
+import (
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Ssawa/accord/accord"
+	"github.com/Ssawa/accord/accord/accordtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCronScheduleNext(t *testing.T) {
+	at := func(value string) time.Time {
+		parsed, err := time.ParseInLocation("2006-01-02 15:04", value, time.UTC)
+		assert.Nil(t, err)
+		return parsed
+	}
+
+	cases := []struct {
+		spec  string
+		after string
+		next  string
+	}{
+		{"* * * * *", "2026-01-01 10:00", "2026-01-01 10:01"},
+		{"*/15 9-17 * * mon-fri", "2026-01-02 17:50", "2026-01-05 09:00"},
+		{"30 2 * jan,jul *", "2026-02-01 00:00", "2026-07-01 02:30"},
+		{"0 0 13 * fri", "2026-01-01 00:00", "2026-01-02 00:00"},
+		{"0 0 * * 7", "2026-01-01 00:00", "2026-01-04 00:00"},
+		{"5/20 * * * *", "2026-01-01 10:06", "2026-01-01 10:25"},
+		{"@daily", "2026-01-01 10:00", "2026-01-02 00:00"},
+		{"@every 90m", "2026-01-01 10:00", "2026-01-01 11:30"},
+	}
+	for _, c := range cases {
+		schedule, err := parseCronSchedule(c.spec, time.UTC)
+		if assert.Nil(t, err, c.spec) {
+			assert.Equal(t, at(c.next), schedule.next(at(c.after)), c.spec)
+		}
+	}
+
+	// The 30th of February never comes
+	schedule, err := parseCronSchedule("0 0 30 2 *", time.UTC)
+	assert.Nil(t, err)
+	assert.True(t, schedule.next(at("2026-01-01 00:00")).IsZero())
+
+	for _, spec := range []string{"61 * * * *", "* * *", "*/0 * * * *", "5-1 * * * *", "* * * smarch *", "@every -1m"} {
+		_, err := parseCronSchedule(spec, time.UTC)
+		assert.NotNil(t, err, spec)
+	}
+}
+
+// fakeClock is a clock our tests move along themselves, so that they aren't left waiting on schedules
+type fakeClock struct {
+	nanos int64
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{nanos: time.Now().UnixNano()}
+}
+
+func (clock *fakeClock) Now() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&clock.nanos))
+}
+
+func (clock *fakeClock) Advance(by time.Duration) {
+	atomic.AddInt64(&clock.nanos, int64(by))
+}
+
+func TestCron(t *testing.T) {
+	clock := newFakeClock()
+	cron := &Cron{Jobs: []CronJob{{Name: "tick", Schedule: "@every 1m", Type: "cron.tick", Payload: []byte("tick")}}, now: clock.Now}
+	manager := &accordtest.Manager{}
+	acc := accordtest.New(t, manager, cron)
+
+	for runs := uint64(1); runs <= 2; runs++ {
+		clock.Advance(time.Minute)
+		accordtest.Eventually(t, 500*time.Millisecond, func() bool { return acc.History().Len() == runs })
+	}
+	msg, err := acc.History().Get(0)
+	assert.Nil(t, err)
+	assert.Equal(t, "cron.tick", msg.Type)
+	assert.Equal(t, "tick", msg.Headers[CronJobHeader])
+	assert.NotEmpty(t, msg.Headers[CronAtHeader])
+	assert.Contains(t, msg.IdempotencyKey, "cron/tick/")
+
+	// Jobs added while we're running are kept in our state
+	assert.Nil(t, cron.AddJob(CronJob{Name: "nightly", Schedule: "@daily"}))
+	assert.NotNil(t, cron.AddJob(CronJob{Name: "broken", Schedule: "never"}))
+	assert.Nil(t, cron.RemoveJob("tick"))
+	assert.NotNil(t, cron.RemoveJob("tick"))
+	data, err := acc.LoadComponentData("cron")
+	assert.Nil(t, err)
+	persisted := cronPersisted{}
+	assert.Nil(t, json.Unmarshal(data, &persisted))
+	assert.Len(t, persisted.Added, 1)
+	assert.Equal(t, "nightly", persisted.Added[0].Name)
+
+	acc.Stop()
+	assert.Nil(t, acc.Start())
+	runs := cron.NextRuns()
+	assert.Contains(t, runs, "nightly")
+	assert.Contains(t, runs, "tick")
+}
+
+func TestCronCatchUp(t *testing.T) {
+	cases := []struct {
+		catchUp CronCatchUp
+		runs    uint64
+	}{
+		{CronCatchUpLatest, 1},
+		{CronCatchUpAll, 5},
+		{CronCatchUpSkip, 0},
+	}
+	for _, c := range cases {
+		cron := &Cron{Jobs: []CronJob{{Name: "hourly", Schedule: "@every 1h", CatchUp: c.catchUp}}}
+		acc := accordtest.NewUnstarted(t, &accordtest.Manager{}, cron)
+
+		// We last ran five and a half hours ago, so five runs were missed while we were stopped
+		assert.Nil(t, acc.Open())
+		lastRun := time.Now().Add(-5*time.Hour - 30*time.Minute)
+		data, _ := json.Marshal(cronPersisted{LastRun: map[string]time.Time{"hourly": lastRun}})
+		assert.Nil(t, acc.SaveComponentData("cron", data))
+		acc.Close()
+
+		assert.Nil(t, acc.Start())
+		accordtest.Eventually(t, 500*time.Millisecond, func() bool { return cron.NextRuns()["hourly"].After(time.Now()) })
+		accordtest.AssertHistoryLen(t, acc, c.runs)
+		acc.Stop()
+	}
+}
+
+func TestCronFollower(t *testing.T) {
+	clock := newFakeClock()
+	cron := &Cron{Jobs: []CronJob{{Name: "tick", Schedule: "@every 1m"}}, now: clock.Now}
+	acc := accordtest.NewUnstarted(t, &accordtest.Manager{}, cron, &followerElector{})
+	assert.Nil(t, acc.Start())
+	defer acc.Stop()
+
+	// Followers keep up with the schedule without running anything
+	clock.Advance(5 * time.Minute)
+	next := clock.Now()
+	accordtest.Eventually(t, 500*time.Millisecond, func() bool { return cron.NextRuns()["tick"].After(next) })
+	accordtest.AssertHistoryLen(t, acc, 0)
+}
+
+// followerElector is a LeaderElector that never elects us
+type followerElector struct {
+	accord.ComponentRunner
+}
+
+func (elector *followerElector) Start(acc *accord.Accord) error {
+	elector.Init(acc, func(*accord.Accord) { time.Sleep(tickResolution) }, nil, nil)
+	return nil
+}
+
+func (elector *followerElector) IsLeader() bool { return false }
+
+func (elector *followerElector) Leader() string { return "elsewhere" }
@@ -0,0 +1,52 @@
+package components
+
+import (
+	"time"
+
+	"github.com/Ssawa/accord/accord"
+	"github.com/Ssawa/accord/accord/discovery"
+)
+
+// DefaultDiscoveryInterval is how often Components ask their Discoverer for peers by default
+const DefaultDiscoveryInterval = 30 * time.Second
+
+// peerDiscovery asks a Discoverer for peers every so often on behalf of a Component. It's only ever used
+// from the Component's own loop
+type peerDiscovery struct {
+	discoverer discovery.Discoverer
+	interval   time.Duration
+	self       string
+	log        accord.Logger
+	last       time.Time
+}
+
+func newPeerDiscovery(discoverer discovery.Discoverer, interval time.Duration, self string, log accord.Logger) *peerDiscovery {
+	if interval <= 0 {
+		interval = DefaultDiscoveryInterval
+	}
+	return &peerDiscovery{discoverer: discoverer, interval: interval, self: self, log: log}
+}
+
+// poll returns every peer besides ourselves that our Discoverer knows of, if it's time to ask it again.
+// Otherwise (or if there's no Discoverer, or it fails) it returns nothing. It's up to the Component to
+// ignore the peers it already knows about
+func (found *peerDiscovery) poll() []discovery.Peer {
+	if found.discoverer == nil || time.Since(found.last) < found.interval {
+		return nil
+	}
+	found.last = time.Now()
+
+	discovered, err := found.discoverer.Discover()
+	if err != nil {
+		found.log.WithError(err).Warn("Unable to discover peers")
+		return nil
+	}
+
+	peers := []discovery.Peer{}
+	for _, peer := range discovered {
+		if peer.Name != found.self {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
@@ -0,0 +1,565 @@
+package components
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Ssawa/accord/accord"
+	"github.com/Ssawa/accord/accord/discovery"
+)
+
+// gossipSeenRetention is how long we remember the IDs of Messages we've already been gossiped, so that we
+// don't apply or spread them a second time
+const gossipSeenRetention = 10 * time.Minute
+
+// EventMemberChanged is emitted by Gossip whenever it changes its mind about a member of the cluster
+const EventMemberChanged = "gossip_member_changed"
+
+// MemberState is what a Gossip node believes about another member of the cluster
+type MemberState int
+
+const (
+	// MemberAlive means the member is responding
+	MemberAlive MemberState = iota
+
+	// MemberSuspect means somebody failed to reach the member. It has SuspicionTimeout to prove it's still
+	// alive before it's declared dead
+	MemberSuspect
+
+	// MemberDead means the member hasn't been heard from and is no longer gossiped to
+	MemberDead
+)
+
+// String returns a readable name for the state
+func (state MemberState) String() string {
+	switch state {
+	case MemberAlive:
+		return "alive"
+	case MemberSuspect:
+		return "suspect"
+	case MemberDead:
+		return "dead"
+	default:
+		return fmt.Sprintf("MemberState(%d)", int(state))
+	}
+}
+
+// Member is a node in the gossip cluster, as far as we know
+type Member struct {
+	Name string
+	URL  string
+
+	State MemberState
+
+	// Incarnation is bumped by a member whenever it needs to refute rumors of its death, so that newer
+	// news about a member always wins over older news
+	Incarnation uint64
+
+	// changedAt is when we last changed our mind about the member
+	changedAt time.Time
+}
+
+// gossipPacket is what gossiping nodes exchange. Membership is pushed and pulled (the response carries
+// the receiver's view of the membership back), while Messages are only ever pushed
+type gossipPacket struct {
+	From     Member
+	Members  []Member
+	Messages []accord.Message
+}
+
+// rumor is a Message we're spreading along with how many times we've passed it on
+type rumor struct {
+	msg       accord.Message
+	transmits int
+}
+
+// Gossip is a Component that spreads Messages epidemically through a mesh of Accord nodes, without any of
+// them needing to act as a hub. It's modelled on SWIM (and HashiCorp's memberlist): every Interval we pass
+// our membership list and our freshest Messages on to Fanout random members. Anyone who receives a Message
+// for the first time applies it and passes it on in turn, so it reaches every node within a number of rounds
+// proportional to the logarithm of the cluster size.
+//
+// Each Message is passed on RetransmitMult * ceil(log10(n+1)) times by every node that receives it, which
+// (like memberlist) makes the chance of a node missing out vanishingly small for any reasonable multiplier,
+// but not zero. Run AntiEntropy alongside Gossip when every node must be guaranteed to converge
+// eventually.
+//
+// Members that can't be reached are marked as suspect and, if they haven't proven otherwise within
+// SuspicionTimeout, dead. A member that hears rumors of its own death refutes them by bumping its
+// incarnation
+type Gossip struct {
+	accord.ComponentRunner
+
+	// BindAddress is the address our endpoint should be served on. If it's left empty no server is started
+	// and Handler should be mounted on an existing one instead
+	BindAddress string
+
+	// AdvertiseURL is the base URL other members reach us at. It's required
+	AdvertiseURL string
+
+	// Seeds are the base URLs of members to join the cluster through. Only one needs to be reachable
+	Seeds []string
+
+	// Discovery finds members on top of the ones we learn about through gossip, which is handy for
+	// bootstrapping without any Seeds
+	Discovery discovery.Discoverer
+
+	// DiscoveryInterval is how often Discovery is asked for members, defaulting to DefaultDiscoveryInterval
+	DiscoveryInterval time.Duration
+
+	// Fanout is how many members we gossip to every round, defaulting to 3
+	Fanout int
+
+	// Interval is how long to wait between rounds, defaulting to 200 milliseconds
+	Interval time.Duration
+
+	// RetransmitMult scales how many times each Message is passed on, defaulting to 4
+	RetransmitMult int
+
+	// SuspicionTimeout is how long a suspect member has to prove it's alive before it's declared dead,
+	// defaulting to 5 seconds
+	SuspicionTimeout time.Duration
+
+	// Client is used to talk to other members, defaulting to a client with a 2 second timeout
+	Client *http.Client
+
+	accord    *accord.Accord
+	log       accord.Logger
+	handler   http.Handler
+	server    *backgroundServer
+	cursor    *accord.PeerCursor
+	lastRound time.Time
+	discovery *peerDiscovery
+
+	// mutex protects everything below, which is shared between our loop and our endpoint
+	mutex   sync.Mutex
+	self    Member
+	members map[string]*Member
+	rumors  []*rumor
+	seen    map[uint64]time.Time
+
+	// events are emitted once our mutex is released, so that subscribers can safely call back into us
+	events []accord.Event
+}
+
+// Start sets up our endpoint and begins our background loop
+func (comp *Gossip) Start(acc *accord.Accord) error {
+	if comp.AdvertiseURL == "" {
+		return fmt.Errorf("Gossip needs an AdvertiseURL")
+	}
+
+	comp.accord = acc
+	comp.log = acc.Logger.WithField("component", "Gossip")
+	comp.self = Member{Name: acc.NodeID, URL: comp.AdvertiseURL, State: MemberAlive}
+	comp.members = map[string]*Member{}
+	comp.rumors = nil
+	comp.seen = map[uint64]time.Time{}
+	comp.lastRound = time.Time{}
+	comp.discovery = newPeerDiscovery(comp.Discovery, comp.DiscoveryInterval, acc.NodeID, comp.log)
+
+	// Our own Messages come off of the synchronization queue like they would for any other transport
+	comp.cursor = acc.AddPeer("gossip")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/gossip", comp.receive)
+	comp.handler = guardPeers(acc, mux)
+
+	if comp.BindAddress != "" {
+		comp.server = startServer(comp.BindAddress, comp.handler, comp.log)
+	}
+
+	comp.ComponentRunner.Init(acc, comp.tick, comp.cleanup, comp.log)
+	return nil
+}
+
+// Handler returns the endpoint other members gossip with, for mounting on an existing HTTP server. It's
+// only available after Start
+func (comp *Gossip) Handler() http.Handler {
+	return comp.handler
+}
+
+// Members returns every member we know of besides ourselves, sorted by name
+func (comp *Gossip) Members() []Member {
+	comp.mutex.Lock()
+	defer comp.mutex.Unlock()
+
+	members := []Member{}
+	for _, member := range comp.members {
+		members = append(members, *member)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Name < members[j].Name })
+	return members
+}
+
+func (comp *Gossip) tick(*accord.Accord) {
+	interval := comp.Interval
+	if interval <= 0 {
+		interval = 200 * time.Millisecond
+	}
+
+	if wait := interval - time.Since(comp.lastRound); wait > 0 {
+		if wait > tickResolution {
+			wait = tickResolution
+		}
+		time.Sleep(wait)
+		return
+	}
+	comp.lastRound = time.Now()
+
+	err := comp.collectLocal()
+	if err != nil {
+		comp.log.WithError(err).Warn("Unable to read new messages from the queue")
+	}
+
+	comp.mutex.Lock()
+	for _, found := range comp.discovery.poll() {
+		comp.merge(Member{Name: found.Name, URL: found.URL, State: MemberAlive}, false)
+	}
+	comp.expire()
+	targets := comp.pickTargets()
+	comp.unlock()
+
+	// Until we know of anybody we keep trying our seeds
+	if len(targets) == 0 {
+		for _, seed := range comp.Seeds {
+			if seed != comp.AdvertiseURL {
+				targets = append(targets, Member{URL: seed})
+			}
+		}
+	}
+
+	for _, target := range targets {
+		comp.gossipTo(target)
+	}
+}
+
+func (comp *Gossip) cleanup(*accord.Accord) {
+	if comp.server != nil {
+		comp.server.stop()
+	}
+}
+
+// collectLocal turns the Messages waiting in our queues into rumors
+func (comp *Gossip) collectLocal() error {
+	for _, channel := range append([]string{""}, comp.accord.ChannelNames()...) {
+		for _, cursor := range comp.cursor.Channel(channel).Shards() {
+			err := comp.collectChannel(cursor)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// collectChannel turns the Messages waiting in one shard of one of our channels' queues into rumors
+func (comp *Gossip) collectChannel(cursor *accord.PeerCursor) error {
+	for {
+		msgs, err := cursor.Peek(100)
+		if err != nil || len(msgs) == 0 {
+			return err
+		}
+
+		comp.mutex.Lock()
+		for _, msg := range msgs {
+			if _, ok := comp.seen[msg.ID]; !ok {
+				comp.seen[msg.ID] = time.Now()
+				comp.rumors = append(comp.rumors, &rumor{msg: *msg})
+			}
+		}
+		comp.mutex.Unlock()
+
+		err = cursor.Advance(len(msgs))
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// expire declares suspects that have run out of time dead and forgets Messages we've seen long enough
+// ago. The caller must hold our mutex
+func (comp *Gossip) expire() {
+	timeout := comp.SuspicionTimeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+
+	for _, member := range comp.members {
+		if member.State == MemberSuspect && time.Since(member.changedAt) > timeout {
+			comp.setState(member, MemberDead)
+		}
+	}
+
+	for id, at := range comp.seen {
+		if time.Since(at) > gossipSeenRetention {
+			delete(comp.seen, id)
+		}
+	}
+}
+
+// pickTargets chooses up to Fanout random members that aren't dead to gossip with. The caller must hold
+// our mutex
+func (comp *Gossip) pickTargets() []Member {
+	fanout := comp.Fanout
+	if fanout <= 0 {
+		fanout = 3
+	}
+
+	candidates := []Member{}
+	for _, member := range comp.members {
+		if member.State != MemberDead {
+			candidates = append(candidates, *member)
+		}
+	}
+
+	rand.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+	if len(candidates) > fanout {
+		candidates = candidates[:fanout]
+	}
+	return candidates
+}
+
+// retransmitLimit is how many times we pass each rumor on, scaled to the size of the cluster. The caller
+// must hold our mutex
+func (comp *Gossip) retransmitLimit() int {
+	mult := comp.RetransmitMult
+	if mult <= 0 {
+		mult = 4
+	}
+
+	alive := 1
+	for _, member := range comp.members {
+		if member.State != MemberDead {
+			alive++
+		}
+	}
+	return mult * int(math.Ceil(math.Log10(float64(alive+1))))
+}
+
+// packet builds what we're going to send out, counting the rumors it carries as transmitted. The caller
+// must hold our mutex
+func (comp *Gossip) packet() gossipPacket {
+	packet := gossipPacket{From: comp.self, Members: []Member{comp.self}}
+	for _, member := range comp.members {
+		packet.Members = append(packet.Members, *member)
+	}
+
+	limit := comp.retransmitLimit()
+	remaining := comp.rumors[:0]
+	for _, rumor := range comp.rumors {
+		packet.Messages = append(packet.Messages, rumor.msg)
+		rumor.transmits++
+		if rumor.transmits < limit {
+			remaining = append(remaining, rumor)
+		}
+	}
+	comp.rumors = remaining
+
+	return packet
+}
+
+// gossipTo sends a round of gossip to a single member
+func (comp *Gossip) gossipTo(target Member) {
+	log := comp.log.WithField("member", target.URL)
+
+	if !comp.accord.AllowPeer(peerAddress(target.URL)) {
+		return
+	}
+
+	comp.mutex.Lock()
+	packet := comp.packet()
+	comp.mutex.Unlock()
+
+	response, err := comp.send(target.URL, packet)
+	if err != nil {
+		log.WithError(err).Debug("Unable to gossip with member")
+		comp.mutex.Lock()
+		if member, ok := comp.members[target.Name]; ok && member.State == MemberAlive {
+			comp.setState(member, MemberSuspect)
+		}
+		comp.unlock()
+		return
+	}
+
+	comp.mutex.Lock()
+	comp.merge(response.From, true)
+	for _, member := range response.Members {
+		comp.merge(member, false)
+	}
+	comp.unlock()
+	if response.From.Name != "" {
+		comp.accord.Heartbeat(response.From.Name)
+	}
+}
+
+func (comp *Gossip) send(url string, packet gossipPacket) (*gossipPacket, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(packet)
+	if err != nil {
+		return nil, err
+	}
+
+	client := comp.Client
+	if client == nil {
+		client = &http.Client{Timeout: 2 * time.Second}
+	}
+
+	resp, err := peerClient(comp.accord, client, url).Post(strings.TrimRight(url, "/")+"/gossip", "application/octet-stream", &buf)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("member responded with %s", resp.Status)
+	}
+
+	response := &gossipPacket{}
+	err = gob.NewDecoder(resp.Body).Decode(response)
+	if err != nil {
+		comp.accord.ReportViolation(peerAddress(url), accord.ViolationMalformed, "unreadable gossip")
+		return nil, err
+	}
+	return response, nil
+}
+
+// receive handles a round of gossip from another member, responding with our own view of the membership
+func (comp *Gossip) receive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	packet := gossipPacket{}
+	err := gob.NewDecoder(r.Body).Decode(&packet)
+	if err != nil {
+		comp.accord.ReportViolation(r.RemoteAddr, accord.ViolationMalformed, "unreadable gossip")
+		http.Error(w, "unreadable gossip", http.StatusBadRequest)
+		return
+	}
+
+	if packet.From.Name != "" {
+		comp.accord.Heartbeat(packet.From.Name)
+	}
+	comp.mutex.Lock()
+	comp.merge(packet.From, true)
+	for _, member := range packet.Members {
+		comp.merge(member, false)
+	}
+
+	fresh := []accord.Message{}
+	for _, msg := range packet.Messages {
+		if _, ok := comp.seen[msg.ID]; !ok {
+			comp.seen[msg.ID] = time.Now()
+			fresh = append(fresh, msg)
+		}
+	}
+	comp.unlock()
+
+	for i := range fresh {
+		err = comp.accord.HandleRemoteMessage(&fresh[i])
+		if _, rejected := err.(*accord.StageError); err != nil && !rejected {
+			accord.WithMessage(comp.log, &fresh[i]).WithError(err).Warn("Unable to handle a gossiped message")
+			continue
+		}
+
+		// Whether or not we applied it ourselves, everyone else still needs to hear about it
+		comp.mutex.Lock()
+		comp.rumors = append(comp.rumors, &rumor{msg: fresh[i]})
+		comp.mutex.Unlock()
+	}
+
+	comp.mutex.Lock()
+	response := gossipPacket{From: comp.self, Members: []Member{comp.self}}
+	for _, member := range comp.members {
+		response.Members = append(response.Members, *member)
+	}
+	comp.mutex.Unlock()
+
+	var buf bytes.Buffer
+	err = gob.NewEncoder(&buf).Encode(response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(buf.Bytes())
+}
+
+// merge folds news about a member into our view, following SWIM's rules: news with a higher incarnation
+// always wins, and at the same incarnation only news that the member is worse off does. Hearing from a
+// member directly is proof that it's alive. The caller must hold our mutex
+func (comp *Gossip) merge(news Member, direct bool) {
+	if news.Name == "" {
+		return
+	}
+
+	if news.Name == comp.self.Name {
+		// Somebody thinks we're in trouble, so make sure our denial outranks the rumor
+		if news.State != MemberAlive && news.Incarnation >= comp.self.Incarnation {
+			comp.self.Incarnation = news.Incarnation + 1
+			comp.log.WithField("incarnation", comp.self.Incarnation).Info("Refuting rumors of our death")
+		}
+		return
+	}
+
+	member, ok := comp.members[news.Name]
+	if !ok {
+		member = &Member{Name: news.Name, URL: news.URL, State: news.State, Incarnation: news.Incarnation, changedAt: time.Now()}
+		comp.members[news.Name] = member
+		comp.log.WithField("member", news.Name).WithField("state", news.State).Info("Discovered member")
+		if direct && member.State != MemberAlive {
+			comp.setState(member, MemberAlive)
+		}
+		return
+	}
+
+	member.URL = news.URL
+	switch {
+	case direct:
+		if news.Incarnation > member.Incarnation {
+			member.Incarnation = news.Incarnation
+		}
+		if member.State != MemberAlive {
+			comp.setState(member, MemberAlive)
+		}
+	case news.Incarnation > member.Incarnation:
+		member.Incarnation = news.Incarnation
+		if member.State != news.State {
+			comp.setState(member, news.State)
+		}
+	case news.Incarnation == member.Incarnation && news.State > member.State:
+		comp.setState(member, news.State)
+	}
+}
+
+// setState changes our mind about a member. The caller must hold our mutex, and release it with unlock so
+// that the change gets announced
+func (comp *Gossip) setState(member *Member, state MemberState) {
+	comp.log.WithField("member", member.Name).WithField("state", state).Info("Member changed state")
+	member.State = state
+	member.changedAt = time.Now()
+	comp.events = append(comp.events, accord.Event{
+		Kind:    EventMemberChanged,
+		Message: "A gossip member changed state",
+		Fields:  map[string]interface{}{"member": member.Name, "url": member.URL, "state": state.String()},
+	})
+}
+
+// unlock releases our mutex and then emits any events that were queued up while it was held
+func (comp *Gossip) unlock() {
+	events := comp.events
+	comp.events = nil
+	comp.mutex.Unlock()
+
+	for _, event := range events {
+		comp.accord.Emit(event.Kind, event.Message, event.Fields)
+	}
+}
@@ -0,0 +1,116 @@
+package components
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Ssawa/accord/accord"
+	"github.com/Ssawa/accord/accord/accordtest"
+	"github.com/stretchr/testify/assert"
+)
+
+type gossipNode struct {
+	accord *accord.Accord
+	gossip *Gossip
+	server *httptest.Server
+	late   *lateHandler
+	dir    string
+}
+
+func newGossipNode(t *testing.T, name string, seeds ...string) *gossipNode {
+	dir, err := ioutil.TempDir("", "accord-gossip")
+	assert.Nil(t, err)
+
+	node := &gossipNode{dir: dir, late: &lateHandler{}}
+	node.server = httptest.NewServer(node.late)
+	node.accord = accord.NewAccord(accord.NewDummerManager(), nil, dir, accord.DummyAccord().Logger)
+	node.accord.NodeID = name
+	assert.Nil(t, node.accord.Start())
+
+	node.gossip = &Gossip{
+		AdvertiseURL:     node.server.URL,
+		Seeds:            seeds,
+		Fanout:           2,
+		Interval:         5 * time.Millisecond,
+		SuspicionTimeout: 25 * time.Millisecond,
+	}
+	assert.Nil(t, node.gossip.Start(node.accord))
+	node.late.handler = node.gossip.Handler()
+	return node
+}
+
+func (node *gossipNode) stop() {
+	node.gossip.Stop(0)
+	node.gossip.WaitForStop()
+	node.server.Close()
+	node.accord.Stop()
+	os.RemoveAll(node.dir)
+}
+
+func TestGossipSpreadsMessages(t *testing.T) {
+	seed := newGossipNode(t, "node-0")
+	defer seed.stop()
+
+	nodes := []*gossipNode{seed}
+	for _, name := range []string{"node-1", "node-2", "node-3"} {
+		node := newGossipNode(t, name, seed.server.URL)
+		defer node.stop()
+		nodes = append(nodes, node)
+	}
+
+	// Everybody only knows about the seed to begin with, but should learn about everybody else from it
+	for _, node := range nodes {
+		current := node
+		accordtest.Eventually(t, 500*time.Millisecond, func() bool { return len(current.gossip.Members()) == 3 })
+	}
+
+	msg, err := accord.NewMessage([]byte("rumor"))
+	assert.Nil(t, err)
+	assert.Nil(t, nodes[3].accord.HandleNewMessage(msg))
+
+	for _, node := range nodes {
+		current := node
+		accordtest.Eventually(t, 500*time.Millisecond, func() bool { return current.accord.History().Len() == 1 })
+	}
+	assert.True(t, nodes[0].accord.CompareDigest(nodes[3].accord.Digest()).Equal)
+}
+
+func TestGossipDetectsFailures(t *testing.T) {
+	seed := newGossipNode(t, "node-0")
+	defer seed.stop()
+	other := newGossipNode(t, "node-1", seed.server.URL)
+
+	accordtest.Eventually(t, 500*time.Millisecond, func() bool { return len(seed.gossip.Members()) == 1 })
+	other.stop()
+
+	accordtest.Eventually(t, 500*time.Millisecond, func() bool {
+		members := seed.gossip.Members()
+		return len(members) == 1 && members[0].State == MemberDead
+	})
+}
+
+func TestGossipRefutesSuspicion(t *testing.T) {
+	node := newGossipNode(t, "node-0")
+	defer node.stop()
+
+	node.gossip.mutex.Lock()
+	node.gossip.merge(Member{Name: "node-0", State: MemberSuspect, Incarnation: 3}, false)
+	incarnation := node.gossip.self.Incarnation
+	node.gossip.mutex.Unlock()
+
+	assert.Equal(t, uint64(4), incarnation)
+}
+
+func TestGossipMalformed(t *testing.T) {
+	node := newGossipNode(t, "node")
+	defer node.stop()
+
+	resp, err := http.Post(node.server.URL+"/gossip", "application/octet-stream", nil)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 400, resp.StatusCode)
+}
@@ -0,0 +1,332 @@
+package components
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/Ssawa/accord/accord"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultGRPCListLimit is how many Messages ListPending returns when a request doesn't set a limit
+const DefaultGRPCListLimit = 100
+
+// maxGRPCListLimit is the most Messages ListPending returns at once, whatever a request asks for
+const maxGRPCListLimit = 1000
+
+// GRPCService is an optional Component serving a gRPC service on the local host, so that sidecars and
+// processes written in other languages can drive a running node programmatically. The service, described
+// in grpc.proto, has four methods:
+//
+//	Submit       creates new Messages, atomically when there's more than one of them (see
+//	             accord.HandleNewMessages), or schedules a single one for later
+//	GetStatus    describes the node, as Admin's "/admin/status" does
+//	ListPending  lists the Messages on a channel waiting to be synchronized
+//	GetState     returns the node's state, and optionally the data a Component saved
+//
+// Submit answers like MessageAPI does, in gRPC's terms: PermissionDenied on a replica or for a Type that
+// can't be created, InvalidArgument for a request that doesn't describe valid Messages, FailedPrecondition
+// if they were refused (by a pipeline, say) and Unavailable if they can't be handled for now, in which
+// case the request can be retried. Duplicates aren't errors, but are flagged in the response.
+//
+// As with MessageAPI, requests have to present one of our Tokens, as a bearer token in their
+// "authorization" metadata, unless Tokens is empty
+type GRPCService struct {
+	accord.ComponentRunner
+
+	// BindAddress is the address the service should be served on, defaulting to "127.0.0.1:7072"
+	BindAddress string
+
+	// Tokens are the tokens we accept. Anyone who can reach BindAddress can use the service while it's
+	// empty
+	Tokens []MessageAPIToken
+
+	// Types limits the Types of the Messages that can be submitted to these ones, when it isn't empty
+	Types []string
+
+	// MaxMessages is the most Messages we accept in one request, defaulting to DefaultMessageAPIMaxMessages
+	MaxMessages int
+
+	accord   *accord.Accord
+	log      accord.Logger
+	server   *grpc.Server
+	listener net.Listener
+	done     chan struct{}
+}
+
+// grpcServiceName is the full name of the service in grpc.proto
+const grpcServiceName = "accord.v1.Accord"
+
+// grpcAccordServer is the service described in grpc.proto, which GRPCService implements
+type grpcAccordServer interface {
+	submit(ctx context.Context, req *grpcSubmitRequest) (*grpcSubmitResponse, error)
+	getStatus(ctx context.Context, req *grpcEmpty) (*grpcStatus, error)
+	listPending(ctx context.Context, req *grpcListPendingRequest) (*grpcListPendingResponse, error)
+	getState(ctx context.Context, req *grpcGetStateRequest) (*grpcState, error)
+}
+
+// grpcServiceDesc is what protoc would generate for grpc.proto's service, written out by hand along with
+// our messages (see grpc_wire.go)
+var grpcServiceDesc = grpc.ServiceDesc{
+	ServiceName: grpcServiceName,
+	HandlerType: (*grpcAccordServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Submit", Handler: grpcHandler("Submit", grpcAccordServer.submit)},
+		{MethodName: "GetStatus", Handler: grpcHandler("GetStatus", grpcAccordServer.getStatus)},
+		{MethodName: "ListPending", Handler: grpcHandler("ListPending", grpcAccordServer.listPending)},
+		{MethodName: "GetState", Handler: grpcHandler("GetState", grpcAccordServer.getState)},
+	},
+	Metadata: "grpc.proto",
+}
+
+// grpcHandler adapts one of grpcAccordServer's methods to a grpc.MethodDesc's handler, decoding its request
+// and running it through the server's interceptor
+func grpcHandler[Req any, Resp grpcMessage](name string, method func(grpcAccordServer, context.Context, *Req) (Resp, error)) grpc.MethodHandler {
+	return func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		req := new(Req)
+		if err := dec(req); err != nil {
+			return nil, err
+		}
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			return method(srv.(grpcAccordServer), ctx, req.(*Req))
+		}
+		if interceptor == nil {
+			return handler(ctx, req)
+		}
+		info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + grpcServiceName + "/" + name}
+		return interceptor(ctx, req, info, handler)
+	}
+}
+
+// grpcClientKey is where the name of the client a request was authenticated as is kept in its context
+type grpcClientKey struct{}
+
+// Start starts listening on our BindAddress and serving the service
+func (comp *GRPCService) Start(acc *accord.Accord) error {
+	comp.accord = acc
+	comp.log = acc.Logger.WithField("component", "GRPCService")
+
+	address := comp.BindAddress
+	if address == "" {
+		address = "127.0.0.1:7072"
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	comp.listener = listener
+	comp.server = grpc.NewServer(grpc.ForceServerCodec(grpcCodec{}), grpc.UnaryInterceptor(comp.authenticate))
+	comp.server.RegisterService(&grpcServiceDesc, comp)
+	comp.done = make(chan struct{})
+
+	comp.log.WithField("address", listener.Addr().String()).Info("Starting gRPC server")
+	go func() {
+		defer close(comp.done)
+		err := comp.server.Serve(listener)
+		if err != nil {
+			comp.log.WithError(err).Warn("gRPC server stopped unexpectedly")
+		}
+	}()
+
+	comp.ComponentRunner.Init(acc, comp.tick, comp.cleanup, comp.log)
+	return nil
+}
+
+// Addr returns the address we're serving on, which is useful when BindAddress has port 0. It's only
+// available after Start
+func (comp *GRPCService) Addr() net.Addr {
+	return comp.listener.Addr()
+}
+
+// Our service does all of our work, so our loop has nothing to do but wait to be stopped
+func (comp *GRPCService) tick(*accord.Accord) {
+	time.Sleep(tickResolution)
+}
+
+// cleanup lets in flight requests finish for as long as we'd give an HTTP server's before cutting them off
+func (comp *GRPCService) cleanup(*accord.Accord) {
+	comp.log.Info("Shutting down gRPC server")
+	stopped := make(chan struct{})
+	go func() {
+		comp.server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(serverShutdownTimeout):
+		comp.server.Stop()
+	}
+	<-comp.done
+	comp.log.Info("gRPC server safely shutdown")
+}
+
+// authenticate refuses requests without a token we accept, and keeps the name of the client the token
+// belongs to in the context of those with one
+func (comp *GRPCService) authenticate(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	token := ""
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		if len(value) > 7 && strings.EqualFold(value[:7], "Bearer ") {
+			token = strings.TrimSpace(value[7:])
+		}
+	}
+	client, ok := acceptToken(comp.Tokens, token)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "unauthorized")
+	}
+	return handler(context.WithValue(ctx, grpcClientKey{}, client), req)
+}
+
+func (comp *GRPCService) submit(ctx context.Context, req *grpcSubmitRequest) (*grpcSubmitResponse, error) {
+	maxMessages := comp.MaxMessages
+	if maxMessages <= 0 {
+		maxMessages = DefaultMessageAPIMaxMessages
+	}
+	if len(req.Messages) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "there are no messages in the request")
+	}
+	if len(req.Messages) > maxMessages {
+		return nil, status.Errorf(codes.InvalidArgument, "requests can have at most %d messages", maxMessages)
+	}
+
+	client, _ := ctx.Value(grpcClientKey{}).(string)
+	msgs := []*accord.Message{}
+	for _, newMsg := range req.Messages {
+		if len(req.Messages) > 1 && !newMsg.At.IsZero() {
+			return nil, status.Error(codes.InvalidArgument, "a group of messages can't be scheduled")
+		}
+		if !allowedType(comp.Types, newMsg.Type) {
+			return nil, status.Errorf(codes.PermissionDenied, "messages of type %q can't be created", newMsg.Type)
+		}
+		msg, err := accord.NewMessage(newMsg.Payload)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		msg.Type = newMsg.Type
+		msg.Key = newMsg.Key
+		msg.Channel = newMsg.Channel
+		msg.IdempotencyKey = newMsg.IdempotencyKey
+		msg.Headers = map[string]string{}
+		for key, value := range newMsg.Headers {
+			msg.Headers[key] = value
+		}
+		if client != "" {
+			msg.Headers[MessageAPIClientHeader] = client
+		}
+		msgs = append(msgs, msg)
+	}
+
+	var err error
+	if len(msgs) == 1 && !req.Messages[0].At.IsZero() {
+		err = comp.accord.HandleNewMessageAt(msgs[0], req.Messages[0].At)
+	} else {
+		err = comp.accord.HandleNewMessagesContext(ctx, msgs...)
+	}
+
+	resp := &grpcSubmitResponse{}
+	for _, msg := range msgs {
+		resp.IDs = append(resp.IDs, msg.ID)
+	}
+	switch {
+	case err == nil:
+		return resp, nil
+	case err == accord.ErrDuplicate:
+		resp.Duplicate = true
+		return resp, nil
+	case err == accord.ErrUnknownChannel:
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	case handleLater(err):
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	if _, replica := err.(*accord.ReplicaError); replica {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	if _, invalid := err.(*accord.ValidationError); invalid {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	comp.log.WithError(err).Info("Messages submitted over gRPC were refused")
+	return nil, status.Error(codes.FailedPrecondition, err.Error())
+}
+
+func (comp *GRPCService) getStatus(ctx context.Context, req *grpcEmpty) (*grpcStatus, error) {
+	acc := comp.accord
+	resp := &grpcStatus{
+		Node:          acc.NodeID,
+		QueueLength:   acc.QueuedMessages(),
+		HistoryLength: acc.History().Len(),
+		DigestRoot:    acc.Digest().RootString(),
+		Clock:         acc.Clock(),
+		HeldBack:      uint64(acc.HeldBack()),
+		ReadOnly:      acc.ReadOnly(),
+		Paused:        acc.Paused(),
+		Draining:      acc.Draining(),
+		Leader:        acc.Leader(),
+	}
+	for _, name := range acc.Peers() {
+		lag, err := acc.PeerLag(name)
+		if err != nil {
+			continue
+		}
+		resp.Peers = append(resp.Peers, &grpcPeer{Name: name, Pending: lag})
+	}
+	return resp, nil
+}
+
+func (comp *GRPCService) listPending(ctx context.Context, req *grpcListPendingRequest) (*grpcListPendingResponse, error) {
+	queue := comp.accord.ChannelQueue(req.Channel)
+	if queue == nil {
+		return nil, status.Error(codes.NotFound, accord.ErrUnknownChannel.Error())
+	}
+	limit := req.Limit
+	if limit == 0 {
+		limit = DefaultGRPCListLimit
+	}
+	if limit > maxGRPCListLimit {
+		limit = maxGRPCListLimit
+	}
+
+	resp := &grpcListPendingResponse{Total: queue.Len()}
+	for offset := req.Offset; offset < resp.Total && offset-req.Offset < limit; offset++ {
+		msg, err := queue.Get(offset)
+		if err != nil {
+			// The queue can shrink as Messages are sent off while we're listing it
+			break
+		}
+		resp.Messages = append(resp.Messages, &grpcPendingMessage{
+			ID:             msg.ID,
+			Origin:         msg.Origin,
+			Type:           msg.Type,
+			Key:            msg.Key,
+			Channel:        msg.Channel,
+			Headers:        msg.Headers,
+			Payload:        msg.Payload,
+			Timestamp:      msg.Timestamp,
+			IdempotencyKey: msg.IdempotencyKey,
+		})
+	}
+	return resp, nil
+}
+
+func (comp *GRPCService) getState(ctx context.Context, req *grpcGetStateRequest) (*grpcState, error) {
+	acc := comp.accord
+	resp := &grpcState{
+		Current:       acc.CurrentState(),
+		DigestRoot:    acc.Digest().RootString(),
+		Clock:         acc.Clock(),
+		HistoryLength: acc.History().Len(),
+	}
+	if req.Component != "" {
+		data, err := acc.LoadComponentData(req.Component)
+		if err != nil {
+			return nil, status.Error(codes.Internal, fmt.Sprintf("unable to load component data: %v", err))
+		}
+		resp.ComponentData = data
+	}
+	return resp, nil
+}
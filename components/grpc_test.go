@@ -0,0 +1,164 @@
+package components
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Ssawa/accord/accord/accordtest"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// dialGRPC connects to a GRPCService, returning a function calling its methods with a token
+func dialGRPC(t *testing.T, service *GRPCService) func(token string, method string, req grpcMessage, resp grpcMessage) codes.Code {
+	conn, err := grpc.NewClient("passthrough:///"+service.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(grpcCodec{})))
+	assert.Nil(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return func(token string, method string, req grpcMessage, resp grpcMessage) codes.Code {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if token != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+		}
+		return status.Code(conn.Invoke(ctx, "/accord.v1.Accord/"+method, req, resp))
+	}
+}
+
+func TestGRPCService(t *testing.T) {
+	manager := &accordtest.Manager{}
+	service := &GRPCService{BindAddress: "127.0.0.1:0", Tokens: []MessageAPIToken{{Client: "billing", Token: "s3cret"}}, Types: []string{"invoice.created", "invoice.paid"}}
+	acc := accordtest.New(t, manager, service)
+	call := dialGRPC(t, service)
+
+	submit := &grpcSubmitRequest{Messages: []*grpcNewMessage{{
+		Type: "invoice.created", Key: "inv-1", Payload: []byte(`{"id":1}`), IdempotencyKey: "inv-1-created", Headers: map[string]string{"source": "test"},
+	}}}
+	assert.Equal(t, codes.Unauthenticated, call("", "Submit", submit, &grpcSubmitResponse{}))
+	assert.Equal(t, codes.Unauthenticated, call("wrong", "Submit", submit, &grpcSubmitResponse{}))
+
+	resp := &grpcSubmitResponse{}
+	assert.Equal(t, codes.OK, call("s3cret", "Submit", submit, resp))
+	assert.Len(t, resp.IDs, 1)
+	assert.False(t, resp.Duplicate)
+	msg, err := acc.History().Get(0)
+	assert.Nil(t, err)
+	assert.Equal(t, resp.IDs[0], msg.ID)
+	assert.Equal(t, `{"id":1}`, string(msg.Payload))
+	assert.Equal(t, "test", msg.Headers["source"])
+	assert.Equal(t, "billing", msg.Headers[MessageAPIClientHeader])
+
+	// Retrying a request that was already handled is harmless
+	resp = &grpcSubmitResponse{}
+	assert.Equal(t, codes.OK, call("s3cret", "Submit", submit, resp))
+	assert.True(t, resp.Duplicate)
+
+	// Groups are handled atomically
+	resp = &grpcSubmitResponse{}
+	group := &grpcSubmitRequest{Messages: []*grpcNewMessage{{Type: "invoice.paid", Key: "inv-1"}, {Type: "invoice.paid", Key: "inv-2"}}}
+	assert.Equal(t, codes.OK, call("s3cret", "Submit", group, resp))
+	assert.Len(t, resp.IDs, 2)
+	accordtest.AssertHistoryLen(t, acc, 3)
+
+	at := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	for code, req := range map[codes.Code]*grpcSubmitRequest{
+		codes.PermissionDenied: {Messages: []*grpcNewMessage{{Type: "invoice.deleted"}}},
+		codes.InvalidArgument:  {Messages: []*grpcNewMessage{{Type: "invoice.paid", Channel: "missing"}}},
+	} {
+		assert.Equal(t, code, call("s3cret", "Submit", req, &grpcSubmitResponse{}))
+	}
+	assert.Equal(t, codes.InvalidArgument, call("s3cret", "Submit", &grpcSubmitRequest{}, &grpcSubmitResponse{}))
+	scheduledGroup := &grpcSubmitRequest{Messages: []*grpcNewMessage{{Type: "invoice.paid", At: at}, {Type: "invoice.paid"}}}
+	assert.Equal(t, codes.InvalidArgument, call("s3cret", "Submit", scheduledGroup, &grpcSubmitResponse{}))
+	accordtest.AssertHistoryLen(t, acc, 3)
+
+	// Messages can be scheduled for later
+	scheduled := &grpcSubmitRequest{Messages: []*grpcNewMessage{{Type: "invoice.paid", At: at}}}
+	assert.Equal(t, codes.OK, call("s3cret", "Submit", scheduled, &grpcSubmitResponse{}))
+	pending, err := acc.ScheduledMessages()
+	assert.Nil(t, err)
+	assert.Len(t, pending, 1)
+
+	// Messages can't be created while we're paused, but can be once we resume
+	acc.Pause()
+	assert.Equal(t, codes.Unavailable, call("s3cret", "Submit", &grpcSubmitRequest{Messages: []*grpcNewMessage{{Type: "invoice.paid"}}}, &grpcSubmitResponse{}))
+	acc.Resume()
+
+	nodeStatus := &grpcStatus{}
+	assert.Equal(t, codes.Unauthenticated, call("", "GetStatus", &grpcEmpty{}, nodeStatus))
+	assert.Equal(t, codes.OK, call("s3cret", "GetStatus", &grpcEmpty{}, nodeStatus))
+	assert.Equal(t, acc.NodeID, nodeStatus.Node)
+	assert.Equal(t, uint64(3), nodeStatus.HistoryLength)
+	assert.Equal(t, acc.Digest().RootString(), nodeStatus.DigestRoot)
+	assert.Equal(t, uint64(3), nodeStatus.Clock[acc.NodeID])
+
+	list := &grpcListPendingResponse{}
+	assert.Equal(t, codes.OK, call("s3cret", "ListPending", &grpcListPendingRequest{Offset: 1, Limit: 1}, list))
+	assert.Equal(t, acc.Queue().Len(), list.Total)
+	if assert.Len(t, list.Messages, 1) {
+		queued, err := acc.Queue().Get(1)
+		assert.Nil(t, err)
+		assert.Equal(t, queued.ID, list.Messages[0].ID)
+		assert.Equal(t, queued.Key, list.Messages[0].Key)
+		assert.Equal(t, acc.NodeID, list.Messages[0].Origin)
+		assert.True(t, queued.Timestamp.Equal(list.Messages[0].Timestamp))
+	}
+	assert.Equal(t, codes.NotFound, call("s3cret", "ListPending", &grpcListPendingRequest{Channel: "missing"}, &grpcListPendingResponse{}))
+
+	assert.Nil(t, acc.SaveComponentData("tool/cursor", []byte("42")))
+	state := &grpcState{}
+	assert.Equal(t, codes.OK, call("s3cret", "GetState", &grpcGetStateRequest{Component: "tool/cursor"}, state))
+	assert.Equal(t, acc.CurrentState(), state.Current)
+	assert.Equal(t, uint64(3), state.HistoryLength)
+	assert.Equal(t, []byte("42"), state.ComponentData)
+}
+
+func TestGRPCServiceReplica(t *testing.T) {
+	manager := &accordtest.Manager{}
+	service := &GRPCService{BindAddress: "127.0.0.1:0"}
+	acc := accordtest.NewUnstarted(t, manager, service)
+	acc.Replica = true
+	assert.Nil(t, acc.Start())
+	call := dialGRPC(t, service)
+
+	assert.Equal(t, codes.PermissionDenied, call("", "Submit", &grpcSubmitRequest{Messages: []*grpcNewMessage{{Type: "invoice.paid"}}}, &grpcSubmitResponse{}))
+	assert.Equal(t, codes.OK, call("", "GetStatus", &grpcEmpty{}, &grpcStatus{}))
+	accordtest.AssertHistoryLen(t, acc, 0)
+}
+
+func TestGRPCWire(t *testing.T) {
+	// Messages survive being encoded and decoded, including the maps and timestamps that are written out by
+	// hand
+	msg := &grpcPendingMessage{
+		ID:        1 << 60,
+		Origin:    "node-1",
+		Headers:   map[string]string{"a": "1", "b": ""},
+		Payload:   []byte{0, 1, 2},
+		Timestamp: time.Date(2024, 5, 6, 7, 8, 9, 10, time.UTC),
+	}
+	decoded := &grpcPendingMessage{}
+	assert.Nil(t, decoded.unmarshal(msg.marshal()))
+	assert.Equal(t, msg, decoded)
+
+	resp := &grpcSubmitResponse{IDs: []uint64{1, 300, 1 << 40}, Duplicate: true}
+	decodedResp := &grpcSubmitResponse{}
+	assert.Nil(t, decodedResp.unmarshal(resp.marshal()))
+	assert.Equal(t, resp, decodedResp)
+
+	state := &grpcState{Clock: map[string]uint64{"node-1": 3, "node-2": 0}}
+	decodedState := &grpcState{}
+	assert.Nil(t, decodedState.unmarshal(state.marshal()))
+	assert.Equal(t, state, decodedState)
+
+	// The same message is always encoded the same way
+	assert.Equal(t, msg.marshal(), msg.marshal())
+
+	assert.NotNil(t, decoded.unmarshal([]byte{0x0a, 0x05, 'a'}))
+}
@@ -0,0 +1,567 @@
+package components
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The messages of GRPCService, as described in grpc.proto. They're encoded by hand with protowire rather
+// than generated, which keeps protoc out of our build while staying compatible with clients generated from
+// grpc.proto in any language
+
+// grpcMessage is one of GRPCService's messages
+type grpcMessage interface {
+	marshal() []byte
+	unmarshal(data []byte) error
+}
+
+// grpcCodec encodes GRPCService's messages in place of gRPC's usual codec, which only knows about
+// generated ones
+type grpcCodec struct{}
+
+func (grpcCodec) Marshal(v interface{}) ([]byte, error) {
+	msg, ok := v.(grpcMessage)
+	if !ok {
+		return nil, fmt.Errorf("unable to encode %T", v)
+	}
+	return msg.marshal(), nil
+}
+
+func (grpcCodec) Unmarshal(data []byte, v interface{}) error {
+	msg, ok := v.(grpcMessage)
+	if !ok {
+		return fmt.Errorf("unable to decode %T", v)
+	}
+	return msg.unmarshal(data)
+}
+
+func (grpcCodec) Name() string {
+	return "proto"
+}
+
+type grpcNewMessage struct {
+	Type           string
+	Key            string
+	Channel        string
+	Headers        map[string]string
+	IdempotencyKey string
+	Payload        []byte
+	At             time.Time
+}
+
+func (msg *grpcNewMessage) marshal() []byte {
+	b := appendString(nil, 1, msg.Type)
+	b = appendString(b, 2, msg.Key)
+	b = appendString(b, 3, msg.Channel)
+	b = appendStringMap(b, 4, msg.Headers)
+	b = appendString(b, 5, msg.IdempotencyKey)
+	b = appendBytes(b, 6, msg.Payload)
+	return appendTimestamp(b, 7, msg.At)
+}
+
+func (msg *grpcNewMessage) unmarshal(data []byte) error {
+	return readFields(data, func(num protowire.Number, typ protowire.Type, v uint64, field []byte) error {
+		var err error
+		switch num {
+		case 1:
+			msg.Type = string(field)
+		case 2:
+			msg.Key = string(field)
+		case 3:
+			msg.Channel = string(field)
+		case 4:
+			msg.Headers, err = readStringMapEntry(msg.Headers, field)
+		case 5:
+			msg.IdempotencyKey = string(field)
+		case 6:
+			msg.Payload = append([]byte{}, field...)
+		case 7:
+			msg.At, err = readTimestamp(field)
+		}
+		return err
+	})
+}
+
+type grpcSubmitRequest struct {
+	Messages []*grpcNewMessage
+}
+
+func (req *grpcSubmitRequest) marshal() []byte {
+	var b []byte
+	for _, msg := range req.Messages {
+		b = appendMessage(b, 1, msg)
+	}
+	return b
+}
+
+func (req *grpcSubmitRequest) unmarshal(data []byte) error {
+	return readFields(data, func(num protowire.Number, typ protowire.Type, v uint64, field []byte) error {
+		if num != 1 {
+			return nil
+		}
+		msg := &grpcNewMessage{}
+		req.Messages = append(req.Messages, msg)
+		return msg.unmarshal(field)
+	})
+}
+
+type grpcSubmitResponse struct {
+	IDs       []uint64
+	Duplicate bool
+}
+
+func (resp *grpcSubmitResponse) marshal() []byte {
+	var b []byte
+	if len(resp.IDs) > 0 {
+		var packed []byte
+		for _, id := range resp.IDs {
+			packed = protowire.AppendVarint(packed, id)
+		}
+		b = appendBytes(b, 1, packed)
+	}
+	return appendBool(b, 2, resp.Duplicate)
+}
+
+func (resp *grpcSubmitResponse) unmarshal(data []byte) error {
+	return readFields(data, func(num protowire.Number, typ protowire.Type, v uint64, field []byte) error {
+		var err error
+		switch num {
+		case 1:
+			resp.IDs, err = readUints(resp.IDs, typ, v, field)
+		case 2:
+			resp.Duplicate = v != 0
+		}
+		return err
+	})
+}
+
+// grpcEmpty is a request without any fields
+type grpcEmpty struct{}
+
+func (*grpcEmpty) marshal() []byte { return nil }
+
+func (*grpcEmpty) unmarshal(data []byte) error {
+	return readFields(data, func(protowire.Number, protowire.Type, uint64, []byte) error { return nil })
+}
+
+type grpcPeer struct {
+	Name    string
+	Pending uint64
+}
+
+func (peer *grpcPeer) marshal() []byte {
+	return appendUint(appendString(nil, 1, peer.Name), 2, peer.Pending)
+}
+
+func (peer *grpcPeer) unmarshal(data []byte) error {
+	return readFields(data, func(num protowire.Number, typ protowire.Type, v uint64, field []byte) error {
+		switch num {
+		case 1:
+			peer.Name = string(field)
+		case 2:
+			peer.Pending = v
+		}
+		return nil
+	})
+}
+
+type grpcStatus struct {
+	Node          string
+	QueueLength   uint64
+	HistoryLength uint64
+	DigestRoot    string
+	Clock         map[string]uint64
+	HeldBack      uint64
+	ReadOnly      bool
+	Paused        bool
+	Draining      bool
+	Leader        string
+	Peers         []*grpcPeer
+}
+
+func (status *grpcStatus) marshal() []byte {
+	b := appendString(nil, 1, status.Node)
+	b = appendUint(b, 2, status.QueueLength)
+	b = appendUint(b, 3, status.HistoryLength)
+	b = appendString(b, 4, status.DigestRoot)
+	b = appendUintMap(b, 5, status.Clock)
+	b = appendUint(b, 6, status.HeldBack)
+	b = appendBool(b, 7, status.ReadOnly)
+	b = appendBool(b, 8, status.Paused)
+	b = appendBool(b, 9, status.Draining)
+	b = appendString(b, 10, status.Leader)
+	for _, peer := range status.Peers {
+		b = appendMessage(b, 11, peer)
+	}
+	return b
+}
+
+func (status *grpcStatus) unmarshal(data []byte) error {
+	return readFields(data, func(num protowire.Number, typ protowire.Type, v uint64, field []byte) error {
+		var err error
+		switch num {
+		case 1:
+			status.Node = string(field)
+		case 2:
+			status.QueueLength = v
+		case 3:
+			status.HistoryLength = v
+		case 4:
+			status.DigestRoot = string(field)
+		case 5:
+			status.Clock, err = readUintMapEntry(status.Clock, field)
+		case 6:
+			status.HeldBack = v
+		case 7:
+			status.ReadOnly = v != 0
+		case 8:
+			status.Paused = v != 0
+		case 9:
+			status.Draining = v != 0
+		case 10:
+			status.Leader = string(field)
+		case 11:
+			peer := &grpcPeer{}
+			status.Peers = append(status.Peers, peer)
+			err = peer.unmarshal(field)
+		}
+		return err
+	})
+}
+
+type grpcListPendingRequest struct {
+	Channel string
+	Offset  uint64
+	Limit   uint64
+}
+
+func (req *grpcListPendingRequest) marshal() []byte {
+	return appendUint(appendUint(appendString(nil, 1, req.Channel), 2, req.Offset), 3, req.Limit)
+}
+
+func (req *grpcListPendingRequest) unmarshal(data []byte) error {
+	return readFields(data, func(num protowire.Number, typ protowire.Type, v uint64, field []byte) error {
+		switch num {
+		case 1:
+			req.Channel = string(field)
+		case 2:
+			req.Offset = v
+		case 3:
+			req.Limit = v
+		}
+		return nil
+	})
+}
+
+type grpcPendingMessage struct {
+	ID             uint64
+	Origin         string
+	Type           string
+	Key            string
+	Channel        string
+	Headers        map[string]string
+	Payload        []byte
+	Timestamp      time.Time
+	IdempotencyKey string
+}
+
+func (msg *grpcPendingMessage) marshal() []byte {
+	b := appendUint(nil, 1, msg.ID)
+	b = appendString(b, 2, msg.Origin)
+	b = appendString(b, 3, msg.Type)
+	b = appendString(b, 4, msg.Key)
+	b = appendString(b, 5, msg.Channel)
+	b = appendStringMap(b, 6, msg.Headers)
+	b = appendBytes(b, 7, msg.Payload)
+	b = appendTimestamp(b, 8, msg.Timestamp)
+	return appendString(b, 9, msg.IdempotencyKey)
+}
+
+func (msg *grpcPendingMessage) unmarshal(data []byte) error {
+	return readFields(data, func(num protowire.Number, typ protowire.Type, v uint64, field []byte) error {
+		var err error
+		switch num {
+		case 1:
+			msg.ID = v
+		case 2:
+			msg.Origin = string(field)
+		case 3:
+			msg.Type = string(field)
+		case 4:
+			msg.Key = string(field)
+		case 5:
+			msg.Channel = string(field)
+		case 6:
+			msg.Headers, err = readStringMapEntry(msg.Headers, field)
+		case 7:
+			msg.Payload = append([]byte{}, field...)
+		case 8:
+			msg.Timestamp, err = readTimestamp(field)
+		case 9:
+			msg.IdempotencyKey = string(field)
+		}
+		return err
+	})
+}
+
+type grpcListPendingResponse struct {
+	Messages []*grpcPendingMessage
+	Total    uint64
+}
+
+func (resp *grpcListPendingResponse) marshal() []byte {
+	var b []byte
+	for _, msg := range resp.Messages {
+		b = appendMessage(b, 1, msg)
+	}
+	return appendUint(b, 2, resp.Total)
+}
+
+func (resp *grpcListPendingResponse) unmarshal(data []byte) error {
+	return readFields(data, func(num protowire.Number, typ protowire.Type, v uint64, field []byte) error {
+		switch num {
+		case 1:
+			msg := &grpcPendingMessage{}
+			resp.Messages = append(resp.Messages, msg)
+			return msg.unmarshal(field)
+		case 2:
+			resp.Total = v
+		}
+		return nil
+	})
+}
+
+type grpcGetStateRequest struct {
+	Component string
+}
+
+func (req *grpcGetStateRequest) marshal() []byte {
+	return appendString(nil, 1, req.Component)
+}
+
+func (req *grpcGetStateRequest) unmarshal(data []byte) error {
+	return readFields(data, func(num protowire.Number, typ protowire.Type, v uint64, field []byte) error {
+		if num == 1 {
+			req.Component = string(field)
+		}
+		return nil
+	})
+}
+
+type grpcState struct {
+	Current       uint64
+	DigestRoot    string
+	Clock         map[string]uint64
+	HistoryLength uint64
+	ComponentData []byte
+}
+
+func (state *grpcState) marshal() []byte {
+	b := appendUint(nil, 1, state.Current)
+	b = appendString(b, 2, state.DigestRoot)
+	b = appendUintMap(b, 3, state.Clock)
+	b = appendUint(b, 4, state.HistoryLength)
+	return appendBytes(b, 5, state.ComponentData)
+}
+
+func (state *grpcState) unmarshal(data []byte) error {
+	return readFields(data, func(num protowire.Number, typ protowire.Type, v uint64, field []byte) error {
+		var err error
+		switch num {
+		case 1:
+			state.Current = v
+		case 2:
+			state.DigestRoot = string(field)
+		case 3:
+			state.Clock, err = readUintMapEntry(state.Clock, field)
+		case 4:
+			state.HistoryLength = v
+		case 5:
+			state.ComponentData = append([]byte{}, field...)
+		}
+		return err
+	})
+}
+
+// Fields are left out when they hold their zero value, as proto3 does
+
+func appendString(b []byte, num protowire.Number, value string) []byte {
+	if value == "" {
+		return b
+	}
+	return protowire.AppendString(protowire.AppendTag(b, num, protowire.BytesType), value)
+}
+
+func appendBytes(b []byte, num protowire.Number, value []byte) []byte {
+	if len(value) == 0 {
+		return b
+	}
+	return protowire.AppendBytes(protowire.AppendTag(b, num, protowire.BytesType), value)
+}
+
+func appendUint(b []byte, num protowire.Number, value uint64) []byte {
+	if value == 0 {
+		return b
+	}
+	return protowire.AppendVarint(protowire.AppendTag(b, num, protowire.VarintType), value)
+}
+
+func appendBool(b []byte, num protowire.Number, value bool) []byte {
+	if !value {
+		return b
+	}
+	return appendUint(b, num, 1)
+}
+
+// appendMessage appends an embedded message, which unlike other fields is there even when it's empty
+func appendMessage(b []byte, num protowire.Number, msg grpcMessage) []byte {
+	return protowire.AppendBytes(protowire.AppendTag(b, num, protowire.BytesType), msg.marshal())
+}
+
+// appendTimestamp appends a google.protobuf.Timestamp
+func appendTimestamp(b []byte, num protowire.Number, t time.Time) []byte {
+	if t.IsZero() {
+		return b
+	}
+	timestamp := appendUint(nil, 1, uint64(t.Unix()))
+	timestamp = appendUint(timestamp, 2, uint64(t.Nanosecond()))
+	return protowire.AppendBytes(protowire.AppendTag(b, num, protowire.BytesType), timestamp)
+}
+
+// appendStringMap appends a map<string, string>, as one entry message per key. They're sorted, so that the
+// same map is always encoded the same way
+func appendStringMap(b []byte, num protowire.Number, m map[string]string) []byte {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		entry := appendString(appendString(nil, 1, key), 2, m[key])
+		b = protowire.AppendBytes(protowire.AppendTag(b, num, protowire.BytesType), entry)
+	}
+	return b
+}
+
+// appendUintMap appends a map<string, uint64>, like appendStringMap
+func appendUintMap(b []byte, num protowire.Number, m map[string]uint64) []byte {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		entry := appendUint(appendString(nil, 1, key), 2, m[key])
+		b = protowire.AppendBytes(protowire.AppendTag(b, num, protowire.BytesType), entry)
+	}
+	return b
+}
+
+// readFields calls fn with each field in an encoded message: its number and type, along with its value if
+// it's a varint or its contents if it's length delimited. Fields of any other type are skipped, as they
+// aren't used by any of our messages
+func readFields(data []byte, fn func(num protowire.Number, typ protowire.Type, v uint64, field []byte) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		var v uint64
+		var field []byte
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(data)
+		case protowire.BytesType:
+			field, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		if typ == protowire.VarintType || typ == protowire.BytesType {
+			err := fn(num, typ, v, field)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readUints reads a repeated uint64 field, which may be packed or not
+func readUints(values []uint64, typ protowire.Type, v uint64, field []byte) ([]uint64, error) {
+	if typ == protowire.VarintType {
+		return append(values, v), nil
+	}
+	for len(field) > 0 {
+		value, n := protowire.ConsumeVarint(field)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		values = append(values, value)
+		field = field[n:]
+	}
+	return values, nil
+}
+
+// readTimestamp reads a google.protobuf.Timestamp
+func readTimestamp(field []byte) (time.Time, error) {
+	var seconds, nanos uint64
+	err := readFields(field, func(num protowire.Number, typ protowire.Type, v uint64, _ []byte) error {
+		switch num {
+		case 1:
+			seconds = v
+		case 2:
+			nanos = v
+		}
+		return nil
+	})
+	return time.Unix(int64(seconds), int64(int32(nanos))).UTC(), err
+}
+
+// readStringMapEntry reads an entry of a map<string, string> into m, creating it if it's nil
+func readStringMapEntry(m map[string]string, field []byte) (map[string]string, error) {
+	var key, value string
+	err := readFields(field, func(num protowire.Number, typ protowire.Type, v uint64, data []byte) error {
+		switch num {
+		case 1:
+			key = string(data)
+		case 2:
+			value = string(data)
+		}
+		return nil
+	})
+	if m == nil {
+		m = map[string]string{}
+	}
+	m[key] = value
+	return m, err
+}
+
+// readUintMapEntry reads an entry of a map<string, uint64> into m, creating it if it's nil
+func readUintMapEntry(m map[string]uint64, field []byte) (map[string]uint64, error) {
+	var key string
+	var value uint64
+	err := readFields(field, func(num protowire.Number, typ protowire.Type, v uint64, data []byte) error {
+		switch num {
+		case 1:
+			key = string(data)
+		case 2:
+			value = v
+		}
+		return nil
+	})
+	if m == nil {
+		m = map[string]uint64{}
+	}
+	m[key] = value
+	return m, err
+}
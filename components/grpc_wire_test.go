@@ -0,0 +1,237 @@
+package components
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcProto describes the messages of grpc.proto, built by hand so that we can check grpc_wire.go against
+// the real protobuf encoding without needing protoc. Anything changed in one has to be changed here too
+func grpcProto(t *testing.T) protoreflect.FileDescriptor {
+	field := func(name string, num int32, typ descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String(name),
+			Number: proto.Int32(num),
+			Type:   typ.Enum(),
+			Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		}
+	}
+	repeated := func(name string, num int32, typ descriptorpb.FieldDescriptorProto_Type, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := field(name, num, typ)
+		f.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+		return f
+	}
+	timestamp := func(name string, num int32) *descriptorpb.FieldDescriptorProto {
+		f := field(name, num, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE)
+		f.TypeName = proto.String(".google.protobuf.Timestamp")
+		return f
+	}
+	mapEntry := func(name string, value descriptorpb.FieldDescriptorProto_Type) *descriptorpb.DescriptorProto {
+		return &descriptorpb.DescriptorProto{
+			Name:    proto.String(name),
+			Field:   []*descriptorpb.FieldDescriptorProto{field("key", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING), field("value", 2, value)},
+			Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+		}
+	}
+	message := func(name string, fields ...*descriptorpb.FieldDescriptorProto) *descriptorpb.DescriptorProto {
+		return &descriptorpb.DescriptorProto{Name: proto.String(name), Field: fields}
+	}
+	const (
+		str     = descriptorpb.FieldDescriptorProto_TYPE_STRING
+		bytes   = descriptorpb.FieldDescriptorProto_TYPE_BYTES
+		uint64  = descriptorpb.FieldDescriptorProto_TYPE_UINT64
+		uint32  = descriptorpb.FieldDescriptorProto_TYPE_UINT32
+		boolean = descriptorpb.FieldDescriptorProto_TYPE_BOOL
+		msg     = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+	)
+
+	newMessage := message("NewMessage",
+		field("type", 1, str), field("key", 2, str), field("channel", 3, str),
+		repeated("headers", 4, msg, ".accord.v1.NewMessage.HeadersEntry"),
+		field("idempotency_key", 5, str), field("payload", 6, bytes), timestamp("at", 7))
+	newMessage.NestedType = []*descriptorpb.DescriptorProto{mapEntry("HeadersEntry", str)}
+
+	status := message("Status",
+		field("node", 1, str), field("queue_length", 2, uint64), field("history_length", 3, uint64),
+		field("digest_root", 4, str), repeated("clock", 5, msg, ".accord.v1.Status.ClockEntry"),
+		field("held_back", 6, uint64), field("read_only", 7, boolean), field("paused", 8, boolean),
+		field("draining", 9, boolean), field("leader", 10, str), repeated("peers", 11, msg, ".accord.v1.Peer"))
+	status.NestedType = []*descriptorpb.DescriptorProto{mapEntry("ClockEntry", uint64)}
+
+	pending := message("Message",
+		field("id", 1, uint64), field("origin", 2, str), field("type", 3, str), field("key", 4, str),
+		field("channel", 5, str), repeated("headers", 6, msg, ".accord.v1.Message.HeadersEntry"),
+		field("payload", 7, bytes), timestamp("timestamp", 8), field("idempotency_key", 9, str))
+	pending.NestedType = []*descriptorpb.DescriptorProto{mapEntry("HeadersEntry", str)}
+
+	state := message("State",
+		field("current", 1, uint64), field("digest_root", 2, str),
+		repeated("clock", 3, msg, ".accord.v1.State.ClockEntry"), field("history_length", 4, uint64),
+		field("component_data", 5, bytes))
+	state.NestedType = []*descriptorpb.DescriptorProto{mapEntry("ClockEntry", uint64)}
+
+	file, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:       proto.String("grpc.proto"),
+		Package:    proto.String("accord.v1"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"google/protobuf/timestamp.proto"},
+		MessageType: []*descriptorpb.DescriptorProto{
+			newMessage,
+			message("SubmitRequest", repeated("messages", 1, msg, ".accord.v1.NewMessage")),
+			message("SubmitResponse", repeated("ids", 1, uint64, ""), field("duplicate", 2, boolean)),
+			message("GetStatusRequest"),
+			message("Peer", field("name", 1, str), field("pending", 2, uint64)),
+			status,
+			message("ListPendingRequest", field("channel", 1, str), field("offset", 2, uint64), field("limit", 3, uint32)),
+			pending,
+			message("ListPendingResponse", repeated("messages", 1, msg, ".accord.v1.Message"), field("total", 2, uint64)),
+			message("GetStateRequest", field("component", 1, str)),
+			state,
+		},
+	}, protoregistry.GlobalFiles)
+	assert.Nil(t, err)
+	return file
+}
+
+// protoMessage builds one of grpc.proto's messages out of the values of its fields
+func protoMessage(file protoreflect.FileDescriptor, name string, fields map[string]interface{}) *dynamicpb.Message {
+	desc := file.Messages().ByName(protoreflect.Name(name))
+	msg := dynamicpb.NewMessage(desc)
+	for fieldName, value := range fields {
+		fd := desc.Fields().ByName(protoreflect.Name(fieldName))
+		switch v := value.(type) {
+		case map[string]string:
+			m := msg.Mutable(fd).Map()
+			for key, val := range v {
+				m.Set(protoreflect.ValueOfString(key).MapKey(), protoreflect.ValueOfString(val))
+			}
+		case map[string]uint64:
+			m := msg.Mutable(fd).Map()
+			for key, val := range v {
+				m.Set(protoreflect.ValueOfString(key).MapKey(), protoreflect.ValueOfUint64(val))
+			}
+		case []uint64:
+			list := msg.Mutable(fd).List()
+			for _, val := range v {
+				list.Append(protoreflect.ValueOfUint64(val))
+			}
+		case []*dynamicpb.Message:
+			list := msg.Mutable(fd).List()
+			for _, val := range v {
+				list.Append(protoreflect.ValueOfMessage(val))
+			}
+		case time.Time:
+			msg.Set(fd, protoreflect.ValueOfMessage(timestamppb.New(v).ProtoReflect()))
+		default:
+			msg.Set(fd, protoreflect.ValueOf(v))
+		}
+	}
+	return msg
+}
+
+func TestGRPCProtoEncoding(t *testing.T) {
+	file := grpcProto(t)
+	at := time.Unix(1700000000, 123456789).UTC()
+
+	newMessage := func() (*grpcNewMessage, *dynamicpb.Message) {
+		return &grpcNewMessage{
+			Type: "user.update", Key: "user-1", Channel: "users", Headers: map[string]string{"a": "1", "b": "2"},
+			IdempotencyKey: "once", Payload: []byte("payload"), At: at,
+		}, protoMessage(file, "NewMessage", map[string]interface{}{
+			"type": "user.update", "key": "user-1", "channel": "users", "headers": map[string]string{"a": "1", "b": "2"},
+			"idempotency_key": "once", "payload": []byte("payload"), "at": at,
+		})
+	}
+	pending := func(id uint64) (*grpcPendingMessage, *dynamicpb.Message) {
+		return &grpcPendingMessage{
+			ID: id, Origin: "node-a", Type: "user.update", Key: "user-1", Channel: "users",
+			Headers: map[string]string{"a": "1"}, Payload: []byte("payload"), Timestamp: at, IdempotencyKey: "once",
+		}, protoMessage(file, "Message", map[string]interface{}{
+			"id": id, "origin": "node-a", "type": "user.update", "key": "user-1", "channel": "users",
+			"headers": map[string]string{"a": "1"}, "payload": []byte("payload"), "timestamp": at, "idempotency_key": "once",
+		})
+	}
+	first, firstProto := newMessage()
+	second, secondProto := newMessage()
+	second.At, second.Headers = time.Time{}, nil
+	secondProto.Clear(secondProto.Descriptor().Fields().ByName("at"))
+	secondProto.Clear(secondProto.Descriptor().Fields().ByName("headers"))
+	pendingA, pendingAProto := pending(1)
+	pendingB, pendingBProto := pending(1 << 40)
+
+	for _, test := range []struct {
+		ours    grpcMessage
+		decoded grpcMessage
+		proto   *dynamicpb.Message
+	}{
+		{first, &grpcNewMessage{}, firstProto},
+		{
+			&grpcSubmitRequest{Messages: []*grpcNewMessage{first, second}}, &grpcSubmitRequest{},
+			protoMessage(file, "SubmitRequest", map[string]interface{}{"messages": []*dynamicpb.Message{firstProto, secondProto}}),
+		},
+		{
+			&grpcSubmitResponse{IDs: []uint64{1, 300, 1 << 63}, Duplicate: true}, &grpcSubmitResponse{},
+			protoMessage(file, "SubmitResponse", map[string]interface{}{"ids": []uint64{1, 300, 1 << 63}, "duplicate": true}),
+		},
+		{&grpcEmpty{}, &grpcEmpty{}, protoMessage(file, "GetStatusRequest", nil)},
+		{
+			&grpcStatus{
+				Node: "node-a", QueueLength: 3, HistoryLength: 7, DigestRoot: "abc", Clock: map[string]uint64{"node-a": 7, "node-b": 2},
+				HeldBack: 1, ReadOnly: true, Paused: true, Draining: true, Leader: "node-b",
+				Peers: []*grpcPeer{{Name: "node-b", Pending: 2}, {Name: "node-c"}},
+			}, &grpcStatus{},
+			protoMessage(file, "Status", map[string]interface{}{
+				"node": "node-a", "queue_length": uint64(3), "history_length": uint64(7), "digest_root": "abc",
+				"clock": map[string]uint64{"node-a": 7, "node-b": 2}, "held_back": uint64(1), "read_only": true,
+				"paused": true, "draining": true, "leader": "node-b", "peers": []*dynamicpb.Message{
+					protoMessage(file, "Peer", map[string]interface{}{"name": "node-b", "pending": uint64(2)}),
+					protoMessage(file, "Peer", map[string]interface{}{"name": "node-c"}),
+				},
+			}),
+		},
+		{
+			&grpcListPendingRequest{Channel: "users", Offset: 10, Limit: 50}, &grpcListPendingRequest{},
+			protoMessage(file, "ListPendingRequest", map[string]interface{}{"channel": "users", "offset": uint64(10), "limit": uint32(50)}),
+		},
+		{pendingA, &grpcPendingMessage{}, pendingAProto},
+		{
+			&grpcListPendingResponse{Messages: []*grpcPendingMessage{pendingA, pendingB}, Total: 12}, &grpcListPendingResponse{},
+			protoMessage(file, "ListPendingResponse", map[string]interface{}{
+				"messages": []*dynamicpb.Message{pendingAProto, pendingBProto}, "total": uint64(12),
+			}),
+		},
+		{&grpcGetStateRequest{Component: "cron"}, &grpcGetStateRequest{}, protoMessage(file, "GetStateRequest", map[string]interface{}{"component": "cron"})},
+		{
+			&grpcState{Current: 42, DigestRoot: "abc", Clock: map[string]uint64{"node-a": 7}, HistoryLength: 7, ComponentData: []byte("data")}, &grpcState{},
+			protoMessage(file, "State", map[string]interface{}{
+				"current": uint64(42), "digest_root": "abc", "clock": map[string]uint64{"node-a": 7},
+				"history_length": uint64(7), "component_data": []byte("data"),
+			}),
+		},
+	} {
+		name := test.proto.Descriptor().Name()
+
+		// What we encode is read by the real encoding as the message we meant, with nothing left over
+		actual := dynamicpb.NewMessage(test.proto.Descriptor())
+		assert.Nil(t, proto.Unmarshal(test.ours.marshal(), actual), name)
+		assert.True(t, proto.Equal(test.proto, actual), "%s: %v", name, actual)
+
+		// And what it encodes, we read back as the message it was
+		data, err := proto.Marshal(test.proto)
+		assert.Nil(t, err, name)
+		assert.Nil(t, test.decoded.unmarshal(data), name)
+		assert.Equal(t, test.ours, test.decoded, name)
+	}
+}
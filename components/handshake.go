@@ -0,0 +1,158 @@
+package components
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Ssawa/accord/accord"
+)
+
+// protocolHeader carries the protocol version requests to our sync endpoints were sent under (see
+// accord.Agreement). Requests without it come from nodes that predate handshakes
+const protocolHeader = "Accord-Protocol"
+
+// compressionHeader is how a Poller asks for the batches it's sent to be compressed
+const compressionHeader = "Accord-Compression"
+
+// syncCodecs are the codecs our sync endpoints read. Messages only go over the wire gob encoded for now
+var syncCodecs = []string{"gob"}
+
+// syncCompression returns the compression our sync endpoints read, with gzip first if we'd rather compress
+// what we send
+func syncCompression(compress bool) []string {
+	if compress {
+		return []string{"gzip", "identity"}
+	}
+	return []string{"identity", "gzip"}
+}
+
+// legacyAgreement is what we talk to a peer that predates handshakes with, which is what we all spoke
+// before there were any
+func legacyAgreement(peer string) accord.Agreement {
+	return accord.Agreement{Peer: peer, ProtocolVersion: 1, Codec: "gob", Compression: "identity"}
+}
+
+// handshake introduces us to the peer at the given base URL and settles on how to talk to it. name is who
+// we expect the peer to be, if we know
+func handshake(acc *accord.Accord, client *http.Client, base string, name string, compress bool) (accord.Agreement, error) {
+	ours := acc.Hello(syncCodecs, syncCompression(compress))
+	body, err := json.Marshal(ours)
+	if err != nil {
+		return accord.Agreement{}, err
+	}
+
+	resp, err := peerClient(acc, client, base).Post(strings.TrimRight(base, "/")+"/sync/hello", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return accord.Agreement{}, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		// Nodes from before handshakes don't know what we're talking about, but we can still talk to them
+		return legacyAgreement(name), nil
+	case http.StatusConflict:
+		reason, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return accord.Agreement{}, &accord.IncompatibleError{Peer: name, Reason: "it refused our handshake: " + strings.TrimSpace(string(reason))}
+	default:
+		return accord.Agreement{}, fmt.Errorf("peer responded with %s", resp.Status)
+	}
+
+	theirs := accord.Hello{}
+	err = json.NewDecoder(resp.Body).Decode(&theirs)
+	if err != nil {
+		acc.ReportViolation(peerAddress(base), accord.ViolationMalformed, "unreadable handshake")
+		return accord.Agreement{}, err
+	}
+	if name != "" && theirs.NodeID != name {
+		return accord.Agreement{}, &accord.IncompatibleError{Peer: name, Reason: fmt.Sprintf("it says it's %s", theirs.NodeID)}
+	}
+	return accord.Negotiate(ours, theirs)
+}
+
+// serveHello answers a peer's handshake with our own Hello, or with a 409 saying why we can't talk to it
+func serveHello(acc *accord.Accord, log accord.Logger, compress bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		theirs := accord.Hello{}
+		err := json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(&theirs)
+		if err != nil {
+			acc.ReportViolation(r.RemoteAddr, accord.ViolationMalformed, "unreadable handshake")
+			http.Error(w, "unreadable handshake", http.StatusBadRequest)
+			return
+		}
+		if impersonating(r, theirs.NodeID) {
+			http.Error(w, "not who you say you are", http.StatusForbidden)
+			return
+		}
+
+		ours := acc.Hello(syncCodecs, syncCompression(compress))
+		_, err = accord.Negotiate(ours, theirs)
+		if err != nil {
+			incompatible(acc, log, theirs.NodeID, err)
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		writeJSON(w, http.StatusOK, ours)
+	}
+}
+
+// incompatible reports that we can't talk to a peer
+func incompatible(acc *accord.Accord, log accord.Logger, peer string, err error) {
+	log.WithField("peer", peer).WithError(err).Error("Peer is incompatible with us, so we won't synchronize with it")
+	acc.Emit(accord.EventPeerIncompatible, err.Error(), map[string]interface{}{"peer": peer})
+}
+
+// checkProtocol refuses a request sent under a protocol version we don't speak with a 409, which our
+// transports take as a sign to shake hands again rather than as a batch to give up on. It reports whether
+// the request can go ahead
+func checkProtocol(w http.ResponseWriter, r *http.Request) bool {
+	header := r.Header.Get(protocolHeader)
+	if header == "" {
+		return true
+	}
+	version, err := strconv.Atoi(header)
+	if err != nil || !accord.SpeaksProtocol(version) {
+		http.Error(w, fmt.Sprintf("protocol version %s isn't one we speak (%d to %d)", header, accord.MinProtocolVersion, accord.ProtocolVersion), http.StatusConflict)
+		return false
+	}
+	return true
+}
+
+// encodeBody compresses a request or response body the way we agreed with a peer, returning the
+// Content-Encoding it should be sent with
+func encodeBody(agreement accord.Agreement, body []byte) ([]byte, string, error) {
+	if agreement.Compression != "gzip" {
+		return body, "", nil
+	}
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	_, err := writer.Write(body)
+	if err == nil {
+		err = writer.Close()
+	}
+	return buf.Bytes(), "gzip", err
+}
+
+// decodeBody undoes whatever Content-Encoding a body was sent with
+func decodeBody(encoding string, body io.Reader) (io.Reader, error) {
+	switch encoding {
+	case "", "identity":
+		return body, nil
+	case "gzip":
+		return gzip.NewReader(body)
+	}
+	return nil, fmt.Errorf("unknown content encoding %q", encoding)
+}
@@ -0,0 +1,161 @@
+package components
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Ssawa/accord/accord"
+	"github.com/Ssawa/accord/accord/accordtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPSyncCompress(t *testing.T) {
+	hub := newSyncNode(t, "hub")
+	edge := newSyncNode(t, "edge")
+	hub.start(t)
+	defer hub.stop()
+
+	// Keep track of how the batches the hub is sent are encoded
+	var mutex sync.Mutex
+	encodings := []string{}
+	handler := hub.late.handler
+	hub.late.handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/sync/messages" {
+			mutex.Lock()
+			encodings = append(encodings, r.Header.Get("Content-Encoding")+" "+r.Header.Get(protocolHeader))
+			mutex.Unlock()
+		}
+		handler.ServeHTTP(w, r)
+	})
+
+	assert.Nil(t, edge.accord.Start())
+	edge.sync = &HTTPSync{Peers: []HTTPPeer{{Name: "hub", URL: hub.server.URL}}, Compress: true}
+	assert.Nil(t, edge.sync.Start(edge.accord))
+	edge.late.handler = edge.sync.Handler()
+	defer edge.stop()
+
+	msg, err := accord.NewMessage([]byte("squeezed"))
+	assert.Nil(t, err)
+	assert.Nil(t, edge.accord.HandleNewMessage(msg))
+	accordtest.Eventually(t, 500*time.Millisecond, func() bool { return hub.accord.History().Len() == 1 })
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Equal(t, []string{"gzip 1"}, encodings)
+}
+
+func TestHTTPSyncIncompatible(t *testing.T) {
+	hub := newSyncNode(t, "hub")
+	edge := newSyncNode(t, "edge")
+	hub.start(t)
+	defer hub.stop()
+
+	// The edge thinks the hub is somebody else, so it mustn't send it anything
+	events := make(chan accord.Event, 10)
+	edge.accord.Subscribe(func(event accord.Event) {
+		if event.Kind == accord.EventPeerIncompatible {
+			events <- event
+		}
+	})
+	assert.Nil(t, edge.accord.Start())
+	edge.sync = &HTTPSync{Peers: []HTTPPeer{{Name: "other-hub", URL: hub.server.URL}}, RetryInterval: time.Hour}
+	assert.Nil(t, edge.sync.Start(edge.accord))
+	edge.late.handler = edge.sync.Handler()
+	defer edge.stop()
+
+	msg, err := accord.NewMessage([]byte("misdirected"))
+	assert.Nil(t, err)
+	assert.Nil(t, edge.accord.HandleNewMessage(msg))
+
+	select {
+	case event := <-events:
+		assert.Equal(t, "other-hub", event.Fields["peer"])
+		assert.Contains(t, event.Message, "it says it's hub")
+	case <-time.After(2 * time.Second):
+		t.Fatal("no incompatible peer was reported")
+	}
+	assert.Zero(t, hub.accord.History().Len())
+	assert.Equal(t, uint64(1), edge.accord.Peer("other-hub").Pending())
+}
+
+func TestHTTPSyncProtocolVersion(t *testing.T) {
+	node := newSyncNode(t, "node")
+	node.start(t)
+	defer node.stop()
+
+	// Nodes from the future are told they can't be understood, rather than having their batches dead
+	// lettered as unreadable
+	req, err := http.NewRequest(http.MethodPost, node.server.URL+"/sync/messages", nil)
+	assert.Nil(t, err)
+	req.Header.Set(protocolHeader, "99")
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	// And so are nodes that would rather be talked to in ways we can't
+	resp, err = http.Post(node.server.URL+"/sync/hello", "application/json", strings.NewReader(`{"nodeId": "future", "protocolVersion": 9, "minProtocolVersion": 9, "codecs": ["gob"], "compression": ["identity"]}`))
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+}
+
+func TestPollerCompress(t *testing.T) {
+	hub := newSyncNode(t, "hub")
+	edge := newSyncNode(t, "edge")
+
+	assert.Nil(t, hub.accord.Start())
+	hub.sync = &HTTPSync{PollingPeers: []string{"edge"}}
+	assert.Nil(t, hub.sync.Start(hub.accord))
+	hub.late.handler = hub.sync.Handler()
+	defer hub.stop()
+
+	assert.Nil(t, edge.accord.Start())
+	edge.sync = &HTTPSync{}
+	assert.Nil(t, edge.sync.Start(edge.accord))
+	defer edge.stop()
+	poller := &Poller{URL: hub.server.URL, Interval: 5 * time.Millisecond, Compress: true}
+	assert.Nil(t, poller.Start(edge.accord))
+
+	msg, err := accord.NewMessage([]byte("pulled"))
+	assert.Nil(t, err)
+	assert.Nil(t, hub.accord.HandleNewMessage(msg))
+	accordtest.Eventually(t, 500*time.Millisecond, func() bool { return edge.accord.History().Len() == 1 })
+	accordtest.Eventually(t, 500*time.Millisecond, func() bool { return hub.accord.Queue().Len() == 0 })
+
+	poller.Stop(0)
+	poller.WaitForStop()
+	assert.Equal(t, &accord.Agreement{Peer: "hub", ProtocolVersion: accord.ProtocolVersion, Codec: "gob", Compression: "gzip"}, poller.agreement)
+}
+
+func TestHTTPSyncHeartbeats(t *testing.T) {
+	hub := newSyncNode(t, "hub")
+	edge := newSyncNode(t, "edge")
+	hub.accord.PeerTimeout = 50 * time.Millisecond
+	edge.accord.PeerTimeout = 50 * time.Millisecond
+
+	assert.Nil(t, hub.accord.Start())
+	hub.sync = &HTTPSync{Peers: []HTTPPeer{{Name: "edge", URL: edge.server.URL}}, HeartbeatInterval: 5 * time.Millisecond, RetryInterval: 5 * time.Millisecond}
+	assert.Nil(t, hub.sync.Start(hub.accord))
+	hub.late.handler = hub.sync.Handler()
+	defer hub.stop()
+	assert.Nil(t, edge.accord.Start())
+	edge.sync = &HTTPSync{Peers: []HTTPPeer{{Name: "hub", URL: hub.server.URL}}, HeartbeatInterval: 5 * time.Millisecond, RetryInterval: 5 * time.Millisecond}
+	assert.Nil(t, edge.sync.Start(edge.accord))
+	edge.late.handler = edge.sync.Handler()
+
+	// With nothing to synchronize, both still hear from each other
+	seen := func(node *syncNode) bool {
+		liveness := node.accord.PeerLiveness()
+		return len(liveness) == 1 && liveness[0].Healthy && !liveness[0].LastSeen.IsZero()
+	}
+	accordtest.Eventually(t, 500*time.Millisecond, func() bool { return seen(hub) && seen(edge) })
+
+	// Until one of them goes away
+	edge.stop()
+	accordtest.Eventually(t, 500*time.Millisecond, func() bool { return !hub.accord.PeerLiveness()[0].Healthy })
+	assert.Equal(t, accord.HealthDegraded, hub.accord.Health().Status)
+}
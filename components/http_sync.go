@@ -0,0 +1,763 @@
+package components
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Ssawa/accord/accord"
+	"github.com/Ssawa/accord/accord/discovery"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DefaultHeartbeatInterval is how often HTTPSync sends its peers heartbeats when HeartbeatInterval isn't
+// set. It leaves plenty of room within accord.DefaultPeerTimeout
+const DefaultHeartbeatInterval = 5 * time.Second
+
+// HTTPPeer is a peer HTTPSync sends Messages to
+type HTTPPeer struct {
+	// Name is the peer's NodeID, which is also the name of its cursor (see Accord.AddPeer)
+	Name string
+
+	// URL is the base URL of the peer's HTTPSync endpoints (for instance "http://10.0.0.2:7000")
+	URL string
+}
+
+// HTTPSync is a Component that synchronizes with any number of peers over HTTP. Each peer gets its own
+// cursor into our synchronization queue, so a slow or unreachable peer never holds up the others, and the
+// Messages we receive are handed to Accord tagged with the node they originated from.
+//
+// For a hub with a number of edge nodes, run HTTPSync on the hub with every edge node as a peer and turn on
+// Accord's Relay, and on each edge node with just the hub as a peer.
+//
+// We shake hands with each peer before sending it anything (and again whenever we've lost touch with it),
+// agreeing on a protocol version, codec and compression and making sure it's who we think it is. A peer
+// we turn out to be incompatible with is never sent anything, and is left alone for RetryInterval at a time
+// in case it's upgraded.
+//
+// Every peer is sent a heartbeat each HeartbeatInterval, whether or not we have anything to send it, so
+// that both of us know the other is still there (see accord.Heartbeat)
+type HTTPSync struct {
+	accord.ComponentRunner
+
+	// BindAddress is the address our endpoint should be served on. If it's left empty no server is started
+	// and Handler should be mounted on an existing one instead
+	BindAddress string
+
+	// Peers are the peers we send our Messages to
+	Peers []HTTPPeer
+
+	// PollingPeers are the names of the peers that fetch our Messages from us with a Poller instead, as we
+	// can't reach them. Their cursors are kept from the moment we start, so nothing is dropped from our
+	// queue before they first poll. Nobody else may poll us
+	PollingPeers []string
+
+	// Discovery finds more peers as they come along, on top of the ones listed in Peers. Peers are only
+	// ever added; one that disappears is simply retried until it comes back
+	Discovery discovery.Discoverer
+
+	// DiscoveryInterval is how often Discovery is asked for peers, defaulting to DefaultDiscoveryInterval
+	DiscoveryInterval time.Duration
+
+	// BatchSize is the most Messages we send to a peer in one request, defaulting to 100
+	BatchSize int
+
+	// RetryInterval is how long we leave a peer alone after failing to reach it, defaulting to 5 seconds
+	RetryInterval time.Duration
+
+	// HeartbeatInterval is how often we send each peer a heartbeat, defaulting to DefaultHeartbeatInterval
+	HeartbeatInterval time.Duration
+
+	// DeltaSync has us compare digests with a peer before sending it its backlog (when we start, and
+	// whenever we've lost touch with it), so that it's only sent the Messages it doesn't already have. This
+	// saves replaying a long backlog to a peer that has caught up some other way, for instance through
+	// AntiEntropy, another hub or a restored backup. The peer has to be running HTTPSync as well
+	DeltaSync bool
+
+	// Compress gzips the batches we send to peers that can read them, for slow or metered links
+	Compress bool
+
+	// BootstrapFrom is the base URL of a peer's HTTPSync endpoints that a brand new node (one with nothing
+	// in its history yet) bootstraps itself from when we start, by installing a snapshot of the peer (see
+	// Accord.Bootstrap) rather than having every Message the cluster has ever performed replayed to it.
+	// After that we synchronize as usual. The peer has to list us as one of its Peers (or PollingPeers), so
+	// that it's kept everything performed since the snapshot for us. Whatever was still queued for us when
+	// the snapshot was taken is sent to us anyway, unless the peer has DeltaSync turned on
+	BootstrapFrom string
+
+	// Client is used to talk to our peers, defaulting to a client with a 10 second timeout
+	Client *http.Client
+
+	accord     *accord.Accord
+	log        accord.Logger
+	handler    http.Handler
+	server     *backgroundServer
+	cursors    []*accord.PeerCursor
+	retryAfter map[string]time.Time
+	discovery  *peerDiscovery
+
+	// compared holds the peers we've compared digests with since we last lost touch with them (see
+	// DeltaSync)
+	compared map[string]bool
+
+	// agreements holds what we settled on with the peers we've shaken hands with since we last lost touch
+	// with them
+	agreements map[string]accord.Agreement
+
+	// beaten holds when we last sent each peer a heartbeat (or shook hands with it)
+	beaten map[string]time.Time
+
+	// newPeers holds the peers passed to SetPeers until our loop picks them up
+	peersMutex sync.Mutex
+	newPeers   []HTTPPeer
+}
+
+// Start registers our peers, sets up our endpoint and begins our background loop
+func (comp *HTTPSync) Start(acc *accord.Accord) error {
+	comp.accord = acc
+	comp.log = acc.Logger.WithField("component", "HTTPSync")
+	comp.retryAfter = map[string]time.Time{}
+	comp.compared = map[string]bool{}
+	comp.agreements = map[string]accord.Agreement{}
+	comp.beaten = map[string]time.Time{}
+	comp.discovery = newPeerDiscovery(comp.Discovery, comp.DiscoveryInterval, acc.NodeID, comp.log)
+
+	if comp.BootstrapFrom != "" && acc.History().Len() == 0 {
+		err := comp.bootstrap()
+		if err != nil {
+			return fmt.Errorf("Unable to bootstrap from %s: %s", comp.BootstrapFrom, err)
+		}
+	}
+
+	comp.cursors = []*accord.PeerCursor{}
+	for _, peer := range comp.Peers {
+		comp.cursors = append(comp.cursors, acc.AddPeer(peer.Name))
+	}
+	for _, name := range comp.PollingPeers {
+		acc.AddPeer(name)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sync/hello", serveHello(acc, comp.log, comp.Compress))
+	mux.HandleFunc("/sync/heartbeat", comp.serveHeartbeat)
+	mux.HandleFunc("/sync/messages", comp.receive)
+	mux.HandleFunc("/sync/poll", comp.poll)
+	mux.HandleFunc("/sync/digest", comp.serveDigest)
+	mux.HandleFunc("/sync/have", comp.serveHave)
+	mux.HandleFunc("/sync/snapshot", comp.serveSnapshot)
+	comp.handler = guardPeers(acc, mux)
+
+	if comp.BindAddress != "" {
+		comp.server = startServer(comp.BindAddress, comp.handler, comp.log)
+	}
+
+	comp.ComponentRunner.Init(acc, comp.tick, comp.cleanup, comp.log)
+	return nil
+}
+
+// Handler returns the endpoint our peers send their Messages to, for mounting on an existing HTTP server.
+// It's only available after Start
+func (comp *HTTPSync) Handler() http.Handler {
+	return comp.handler
+}
+
+func (comp *HTTPSync) tick(*accord.Accord) {
+	comp.updatePeers()
+	comp.discoverPeers()
+
+	sent := 0
+	for i, peer := range comp.Peers {
+		if time.Now().Before(comp.retryAfter[peer.Name]) {
+			continue
+		}
+
+		if _, ok := comp.agreements[peer.Name]; !ok {
+			agreement, err := handshake(comp.accord, comp.client(), peer.URL, peer.Name, comp.Compress)
+			if err != nil {
+				comp.retry(peer)
+				if _, ok := err.(*accord.IncompatibleError); ok {
+					incompatible(comp.accord, accord.WithPeer(comp.log, comp.cursors[i]), peer.Name, err)
+				} else {
+					accord.WithPeer(comp.log, comp.cursors[i]).WithError(err).Warn("Unable to shake hands with peer")
+				}
+				continue
+			}
+			comp.agreements[peer.Name] = agreement
+			comp.beat(peer)
+		}
+
+		if comp.DeltaSync && !comp.compared[peer.Name] {
+			err := comp.skipKnown(peer, comp.cursors[i])
+			if err != nil {
+				comp.retry(peer)
+				accord.WithPeer(comp.log, comp.cursors[i]).WithError(err).Warn("Unable to compare digests with peer")
+				continue
+			}
+			comp.compared[peer.Name] = true
+		}
+
+		// Each channel (and each shard of it) gets a batch of its own, so a backlog on one doesn't hold up
+		// the others
+		for _, cursor := range comp.channelCursors(comp.cursors[i]) {
+			count, err := comp.sendBatch(peer, cursor)
+			if err != nil {
+				comp.retry(peer)
+				accord.WithPeer(comp.log, cursor).WithError(err).Warn("Unable to synchronize with peer")
+				break
+			}
+			if count > 0 {
+				comp.accord.Heartbeat(peer.Name)
+			}
+			sent += count
+		}
+
+		if time.Since(comp.beaten[peer.Name]) >= comp.heartbeatInterval() && time.Now().After(comp.retryAfter[peer.Name]) {
+			err := comp.sendHeartbeat(peer)
+			if err != nil {
+				comp.retry(peer)
+				accord.WithPeer(comp.log, comp.cursors[i]).WithError(err).Warn("Unable to send peer a heartbeat")
+				continue
+			}
+			comp.beat(peer)
+		}
+	}
+
+	// Only take a break once everyone is caught up
+	if sent == 0 {
+		time.Sleep(tickResolution)
+	}
+}
+
+// retry leaves a peer alone for a while after we've failed to reach it. It may well catch up (or be
+// upgraded) some other way in the meantime, so we shake hands and compare digests with it again before
+// sending it anything else
+func (comp *HTTPSync) retry(peer HTTPPeer) {
+	retry := comp.RetryInterval
+	if retry <= 0 {
+		retry = 5 * time.Second
+	}
+	comp.retryAfter[peer.Name] = time.Now().Add(retry)
+	delete(comp.compared, peer.Name)
+	delete(comp.agreements, peer.Name)
+}
+
+// beat records that a peer just answered a heartbeat (or a handshake, which is just as good)
+func (comp *HTTPSync) beat(peer HTTPPeer) {
+	comp.beaten[peer.Name] = time.Now()
+	comp.accord.Heartbeat(peer.Name)
+}
+
+func (comp *HTTPSync) heartbeatInterval() time.Duration {
+	if comp.HeartbeatInterval <= 0 {
+		return DefaultHeartbeatInterval
+	}
+	return comp.HeartbeatInterval
+}
+
+// sendHeartbeat lets a peer know we're still here, which is how we know it is too
+func (comp *HTTPSync) sendHeartbeat(peer HTTPPeer) error {
+	if !comp.accord.AllowPeer(peerAddress(peer.URL)) {
+		return fmt.Errorf("peer %s is not allowed", peer.Name)
+	}
+
+	resp, err := peerClient(comp.accord, comp.client(), peer.URL).Post(strings.TrimRight(peer.URL, "/")+"/sync/heartbeat?peer="+url.QueryEscape(comp.accord.NodeID), "text/plain", nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	// Nodes from before heartbeats answered anything we sent them, which is just as good
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("peer responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// serveHeartbeat hears from a peer that's still there
+func (comp *HTTPSync) serveHeartbeat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	peer := r.URL.Query().Get("peer")
+	if comp.accord.Peer(peer) == nil || impersonating(r, peer) {
+		http.Error(w, "not a peer", http.StatusForbidden)
+		return
+	}
+	comp.accord.Heartbeat(peer)
+	w.Write([]byte("ok"))
+}
+
+// channelCursors returns the peer's cursor into every shard of every one of our channels
+func (comp *HTTPSync) channelCursors(cursor *accord.PeerCursor) []*accord.PeerCursor {
+	cursors := []*accord.PeerCursor{}
+	for _, channel := range append([]string{""}, comp.accord.ChannelNames()...) {
+		cursors = append(cursors, cursor.Channel(channel).Shards()...)
+	}
+	return cursors
+}
+
+// SetPeers replaces our Peers while we're running, for instance when the configuration is reloaded (see
+// accord.Reload). New peers are sent everything still waiting in the queue, and peers that are no longer
+// listed are removed from Accord altogether, so whatever only they were waiting on is dropped
+func (comp *HTTPSync) SetPeers(peers []HTTPPeer) {
+	comp.peersMutex.Lock()
+	defer comp.peersMutex.Unlock()
+	comp.newPeers = append([]HTTPPeer{}, peers...)
+}
+
+// updatePeers picks up the peers passed to SetPeers
+func (comp *HTTPSync) updatePeers() {
+	comp.peersMutex.Lock()
+	peers := comp.newPeers
+	comp.newPeers = nil
+	comp.peersMutex.Unlock()
+	if peers == nil {
+		return
+	}
+
+	listed := map[string]bool{}
+	cursors := []*accord.PeerCursor{}
+	for _, peer := range peers {
+		listed[peer.Name] = true
+		cursors = append(cursors, comp.accord.AddPeer(peer.Name))
+		delete(comp.retryAfter, peer.Name)
+	}
+	for _, peer := range comp.Peers {
+		if !listed[peer.Name] {
+			comp.log.WithField("peer", peer.Name).Info("Peer is no longer configured, removing it")
+			comp.accord.RemovePeer(peer.Name)
+		}
+	}
+
+	comp.Peers = peers
+	comp.cursors = cursors
+}
+
+// discoverPeers starts synchronizing with any peers our Discovery has found that we didn't know about
+func (comp *HTTPSync) discoverPeers() {
+	for _, found := range comp.discovery.poll() {
+		known := false
+		for _, peer := range comp.Peers {
+			known = known || peer.Name == found.Name
+		}
+		if known {
+			continue
+		}
+
+		comp.log.WithField("peer", found.Name).WithField("url", found.URL).Info("Discovered peer")
+		comp.Peers = append(comp.Peers, HTTPPeer{Name: found.Name, URL: found.URL})
+		comp.cursors = append(comp.cursors, comp.accord.AddPeer(found.Name))
+	}
+}
+
+func (comp *HTTPSync) cleanup(*accord.Accord) {
+	if comp.server != nil {
+		comp.server.stop()
+	}
+}
+
+// sendBatch sends the next batch of Messages waiting for a peer, returning how many were sent. The batch is
+// shipped through the peer's cursor, which only moves past it once the peer has confirmed it received it;
+// if the peer doesn't, the batch is shipped again next time
+func (comp *HTTPSync) sendBatch(peer HTTPPeer, cursor *accord.PeerCursor) (sent int, err error) {
+	if !comp.accord.AllowPeer(peerAddress(peer.URL)) {
+		return 0, fmt.Errorf("peer %s is not allowed", peer.Name)
+	}
+
+	batchSize := comp.BatchSize
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+
+	pending, err := cursor.Ship(batchSize)
+	if err != nil || len(pending) == 0 {
+		return 0, err
+	}
+
+	msgs := make([]accord.Message, len(pending))
+	ids := make([]uint64, len(pending))
+	spans := make([]trace.Span, len(pending))
+	for i, msg := range pending {
+		msgs[i] = *msg
+		ids[i] = msg.ID
+		spans[i] = comp.accord.TraceMessage(msg, "accord.transport", attribute.String("accord.peer", peer.Name), attribute.String("accord.transport", "http"))
+	}
+	defer func() {
+		if err != nil {
+			cursor.Nack(ids...)
+		}
+		for _, span := range spans {
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+			span.End()
+		}
+	}()
+
+	var buf bytes.Buffer
+	err = gob.NewEncoder(&buf).Encode(msgs)
+	if err != nil {
+		return 0, err
+	}
+	agreement := comp.agreements[peer.Name]
+	body, encoding, err := encodeBody(agreement, buf.Bytes())
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, strings.TrimRight(peer.URL, "/")+"/sync/messages", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set(protocolHeader, strconv.Itoa(agreement.ProtocolVersion))
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+
+	resp, err := peerClient(comp.accord, comp.client(), peer.URL).Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	// A peer that can't make sense of a batch never will, so rather than have it hold up everything after
+	// it we set it aside for an operator to look into
+	if resp.StatusCode == http.StatusBadRequest {
+		return len(msgs), cursor.Reject("peer responded with "+resp.Status, ids...)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("peer responded with %s", resp.Status)
+	}
+
+	err = cursor.Ack(ids...)
+	if err == nil {
+		comp.accord.RecordSent(len(msgs))
+	}
+	return len(msgs), err
+}
+
+// receive takes a batch of Messages sent by a peer and hands them off to Accord. We only respond with a 200
+// once every one of them has been handled, so that the peer knows it can move on
+func (comp *HTTPSync) receive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !checkProtocol(w, r) {
+		return
+	}
+
+	msgs := []accord.Message{}
+	body, err := decodeBody(r.Header.Get("Content-Encoding"), r.Body)
+	if err == nil {
+		err = gob.NewDecoder(body).Decode(&msgs)
+	}
+	if err != nil {
+		comp.accord.ReportViolation(r.RemoteAddr, accord.ViolationMalformed, "unreadable messages")
+		http.Error(w, "unreadable messages", http.StatusBadRequest)
+		return
+	}
+
+	for i := range msgs {
+		err = comp.accord.HandleRemoteMessage(&msgs[i])
+		if err == nil {
+			continue
+		}
+
+		// A Message that's refused by a pipeline is never going to be accepted, so there's no sense in the
+		// peer sending it again
+		if _, rejected := err.(*accord.StageError); rejected {
+			continue
+		}
+
+		accord.WithMessage(comp.log, &msgs[i]).WithError(err).Warn("Unable to handle a remote message")
+		status := http.StatusInternalServerError
+		if err == accord.ErrReadOnly || err == accord.ErrHoldBackFull || err == accord.ErrPaused {
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Write([]byte("ok"))
+}
+
+// poll hands a Poller its next batch of Messages, after acknowledging (or handing back) the ones out of the
+// batch before. Batches are shipped through the peer's cursors just like the ones we send ourselves, so
+// a batch the peer never acknowledges is shipped again once it times out
+func (comp *HTTPSync) poll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !checkProtocol(w, r) {
+		return
+	}
+
+	req := pollRequest{}
+	err := gob.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		comp.accord.ReportViolation(r.RemoteAddr, accord.ViolationMalformed, "unreadable poll")
+		http.Error(w, "unreadable poll", http.StatusBadRequest)
+		return
+	}
+
+	allowed := false
+	for _, name := range comp.PollingPeers {
+		allowed = allowed || name == req.Peer
+	}
+	cursor := comp.accord.Peer(req.Peer)
+	if !allowed || cursor == nil || impersonating(r, req.Peer) {
+		http.Error(w, "not a polling peer", http.StatusForbidden)
+		return
+	}
+
+	max := comp.BatchSize
+	if max <= 0 {
+		max = 100
+	}
+	if req.Max > 0 && req.Max < max {
+		max = req.Max
+	}
+
+	cursors := comp.channelCursors(cursor)
+	for _, cursor := range cursors {
+		err = cursor.Ack(req.Acks...)
+		if err == nil {
+			err = cursor.Nack(req.Nacks...)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if len(req.Acks) > 0 {
+		comp.accord.RecordSent(len(req.Acks))
+	}
+	comp.accord.Heartbeat(req.Peer)
+
+	msgs := []accord.Message{}
+	for _, cursor := range cursors {
+		if len(msgs) >= max {
+			break
+		}
+		shipped, err := cursor.Ship(max - len(msgs))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, msg := range shipped {
+			msgs = append(msgs, *msg)
+		}
+	}
+
+	var buf bytes.Buffer
+	err = gob.NewEncoder(&buf).Encode(msgs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	body, encoding, err := encodeBody(accord.Agreement{Compression: r.Header.Get(compressionHeader)}, buf.Bytes())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if encoding != "" {
+		w.Header().Set("Content-Encoding", encoding)
+	}
+	w.Write(body)
+}
+
+// skipKnown compares digests with a peer, passing over every Message waiting for it that it turns out to
+// have already (see PeerCursor.SkipKnown)
+func (comp *HTTPSync) skipKnown(peer HTTPPeer, cursor *accord.PeerCursor) error {
+	if !comp.accord.AllowPeer(peerAddress(peer.URL)) {
+		return fmt.Errorf("peer %s is not allowed", peer.Name)
+	}
+
+	digest, err := comp.fetchDigest(peer)
+	if err != nil {
+		return err
+	}
+
+	skipped := 0
+	for _, cursor := range comp.channelCursors(cursor) {
+		count, err := cursor.SkipKnown(digest, func(ids []uint64) ([]uint64, error) {
+			return comp.fetchHave(peer, ids)
+		})
+		if err != nil {
+			return err
+		}
+		skipped += count
+	}
+
+	if skipped > 0 {
+		accord.WithPeer(comp.log, cursor).WithField("skipped", skipped).Info("Skipping messages the peer already has")
+	}
+	return nil
+}
+
+func (comp *HTTPSync) client() *http.Client {
+	if comp.Client != nil {
+		return comp.Client
+	}
+	return &http.Client{Timeout: 10 * time.Second}
+}
+
+func (comp *HTTPSync) fetchDigest(peer HTTPPeer) (accord.Digest, error) {
+	digest := accord.Digest{}
+
+	resp, err := peerClient(comp.accord, comp.client(), peer.URL).Get(strings.TrimRight(peer.URL, "/") + "/sync/digest")
+	if err != nil {
+		return digest, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return digest, fmt.Errorf("peer responded with %s", resp.Status)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&digest)
+	if err != nil {
+		comp.accord.ReportViolation(peerAddress(peer.URL), accord.ViolationMalformed, "unreadable digest")
+	}
+	return digest, err
+}
+
+// fetchHave asks a peer which of the given Messages it has
+func (comp *HTTPSync) fetchHave(peer HTTPPeer, ids []uint64) ([]uint64, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := peerClient(comp.accord, comp.client(), peer.URL).Post(strings.TrimRight(peer.URL, "/")+"/sync/have", "application/octet-stream", &buf)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("peer responded with %s", resp.Status)
+	}
+
+	have := []uint64{}
+	err = gob.NewDecoder(resp.Body).Decode(&have)
+	if err != nil {
+		comp.accord.ReportViolation(peerAddress(peer.URL), accord.ViolationMalformed, "unreadable message list")
+	}
+	return have, err
+}
+
+// serveDigest responds with our current digest
+func (comp *HTTPSync) serveDigest(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(comp.accord.Digest())
+}
+
+// serveHave responds with the Messages out of those asked about that are in our history
+func (comp *HTTPSync) serveHave(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ids := []uint64{}
+	err := gob.NewDecoder(r.Body).Decode(&ids)
+	if err != nil {
+		comp.accord.ReportViolation(r.RemoteAddr, accord.ViolationMalformed, "unreadable message list")
+		http.Error(w, "unreadable message list", http.StatusBadRequest)
+		return
+	}
+
+	// Only the buckets the Messages fall into need looking through
+	wanted := map[uint64]bool{}
+	buckets := map[int]bool{}
+	filter := accord.HistoryFilter{}
+	for _, id := range ids {
+		wanted[id] = true
+		if bucket := accord.DigestBucket(id); !buckets[bucket] {
+			buckets[bucket] = true
+			filter.Buckets = append(filter.Buckets, bucket)
+		}
+	}
+
+	have := []uint64{}
+	if len(ids) > 0 {
+		iter := comp.accord.History().Query(filter)
+		for iter.Next() {
+			if wanted[iter.Message().ID] {
+				have = append(have, iter.Message().ID)
+			}
+		}
+		if iter.Err() != nil {
+			http.Error(w, iter.Err().Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	gob.NewEncoder(w).Encode(have)
+}
+
+// bootstrap installs a snapshot of the peer at BootstrapFrom
+func (comp *HTTPSync) bootstrap() error {
+	if !comp.accord.AllowPeer(peerAddress(comp.BootstrapFrom)) {
+		return fmt.Errorf("peer is not allowed")
+	}
+
+	// A snapshot of a long lived node can take a while to download, so it isn't held to our usual timeout
+	client := comp.Client
+	if client == nil {
+		client = &http.Client{}
+	}
+
+	comp.log.WithField("peer", comp.BootstrapFrom).Info("Bootstrapping from peer")
+	resp, err := peerClient(comp.accord, client, comp.BootstrapFrom).Get(strings.TrimRight(comp.BootstrapFrom, "/") + "/sync/snapshot?peer=" + url.QueryEscape(comp.accord.NodeID))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("peer responded with %s", resp.Status)
+	}
+	return comp.accord.Bootstrap(resp.Body)
+}
+
+// serveSnapshot sends a new peer a snapshot to bootstrap itself from. We only hand them out to peers we're
+// keeping a cursor for, as that's what makes sure they're sent everything performed after the snapshot
+func (comp *HTTPSync) serveSnapshot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	peer := r.URL.Query().Get("peer")
+	if comp.accord.Peer(peer) == nil || impersonating(r, peer) {
+		http.Error(w, "not a peer", http.StatusForbidden)
+		return
+	}
+
+	comp.log.WithField("peer", peer).Info("Sending a snapshot to peer")
+	w.Header().Set("Content-Type", "application/octet-stream")
+	err := comp.accord.ExportSnapshot(w)
+	if err != nil {
+		comp.log.WithError(err).WithField("peer", peer).Warn("Unable to send a snapshot")
+	}
+}
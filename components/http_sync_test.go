@@ -0,0 +1,321 @@
+package components
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Ssawa/accord/accord"
+	"github.com/Ssawa/accord/accord/accordtest"
+	"github.com/Ssawa/accord/accord/discovery"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	tickResolution = 5 * time.Millisecond
+	os.Exit(m.Run())
+}
+
+// lateHandler lets us hand out a server's URL before the handler it serves exists
+type lateHandler struct {
+	handler http.Handler
+}
+
+func (late *lateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if late.handler == nil {
+		http.Error(w, "not started", http.StatusServiceUnavailable)
+		return
+	}
+	late.handler.ServeHTTP(w, r)
+}
+
+type syncNode struct {
+	accord *accord.Accord
+	sync   *HTTPSync
+	server *httptest.Server
+	late   *lateHandler
+	dir    string
+}
+
+func newSyncNode(t *testing.T, name string) *syncNode {
+	dir, err := ioutil.TempDir("", "accord-httpsync")
+	assert.Nil(t, err)
+
+	node := &syncNode{dir: dir, late: &lateHandler{}}
+	node.server = httptest.NewServer(node.late)
+	node.accord = accord.NewAccord(accord.NewDummerManager(), nil, dir, accord.DummyAccord().Logger)
+	node.accord.NodeID = name
+	return node
+}
+
+func (node *syncNode) start(t *testing.T, peers ...*syncNode) {
+	assert.Nil(t, node.accord.Start())
+
+	node.sync = &HTTPSync{RetryInterval: 10 * time.Millisecond}
+	for _, peer := range peers {
+		node.sync.Peers = append(node.sync.Peers, HTTPPeer{Name: peer.accord.NodeID, URL: peer.server.URL})
+	}
+	assert.Nil(t, node.sync.Start(node.accord))
+	node.late.handler = node.sync.Handler()
+}
+
+func (node *syncNode) stop() {
+	node.sync.Stop(0)
+	node.sync.WaitForStop()
+	node.server.Close()
+	node.accord.Stop()
+	os.RemoveAll(node.dir)
+}
+
+func TestHTTPSyncHub(t *testing.T) {
+	hub := newSyncNode(t, "hub")
+	edgeA := newSyncNode(t, "edge-a")
+	edgeB := newSyncNode(t, "edge-b")
+
+	hub.accord.Relay = true
+	hub.start(t, edgeA, edgeB)
+	defer hub.stop()
+	edgeA.start(t, hub)
+	defer edgeA.stop()
+	edgeB.start(t, hub)
+	defer edgeB.stop()
+
+	msg, err := accord.NewMessage([]byte("from a"))
+	assert.Nil(t, err)
+	assert.Nil(t, edgeA.accord.HandleNewMessage(msg))
+
+	msg, err = accord.NewMessage([]byte("from hub"))
+	assert.Nil(t, err)
+	assert.Nil(t, hub.accord.HandleNewMessage(msg))
+
+	// Everyone should end up with both messages, with edge-b getting edge-a's by way of the hub
+	for _, node := range []*syncNode{hub, edgeA, edgeB} {
+		current := node
+		accordtest.Eventually(t, 500*time.Millisecond, func() bool { return current.accord.History().Len() == 2 })
+	}
+	assert.True(t, hub.accord.CompareDigest(edgeB.accord.Digest()).Equal)
+
+	// edge-a's message must not have been sent back to it
+	assert.Equal(t, uint64(2), edgeA.accord.History().Len())
+	accordtest.Eventually(t, 500*time.Millisecond, func() bool { return hub.accord.Queue().Len() == 0 })
+}
+
+func TestHTTPSyncChunks(t *testing.T) {
+	hub := newSyncNode(t, "hub")
+	edgeA := newSyncNode(t, "edge-a")
+	edgeB := newSyncNode(t, "edge-b")
+	for _, node := range []*syncNode{hub, edgeA, edgeB} {
+		node.accord.ChunkSize = 128
+	}
+
+	hub.accord.Relay = true
+	hub.start(t, edgeA, edgeB)
+	defer hub.stop()
+	edgeA.start(t, hub)
+	defer edgeA.stop()
+	edgeB.start(t, hub)
+	defer edgeB.stop()
+
+	// A large Message makes it to edge-b in chunks, by way of the hub, and is put back together there
+	payload := bytes.Repeat([]byte("large payload "), 200)
+	msg, err := accord.NewMessage(payload)
+	assert.Nil(t, err)
+	assert.Nil(t, edgeA.accord.HandleNewMessage(msg))
+
+	accordtest.Eventually(t, 500*time.Millisecond, func() bool { return edgeB.accord.History().Len() == 1 })
+	received, err := edgeB.accord.History().Get(0)
+	assert.Nil(t, err)
+	assert.Equal(t, msg.ID, received.ID)
+	assert.Equal(t, payload, received.Payload)
+	assert.True(t, hub.accord.CompareDigest(edgeB.accord.Digest()).Equal)
+	accordtest.Eventually(t, 500*time.Millisecond, func() bool { return hub.accord.Queue().Len() == 0 && edgeA.accord.Queue().Len() == 0 })
+}
+
+func TestHTTPSyncMalformed(t *testing.T) {
+	node := newSyncNode(t, "node")
+	node.start(t)
+	defer node.stop()
+
+	resp, err := http.Post(node.server.URL+"/sync/messages", "application/octet-stream", nil)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 400, resp.StatusCode)
+}
+
+func TestHTTPSyncDiscovery(t *testing.T) {
+	sender := newSyncNode(t, "sender")
+	receiver := newSyncNode(t, "receiver")
+	receiver.start(t)
+	defer receiver.stop()
+
+	// The sender isn't told about anybody up front, and should ignore itself when it's discovered
+	assert.Nil(t, sender.accord.Start())
+	sender.sync = &HTTPSync{
+		RetryInterval: 10 * time.Millisecond,
+		Discovery: discovery.Static{
+			{Name: "sender", URL: sender.server.URL},
+			{Name: "receiver", URL: receiver.server.URL},
+		},
+	}
+	assert.Nil(t, sender.sync.Start(sender.accord))
+	sender.late.handler = sender.sync.Handler()
+	defer sender.stop()
+
+	msg, err := accord.NewMessage([]byte("found you"))
+	assert.Nil(t, err)
+	assert.Nil(t, sender.accord.HandleNewMessage(msg))
+
+	accordtest.Eventually(t, 500*time.Millisecond, func() bool { return receiver.accord.History().Len() == 1 })
+	assert.Equal(t, []string{"receiver"}, sender.accord.Peers())
+}
+
+func TestHTTPSyncSetPeers(t *testing.T) {
+	sender := newSyncNode(t, "sender")
+	first := newSyncNode(t, "first")
+	second := newSyncNode(t, "second")
+	for _, node := range []*syncNode{first, second} {
+		node.start(t)
+		defer node.stop()
+	}
+	sender.start(t, first)
+	defer sender.stop()
+
+	// Swap our only peer for another one, the way a reload would
+	sender.sync.SetPeers([]HTTPPeer{{Name: "second", URL: second.server.URL}})
+	accordtest.Eventually(t, 500*time.Millisecond, func() bool {
+		peers := sender.accord.Peers()
+		return len(peers) == 1 && peers[0] == "second"
+	})
+
+	msg, err := accord.NewMessage([]byte("reloaded"))
+	assert.Nil(t, err)
+	assert.Nil(t, sender.accord.HandleNewMessage(msg))
+	accordtest.Eventually(t, 500*time.Millisecond, func() bool { return second.accord.History().Len() == 1 })
+	assert.Equal(t, uint64(0), first.accord.History().Len())
+}
+
+func TestHTTPSyncDelta(t *testing.T) {
+	hub := newSyncNode(t, "hub")
+	edge := newSyncNode(t, "edge")
+
+	// The hub has already been brought up to date some other way with all but the last of edge's messages
+	hub.start(t)
+	defer hub.stop()
+	assert.Nil(t, edge.accord.Start())
+	edge.accord.AddPeer("hub")
+	ids := []uint64{}
+	for i := 0; i < 5; i++ {
+		msg, err := accord.NewMessage([]byte("from edge"))
+		assert.Nil(t, err)
+		assert.Nil(t, edge.accord.HandleNewMessage(msg))
+		if i < 4 {
+			copied := *msg
+			assert.Nil(t, hub.accord.HandleNewMessage(&copied))
+		}
+		ids = append(ids, msg.ID)
+	}
+
+	// Keep track of what the hub is actually sent
+	received := []uint64{}
+	handler := hub.late.handler
+	hub.late.handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/sync/messages" {
+			body, _ := ioutil.ReadAll(r.Body)
+			msgs := []accord.Message{}
+			gob.NewDecoder(bytes.NewReader(body)).Decode(&msgs)
+			for _, msg := range msgs {
+				received = append(received, msg.ID)
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+		handler.ServeHTTP(w, r)
+	})
+
+	edge.sync = &HTTPSync{RetryInterval: 10 * time.Millisecond, DeltaSync: true, Peers: []HTTPPeer{{Name: "hub", URL: hub.server.URL}}}
+	assert.Nil(t, edge.sync.Start(edge.accord))
+	edge.late.handler = edge.sync.Handler()
+	defer edge.stop()
+
+	accordtest.Eventually(t, 500*time.Millisecond, func() bool { return edge.accord.Queue().Len() == 0 })
+	assert.True(t, hub.accord.CompareDigest(edge.accord.Digest()).Equal)
+	assert.Equal(t, []uint64{ids[4]}, received)
+}
+
+func TestHTTPSyncBootstrap(t *testing.T) {
+	hub := newSyncNode(t, "hub")
+	edge := newSyncNode(t, "edge")
+	stranger := newSyncNode(t, "stranger")
+
+	// The hub doesn't send us what was in the snapshot all over again
+	assert.Nil(t, hub.accord.Start())
+	hub.sync = &HTTPSync{RetryInterval: 10 * time.Millisecond, DeltaSync: true, Peers: []HTTPPeer{{Name: "edge", URL: edge.server.URL}}}
+	assert.Nil(t, hub.sync.Start(hub.accord))
+	hub.late.handler = hub.sync.Handler()
+	defer hub.stop()
+	for i := 0; i < 5; i++ {
+		msg, err := accord.NewMessage([]byte("from hub"))
+		assert.Nil(t, err)
+		assert.Nil(t, hub.accord.HandleNewMessage(msg))
+	}
+
+	// Only the hub's peers can bootstrap from it
+	assert.Nil(t, stranger.accord.Start())
+	stranger.sync = &HTTPSync{BootstrapFrom: hub.server.URL}
+	assert.NotNil(t, stranger.sync.Start(stranger.accord))
+	stranger.accord.Stop()
+	os.RemoveAll(stranger.dir)
+	stranger.server.Close()
+
+	assert.Nil(t, edge.accord.Start())
+	edge.sync = &HTTPSync{RetryInterval: 10 * time.Millisecond, BootstrapFrom: hub.server.URL, Peers: []HTTPPeer{{Name: "hub", URL: hub.server.URL}}}
+	assert.Nil(t, edge.sync.Start(edge.accord))
+	edge.late.handler = edge.sync.Handler()
+	defer edge.stop()
+	assert.Equal(t, uint64(5), edge.accord.History().Len())
+	assert.True(t, edge.accord.CompareDigest(hub.accord.Digest()).Equal)
+
+	// From then on we carry on as usual
+	msg, err := accord.NewMessage([]byte("after the snapshot"))
+	assert.Nil(t, err)
+	assert.Nil(t, hub.accord.HandleNewMessage(msg))
+	accordtest.Eventually(t, 500*time.Millisecond, func() bool { return edge.accord.History().Len() == 6 })
+	accordtest.Eventually(t, 500*time.Millisecond, func() bool { return hub.accord.Queue().Len() == 0 })
+}
+
+func TestHTTPSyncPeerAuth(t *testing.T) {
+	hub := newSyncNode(t, "hub")
+	edge := newSyncNode(t, "edge")
+	hub.accord.PeerAuth.Tokens = []accord.PeerToken{{Peer: "edge", Token: "edge-token"}}
+	edge.accord.PeerAuth.Token = "edge-token"
+	hub.start(t)
+	edge.start(t, hub)
+	defer hub.stop()
+	defer edge.stop()
+
+	msg, err := accord.NewMessage([]byte("authenticated"))
+	assert.Nil(t, err)
+	assert.Nil(t, edge.accord.HandleNewMessage(msg))
+	accordtest.Eventually(t, 500*time.Millisecond, func() bool { return hub.accord.History().Len() == 1 })
+
+	// Anyone else is turned away
+	resp, err := http.Post(hub.server.URL+"/sync/messages", "application/octet-stream", bytes.NewReader(nil))
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// As is a peer claiming to be someone it isn't
+	var buf bytes.Buffer
+	assert.Nil(t, gob.NewEncoder(&buf).Encode(pollRequest{Peer: "somebody-else"}))
+	req, err := http.NewRequest(http.MethodPost, hub.server.URL+"/sync/poll", &buf)
+	assert.Nil(t, err)
+	req.Header.Set("Authorization", "Bearer edge-token")
+	resp, err = http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
@@ -0,0 +1,333 @@
+package components
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/Ssawa/accord/accord"
+)
+
+// DefaultMessageAPIMaxBody is the largest request body MessageAPI accepts when MaxBodyBytes isn't set
+const DefaultMessageAPIMaxBody = 1 << 20
+
+// DefaultMessageAPIMaxMessages is the most Messages MessageAPI accepts in one request when MaxMessages
+// isn't set
+const DefaultMessageAPIMaxMessages = 100
+
+// MessageAPIClientHeader is added to every Message created through MessageAPI, naming the client (see
+// MessageAPIToken) it was created by
+const MessageAPIClientHeader = "api-client"
+
+// MessageAPIToken is a token MessageAPI accepts, and the name of the client that presents it
+type MessageAPIToken struct {
+	Client string
+	Token  string
+}
+
+// MessageAPIRequest is a Message as it's sent to MessageAPI. Payload can be any JSON value, which becomes
+// the Message's Payload as it was written; PayloadBase64 is for payloads that aren't JSON, and only one of
+// them can be given. At schedules the Message for later (see accord.HandleNewMessageAt)
+type MessageAPIRequest struct {
+	Type           string            `json:"type"`
+	Key            string            `json:"key"`
+	Channel        string            `json:"channel"`
+	Headers        map[string]string `json:"headers"`
+	IdempotencyKey string            `json:"idempotencyKey"`
+	Payload        json.RawMessage   `json:"payload"`
+	PayloadBase64  string            `json:"payloadBase64"`
+	At             *time.Time        `json:"at"`
+}
+
+// MessageAPIResponse is what MessageAPI responds with. Duplicate is set when the Messages were dropped as
+// duplicates of ones we'd already handled (see accord.ErrDuplicate), which a client retrying a request
+// can treat as success
+type MessageAPIResponse struct {
+	IDs       []uint64 `json:"ids,omitempty"`
+	Duplicate bool     `json:"duplicate,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// MessageAPI is an optional Component serving an HTTP API for creating new Messages, so that services on
+// the same host that aren't written in Go can hand operations to Accord without linking the library:
+//
+//	POST /messages  creates the Message described by a MessageAPIRequest in the body, or the group of
+//	                Messages described by an array of them, which are handled atomically (see
+//	                accord.HandleNewMessages)
+//
+// It responds with a MessageAPIResponse: 201 with the IDs of the new Messages, 200 if they were
+// duplicates, 400 for a request that doesn't describe valid Messages (including ones a Validator refuses,
+// see accord.AddValidator), 401 without a token we accept, 403 on a replica, 422 if they were refused (by
+// a pipeline, say) and 503 if they can't be handled for now (because we're paused, say), in which case the
+// request can be retried after the Retry-After header.
+//
+// Unlike WebReceiver, requests have to present one of our Tokens as a bearer token in their Authorization
+// header, unless Tokens is empty. Even so the API is only served on localhost by default
+type MessageAPI struct {
+	accord.ComponentRunner
+
+	// BindAddress is the address the API should be served on, defaulting to "127.0.0.1:7071". Set it to
+	// "-" to not start a server, and mount Handler on an existing one instead
+	BindAddress string
+
+	// Tokens are the tokens we accept. Anyone who can reach BindAddress can create Messages while it's
+	// empty
+	Tokens []MessageAPIToken
+
+	// Types limits the Types of the Messages that can be created to these ones, when it isn't empty
+	Types []string
+
+	// MaxBodyBytes is the largest request body we accept, defaulting to DefaultMessageAPIMaxBody
+	MaxBodyBytes int64
+
+	// MaxMessages is the most Messages we accept in one request, defaulting to DefaultMessageAPIMaxMessages
+	MaxMessages int
+
+	accord  *accord.Accord
+	log     accord.Logger
+	handler http.Handler
+	server  *backgroundServer
+}
+
+// Start sets up our endpoint and starts serving it
+func (comp *MessageAPI) Start(acc *accord.Accord) error {
+	comp.accord = acc
+	comp.log = acc.Logger.WithField("component", "MessageAPI")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/messages", comp.messages)
+	comp.handler = mux
+
+	address := comp.BindAddress
+	if address == "" {
+		address = "127.0.0.1:7071"
+	}
+	if address != "-" {
+		comp.server = startServer(address, comp.handler, comp.log)
+	}
+
+	comp.ComponentRunner.Init(acc, comp.tick, comp.cleanup, comp.log)
+	return nil
+}
+
+// Handler returns our endpoint, for mounting on an existing HTTP server. It's only available after Start
+func (comp *MessageAPI) Handler() http.Handler {
+	return comp.handler
+}
+
+// Our endpoint does all of our work, so our loop has nothing to do but wait to be stopped
+func (comp *MessageAPI) tick(*accord.Accord) {
+	time.Sleep(tickResolution)
+}
+
+func (comp *MessageAPI) cleanup(*accord.Accord) {
+	if comp.server != nil {
+		comp.server.stop()
+	}
+}
+
+// authenticate returns the name of the client a request's token belongs to, and whether it's one we
+// accept
+func (comp *MessageAPI) authenticate(r *http.Request) (string, bool) {
+	return acceptToken(comp.Tokens, bearerToken(r))
+}
+
+// acceptToken returns the name of the client a token belongs to, and whether it's one of tokens. Any token
+// is accepted when there aren't any
+func acceptToken(tokens []MessageAPIToken, token string) (string, bool) {
+	if len(tokens) == 0 {
+		return "", true
+	}
+	for _, accepted := range tokens {
+		if token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(accepted.Token)) == 1 {
+			return accepted.Client, true
+		}
+	}
+	return "", false
+}
+
+// allowedType returns whether Messages of a Type can be created, when only types can be. Any Type can be
+// when types is empty
+func allowedType(types []string, typ string) bool {
+	for _, allowed := range types {
+		if allowed == typ {
+			return true
+		}
+	}
+	return len(types) == 0
+}
+
+func (comp *MessageAPI) messages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		respondMessageAPI(w, http.StatusMethodNotAllowed, MessageAPIResponse{Error: "only POST is supported"})
+		return
+	}
+	client, ok := comp.authenticate(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		respondMessageAPI(w, http.StatusUnauthorized, MessageAPIResponse{Error: "unauthorized"})
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		respondMessageAPI(w, http.StatusUnsupportedMediaType, MessageAPIResponse{Error: "requests have to be application/json"})
+		return
+	}
+
+	maxBody := comp.MaxBodyBytes
+	if maxBody <= 0 {
+		maxBody = DefaultMessageAPIMaxBody
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBody))
+	if err != nil {
+		respondMessageAPI(w, http.StatusRequestEntityTooLarge, MessageAPIResponse{Error: fmt.Sprintf("requests can be at most %d bytes", maxBody)})
+		return
+	}
+
+	requests, err := comp.decode(body)
+	if err != nil {
+		respondMessageAPI(w, http.StatusBadRequest, MessageAPIResponse{Error: err.Error()})
+		return
+	}
+	msgs := []*accord.Message{}
+	for _, req := range requests {
+		msg, err := comp.newMessage(req, client)
+		if err != nil {
+			respondMessageAPI(w, http.StatusBadRequest, MessageAPIResponse{Error: err.Error()})
+			return
+		}
+		msgs = append(msgs, msg)
+	}
+
+	if len(msgs) == 1 && requests[0].At != nil {
+		err = comp.accord.HandleNewMessageAt(msgs[0], *requests[0].At)
+	} else {
+		err = comp.accord.HandleNewMessages(msgs...)
+	}
+
+	ids := []uint64{}
+	for _, msg := range msgs {
+		ids = append(ids, msg.ID)
+	}
+	status, resp := comp.result(err)
+	if status < 300 {
+		resp.IDs = ids
+	}
+	respondMessageAPI(w, status, resp)
+}
+
+// decode reads the Messages described by a request body, which is either a single one or an array of them
+func (comp *MessageAPI) decode(body []byte) ([]MessageAPIRequest, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	requests := []MessageAPIRequest{}
+	var err error
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = decoder.Decode(&requests)
+	} else {
+		req := MessageAPIRequest{}
+		err = decoder.Decode(&req)
+		requests = append(requests, req)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid request: %v", err)
+	}
+	if decoder.More() {
+		return nil, errors.New("invalid request: there's more than one value in the body")
+	}
+
+	maxMessages := comp.MaxMessages
+	if maxMessages <= 0 {
+		maxMessages = DefaultMessageAPIMaxMessages
+	}
+	if len(requests) == 0 {
+		return nil, errors.New("there are no messages in the request")
+	}
+	if len(requests) > maxMessages {
+		return nil, fmt.Errorf("requests can have at most %d messages", maxMessages)
+	}
+	if len(requests) > 1 {
+		for _, req := range requests {
+			if req.At != nil {
+				return nil, errors.New("a group of messages can't be scheduled")
+			}
+		}
+	}
+	return requests, nil
+}
+
+// newMessage validates a request for a Message and creates it
+func (comp *MessageAPI) newMessage(req MessageAPIRequest, client string) (*accord.Message, error) {
+	if !allowedType(comp.Types, req.Type) {
+		return nil, fmt.Errorf("messages of type %q can't be created", req.Type)
+	}
+
+	payload := []byte(req.Payload)
+	if req.PayloadBase64 != "" {
+		if len(req.Payload) > 0 {
+			return nil, errors.New("only one of payload and payloadBase64 can be given")
+		}
+		var err error
+		payload, err = base64.StdEncoding.DecodeString(req.PayloadBase64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid payloadBase64: %v", err)
+		}
+	}
+
+	msg, err := accord.NewMessage(payload)
+	if err != nil {
+		return nil, err
+	}
+	msg.Type = req.Type
+	msg.Key = req.Key
+	msg.Channel = req.Channel
+	msg.IdempotencyKey = req.IdempotencyKey
+	msg.Headers = map[string]string{}
+	for key, value := range req.Headers {
+		msg.Headers[key] = value
+	}
+	if client != "" {
+		msg.Headers[MessageAPIClientHeader] = client
+	}
+	return msg, nil
+}
+
+// result turns the outcome of handling a request's Messages into the status and response to send back
+func (comp *MessageAPI) result(err error) (int, MessageAPIResponse) {
+	if err == nil {
+		return http.StatusCreated, MessageAPIResponse{}
+	}
+	if err == accord.ErrDuplicate {
+		return http.StatusOK, MessageAPIResponse{Duplicate: true}
+	}
+	resp := MessageAPIResponse{Error: err.Error()}
+	if _, replica := err.(*accord.ReplicaError); replica {
+		return http.StatusForbidden, resp
+	}
+	if _, invalid := err.(*accord.ValidationError); invalid || err == accord.ErrUnknownChannel {
+		return http.StatusBadRequest, resp
+	}
+	if handleLater(err) {
+		return http.StatusServiceUnavailable, resp
+	}
+	comp.log.WithError(err).Info("Messages created through the API were refused")
+	return http.StatusUnprocessableEntity, resp
+}
+
+// respondMessageAPI writes a MessageAPIResponse. Clients are asked to wait a second before retrying a
+// request that can't be handled for now
+func respondMessageAPI(w http.ResponseWriter, status int, resp MessageAPIResponse) {
+	if status == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", "1")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,112 @@
+package components
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Ssawa/accord/accord/accordtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageAPI(t *testing.T) {
+	manager := &accordtest.Manager{}
+	api := &MessageAPI{BindAddress: "-", Tokens: []MessageAPIToken{{Client: "billing", Token: "s3cret"}}, Types: []string{"invoice.created", "invoice.paid"}}
+	acc := accordtest.New(t, manager, api)
+
+	post := func(token string, body string) (int, MessageAPIResponse) {
+		req := httptest.NewRequest(http.MethodPost, "/messages", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		api.Handler().ServeHTTP(w, req)
+		resp := MessageAPIResponse{}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+
+	status, _ := post("", `{"type":"invoice.created","payload":{"id":1}}`)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	status, _ = post("wrong", `{"type":"invoice.created","payload":{"id":1}}`)
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	status, resp := post("s3cret", `{"type":"invoice.created","key":"inv-1","payload":{"id":1},"idempotencyKey":"inv-1-created"}`)
+	assert.Equal(t, http.StatusCreated, status)
+	assert.Len(t, resp.IDs, 1)
+	msg, err := acc.History().Get(0)
+	assert.Nil(t, err)
+	assert.Equal(t, resp.IDs[0], msg.ID)
+	assert.Equal(t, `{"id":1}`, string(msg.Payload))
+	assert.Equal(t, "inv-1", msg.Key)
+	assert.Equal(t, "billing", msg.Headers[MessageAPIClientHeader])
+
+	// Retrying a request that was already handled is harmless
+	status, resp = post("s3cret", `{"type":"invoice.created","key":"inv-1","payload":{"id":1},"idempotencyKey":"inv-1-created"}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.True(t, resp.Duplicate)
+
+	// Groups are handled atomically
+	status, resp = post("s3cret", `[{"type":"invoice.paid","payloadBase64":"AAE="},{"type":"invoice.paid","payload":"cash"}]`)
+	assert.Equal(t, http.StatusCreated, status)
+	assert.Len(t, resp.IDs, 2)
+	accordtest.AssertHistoryLen(t, acc, 3)
+	msg, err = acc.History().Get(1)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0, 1}, msg.Payload)
+
+	for _, body := range []string{
+		`{"type":"invoice.deleted"}`,
+		`{"type":"invoice.paid","unknown":true}`,
+		`{"type":"invoice.paid","payload":1,"payloadBase64":"AAE="}`,
+		`[]`,
+		`[{"type":"invoice.paid","at":"2030-01-01T00:00:00Z"},{"type":"invoice.paid"}]`,
+		`{"type":"invoice.paid","channel":"missing"}`,
+		`not json`,
+	} {
+		status, resp = post("s3cret", body)
+		assert.Equal(t, http.StatusBadRequest, status, body)
+		assert.NotEmpty(t, resp.Error, body)
+	}
+	accordtest.AssertHistoryLen(t, acc, 3)
+
+	// Messages can be scheduled for later
+	status, _ = post("s3cret", `{"type":"invoice.paid","at":"2030-01-01T00:00:00Z"}`)
+	assert.Equal(t, http.StatusCreated, status)
+	scheduled, err := acc.ScheduledMessages()
+	assert.Nil(t, err)
+	assert.Len(t, scheduled, 1)
+
+	// Clients are told to come back later while we can't handle anything
+	acc.Pause()
+	status, _ = post("s3cret", `{"type":"invoice.paid"}`)
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	acc.Resume()
+
+	req := httptest.NewRequest(http.MethodPost, "/messages", bytes.NewBufferString(`{}`))
+	req.Header.Set("Authorization", "Bearer s3cret")
+	w := httptest.NewRecorder()
+	api.Handler().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+}
+
+func TestMessageAPIReplica(t *testing.T) {
+	api := &MessageAPI{BindAddress: "-", MaxBodyBytes: 64}
+	acc := accordtest.NewUnstarted(t, &accordtest.Manager{}, api)
+	acc.Replica = true
+	assert.Nil(t, acc.Start())
+	defer acc.Stop()
+
+	post := func(body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/messages", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+		w := httptest.NewRecorder()
+		api.Handler().ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusForbidden, post(`{"type":"anything"}`))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, post(`{"type":"`+string(bytes.Repeat([]byte("x"), 100))+`"}`))
+}
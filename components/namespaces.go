@@ -0,0 +1,75 @@
+package components
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// NamespacePrefix is where NamespaceMux serves each namespace's endpoints from, followed by the
+// namespace's name
+const NamespacePrefix = "/ns/"
+
+// NamespaceMux serves the endpoints of Components running in many namespaces (see accord.Namespaces) from a
+// single server, so that they can all share one port. A namespace's endpoints are found under
+// NamespacePrefix and its name: HTTPSync's for the namespace "tenant-a" are at "/ns/tenant-a/sync/...",
+// and so peers in other processes reach it with a URL like "http://host:7000/ns/tenant-a" (see
+// NamespaceURL). Requests for namespaces it doesn't know about are answered with a 404
+type NamespaceMux struct {
+	mutex    sync.RWMutex
+	handlers map[string]http.Handler
+}
+
+// NewNamespaceMux creates a NamespaceMux with no namespaces yet
+func NewNamespaceMux() *NamespaceMux {
+	return &NamespaceMux{handlers: map[string]http.Handler{}}
+}
+
+// Handle serves a namespace's endpoints with handler (which is generally a Component's Handler, only
+// available once the namespace has been started), replacing whatever it was served with before
+func (mux *NamespaceMux) Handle(namespace string, handler http.Handler) {
+	mux.mutex.Lock()
+	defer mux.mutex.Unlock()
+	mux.handlers[namespace] = handler
+}
+
+// Remove stops serving a namespace's endpoints
+func (mux *NamespaceMux) Remove(namespace string) {
+	mux.mutex.Lock()
+	defer mux.mutex.Unlock()
+	delete(mux.handlers, namespace)
+}
+
+// ServeHTTP hands a request to the handler of the namespace it's for, with the namespace taken off the
+// front of its path
+func (mux *NamespaceMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, NamespacePrefix) {
+		http.NotFound(w, r)
+		return
+	}
+	rest := strings.TrimPrefix(r.URL.Path, NamespacePrefix)
+	namespace := rest
+	path := "/"
+	if i := strings.Index(rest, "/"); i >= 0 {
+		namespace, path = rest[:i], rest[i:]
+	}
+
+	mux.mutex.RLock()
+	handler := mux.handlers[namespace]
+	mux.mutex.RUnlock()
+	if handler == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	inner := r.Clone(r.Context())
+	inner.URL.Path = path
+	inner.URL.RawPath = ""
+	handler.ServeHTTP(w, inner)
+}
+
+// NamespaceURL returns the base URL of a namespace's endpoints on a server using NamespaceMux, given the
+// server's own base URL
+func NamespaceURL(base string, namespace string) string {
+	return strings.TrimRight(base, "/") + NamespacePrefix + namespace
+}
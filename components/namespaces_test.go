@@ -0,0 +1,59 @@
+package components
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Ssawa/accord/accord"
+	"github.com/Ssawa/accord/accord/accordtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamespaceMux(t *testing.T) {
+	// Two hosts with the same two tenants, each serving all of its tenants from one port
+	hosts := []string{"host-1", "host-2"}
+	muxes := []*NamespaceMux{NewNamespaceMux(), NewNamespaceMux()}
+	servers := []*httptest.Server{httptest.NewServer(muxes[0]), httptest.NewServer(muxes[1])}
+	namespaces := []*accord.Namespaces{}
+	for i := range hosts {
+		defer servers[i].Close()
+		dir, err := ioutil.TempDir("", "accord-namespacemux")
+		assert.Nil(t, err)
+		defer os.RemoveAll(dir)
+
+		other := 1 - i
+		host := accord.NewNamespaces(dir, accord.DummyAccord().Logger)
+		syncs := map[string]*HTTPSync{}
+		for _, tenant := range []string{"a", "b"} {
+			syncs[tenant] = &HTTPSync{
+				RetryInterval: 10 * time.Millisecond,
+				Peers:         []HTTPPeer{{Name: hosts[other], URL: NamespaceURL(servers[other].URL, tenant)}},
+			}
+			_, err = host.Add(tenant, accord.NewDummerManager(), []accord.Component{syncs[tenant]}, func(acc *accord.Accord) { acc.NodeID = hosts[i] })
+			assert.Nil(t, err)
+		}
+		assert.Nil(t, host.Start())
+		defer host.Stop()
+		for tenant, sync := range syncs {
+			muxes[i].Handle(tenant, sync.Handler())
+		}
+		namespaces = append(namespaces, host)
+	}
+
+	msg, err := accord.NewMessage([]byte("tenant a"))
+	assert.Nil(t, err)
+	assert.Nil(t, namespaces[0].Get("a").HandleNewMessage(msg))
+
+	// Only tenant a on the other host gets it
+	accordtest.Eventually(t, 500*time.Millisecond, func() bool { return namespaces[1].Get("a").History().Len() == 1 })
+	accordtest.Eventually(t, 500*time.Millisecond, func() bool { return namespaces[0].Get("a").Queue().Len() == 0 })
+	assert.Equal(t, uint64(0), namespaces[1].Get("b").History().Len())
+
+	resp, err := servers[0].Client().Get(NamespaceURL(servers[0].URL, "c") + "/sync/digest")
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 404, resp.StatusCode)
+}
@@ -0,0 +1,289 @@
+package components
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/Ssawa/accord/accord"
+)
+
+// Event kinds emitted by Outbox as it hands the Messages in its table to Accord, and when one of them is
+// refused for good
+const (
+	EventOutboxEnqueued = "outbox_enqueued"
+	EventOutboxFailed   = "outbox_failed"
+)
+
+// DefaultOutboxTable is the table Outbox uses when Table isn't set
+const DefaultOutboxTable = "accord_outbox"
+
+// DefaultOutboxInterval is how often Outbox checks its table once it's caught up, when Interval isn't set
+const DefaultOutboxInterval = time.Second
+
+// OutboxDialect is the flavour of SQL spoken by the database an Outbox's table lives in
+type OutboxDialect int
+
+const (
+	// OutboxPostgres is PostgreSQL (and anything that speaks its dialect, like CockroachDB)
+	OutboxPostgres OutboxDialect = iota
+
+	// OutboxMySQL is MySQL or MariaDB
+	OutboxMySQL
+
+	// OutboxSQLite is SQLite
+	OutboxSQLite
+)
+
+// outboxTableName is what we'll accept as a table name, since it has to be written into our queries
+var outboxTableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// OutboxExecer is anything a Message can be written to an Outbox with: a *sql.Tx, most usefully, but a
+// *sql.DB or *sql.Conn works too
+type OutboxExecer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Outbox is a Component implementing the transactional outbox pattern, so that an application's change
+// to its own database and the Message announcing it either both happen or neither does. Rather than
+// calling HandleNewMessage after committing (and losing the Message if we go down in between), the
+// application writes the Message into a table in its own database with Write, in the same transaction as
+// the change itself. Outbox tails that table, handing each Message to Accord in the order they were
+// written and deleting it from the table once it's been handled.
+//
+// A Message is deleted only after it's been handled, so going down in between hands it to Accord again
+// when we start. Each Message is given an IdempotencyKey naming its row if it doesn't already have one,
+// so that it's dropped as a duplicate the second time around (see accord.DedupWindow). Messages that
+// can't be handled yet (because we're paused, say) are tried again later, holding up the ones after
+// them; a Message that's refused for any other reason is never going to be accepted, so it's deleted and
+// reported with EventOutboxFailed rather than holding up the rest of the table forever.
+//
+// Only the leader tails the table (see accord.IsLeader), so every node in a cluster can share one; without
+// a LeaderElector every node is its own leader, so only one node should run an Outbox for a table
+type Outbox struct {
+	accord.ComponentRunner
+
+	// DB is the application's database, which the table lives in
+	DB *sql.DB
+
+	// Table is the name of the outbox table, defaulting to DefaultOutboxTable
+	Table string
+
+	Dialect OutboxDialect
+
+	// CreateTable creates the table when we start if it doesn't already exist. Otherwise it has to be
+	// created up front, following CreateTableSQL
+	CreateTable bool
+
+	// Interval is how long we wait between checks of the table once we're caught up, defaulting to
+	// DefaultOutboxInterval. While there's a backlog we check again straight away
+	Interval time.Duration
+
+	// BatchSize is the most Messages we read from the table at once, defaulting to 100
+	BatchSize int
+
+	accord    *accord.Accord
+	log       accord.Logger
+	nextCheck time.Time
+}
+
+// Start creates our table if we were asked to and begins tailing it
+func (comp *Outbox) Start(acc *accord.Accord) error {
+	if comp.DB == nil {
+		return fmt.Errorf("Outbox needs a DB")
+	}
+	table, err := comp.table()
+	if err != nil {
+		return err
+	}
+
+	comp.accord = acc
+	comp.log = acc.Logger.WithField("component", "Outbox").WithField("table", table)
+	comp.nextCheck = time.Time{}
+
+	if comp.CreateTable {
+		_, err = comp.DB.Exec(comp.CreateTableSQL())
+		if err != nil {
+			return fmt.Errorf("unable to create outbox table %s: %v", table, err)
+		}
+	}
+
+	comp.ComponentRunner.Init(acc, comp.tick, nil, comp.log)
+	return nil
+}
+
+// CreateTableSQL returns the statement that creates our table in our Dialect
+func (comp *Outbox) CreateTableSQL() string {
+	table, _ := comp.table()
+	switch comp.Dialect {
+	case OutboxMySQL:
+		return "CREATE TABLE IF NOT EXISTS " + table + " (id BIGINT AUTO_INCREMENT PRIMARY KEY, message LONGBLOB NOT NULL, created_at TIMESTAMP NOT NULL)"
+	case OutboxSQLite:
+		return "CREATE TABLE IF NOT EXISTS " + table + " (id INTEGER PRIMARY KEY AUTOINCREMENT, message BLOB NOT NULL, created_at TIMESTAMP NOT NULL)"
+	default:
+		return "CREATE TABLE IF NOT EXISTS " + table + " (id BIGSERIAL PRIMARY KEY, message BYTEA NOT NULL, created_at TIMESTAMPTZ NOT NULL)"
+	}
+}
+
+// Write adds a Message to our table, to be handed to Accord once the transaction it was written in has
+// been committed. It doesn't need us to have been started, so it can be used by any process sharing the
+// database
+func (comp *Outbox) Write(ctx context.Context, tx OutboxExecer, msg *accord.Message) error {
+	table, err := comp.table()
+	if err != nil {
+		return err
+	}
+	data, err := msg.Serialize()
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO "+table+" (message, created_at) VALUES ("+comp.placeholder(1)+", "+comp.placeholder(2)+")", data, time.Now().UTC())
+	return err
+}
+
+// Pending returns how many Messages are in our table waiting to be handed to Accord
+func (comp *Outbox) Pending() (int, error) {
+	table, err := comp.table()
+	if err != nil {
+		return 0, err
+	}
+	pending := 0
+	err = comp.DB.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&pending)
+	return pending, err
+}
+
+func (comp *Outbox) table() (string, error) {
+	table := comp.Table
+	if table == "" {
+		table = DefaultOutboxTable
+	}
+	if !outboxTableName.MatchString(table) {
+		return "", fmt.Errorf("%q isn't a valid outbox table name", table)
+	}
+	return table, nil
+}
+
+// placeholder returns the nth query parameter in our Dialect
+func (comp *Outbox) placeholder(n int) string {
+	if comp.Dialect == OutboxPostgres {
+		return "$" + strconv.Itoa(n)
+	}
+	return "?"
+}
+
+func (comp *Outbox) tick(*accord.Accord) {
+	if time.Now().Before(comp.nextCheck) || !comp.accord.IsLeader() {
+		time.Sleep(tickResolution)
+		return
+	}
+
+	batchSize := comp.BatchSize
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+	interval := comp.Interval
+	if interval <= 0 {
+		interval = DefaultOutboxInterval
+	}
+
+	handled, caughtUp, err := comp.drain(batchSize)
+	if err != nil {
+		comp.log.WithError(err).Warn("Unable to read the outbox table")
+	}
+	if handled > 0 {
+		comp.log.WithField("handled", handled).Debug("Handled messages from the outbox table")
+	}
+	if err != nil || caughtUp {
+		comp.nextCheck = time.Now().Add(interval)
+	}
+}
+
+// outboxRow is a Message read from our table
+type outboxRow struct {
+	id   int64
+	data []byte
+}
+
+// drain hands a batch of Messages from our table to Accord, returning how many were handled and whether
+// we're caught up, either because there's nothing more in the table or because we're stuck on a Message
+// that can't be handled yet
+func (comp *Outbox) drain(batchSize int) (int, bool, error) {
+	table, _ := comp.table()
+	rows, err := comp.DB.Query("SELECT id, message FROM " + table + " ORDER BY id LIMIT " + strconv.Itoa(batchSize))
+	if err != nil {
+		return 0, false, err
+	}
+	batch := []outboxRow{}
+	for rows.Next() {
+		row := outboxRow{}
+		err = rows.Scan(&row.id, &row.data)
+		if err != nil {
+			rows.Close()
+			return 0, false, err
+		}
+		batch = append(batch, row)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return 0, false, err
+	}
+
+	for i, row := range batch {
+		if !comp.handle(table, row) {
+			return i, true, nil
+		}
+		_, err = comp.DB.Exec("DELETE FROM "+table+" WHERE id = "+comp.placeholder(1), row.id)
+		if err != nil {
+			return i, false, err
+		}
+	}
+	return len(batch), len(batch) < batchSize, nil
+}
+
+// handle hands a single Message from our table to Accord, returning whether we're done with it. One that
+// can't be handled yet is left to be tried again later; any other failure is reported and the Message is
+// dropped
+func (comp *Outbox) handle(table string, row outboxRow) bool {
+	fields := map[string]interface{}{"table": table, "row": row.id}
+	msg, err := accord.DeserializeMessage(row.data)
+	if err != nil {
+		comp.log.WithError(err).WithField("row", row.id).Warn("Unable to decode a message in the outbox table")
+		fields["error"] = err.Error()
+		comp.accord.Emit(EventOutboxFailed, "A message in the outbox table could not be decoded", fields)
+		return true
+	}
+	if msg.IdempotencyKey == "" {
+		msg.IdempotencyKey = "outbox/" + table + "/" + strconv.FormatInt(row.id, 10)
+	}
+
+	log := accord.WithMessage(comp.log, msg).WithField("row", row.id)
+	fields["id"] = msg.ID
+	err = comp.accord.HandleNewMessage(msg)
+	if err == nil || err == accord.ErrDuplicate {
+		comp.accord.Emit(EventOutboxEnqueued, "A message from the outbox table was handled", fields)
+		return true
+	}
+	if handleLater(err) {
+		log.WithError(err).Debug("A message in the outbox table can't be handled yet")
+		return false
+	}
+	log.WithError(err).Warn("A message in the outbox table was refused")
+	fields["error"] = err.Error()
+	comp.accord.Emit(EventOutboxFailed, "A message in the outbox table was refused", fields)
+	return true
+}
+
+// handleLater reports whether a Message we created was refused for a reason that should pass, so that it's
+// worth trying again later
+func handleLater(err error) bool {
+	switch err {
+	case accord.ErrPaused, accord.ErrDraining, accord.ErrReadOnly, accord.ErrDiskQuota, accord.ErrQueueFull:
+		return true
+	}
+	_, retryable := err.(*accord.RetryableError)
+	return retryable
+}
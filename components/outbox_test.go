@@ -0,0 +1,234 @@
+package components
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Ssawa/accord/accord"
+	"github.com/Ssawa/accord/accord/accordtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	sql.Register("outboxtest", &outboxDriver{tables: map[string]*outboxTable{}})
+}
+
+// outboxDriver is a database/sql driver understanding just enough SQL for Outbox, keeping its table in
+// memory. Rows written in a transaction only show up once it's committed
+type outboxDriver struct {
+	mutex  sync.Mutex
+	tables map[string]*outboxTable
+}
+
+type outboxTable struct {
+	mutex  sync.Mutex
+	rows   []outboxRow
+	nextID int64
+}
+
+func (d *outboxDriver) Open(name string) (driver.Conn, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.tables[name] == nil {
+		d.tables[name] = &outboxTable{}
+	}
+	return &outboxConn{table: d.tables[name]}, nil
+}
+
+type outboxConn struct {
+	table   *outboxTable
+	pending [][]byte
+	inTx    bool
+}
+
+func (conn *outboxConn) Prepare(query string) (driver.Stmt, error) {
+	return &outboxStmt{conn: conn, query: query}, nil
+}
+
+func (conn *outboxConn) Close() error { return nil }
+
+func (conn *outboxConn) Begin() (driver.Tx, error) {
+	conn.inTx = true
+	return conn, nil
+}
+
+func (conn *outboxConn) Commit() error {
+	for _, data := range conn.pending {
+		conn.table.insert(data)
+	}
+	conn.pending, conn.inTx = nil, false
+	return nil
+}
+
+func (conn *outboxConn) Rollback() error {
+	conn.pending, conn.inTx = nil, false
+	return nil
+}
+
+func (table *outboxTable) insert(data []byte) {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	table.nextID++
+	table.rows = append(table.rows, outboxRow{id: table.nextID, data: data})
+}
+
+type outboxStmt struct {
+	conn  *outboxConn
+	query string
+}
+
+func (stmt *outboxStmt) Close() error  { return nil }
+func (stmt *outboxStmt) NumInput() int { return -1 }
+
+func (stmt *outboxStmt) Exec(args []driver.Value) (driver.Result, error) {
+	table := stmt.conn.table
+	switch {
+	case strings.HasPrefix(stmt.query, "CREATE TABLE"):
+	case strings.HasPrefix(stmt.query, "INSERT INTO"):
+		if stmt.conn.inTx {
+			stmt.conn.pending = append(stmt.conn.pending, args[0].([]byte))
+		} else {
+			table.insert(args[0].([]byte))
+		}
+	case strings.HasPrefix(stmt.query, "DELETE FROM"):
+		table.mutex.Lock()
+		defer table.mutex.Unlock()
+		rows := []outboxRow{}
+		for _, row := range table.rows {
+			if row.id != args[0].(int64) {
+				rows = append(rows, row)
+			}
+		}
+		table.rows = rows
+	default:
+		return nil, errors.New("unsupported statement: " + stmt.query)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (stmt *outboxStmt) Query(args []driver.Value) (driver.Rows, error) {
+	table := stmt.conn.table
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	switch {
+	case strings.HasPrefix(stmt.query, "SELECT COUNT(*)"):
+		return &outboxRows{columns: []string{"count"}, values: [][]driver.Value{{int64(len(table.rows))}}}, nil
+	case strings.HasPrefix(stmt.query, "SELECT id, message"):
+		limit, err := strconv.Atoi(stmt.query[strings.LastIndex(stmt.query, " ")+1:])
+		if err != nil {
+			return nil, err
+		}
+		rows := &outboxRows{columns: []string{"id", "message"}}
+		for i, row := range table.rows {
+			if i == limit {
+				break
+			}
+			rows.values = append(rows.values, []driver.Value{row.id, row.data})
+		}
+		return rows, nil
+	}
+	return nil, errors.New("unsupported query: " + stmt.query)
+}
+
+type outboxRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (rows *outboxRows) Columns() []string { return rows.columns }
+func (rows *outboxRows) Close() error      { return nil }
+
+func (rows *outboxRows) Next(dest []driver.Value) error {
+	if len(rows.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, rows.values[0])
+	rows.values = rows.values[1:]
+	return nil
+}
+
+func TestOutbox(t *testing.T) {
+	db, err := sql.Open("outboxtest", t.Name())
+	assert.Nil(t, err)
+	defer db.Close()
+
+	manager := &accordtest.Manager{}
+	outbox := &Outbox{DB: db, CreateTable: true, Interval: 5 * time.Millisecond}
+	acc := accordtest.New(t, manager, outbox)
+
+	write := func(payload string, commit bool) {
+		tx, err := db.Begin()
+		assert.Nil(t, err)
+		msg, err := accord.NewMessage([]byte(payload))
+		assert.Nil(t, err)
+		assert.Nil(t, outbox.Write(context.Background(), tx, msg))
+		if commit {
+			assert.Nil(t, tx.Commit())
+		} else {
+			assert.Nil(t, tx.Rollback())
+		}
+	}
+
+	// Messages written in a transaction that's rolled back never show up
+	write("one", true)
+	write("never", false)
+	write("two", true)
+	accordtest.Eventually(t, 500*time.Millisecond, func() bool { return acc.History().Len() == 2 })
+	accordtest.AssertPayloads(t, manager, "one", "two")
+	pending, err := outbox.Pending()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, pending)
+
+	// Nothing is taken out of the table while we can't handle it
+	acc.Pause()
+	write("three", true)
+	time.Sleep(20 * time.Millisecond)
+	pending, err = outbox.Pending()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, pending)
+	acc.Resume()
+	accordtest.Eventually(t, 500*time.Millisecond, func() bool { return acc.History().Len() == 3 })
+}
+
+func TestOutboxRedelivery(t *testing.T) {
+	db, err := sql.Open("outboxtest", t.Name())
+	assert.Nil(t, err)
+	defer db.Close()
+
+	// We went down after handling a Message but before deleting it, so it's handled again
+	outbox := &Outbox{DB: db, Dialect: OutboxSQLite}
+	acc := accordtest.NewUnstarted(t, &accordtest.Manager{}, outbox)
+	assert.Nil(t, acc.Start())
+	defer acc.Stop()
+	outbox.ComponentRunner.Stop(0)
+	outbox.WaitForStop()
+
+	msg, err := accord.NewMessage([]byte("once"))
+	assert.Nil(t, err)
+	data, err := msg.Serialize()
+	assert.Nil(t, err)
+	row := outboxRow{id: 7, data: data}
+	assert.True(t, outbox.handle(DefaultOutboxTable, row))
+	assert.True(t, outbox.handle(DefaultOutboxTable, row))
+	accordtest.AssertHistoryLen(t, acc, 1)
+
+	// A Message that can't be decoded is dropped rather than holding up the rest
+	assert.True(t, outbox.handle(DefaultOutboxTable, outboxRow{id: 8, data: []byte("garbage")}))
+	accordtest.AssertHistoryLen(t, acc, 1)
+}
+
+func TestOutboxTableName(t *testing.T) {
+	outbox := &Outbox{Table: "outbox; DROP TABLE users"}
+	assert.NotNil(t, outbox.Write(context.Background(), nil, &accord.Message{}))
+	assert.Equal(t, "$1", outbox.placeholder(1))
+	outbox.Dialect = OutboxMySQL
+	assert.Equal(t, "?", outbox.placeholder(1))
+}
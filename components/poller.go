@@ -0,0 +1,211 @@
+package components
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Ssawa/accord/accord"
+)
+
+// DefaultPollInterval is how often Poller asks for new Messages once it's caught up, when Interval isn't
+// set
+const DefaultPollInterval = 5 * time.Second
+
+// pollRequest is what a Poller sends to HTTPSync's poll endpoint: the Messages it handled (or couldn't
+// handle) out of the last batch it was sent, and how many more it wants
+type pollRequest struct {
+	Peer  string
+	Acks  []uint64
+	Nacks []uint64
+	Max   int
+}
+
+// Poller is a Component that fetches Messages from a remote node's HTTPSync by polling it, for nodes that
+// can make outbound requests but can't accept inbound connections (an edge node behind NAT or a
+// firewall, say). The remote has to list us (by our NodeID) in its HTTPSync's PollingPeers: it keeps our
+// cursor for us, and every poll acknowledges the Messages we handled out of the previous batch. To send our
+// own Messages the other way, run HTTPSync as well with the remote as a peer and no BindAddress.
+//
+// Like HTTPSync we shake hands with the remote before our first poll, and again whenever we've failed to
+// reach it, and don't poll a remote we turn out to be incompatible with. Shaking hands is what connecting
+// to the remote amounts to (see accord.ReconnectingComponent), so we back off between failed handshakes
+// according to our Backoff. Every poll counts as a heartbeat (see accord.Heartbeat), both for us and for
+// the remote, so Interval should be well within PeerTimeout
+type Poller struct {
+	accord.ReconnectingComponent
+
+	// URL is the base URL of the remote's HTTPSync endpoints (for instance "http://hub:7000")
+	URL string
+
+	// Interval is how long we wait between polls once we're caught up, defaulting to DefaultPollInterval.
+	// While there's a backlog we poll again straight away
+	Interval time.Duration
+
+	// BatchSize is the most Messages we ask for in one poll, defaulting to 100
+	BatchSize int
+
+	// Client is used to talk to the remote, defaulting to a client with a 10 second timeout
+	Client *http.Client
+
+	// Compress asks the remote to gzip the batches it sends us, if it can
+	Compress bool
+
+	accord   *accord.Accord
+	log      accord.Logger
+	nextPoll time.Time
+
+	// agreement is what we last settled on with the remote when we shook hands
+	agreement *accord.Agreement
+
+	// acks and nacks are what we have to tell the remote about the last batch on our next poll
+	acks  []uint64
+	nacks []uint64
+}
+
+// Start begins polling
+func (comp *Poller) Start(acc *accord.Accord) error {
+	if comp.URL == "" {
+		return fmt.Errorf("Poller needs a URL")
+	}
+
+	comp.accord = acc
+	comp.log = acc.Logger.WithField("component", "Poller").WithField("remote", comp.URL)
+	comp.nextPoll = time.Time{}
+	comp.acks, comp.nacks = nil, nil
+	comp.agreement = nil
+
+	comp.InitReconnecting(acc, comp.URL, comp.dial, comp.tick, nil, comp.log)
+	return nil
+}
+
+// pollSession is our "connection" to the remote, which is just what we agreed on when we shook hands
+type pollSession struct {
+	agreement accord.Agreement
+}
+
+func (session *pollSession) Close() error {
+	return nil
+}
+
+// dial shakes hands with the remote
+func (comp *Poller) dial(*accord.Accord) (io.Closer, error) {
+	agreement, err := handshake(comp.accord, comp.client(), comp.URL, "", comp.Compress)
+	if err != nil {
+		if _, ok := err.(*accord.IncompatibleError); ok {
+			incompatible(comp.accord, comp.log, comp.URL, err)
+		}
+		return nil, err
+	}
+	comp.agreement = &agreement
+	return &pollSession{agreement: agreement}, nil
+}
+
+func (comp *Poller) tick(_ *accord.Accord, conn io.Closer) error {
+	if time.Now().Before(comp.nextPoll) {
+		time.Sleep(tickResolution)
+		return nil
+	}
+	session := conn.(*pollSession)
+
+	batchSize := comp.BatchSize
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+	interval := comp.Interval
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+
+	// Failing to poll could well mean the remote was restarted or upgraded, so we shake hands again
+	msgs, err := comp.poll(session.agreement, batchSize)
+	if err != nil {
+		return fmt.Errorf("unable to poll for messages: %v", err)
+	}
+	handled := comp.handle(msgs)
+
+	// Whatever we handled has to be acknowledged, which the next poll does for us, so only wait if there's
+	// nothing to tell the remote and nothing more to fetch
+	if len(msgs) < batchSize && len(comp.acks) == 0 && len(comp.nacks) == 0 {
+		comp.nextPoll = time.Now().Add(interval)
+	}
+	if handled < len(msgs) {
+		comp.nextPoll = time.Now().Add(interval)
+	}
+	return nil
+}
+
+// poll asks the remote for our next batch of Messages, acknowledging the last one
+func (comp *Poller) poll(agreement accord.Agreement, max int) ([]accord.Message, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(pollRequest{Peer: comp.accord.NodeID, Acks: comp.acks, Nacks: comp.nacks, Max: max})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, strings.TrimRight(comp.URL, "/")+"/sync/poll", &buf)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set(protocolHeader, strconv.Itoa(agreement.ProtocolVersion))
+	if agreement.Compression == "gzip" {
+		req.Header.Set(compressionHeader, "gzip")
+	}
+
+	resp, err := peerClient(comp.accord, comp.client(), comp.URL).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("remote responded with %s", resp.Status)
+	}
+
+	// The remote has heard about the last batch now
+	comp.acks, comp.nacks = nil, nil
+	comp.accord.Heartbeat(agreement.Peer)
+
+	msgs := []accord.Message{}
+	body, err := decodeBody(resp.Header.Get("Content-Encoding"), resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	err = gob.NewDecoder(body).Decode(&msgs)
+	return msgs, err
+}
+
+func (comp *Poller) client() *http.Client {
+	if comp.Client != nil {
+		return comp.Client
+	}
+	return &http.Client{Timeout: 10 * time.Second}
+}
+
+// handle hands a batch of polled Messages to Accord, noting which ones to acknowledge. We stop at the first
+// one we can't handle, handing it back along with everything after it so that they're sent again in order.
+// It returns how many were handled
+func (comp *Poller) handle(msgs []accord.Message) int {
+	for i := range msgs {
+		err := comp.accord.HandleRemoteMessage(&msgs[i])
+
+		// A Message that's refused by a pipeline is never going to be accepted, so it's acknowledged
+		// like any other
+		if _, rejected := err.(*accord.StageError); err == nil || rejected {
+			comp.acks = append(comp.acks, msgs[i].ID)
+			continue
+		}
+
+		accord.WithMessage(comp.log, &msgs[i]).WithError(err).Warn("Unable to handle a polled message")
+		for _, msg := range msgs[i:] {
+			comp.nacks = append(comp.nacks, msg.ID)
+		}
+		return i
+	}
+	return len(msgs)
+}
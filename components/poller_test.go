@@ -0,0 +1,61 @@
+package components
+
+import (
+	"bytes"
+	"encoding/gob"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Ssawa/accord/accord"
+	"github.com/Ssawa/accord/accord/accordtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPoller(t *testing.T) {
+	hub := newSyncNode(t, "hub")
+	edge := newSyncNode(t, "edge")
+
+	assert.Nil(t, hub.accord.Start())
+	hub.sync = &HTTPSync{PollingPeers: []string{"edge"}}
+	assert.Nil(t, hub.sync.Start(hub.accord))
+	hub.late.handler = hub.sync.Handler()
+	defer hub.stop()
+
+	// The edge can't be reached, so it pulls what it's missing from the hub
+	assert.Nil(t, edge.accord.Start())
+	edge.sync = &HTTPSync{}
+	assert.Nil(t, edge.sync.Start(edge.accord))
+	defer edge.stop()
+	poller := &Poller{URL: hub.server.URL, BatchSize: 2, Interval: 5 * time.Millisecond}
+	assert.Nil(t, poller.Start(edge.accord))
+	defer func() {
+		poller.Stop(0)
+		poller.WaitForStop()
+	}()
+
+	for i := 0; i < 5; i++ {
+		msg, err := accord.NewMessage([]byte("pulled"))
+		assert.Nil(t, err)
+		assert.Nil(t, hub.accord.HandleNewMessage(msg))
+	}
+
+	accordtest.Eventually(t, 500*time.Millisecond, func() bool { return edge.accord.History().Len() == 5 })
+	assert.True(t, hub.accord.CompareDigest(edge.accord.Digest()).Equal)
+
+	// Once the edge has acknowledged everything, the hub can let go of it
+	accordtest.Eventually(t, 500*time.Millisecond, func() bool { return hub.accord.Queue().Len() == 0 })
+}
+
+func TestPollerUnknownPeer(t *testing.T) {
+	hub := newSyncNode(t, "hub")
+	hub.start(t)
+	defer hub.stop()
+
+	var buf bytes.Buffer
+	assert.Nil(t, gob.NewEncoder(&buf).Encode(pollRequest{Peer: "stranger", Max: 10}))
+	resp, err := http.Post(hub.server.URL+"/sync/poll", "application/octet-stream", &buf)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
@@ -0,0 +1,366 @@
+package components
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Ssawa/accord/accord"
+)
+
+// Event kinds emitted by PostgresSource as it turns the transactions in its replication slot into Messages,
+// and when one of them is refused for good
+const (
+	EventPostgresTransaction = "postgres_transaction"
+	EventPostgresFailed      = "postgres_failed"
+)
+
+// DefaultPostgresInterval is how often PostgresSource checks its replication slot once it's caught up,
+// when Interval isn't set
+const DefaultPostgresInterval = time.Second
+
+// Headers PostgresSource adds to the Messages it creates by default, saying where the change came from
+const (
+	PostgresActionHeader = "pg-action"
+	PostgresTableHeader  = "pg-table"
+	PostgresLSNHeader    = "pg-lsn"
+)
+
+// PostgresChange is a change to a single row, as decoded from a replication slot
+type PostgresChange struct {
+	// Action is "insert", "update", "delete" or "truncate"
+	Action string
+
+	Schema string
+	Table  string
+
+	// Columns holds the row's new values, for inserts and updates
+	Columns map[string]interface{} `json:",omitempty"`
+
+	// Identity holds the values of the row's replica identity (usually its primary key) from before the
+	// change, for updates and deletes
+	Identity map[string]interface{} `json:",omitempty"`
+
+	// PrimaryKey names the columns of the table's primary key, in order
+	PrimaryKey []string `json:",omitempty"`
+
+	// LSN is the log sequence number of the commit of the transaction the change was made in
+	LSN string
+}
+
+// PostgresSource is a Component that consumes a Postgres logical replication slot, turning the row changes
+// made in the database into Messages, so that a hub backed by a database can feed its edge nodes without
+// the application having to create a Message for every change itself. It reads the slot through the SQL
+// interface to logical decoding, so DB can be opened with any Postgres driver, and needs the slot to use
+// the wal2json output plugin (which CreateSlot takes care of).
+//
+// The changes made in each transaction are handed to Accord together with HandleNewMessages, so they're
+// recorded atomically, and the slot is only advanced past a transaction once it's been handled. Going
+// down in between hands the transaction to Accord again when we start, but every Message carries an
+// IdempotencyKey naming the slot and where the change sits in it, so it's dropped as a duplicate the second
+// time around (see accord.DedupWindow). A transaction that can't be handled yet (because we're paused,
+// say) is tried again later, holding up the ones after it; one that's refused for any other reason is
+// never going to be accepted, so it's skipped and reported with EventPostgresFailed rather than holding
+// up the slot forever.
+//
+// Only the leader consumes the slot (see accord.IsLeader); without a LeaderElector every node is its own
+// leader, so only one node should run a PostgresSource for a slot
+type PostgresSource struct {
+	accord.ComponentRunner
+
+	// DB is the database the slot belongs to
+	DB *sql.DB
+
+	// Slot is the name of the logical replication slot to consume
+	Slot string
+
+	// CreateSlot creates the slot when we start if it doesn't already exist. The slot then only sees
+	// changes made from that point on
+	CreateSlot bool
+
+	// Tables limits the changes we turn into Messages to those made to these tables ("schema.table"). By
+	// default changes to every table are
+	Tables []string
+
+	// Convert turns a change into a Message, or returns nil to leave the change out. By default the
+	// Message's Type is the table's "schema.table", its Key is the row's primary key (its values joined with
+	// "/") and its Payload is the change encoded as JSON, with headers saying what the change was
+	Convert func(change PostgresChange) (*accord.Message, error)
+
+	// Interval is how long we wait between checks of the slot once we're caught up, defaulting to
+	// DefaultPostgresInterval. While there's a backlog we check again straight away
+	Interval time.Duration
+
+	// BatchSize is roughly the most changes we read from the slot at once, defaulting to 1000. Transactions
+	// are never split up, so one that's larger is still read whole
+	BatchSize int
+
+	accord    *accord.Accord
+	log       accord.Logger
+	nextCheck time.Time
+}
+
+// Start creates our slot if we were asked to and begins consuming it
+func (comp *PostgresSource) Start(acc *accord.Accord) error {
+	if comp.DB == nil {
+		return fmt.Errorf("PostgresSource needs a DB")
+	}
+	if comp.Slot == "" {
+		return fmt.Errorf("PostgresSource needs a Slot")
+	}
+
+	comp.accord = acc
+	comp.log = acc.Logger.WithField("component", "PostgresSource").WithField("slot", comp.Slot)
+	comp.nextCheck = time.Time{}
+
+	if comp.CreateSlot {
+		err := comp.createSlot()
+		if err != nil {
+			return fmt.Errorf("unable to create replication slot %s: %v", comp.Slot, err)
+		}
+	}
+
+	comp.ComponentRunner.Init(acc, comp.tick, nil, comp.log)
+	return nil
+}
+
+// createSlot creates our slot, unless it already exists
+func (comp *PostgresSource) createSlot() error {
+	exists := 0
+	err := comp.DB.QueryRow("SELECT COUNT(*) FROM pg_replication_slots WHERE slot_name = $1", comp.Slot).Scan(&exists)
+	if err != nil || exists > 0 {
+		return err
+	}
+	_, err = comp.DB.Exec("SELECT pg_create_logical_replication_slot($1, 'wal2json')", comp.Slot)
+	if err == nil {
+		comp.log.Info("Created a logical replication slot")
+	}
+	return err
+}
+
+func (comp *PostgresSource) tick(*accord.Accord) {
+	if time.Now().Before(comp.nextCheck) || !comp.accord.IsLeader() {
+		time.Sleep(tickResolution)
+		return
+	}
+
+	batchSize := comp.BatchSize
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+	interval := comp.Interval
+	if interval <= 0 {
+		interval = DefaultPostgresInterval
+	}
+
+	caughtUp, err := comp.consume(batchSize)
+	if err != nil {
+		comp.log.WithError(err).Warn("Unable to consume our replication slot")
+	}
+	if err != nil || caughtUp {
+		comp.nextCheck = time.Now().Add(interval)
+	}
+}
+
+// postgresTransaction is a transaction read from our slot: its changes, and the LSN of its commit
+type postgresTransaction struct {
+	changes []PostgresChange
+	lsn     string
+}
+
+// consume hands a batch of transactions from our slot to Accord, advancing the slot past the ones that were
+// handled. It returns whether we're caught up, either because there's nothing more in the slot or because
+// we're stuck on a transaction that can't be handled yet
+func (comp *PostgresSource) consume(batchSize int) (bool, error) {
+	txs, changes, err := comp.peek(batchSize)
+	if err != nil {
+		return false, err
+	}
+
+	for _, tx := range txs {
+		if !comp.handle(tx) {
+			return true, nil
+		}
+		_, err = comp.DB.Exec("SELECT pg_replication_slot_advance($1, $2::pg_lsn)", comp.Slot, tx.lsn)
+		if err != nil {
+			return false, err
+		}
+	}
+	return changes < batchSize, nil
+}
+
+// peek reads the transactions waiting in our slot without consuming them, returning them along with how
+// many rows were read
+func (comp *PostgresSource) peek(batchSize int) ([]postgresTransaction, int, error) {
+	query := "SELECT lsn::text, data FROM pg_logical_slot_peek_changes($1, NULL, $2, 'format-version', '2', 'include-transaction', 'true', 'include-pk', 'true'"
+	args := []interface{}{comp.Slot, batchSize}
+	if len(comp.Tables) > 0 {
+		query += ", 'add-tables', $3"
+		args = append(args, strings.Join(comp.Tables, ","))
+	}
+	rows, err := comp.DB.Query(query+")", args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	txs := []postgresTransaction{}
+	current := postgresTransaction{}
+	read := 0
+	for rows.Next() {
+		var lsn, data string
+		err = rows.Scan(&lsn, &data)
+		if err != nil {
+			return nil, 0, err
+		}
+		read++
+
+		// Numbers are kept as they were written, so that large keys don't turn into floats
+		record := wal2jsonRecord{}
+		decoder := json.NewDecoder(strings.NewReader(data))
+		decoder.UseNumber()
+		err = decoder.Decode(&record)
+		if err != nil {
+			return nil, 0, fmt.Errorf("unable to decode a change at %s: %v", lsn, err)
+		}
+		switch record.Action {
+		case "B":
+			current = postgresTransaction{}
+		case "C":
+			current.lsn = lsn
+			for i := range current.changes {
+				current.changes[i].LSN = lsn
+			}
+			txs = append(txs, current)
+			current = postgresTransaction{}
+		default:
+			if change, ok := record.change(); ok {
+				current.changes = append(current.changes, change)
+			}
+		}
+	}
+	// Only whole transactions are returned, so anything left over is the start of one we haven't been
+	// given all of and is left for next time
+	return txs, read, rows.Err()
+}
+
+// wal2jsonRecord is a single record in wal2json's format-version 2
+type wal2jsonRecord struct {
+	Action   string           `json:"action"`
+	Schema   string           `json:"schema"`
+	Table    string           `json:"table"`
+	Columns  []wal2jsonColumn `json:"columns"`
+	Identity []wal2jsonColumn `json:"identity"`
+	PK       []wal2jsonColumn `json:"pk"`
+}
+
+type wal2jsonColumn struct {
+	Name  string      `json:"name"`
+	Value interface{} `json:"value"`
+}
+
+// change turns a record into the change it describes, if it's one
+func (record wal2jsonRecord) change() (PostgresChange, bool) {
+	actions := map[string]string{"I": "insert", "U": "update", "D": "delete", "T": "truncate"}
+	action, ok := actions[record.Action]
+	if !ok {
+		return PostgresChange{}, false
+	}
+	change := PostgresChange{Action: action, Schema: record.Schema, Table: record.Table}
+	if len(record.Columns) > 0 {
+		change.Columns = map[string]interface{}{}
+		for _, column := range record.Columns {
+			change.Columns[column.Name] = column.Value
+		}
+	}
+	if len(record.Identity) > 0 {
+		change.Identity = map[string]interface{}{}
+		for _, column := range record.Identity {
+			change.Identity[column.Name] = column.Value
+		}
+	}
+	for _, column := range record.PK {
+		change.PrimaryKey = append(change.PrimaryKey, column.Name)
+	}
+	return change, true
+}
+
+// handle hands a transaction's changes to Accord, returning whether we're done with it. One that can't be
+// handled yet is left to be tried again later; any other failure is reported and the transaction skipped
+func (comp *PostgresSource) handle(tx postgresTransaction) bool {
+	log := comp.log.WithField("lsn", tx.lsn)
+	fields := map[string]interface{}{"slot": comp.Slot, "lsn": tx.lsn, "changes": len(tx.changes)}
+	convert := comp.Convert
+	if convert == nil {
+		convert = convertPostgresChange
+	}
+
+	msgs := []*accord.Message{}
+	for i, change := range tx.changes {
+		msg, err := convert(change)
+		if err != nil {
+			log.WithError(err).WithField("table", change.Schema+"."+change.Table).Warn("Unable to convert a row change into a message")
+			fields["error"] = err.Error()
+			comp.accord.Emit(EventPostgresFailed, "A row change could not be converted into a message", fields)
+			return true
+		}
+		if msg == nil {
+			continue
+		}
+		if msg.IdempotencyKey == "" {
+			msg.IdempotencyKey = "postgres/" + comp.Slot + "/" + tx.lsn + "/" + strconv.Itoa(i)
+		}
+		msgs = append(msgs, msg)
+	}
+	if len(msgs) == 0 {
+		return true
+	}
+
+	// Transactions are handled whole, so one of them being a duplicate means all of them are
+	err := comp.accord.HandleNewMessages(msgs...)
+	if err == nil || err == accord.ErrDuplicate {
+		log.WithField("messages", len(msgs)).Debug("Handled a transaction from our replication slot")
+		comp.accord.Emit(EventPostgresTransaction, "A transaction from the replication slot was handled", fields)
+		return true
+	}
+	if handleLater(err) {
+		log.WithError(err).Debug("A transaction from our replication slot can't be handled yet")
+		return false
+	}
+	log.WithError(err).Warn("A transaction from our replication slot was refused")
+	fields["error"] = err.Error()
+	comp.accord.Emit(EventPostgresFailed, "A transaction from the replication slot was refused", fields)
+	return true
+}
+
+// convertPostgresChange is PostgresSource's default Convert
+func convertPostgresChange(change PostgresChange) (*accord.Message, error) {
+	payload, err := json.Marshal(change)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := accord.NewMessage(payload)
+	if err != nil {
+		return nil, err
+	}
+	msg.Type = change.Schema + "." + change.Table
+	msg.Headers = map[string]string{
+		PostgresActionHeader: change.Action,
+		PostgresTableHeader:  msg.Type,
+		PostgresLSNHeader:    change.LSN,
+	}
+
+	values := change.Columns
+	if change.Action == "delete" {
+		values = change.Identity
+	}
+	key := []string{}
+	for _, column := range change.PrimaryKey {
+		key = append(key, fmt.Sprint(values[column]))
+	}
+	msg.Key = strings.Join(key, "/")
+	return msg, nil
+}
@@ -0,0 +1,145 @@
+package components
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Ssawa/accord/accord"
+	"github.com/Ssawa/accord/accord/accordtest"
+	"github.com/stretchr/testify/assert"
+)
+
+// replicationSlot is a database/sql connector standing in for a Postgres database with a single logical
+// replication slot, whose records are fed to it by the test
+type replicationSlot struct {
+	mutex   sync.Mutex
+	records [][2]string
+	created []string
+}
+
+// commit adds a transaction's records to the slot
+func (slot *replicationSlot) commit(lsn string, changes ...string) {
+	slot.mutex.Lock()
+	defer slot.mutex.Unlock()
+	slot.records = append(slot.records, [2]string{lsn, `{"action":"B"}`})
+	for _, change := range changes {
+		slot.records = append(slot.records, [2]string{lsn, change})
+	}
+	slot.records = append(slot.records, [2]string{lsn, `{"action":"C"}`})
+}
+
+func (slot *replicationSlot) pending() int {
+	slot.mutex.Lock()
+	defer slot.mutex.Unlock()
+	return len(slot.records)
+}
+
+func (slot *replicationSlot) Connect(context.Context) (driver.Conn, error) { return slot, nil }
+func (slot *replicationSlot) Driver() driver.Driver                        { return slot }
+func (slot *replicationSlot) Open(name string) (driver.Conn, error)        { return slot, nil }
+func (slot *replicationSlot) Close() error                                 { return nil }
+func (slot *replicationSlot) Begin() (driver.Tx, error)                    { return nil, errors.New("unsupported") }
+
+func (slot *replicationSlot) Prepare(query string) (driver.Stmt, error) {
+	return &slotStmt{slot: slot, query: query}, nil
+}
+
+type slotStmt struct {
+	slot  *replicationSlot
+	query string
+}
+
+func (stmt *slotStmt) Close() error  { return nil }
+func (stmt *slotStmt) NumInput() int { return -1 }
+
+func (stmt *slotStmt) Exec(args []driver.Value) (driver.Result, error) {
+	slot := stmt.slot
+	slot.mutex.Lock()
+	defer slot.mutex.Unlock()
+	switch {
+	case strings.Contains(stmt.query, "pg_create_logical_replication_slot"):
+		slot.created = append(slot.created, args[0].(string))
+	case strings.Contains(stmt.query, "pg_replication_slot_advance"):
+		// Our LSNs are all the same length, so they can be compared as strings
+		for len(slot.records) > 0 && slot.records[0][0] <= args[1].(string) {
+			slot.records = slot.records[1:]
+		}
+	default:
+		return nil, errors.New("unsupported statement: " + stmt.query)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (stmt *slotStmt) Query(args []driver.Value) (driver.Rows, error) {
+	slot := stmt.slot
+	slot.mutex.Lock()
+	defer slot.mutex.Unlock()
+	switch {
+	case strings.Contains(stmt.query, "pg_replication_slots"):
+		return &outboxRows{columns: []string{"count"}, values: [][]driver.Value{{int64(len(slot.created))}}}, nil
+	case strings.Contains(stmt.query, "pg_logical_slot_peek_changes"):
+		rows := &outboxRows{columns: []string{"lsn", "data"}}
+		for _, record := range slot.records {
+			rows.values = append(rows.values, []driver.Value{record[0], record[1]})
+		}
+		return rows, nil
+	}
+	return nil, errors.New("unsupported query: " + stmt.query)
+}
+
+func TestPostgresSource(t *testing.T) {
+	slot := &replicationSlot{}
+	db := sql.OpenDB(slot)
+	defer db.Close()
+
+	manager := &accordtest.Manager{}
+	source := &PostgresSource{DB: db, Slot: "accord", CreateSlot: true, Interval: 5 * time.Millisecond}
+	acc := accordtest.New(t, manager, source)
+	assert.Equal(t, []string{"accord"}, slot.created)
+
+	slot.commit("0/00000010",
+		`{"action":"I","schema":"public","table":"users","columns":[{"name":"id","type":"bigint","value":12345678901},{"name":"name","type":"text","value":"ada"}],"pk":[{"name":"id","type":"bigint"}]}`,
+		`{"action":"I","schema":"public","table":"teams","columns":[{"name":"id","type":"integer","value":1}],"pk":[{"name":"id","type":"integer"}]}`)
+	slot.commit("0/00000020",
+		`{"action":"D","schema":"public","table":"users","identity":[{"name":"id","type":"bigint","value":12345678901}],"pk":[{"name":"id","type":"bigint"}]}`)
+
+	accordtest.Eventually(t, 500*time.Millisecond, func() bool { return slot.pending() == 0 })
+	accordtest.AssertHistoryLen(t, acc, 3)
+	msg, err := acc.History().Get(2)
+	assert.Nil(t, err)
+	assert.Equal(t, "public.users", msg.Type)
+	assert.Equal(t, "12345678901", msg.Key)
+	assert.Equal(t, "insert", msg.Headers[PostgresActionHeader])
+	assert.Equal(t, "0/00000010", msg.Headers[PostgresLSNHeader])
+	change := PostgresChange{}
+	assert.Nil(t, json.Unmarshal(msg.Payload, &change))
+	assert.Equal(t, "ada", change.Columns["name"])
+
+	msg, err = acc.History().Get(0)
+	assert.Nil(t, err)
+	assert.Equal(t, "delete", msg.Headers[PostgresActionHeader])
+	assert.Equal(t, "12345678901", msg.Key)
+
+	// A transaction we handled but went down before consuming is dropped as a duplicate the second time
+	tx := postgresTransaction{lsn: "0/00000018", changes: []PostgresChange{{Action: "insert", Schema: "public", Table: "teams", LSN: "0/00000018"}}}
+	source.Convert = func(change PostgresChange) (*accord.Message, error) { return accord.NewMessage([]byte(change.Table)) }
+	assert.True(t, source.handle(tx))
+	assert.True(t, source.handle(tx))
+	accordtest.AssertHistoryLen(t, acc, 4)
+
+	// Transactions that can't be handled yet stay in the slot
+	acc.Pause()
+	slot.commit("0/00000030", `{"action":"U","schema":"public","table":"teams","columns":[{"name":"id","type":"integer","value":1}]}`)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, 3, slot.pending())
+	acc.Resume()
+	accordtest.Eventually(t, 500*time.Millisecond, func() bool { return slot.pending() == 0 })
+	accordtest.AssertHistoryLen(t, acc, 5)
+}
@@ -0,0 +1,133 @@
+package components
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Ssawa/accord/accord"
+)
+
+// Probes is an optional Component serving liveness and readiness probes backed by accord.Health, for
+// running a node under Kubernetes or anything else that probes over HTTP:
+//
+//	GET /livez   200 as long as the node is running and none of its Components has stopped, so that a
+//	             node that's wedged gets restarted
+//	GET /readyz  200 while the node is running, all of its Components are, and it can take new Messages:
+//	             it isn't read-only, paused or draining and its backlog is no more than MaxBacklog
+//
+// Either one responds with a 503 otherwise. Both describe why in a ProbeStatus
+type Probes struct {
+	accord.ComponentRunner
+
+	// BindAddress is the address our endpoints should be served on, defaulting to ":8086" as probes come
+	// from outside of the host. Set it to "-" to not start a server, and mount Handler on an existing one
+	// instead
+	BindAddress string
+
+	// MaxBacklog is how many Messages can be waiting to be synchronized before we stop reporting ready.
+	// Zero means there's no limit
+	MaxBacklog uint64
+
+	accord  *accord.Accord
+	log     accord.Logger
+	handler http.Handler
+	server  *backgroundServer
+}
+
+// ProbeStatus is what our probes respond with
+type ProbeStatus struct {
+	OK bool `json:"ok"`
+
+	// Reasons lists why we aren't live or ready, if we aren't
+	Reasons []string `json:"reasons,omitempty"`
+
+	Health accord.HealthReport `json:"health"`
+}
+
+// Start sets up our endpoints and starts serving them
+func (comp *Probes) Start(acc *accord.Accord) error {
+	comp.accord = acc
+	comp.log = acc.Logger.WithField("component", "Probes")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/livez", comp.livez)
+	mux.HandleFunc("/readyz", comp.readyz)
+	comp.handler = mux
+
+	address := comp.BindAddress
+	if address == "" {
+		address = ":8086"
+	}
+	if address != "-" {
+		comp.server = startServer(address, comp.handler, comp.log)
+	}
+
+	comp.ComponentRunner.Init(acc, comp.tick, comp.cleanup, comp.log)
+	return nil
+}
+
+// Handler returns our endpoints, for mounting on an existing HTTP server. It's only available after Start
+func (comp *Probes) Handler() http.Handler {
+	return comp.handler
+}
+
+// Our endpoints do all of our work, so our loop has nothing to do but wait to be stopped
+func (comp *Probes) tick(*accord.Accord) {
+	time.Sleep(tickResolution)
+}
+
+func (comp *Probes) cleanup(*accord.Accord) {
+	if comp.server != nil {
+		comp.server.stop()
+	}
+}
+
+func (comp *Probes) livez(w http.ResponseWriter, r *http.Request) {
+	health := comp.accord.Health()
+	comp.respond(w, health, comp.down(health))
+}
+
+func (comp *Probes) readyz(w http.ResponseWriter, r *http.Request) {
+	health := comp.accord.Health()
+	reasons := comp.down(health)
+	if health.ReadOnly {
+		reasons = append(reasons, "our data directory is read-only")
+	}
+	if health.Paused {
+		reasons = append(reasons, "we're paused")
+	}
+	if health.Draining {
+		reasons = append(reasons, "we're draining")
+	}
+	if comp.MaxBacklog > 0 && health.QueuedMessages > comp.MaxBacklog {
+		reasons = append(reasons, fmt.Sprintf("%d messages are waiting to be synchronized, more than %d", health.QueuedMessages, comp.MaxBacklog))
+	}
+	comp.respond(w, health, reasons)
+}
+
+// down lists why we aren't up at all: we aren't running, or one of our Components has stopped
+func (comp *Probes) down(health accord.HealthReport) []string {
+	reasons := []string{}
+	if health.Status != accord.HealthDown {
+		return reasons
+	}
+	for _, component := range health.Components {
+		if component.Status == accord.HealthDown {
+			reasons = append(reasons, fmt.Sprintf("component %s is %s", component.Name, component.Detail))
+		}
+	}
+	if len(reasons) == 0 {
+		reasons = append(reasons, "we aren't running")
+	}
+	return reasons
+}
+
+func (comp *Probes) respond(w http.ResponseWriter, health accord.HealthReport, reasons []string) {
+	status := ProbeStatus{OK: len(reasons) == 0, Reasons: reasons, Health: health}
+	if !status.OK {
+		writeJSON(w, http.StatusServiceUnavailable, status)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
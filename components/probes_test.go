@@ -0,0 +1,57 @@
+package components
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/Ssawa/accord/accord"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProbes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "accord-probes")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	probes := &Probes{BindAddress: "-", MaxBacklog: 1}
+	acc := accord.NewAccord(accord.NewDummerManager(), []accord.Component{probes}, dir, accord.DummyAccord().Logger)
+	assert.Nil(t, acc.Start())
+	defer acc.Stop()
+
+	server := httptest.NewServer(probes.Handler())
+	defer server.Close()
+
+	probe := func(path string) (int, ProbeStatus) {
+		status := ProbeStatus{}
+		resp, err := http.Get(server.URL + path)
+		assert.Nil(t, err)
+		defer resp.Body.Close()
+		assert.Nil(t, json.NewDecoder(resp.Body).Decode(&status))
+		return resp.StatusCode, status
+	}
+
+	code, status := probe("/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, status.OK)
+	assert.Equal(t, accord.HealthOK, status.Health.Status)
+
+	// A backlog over MaxBacklog makes us unready, but we're still alive
+	for i := 0; i < 2; i++ {
+		msg, _ := accord.NewMessage([]byte("hello"))
+		assert.Nil(t, acc.HandleNewMessage(msg))
+	}
+	code, status = probe("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, []string{"2 messages are waiting to be synchronized, more than 1"}, status.Reasons)
+	code, _ = probe("/livez")
+	assert.Equal(t, http.StatusOK, code)
+
+	acc.Pause()
+	code, status = probe("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Contains(t, status.Reasons, "we're paused")
+}
@@ -0,0 +1,469 @@
+package components
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Ssawa/accord/accord"
+)
+
+// raftDataKey is where RaftElection persists its term and vote
+const raftDataKey = "raft/election"
+
+// raftRole is the part a node is currently playing in an election
+type raftRole int
+
+const (
+	raftFollower raftRole = iota
+	raftCandidate
+	raftLeader
+)
+
+// raftPersisted is what Raft requires us to remember across restarts: without it we could vote twice in
+// the same term and let two leaders get elected
+type raftPersisted struct {
+	Term     uint64
+	VotedFor string
+}
+
+// raftVoteRequest asks a voter to vote for a candidate
+type raftVoteRequest struct {
+	Term      uint64
+	Candidate string
+}
+
+// raftHeartbeat is sent by a leader to assert its leadership
+type raftHeartbeat struct {
+	Term   uint64
+	Leader string
+}
+
+// raftResponse answers both vote requests and heartbeats
+type raftResponse struct {
+	Term    uint64
+	Granted bool
+}
+
+// RaftElection is a Component that elects a leader amongst a fixed set of Accord nodes using the leader
+// election half of Raft (we don't need Raft's replicated log; our Messages are synchronized by other
+// Components). It implements accord.LeaderElector, so Accord.IsLeader defers to it once it's registered.
+//
+// Every node starts out as a follower. A follower that hasn't heard from a leader within its (randomized)
+// election timeout becomes a candidate for the next term and asks everybody for their vote; each node only
+// votes once per term, so at most one candidate can win a majority. The winner sends heartbeats every
+// HeartbeatInterval to keep the others following. A leader that can't reach a majority for a whole
+// ElectionTimeout steps down on its own, so the minority side of a partition stops leading rather than
+// carrying on alongside the leader the majority elects.
+//
+// A cluster needs a majority of its nodes up to have a leader at all, so run at least three of them
+type RaftElection struct {
+	accord.ComponentRunner
+
+	// BindAddress is the address our endpoints should be served on. If it's left empty no server is started
+	// and Handler should be mounted on an existing one instead
+	BindAddress string
+
+	// Peers are the base URLs of every other voting node. Every node must be configured with the same set
+	Peers []string
+
+	// ElectionTimeout is how long a follower waits to hear from a leader before standing for election. The
+	// actual timeout is picked at random from between it and twice it, so that nodes rarely stand at the
+	// same time. It defaults to 1 second
+	ElectionTimeout time.Duration
+
+	// HeartbeatInterval is how often a leader asserts itself, defaulting to a fifth of ElectionTimeout
+	HeartbeatInterval time.Duration
+
+	// Client is used to talk to our peers, defaulting to a client that times out after half of
+	// ElectionTimeout
+	Client *http.Client
+
+	accord  *accord.Accord
+	log     accord.Logger
+	handler http.Handler
+	server  *backgroundServer
+
+	// mutex protects everything below, which is shared between our loop and our endpoints
+	mutex         sync.Mutex
+	role          raftRole
+	term          uint64
+	votedFor      string
+	leader        string
+	deadline      time.Time
+	lastHeartbeat time.Time
+	lastQuorum    time.Time
+
+	// events are emitted once our mutex is released, so that subscribers can safely call back into us
+	events []accord.Event
+}
+
+// Start loads our persisted term, sets up our endpoints and begins our background loop
+func (comp *RaftElection) Start(acc *accord.Accord) error {
+	comp.accord = acc
+	comp.log = acc.Logger.WithField("component", "RaftElection")
+
+	if comp.ElectionTimeout <= 0 {
+		comp.ElectionTimeout = time.Second
+	}
+	if comp.HeartbeatInterval <= 0 {
+		comp.HeartbeatInterval = comp.ElectionTimeout / 5
+	}
+	if comp.Client == nil {
+		comp.Client = &http.Client{Timeout: comp.ElectionTimeout / 2}
+	}
+
+	data, err := acc.LoadComponentData(raftDataKey)
+	if err != nil {
+		return err
+	}
+	persisted := raftPersisted{}
+	if data != nil {
+		err = json.Unmarshal(data, &persisted)
+		if err != nil {
+			return err
+		}
+	}
+
+	comp.role = raftFollower
+	comp.term = persisted.Term
+	comp.votedFor = persisted.VotedFor
+	comp.leader = ""
+	comp.resetDeadline()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/raft/vote", comp.vote)
+	mux.HandleFunc("/raft/heartbeat", comp.heartbeat)
+	comp.handler = guardPeers(acc, mux)
+
+	if comp.BindAddress != "" {
+		comp.server = startServer(comp.BindAddress, comp.handler, comp.log)
+	}
+
+	comp.ComponentRunner.Init(acc, comp.tick, comp.cleanup, comp.log)
+	return nil
+}
+
+// Handler returns our endpoints, for mounting on an existing HTTP server. It's only available after Start
+func (comp *RaftElection) Handler() http.Handler {
+	return comp.handler
+}
+
+// IsLeader reports whether we're currently the leader
+func (comp *RaftElection) IsLeader() bool {
+	comp.mutex.Lock()
+	defer comp.mutex.Unlock()
+	return comp.role == raftLeader
+}
+
+// Leader returns the NodeID of the leader we're following (or ourselves, if we're leading), or an empty
+// string if we don't know of one
+func (comp *RaftElection) Leader() string {
+	comp.mutex.Lock()
+	defer comp.mutex.Unlock()
+	return comp.leader
+}
+
+// Term returns the election term we're in
+func (comp *RaftElection) Term() uint64 {
+	comp.mutex.Lock()
+	defer comp.mutex.Unlock()
+	return comp.term
+}
+
+func (comp *RaftElection) tick(*accord.Accord) {
+	comp.mutex.Lock()
+	role := comp.role
+	now := time.Now()
+	var wait time.Duration
+
+	switch role {
+	case raftLeader:
+		if now.Sub(comp.lastQuorum) > comp.ElectionTimeout {
+			comp.log.WithField("term", comp.term).Warn("Lost touch with a majority of our peers, stepping down")
+			comp.becomeFollower(comp.term, "")
+			comp.unlock()
+			return
+		}
+		wait = comp.HeartbeatInterval - now.Sub(comp.lastHeartbeat)
+	default:
+		wait = comp.deadline.Sub(now)
+	}
+	comp.unlock()
+
+	if wait > 0 {
+		if wait > tickResolution {
+			wait = tickResolution
+		}
+		time.Sleep(wait)
+		return
+	}
+
+	if role == raftLeader {
+		comp.sendHeartbeats()
+	} else {
+		comp.standForElection()
+	}
+}
+
+func (comp *RaftElection) cleanup(*accord.Accord) {
+	comp.mutex.Lock()
+	if comp.role == raftLeader {
+		comp.becomeFollower(comp.term, "")
+	}
+	comp.unlock()
+
+	if comp.server != nil {
+		comp.server.stop()
+	}
+}
+
+// emit queues up an event to be emitted when our mutex is released. The caller must hold our mutex
+func (comp *RaftElection) emit(kind string, message string, fields map[string]interface{}) {
+	comp.events = append(comp.events, accord.Event{Kind: kind, Message: message, Fields: fields})
+}
+
+// unlock releases our mutex and then emits any events that were queued up while it was held
+func (comp *RaftElection) unlock() {
+	events := comp.events
+	comp.events = nil
+	comp.mutex.Unlock()
+
+	for _, event := range events {
+		comp.accord.Emit(event.Kind, event.Message, event.Fields)
+	}
+}
+
+// majority is how many votes (including our own) it takes to win an election
+func (comp *RaftElection) majority() int {
+	return (len(comp.Peers)+1)/2 + 1
+}
+
+// resetDeadline picks a new random election timeout. The caller must hold our mutex
+func (comp *RaftElection) resetDeadline() {
+	jitter := time.Duration(rand.Int63n(int64(comp.ElectionTimeout)))
+	comp.deadline = time.Now().Add(comp.ElectionTimeout + jitter)
+}
+
+// persist saves our term and vote. We have to do this before answering anybody about them. The caller
+// must hold our mutex
+func (comp *RaftElection) persist() error {
+	data, err := json.Marshal(raftPersisted{Term: comp.term, VotedFor: comp.votedFor})
+	if err != nil {
+		return err
+	}
+	return comp.accord.SaveComponentData(raftDataKey, data)
+}
+
+// becomeFollower moves us into a (possibly new) term as a follower. The caller must hold our mutex
+func (comp *RaftElection) becomeFollower(term uint64, leader string) {
+	if term > comp.term {
+		comp.term = term
+		comp.votedFor = ""
+		err := comp.persist()
+		if err != nil {
+			comp.log.WithError(err).Warn("Unable to persist our election term")
+		}
+	}
+
+	if comp.role == raftLeader {
+		comp.emit(accord.EventLeadershipLost, "We are no longer the leader", map[string]interface{}{"term": comp.term})
+	}
+	comp.role = raftFollower
+	comp.leader = leader
+	comp.resetDeadline()
+}
+
+// standForElection starts a new term with ourselves as the candidate and asks our peers for their votes
+func (comp *RaftElection) standForElection() {
+	comp.mutex.Lock()
+	comp.role = raftCandidate
+	comp.term++
+	comp.votedFor = comp.accord.NodeID
+	comp.leader = ""
+	comp.resetDeadline()
+	err := comp.persist()
+	request := raftVoteRequest{Term: comp.term, Candidate: comp.accord.NodeID}
+	comp.unlock()
+
+	if err != nil {
+		// Without our vote on record we can't safely stand, so wait for the next timeout and try again
+		comp.log.WithError(err).Warn("Unable to persist our vote")
+		return
+	}
+
+	comp.log.WithField("term", request.Term).Debug("Standing for election")
+	votes := 1 + comp.broadcast("/raft/vote", request)
+
+	comp.mutex.Lock()
+	defer comp.unlock()
+
+	// Somebody may have beaten us to it, or told us about a newer term, while we were waiting
+	if comp.role != raftCandidate || comp.term != request.Term || votes < comp.majority() {
+		return
+	}
+
+	comp.log.WithField("term", comp.term).WithField("votes", votes).Info("Elected leader")
+	comp.role = raftLeader
+	comp.leader = comp.accord.NodeID
+	comp.lastQuorum = time.Now()
+	comp.lastHeartbeat = time.Time{}
+	comp.emit(accord.EventLeaderElected, "We were elected leader", map[string]interface{}{"term": comp.term})
+}
+
+// sendHeartbeats asserts our leadership over our peers
+func (comp *RaftElection) sendHeartbeats() {
+	comp.mutex.Lock()
+	comp.lastHeartbeat = time.Now()
+	heartbeat := raftHeartbeat{Term: comp.term, Leader: comp.accord.NodeID}
+	comp.unlock()
+
+	sent := time.Now()
+	acks := 1 + comp.broadcast("/raft/heartbeat", heartbeat)
+
+	comp.mutex.Lock()
+	defer comp.unlock()
+	if comp.role == raftLeader && comp.term == heartbeat.Term && acks >= comp.majority() {
+		comp.lastQuorum = sent
+	}
+}
+
+// broadcast sends a request to every peer at once, returning how many of them granted it. Any peer that
+// tells us about a newer term makes us follow it
+func (comp *RaftElection) broadcast(path string, request interface{}) int {
+	body, err := json.Marshal(request)
+	if err != nil {
+		comp.log.WithError(err).Warn("Unable to encode election request")
+		return 0
+	}
+
+	responses := make(chan raftResponse, len(comp.Peers))
+	wg := sync.WaitGroup{}
+	for _, peer := range comp.Peers {
+		if !comp.accord.AllowPeer(peerAddress(peer)) {
+			continue
+		}
+
+		wg.Add(1)
+		go func(peer string) {
+			defer wg.Done()
+			response, err := comp.send(peer, path, body)
+			if err != nil {
+				comp.log.WithError(err).WithField("peer", peer).Debug("Unable to reach peer")
+				return
+			}
+			responses <- response
+		}(peer)
+	}
+	wg.Wait()
+	close(responses)
+
+	granted := 0
+	for response := range responses {
+		if response.Granted {
+			granted++
+		}
+
+		comp.mutex.Lock()
+		if response.Term > comp.term {
+			comp.becomeFollower(response.Term, "")
+		}
+		comp.unlock()
+	}
+	return granted
+}
+
+func (comp *RaftElection) send(peer string, path string, body []byte) (raftResponse, error) {
+	response := raftResponse{}
+
+	resp, err := peerClient(comp.accord, comp.Client, peer).Post(strings.TrimRight(peer, "/")+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return response, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return response, fmt.Errorf("peer responded with %s", resp.Status)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&response)
+	return response, err
+}
+
+// vote answers a candidate's request for our vote
+func (comp *RaftElection) vote(w http.ResponseWriter, r *http.Request) {
+	request := raftVoteRequest{}
+	if !comp.decode(w, r, &request) {
+		return
+	}
+
+	comp.mutex.Lock()
+	defer comp.unlock()
+
+	if request.Term > comp.term {
+		comp.becomeFollower(request.Term, "")
+	}
+
+	granted := false
+	if request.Term == comp.term && (comp.votedFor == "" || comp.votedFor == request.Candidate) {
+		comp.votedFor = request.Candidate
+		err := comp.persist()
+		if err != nil {
+			comp.log.WithError(err).Warn("Unable to persist our vote")
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		granted = true
+		comp.resetDeadline()
+	}
+
+	comp.respond(w, raftResponse{Term: comp.term, Granted: granted})
+}
+
+// heartbeat accepts a leader's assertion of its leadership
+func (comp *RaftElection) heartbeat(w http.ResponseWriter, r *http.Request) {
+	heartbeat := raftHeartbeat{}
+	if !comp.decode(w, r, &heartbeat) {
+		return
+	}
+
+	comp.mutex.Lock()
+	defer comp.unlock()
+
+	if heartbeat.Term < comp.term {
+		comp.respond(w, raftResponse{Term: comp.term})
+		return
+	}
+
+	if heartbeat.Term > comp.term || comp.role != raftFollower || comp.leader != heartbeat.Leader {
+		comp.log.WithField("term", heartbeat.Term).WithField("leader", heartbeat.Leader).Info("Following leader")
+	}
+	comp.becomeFollower(heartbeat.Term, heartbeat.Leader)
+	comp.respond(w, raftResponse{Term: comp.term, Granted: true})
+}
+
+// decode reads a request body, reporting peers that send us garbage
+func (comp *RaftElection) decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err != nil {
+		comp.accord.ReportViolation(r.RemoteAddr, accord.ViolationMalformed, "unreadable election request")
+		http.Error(w, "unreadable election request", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func (comp *RaftElection) respond(w http.ResponseWriter, response raftResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
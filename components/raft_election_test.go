@@ -0,0 +1,141 @@
+package components
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Ssawa/accord/accord"
+	"github.com/Ssawa/accord/accord/accordtest"
+	"github.com/stretchr/testify/assert"
+)
+
+type raftNode struct {
+	accord   *accord.Accord
+	election *RaftElection
+	server   *httptest.Server
+	late     *lateHandler
+	dir      string
+	stopped  bool
+}
+
+func newRaftNodes(t *testing.T, count int) []*raftNode {
+	nodes := make([]*raftNode, count)
+	for i := range nodes {
+		dir, err := ioutil.TempDir("", "accord-raft")
+		assert.Nil(t, err)
+
+		nodes[i] = &raftNode{dir: dir, late: &lateHandler{}}
+		nodes[i].server = httptest.NewServer(nodes[i].late)
+	}
+
+	for i, node := range nodes {
+		node.election = &RaftElection{ElectionTimeout: 30 * time.Millisecond, HeartbeatInterval: 5 * time.Millisecond}
+		for j, peer := range nodes {
+			if i != j {
+				node.election.Peers = append(node.election.Peers, peer.server.URL)
+			}
+		}
+
+		node.accord = accord.NewAccord(accord.NewDummerManager(), []accord.Component{node.election}, node.dir, accord.DummyAccord().Logger)
+		node.accord.NodeID = string(rune('a' + i))
+		assert.Nil(t, node.accord.Start())
+		node.late.handler = node.election.Handler()
+	}
+	return nodes
+}
+
+func (node *raftNode) stop() {
+	if node.stopped {
+		return
+	}
+	node.stopped = true
+	node.server.Close()
+	node.accord.Stop()
+	os.RemoveAll(node.dir)
+}
+
+// leaders returns the nodes that believe they're leading, making sure everybody else is following one of
+// them
+func leaders(nodes []*raftNode) []*raftNode {
+	found := []*raftNode{}
+	for _, node := range nodes {
+		if !node.stopped && node.accord.IsLeader() {
+			found = append(found, node)
+		}
+	}
+	if len(found) != 1 {
+		return found
+	}
+
+	for _, node := range nodes {
+		if !node.stopped && node.accord.Leader() != found[0].accord.NodeID {
+			return nil
+		}
+	}
+	return found
+}
+
+func TestRaftElectsOneLeader(t *testing.T) {
+	nodes := newRaftNodes(t, 3)
+	for _, node := range nodes {
+		defer node.stop()
+	}
+
+	accordtest.Eventually(t, 500*time.Millisecond, func() bool { return len(leaders(nodes)) == 1 })
+	first := leaders(nodes)[0]
+	term := first.election.Term()
+
+	// With the leader gone, the other two are still a majority and should elect a new one
+	first.stop()
+	accordtest.Eventually(t, 500*time.Millisecond, func() bool { return len(leaders(nodes)) == 1 })
+	assert.True(t, leaders(nodes)[0].election.Term() > term)
+}
+
+func TestRaftLeaderStepsDownWithoutMajority(t *testing.T) {
+	nodes := newRaftNodes(t, 3)
+	for _, node := range nodes {
+		defer node.stop()
+	}
+
+	accordtest.Eventually(t, 500*time.Millisecond, func() bool { return len(leaders(nodes)) == 1 })
+	leader := leaders(nodes)[0]
+
+	for _, node := range nodes {
+		if node != leader {
+			node.stop()
+		}
+	}
+	accordtest.Eventually(t, 500*time.Millisecond, func() bool { return !leader.accord.IsLeader() })
+}
+
+func TestRaftRemembersVotes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "accord-raft")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	acc := accord.NewAccord(accord.NewDummerManager(), nil, dir, accord.DummyAccord().Logger)
+	assert.Nil(t, acc.Start())
+	defer acc.Stop()
+
+	election := &RaftElection{ElectionTimeout: time.Hour}
+	assert.Nil(t, election.Start(acc))
+	election.mutex.Lock()
+	election.term = 7
+	election.votedFor = "somebody"
+	assert.Nil(t, election.persist())
+	election.mutex.Unlock()
+	election.Stop(0)
+	election.WaitForStop()
+
+	restarted := &RaftElection{ElectionTimeout: time.Hour}
+	assert.Nil(t, restarted.Start(acc))
+	defer func() {
+		restarted.Stop(0)
+		restarted.WaitForStop()
+	}()
+	assert.Equal(t, uint64(7), restarted.Term())
+	assert.Equal(t, "somebody", restarted.votedFor)
+}
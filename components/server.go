@@ -0,0 +1,133 @@
+package components
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Ssawa/accord/accord"
+)
+
+// serverShutdownTimeout is how long we give in flight requests to finish when shutting down a server
+const serverShutdownTimeout = 5 * time.Second
+
+// backgroundServer is a small wrapper around http.Server for Components that serve HTTP alongside their
+// main loop. It starts listening in a background goroutine and can be stopped synchronously, which makes
+// it a good fit for ComponentRunner's cleanup function
+type backgroundServer struct {
+	server *http.Server
+	done   chan struct{}
+	log    accord.Logger
+}
+
+// startServer begins serving the handler on the given address in the background
+func startServer(address string, handler http.Handler, log accord.Logger) *backgroundServer {
+	srv := &backgroundServer{
+		server: &http.Server{Addr: address, Handler: handler},
+		done:   make(chan struct{}),
+		log:    log,
+	}
+
+	log.WithField("address", address).Info("Starting HTTP server")
+	go func() {
+		defer close(srv.done)
+		err := srv.server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.WithError(err).Warn("HTTP server stopped unexpectedly")
+		}
+	}()
+
+	return srv
+}
+
+// stop gracefully shuts down the server and waits for it to finish
+func (srv *backgroundServer) stop() {
+	srv.log.Info("Shutting down HTTP server")
+
+	ctx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+	defer cancel()
+	srv.server.Shutdown(ctx)
+
+	<-srv.done
+	srv.log.Info("HTTP server safely shutdown")
+}
+
+// guardPeers wraps a handler so that it refuses requests from peers our PeerPolicy doesn't allow, whether
+// because they aren't on the allowlist or because they've been banned, and from peers that don't present a
+// token our PeerAuth accepts. The name of the peer a request was authenticated as is available to the
+// handler through authenticatedPeer
+func guardPeers(acc *accord.Accord, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !acc.AllowPeer(r.RemoteAddr) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		peer, ok := acc.AuthenticatePeer(r.RemoteAddr, bearerToken(r))
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if peer != "" {
+			r = r.WithContext(context.WithValue(r.Context(), peerContextKey{}, peer))
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// peerContextKey is where guardPeers keeps the name of the peer a request was authenticated as
+type peerContextKey struct{}
+
+// authenticatedPeer returns the name of the peer a request was authenticated as, or an empty string if our
+// peers don't authenticate themselves
+func authenticatedPeer(r *http.Request) string {
+	peer, _ := r.Context().Value(peerContextKey{}).(string)
+	return peer
+}
+
+// impersonating reports whether a peer that authenticated itself claims to be another one
+func impersonating(r *http.Request, claimed string) bool {
+	peer := authenticatedPeer(r)
+	return peer != "" && peer != claimed
+}
+
+// bearerToken returns the token a request was sent with in its Authorization header, if any
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(header[7:])
+}
+
+// peerClient returns a client that presents our token for the peer at the given URL (see
+// accord.Accord.PeerToken) with every request, or the client as it is when we don't have one
+func peerClient(acc *accord.Accord, client *http.Client, url string) *http.Client {
+	token := acc.PeerToken(peerAddress(url))
+	if token == "" {
+		return client
+	}
+
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	authorized := *client
+	authorized.Transport = &bearerTransport{token: token, next: next}
+	return &authorized
+}
+
+// bearerTransport adds an Authorization header to every request it sends
+type bearerTransport struct {
+	token string
+	next  http.RoundTripper
+}
+
+func (transport *bearerTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	// RoundTrippers mustn't change the requests they're handed
+	r = r.Clone(r.Context())
+	r.Header.Set("Authorization", "Bearer "+transport.token)
+	return transport.next.RoundTrip(r)
+}
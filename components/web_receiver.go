@@ -6,7 +6,6 @@ import (
 	"sync"
 
 	"github.com/Ssawa/accord/accord"
-	"github.com/sirupsen/logrus"
 )
 
 // WebReceiver is a Component that is responsible for starting an HTTP server and ingesting
@@ -33,7 +32,7 @@ type WebReceiver struct {
 	stopSignal *sync.Cond
 	stopping   bool
 	accord     *accord.Accord
-	log        *logrus.Entry
+	log        accord.Logger
 }
 
 // Start initializes our web routes and starts the HTTP server (it does *not*, however, assure
@@ -108,6 +107,10 @@ func (receiver *WebReceiver) newCommand(w http.ResponseWriter, r *http.Request)
 	}
 
 	err = receiver.accord.HandleNewMessage(msg)
+	if _, replica := err.(*accord.ReplicaError); replica {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 	if err != nil {
 		receiver.log.WithError(err).Warn("Error handling new message")
 		http.Error(w, err.Error(), 500)
@@ -0,0 +1,277 @@
+package components
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Ssawa/accord/accord"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// The headers every webhook request is sent with, besides its Content-Type
+const (
+	// WebhookSignatureHeader holds "sha256=" followed by the hex encoded HMAC-SHA256 of the request's
+	// timestamp, a ".", and its body, keyed with the webhook's Secret (see SignWebhook)
+	WebhookSignatureHeader = "X-Accord-Signature"
+
+	// WebhookTimestampHeader holds the Unix time the request was signed at, so that receivers can turn away
+	// requests that are replayed long after the fact
+	WebhookTimestampHeader = "X-Accord-Timestamp"
+
+	// WebhookMessageHeader holds the Message's ID. A Message can be delivered more than once (if we never
+	// hear back about an earlier attempt), so receivers should use it to spot repeats
+	WebhookMessageHeader = "X-Accord-Message-Id"
+
+	// WebhookKeyHeader holds the ID of the key the request was signed with (see accord.KeyID), as 8 hex
+	// digits, when the webhook signs with Keys rather than a single Secret
+	WebhookKeyHeader = "X-Accord-Key-Id"
+)
+
+// DefaultWebhookAttempts is how many times Webhook tries to deliver a Message before dead lettering it,
+// when MaxAttempts isn't set
+const DefaultWebhookAttempts = 5
+
+// Webhook is a Component that POSTs each queued Message as JSON to a URL, for pushing our operations into
+// systems that only speak webhooks. It's one more peer as far as Accord is concerned: it has a cursor of
+// its own (named Name) into every channel, and Messages stay queued until the endpoint has accepted them.
+//
+// Any 2xx response counts as delivered. Other 4xx responses (besides 408 and 429) mean the endpoint will
+// never take the Message, which is dead lettered straight away; anything else is retried with an
+// exponential backoff, and dead lettered once MaxAttempts attempts have failed. Messages on the same
+// channel shard are delivered one at a time, in order, so a Message being retried holds up the ones
+// queued behind it
+type Webhook struct {
+	accord.ComponentRunner
+
+	// Name is the name of our cursor (see Accord.AddPeer), defaulting to "webhook". Give each Webhook a
+	// name of its own if there's more than one
+	Name string
+
+	// URL is where Messages are POSTed to
+	URL string
+
+	// Secret signs every request (see WebhookSignatureHeader). Requests aren't signed if it's empty
+	Secret []byte
+
+	// Keys signs every request in place of Secret, with the Keyring's active key, so that the key can be
+	// rotated without receivers turning away requests in the meantime: they're told which key signed each
+	// request (see WebhookKeyHeader), and can accept the ones signed with a key they know was retired
+	// recently (see VerifyWebhook)
+	Keys *accord.Keyring
+
+	// MaxAttempts is how many times we try to deliver a Message before giving up on it, defaulting to
+	// DefaultWebhookAttempts
+	MaxAttempts int
+
+	// Backoff is how long we wait before the first retry, doubling every attempt after that up to
+	// MaxBackoff. They default to a second and a minute
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// Client is used to make our requests, defaulting to a client with a 10 second timeout
+	Client *http.Client
+
+	accord *accord.Accord
+	log    accord.Logger
+	cursor *accord.PeerCursor
+
+	// attempts counts the failed attempts at delivering each Message we're retrying, and retryAfter is
+	// when each of our cursors (by channel and shard) should next be tried
+	attempts   map[uint64]int
+	retryAfter map[*accord.PeerCursor]time.Time
+	cursors    []*accord.PeerCursor
+}
+
+// Start registers our cursor and begins delivering
+func (comp *Webhook) Start(acc *accord.Accord) error {
+	if comp.URL == "" {
+		return fmt.Errorf("Webhook needs a URL")
+	}
+	if comp.Name == "" {
+		comp.Name = "webhook"
+	}
+
+	comp.accord = acc
+	comp.log = acc.Logger.WithField("component", "Webhook").WithField("peer", comp.Name)
+	comp.attempts = map[uint64]int{}
+	comp.retryAfter = map[*accord.PeerCursor]time.Time{}
+	comp.cursor = acc.AddPeer(comp.Name)
+
+	comp.cursors = []*accord.PeerCursor{}
+	for _, channel := range append([]string{""}, acc.ChannelNames()...) {
+		comp.cursors = append(comp.cursors, comp.cursor.Channel(channel).Shards()...)
+	}
+
+	comp.ComponentRunner.Init(acc, comp.tick, nil, comp.log)
+	return nil
+}
+
+func (comp *Webhook) tick(*accord.Accord) {
+	sent := 0
+	for _, cursor := range comp.cursors {
+		if time.Now().Before(comp.retryAfter[cursor]) {
+			continue
+		}
+		if comp.deliverNext(cursor) {
+			sent++
+		}
+	}
+
+	// Only take a break once we're caught up
+	if sent == 0 {
+		time.Sleep(tickResolution)
+	}
+}
+
+// deliverNext tries to deliver the next Message waiting on a cursor, returning whether there was one and
+// it was dealt with (delivered or dead lettered)
+func (comp *Webhook) deliverNext(cursor *accord.PeerCursor) bool {
+	pending, err := cursor.Ship(1)
+	if err != nil {
+		comp.log.WithError(err).Warn("Unable to read the queue")
+		return false
+	}
+	if len(pending) == 0 {
+		return false
+	}
+	msg := pending[0]
+
+	span := comp.accord.TraceMessage(msg, "accord.transport", attribute.String("accord.peer", comp.Name), attribute.String("accord.transport", "webhook"))
+	defer span.End()
+
+	status, err := comp.post(msg)
+	if err == nil {
+		delete(comp.attempts, msg.ID)
+		delete(comp.retryAfter, cursor)
+		err = cursor.Ack(msg.ID)
+		if err == nil {
+			comp.accord.RecordSent(1)
+		}
+		return err == nil
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+
+	comp.attempts[msg.ID]++
+	attempts := comp.attempts[msg.ID]
+	if permanentStatus(status) || attempts >= comp.maxAttempts() {
+		accord.WithMessage(comp.log, msg).WithError(err).WithField("attempts", attempts).Warn("Giving up on delivering a message")
+		delete(comp.attempts, msg.ID)
+		delete(comp.retryAfter, cursor)
+		return cursor.Reject(fmt.Sprintf("webhook failed after %d attempts: %s", attempts, err), msg.ID) == nil
+	}
+
+	comp.accord.SampledDebug(accord.WithMessage(comp.log, msg).WithError(err).WithField("attempts", attempts), "Unable to deliver a message, retrying later")
+	comp.retryAfter[cursor] = time.Now().Add(comp.backoff(attempts))
+	cursor.Nack(msg.ID)
+	return false
+}
+
+// post sends a single Message to our URL, returning the status it was answered with (zero if we never got
+// an answer) and an error unless it was accepted
+func (comp *Webhook) post(msg *accord.Message) (int, error) {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, comp.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	req.Header.Set(WebhookMessageHeader, strconv.FormatUint(msg.ID, 10))
+	if comp.Keys != nil {
+		if id, signature, ok := comp.Keys.Sign(webhookSigned(timestamp, body)); ok {
+			req.Header.Set(WebhookKeyHeader, fmt.Sprintf("%08x", id))
+			req.Header.Set(WebhookSignatureHeader, "sha256="+hex.EncodeToString(signature))
+		}
+	} else if len(comp.Secret) > 0 {
+		req.Header.Set(WebhookSignatureHeader, SignWebhook(comp.Secret, timestamp, body))
+	}
+
+	client := comp.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// permanentStatus reports whether a response means the endpoint won't ever accept the Message
+func permanentStatus(status int) bool {
+	return status >= 400 && status < 500 && status != http.StatusRequestTimeout && status != http.StatusTooManyRequests
+}
+
+func (comp *Webhook) maxAttempts() int {
+	if comp.MaxAttempts <= 0 {
+		return DefaultWebhookAttempts
+	}
+	return comp.MaxAttempts
+}
+
+// backoff returns how long we wait after the given number of failed attempts
+func (comp *Webhook) backoff(attempts int) time.Duration {
+	backoff, max := comp.Backoff, comp.MaxBackoff
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+	if max <= 0 {
+		max = time.Minute
+	}
+	for i := 1; i < attempts && backoff < max; i++ {
+		backoff *= 2
+	}
+	if backoff > max {
+		backoff = max
+	}
+	return backoff
+}
+
+// SignWebhook returns the signature of a webhook request, as sent in WebhookSignatureHeader. Receivers can
+// compute it themselves (with hmac.Equal) to check that a request really came from us
+func SignWebhook(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(webhookSigned(timestamp, body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook checks the signature of a webhook request signed with a Keyring (see Webhook's Keys),
+// given its WebhookKeyHeader, WebhookTimestampHeader and WebhookSignatureHeader. It accepts requests signed
+// with any key still on keys, so receivers should add a new key to their Keyring with Rotate before the
+// webhook starts signing with it
+func VerifyWebhook(keys *accord.Keyring, keyID string, timestamp string, body []byte, signature string) bool {
+	id, err := strconv.ParseUint(keyID, 16, 32)
+	if err != nil || !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+	sum, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return false
+	}
+	return keys.Verify(uint32(id), webhookSigned(timestamp, body), sum)
+}
+
+// webhookSigned returns what a webhook request's signature covers
+func webhookSigned(timestamp string, body []byte) []byte {
+	return append([]byte(timestamp+"."), body...)
+}
@@ -0,0 +1,143 @@
+package components
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Ssawa/accord/accord"
+	"github.com/Ssawa/accord/accord/accordtest"
+	"github.com/stretchr/testify/assert"
+)
+
+// webhookReceiver is an endpoint that fails the first few requests it gets and then accepts the rest
+type webhookReceiver struct {
+	mutex     sync.Mutex
+	failWith  int
+	failures  int
+	received  []accord.Message
+	signature bool
+}
+
+func (receiver *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	body, _ := ioutil.ReadAll(r.Body)
+	receiver.signature = r.Header.Get(WebhookSignatureHeader) == SignWebhook([]byte("secret"), r.Header.Get(WebhookTimestampHeader), body)
+	if receiver.failures > 0 {
+		receiver.failures--
+		w.WriteHeader(receiver.failWith)
+		return
+	}
+
+	msg := accord.Message{}
+	json.Unmarshal(body, &msg)
+	receiver.received = append(receiver.received, msg)
+}
+
+func (receiver *webhookReceiver) count() int {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+	return len(receiver.received)
+}
+
+func startWebhook(t *testing.T, receiver *webhookReceiver) (*accord.Accord, *Webhook, func()) {
+	dir, err := ioutil.TempDir("", "accord-webhook")
+	assert.Nil(t, err)
+	server := httptest.NewServer(receiver)
+
+	acc := accord.NewAccord(accord.NewDummerManager(), nil, dir, accord.DummyAccord().Logger)
+	assert.Nil(t, acc.Start())
+	webhook := &Webhook{URL: server.URL, Secret: []byte("secret"), MaxAttempts: 3, Backoff: time.Millisecond}
+	assert.Nil(t, webhook.Start(acc))
+
+	return acc, webhook, func() {
+		webhook.Stop(0)
+		webhook.WaitForStop()
+		server.Close()
+		acc.Stop()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	receiver := &webhookReceiver{failWith: http.StatusServiceUnavailable, failures: 2}
+	acc, _, stop := startWebhook(t, receiver)
+	defer stop()
+
+	msg, err := accord.NewMessage([]byte("pushed"))
+	assert.Nil(t, err)
+	assert.Nil(t, acc.HandleNewMessage(msg))
+
+	accordtest.Eventually(t, 500*time.Millisecond, func() bool { return receiver.count() == 1 })
+	accordtest.Eventually(t, 500*time.Millisecond, func() bool { return acc.Queue().Len() == 0 })
+	receiver.mutex.Lock()
+	assert.Equal(t, msg.ID, receiver.received[0].ID)
+	assert.Equal(t, "pushed", string(receiver.received[0].Payload))
+	assert.True(t, receiver.signature)
+	receiver.mutex.Unlock()
+}
+
+func TestWebhookDeadLetters(t *testing.T) {
+	// An endpoint that keeps failing is given up on after MaxAttempts, and one that refuses a Message
+	// straight away
+	for _, status := range []int{http.StatusInternalServerError, http.StatusUnprocessableEntity} {
+		receiver := &webhookReceiver{failWith: status, failures: 1000}
+		acc, _, stop := startWebhook(t, receiver)
+
+		msg, err := accord.NewMessage([]byte("refused"))
+		assert.Nil(t, err)
+		assert.Nil(t, acc.HandleNewMessage(msg))
+
+		accordtest.Eventually(t, 500*time.Millisecond, func() bool { return acc.Queue().Len() == 0 })
+		letters, err := acc.DeadLetters()
+		assert.Nil(t, err)
+		assert.Len(t, letters, 1)
+		assert.Equal(t, "webhook", letters[0].Peer)
+		assert.Equal(t, 0, receiver.count())
+
+		receiver.mutex.Lock()
+		if status == http.StatusUnprocessableEntity {
+			assert.Equal(t, 999, receiver.failures)
+		} else {
+			assert.Equal(t, 997, receiver.failures)
+		}
+		receiver.mutex.Unlock()
+		stop()
+	}
+}
+
+func TestWebhookKeys(t *testing.T) {
+	keys, err := accord.NewKeyring([]byte("old"))
+	assert.Nil(t, err)
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+	}))
+	defer server.Close()
+	webhook := &Webhook{URL: server.URL, Keys: keys}
+
+	msg, err := accord.NewMessage([]byte("signed"))
+	assert.Nil(t, err)
+	_, err = webhook.post(msg)
+	assert.Nil(t, err)
+	body, _ := json.Marshal(msg)
+	old := header.Get(WebhookKeyHeader)
+	timestamp, signature := header.Get(WebhookTimestampHeader), header.Get(WebhookSignatureHeader)
+	assert.True(t, VerifyWebhook(keys, old, timestamp, body, signature))
+	assert.False(t, VerifyWebhook(keys, old, timestamp, []byte("tampered"), signature))
+
+	// Requests signed with the old key are still accepted once the new one has taken over
+	assert.Nil(t, keys.Rotate([]byte("new")))
+	_, err = webhook.post(msg)
+	assert.Nil(t, err)
+	assert.NotEqual(t, old, header.Get(WebhookKeyHeader))
+	assert.True(t, VerifyWebhook(keys, header.Get(WebhookKeyHeader), header.Get(WebhookTimestampHeader), body, header.Get(WebhookSignatureHeader)))
+	assert.True(t, VerifyWebhook(keys, old, timestamp, body, signature))
+}
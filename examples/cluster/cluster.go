@@ -0,0 +1,309 @@
+package cluster
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Ssawa/accord/accord"
+)
+
+// Topology is how the nodes of a cluster are connected to each other
+type Topology int
+
+const (
+	// Hub makes the first node a hub that every other node synchronizes with, relaying Messages between
+	// them
+	Hub Topology = iota
+
+	// Mesh has every node synchronize directly with every other node
+	Mesh
+)
+
+// Options describes the cluster to build
+type Options struct {
+	// Nodes is how many nodes to run, defaulting to 3
+	Nodes int
+
+	// Topology is how the nodes are connected, defaulting to Hub
+	Topology Topology
+
+	// Launcher is what actually runs the nodes, defaulting to InProcess
+	Launcher Launcher
+
+	// Dir is where the nodes store their data. If it's left empty a temporary directory is used and
+	// removed again when the cluster is stopped
+	Dir string
+
+	// BasePort is the first port nodes listen on, with each following node using the next one. If it's left
+	// as zero, free ports are picked automatically
+	BasePort int
+
+	// AntiEntropyInterval is how often nodes compare digests, defaulting to 2 seconds
+	AntiEntropyInterval time.Duration
+
+	// SyncRetryInterval is how long nodes wait before sending Messages a peer couldn't take again,
+	// defaulting to a second. Nodes come up one after the other, so the first few sends usually fail
+	SyncRetryInterval time.Duration
+
+	// Logger is used by the harness and by nodes run in process. It defaults to discarding everything
+	Logger accord.Logger
+}
+
+// Launcher runs the nodes of a cluster. InProcess, Processes and Compose are provided
+type Launcher interface {
+	// Launch starts every node and returns once they've all been started (they may not be accepting
+	// requests yet; the Cluster waits for that itself)
+	Launch(specs []NodeSpec, logger accord.Logger) error
+
+	// Shutdown stops every node that was launched
+	Shutdown() error
+}
+
+// Cluster is a demo cluster of Accord nodes talking to each other over HTTP, along with everything needed
+// to drive a workload against it and check that it converges. It serves both as living documentation of
+// how to put a multi-node deployment together and as our end to end test
+type Cluster struct {
+	Options Options
+
+	// Specs describes every node in the cluster. They're available once the cluster has been created
+	Specs []NodeSpec
+
+	client  *http.Client
+	tempDir bool
+}
+
+// New lays out a cluster without starting it
+func New(opts Options) (*Cluster, error) {
+	if opts.Nodes <= 0 {
+		opts.Nodes = 3
+	}
+	if opts.Launcher == nil {
+		opts.Launcher = &InProcess{}
+	}
+	if opts.AntiEntropyInterval <= 0 {
+		opts.AntiEntropyInterval = 2 * time.Second
+	}
+	if opts.SyncRetryInterval <= 0 {
+		opts.SyncRetryInterval = time.Second
+	}
+	if opts.Logger == nil {
+		opts.Logger = accord.NopLogger()
+	}
+
+	cluster := &Cluster{Options: opts, client: &http.Client{Timeout: 5 * time.Second}}
+	if opts.Dir == "" {
+		dir, err := ioutil.TempDir("", "accord-cluster")
+		if err != nil {
+			return nil, err
+		}
+		cluster.Options.Dir = dir
+		cluster.tempDir = true
+	}
+
+	specs, err := Layout(cluster.Options)
+	if err != nil {
+		return nil, err
+	}
+	cluster.Specs = specs
+	return cluster, nil
+}
+
+// Layout works out the spec of every node in a cluster with the given options, without launching anything
+func Layout(opts Options) ([]NodeSpec, error) {
+	specs := make([]NodeSpec, opts.Nodes)
+	for i := range specs {
+		port := opts.BasePort + i
+		if opts.BasePort == 0 {
+			var err error
+			port, err = freePort()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		specs[i] = NodeSpec{
+			Name:                fmt.Sprintf("node-%d", i),
+			ListenAddress:       fmt.Sprintf("127.0.0.1:%d", port),
+			URL:                 fmt.Sprintf("http://127.0.0.1:%d", port),
+			DataDir:             filepath.Join(opts.Dir, fmt.Sprintf("node-%d", i)),
+			AntiEntropyInterval: opts.AntiEntropyInterval,
+			SyncRetryInterval:   opts.SyncRetryInterval,
+		}
+	}
+
+	for i := range specs {
+		for j := range specs {
+			if i == j {
+				continue
+			}
+			peer := PeerSpec{Name: specs[j].Name, URL: specs[j].URL}
+
+			// In a hub, edge nodes only talk to the hub
+			if opts.Topology == Hub && i != 0 && j != 0 {
+				continue
+			}
+			specs[i].Peers = append(specs[i].Peers, peer)
+			specs[i].AntiEntropyPeers = append(specs[i].AntiEntropyPeers, peer)
+		}
+	}
+	if opts.Topology == Hub {
+		specs[0].Relay = true
+	}
+
+	return specs, nil
+}
+
+// freePort asks the operating system for a port nobody is listening on
+func freePort() (int, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+// Start launches every node and waits for them all to respond
+func (cluster *Cluster) Start() error {
+	err := cluster.Options.Launcher.Launch(cluster.Specs, cluster.Options.Logger)
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(30 * time.Second)
+	for i := range cluster.Specs {
+		for {
+			_, err = cluster.Status(i)
+			if err == nil {
+				break
+			}
+			if time.Now().After(deadline) {
+				cluster.Stop()
+				return fmt.Errorf("%s never came up: %v", cluster.Specs[i].Name, err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	return nil
+}
+
+// Stop shuts every node down, removing their data if it was stored in a temporary directory
+func (cluster *Cluster) Stop() error {
+	err := cluster.Options.Launcher.Shutdown()
+	if cluster.tempDir {
+		os.RemoveAll(cluster.Options.Dir)
+	}
+	return err
+}
+
+// Submit creates a new Message on the given node
+func (cluster *Cluster) Submit(node int, payload []byte) error {
+	resp, err := cluster.client.Post(cluster.Specs[node].URL+"/submit", "application/octet-stream", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s refused a message: %s %s", cluster.Specs[node].Name, resp.Status, body)
+	}
+	return nil
+}
+
+// Status asks a node how it's doing
+func (cluster *Cluster) Status(node int) (NodeStatus, error) {
+	status := NodeStatus{}
+
+	resp, err := cluster.client.Get(cluster.Specs[node].URL + "/status")
+	if err != nil {
+		return status, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return status, fmt.Errorf("%s responded with %s", cluster.Specs[node].Name, resp.Status)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&status)
+	return status, err
+}
+
+// Workload is a scripted series of Messages to submit to a cluster
+type Workload struct {
+	// Messages is how many Messages to submit. They're spread evenly over every node
+	Messages int
+
+	// Interval is how long to wait between submitting each Message
+	Interval time.Duration
+}
+
+// Run submits the workload to a cluster, returning the payloads that were submitted
+func (workload Workload) Run(cluster *Cluster) ([]string, error) {
+	payloads := []string{}
+	for i := 0; i < workload.Messages; i++ {
+		node := i % len(cluster.Specs)
+		payload := fmt.Sprintf("%s message %d", cluster.Specs[node].Name, i)
+
+		err := cluster.Submit(node, []byte(payload))
+		if err != nil {
+			return payloads, err
+		}
+		payloads = append(payloads, payload)
+
+		if workload.Interval > 0 {
+			time.Sleep(workload.Interval)
+		}
+	}
+	return payloads, nil
+}
+
+// WaitForConvergence waits until every node has performed the same number of Messages, at least
+// expected of them, and all of their digests agree
+func (cluster *Cluster) WaitForConvergence(expected uint64, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		statuses, err := cluster.statuses()
+		if err == nil && converged(statuses, expected) {
+			cluster.Options.Logger.WithField("digest", statuses[0].DigestRoot).Info("Cluster converged")
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("cluster did not converge within %s: %+v", timeout, statuses)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (cluster *Cluster) statuses() ([]NodeStatus, error) {
+	statuses := make([]NodeStatus, len(cluster.Specs))
+	for i := range cluster.Specs {
+		var err error
+		statuses[i], err = cluster.Status(i)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return statuses, nil
+}
+
+func converged(statuses []NodeStatus, expected uint64) bool {
+	for _, status := range statuses {
+		if status.History < expected || status.History != statuses[0].History || status.DigestRoot != statuses[0].DigestRoot {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,67 @@
+package cluster
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClusterConverges(t *testing.T) {
+	for _, topology := range []Topology{Hub, Mesh} {
+		launcher := &InProcess{}
+		demo, err := New(Options{Nodes: 3, Topology: topology, Launcher: launcher, SyncRetryInterval: 10 * time.Millisecond})
+		assert.Nil(t, err)
+		assert.Nil(t, demo.Start())
+
+		payloads, err := Workload{Messages: 12}.Run(demo)
+		assert.Nil(t, err)
+		assert.Nil(t, demo.WaitForConvergence(uint64(len(payloads)), 5*time.Second))
+
+		// Every node's Manager should have seen every message exactly once
+		sort.Strings(payloads)
+		for _, node := range launcher.Nodes() {
+			processed := node.Manager.Payloads()
+			sort.Strings(processed)
+			assert.Equal(t, payloads, processed, node.Spec.Name)
+		}
+
+		assert.Nil(t, demo.Stop())
+	}
+}
+
+func TestLayoutHub(t *testing.T) {
+	specs, err := Layout(Options{Nodes: 3, BasePort: 7000, Dir: "data"})
+	assert.Nil(t, err)
+
+	assert.True(t, specs[0].Relay)
+	assert.Len(t, specs[0].Peers, 2)
+	assert.Equal(t, []PeerSpec{{Name: "node-0", URL: "http://127.0.0.1:7000"}}, specs[2].Peers)
+	assert.Equal(t, "127.0.0.1:7002", specs[2].ListenAddress)
+}
+
+func TestArgsRoundTrip(t *testing.T) {
+	specs, err := Layout(Options{Nodes: 2, BasePort: 7000, Dir: "data", AntiEntropyInterval: time.Second})
+	assert.Nil(t, err)
+
+	parsed, err := ParseArgs(specs[0].Args())
+	assert.Nil(t, err)
+	assert.Equal(t, specs[0], parsed)
+}
+
+func TestWriteCompose(t *testing.T) {
+	specs, err := Layout(Options{Nodes: 2, BasePort: 7000, Dir: "data"})
+	assert.Nil(t, err)
+
+	var buf bytes.Buffer
+	assert.Nil(t, WriteCompose(&buf, specs, "/src/accord"))
+	compose := buf.String()
+
+	assert.True(t, strings.Contains(compose, "  node-1:\n"))
+	assert.True(t, strings.Contains(compose, `"7001:7001"`))
+	assert.True(t, strings.Contains(compose, `"-peer", "node-0=http://node-0:7000"`))
+	assert.True(t, strings.Contains(compose, "context: /src/accord"))
+}
@@ -0,0 +1,40 @@
+// Command accord-node runs a single node of the demo cluster. It's normally launched by the cluster
+// harness (see github.com/Ssawa/accord/examples/cluster), but can just as well be run by hand:
+//
+//	accord-node -name hub -listen 127.0.0.1:7000 -url http://127.0.0.1:7000 -relay \
+//		-peer edge=http://127.0.0.1:7001 -anti-entropy-peer edge=http://127.0.0.1:7001
+//	accord-node -name edge -listen 127.0.0.1:7001 -url http://127.0.0.1:7001 -data edge-data \
+//		-peer hub=http://127.0.0.1:7000 -anti-entropy-peer hub=http://127.0.0.1:7000
+//
+// Messages can then be created with "curl -d hello http://127.0.0.1:7000/submit"
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/Ssawa/accord/accord/logging"
+	"github.com/Ssawa/accord/examples/cluster"
+	"github.com/sirupsen/logrus"
+)
+
+func main() {
+	logger := logrus.NewEntry(logrus.New())
+
+	spec, err := cluster.ParseArgs(os.Args[1:])
+	if err != nil {
+		logger.WithError(err).Fatal("Invalid arguments")
+	}
+
+	node, err := cluster.StartNode(spec, logging.NewLogrus(logger))
+	if err != nil {
+		logger.WithError(err).Fatal("Unable to start node")
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+
+	node.Stop()
+}
@@ -0,0 +1,73 @@
+// Command cluster-demo launches a demo cluster, drives a workload against it and checks that every node
+// converges on the same state:
+//
+//	cluster-demo -nodes 5 -messages 200 -mode process
+//
+// Nodes can be run in this process ("inprocess"), as separate accord-node processes ("process") or as
+// containers with Docker Compose ("compose", run from the root of the repository)
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Ssawa/accord/accord/logging"
+	"github.com/Ssawa/accord/examples/cluster"
+	"github.com/sirupsen/logrus"
+)
+
+func main() {
+	nodes := flag.Int("nodes", 3, "how many nodes to run")
+	messages := flag.Int("messages", 100, "how many messages to submit")
+	mode := flag.String("mode", "inprocess", "how to run the nodes: inprocess, process or compose")
+	mesh := flag.Bool("mesh", false, "connect every node to every other, rather than through a hub")
+	timeout := flag.Duration("timeout", time.Minute, "how long to wait for the cluster to converge")
+	flag.Parse()
+
+	logger := logrus.NewEntry(logrus.New())
+
+	opts := cluster.Options{Nodes: *nodes, Logger: logging.NewLogrus(logger)}
+	if *mesh {
+		opts.Topology = cluster.Mesh
+	}
+
+	switch *mode {
+	case "inprocess":
+		opts.Launcher = &cluster.InProcess{}
+	case "process":
+		opts.Launcher = &cluster.Processes{Output: os.Stderr}
+	case "compose":
+		// Containers publish their ports on the host, so they need to be predictable
+		opts.BasePort = 7000
+		opts.Launcher = &cluster.Compose{Context: ".", Output: os.Stderr}
+	default:
+		logger.Fatalf("Unknown mode %q", *mode)
+	}
+
+	demo, err := cluster.New(opts)
+	if err != nil {
+		logger.WithError(err).Fatal("Unable to lay out the cluster")
+	}
+
+	err = demo.Start()
+	if err != nil {
+		logger.WithError(err).Fatal("Unable to start the cluster")
+	}
+	defer demo.Stop()
+
+	payloads, err := cluster.Workload{Messages: *messages}.Run(demo)
+	if err != nil {
+		demo.Stop()
+		logger.WithError(err).Fatal("The workload failed")
+	}
+
+	err = demo.WaitForConvergence(uint64(len(payloads)), *timeout)
+	if err != nil {
+		demo.Stop()
+		logger.WithError(err).Fatal("The cluster did not converge")
+	}
+
+	fmt.Printf("%d nodes converged on %d messages\n", len(demo.Specs), len(payloads))
+}
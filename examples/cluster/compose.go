@@ -0,0 +1,157 @@
+package cluster
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/Ssawa/accord/accord"
+)
+
+// composeTemplate is the docker-compose file we generate for a cluster. Every node is built from the
+// Dockerfile next to this file and publishes its port on the host, so the harness can reach it the same
+// way it would reach a process
+var composeTemplate = template.Must(template.New("compose").Parse(`# Generated by github.com/Ssawa/accord/examples/cluster, do not edit
+version: "3"
+services:
+{{- range .Services}}
+  {{.Name}}:
+    build:
+      context: {{$.Context}}
+      dockerfile: examples/cluster/Dockerfile
+    command: [{{.Command}}]
+    ports:
+      - "{{.Port}}:{{.Port}}"
+    volumes:
+      - {{.Name}}-data:/data
+{{- end}}
+volumes:
+{{- range .Services}}
+  {{.Name}}-data:
+{{- end}}
+`))
+
+// composeService is a node as it's described in the compose file
+type composeService struct {
+	Name    string
+	Command string
+	Port    string
+}
+
+// composeFile is everything our template needs
+type composeFile struct {
+	Services []composeService
+	Context  string
+}
+
+// WriteCompose writes a docker-compose file running the given nodes, with context being the path to the
+// root of the Accord repository. Inside of the containers nodes reach each other by their service names,
+// while their ports are published on the host so that they can still be reached at the URLs in their
+// specs
+func WriteCompose(w io.Writer, specs []NodeSpec, context string) error {
+	ports := map[string]string{}
+	for _, spec := range specs {
+		_, port, err := net.SplitHostPort(spec.ListenAddress)
+		if err != nil {
+			return err
+		}
+		ports[spec.Name] = port
+	}
+
+	containerURL := func(name string) string {
+		return fmt.Sprintf("http://%s:%s", name, ports[name])
+	}
+
+	services := []composeService{}
+	for _, spec := range specs {
+		container := spec
+		container.ListenAddress = "0.0.0.0:" + ports[spec.Name]
+		container.URL = containerURL(spec.Name)
+		container.DataDir = "/data"
+		container.Peers = nil
+		for _, peer := range spec.Peers {
+			container.Peers = append(container.Peers, PeerSpec{Name: peer.Name, URL: containerURL(peer.Name)})
+		}
+		container.AntiEntropyPeers = nil
+		for _, peer := range spec.AntiEntropyPeers {
+			container.AntiEntropyPeers = append(container.AntiEntropyPeers, PeerSpec{Name: peer.Name, URL: containerURL(peer.Name)})
+		}
+
+		args := []string{}
+		for _, arg := range container.Args() {
+			args = append(args, fmt.Sprintf("%q", arg))
+		}
+
+		services = append(services, composeService{
+			Name:    spec.Name,
+			Command: strings.Join(args, ", "),
+			Port:    ports[spec.Name],
+		})
+	}
+
+	return composeTemplate.Execute(w, composeFile{Services: services, Context: context})
+}
+
+// Compose runs every node as a container using Docker Compose. It needs a docker installation with the
+// compose plugin
+type Compose struct {
+	// Context is the path to the root of the Accord repository, which the node image is built from
+	Context string
+
+	// File is where the generated compose file is written, defaulting to docker-compose.yml in the
+	// current directory
+	File string
+
+	// Project is the compose project name, defaulting to "accord-cluster"
+	Project string
+
+	// Output is where docker's output is written, defaulting to discarding it
+	Output io.Writer
+}
+
+// Launch writes the compose file and brings the containers up
+func (launcher *Compose) Launch(specs []NodeSpec, logger accord.Logger) error {
+	if launcher.File == "" {
+		launcher.File = "docker-compose.yml"
+	}
+	if launcher.Project == "" {
+		launcher.Project = "accord-cluster"
+	}
+
+	context, err := filepath.Abs(launcher.Context)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(launcher.File)
+	if err != nil {
+		return err
+	}
+	err = WriteCompose(file, specs, context)
+	file.Close()
+	if err != nil {
+		return err
+	}
+
+	logger.WithField("file", launcher.File).Info("Starting compose cluster")
+	return launcher.compose("up", "-d", "--build")
+}
+
+// Shutdown brings the containers down and removes their volumes
+func (launcher *Compose) Shutdown() error {
+	return launcher.compose("down", "-v")
+}
+
+func (launcher *Compose) compose(args ...string) error {
+	cmd := exec.Command("docker", append([]string{"compose", "-f", launcher.File, "-p", launcher.Project}, args...)...)
+	if launcher.Output != nil {
+		cmd.Stdout = launcher.Output
+		cmd.Stderr = launcher.Output
+	}
+	return cmd.Run()
+}
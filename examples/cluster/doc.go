@@ -0,0 +1,19 @@
+// Package cluster is an end to end example of running Accord across several machines. It contains a demo
+// node (see StartNode and the accord-node command), which synchronizes with its peers using the HTTPSync
+// and AntiEntropy Components, and a harness that lays out a cluster of them, launches it, drives a
+// scripted workload and checks that every node converges on the same state.
+//
+// Nodes can be launched in the current process (InProcess), as separate processes (Processes) or as
+// containers with Docker Compose (Compose), all through the same API:
+//
+//	demo, err := cluster.New(cluster.Options{Nodes: 3, Launcher: &cluster.Processes{}})
+//	...
+//	err = demo.Start()
+//	defer demo.Stop()
+//
+//	payloads, err := cluster.Workload{Messages: 100}.Run(demo)
+//	err = demo.WaitForConvergence(uint64(len(payloads)), time.Minute)
+//
+// The cluster-demo command does exactly this from the command line, and this package's own tests do it in
+// process
+package cluster
@@ -0,0 +1,201 @@
+package cluster
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Ssawa/accord/accord"
+)
+
+// NodePackage is the import path of the demo node binary
+const NodePackage = "github.com/Ssawa/accord/examples/cluster/cmd/accord-node"
+
+// InProcess runs every node inside of the current process. It's the quickest way to run a cluster, and
+// what our own tests use
+type InProcess struct {
+	nodes []*Node
+}
+
+// Launch starts every node
+func (launcher *InProcess) Launch(specs []NodeSpec, logger accord.Logger) error {
+	for _, spec := range specs {
+		node, err := StartNode(spec, logger)
+		if err != nil {
+			launcher.Shutdown()
+			return err
+		}
+		launcher.nodes = append(launcher.nodes, node)
+	}
+	return nil
+}
+
+// Shutdown stops every node
+func (launcher *InProcess) Shutdown() error {
+	for _, node := range launcher.nodes {
+		node.Stop()
+	}
+	launcher.nodes = nil
+	return nil
+}
+
+// Nodes returns the running nodes, so that tests can reach inside of them
+func (launcher *InProcess) Nodes() []*Node {
+	return launcher.nodes
+}
+
+// Processes runs every node as a separate process of the accord-node binary, which is closer to a real
+// deployment and lets nodes be killed and restarted independently
+type Processes struct {
+	// Binary is the path to the accord-node binary. If it's left empty the binary is built with "go build"
+	// when the cluster is launched
+	Binary string
+
+	// Output is where the nodes' logs are written, defaulting to discarding them
+	Output io.Writer
+
+	processes []*exec.Cmd
+}
+
+// Launch starts a process for every node
+func (launcher *Processes) Launch(specs []NodeSpec, logger accord.Logger) error {
+	if launcher.Binary == "" {
+		dir, err := ioutil.TempDir("", "accord-node")
+		if err != nil {
+			return err
+		}
+		launcher.Binary, err = BuildNode(dir)
+		if err != nil {
+			return err
+		}
+	}
+
+	output := launcher.Output
+	if output == nil {
+		output = ioutil.Discard
+	}
+
+	for _, spec := range specs {
+		cmd := exec.Command(launcher.Binary, spec.Args()...)
+		cmd.Stdout = output
+		cmd.Stderr = output
+
+		logger.WithField("node", spec.Name).Info("Launching node process")
+		err := cmd.Start()
+		if err != nil {
+			launcher.Shutdown()
+			return err
+		}
+		launcher.processes = append(launcher.processes, cmd)
+	}
+	return nil
+}
+
+// Shutdown interrupts every process, killing any that don't stop within ten seconds
+func (launcher *Processes) Shutdown() error {
+	for _, cmd := range launcher.processes {
+		cmd.Process.Signal(os.Interrupt)
+
+		done := make(chan struct{})
+		go func() {
+			cmd.Wait()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			cmd.Process.Kill()
+			<-done
+		}
+	}
+	launcher.processes = nil
+	return nil
+}
+
+// BuildNode builds the accord-node binary into dir, returning its path
+func BuildNode(dir string) (string, error) {
+	binary := filepath.Join(dir, "accord-node")
+	output, err := exec.Command("go", "build", "-o", binary, NodePackage).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("unable to build %s: %v: %s", NodePackage, err, output)
+	}
+	return binary, nil
+}
+
+// Args turns the spec into the command line arguments accord-node understands
+func (spec NodeSpec) Args() []string {
+	args := []string{
+		"-name", spec.Name,
+		"-listen", spec.ListenAddress,
+		"-url", spec.URL,
+		"-data", spec.DataDir,
+		"-anti-entropy-interval", spec.AntiEntropyInterval.String(),
+		"-sync-retry-interval", spec.SyncRetryInterval.String(),
+	}
+	for _, peer := range spec.Peers {
+		args = append(args, "-peer", peer.Name+"="+peer.URL)
+	}
+	for _, peer := range spec.AntiEntropyPeers {
+		args = append(args, "-anti-entropy-peer", peer.Name+"="+peer.URL)
+	}
+	if spec.Relay {
+		args = append(args, "-relay")
+	}
+	return args
+}
+
+// peerFlags collects repeated "name=url" flags
+type peerFlags []PeerSpec
+
+func (peers *peerFlags) String() string {
+	list := []string{}
+	for _, peer := range *peers {
+		list = append(list, peer.Name+"="+peer.URL)
+	}
+	return strings.Join(list, ",")
+}
+
+func (peers *peerFlags) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("peers should look like name=url, not %q", value)
+	}
+	*peers = append(*peers, PeerSpec{Name: parts[0], URL: parts[1]})
+	return nil
+}
+
+// ParseArgs reads a NodeSpec back out of the arguments produced by Args
+func ParseArgs(args []string) (NodeSpec, error) {
+	spec := NodeSpec{}
+	var peers, antiEntropyPeers peerFlags
+
+	flags := flag.NewFlagSet("accord-node", flag.ContinueOnError)
+	flags.StringVar(&spec.Name, "name", "", "the node's NodeID")
+	flags.StringVar(&spec.ListenAddress, "listen", "127.0.0.1:7000", "the address to serve on")
+	flags.StringVar(&spec.URL, "url", "", "the base URL other nodes reach this one at")
+	flags.StringVar(&spec.DataDir, "data", "data", "where to store data")
+	flags.DurationVar(&spec.AntiEntropyInterval, "anti-entropy-interval", 30*time.Second, "how often to compare digests with peers")
+	flags.DurationVar(&spec.SyncRetryInterval, "sync-retry-interval", time.Second, "how long to wait before sending messages a peer couldn't take again")
+	flags.BoolVar(&spec.Relay, "relay", false, "relay remote messages on to our other peers (for hubs)")
+	flags.Var(&peers, "peer", "a peer to send messages to, as name=url (repeatable)")
+	flags.Var(&antiEntropyPeers, "anti-entropy-peer", "a peer to compare digests with, as name=url (repeatable)")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return spec, err
+	}
+	if spec.Name == "" {
+		return spec, fmt.Errorf("a -name is required")
+	}
+
+	spec.Peers = peers
+	spec.AntiEntropyPeers = antiEntropyPeers
+	return spec, nil
+}
@@ -0,0 +1,179 @@
+package cluster
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Ssawa/accord/accord"
+	"github.com/Ssawa/accord/components"
+	"github.com/beeker1121/goque"
+)
+
+// PeerSpec is another node in the cluster that a node synchronizes with
+type PeerSpec struct {
+	Name string
+	URL  string
+}
+
+// NodeSpec describes a single demo node
+type NodeSpec struct {
+	// Name is the node's NodeID
+	Name string
+
+	// ListenAddress is the address the node serves its HTTP endpoints on
+	ListenAddress string
+
+	// URL is the base URL the rest of the cluster reaches the node at
+	URL string
+
+	// DataDir is where the node stores its data
+	DataDir string
+
+	// Peers are the nodes this one sends its Messages to
+	Peers []PeerSpec
+
+	// AntiEntropyPeers are the nodes this one compares digests with. Every node should have at least one
+	// path to every other through these
+	AntiEntropyPeers []PeerSpec
+
+	// Relay should be set for hubs, so that Messages from one edge node make it to the rest
+	Relay bool
+
+	// AntiEntropyInterval is how often the node compares digests with its peers
+	AntiEntropyInterval time.Duration
+
+	// SyncRetryInterval is how long the node waits before sending Messages a peer couldn't take again
+	SyncRetryInterval time.Duration
+}
+
+// Node is a running demo node. Besides the endpoints of its Components, it serves two of its own:
+// "POST /submit" creates a new Message out of the request body, and "GET /status" describes the node
+type Node struct {
+	Spec    NodeSpec
+	Accord  *accord.Accord
+	Manager *LogManager
+
+	server *http.Server
+	done   chan struct{}
+}
+
+// LogManager is the demo's Manager. It simply keeps a log of every payload it has processed
+type LogManager struct {
+	mutex    sync.Mutex
+	payloads []string
+}
+
+// Process records the Message's payload
+func (manager *LogManager) Process(msg *accord.Message, fromRemote bool) error {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	manager.payloads = append(manager.payloads, string(msg.Payload))
+	return nil
+}
+
+// ShouldProcess refuses Messages we've already performed, which is what keeps the demo's hub from
+// relaying a Message it has already seen back out again
+func (manager *LogManager) ShouldProcess(msg accord.Message, history *goque.Stack) bool {
+	seen, err := accord.NewHistory(history).Contains(msg.ID)
+	return err == nil && !seen
+}
+
+// Payloads returns every payload processed so far, in the order they were processed
+func (manager *LogManager) Payloads() []string {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	return append([]string{}, manager.payloads...)
+}
+
+// StartNode starts up a demo node
+func StartNode(spec NodeSpec, logger accord.Logger) (*Node, error) {
+	logger = logger.WithField("node", spec.Name)
+
+	httpSync := &components.HTTPSync{RetryInterval: spec.SyncRetryInterval}
+	for _, peer := range spec.Peers {
+		httpSync.Peers = append(httpSync.Peers, components.HTTPPeer{Name: peer.Name, URL: peer.URL})
+	}
+
+	antiEntropy := &components.AntiEntropy{Interval: spec.AntiEntropyInterval}
+	for _, peer := range spec.AntiEntropyPeers {
+		antiEntropy.Peers = append(antiEntropy.Peers, peer.URL)
+	}
+
+	node := &Node{Spec: spec, Manager: &LogManager{}, done: make(chan struct{})}
+	node.Accord = accord.NewAccord(node.Manager, []accord.Component{httpSync, antiEntropy}, spec.DataDir, logger)
+	node.Accord.NodeID = spec.Name
+	node.Accord.Relay = spec.Relay
+
+	err := node.Accord.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	// Our Components' handlers only exist once Accord has started them, so the server comes last
+	mux := http.NewServeMux()
+	mux.Handle("/sync/", httpSync.Handler())
+	mux.Handle("/antientropy/", antiEntropy.Handler())
+	mux.HandleFunc("/submit", node.submit)
+	mux.HandleFunc("/status", node.status)
+	node.server = &http.Server{Addr: spec.ListenAddress, Handler: mux}
+
+	go func() {
+		defer close(node.done)
+		err := node.server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			logger.WithError(err).Error("Demo node server stopped unexpectedly")
+		}
+	}()
+
+	return node, nil
+}
+
+// Stop shuts the node down
+func (node *Node) Stop() {
+	node.server.Close()
+	<-node.done
+	node.Accord.Stop()
+}
+
+func (node *Node) submit(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	msg, err := accord.NewMessage(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = node.Accord.HandleNewMessage(msg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+// NodeStatus is what a node's "/status" endpoint responds with
+type NodeStatus struct {
+	Name       string
+	History    uint64
+	Queue      uint64
+	DigestRoot string
+}
+
+func (node *Node) status(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(NodeStatus{
+		Name:       node.Spec.Name,
+		History:    node.Accord.History().Len(),
+		Queue:      node.Accord.Queue().Len(),
+		DigestRoot: node.Accord.Digest().RootString(),
+	})
+}